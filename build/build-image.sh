@@ -11,7 +11,20 @@ GIT_COMMIT="$(git rev-parse --verify HEAD)"
 GIT_BRANCH=`git branch | grep \* | cut -d ' ' -f2`
 TAG="${GIT_BRANCH}_${GIT_COMMIT:0:8}-$(date +%Y''%m''%d''%H''%M''%S)"
 
-docker build -t "${NAME}:${TAG}" -f ${ROOTDIR}/Dockerfile ${ROOTDIR}/../
+# ARCH=arm64 builds a linux/arm64 image, FIPS=1 a boringcrypto image; both
+# are tagged with a suffix.
+ARCH="${ARCH:-amd64}"
+SUFFIX=""
+if [ "${ARCH}" != "amd64" ]; then
+    SUFFIX="${SUFFIX}-${ARCH}"
+fi
+if [ -n "${FIPS}" ]; then
+    SUFFIX="${SUFFIX}-fips"
+fi
+TAG="${TAG}${SUFFIX}"
+
+docker buildx build --load --platform "linux/${ARCH}" --build-arg FIPS="${FIPS}" ${GO_IMAGE:+--build-arg GO_IMAGE="${GO_IMAGE}"} \
+    -t "${NAME}:${TAG}" -f ${ROOTDIR}/Dockerfile ${ROOTDIR}/../
 
 array=( registry.cn-hangzhou.aliyuncs.com )
 for registry in "${array[@]}"
@@ -20,6 +33,6 @@ do
     docker tag "${NAME}:${TAG}" "${registry}/${NAMESPACE}/${NAME}:${TAG}"
     docker push "${registry}/${NAMESPACE}/${NAME}:${TAG}"
 
-    docker tag "${NAME}:${TAG}" "${registry}/${NAMESPACE}/${NAME}:latest"
-    docker push "${registry}/${NAMESPACE}/${NAME}:latest"
+    docker tag "${NAME}:${TAG}" "${registry}/${NAMESPACE}/${NAME}:latest${SUFFIX}"
+    docker push "${registry}/${NAMESPACE}/${NAME}:latest${SUFFIX}"
 done
@@ -2,20 +2,40 @@ package app
 
 import (
 	"encoding/json"
-
+	"fmt"
 	"log"
+	"os"
+
+	plugin "github.com/knative-sample/revision-controller/cmd/kubectl-revision_gc/app"
 
+	"github.com/knative-sample/revision-controller/pkg/admin"
+	"github.com/knative-sample/revision-controller/pkg/apiserver"
+	"github.com/knative-sample/revision-controller/pkg/chaos"
+	"github.com/knative-sample/revision-controller/pkg/clockskew"
 	controller2 "github.com/knative-sample/revision-controller/pkg/controller"
+	"github.com/knative-sample/revision-controller/pkg/crashreport"
+	"github.com/knative-sample/revision-controller/pkg/health"
+	"github.com/knative-sample/revision-controller/pkg/inventory"
+	"github.com/knative-sample/revision-controller/pkg/rbac"
+	"github.com/knative-sample/revision-controller/pkg/summary"
+	"github.com/knative-sample/revision-controller/pkg/tuning"
+	"github.com/knative-sample/revision-controller/pkg/useragent"
+	"github.com/knative-sample/revision-controller/pkg/webhook"
+	ws "github.com/knative-sample/revision-controller/pkg/workspace"
 	"github.com/spf13/cobra"
 	"go.uber.org/zap"
 	"golang.org/x/sync/errgroup"
-	"knative.dev/pkg/controller"
 	"knative.dev/pkg/injection"
+	"knative.dev/pkg/injection/clients/kubeclient"
 	"knative.dev/pkg/injection/sharedmain"
 	"knative.dev/pkg/logging"
 	"knative.dev/pkg/signals"
+	"knative.dev/pkg/system"
 )
 
+// defaultMetricsNamespace prefixes the names of all metrics by default.
+const defaultMetricsNamespace = "revisiongc"
+
 var defaultZLC = []byte(`{
   "level": "info",
   "development": false,
@@ -37,22 +57,145 @@ var defaultZLC = []byte(`{
   }
 }`)
 
-// start edas api
+// NewCommandStartServer returns the controller command. Without a subcommand
+// it serves like `controller serve`.
 func NewCommandStartServer() *cobra.Command {
 	ops := &Options{}
 	mainCmd := &cobra.Command{
+		Use:   "controller",
 		Short: "serving-controller",
 		Long:  "serving-controller",
 		RunE: func(c *cobra.Command, args []string) error {
+			if ops.Version {
+				printVersion(c.OutOrStdout())
+				return nil
+			}
 			run(ops)
 			return nil
 		},
 	}
 
 	ops.SetOps(mainCmd)
+	ops.SetServeOps(mainCmd)
+	ops.SetSweepOps(mainCmd)
+	mainCmd.Flags().BoolVar(&ops.Once, "once", ops.Once, "Perform a single full garbage collection sweep, print a summary and exit, non-zero when a Service or namespace failed.")
+	mainCmd.Flags().MarkDeprecated("once", "use the sweep subcommand instead")
+	mainCmd.Flags().BoolVar(&ops.Version, "version", ops.Version, "Print the version and exit.")
+	mainCmd.AddCommand(
+		newCommandServe(ops),
+		newCommandSweep(ops),
+		newCommandPlan(ops),
+		newCommandRBAC(),
+		newCommandVersion(),
+	)
 	return mainCmd
 }
 
+// newCommandServe returns the `controller serve` command.
+func newCommandServe(ops *Options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Run the controller until it is stopped",
+		Long: "Run the planner, the executor and the child resource sweeper until the\n" +
+			"process is stopped, serving the optional aggregated API, webhook and admin API.",
+		Args: cobra.NoArgs,
+		RunE: func(c *cobra.Command, args []string) error {
+			run(ops)
+			return nil
+		},
+	}
+	ops.SetServeOps(cmd)
+	return cmd
+}
+
+// newCommandSweep returns the `controller sweep` command.
+func newCommandSweep(ops *Options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "sweep",
+		Short: "Perform a single full garbage collection sweep",
+		Long: "Perform a single full garbage collection sweep: plan every Service, carry out\n" +
+			"the plans and sweep the child resources of deleted revisions, then print a\n" +
+			"summary and exit, non-zero when a Service or namespace failed. For running as\n" +
+			"a CronJob or gating CI; the APIs and the webhook are not served. Exit codes:\n" +
+			"  0 the sweep succeeded\n" +
+			"  1 a Service or namespace failed, with --fail-on-errors\n" +
+			"  2 revisions were planned for deletion, with --fail-on-candidates",
+		Args: cobra.NoArgs,
+		RunE: func(c *cobra.Command, args []string) error {
+			ops.Once = true
+			run(ops)
+			return nil
+		},
+	}
+	ops.SetSweepOps(cmd)
+	return cmd
+}
+
+// newCommandPlan returns the `controller plan` command, the preview of the
+// kubectl plugin.
+func newCommandPlan(ops *Options) *cobra.Command {
+	planOps := &plugin.Options{}
+	cmd := &cobra.Command{
+		Use:   "plan SERVICE",
+		Short: "Preview which revisions of a Service are retained or deleted",
+		Long: "Preview which revisions of a Service are retained or deleted, like\n" +
+			"`kubectl revision-gc`. Nothing is deleted.",
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			planOps.Kubeconfig, planOps.Server = ops.Kubeconfig, ops.MasterURL
+			planOps.UserAgentSuffix = ops.UserAgentSuffix
+			planOps.Exemptions = ops.Exemptions
+			return plugin.Preview(planOps, args[0], c.OutOrStdout())
+		},
+	}
+	planOps.SetServiceOps(cmd)
+	return cmd
+}
+
+// newCommandRBAC returns the `controller rbac` command.
+func newCommandRBAC() *cobra.Command {
+	var features, namespace, serviceAccount string
+	cmd := &cobra.Command{
+		Use:   "rbac",
+		Short: "Print the minimal RBAC manifests for a feature set",
+		Long: "Print the minimal RBAC manifests granting the features to the ServiceAccount of\n" +
+			"the controller: a ClusterRole for the objects of all namespaces, a Role for\n" +
+			"those of the system namespace and the bindings, e.g.\n" +
+			"  controller rbac --features gc,webhook,sweeper | kubectl apply -f -",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			fs, err := rbac.ParseFeatures(features)
+			if err != nil {
+				return err
+			}
+			raw, err := rbac.YAML(rbac.Manifests(fs, namespace, serviceAccount))
+			if err != nil {
+				return err
+			}
+			_, err = c.OutOrStdout().Write(raw)
+			return err
+		},
+	}
+	cmd.Flags().StringVar(&features, "features", string(rbac.GC), "Comma separated features to grant: gc, webhook, apiserver, admin, sweeper, mesh, ingress, suspend and active-active.")
+	cmd.Flags().StringVar(&namespace, "namespace", "knative-serving", "The system namespace the controller runs in.")
+	cmd.Flags().StringVar(&serviceAccount, "service-account", "revision-controller", "The ServiceAccount of the controller.")
+	return cmd
+}
+
+// newCommandVersion returns the `controller version` command.
+func newCommandVersion() *cobra.Command {
+	return &cobra.Command{
+		Use:   "version",
+		Short: "Print the version",
+		Args:  cobra.NoArgs,
+		Run: func(c *cobra.Command, args []string) {
+			printVersion(c.OutOrStdout())
+		},
+	}
+}
+
 func run(ops *Options) {
 	var logger *zap.SugaredLogger
 	// setup logger
@@ -60,43 +203,195 @@ func run(ops *Options) {
 	if err := json.Unmarshal(defaultZLC, &zlc); err != nil {
 		log.Fatalf("Unmarshal zap.Logger config error:%s ", err)
 	}
-	if l, err := zlc.Build(); err != nil {
+	if ops.Once {
+		if ops.Output != outputText && ops.Output != outputJSON {
+			log.Fatalf("Invalid --output %q, use %s or %s", ops.Output, outputText, outputJSON)
+		}
+		if ops.Output == outputJSON {
+			// Keep stdout to the summary.
+			zlc.OutputPaths = []string{"stderr"}
+		}
+	}
+	var zopts []zap.Option
+	if ops.CrashReportFile != "" {
+		zopts = append(zopts, zap.Hooks(crashreport.Default.FatalHook(ops.CrashReportFile)))
+	}
+	if l, err := zlc.Build(zopts...); err != nil {
 		log.Fatalf("Build Logger error:%s", err)
 	} else {
 		logger = l.Sugar()
 	}
 
 	defer logger.Sync()
+	if ops.CrashReportFile != "" {
+		defer func() {
+			if value := recover(); value != nil {
+				crashreport.Default.WriteFile(ops.CrashReportFile, fmt.Sprint("panic: ", value))
+				panic(value)
+			}
+		}()
+	}
 
 	ctx := signals.NewContext()
 	ctx = logging.WithLogger(ctx, logger)
 	logger.Info("logger construction succeeded")
 
+	if err := tuning.Apply(logger, ops.Tuning); err != nil {
+		logger.Fatalf("Tune runtime error:%s", err)
+	}
+
 	// setup informer
 	cfg, err := sharedmain.GetConfig(ops.MasterURL, ops.Kubeconfig)
 	if err != nil {
 		logger.Fatal("Error building kubeconfig:", err)
 	}
+	if chaos.Enabled {
+		logger.Warn("Built with the chaos build tag, failure injection is enabled")
+		cfg = chaos.WrapConfig(cfg)
+	}
+	cfg = clockskew.WrapConfig(cfg)
+	component := "controller"
+	if ops.Once {
+		component = "sweep"
+	}
+	cfg = useragent.WrapConfig(cfg, useragent.Build(component, ops.UserAgentSuffix))
 
 	logger.Infof("Registering %d clients", len(injection.Default.GetClients()))
 	logger.Infof("Registering %d informer factories", len(injection.Default.GetInformerFactories()))
 	logger.Infof("Registering %d informers", len(injection.Default.GetInformers()))
 
-	ctx, informers := injection.Default.SetupInformers(ctx, cfg)
+	if ops.ServingCheckInterval <= 0 {
+		logger.Fatalf("--serving-check-interval must be positive, got %s", ops.ServingCheckInterval)
+	}
+	if ops.HealthAddress != "" && !ops.Once {
+		health.Default.NotReady("controllers", "not started yet")
+		go func() {
+			if err := health.Default.Run(ctx, ops.HealthAddress); err != nil {
+				logger.Errorw("Failed to serve the health probes", zap.Error(err))
+			}
+		}()
+	}
+
+	// Every workspace gets its own informers and controllers, the cluster of
+	// the kubeconfig is the only one without workspaces.
+	names := ops.Workspaces
+	if len(names) == 0 {
+		names = []string{""}
+	}
+	var workspaces []*workspace
+	for _, name := range names {
+		wsCfg := cfg
+		if name != "" {
+			if wsCfg, err = ws.Config(cfg, name); err != nil {
+				logger.Fatalw("Invalid workspace", zap.Error(err))
+			}
+		}
+		workspaces = append(workspaces, setupWorkspace(ctx, ops, name, wsCfg))
+	}
+	// The embedded servers serve the first workspace.
+	first := workspaces[0]
+	if len(workspaces) > 1 {
+		logger.Infof("Operating in %d workspaces, the embedded servers serve %s", len(workspaces), first.name)
+	}
+
+	if ops.Once {
+		ops.APIServer.Address, ops.Admin.Listener.Address, ops.Webhook.Address = "", "", ""
+		ops.InventoryFile = ""
+	}
+
+	var plans *controller2.PlanSource
+	if ops.APIServer.Address != "" || ops.Admin.Listener.Address != "" || ops.Webhook.Address != "" || ops.InventoryFile != "" {
+		plans = controller2.NewPlanSource(first.ctx, first.cmw)
+	}
+
+	var wh *webhook.Webhook
+	if ops.Webhook.Address != "" {
+		wh = webhook.New(first.ctx, first.cmw, webhook.Options{
+			ServiceName: ops.WebhookService,
+			Namespace:   system.Namespace(),
+			Listener:    ops.Webhook,
+
+			InjectCABundle: first.distribution.InjectsCABundle(),
+			Estimator:      plans,
+		})
+	}
+
+	for _, w := range workspaces {
+		w.start()
+	}
+
+	if ops.Once {
+		failed, candidates := false, false
+		for _, w := range workspaces {
+			w.logger.Info("Performing a single sweep...")
+			summary := controller2.RunOnce(w.ctx, w.serviceControllers[0], w.serviceControllers[1], w.sweeper)
+			if err := printSummary(os.Stdout, ops.Output, w.name, summary); err != nil {
+				logger.Errorw("Failed to print the sweep summary", zap.Error(err))
+			}
+			w.decisions.Flush()
+			failed = failed || len(summary.Failures) > 0
+			candidates = candidates || summary.Planned > 0
+		}
+		logger.Sync()
+		if code := sweepExitCode(ops, failed, candidates); code != 0 {
+			os.Exit(code)
+		}
+		return
+	}
+
+	for _, w := range workspaces {
+		go w.configStatus.Run(w.ctx)
+	}
+
+	// Serve the plans once the informers are synced.
+	if ops.APIServer.Address != "" {
+		server := apiserver.New(logger.Named("apiserver"), plans, kubeclient.Get(first.ctx))
+		go func() {
+			if err := server.Run(first.ctx, ops.APIServer); err != nil {
+				logger.Errorw("Failed to serve the aggregated API", zap.Error(err))
+			}
+		}()
+	}
+
+	if wh != nil {
+		go func() {
+			if err := wh.Run(first.ctx); err != nil {
+				logger.Errorw("Failed to serve the webhook", zap.Error(err))
+			}
+		}()
+	}
 
-	// Start all of the informers and wait for them to sync.
-	logger.Info("Starting informers.")
-	if err := controller.StartInformers(ctx.Done(), informers...); err != nil {
-		logger.Fatalw("Failed to start informers", err)
+	if ops.Admin.Listener.Address != "" {
+		server := admin.New(logger.Named("admin"), kubeclient.Get(first.ctx), plans, plans, first.backlog, func(key string) {
+			for _, impl := range first.serviceControllers {
+				impl.EnqueueKey(key)
+			}
+		})
+		go func() {
+			if err := server.Run(first.ctx, ops.Admin); err != nil {
+				logger.Errorw("Failed to serve the admin API", zap.Error(err))
+			}
+		}()
 	}
 
-	// start controllers
-	controllers := []*controller.Impl{
-		controller2.NewController(ctx),
+	if ops.InventoryFile != "" {
+		if ops.InventoryInterval <= 0 {
+			logger.Fatalf("--inventory-interval must be positive, got %s", ops.InventoryInterval)
+		}
+		go inventory.Dump(ctx.Done(), logger.Named("inventory"), ops.InventoryFile, ops.InventoryInterval, func() (*inventory.Inventory, error) {
+			return plans.Inventory(first.ctx)
+		})
 	}
+
 	// Start all of the controllers.
 	logger.Info("Starting controllers...")
-	go controller.StartAll(ctx.Done(), controllers...)
+	for _, w := range workspaces {
+		go w.supervisor.Run(ctx.Done())
+	}
+	health.Default.Ready("controllers")
+	for _, w := range workspaces {
+		go w.summarizer.Run(ctx.Done(), summary.Log(w.logger.Named("summary")))
+	}
 	_, egCtx := errgroup.WithContext(ctx)
 
 	// This will block until either a signal arrives or one of the grouped functions
@@ -0,0 +1,12 @@
+//go:build boringcrypto
+// +build boringcrypto
+
+package app
+
+// Restrict TLS, the webhook, aggregated API and admin API servers and the
+// API server clients, to FIPS approved settings.
+import _ "crypto/tls/fipsonly"
+
+// fipsOnly reports whether the binary is built with the FIPS validated
+// BoringCrypto module.
+const fipsOnly = true
@@ -0,0 +1,8 @@
+//go:build !boringcrypto
+// +build !boringcrypto
+
+package app
+
+// fipsOnly reports whether the binary is built with the FIPS validated
+// BoringCrypto module.
+const fipsOnly = false
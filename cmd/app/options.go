@@ -1,6 +1,18 @@
 package app
 
 import (
+	"time"
+
+	"github.com/knative-sample/revision-controller/pkg/admin"
+	"github.com/knative-sample/revision-controller/pkg/agent"
+	"github.com/knative-sample/revision-controller/pkg/chaos"
+	"github.com/knative-sample/revision-controller/pkg/decisionlog"
+	"github.com/knative-sample/revision-controller/pkg/distribution"
+	"github.com/knative-sample/revision-controller/pkg/exemption"
+	"github.com/knative-sample/revision-controller/pkg/hooks"
+	"github.com/knative-sample/revision-controller/pkg/listener"
+	"github.com/knative-sample/revision-controller/pkg/shard"
+	"github.com/knative-sample/revision-controller/pkg/tuning"
 	"github.com/spf13/cobra"
 )
 
@@ -8,9 +20,118 @@ type Options struct {
 	Version    bool
 	MasterURL  string
 	Kubeconfig string
+
+	APIServer listener.Options
+
+	Webhook        listener.Options
+	WebhookService string
+
+	Admin admin.Options
+
+	Agent agent.Options
+
+	Exemptions exemption.Options
+
+	Hooks hooks.Options
+
+	DecisionLog decisionlog.Options
+
+	Shards shard.Options
+
+	Distribution string
+
+	UserAgentSuffix string
+
+	Protobuf bool
+
+	Workspaces []string
+
+	CrashReportFile string
+
+	// HealthAddress serves the liveness and readiness probes, and
+	// ServingCheckInterval is how often the Knative APIs are checked again
+	// while standing by.
+	HealthAddress        string
+	ServingCheckInterval time.Duration
+
+	InventoryFile     string
+	InventoryInterval time.Duration
+
+	Once bool
+
+	// MetricsNamespace prefixes the names of all metrics, and
+	// MetricsPerServiceLabels keeps the Service keys on the reconcile
+	// metrics instead of aggregating them by namespace.
+	MetricsNamespace        string
+	MetricsPerServiceLabels bool
+
+	Tuning tuning.Options
+
+	// FailOnCandidates, FailOnErrors and Output shape the result of a
+	// sweep for CI gating.
+	FailOnCandidates bool
+	FailOnErrors     bool
+	Output           string
 }
 
+// SetOps adds the flags shared by all subcommands.
 func (s *Options) SetOps(ac *cobra.Command) {
-	ac.Flags().StringVar(&s.MasterURL, "master", s.MasterURL, "The address of the Kubernetes API server. Overrides any value in kubeconfig. Only required if out-of-cluster.")
-	ac.Flags().StringVar(&s.Kubeconfig, "kubeconfig", s.Kubeconfig, "Path to a kubeconfig. Only required if out-of-cluster.")
+	ac.PersistentFlags().StringVar(&s.MasterURL, "master", s.MasterURL, "The address of the Kubernetes API server. Overrides any value in kubeconfig. Only required if out-of-cluster.")
+	ac.PersistentFlags().StringVar(&s.Kubeconfig, "kubeconfig", s.Kubeconfig, "Path to a kubeconfig. Only required if out-of-cluster.")
+	ac.PersistentFlags().StringVar(&s.Distribution, "distribution", string(distribution.Auto), "The Knative Serving distribution: auto, upstream or openshift-serverless. auto detects OpenShift from the API groups the cluster serves.")
+	ac.PersistentFlags().StringVar(&s.UserAgentSuffix, "user-agent-suffix", s.UserAgentSuffix, "Appended to the User-Agent sent to the API server, e.g. the pod name, to attribute the calls of an instance in the audit logs.")
+	ac.PersistentFlags().BoolVar(&s.Protobuf, "kube-api-protobuf", s.Protobuf, "Read the built-in Kubernetes APIs, e.g. Deployments, Pods and Namespaces, with the protobuf encoding, which the API server encodes faster and sends smaller than JSON. The Knative APIs are custom resources and stay JSON.")
+	ac.PersistentFlags().StringSliceVar(&s.Workspaces, "workspace", s.Workspaces, "A kcp logical cluster to operate in, e.g. root:org:team, served under <server>/clusters/, or the base URL of a virtual workspace. Repeat for several, each gets its own informers and controllers; the embedded servers serve the first. Empty operates on the cluster of the kubeconfig.")
+	ac.PersistentFlags().DurationVar(&s.ServingCheckInterval, "serving-check-interval", 30*time.Second, "How often the controller checks again whether the cluster serves the Knative Serving APIs it reads while it stands by for them, e.g. before Knative Serving is installed.")
+	ac.PersistentFlags().StringVar(&s.CrashReportFile, "crash-report-file", s.CrashReportFile, "The file the recent keys, recovered panics and configuration are dumped to on fatal exit, e.g. /dev/termination-log. Empty disables the dump.")
+	ac.PersistentFlags().StringVar(&s.Agent.URL, "agent-url", s.Agent.URL, "The HTTPS endpoint of a central control plane serving the signed garbage collection configuration, replacing the config-revision-gc and config-revision-gc-notifications ConfigMaps. The admin pause and the validation of the ConfigMaps do not apply to it. Empty reads the local ConfigMaps.")
+	ac.PersistentFlags().StringVar(&s.Agent.PublicKeyFile, "agent-public-key-file", s.Agent.PublicKeyFile, "The PEM encoded Ed25519, ECDSA or RSA public key the configuration served by --agent-url is verified with.")
+	ac.PersistentFlags().StringVar(&s.Agent.CAFile, "agent-ca-file", s.Agent.CAFile, "The CA bundle the --agent-url serving certificate is verified with. The system roots are used when empty.")
+	ac.PersistentFlags().StringVar(&s.Agent.Cluster, "agent-cluster", s.Agent.Cluster, "The name of the cluster, sent to --agent-url as the cluster query parameter. Required with --agent-url: only payloads issued for this audience are accepted.")
+	ac.PersistentFlags().DurationVar(&s.Agent.Interval, "agent-interval", time.Minute, "How often the configuration is fetched from --agent-url.")
+	ac.PersistentFlags().StringVar(&s.Exemptions.URL, "exemptions-url", s.Exemptions.URL, "The HTTP source, e.g. a CMDB export, of a list of namespaces and namespace/service entries exempted from garbage collection, one per line or as a JSON array. Empty disables the source.")
+	ac.PersistentFlags().DurationVar(&s.Exemptions.Interval, "exemptions-interval", 5*time.Minute, "How often the list is pulled from --exemptions-url. The previous list stays in effect while it cannot be read.")
+	ac.PersistentFlags().StringVar(&s.Exemptions.ConfigMap, "exemptions-configmap", s.Exemptions.ConfigMap, "The ConfigMap of the system namespace another tool syncs an exemption list to, in its exemptions key. Empty disables the source.")
+	ac.PersistentFlags().StringSliceVar(&s.Hooks.Paths, "deletion-check-hook", s.Hooks.Paths, "An executable asked whether every deletion candidate is safe to delete, with a JSON request on stdin and a JSON response on stdout, see pkg/hooks. Repeat for several, run in order until one vetoes. A hook that fails or times out vetoes the deletion. Empty runs no hook.")
+	ac.PersistentFlags().DurationVar(&s.Hooks.Timeout, "deletion-check-timeout", 10*time.Second, "How long a --deletion-check-hook may run for one candidate.")
+	ac.PersistentFlags().StringVar(&s.DecisionLog.URL, "decision-log-url", s.DecisionLog.URL, "The object storage location the planned and executed deletions are archived to as CloudEvents, in newline-delimited JSON objects: s3://bucket/prefix, gs://bucket/prefix or azblob://account/container/prefix with the SAS token in $"+decisionlog.AzureSASTokenEnv+". Empty disables the archive.")
+	ac.PersistentFlags().DurationVar(&s.DecisionLog.Interval, "decision-log-interval", 5*time.Minute, "How often the recorded decisions are written to --decision-log-url.")
+	ac.PersistentFlags().DurationVar(&s.DecisionLog.Retention, "decision-log-retention", s.DecisionLog.Retention, "How long the objects written to --decision-log-url are kept before the controller deletes them, e.g. 8760h. Zero keeps them. Requires a prefix in --decision-log-url.")
+	ac.PersistentFlags().StringVar(&s.DecisionLog.Source, "decision-log-source", "revision-controller", "The CloudEvents source of the archived decisions, e.g. the name of the cluster. The workspace is appended.")
+	ac.PersistentFlags().StringVar(&s.MetricsNamespace, "metrics-namespace", defaultMetricsNamespace, "The namespace prefixing the names of all metrics, e.g. revisiongc_revisions_deleted in Prometheus.")
+	ac.PersistentFlags().BoolVar(&s.MetricsPerServiceLabels, "metrics-per-service-labels", true, "Label the reconcile count and latency metrics with the namespace/name key of every Service. Disable it on very large clusters to label them with the namespace only, bounding their cardinality by the number of namespaces.")
+	ac.PersistentFlags().BoolVar(&s.Tuning.CgroupCPU, "gomaxprocs-from-cgroup", true, "Set GOMAXPROCS to the CPU quota of the container instead of the CPUs of the node, so a CPU limited pod is not throttled. The GOMAXPROCS environment variable takes precedence.")
+	ac.PersistentFlags().IntVar(&s.Tuning.GCPercent, "gc-percent", s.Tuning.GCPercent, "The garbage collection target percentage, like GOGC. Lower it to keep the heap of a memory limited pod caching large revision sets in check, at the cost of CPU. Zero leaves it to GOGC, a negative value disables the collector.")
+	ac.PersistentFlags().StringVar(&s.Tuning.MemoryBallast, "memory-ballast", s.Tuning.MemoryBallast, "The size of a memory ballast, a quantity like 256Mi or a percentage of the container memory limit like 25%. The ballast takes no resident memory but raises the heap the garbage collector paces against, collecting less often while the heap is small. Keep the ballast plus the live heap grown by --gc-percent under the memory limit. Empty allocates none.")
+	chaos.AddFlags(ac.PersistentFlags())
+}
+
+// SetServeOps adds the flags of the long-running controller.
+func (s *Options) SetServeOps(ac *cobra.Command) {
+	ac.Flags().StringVar(&s.HealthAddress, "health-address", ":8081", "The plain HTTP address serving /healthz and /readyz for the probes of the pod: host:port, [ipv6]:port or unix:///path. /readyz fails, with the reason, until the controllers start, e.g. while standing by for the Knative Serving APIs. Empty disables the probes.")
+	ac.Flags().StringVar(&s.APIServer.Address, "apiserver-address", s.APIServer.Address, "The address the gc.knative.dev aggregated API is served on: host:port, [ipv6]:port or unix:///path, e.g. :8443. Empty, the default, disables the API.")
+	ac.Flags().StringVar(&s.APIServer.CertFile, "apiserver-cert-file", s.APIServer.CertFile, "The serving certificate of the aggregated API. A self signed certificate is generated when no certificate is configured.")
+	ac.Flags().StringVar(&s.APIServer.KeyFile, "apiserver-key-file", s.APIServer.KeyFile, "The private key of the aggregated API serving certificate.")
+	ac.Flags().StringVar(&s.APIServer.TLSSecret, "apiserver-tls-secret", s.APIServer.TLSSecret, "The kubernetes.io/tls Secret, [namespace/]name, holding the aggregated API serving certificate.")
+	ac.Flags().StringVar(&s.Webhook.Address, "webhook-address", s.Webhook.Address, "The address of the webhook stamping new Revisions with their creation metadata: host:port, [ipv6]:port or unix:///path. Empty disables the webhook.")
+	ac.Flags().StringVar(&s.Webhook.TLSSecret, "webhook-tls-secret", s.Webhook.TLSSecret, "The kubernetes.io/tls Secret, [namespace/]name, holding the webhook serving certificate and its CA in ca.crt. A certificate is generated when empty.")
+	ac.Flags().StringVar(&s.WebhookService, "webhook-service", "revision-controller-webhook", "The name of the Service routing to the webhook, in the system namespace.")
+	ac.Flags().StringVar(&s.Admin.Listener.Address, "admin-address", s.Admin.Listener.Address, "The address of the admin API triggering garbage collection and pausing deletions: host:port, [ipv6]:port or unix:///path. Empty disables the admin API.")
+	ac.Flags().StringVar(&s.Admin.Listener.CertFile, "admin-cert-file", s.Admin.Listener.CertFile, "The serving certificate of the admin API. A self signed certificate is generated when no certificate is configured.")
+	ac.Flags().StringVar(&s.Admin.Listener.KeyFile, "admin-key-file", s.Admin.Listener.KeyFile, "The private key of the admin API serving certificate.")
+	ac.Flags().StringVar(&s.Admin.Listener.TLSSecret, "admin-tls-secret", s.Admin.Listener.TLSSecret, "The kubernetes.io/tls Secret, [namespace/]name, holding the admin API serving certificate.")
+	ac.Flags().StringVar(&s.InventoryFile, "inventory-file", s.InventoryFile, "The file the inventory of retained revisions is written to every --inventory-interval: in the Prometheus text format when it ends with .prom, e.g. for the node exporter textfile collector, as JSON otherwise. Empty disables the dump.")
+	ac.Flags().DurationVar(&s.InventoryInterval, "inventory-interval", 5*time.Minute, "How often the inventory file is written.")
+	ac.Flags().StringVar(&s.Admin.ClientCAFile, "admin-client-ca-file", s.Admin.ClientCAFile, "The CA bundle admin API client certificates are verified with. Only bearer tokens are accepted when empty.")
+	ac.Flags().BoolVar(&s.Shards.Enabled, "active-active", s.Shards.Enabled, "Spread the namespaces over all replicas, each reconciling the namespaces it holds a Lease for in the system namespace. The namespaces of a replica that dies move to the others once its Leases expired.")
+	ac.Flags().StringVar(&s.Shards.Identity, "shard-identity", s.Shards.Identity, "The identity of the replica in active-active mode, e.g. $(POD_NAME). The hostname when empty.")
+	ac.Flags().DurationVar(&s.Shards.LeaseDuration, "shard-lease-duration", 30*time.Second, "How long the Leases of a replica stay valid after their last renewal in active-active mode.")
+	ac.Flags().DurationVar(&s.Shards.RenewInterval, "shard-renew-interval", 10*time.Second, "How often a replica renews its Leases and rebalances the namespaces in active-active mode. Must be shorter than --shard-lease-duration.")
+}
+
+// SetSweepOps adds the flags of a single sweep.
+func (s *Options) SetSweepOps(ac *cobra.Command) {
+	ac.Flags().BoolVar(&s.FailOnCandidates, "fail-on-candidates", s.FailOnCandidates, "Exit with code 2 when the sweep planned the deletion of any revision, so CI jobs can gate a promotion on revision hygiene.")
+	ac.Flags().BoolVar(&s.FailOnErrors, "fail-on-errors", true, "Exit with code 1 when a Service or namespace failed. Failures take precedence over candidates.")
+	ac.Flags().StringVar(&s.Output, "output", outputText, "The format of the summary printed to stdout: text or json. With json the logs go to stderr.")
 }
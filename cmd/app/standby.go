@@ -0,0 +1,51 @@
+package app
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/knative-sample/revision-controller/pkg/distribution"
+	"github.com/knative-sample/revision-controller/pkg/health"
+	"go.uber.org/zap"
+	"k8s.io/client-go/discovery"
+)
+
+// waitForServing returns the resolved distribution once the cluster serves
+// the Knative APIs the controller reads. Until then, e.g. on a cluster
+// without Knative Serving or with an unsupported version of it, the
+// controller stands by: it reports the component not ready to the health
+// status of ctx with the reason and checks again every interval, instead of
+// crash looping. A single sweep fails right away instead.
+func waitForServing(ctx context.Context, logger *zap.SugaredLogger, component string, dist distribution.Distribution, client discovery.DiscoveryInterface, interval time.Duration, once bool) distribution.Distribution {
+	var last string
+	for {
+		resolved, err := distribution.Resolve(dist, client)
+		if err == nil {
+			err = distribution.CheckAPIs(client)
+		}
+		if err == nil {
+			if last != "" {
+				logger.Info("Knative Serving is available, leaving standby")
+			}
+			health.FromContext(ctx).Ready(component)
+			return resolved
+		}
+		if once {
+			logger.Fatalw("Unsupported Knative Serving installation", zap.Error(err))
+		}
+
+		health.FromContext(ctx).NotReady(component, err.Error())
+		if err.Error() != last {
+			logger.Warnw("Unsupported Knative Serving installation, standing by", zap.Error(err), zap.Duration("recheck", interval))
+			last = err.Error()
+		}
+		select {
+		case <-ctx.Done():
+			logger.Info("Stopped while standing by for Knative Serving")
+			logger.Sync()
+			os.Exit(0)
+		case <-time.After(interval):
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	controller2 "github.com/knative-sample/revision-controller/pkg/controller"
+)
+
+const (
+	// outputText and outputJSON are the formats of the sweep summary.
+	outputText = "text"
+	outputJSON = "json"
+
+	// exitFailures and exitCandidates are the exit codes of a sweep with
+	// failures, or that planned deletions.
+	exitFailures   = 1
+	exitCandidates = 2
+)
+
+// printSummary prints the summary of the sweep of the workspace: a line of
+// text, or a JSON object per workspace on its own line.
+func printSummary(w io.Writer, output, workspace string, summary *controller2.Summary) error {
+	if output == outputJSON {
+		return json.NewEncoder(w).Encode(struct {
+			Workspace string `json:"workspace,omitempty"`
+			*controller2.Summary
+		}{workspace, summary})
+	}
+	if workspace != "" {
+		fmt.Fprintf(w, "Workspace %s: ", workspace)
+	}
+	_, err := fmt.Fprintln(w, summary)
+	return err
+}
+
+// sweepExitCode returns the exit code of a sweep that failed or planned
+// deletions, following the gating options.
+func sweepExitCode(ops *Options, failed, candidates bool) int {
+	switch {
+	case failed && ops.FailOnErrors:
+		return exitFailures
+	case candidates && ops.FailOnCandidates:
+		return exitCandidates
+	}
+	return 0
+}
@@ -0,0 +1,17 @@
+package app
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+
+	"github.com/knative-sample/revision-controller/pkg/version"
+)
+
+func printVersion(out io.Writer) {
+	crypto := ""
+	if fipsOnly {
+		crypto = ", boringcrypto fips-only"
+	}
+	fmt.Fprintf(out, "revision-controller %s (%s %s/%s%s)\n", version.Version, runtime.Version(), runtime.GOOS, runtime.GOARCH, crypto)
+}
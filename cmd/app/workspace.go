@@ -0,0 +1,260 @@
+package app
+
+import (
+	"context"
+
+	"github.com/knative-sample/revision-controller/pkg/agent"
+	"github.com/knative-sample/revision-controller/pkg/backlog"
+	"github.com/knative-sample/revision-controller/pkg/config"
+	"github.com/knative-sample/revision-controller/pkg/configstatus"
+	controller2 "github.com/knative-sample/revision-controller/pkg/controller"
+	"github.com/knative-sample/revision-controller/pkg/crashreport"
+	"github.com/knative-sample/revision-controller/pkg/decisionlog"
+	"github.com/knative-sample/revision-controller/pkg/distribution"
+	"github.com/knative-sample/revision-controller/pkg/exemption"
+	"github.com/knative-sample/revision-controller/pkg/health"
+	"github.com/knative-sample/revision-controller/pkg/hooks"
+	"github.com/knative-sample/revision-controller/pkg/pressure"
+	"github.com/knative-sample/revision-controller/pkg/protobuf"
+	"github.com/knative-sample/revision-controller/pkg/shard"
+	"github.com/knative-sample/revision-controller/pkg/summary"
+	"github.com/knative-sample/revision-controller/pkg/tracing"
+	"github.com/knative-sample/revision-controller/pkg/workers"
+	ws "github.com/knative-sample/revision-controller/pkg/workspace"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"knative.dev/pkg/configmap"
+	"knative.dev/pkg/controller"
+	"knative.dev/pkg/injection"
+	"knative.dev/pkg/injection/clients/dynamicclient"
+	"knative.dev/pkg/injection/clients/kubeclient"
+	namespaceinformer "knative.dev/pkg/injection/informers/kubeinformers/corev1/namespace"
+	"knative.dev/pkg/logging"
+	"knative.dev/pkg/metrics"
+	"knative.dev/pkg/system"
+)
+
+// workspace holds the informers and controllers of one workspace, or of the
+// cluster of the kubeconfig when name is empty.
+type workspace struct {
+	name   string
+	ctx    context.Context
+	logger *zap.SugaredLogger
+
+	informers    []controller.Informer
+	cmw          configmap.DefaultingWatcher
+	distribution distribution.Distribution
+	configStatus *configstatus.Reporter
+	decisions    *decisionlog.Log
+	summarizer   *summary.Summarizer
+	backlog      *backlog.Tracker
+
+	// serviceControllers are the planner and the executor, keyed by Service
+	serviceControllers []*controller.Impl
+	sweeper            *controller.Impl
+	supervisor         *workers.Supervisor
+}
+
+// setupWorkspace sets up the informers and controllers of the workspace
+// reached with cfg. Only the first workspace exports metrics and traces.
+func setupWorkspace(ctx context.Context, ops *Options, name string, cfg *rest.Config) *workspace {
+	logger := logging.FromContext(ctx)
+	if name != "" {
+		logger = logger.With(zap.String("workspace", name))
+		ctx = logging.WithLogger(ctx, logger)
+	}
+	w := &workspace{name: name, logger: logger, summarizer: summary.New(), backlog: backlog.New()}
+
+	// Every workspace summarizes, tracks its stale backlog and observes the
+	// pressure on its API server on its own. Its readiness and crash report
+	// are part of those of the process, and its metrics are tagged with its
+	// name.
+	status, crashes := health.Default, crashreport.Default
+	if name != "" {
+		status, crashes = health.Default.Workspace(name), crashreport.Default.Workspace(name)
+	}
+	monitor := &pressure.Monitor{}
+	ctx = ws.WithName(ctx, name)
+	ctx = health.WithStatus(ctx, status)
+	ctx = crashreport.WithRecorder(ctx, crashes)
+	ctx = summary.WithSummarizer(ctx, w.summarizer)
+	ctx = backlog.WithTracker(ctx, w.backlog)
+	ctx = pressure.WithMonitor(ctx, monitor)
+	w.ctx, w.informers = setupInformers(ctx, monitor.WrapConfig(cfg), ops.Protobuf)
+
+	// Stand by while the distribution does not serve the Knative APIs read.
+	dist, err := distribution.Parse(ops.Distribution)
+	if err != nil {
+		logger.Fatalw("Invalid distribution", zap.Error(err))
+	}
+	dist = waitForServing(w.ctx, logger, "serving", dist, kubeclient.Get(w.ctx).Discovery(), ops.ServingCheckInterval, ops.Once)
+	logger.Infof("Running against the %s Knative Serving distribution", dist)
+	w.distribution = dist
+
+	// setup configmap watcher, the garbage collection configuration is
+	// served by the control plane in agent mode
+	informed := configmap.NewInformedWatcher(kubeclient.Get(w.ctx), system.Namespace())
+	w.cmw = informed
+	if ops.Agent.Enabled() {
+		aw, err := agent.NewWatcher(logger.Named("agent"), informed, system.Namespace(), ops.Agent,
+			config.GCConfigName, config.NotificationsConfigName)
+		if err != nil {
+			logger.Fatalw("Invalid agent mode configuration", zap.Error(err))
+		}
+		logger.Infof("Running as an agent of %s", ops.Agent.URL)
+		w.cmw = aw
+	}
+
+	// exempt the Services of the external exemption lists
+	exemptions, err := exemption.New(logger.Named("exemptions"), ops.Exemptions, w.cmw, system.Namespace(), w.ctx.Done())
+	if err != nil {
+		logger.Fatalw("Invalid exemption sources", zap.Error(err))
+	}
+	if exemptions != nil {
+		w.ctx = exemption.WithSet(w.ctx, exemptions)
+	}
+
+	// bound the cardinality of the reconcile metrics on large clusters
+	if !ops.MetricsPerServiceLabels {
+		w.ctx = controller2.WithNamespaceAggregation(w.ctx)
+	}
+
+	// ask the deletion check hooks about every candidate
+	checker, err := hooks.New(ops.Hooks)
+	if err != nil {
+		logger.Fatalw("Invalid deletion check hooks", zap.Error(err))
+	}
+	if checker != nil {
+		w.ctx = hooks.WithChecker(w.ctx, checker)
+	}
+
+	// archive the decisions to object storage
+	logOptions := ops.DecisionLog
+	if name != "" {
+		logOptions.Source += "/" + name
+	}
+	w.decisions, err = decisionlog.New(logger.Named("decision-log"), logOptions, w.ctx.Done())
+	if err != nil {
+		logger.Fatalw("Invalid decision log configuration", zap.Error(err))
+	}
+	if w.decisions != nil {
+		w.ctx = decisionlog.WithLog(w.ctx, w.decisions)
+	}
+
+	// spread the namespaces over the replicas, a sweep covers them all
+	shardOptions := ops.Shards
+	shardOptions.Enabled = shardOptions.Enabled && !ops.Once
+	namespaceLister := namespaceinformer.Get(w.ctx).Lister()
+	shards, err := shard.New(logger.Named("shard"), kubeclient.Get(w.ctx), shardOptions, system.Namespace(), func() ([]string, error) {
+		namespaces, err := namespaceLister.List(labels.Everything())
+		if err != nil {
+			return nil, err
+		}
+		names := make([]string, 0, len(namespaces))
+		for _, ns := range namespaces {
+			names = append(names, ns.Name)
+		}
+		return names, nil
+	}, w.ctx.Done())
+	if err != nil {
+		logger.Fatalw("Invalid active-active configuration", zap.Error(err))
+	}
+	if shards != nil {
+		w.ctx = shard.WithCoordinator(w.ctx, shards)
+	}
+
+	if first := !metricsConfigured; first {
+		metricsConfigured = true
+
+		// setup metrics exporter
+		w.cmw.Watch(metrics.ConfigMapName(), metrics.UpdateExporterFromConfigMap(ops.MetricsNamespace, logger))
+
+		// setup tracing of the reconciles, linked from the deletion metrics
+		tracer := tracing.NewTracer(logger.Named("tracing"))
+		w.cmw.WatchWithDefault(tracing.DefaultConfigMap(system.Namespace()), tracer.UpdateFromConfigMap)
+	}
+
+	// snapshot the configuration for the crash report
+	for _, cm := range []string{config.GCConfigName, config.NotificationsConfigName} {
+		w.cmw.Watch(cm, func(cm *corev1.ConfigMap) {
+			crashes.Config(cm.Name, cm.Data)
+		})
+	}
+
+	// report the configuration in effect in the RevisionGCConfig status
+	w.configStatus = configstatus.NewReporter(logger.Named("config-status"), dynamicclient.Get(w.ctx))
+	w.configStatus.WatchConfigs(w.cmw)
+
+	// setup controllers
+	w.serviceControllers = []*controller.Impl{
+		controller2.NewController(w.ctx, w.cmw),
+		controller2.NewExecutorController(w.ctx, w.cmw),
+	}
+	w.sweeper = controller2.NewSweeperController(w.ctx, w.cmw)
+
+	// run the workers of the controllers, following the configured concurrency
+	w.supervisor = workers.New(logger.Named("workers"), config.DefaultConcurrency)
+	for name, impl := range map[string]*controller.Impl{
+		controller2.ReconcilerName: w.serviceControllers[0],
+		controller2.ExecutorName:   w.serviceControllers[1],
+		controller2.SweeperName:    w.sweeper,
+	} {
+		statsReporter, err := controller2.ImplStatsReporter(w.ctx, name)
+		if err != nil {
+			logger.Fatalw("Failed to set up the controller workers", zap.Error(err))
+		}
+		w.supervisor.Add(name, impl, statsReporter)
+	}
+	config.NewStore(logger.Named("config-store"), func(name string, value interface{}) {
+		if gc, ok := value.(*config.GC); ok {
+			w.supervisor.Resize(gc.Concurrency)
+			w.summarizer.SetInterval(gc.SummaryInterval)
+		}
+	}).WatchConfigs(w.cmw)
+	return w
+}
+
+// setupInformers injects the clients, informer factories and informers like
+// injection.Default.SetupInformers, with a Kubernetes client using protobuf
+// when enabled. The other clients read custom resources, served as JSON only.
+func setupInformers(ctx context.Context, cfg *rest.Config, useProtobuf bool) (context.Context, []controller.Informer) {
+	for _, ci := range injection.Default.GetClients() {
+		ctx = ci(ctx, cfg)
+	}
+	if useProtobuf {
+		ctx = context.WithValue(ctx, kubeclient.Key{}, kubernetes.NewForConfigOrDie(protobuf.WrapConfig(cfg)))
+	}
+	for _, ifi := range injection.Default.GetInformerFactories() {
+		ctx = ifi(ctx)
+	}
+	var inf controller.Informer
+	informers := make([]controller.Informer, 0, len(injection.Default.GetInformers()))
+	for _, ii := range injection.Default.GetInformers() {
+		ctx, inf = ii(ctx)
+		informers = append(informers, inf)
+	}
+	return ctx, informers
+}
+
+// metricsConfigured is set once a workspace watches the metrics and tracing
+// configuration, the exporters are process wide.
+var metricsConfigured bool
+
+// start starts the configmap watcher and the informers of the workspace and
+// waits for them to sync. The controllers must have registered their
+// configs.
+func (w *workspace) start() {
+	w.logger.Info("Starting configuration manager...")
+	if err := w.cmw.Start(w.ctx.Done()); err != nil {
+		w.logger.Fatalw("Failed to start configuration manager", err)
+	}
+
+	// Start all of the informers and wait for them to sync.
+	w.logger.Info("Starting informers.")
+	if err := controller.StartInformers(w.ctx.Done(), w.informers...); err != nil {
+		w.logger.Fatalw("Failed to start informers", err)
+	}
+}
@@ -0,0 +1,56 @@
+// Command deletion-check-example is a sample deletion check hook, see
+// pkg/hooks for the contract. Pass it to the controller with
+// --deletion-check-hook. It vetoes the deletion of revisions annotated
+// $KEEP_ANNOTATION=true (example.com/keep by default) and, when $MIN_AGE is
+// set, of revisions younger than it, e.g. 72h.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/knative-sample/revision-controller/pkg/hooks"
+)
+
+func main() {
+	var req hooks.Request
+	if err := json.NewDecoder(os.Stdin).Decode(&req); err != nil {
+		fail("decode request: %v", err)
+	}
+	if req.APIVersion != hooks.APIVersion || req.Kind != hooks.Kind {
+		fail("unsupported request %s %s", req.APIVersion, req.Kind)
+	}
+
+	resp := check(req)
+	if err := json.NewEncoder(os.Stdout).Encode(resp); err != nil {
+		fail("encode response: %v", err)
+	}
+}
+
+func check(req hooks.Request) hooks.Response {
+	key := os.Getenv("KEEP_ANNOTATION")
+	if key == "" {
+		key = "example.com/keep"
+	}
+	if req.Annotations[key] == "true" {
+		return hooks.Response{Message: fmt.Sprintf("annotated %s=true", key)}
+	}
+	if raw := os.Getenv("MIN_AGE"); raw != "" {
+		minAge, err := time.ParseDuration(raw)
+		if err != nil {
+			fail("invalid MIN_AGE: %v", err)
+		}
+		if age := time.Since(req.CreatedAt); age < minAge {
+			return hooks.Response{Message: fmt.Sprintf("age %s is below %s", age.Round(time.Second), minAge)}
+		}
+	}
+	return hooks.Response{Allowed: true}
+}
+
+// fail exits non-zero, which vetoes the deletion.
+func fail(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(1)
+}
@@ -0,0 +1,292 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/knative-sample/revision-controller/pkg/clock"
+	"github.com/knative-sample/revision-controller/pkg/clockskew"
+	"github.com/knative-sample/revision-controller/pkg/config"
+	"github.com/knative-sample/revision-controller/pkg/connections"
+	"github.com/knative-sample/revision-controller/pkg/exemption"
+	"github.com/knative-sample/revision-controller/pkg/footprint"
+	"github.com/knative-sample/revision-controller/pkg/gc"
+	"github.com/knative-sample/revision-controller/pkg/resourcequota"
+	"github.com/knative-sample/revision-controller/pkg/strategy"
+	"github.com/knative-sample/revision-controller/pkg/useragent"
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	autoscalingv1alpha1 "knative.dev/serving/pkg/apis/autoscaling/v1alpha1"
+	"knative.dev/serving/pkg/apis/serving/v1alpha1"
+	versioned "knative.dev/serving/pkg/client/clientset/versioned"
+	resourcenames "knative.dev/serving/pkg/reconciler/service/resources/names"
+)
+
+// NewCommandPreview returns the `kubectl revision-gc` command.
+func NewCommandPreview() *cobra.Command {
+	ops := &Options{}
+	mainCmd := &cobra.Command{
+		Use:   "kubectl revision-gc SERVICE",
+		Short: "Preview which revisions of a Service the revision-controller retains or deletes",
+		Long: "Preview which revisions of a Service the revision-controller retains or deletes.\n\n" +
+			"The cluster policy is read from the revision-controller ConfigMap and evaluated\n" +
+			"with the same decision logic the controller uses. The deletion check hooks of\n" +
+			"the controller are not run, they may still veto candidates. Nothing is deleted.",
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			return Preview(ops, args[0], c.OutOrStdout())
+		},
+	}
+
+	ops.SetOps(mainCmd)
+	mainCmd.AddCommand(newCommandExplain(ops))
+	return mainCmd
+}
+
+// Preview prints which revisions of the named Service are retained or
+// deleted under the cluster policy.
+func Preview(ops *Options, name string, out io.Writer) error {
+	c, err := newClients(ops)
+	if err != nil {
+		return err
+	}
+	cfg, s, err := load(ops, c, name)
+	if err != nil {
+		return err
+	}
+
+	result, err := gc.New(cfg).Evaluate(s)
+	if err != nil {
+		return err
+	}
+
+	footprints := make(map[string]footprint.Footprint)
+	for _, d := range append(append([]strategy.Decision(nil), result.Retained...), result.Candidates...) {
+		fp, err := revisionFootprint(c.kubeClient, d.Revision)
+		if err != nil {
+			return err
+		}
+		footprints[d.Revision.Name] = fp
+	}
+
+	if ops.At != "" {
+		fmt.Fprintf(out, "At:       %s (frozen clock)\n", s.Now.Format(time.RFC3339))
+	}
+	printResult(out, s.Service, cfg, result, footprints, s.Now)
+	return nil
+}
+
+// clients holds the clients of the namespace of the current context.
+type clients struct {
+	namespace     string
+	kubeClient    kubernetes.Interface
+	servingClient versioned.Interface
+}
+
+func newClients(ops *Options) (*clients, error) {
+	clientConfig := ops.ClientConfig()
+	namespace, _, err := clientConfig.Namespace()
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := clientConfig.ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+	cfg = clockskew.WrapConfig(cfg)
+	cfg = useragent.WrapConfig(cfg, useragent.Build("kubectl-revision_gc", ops.UserAgentSuffix))
+	kubeClient, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	servingClient, err := versioned.NewForConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &clients{namespace: namespace, kubeClient: kubeClient, servingClient: servingClient}, nil
+}
+
+// load reads the cluster policy and a snapshot of the named Service and the
+// objects its revisions are evaluated against, like the controller does.
+func load(ops *Options, c *clients, name string) (*config.GC, gc.Snapshot, error) {
+	cfg, err := loadGC(c.kubeClient, ops.ConfigNamespace)
+	if err != nil {
+		return nil, gc.Snapshot{}, err
+	}
+	service, err := c.servingClient.ServingV1alpha1().Services(c.namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return nil, gc.Snapshot{}, err
+	}
+	route, err := c.servingClient.ServingV1alpha1().Routes(c.namespace).Get(resourcenames.Route(service), metav1.GetOptions{})
+	if apierrs.IsNotFound(err) {
+		route = nil
+	} else if err != nil {
+		return nil, gc.Snapshot{}, err
+	}
+	configuration, err := c.servingClient.ServingV1alpha1().Configurations(c.namespace).Get(resourcenames.Configuration(service), metav1.GetOptions{})
+	if apierrs.IsNotFound(err) {
+		configuration = nil
+	} else if err != nil {
+		return nil, gc.Snapshot{}, err
+	}
+	revisionList, err := c.servingClient.ServingV1alpha1().Revisions(c.namespace).List(metav1.ListOptions{
+		LabelSelector: cfg.LabelKeys.RevisionSelector(service).String(),
+	})
+	if err != nil {
+		return nil, gc.Snapshot{}, err
+	}
+	revisions := make([]*v1alpha1.Revision, 0, len(revisionList.Items))
+	for i := range revisionList.Items {
+		revisions = append(revisions, &revisionList.Items[i])
+	}
+
+	var pas []*autoscalingv1alpha1.PodAutoscaler
+	for _, re := range revisions {
+		pa, err := c.servingClient.AutoscalingV1alpha1().PodAutoscalers(c.namespace).Get(re.Name, metav1.GetOptions{})
+		if apierrs.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return nil, gc.Snapshot{}, err
+		}
+		pas = append(pas, pa)
+	}
+
+	if ops.PrometheusURL != "" {
+		cfg.ConnectionsPrometheusURL = ops.PrometheusURL
+	}
+	var open map[string]float64
+	source, err := connections.FromConfig(cfg)
+	if err != nil {
+		return nil, gc.Snapshot{}, err
+	}
+	if source != nil {
+		if open, err = source.OpenConnections(context.Background(), service); err != nil {
+			return nil, gc.Snapshot{}, err
+		}
+	}
+
+	exemptions, err := exemption.Read(c.kubeClient, ops.Exemptions, ops.ConfigNamespace)
+	if err != nil {
+		return nil, gc.Snapshot{}, err
+	}
+	exemptedBy, _ := exemptions.Exempt(service.Namespace, service.Name)
+
+	var pressure string
+	if cfg.QuotaPressureThreshold > 0 {
+		quotaList, err := c.kubeClient.CoreV1().ResourceQuotas(c.namespace).List(metav1.ListOptions{})
+		if err != nil {
+			return nil, gc.Snapshot{}, err
+		}
+		quotas := make([]*corev1.ResourceQuota, 0, len(quotaList.Items))
+		for i := range quotaList.Items {
+			quotas = append(quotas, &quotaList.Items[i])
+		}
+		pressure, _ = resourcequota.Pressure(quotas, cfg.QuotaPressureResources, cfg.QuotaPressureThreshold)
+	}
+
+	skew, _ := clockskew.Default.Offset()
+	now := clock.Real.Now().Add(skew)
+	if ops.At != "" {
+		frozen, err := clock.ParseAt(ops.At, now)
+		if err != nil {
+			return nil, gc.Snapshot{}, fmt.Errorf("invalid --at: %v", err)
+		}
+		now = frozen.Now()
+	}
+	return cfg, gc.Snapshot{
+		Service:        service,
+		Route:          route,
+		Configuration:  configuration,
+		Revisions:      revisions,
+		PodAutoscalers: pas,
+		Connections:    open,
+		Now:            now,
+		ClockSkew:      skew,
+		ExemptedBy:     exemptedBy,
+		QuotaPressure:  pressure,
+	}, nil
+}
+
+// revisionFootprint estimates the footprint of the revision from its
+// Deployment, falling back to the revision spec.
+func revisionFootprint(kubeClient kubernetes.Interface, revision *v1alpha1.Revision) (footprint.Footprint, error) {
+	deployments, err := kubeClient.AppsV1().Deployments(revision.Namespace).List(metav1.ListOptions{
+		LabelSelector: footprint.RevisionSelector(revision.Name).String(),
+	})
+	if err != nil {
+		return footprint.Footprint{}, err
+	}
+	if len(deployments.Items) == 0 {
+		return footprint.FromRevision(revision), nil
+	}
+	total := footprint.Footprint{}
+	for i := range deployments.Items {
+		total.Add(footprint.FromDeployment(&deployments.Items[i]))
+	}
+	return total, nil
+}
+
+// loadGC reads the cluster policy, falling back to the defaults when the
+// ConfigMap does not exist.
+func loadGC(kubeClient kubernetes.Interface, namespace string) (*config.GC, error) {
+	cm, err := kubeClient.CoreV1().ConfigMaps(namespace).Get(config.GCConfigName, metav1.GetOptions{})
+	if apierrs.IsNotFound(err) {
+		return config.NewGCFromMap(map[string]string{})
+	} else if err != nil {
+		return nil, err
+	}
+	return config.NewGCFromConfigMap(cm)
+}
+
+func printResult(out io.Writer, service *v1alpha1.Service, cfg *config.GC, result *strategy.Result, footprints map[string]footprint.Footprint, now time.Time) {
+	policy := cfg.Policy()
+	fmt.Fprintf(out, "Service:  %s/%s\n", service.Namespace, service.Name)
+	fmt.Fprintf(out, "Policy:   %s (retain-count=%d, min-stale-age=%s, max-revisions=%d)\n", policy.Name, policy.RetainCount, policy.MinStaleAge, policy.MaxRevisions)
+	if cfg.MaintenanceHold {
+		fmt.Fprintln(out, "Hold:     maintenance hold active, deletions are deferred")
+	}
+	if windows := cfg.DeletionWindows; len(windows) > 0 {
+		if windows.Active(now) {
+			fmt.Fprintf(out, "Windows:  %s, open now\n", windows)
+		} else {
+			fmt.Fprintf(out, "Windows:  %s, next opens at %s\n", windows, windows.NextStart(now).Format(time.RFC3339))
+		}
+	}
+	if result.Skipped() {
+		fmt.Fprintf(out, "Skipped:  %s\n", result.SkipReason)
+	} else {
+		fmt.Fprintf(out, "Routed:   %s\n", result.RoutedRevision)
+	}
+	fmt.Fprintf(out, "Retained: %d, Candidates: %d\n", len(result.Retained), len(result.Candidates))
+	reclaim := footprint.Footprint{}
+	for _, d := range result.Candidates {
+		reclaim.Add(footprints[d.Revision.Name])
+	}
+	fmt.Fprintf(out, "Reclaim:  %s\n", reclaim)
+	if len(result.Candidates) > 0 {
+		fmt.Fprintln(out, "Hooks:    not run, the deletion check hooks of the controller may still veto candidates")
+	}
+	fmt.Fprintln(out)
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "REVISION\tGENERATION\tAGE\tDECISION\tREASON\tFOOTPRINT\tMESSAGE")
+	for _, d := range result.Retained {
+		printDecision(w, d, "retain", footprints[d.Revision.Name], now)
+	}
+	for _, d := range result.Candidates {
+		printDecision(w, d, "delete", footprints[d.Revision.Name], now)
+	}
+	w.Flush()
+}
+
+func printDecision(w io.Writer, d strategy.Decision, decision string, fp footprint.Footprint, now time.Time) {
+	age := now.Sub(strategy.CreatedAt(d.Revision)).Round(time.Second)
+	fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t%s\t%s\n", d.Revision.Name, d.Generation, age, decision, d.Reason, fp, d.Message)
+}
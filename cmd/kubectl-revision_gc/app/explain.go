@@ -0,0 +1,90 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/knative-sample/revision-controller/pkg/explain"
+	"github.com/knative-sample/revision-controller/pkg/gc"
+	"github.com/knative-sample/revision-controller/pkg/quota"
+	"github.com/spf13/cobra"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// newCommandExplain returns the `kubectl revision-gc explain` command.
+func newCommandExplain(ops *Options) *cobra.Command {
+	return &cobra.Command{
+		Use:   "explain REVISION",
+		Short: "Explain why a revision is still there",
+		Long: "Explain why a revision is still there.\n\n" +
+			"Lists every rule that currently keeps the revision: the rules of the cluster\n" +
+			"policy, the maintenance hold, the deletion windows, the approval of the\n" +
+			"deletion plan and the deletion quotas. Nothing is deleted.",
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			return explainRevision(ops, args[0], c.OutOrStdout())
+		},
+	}
+}
+
+func explainRevision(ops *Options, name string, out io.Writer) error {
+	c, err := newClients(ops)
+	if err != nil {
+		return err
+	}
+	revision, err := c.servingClient.ServingV1alpha1().Revisions(c.namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	cfg, err := loadGC(c.kubeClient, ops.ConfigNamespace)
+	if err != nil {
+		return err
+	}
+	serviceName, ok := revision.Labels[cfg.LabelKeys.Service]
+	if !ok {
+		return fmt.Errorf("revision %s does not belong to a Service", name)
+	}
+
+	cfg, s, err := load(ops, c, serviceName)
+	if err != nil {
+		return err
+	}
+	remaining, err := quota.NewTracker(c.kubeClient, ops.ConfigNamespace).Remaining(context.Background(), c.namespace, cfg.Team(s.Service.Labels), cfg.Quotas(), s.Now)
+	if err != nil {
+		return err
+	}
+	e, err := gc.New(cfg).Explain(s, name, remaining)
+	if err != nil {
+		return err
+	}
+
+	printExplanation(out, e)
+	return nil
+}
+
+func printExplanation(out io.Writer, e *explain.Explanation) {
+	fmt.Fprintf(out, "Revision:   %s/%s (generation %d)\n", e.Namespace, e.Revision, e.Generation)
+	fmt.Fprintf(out, "Service:    %s\n", e.Service)
+	fmt.Fprintf(out, "Policy:     %s\n", e.Policy)
+	fmt.Fprintf(out, "Decision:   %s\n", e.Decision)
+	fmt.Fprintf(out, "Evaluated:  %s\n", e.EvaluatedAt.Format(time.RFC3339))
+	if e.EligibleAt != nil {
+		fmt.Fprintf(out, "Eligible:   %s (in %s)\n", e.EligibleAt.Format(time.RFC3339), e.EligibleAt.Sub(e.EvaluatedAt).Round(time.Second))
+	}
+	if len(e.Rules) == 0 {
+		fmt.Fprintln(out, "\nNo rule keeps the revision, it is deleted by the next execution unless a deletion\ncheck hook of the controller vetoes it; the hooks are not run here.")
+		return
+	}
+
+	fmt.Fprintln(out)
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "RULE\tMESSAGE")
+	for _, r := range e.Rules {
+		fmt.Fprintf(w, "%s\t%s\n", r.Reason, r.Message)
+	}
+	w.Flush()
+}
@@ -0,0 +1,49 @@
+package app
+
+import (
+	"github.com/knative-sample/revision-controller/pkg/exemption"
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+type Options struct {
+	Kubeconfig      string
+	Server          string
+	Context         string
+	UserAgentSuffix string
+	Namespace       string
+	ConfigNamespace string
+	PrometheusURL   string
+	At              string
+	Exemptions      exemption.Options
+}
+
+func (s *Options) SetOps(ac *cobra.Command) {
+	ac.Flags().StringVar(&s.Kubeconfig, "kubeconfig", s.Kubeconfig, "Path to a kubeconfig. Defaults to the kubectl configuration.")
+	ac.Flags().StringVar(&s.Context, "context", s.Context, "The kubeconfig context to use.")
+	ac.Flags().StringVar(&s.UserAgentSuffix, "user-agent-suffix", s.UserAgentSuffix, "Appended to the User-Agent sent to the API server.")
+	ac.Flags().StringVar(&s.Exemptions.URL, "exemptions-url", s.Exemptions.URL, "The exemption list the controller pulls with --exemptions-url, read once.")
+	ac.Flags().StringVar(&s.Exemptions.ConfigMap, "exemptions-configmap", s.Exemptions.ConfigMap, "The ConfigMap of --config-namespace the controller reads its exemption list from with --exemptions-configmap.")
+	s.SetServiceOps(ac)
+}
+
+// SetServiceOps adds the flags locating the Service and the configuration,
+// shared with the plan command of the controller.
+func (s *Options) SetServiceOps(ac *cobra.Command) {
+	ac.Flags().StringVarP(&s.Namespace, "namespace", "n", s.Namespace, "The namespace of the Service. Defaults to the namespace of the current context.")
+	ac.Flags().StringVar(&s.ConfigNamespace, "config-namespace", "knative-serving", "The namespace holding the revision-controller configuration.")
+	ac.Flags().StringVar(&s.PrometheusURL, "prometheus-url", s.PrometheusURL, "The Prometheus server open connections are read from, e.g. through a port-forward. Overrides connections-prometheus-url.")
+	ac.Flags().StringVar(&s.At, "at", s.At, "Evaluate with the clock frozen at this time instead of now, to preview which revisions become eligible then if nothing else changes: an RFC 3339 time or a duration from now, e.g. 72h.")
+}
+
+// ClientConfig returns the kubectl compatible client configuration.
+func (s *Options) ClientConfig() clientcmd.ClientConfig {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = s.Kubeconfig
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: s.Context}
+	overrides.ClusterInfo.Server = s.Server
+	if s.Namespace != "" {
+		overrides.Context.Namespace = s.Namespace
+	}
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides)
+}
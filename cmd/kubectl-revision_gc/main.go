@@ -0,0 +1,15 @@
+package main
+
+import (
+	"os"
+
+	"github.com/knative-sample/revision-controller/cmd/kubectl-revision_gc/app"
+)
+
+// kubectl-revision_gc is invoked by kubectl as `kubectl revision-gc`.
+func main() {
+	cmd := app.NewCommandPreview()
+	if err := cmd.Execute(); err != nil {
+		os.Exit(1)
+	}
+}
@@ -20,7 +20,7 @@ func main() {
 
 	// Start runner
 	cmd := app.NewCommandStartServer()
-	cmd.Flags().AddGoFlagSet(flag.CommandLine)
+	cmd.PersistentFlags().AddGoFlagSet(flag.CommandLine)
 	flag.CommandLine.Parse([]string{})
 
 	if err := cmd.Execute(); err != nil {
@@ -0,0 +1,352 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package admin serves the admin API of the controller: on-demand garbage
+// collection of a Service, the cluster wide pause switch, explanations of
+// why a revision is still there, the inventory of retained revisions and the
+// backlog of stale revisions. Callers
+// authenticate with a client certificate or a bearer token and are authorized
+// with a SubjectAccessReview against custom verbs on the gc.knative.dev
+// revisiongc resource, e.g.
+//
+//   - apiGroups: ["gc.knative.dev"]
+//     resources: ["revisiongc"]
+//     verbs: ["trigger", "pause", "resume", "explain", "inventory", "backlog"]
+package admin
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	gcv1alpha1 "github.com/knative-sample/revision-controller/pkg/apis/gc/v1alpha1"
+	"github.com/knative-sample/revision-controller/pkg/auth"
+	"github.com/knative-sample/revision-controller/pkg/backlog"
+	"github.com/knative-sample/revision-controller/pkg/config"
+	"github.com/knative-sample/revision-controller/pkg/explain"
+	"github.com/knative-sample/revision-controller/pkg/inventory"
+	"github.com/knative-sample/revision-controller/pkg/listener"
+	"go.uber.org/zap"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/cert"
+	"knative.dev/pkg/system"
+)
+
+const (
+	// Resource is the resource the admin verbs are authorized on.
+	Resource = "revisiongc"
+
+	// VerbTrigger runs the garbage collection of a Service now.
+	VerbTrigger = "trigger"
+	// VerbPause sets the maintenance hold, deferring all deletions.
+	VerbPause = "pause"
+	// VerbResume lifts the maintenance hold.
+	VerbResume = "resume"
+	// VerbExplain explains why a revision is still there.
+	VerbExplain = "explain"
+	// VerbInventory exports the revisions retained across all Services.
+	VerbInventory = "inventory"
+	// VerbBacklog reports the backlog of stale revisions, e.g. to an
+	// external autoscaler of the garbage collection interval.
+	VerbBacklog = "backlog"
+)
+
+// Options configures the admin server.
+type Options struct {
+	// Listener configures where the server listens and its certificate.
+	Listener listener.Options
+
+	// ClientCAFile holds the CAs client certificates are verified with.
+	// Only bearer tokens are accepted when empty.
+	ClientCAFile string
+}
+
+// Explainer explains the decisions made for revisions.
+type Explainer interface {
+	// Explain explains why the named revision is still there.
+	Explain(ctx context.Context, namespace, name string) (*explain.Explanation, error)
+}
+
+// InventorySource lists the retained revisions.
+type InventorySource interface {
+	// Inventory lists the revisions retained across all Services.
+	Inventory(ctx context.Context) (*inventory.Inventory, error)
+}
+
+// BacklogSource reports the backlog of stale revisions.
+type BacklogSource interface {
+	// Snapshot returns the current backlog.
+	Snapshot() backlog.Backlog
+}
+
+// Server serves the admin API.
+type Server struct {
+	logger     *zap.SugaredLogger
+	kubeClient kubernetes.Interface
+	explainer  Explainer
+	inventory  InventorySource
+	backlog    BacklogSource
+
+	// trigger enqueues the Service key in the controllers.
+	trigger func(key string)
+}
+
+// New returns a Server enqueuing triggered Services with trigger.
+func New(logger *zap.SugaredLogger, kubeClient kubernetes.Interface, explainer Explainer, inventory InventorySource, backlog BacklogSource, trigger func(key string)) *Server {
+	return &Server{
+		logger:     logger,
+		kubeClient: kubeClient,
+		explainer:  explainer,
+		inventory:  inventory,
+		backlog:    backlog,
+		trigger:    trigger,
+	}
+}
+
+// Run serves the admin API over TLS until ctx is done. TLS is always on; a
+// self signed certificate is generated when the options configure none.
+func (s *Server) Run(ctx context.Context, options Options) error {
+	serverCert, _, err := options.Listener.Certificate(s.kubeClient, system.Namespace())
+	if err != nil {
+		return err
+	}
+	if serverCert == nil {
+		certPEM, keyPEM, err := cert.GenerateSelfSignedCertKey("revision-controller-admin", nil, nil)
+		if err != nil {
+			return fmt.Errorf("generate admin serving certificate: %v", err)
+		}
+		pair, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			return err
+		}
+		serverCert = &pair
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{*serverCert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if options.ClientCAFile != "" {
+		pool, err := cert.NewPool(options.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("read admin client CA: %v", err)
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	l, err := options.Listener.Listen()
+	if err != nil {
+		return err
+	}
+	server := &http.Server{Handler: s, TLSConfig: tlsConfig}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- server.ServeTLS(l, "", "")
+	}()
+	s.logger.Infof("Serving the admin API on %s", l.Addr())
+
+	select {
+	case <-ctx.Done():
+		return server.Shutdown(context.Background())
+	case err := <-errCh:
+		return err
+	}
+}
+
+// ServeHTTP implements http.Handler. It serves
+//
+//	POST /v1/namespaces/{namespace}/services/{name}/trigger
+//	GET  /v1/namespaces/{namespace}/revisions/{name}/explain
+//	GET  /v1/inventory?format=json|prometheus
+//	GET  /v1/backlog
+//	POST /v1/pause
+//	POST /v1/resume
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/healthz" {
+		w.Write([]byte("ok"))
+		return
+	}
+
+	user, err := s.authenticate(r)
+	if err != nil {
+		s.writeError(w, apierrs.NewUnauthorized(err.Error()))
+		return
+	}
+
+	verb, namespace, name, ok := parsePath(r.URL.Path)
+	if !ok {
+		s.writeError(w, apierrs.NewNotFound(schema.GroupResource{}, r.URL.Path))
+		return
+	}
+	method := http.MethodPost
+	if verb == VerbExplain || verb == VerbInventory || verb == VerbBacklog {
+		method = http.MethodGet
+	}
+	if r.Method != method {
+		s.writeError(w, apierrs.NewMethodNotSupported(resource(), strings.ToLower(r.Method)))
+		return
+	}
+
+	allowed, reason, err := auth.Authorize(s.kubeClient, user, &authorizationv1.ResourceAttributes{
+		Namespace: namespace,
+		Verb:      verb,
+		Group:     gcv1alpha1.GroupName,
+		Resource:  Resource,
+		Name:      name,
+	})
+	if err != nil {
+		s.writeError(w, apierrs.NewInternalError(err))
+		return
+	}
+	if !allowed {
+		s.writeError(w, apierrs.NewForbidden(resource(), name, fmt.Errorf("user %q cannot %s: %s", user.Name, verb, reason)))
+		return
+	}
+
+	switch verb {
+	case VerbTrigger:
+		key := namespace + "/" + name
+		s.logger.Infof("admin: %s triggered garbage collection of service %s", user.Name, key)
+		s.trigger(key)
+		s.writeStatus(w, http.StatusAccepted, fmt.Sprintf("garbage collection of service %s triggered", key))
+	case VerbPause, VerbResume:
+		hold := verb == VerbPause
+		if err := s.setMaintenanceHold(hold); err != nil {
+			s.writeError(w, apierrs.NewInternalError(err))
+			return
+		}
+		s.logger.Infof("admin: %s set the maintenance hold to %t", user.Name, hold)
+		s.writeStatus(w, http.StatusOK, fmt.Sprintf("maintenance hold set to %t", hold))
+	case VerbExplain:
+		e, err := s.explainer.Explain(r.Context(), namespace, name)
+		if err != nil {
+			if status, ok := err.(*apierrs.StatusError); ok {
+				s.writeError(w, status)
+			} else {
+				s.writeError(w, apierrs.NewInternalError(err))
+			}
+			return
+		}
+		s.writeJSON(w, http.StatusOK, e)
+	case VerbInventory:
+		format, err := inventory.ParseFormat(r.URL.Query().Get("format"))
+		if err != nil {
+			s.writeError(w, apierrs.NewBadRequest(err.Error()))
+			return
+		}
+		inv, err := s.inventory.Inventory(r.Context())
+		if err != nil {
+			s.writeError(w, apierrs.NewInternalError(err))
+			return
+		}
+		if format == inventory.FormatPrometheus {
+			w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		} else {
+			w.Header().Set("Content-Type", "application/json")
+		}
+		if err := inv.Write(w, format); err != nil {
+			s.logger.Errorf("admin encode inventory error: %s", err.Error())
+		}
+	case VerbBacklog:
+		s.writeJSON(w, http.StatusOK, s.backlog.Snapshot())
+	}
+}
+
+// authenticate returns the user of a verified client certificate, or of the
+// bearer token of the request.
+func (s *Server) authenticate(r *http.Request) (*auth.User, error) {
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		return auth.FromCertificate(r.TLS.VerifiedChains[0][0]), nil
+	}
+	token := auth.BearerToken(r)
+	if token == "" {
+		return nil, fmt.Errorf("a client certificate or a bearer token is required")
+	}
+	return auth.ReviewToken(s.kubeClient, token)
+}
+
+// parsePath returns the verb and the object the request path addresses.
+func parsePath(path string) (verb, namespace, name string, ok bool) {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	switch {
+	case len(parts) == 2 && parts[0] == "v1" && (parts[1] == VerbPause || parts[1] == VerbResume || parts[1] == VerbInventory || parts[1] == VerbBacklog):
+		return parts[1], "", "", true
+	case len(parts) == 6 && parts[0] == "v1" && parts[1] == "namespaces" && parts[2] != "" &&
+		parts[3] == "services" && parts[4] != "" && parts[5] == VerbTrigger:
+		return VerbTrigger, parts[2], parts[4], true
+	case len(parts) == 6 && parts[0] == "v1" && parts[1] == "namespaces" && parts[2] != "" &&
+		parts[3] == "revisions" && parts[4] != "" && parts[5] == VerbExplain:
+		return VerbExplain, parts[2], parts[4], true
+	}
+	return "", "", "", false
+}
+
+// setMaintenanceHold sets the maintenance hold annotation of the GC
+// ConfigMap, which the controllers pick up like any configuration change.
+func (s *Server) setMaintenanceHold(hold bool) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{
+				config.MaintenanceHoldAnnotationKey: fmt.Sprintf("%t", hold),
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = s.kubeClient.CoreV1().ConfigMaps(system.Namespace()).Patch(config.GCConfigName, types.MergePatchType, patch)
+	return err
+}
+
+func resource() schema.GroupResource {
+	return schema.GroupResource{Group: gcv1alpha1.GroupName, Resource: Resource}
+}
+
+func (s *Server) writeStatus(w http.ResponseWriter, code int, message string) {
+	s.writeJSON(w, code, &metav1.Status{
+		TypeMeta: metav1.TypeMeta{Kind: "Status", APIVersion: "v1"},
+		Status:   metav1.StatusSuccess,
+		Code:     int32(code),
+		Message:  message,
+	})
+}
+
+func (s *Server) writeError(w http.ResponseWriter, err *apierrs.StatusError) {
+	status := err.Status()
+	status.TypeMeta = metav1.TypeMeta{Kind: "Status", APIVersion: "v1"}
+	if status.Code >= http.StatusInternalServerError {
+		s.logger.Errorf("admin error: %s", status.Message)
+	}
+	s.writeJSON(w, int(status.Code), &status)
+}
+
+func (s *Server) writeJSON(w http.ResponseWriter, code int, obj interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(obj); err != nil {
+		s.logger.Errorf("admin encode response error: %s", err.Error())
+	}
+}
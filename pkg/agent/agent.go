@@ -0,0 +1,390 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package agent runs the controller as an agent of a central control plane:
+// the garbage collection configuration is fetched from an HTTPS endpoint
+// instead of read from the local ConfigMaps, for fleets whose policy is
+// managed outside of the clusters. The endpoint serves a signed envelope
+//
+//	{"payload": "<base64 JSON>", "signature": "<base64>"}
+//
+// whose payload is
+//
+//	{
+//	  "issuedAt": "2019-10-01T12:00:00Z",
+//	  "audience": "prod-eu-1",
+//	  "configMaps": {
+//	    "config-revision-gc": {"data": {"retain-count": "5"}},
+//	    "config-revision-gc-notifications": {"data": {}}
+//	  }
+//	}
+//
+// signed with the private key matching the configured public key: Ed25519,
+// ECDSA over the SHA-256 digest or RSA PKCS #1 v1.5 with SHA-256. The
+// audience binds the payload to the cluster it was issued for, so the
+// payload of another cluster signed with the same key cannot be replayed.
+// Payloads that fail verification, were issued for another cluster, before
+// the one in effect or more than MaxIssuedAtSkew in the future are rejected
+// and the configuration in effect is kept.
+package agent
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/knative-sample/revision-controller/pkg/clock"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/cert"
+	"knative.dev/pkg/configmap"
+)
+
+// Options configures the agent mode.
+type Options struct {
+	// URL is the HTTPS endpoint serving the signed configuration. The agent
+	// mode is off when empty.
+	URL string
+
+	// PublicKeyFile holds the PEM encoded public key the payloads are
+	// verified with.
+	PublicKeyFile string
+
+	// CAFile holds the CAs the endpoint certificate is verified with, the
+	// system roots when empty.
+	CAFile string
+
+	// Cluster identifies the cluster to the control plane, sent as the
+	// cluster query parameter. Only payloads issued for it are accepted.
+	Cluster string
+
+	// Interval is how often the configuration is fetched.
+	Interval time.Duration
+}
+
+// Enabled reports whether the agent mode is on.
+func (o Options) Enabled() bool {
+	return o.URL != ""
+}
+
+// ConfigMap is the content of a ConfigMap served by the control plane.
+type ConfigMap struct {
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Data        map[string]string `json:"data"`
+}
+
+// MaxIssuedAtSkew is how far in the future of the local clock a payload may
+// be issued, tolerating small clock skew with the control plane. A payload
+// issued later would lock out the payloads issued until then.
+const MaxIssuedAtSkew = 5 * time.Minute
+
+// Payload is the configuration served by the control plane.
+type Payload struct {
+	// IssuedAt orders the payloads, older ones are rejected.
+	IssuedAt time.Time `json:"issuedAt"`
+
+	// Audience is the cluster the payload was issued for.
+	Audience string `json:"audience"`
+
+	// ConfigMaps are the ConfigMaps replacing the local ones, by name.
+	ConfigMaps map[string]ConfigMap `json:"configMaps"`
+}
+
+// Envelope is the signed payload served by the endpoint.
+type Envelope struct {
+	Payload   string `json:"payload"`
+	Signature string `json:"signature"`
+}
+
+// Verify verifies the signature of the envelope with key and returns its
+// payload. The payload must be issued for the audience, no earlier than the
+// previous payload, if any, and at most MaxIssuedAtSkew after now.
+func (e *Envelope) Verify(key crypto.PublicKey, audience string, previous *Payload, now time.Time) (*Payload, error) {
+	raw, err := base64.StdEncoding.DecodeString(e.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("decode payload: %v", err)
+	}
+	sig, err := base64.StdEncoding.DecodeString(e.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("decode signature: %v", err)
+	}
+	digest := sha256.Sum256(raw)
+	switch k := key.(type) {
+	case ed25519.PublicKey:
+		if !ed25519.Verify(k, raw, sig) {
+			return nil, errors.New("invalid Ed25519 signature")
+		}
+	case *ecdsa.PublicKey:
+		var rs struct{ R, S *big.Int }
+		if rest, err := asn1.Unmarshal(sig, &rs); err != nil || len(rest) > 0 || !ecdsa.Verify(k, digest[:], rs.R, rs.S) {
+			return nil, errors.New("invalid ECDSA signature")
+		}
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], sig); err != nil {
+			return nil, fmt.Errorf("invalid RSA signature: %v", err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T", key)
+	}
+
+	p := &Payload{}
+	if err := json.Unmarshal(raw, p); err != nil {
+		return nil, fmt.Errorf("decode payload: %v", err)
+	}
+	if p.Audience != audience {
+		return nil, fmt.Errorf("payload issued for cluster %q, not %q", p.Audience, audience)
+	}
+	if p.IssuedAt.IsZero() {
+		return nil, errors.New("payload has no issue time")
+	}
+	if p.IssuedAt.After(now.Add(MaxIssuedAtSkew)) {
+		return nil, fmt.Errorf("payload issued at %s, more than %s in the future", p.IssuedAt.Format(time.RFC3339), MaxIssuedAtSkew)
+	}
+	if previous != nil && p.IssuedAt.Before(previous.IssuedAt) {
+		return nil, fmt.Errorf("payload issued at %s predates the one in effect, issued at %s",
+			p.IssuedAt.Format(time.RFC3339), previous.IssuedAt.Format(time.RFC3339))
+	}
+	return p, nil
+}
+
+// ReadPublicKey reads a PEM encoded PKIX public key.
+func ReadPublicKey(path string) (crypto.PublicKey, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return nil, fmt.Errorf("%s holds no PEM block", path)
+	}
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
+
+// Watcher serves the ConfigMaps of the control plane to their observers,
+// and watches all other ConfigMaps, e.g. the logging and observability
+// ones, with the embedded InformedWatcher.
+type Watcher struct {
+	*configmap.InformedWatcher
+
+	logger    *zap.SugaredLogger
+	options   Options
+	namespace string
+	key       crypto.PublicKey
+	client    *http.Client
+	clock     clock.Clock
+
+	mu        sync.Mutex
+	observers map[string][]configmap.Observer
+	current   *Payload
+}
+
+var _ configmap.DefaultingWatcher = (*Watcher)(nil)
+
+// NewWatcher returns a Watcher serving the ConfigMaps names of the control
+// plane in the namespace, delegating the others to inner.
+func NewWatcher(logger *zap.SugaredLogger, inner *configmap.InformedWatcher, namespace string, options Options, names ...string) (*Watcher, error) {
+	u, err := url.Parse(options.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid agent URL: %v", err)
+	}
+	if u.Scheme != "https" {
+		return nil, fmt.Errorf("agent URL %s must use https", options.URL)
+	}
+	if options.Interval <= 0 {
+		return nil, fmt.Errorf("agent interval must be positive, got %s", options.Interval)
+	}
+	if options.Cluster == "" {
+		return nil, errors.New("agent cluster must be set, payloads are only accepted for it")
+	}
+	key, err := ReadPublicKey(options.PublicKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("read agent public key: %v", err)
+	}
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if options.CAFile != "" {
+		pool, err := cert.NewPool(options.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read agent CA: %v", err)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	w := &Watcher{
+		InformedWatcher: inner,
+		logger:          logger,
+		options:         options,
+		namespace:       namespace,
+		key:             key,
+		client: &http.Client{
+			Timeout:   30 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: tlsConfig},
+		},
+		clock:     clock.Real,
+		observers: make(map[string][]configmap.Observer, len(names)),
+	}
+	for _, name := range names {
+		w.observers[name] = nil
+	}
+	return w, nil
+}
+
+// Watch implements configmap.Watcher.
+func (w *Watcher) Watch(name string, o configmap.Observer) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if observers, ok := w.observers[name]; ok {
+		w.observers[name] = append(observers, o)
+		return
+	}
+	w.InformedWatcher.Watch(name, o)
+}
+
+// Start implements configmap.Watcher. It fails unless the first payload is
+// fetched and verified, the controllers cannot run without a policy.
+func (w *Watcher) Start(stopCh <-chan struct{}) error {
+	if err := w.InformedWatcher.Start(stopCh); err != nil {
+		return err
+	}
+	if err := w.poll(); err != nil {
+		return fmt.Errorf("fetch the configuration from %s: %v", w.options.URL, err)
+	}
+	go func() {
+		ticker := time.NewTicker(w.options.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stopCh:
+				return
+			case <-ticker.C:
+				if err := w.poll(); err != nil {
+					w.logger.Errorf("agent: fetch the configuration from %s error:%s, keeping the configuration issued at %s",
+						w.options.URL, err.Error(), w.issuedAt().Format(time.RFC3339))
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+func (w *Watcher) issuedAt() time.Time {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.current == nil {
+		return time.Time{}
+	}
+	return w.current.IssuedAt
+}
+
+// poll fetches and verifies the payload and notifies the observers of the
+// ConfigMaps it changed.
+func (w *Watcher) poll() error {
+	w.mu.Lock()
+	previous := w.current
+	w.mu.Unlock()
+	p, err := w.fetch(previous)
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	for name := range w.observers {
+		if _, ok := p.ConfigMaps[name]; !ok {
+			w.mu.Unlock()
+			return fmt.Errorf("payload issued at %s misses ConfigMap %s", p.IssuedAt.Format(time.RFC3339), name)
+		}
+	}
+	w.current = p
+	type notification struct {
+		cm        *corev1.ConfigMap
+		observers []configmap.Observer
+	}
+	var notifications []notification
+	for name, observers := range w.observers {
+		content := p.ConfigMaps[name]
+		if previous != nil && reflect.DeepEqual(previous.ConfigMaps[name], content) {
+			continue
+		}
+		notifications = append(notifications, notification{
+			cm: &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        name,
+					Namespace:   w.namespace,
+					Annotations: content.Annotations,
+				},
+				Data: content.Data,
+			},
+			observers: append([]configmap.Observer(nil), observers...),
+		})
+	}
+	w.mu.Unlock()
+
+	if len(notifications) > 0 {
+		w.logger.Infof("agent: applying the configuration issued at %s", p.IssuedAt.Format(time.RFC3339))
+	}
+	for _, n := range notifications {
+		for _, o := range n.observers {
+			o(n.cm)
+		}
+	}
+	return nil
+}
+
+// fetch reads the payload served by the endpoint and verifies it against
+// the previous one.
+func (w *Watcher) fetch(previous *Payload) (*Payload, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), w.client.Timeout)
+	defer cancel()
+	u, _ := url.Parse(w.options.URL)
+	q := u.Query()
+	q.Set("cluster", w.options.Cluster)
+	u.RawQuery = q.Encode()
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := w.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	e := &Envelope{}
+	if err := json.NewDecoder(resp.Body).Decode(e); err != nil {
+		return nil, fmt.Errorf("decode envelope: %v", err)
+	}
+	return e.Verify(w.key, w.options.Cluster, previous, w.clock.Now())
+}
@@ -0,0 +1,290 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package agent
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var now = time.Date(2019, 10, 1, 12, 0, 0, 0, time.UTC)
+
+// signer signs raw with the private key of a key pair.
+type signer func(t *testing.T, raw []byte) []byte
+
+type keyPair struct {
+	name   string
+	public crypto.PublicKey
+	sign   signer
+}
+
+func keyPairs(t *testing.T) []keyPair {
+	t.Helper()
+	edPublic, edPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("ed25519.GenerateKey() = %v", err)
+	}
+	ecPrivate, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("ecdsa.GenerateKey() = %v", err)
+	}
+	rsaPrivate, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey() = %v", err)
+	}
+	return []keyPair{{
+		name:   "ed25519",
+		public: edPublic,
+		sign: func(t *testing.T, raw []byte) []byte {
+			return ed25519.Sign(edPrivate, raw)
+		},
+	}, {
+		name:   "ecdsa",
+		public: &ecPrivate.PublicKey,
+		sign: func(t *testing.T, raw []byte) []byte {
+			digest := sha256.Sum256(raw)
+			r, s, err := ecdsa.Sign(rand.Reader, ecPrivate, digest[:])
+			if err != nil {
+				t.Fatalf("ecdsa.Sign() = %v", err)
+			}
+			sig, err := asn1.Marshal(struct{ R, S *big.Int }{r, s})
+			if err != nil {
+				t.Fatalf("asn1.Marshal() = %v", err)
+			}
+			return sig
+		},
+	}, {
+		name:   "rsa",
+		public: &rsaPrivate.PublicKey,
+		sign: func(t *testing.T, raw []byte) []byte {
+			digest := sha256.Sum256(raw)
+			sig, err := rsa.SignPKCS1v15(rand.Reader, rsaPrivate, crypto.SHA256, digest[:])
+			if err != nil {
+				t.Fatalf("rsa.SignPKCS1v15() = %v", err)
+			}
+			return sig
+		},
+	}}
+}
+
+func payload(audience string, issuedAt time.Time) *Payload {
+	return &Payload{
+		IssuedAt: issuedAt,
+		Audience: audience,
+		ConfigMaps: map[string]ConfigMap{
+			"config-revision-gc": {Data: map[string]string{"stale-revision-lastpinned-debounce": "5h"}},
+		},
+	}
+}
+
+func envelope(t *testing.T, p *Payload, sign signer) *Envelope {
+	t.Helper()
+	raw, err := json.Marshal(p)
+	if err != nil {
+		t.Fatalf("json.Marshal() = %v", err)
+	}
+	return &Envelope{
+		Payload:   base64.StdEncoding.EncodeToString(raw),
+		Signature: base64.StdEncoding.EncodeToString(sign(t, raw)),
+	}
+}
+
+func TestVerify(t *testing.T) {
+	pairs := keyPairs(t)
+	for i, pair := range pairs {
+		other := pairs[(i+1)%len(pairs)]
+		tests := []struct {
+			name     string
+			envelope func(t *testing.T) *Envelope
+			key      crypto.PublicKey
+			previous *Payload
+			want     *Payload
+			wantErr  string
+		}{{
+			name: "valid",
+			envelope: func(t *testing.T) *Envelope {
+				return envelope(t, payload("prod-eu-1", now), pair.sign)
+			},
+			want: payload("prod-eu-1", now),
+		}, {
+			name: "issued with the previous one",
+			envelope: func(t *testing.T) *Envelope {
+				return envelope(t, payload("prod-eu-1", now), pair.sign)
+			},
+			previous: payload("prod-eu-1", now),
+			want:     payload("prod-eu-1", now),
+		}, {
+			name: "issued after the previous one",
+			envelope: func(t *testing.T) *Envelope {
+				return envelope(t, payload("prod-eu-1", now), pair.sign)
+			},
+			previous: payload("prod-eu-1", now.Add(-time.Hour)),
+			want:     payload("prod-eu-1", now),
+		}, {
+			name: "issued within the skew",
+			envelope: func(t *testing.T) *Envelope {
+				return envelope(t, payload("prod-eu-1", now.Add(MaxIssuedAtSkew)), pair.sign)
+			},
+			want: payload("prod-eu-1", now.Add(MaxIssuedAtSkew)),
+		}, {
+			name: "tampered payload",
+			envelope: func(t *testing.T) *Envelope {
+				e := envelope(t, payload("prod-eu-1", now), pair.sign)
+				tampered := envelope(t, payload("prod-eu-1", now.Add(time.Minute)), pair.sign)
+				e.Payload = tampered.Payload
+				return e
+			},
+			wantErr: "invalid",
+		}, {
+			name: "signed with another key",
+			envelope: func(t *testing.T) *Envelope {
+				return envelope(t, payload("prod-eu-1", now), other.sign)
+			},
+			wantErr: "invalid",
+		}, {
+			name: "verified with another key type",
+			envelope: func(t *testing.T) *Envelope {
+				return envelope(t, payload("prod-eu-1", now), pair.sign)
+			},
+			key:     other.public,
+			wantErr: "invalid",
+		}, {
+			name: "malformed signature",
+			envelope: func(t *testing.T) *Envelope {
+				e := envelope(t, payload("prod-eu-1", now), pair.sign)
+				e.Signature = "not base64"
+				return e
+			},
+			wantErr: "decode signature",
+		}, {
+			name: "issued for another cluster",
+			envelope: func(t *testing.T) *Envelope {
+				return envelope(t, payload("prod-us-1", now), pair.sign)
+			},
+			wantErr: `issued for cluster "prod-us-1", not "prod-eu-1"`,
+		}, {
+			name: "issued for no cluster",
+			envelope: func(t *testing.T) *Envelope {
+				return envelope(t, payload("", now), pair.sign)
+			},
+			wantErr: `issued for cluster "", not "prod-eu-1"`,
+		}, {
+			name: "issued before the previous one",
+			envelope: func(t *testing.T) *Envelope {
+				return envelope(t, payload("prod-eu-1", now.Add(-time.Second)), pair.sign)
+			},
+			previous: payload("prod-eu-1", now),
+			wantErr:  "predates the one in effect",
+		}, {
+			name: "issued in the future",
+			envelope: func(t *testing.T) *Envelope {
+				return envelope(t, payload("prod-eu-1", now.Add(MaxIssuedAtSkew+time.Second)), pair.sign)
+			},
+			wantErr: "in the future",
+		}, {
+			name: "issued at no time",
+			envelope: func(t *testing.T) *Envelope {
+				return envelope(t, payload("prod-eu-1", time.Time{}), pair.sign)
+			},
+			wantErr: "no issue time",
+		}}
+
+		for _, test := range tests {
+			t.Run(pair.name+"/"+test.name, func(t *testing.T) {
+				key := test.key
+				if key == nil {
+					key = pair.public
+				}
+				got, err := test.envelope(t).Verify(key, "prod-eu-1", test.previous, now)
+				if test.wantErr != "" {
+					if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+						t.Fatalf("Verify() = %v, want error containing %q", err, test.wantErr)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("Verify() = %v", err)
+				}
+				if !got.IssuedAt.Equal(test.want.IssuedAt) || got.Audience != test.want.Audience ||
+					!reflect.DeepEqual(got.ConfigMaps, test.want.ConfigMaps) {
+					t.Errorf("Verify() = %+v, want %+v", got, test.want)
+				}
+			})
+		}
+	}
+}
+
+func TestVerifyUnsupportedKey(t *testing.T) {
+	e := envelope(t, payload("prod-eu-1", now), func(*testing.T, []byte) []byte { return []byte("sig") })
+	if _, err := e.Verify("not a key", "prod-eu-1", nil, now); err == nil || !strings.Contains(err.Error(), "unsupported public key type") {
+		t.Errorf("Verify() = %v, want unsupported public key type", err)
+	}
+}
+
+func TestReadPublicKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "agent")
+	if err != nil {
+		t.Fatalf("TempDir() = %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, pair := range keyPairs(t) {
+		t.Run(pair.name, func(t *testing.T) {
+			der, err := x509.MarshalPKIXPublicKey(pair.public)
+			if err != nil {
+				t.Fatalf("MarshalPKIXPublicKey() = %v", err)
+			}
+			path := filepath.Join(dir, pair.name+".pem")
+			if err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0600); err != nil {
+				t.Fatalf("WriteFile() = %v", err)
+			}
+			got, err := ReadPublicKey(path)
+			if err != nil {
+				t.Fatalf("ReadPublicKey() = %v", err)
+			}
+			if !reflect.DeepEqual(got, pair.public) {
+				t.Errorf("ReadPublicKey() = %v, want %v", got, pair.public)
+			}
+		})
+	}
+
+	path := filepath.Join(dir, "empty.pem")
+	if err := ioutil.WriteFile(path, nil, 0600); err != nil {
+		t.Fatalf("WriteFile() = %v", err)
+	}
+	if _, err := ReadPublicKey(path); err == nil {
+		t.Error("ReadPublicKey() = nil, want error for a file without PEM block")
+	}
+}
@@ -0,0 +1,258 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package apicall binds the API calls of the reconcilers to the reconcile
+// context, so a stuck call is cancelled with the reconcile or after a
+// per-call timeout instead of wedging a worker indefinitely. The typed
+// clientsets of this Kubernetes version take no context, the calls go
+// through their REST clients instead.
+package apicall
+
+import (
+	"context"
+	"time"
+
+	coordinationv1beta1 "k8s.io/api/coordination/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	autoscalingv1alpha1 "knative.dev/serving/pkg/apis/autoscaling/v1alpha1"
+	networkingv1alpha1 "knative.dev/serving/pkg/apis/networking/v1alpha1"
+	"knative.dev/serving/pkg/apis/serving/v1alpha1"
+	versioned "knative.dev/serving/pkg/client/clientset/versioned"
+)
+
+type timeoutKey struct{}
+
+// WithTimeout returns a context whose API calls time out after d each. Zero
+// leaves them bound to the deadline of ctx only.
+func WithTimeout(ctx context.Context, d time.Duration) context.Context {
+	return context.WithValue(ctx, timeoutKey{}, d)
+}
+
+// Timeout returns the per-call timeout of ctx, zero when there is none.
+func Timeout(ctx context.Context) time.Duration {
+	d, _ := ctx.Value(timeoutKey{}).(time.Duration)
+	return d
+}
+
+// Request binds req to ctx and its per-call timeout.
+func Request(ctx context.Context, req *rest.Request) *rest.Request {
+	req = req.Context(ctx)
+	if d := Timeout(ctx); d > 0 {
+		req = req.Timeout(d)
+	}
+	return req
+}
+
+// GetRevision reads the named revision from the API server.
+func GetRevision(ctx context.Context, client versioned.Interface, namespace, name string) (*v1alpha1.Revision, error) {
+	result := &v1alpha1.Revision{}
+	err := Request(ctx, client.ServingV1alpha1().RESTClient().Get()).
+		Namespace(namespace).Resource("revisions").Name(name).
+		Do().Into(result)
+	return result, err
+}
+
+// ListRevisions lists the revisions matching selector from the API server.
+func ListRevisions(ctx context.Context, client versioned.Interface, namespace string, selector labels.Selector) (*v1alpha1.RevisionList, error) {
+	result := &v1alpha1.RevisionList{}
+	err := Request(ctx, client.ServingV1alpha1().RESTClient().Get()).
+		Namespace(namespace).Resource("revisions").Param("labelSelector", selector.String()).
+		Do().Into(result)
+	return result, err
+}
+
+// DeleteRevision deletes the named revision.
+func DeleteRevision(ctx context.Context, client versioned.Interface, namespace, name string, options *metav1.DeleteOptions) error {
+	return Request(ctx, client.ServingV1alpha1().RESTClient().Delete()).
+		Namespace(namespace).Resource("revisions").Name(name).Body(options).
+		Do().Error()
+}
+
+// PatchRevision patches the named revision and returns the patched revision.
+func PatchRevision(ctx context.Context, client versioned.Interface, namespace, name string, pt types.PatchType, data []byte) (*v1alpha1.Revision, error) {
+	result := &v1alpha1.Revision{}
+	err := Request(ctx, client.ServingV1alpha1().RESTClient().Patch(pt)).
+		Namespace(namespace).Resource("revisions").Name(name).Body(data).
+		Do().Into(result)
+	return result, err
+}
+
+// GetService reads the named Service from the API server.
+func GetService(ctx context.Context, client versioned.Interface, namespace, name string) (*v1alpha1.Service, error) {
+	result := &v1alpha1.Service{}
+	err := Request(ctx, client.ServingV1alpha1().RESTClient().Get()).
+		Namespace(namespace).Resource("services").Name(name).
+		Do().Into(result)
+	return result, err
+}
+
+// PatchService patches the named Service and returns the patched Service.
+func PatchService(ctx context.Context, client versioned.Interface, namespace, name string, pt types.PatchType, data []byte) (*v1alpha1.Service, error) {
+	result := &v1alpha1.Service{}
+	err := Request(ctx, client.ServingV1alpha1().RESTClient().Patch(pt)).
+		Namespace(namespace).Resource("services").Name(name).Body(data).
+		Do().Into(result)
+	return result, err
+}
+
+// PatchConfiguration patches the named Configuration and returns the patched
+// Configuration.
+func PatchConfiguration(ctx context.Context, client versioned.Interface, namespace, name string, pt types.PatchType, data []byte) (*v1alpha1.Configuration, error) {
+	result := &v1alpha1.Configuration{}
+	err := Request(ctx, client.ServingV1alpha1().RESTClient().Patch(pt)).
+		Namespace(namespace).Resource("configurations").Name(name).Body(data).
+		Do().Into(result)
+	return result, err
+}
+
+// PatchPodAutoscaler patches the named PodAutoscaler and returns the
+// patched PodAutoscaler.
+func PatchPodAutoscaler(ctx context.Context, client versioned.Interface, namespace, name string, pt types.PatchType, data []byte) (*autoscalingv1alpha1.PodAutoscaler, error) {
+	result := &autoscalingv1alpha1.PodAutoscaler{}
+	err := Request(ctx, client.AutoscalingV1alpha1().RESTClient().Patch(pt)).
+		Namespace(namespace).Resource("podautoscalers").Name(name).Body(data).
+		Do().Into(result)
+	return result, err
+}
+
+// ListIngresses lists the networking Ingresses matching selector.
+func ListIngresses(ctx context.Context, client versioned.Interface, namespace string, selector labels.Selector) (*networkingv1alpha1.IngressList, error) {
+	result := &networkingv1alpha1.IngressList{}
+	err := Request(ctx, client.NetworkingV1alpha1().RESTClient().Get()).
+		Namespace(namespace).Resource("ingresses").Param("labelSelector", selector.String()).
+		Do().Into(result)
+	return result, err
+}
+
+// ListClusterIngresses lists the ClusterIngresses matching selector.
+func ListClusterIngresses(ctx context.Context, client versioned.Interface, selector labels.Selector) (*networkingv1alpha1.ClusterIngressList, error) {
+	result := &networkingv1alpha1.ClusterIngressList{}
+	err := Request(ctx, client.NetworkingV1alpha1().RESTClient().Get()).
+		Resource("clusteringresses").Param("labelSelector", selector.String()).
+		Do().Into(result)
+	return result, err
+}
+
+// ListPods lists the pods matching selector.
+func ListPods(ctx context.Context, client kubernetes.Interface, namespace string, selector labels.Selector) (*corev1.PodList, error) {
+	result := &corev1.PodList{}
+	err := Request(ctx, client.CoreV1().RESTClient().Get()).
+		Namespace(namespace).Resource("pods").Param("labelSelector", selector.String()).
+		Do().Into(result)
+	return result, err
+}
+
+// ListPersistentVolumeClaims lists the PersistentVolumeClaims matching
+// selector.
+func ListPersistentVolumeClaims(ctx context.Context, client kubernetes.Interface, namespace string, selector labels.Selector) (*corev1.PersistentVolumeClaimList, error) {
+	result := &corev1.PersistentVolumeClaimList{}
+	err := Request(ctx, client.CoreV1().RESTClient().Get()).
+		Namespace(namespace).Resource("persistentvolumeclaims").Param("labelSelector", selector.String()).
+		Do().Into(result)
+	return result, err
+}
+
+// ListSecrets lists the Secrets of the namespace.
+func ListSecrets(ctx context.Context, client kubernetes.Interface, namespace string) (*corev1.SecretList, error) {
+	result := &corev1.SecretList{}
+	err := Request(ctx, client.CoreV1().RESTClient().Get()).
+		Namespace(namespace).Resource("secrets").
+		Do().Into(result)
+	return result, err
+}
+
+// ListConfigMaps lists the ConfigMaps of the namespace.
+func ListConfigMaps(ctx context.Context, client kubernetes.Interface, namespace string) (*corev1.ConfigMapList, error) {
+	result := &corev1.ConfigMapList{}
+	err := Request(ctx, client.CoreV1().RESTClient().Get()).
+		Namespace(namespace).Resource("configmaps").
+		Do().Into(result)
+	return result, err
+}
+
+// GetConfigMap reads the named ConfigMap.
+func GetConfigMap(ctx context.Context, client kubernetes.Interface, namespace, name string) (*corev1.ConfigMap, error) {
+	result := &corev1.ConfigMap{}
+	err := Request(ctx, client.CoreV1().RESTClient().Get()).
+		Namespace(namespace).Resource("configmaps").Name(name).
+		Do().Into(result)
+	return result, err
+}
+
+// CreateConfigMap creates the ConfigMap.
+func CreateConfigMap(ctx context.Context, client kubernetes.Interface, cm *corev1.ConfigMap) (*corev1.ConfigMap, error) {
+	result := &corev1.ConfigMap{}
+	err := Request(ctx, client.CoreV1().RESTClient().Post()).
+		Namespace(cm.Namespace).Resource("configmaps").Body(cm).
+		Do().Into(result)
+	return result, err
+}
+
+// UpdateConfigMap updates the ConfigMap.
+func UpdateConfigMap(ctx context.Context, client kubernetes.Interface, cm *corev1.ConfigMap) (*corev1.ConfigMap, error) {
+	result := &corev1.ConfigMap{}
+	err := Request(ctx, client.CoreV1().RESTClient().Put()).
+		Namespace(cm.Namespace).Resource("configmaps").Name(cm.Name).Body(cm).
+		Do().Into(result)
+	return result, err
+}
+
+// Delete deletes the named object of the core resource, e.g. "secrets".
+func Delete(ctx context.Context, client kubernetes.Interface, resource, namespace, name string, options *metav1.DeleteOptions) error {
+	return Request(ctx, client.CoreV1().RESTClient().Delete()).
+		Namespace(namespace).Resource(resource).Name(name).Body(options).
+		Do().Error()
+}
+
+// ListLeases lists the Leases matching selector.
+func ListLeases(ctx context.Context, client kubernetes.Interface, namespace string, selector labels.Selector) (*coordinationv1beta1.LeaseList, error) {
+	result := &coordinationv1beta1.LeaseList{}
+	err := Request(ctx, client.CoordinationV1beta1().RESTClient().Get()).
+		Namespace(namespace).Resource("leases").Param("labelSelector", selector.String()).
+		Do().Into(result)
+	return result, err
+}
+
+// CreateLease creates the Lease.
+func CreateLease(ctx context.Context, client kubernetes.Interface, lease *coordinationv1beta1.Lease) (*coordinationv1beta1.Lease, error) {
+	result := &coordinationv1beta1.Lease{}
+	err := Request(ctx, client.CoordinationV1beta1().RESTClient().Post()).
+		Namespace(lease.Namespace).Resource("leases").Body(lease).
+		Do().Into(result)
+	return result, err
+}
+
+// UpdateLease updates the Lease. It fails with a conflict when the Lease
+// changed since it was read.
+func UpdateLease(ctx context.Context, client kubernetes.Interface, lease *coordinationv1beta1.Lease) (*coordinationv1beta1.Lease, error) {
+	result := &coordinationv1beta1.Lease{}
+	err := Request(ctx, client.CoordinationV1beta1().RESTClient().Put()).
+		Namespace(lease.Namespace).Resource("leases").Name(lease.Name).Body(lease).
+		Do().Into(result)
+	return result, err
+}
+
+// DeleteLease deletes the named Lease.
+func DeleteLease(ctx context.Context, client kubernetes.Interface, namespace, name string) error {
+	return Request(ctx, client.CoordinationV1beta1().RESTClient().Delete()).
+		Namespace(namespace).Resource("leases").Name(name).
+		Do().Error()
+}
@@ -0,0 +1,38 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package v1alpha1 holds the config.gc.knative.dev/v1alpha1 types, a typed
+// and observable view of the configuration the revision-controller runs with.
+// The configuration itself stays in the ConfigMaps.
+package v1alpha1
+
+import (
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	// GroupName is the API group of the configuration types.
+	GroupName = "config.gc.knative.dev"
+
+	// RevisionGCConfigsResource is the resource name of RevisionGCConfig.
+	RevisionGCConfigsResource = "revisiongcconfigs"
+
+	// RevisionGCConfigName is the name of the singleton RevisionGCConfig.
+	RevisionGCConfigName = "cluster"
+)
+
+// SchemeGroupVersion is the group version of the types in this package.
+var SchemeGroupVersion = schema.GroupVersion{Group: GroupName, Version: "v1alpha1"}
@@ -0,0 +1,79 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"knative.dev/pkg/apis"
+	duckv1beta1 "knative.dev/pkg/apis/duck/v1beta1"
+)
+
+// RevisionGCConfig reports the configuration the controller runs with. The
+// controller creates the cluster scoped singleton named "cluster" and keeps
+// its status up to date whenever it reloads a configuration ConfigMap.
+type RevisionGCConfig struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Status RevisionGCConfigStatus `json:"status,omitempty"`
+}
+
+const (
+	// ConditionReady is True while every configuration ConfigMap in effect
+	// is the latest version.
+	ConditionReady = apis.ConditionReady
+
+	// ConditionGCConfigValid is False while the latest version of the GC
+	// ConfigMap is rejected and an earlier one stays in effect.
+	ConditionGCConfigValid apis.ConditionType = "GCConfigValid"
+
+	// ConditionNotificationsConfigValid is False while the latest version of
+	// the notifications ConfigMap is rejected.
+	ConditionNotificationsConfigValid apis.ConditionType = "NotificationsConfigValid"
+)
+
+// RevisionGCConfigStatus is the configuration in effect.
+type RevisionGCConfigStatus struct {
+	duckv1beta1.Status `json:",inline"`
+
+	// ConfigMaps describe the configuration ConfigMaps in effect.
+	ConfigMaps []ConfigMapStatus `json:"configMaps,omitempty"`
+}
+
+// ConfigMapStatus describes the version of a configuration ConfigMap in
+// effect and why a later version was rejected.
+type ConfigMapStatus struct {
+	// Name is the name of the ConfigMap in the system namespace.
+	Name string `json:"name"`
+
+	// ResourceVersion is the version of the ConfigMap in effect.
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+
+	// Data is the data of the ConfigMap in effect. Keys it does not set
+	// take their defaults.
+	Data map[string]string `json:"data,omitempty"`
+
+	// LastReloadTime is the time the version in effect was loaded.
+	LastReloadTime metav1.Time `json:"lastReloadTime,omitempty"`
+
+	// RejectedResourceVersion is the latest version of the ConfigMap when it
+	// was rejected.
+	RejectedResourceVersion string `json:"rejectedResourceVersion,omitempty"`
+
+	// Error is why RejectedResourceVersion was rejected.
+	Error string `json:"error,omitempty"`
+}
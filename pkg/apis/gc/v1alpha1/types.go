@@ -0,0 +1,76 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// RevisionGCPlan is the garbage collection plan of the Service with the same
+// namespace and name, computed when it is read.
+type RevisionGCPlan struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Status RevisionGCPlanStatus `json:"status,omitempty"`
+}
+
+// RevisionGCPlanStatus is the outcome of evaluating the revisions of a
+// Service against the policy.
+type RevisionGCPlanStatus struct {
+	// Policy is the name of the policy the Service was evaluated against.
+	Policy string `json:"policy"`
+
+	// SkipReason is set when the Service was not evaluated at all.
+	SkipReason string `json:"skipReason,omitempty"`
+
+	// RoutedRevision is the revision receiving the traffic.
+	RoutedRevision string `json:"routedRevision,omitempty"`
+
+	// Retained are the revisions that are kept.
+	Retained []RevisionDecision `json:"retained,omitempty"`
+
+	// Candidates are the revisions that would be deleted.
+	Candidates []RevisionDecision `json:"candidates,omitempty"`
+
+	// EstimatedReclaim describes the resources held by the candidates.
+	EstimatedReclaim string `json:"estimatedReclaim,omitempty"`
+
+	// EvaluatedAt is the time the plan was computed.
+	EvaluatedAt metav1.Time `json:"evaluatedAt"`
+}
+
+// RevisionDecision is the decision taken for a single revision.
+type RevisionDecision struct {
+	Name       string `json:"name"`
+	Generation int    `json:"generation,omitempty"`
+	Reason     string `json:"reason"`
+	Message    string `json:"message,omitempty"`
+
+	// EligibleAt is the earliest time the revision is a deletion candidate
+	// under the policy. It is unset while rules that do not end by
+	// themselves keep the revision.
+	EligibleAt *metav1.Time `json:"eligibleAt,omitempty"`
+}
+
+// RevisionGCPlanList is a list of RevisionGCPlan.
+type RevisionGCPlanList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []RevisionGCPlan `json:"items"`
+}
@@ -0,0 +1,165 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package apiserver
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/knative-sample/revision-controller/pkg/auth"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/cert"
+)
+
+const (
+	// authenticationConfigMapNamespace and authenticationConfigMapName locate
+	// the ConfigMap the Kubernetes API server publishes its front proxy
+	// client CA and request header names in.
+	authenticationConfigMapNamespace = metav1.NamespaceSystem
+	authenticationConfigMapName      = "extension-apiserver-authentication"
+)
+
+// requestHeaderAuth authenticates requests proxied by the Kubernetes API
+// server, which passes the user in request headers.
+type requestHeaderAuth struct {
+	clientCAs       *x509.CertPool
+	allowedNames    []string
+	usernameHeaders []string
+	groupHeaders    []string
+	extraPrefixes   []string
+}
+
+// loadRequestHeaderAuth reads the front proxy settings of the Kubernetes API
+// server.
+func loadRequestHeaderAuth(kubeClient kubernetes.Interface) (*requestHeaderAuth, error) {
+	cm, err := kubeClient.CoreV1().ConfigMaps(authenticationConfigMapNamespace).Get(authenticationConfigMapName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("read %s/%s: %v", authenticationConfigMapNamespace, authenticationConfigMapName, err)
+	}
+
+	raw, ok := cm.Data["requestheader-client-ca-file"]
+	if !ok || raw == "" {
+		return nil, fmt.Errorf("%s/%s has no requestheader-client-ca-file, the API server front proxy is not configured", authenticationConfigMapNamespace, authenticationConfigMapName)
+	}
+	certs, err := cert.ParseCertsPEM([]byte(raw))
+	if err != nil {
+		return nil, fmt.Errorf("parse requestheader-client-ca-file: %v", err)
+	}
+	pool := x509.NewCertPool()
+	for _, c := range certs {
+		pool.AddCert(c)
+	}
+
+	auth := &requestHeaderAuth{clientCAs: pool}
+	for _, list := range []struct {
+		key      string
+		field    *[]string
+		fallback []string
+	}{
+		{"requestheader-allowed-names", &auth.allowedNames, nil},
+		{"requestheader-username-headers", &auth.usernameHeaders, []string{"X-Remote-User"}},
+		{"requestheader-group-headers", &auth.groupHeaders, []string{"X-Remote-Group"}},
+		{"requestheader-extra-headers-prefix", &auth.extraPrefixes, []string{"X-Remote-Extra-"}},
+	} {
+		*list.field = list.fallback
+		raw, ok := cm.Data[list.key]
+		if !ok || raw == "" {
+			continue
+		}
+		var values []string
+		if err := json.Unmarshal([]byte(raw), &values); err != nil {
+			return nil, fmt.Errorf("parse %s: %v", list.key, err)
+		}
+		if len(values) > 0 || list.fallback == nil {
+			*list.field = values
+		}
+	}
+	return auth, nil
+}
+
+// authenticate returns the user of a request proxied by the Kubernetes API
+// server, whose client certificate must be signed by the front proxy CA.
+func (a *requestHeaderAuth) authenticate(r *http.Request) (*auth.User, error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return nil, errors.New("no verified front proxy client certificate")
+	}
+	if len(a.allowedNames) > 0 {
+		cn := r.TLS.VerifiedChains[0][0].Subject.CommonName
+		allowed := false
+		for _, name := range a.allowedNames {
+			if name == cn {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return nil, fmt.Errorf("front proxy client certificate %q is not allowed", cn)
+		}
+	}
+
+	user := &auth.User{}
+	for _, h := range a.usernameHeaders {
+		if user.Name = r.Header.Get(h); user.Name != "" {
+			break
+		}
+	}
+	if user.Name == "" {
+		return nil, errors.New("no user in the request headers")
+	}
+	for _, h := range a.groupHeaders {
+		user.Groups = append(user.Groups, r.Header[http.CanonicalHeaderKey(h)]...)
+	}
+	for key, values := range r.Header {
+		for _, prefix := range a.extraPrefixes {
+			if !strings.HasPrefix(strings.ToLower(key), strings.ToLower(prefix)) {
+				continue
+			}
+			if user.Extra == nil {
+				user.Extra = map[string]authorizationv1.ExtraValue{}
+			}
+			extra := strings.ToLower(key[len(prefix):])
+			user.Extra[extra] = append(user.Extra[extra], values...)
+		}
+	}
+	return user, nil
+}
+
+// selfSignedCertificate generates a self signed serving certificate. The
+// APIService must then skip the TLS verification of the server.
+func selfSignedCertificate() (*tls.Certificate, error) {
+	host, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	certPEM, keyPEM, err := cert.GenerateSelfSignedCertKey(host, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("generate serving certificate: %v", err)
+	}
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	return &pair, nil
+}
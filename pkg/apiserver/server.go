@@ -0,0 +1,279 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package apiserver serves the gc.knative.dev aggregated API. The Kubernetes
+// API server authenticates the callers and proxies their requests; this
+// server verifies the proxy client certificate, authorizes the proxied user
+// with a SubjectAccessReview and computes the requested plans on demand.
+package apiserver
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	gcv1alpha1 "github.com/knative-sample/revision-controller/pkg/apis/gc/v1alpha1"
+	"github.com/knative-sample/revision-controller/pkg/auth"
+	"github.com/knative-sample/revision-controller/pkg/listener"
+	"go.uber.org/zap"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+	"knative.dev/pkg/system"
+)
+
+// PlanGetter computes revision garbage collection plans.
+type PlanGetter interface {
+	// Get returns the plan of the named Service.
+	Get(ctx context.Context, namespace, name string) (*gcv1alpha1.RevisionGCPlan, error)
+
+	// List returns the plans of the Services in the namespace, or of all
+	// Services when namespace is empty.
+	List(ctx context.Context, namespace string) (*gcv1alpha1.RevisionGCPlanList, error)
+}
+
+// Server serves the gc.knative.dev aggregated API.
+type Server struct {
+	logger     *zap.SugaredLogger
+	plans      PlanGetter
+	kubeClient kubernetes.Interface
+	headerAuth *requestHeaderAuth
+}
+
+// New returns a Server computing plans with plans and authorizing requests
+// against the Kubernetes API server of kubeClient.
+func New(logger *zap.SugaredLogger, plans PlanGetter, kubeClient kubernetes.Interface) *Server {
+	return &Server{
+		logger:     logger,
+		plans:      plans,
+		kubeClient: kubeClient,
+	}
+}
+
+// Run serves the API over TLS until ctx is done. A self signed serving
+// certificate is generated when the options configure none.
+func (s *Server) Run(ctx context.Context, options listener.Options) error {
+	headerAuth, err := loadRequestHeaderAuth(s.kubeClient)
+	if err != nil {
+		return err
+	}
+	s.headerAuth = headerAuth
+
+	cert, _, err := options.Certificate(s.kubeClient, system.Namespace())
+	if err != nil {
+		return err
+	}
+	if cert == nil {
+		if cert, err = selfSignedCertificate(); err != nil {
+			return err
+		}
+	}
+
+	l, err := options.Listen()
+	if err != nil {
+		return err
+	}
+	server := &http.Server{
+		Handler: s,
+		TLSConfig: &tls.Config{
+			Certificates: []tls.Certificate{*cert},
+			ClientAuth:   tls.VerifyClientCertIfGiven,
+			ClientCAs:    headerAuth.clientCAs,
+			MinVersion:   tls.VersionTLS12,
+		},
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- server.ServeTLS(l, "", "")
+	}()
+	s.logger.Infof("Serving %s on %s", gcv1alpha1.SchemeGroupVersion, l.Addr())
+
+	select {
+	case <-ctx.Done():
+		return server.Shutdown(context.Background())
+	case err := <-errCh:
+		return err
+	}
+}
+
+const (
+	groupPath   = "/apis/" + gcv1alpha1.GroupName
+	versionPath = groupPath + "/v1alpha1"
+)
+
+// ServeHTTP implements http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/healthz" {
+		w.Write([]byte("ok"))
+		return
+	}
+
+	user, err := s.headerAuth.authenticate(r)
+	if err != nil {
+		s.writeError(w, apierrs.NewUnauthorized(err.Error()))
+		return
+	}
+	if r.Method != http.MethodGet {
+		s.writeError(w, apierrs.NewMethodNotSupported(s.resource(), strings.ToLower(r.Method)))
+		return
+	}
+
+	switch r.URL.Path {
+	case "/apis":
+		s.writeJSON(w, http.StatusOK, &metav1.APIGroupList{
+			TypeMeta: metav1.TypeMeta{Kind: "APIGroupList", APIVersion: "v1"},
+			Groups:   []metav1.APIGroup{apiGroup()},
+		})
+		return
+	case groupPath:
+		group := apiGroup()
+		group.TypeMeta = metav1.TypeMeta{Kind: "APIGroup", APIVersion: "v1"}
+		s.writeJSON(w, http.StatusOK, &group)
+		return
+	case versionPath:
+		s.writeJSON(w, http.StatusOK, &metav1.APIResourceList{
+			TypeMeta:     metav1.TypeMeta{Kind: "APIResourceList", APIVersion: "v1"},
+			GroupVersion: gcv1alpha1.SchemeGroupVersion.String(),
+			APIResources: []metav1.APIResource{{
+				Name:       gcv1alpha1.RevisionGCPlansResource,
+				Namespaced: true,
+				Kind:       "RevisionGCPlan",
+				Verbs:      metav1.Verbs{"get", "list"},
+				ShortNames: []string{"gcplan"},
+			}},
+		})
+		return
+	}
+
+	namespace, name, ok := parseResourcePath(r.URL.Path)
+	if !ok {
+		s.writeError(w, apierrs.NewNotFound(schema.GroupResource{}, r.URL.Path))
+		return
+	}
+	if r.URL.Query().Get("watch") == "true" || r.URL.Query().Get("watch") == "1" {
+		s.writeError(w, apierrs.NewMethodNotSupported(s.resource(), "watch"))
+		return
+	}
+	verb := "get"
+	if name == "" {
+		verb = "list"
+	}
+	if err := s.authorize(user, verb, namespace, name); err != nil {
+		s.writeError(w, err)
+		return
+	}
+
+	ctx := r.Context()
+	if name == "" {
+		list, err := s.plans.List(ctx, namespace)
+		if err != nil {
+			s.writeError(w, apierrs.NewInternalError(err))
+			return
+		}
+		s.writeJSON(w, http.StatusOK, list)
+		return
+	}
+
+	p, err := s.plans.Get(ctx, namespace, name)
+	if apierrs.IsNotFound(err) {
+		s.writeError(w, apierrs.NewNotFound(s.resource(), name))
+		return
+	} else if err != nil {
+		s.writeError(w, apierrs.NewInternalError(err))
+		return
+	}
+	s.writeJSON(w, http.StatusOK, p)
+}
+
+// parseResourcePath extracts the namespace and name from the paths
+//
+//	/apis/gc.knative.dev/v1alpha1/revisiongcplans
+//	/apis/gc.knative.dev/v1alpha1/namespaces/{namespace}/revisiongcplans
+//	/apis/gc.knative.dev/v1alpha1/namespaces/{namespace}/revisiongcplans/{name}
+func parseResourcePath(path string) (namespace, name string, ok bool) {
+	if !strings.HasPrefix(path, versionPath+"/") {
+		return "", "", false
+	}
+	parts := strings.Split(strings.TrimPrefix(path, versionPath+"/"), "/")
+	switch {
+	case len(parts) == 1 && parts[0] == gcv1alpha1.RevisionGCPlansResource:
+		return "", "", true
+	case len(parts) == 3 && parts[0] == "namespaces" && parts[1] != "" && parts[2] == gcv1alpha1.RevisionGCPlansResource:
+		return parts[1], "", true
+	case len(parts) == 4 && parts[0] == "namespaces" && parts[1] != "" && parts[2] == gcv1alpha1.RevisionGCPlansResource && parts[3] != "":
+		return parts[1], parts[3], true
+	}
+	return "", "", false
+}
+
+func apiGroup() metav1.APIGroup {
+	version := metav1.GroupVersionForDiscovery{
+		GroupVersion: gcv1alpha1.SchemeGroupVersion.String(),
+		Version:      gcv1alpha1.SchemeGroupVersion.Version,
+	}
+	return metav1.APIGroup{
+		Name:             gcv1alpha1.GroupName,
+		Versions:         []metav1.GroupVersionForDiscovery{version},
+		PreferredVersion: version,
+	}
+}
+
+func (s *Server) resource() schema.GroupResource {
+	return gcv1alpha1.SchemeGroupVersion.WithResource(gcv1alpha1.RevisionGCPlansResource).GroupResource()
+}
+
+// authorize checks with a SubjectAccessReview that the user may run verb on
+// the plans.
+func (s *Server) authorize(user *auth.User, verb, namespace, name string) *apierrs.StatusError {
+	allowed, reason, err := auth.Authorize(s.kubeClient, user, &authorizationv1.ResourceAttributes{
+		Namespace: namespace,
+		Verb:      verb,
+		Group:     gcv1alpha1.GroupName,
+		Version:   gcv1alpha1.SchemeGroupVersion.Version,
+		Resource:  gcv1alpha1.RevisionGCPlansResource,
+		Name:      name,
+	})
+	if err != nil {
+		return apierrs.NewInternalError(err)
+	}
+	if !allowed {
+		return apierrs.NewForbidden(s.resource(), name, fmt.Errorf("user %q cannot %s %s: %s", user.Name, verb, gcv1alpha1.RevisionGCPlansResource, reason))
+	}
+	return nil
+}
+
+func (s *Server) writeError(w http.ResponseWriter, err *apierrs.StatusError) {
+	status := err.Status()
+	status.TypeMeta = metav1.TypeMeta{Kind: "Status", APIVersion: "v1"}
+	if status.Code >= http.StatusInternalServerError {
+		s.logger.Errorf("apiserver error: %s", status.Message)
+	}
+	s.writeJSON(w, int(status.Code), &status)
+}
+
+func (s *Server) writeJSON(w http.ResponseWriter, code int, obj interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(obj); err != nil {
+		s.logger.Errorf("apiserver encode response error: %s", err.Error())
+	}
+}
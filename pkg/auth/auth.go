@@ -0,0 +1,102 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package auth authenticates and authorizes the callers of the embedded
+// servers against the Kubernetes API server with TokenReviews and
+// SubjectAccessReviews, so access is governed by the cluster RBAC rules.
+package auth
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// User is an authenticated caller.
+type User struct {
+	Name   string
+	Groups []string
+	Extra  map[string]authorizationv1.ExtraValue
+}
+
+// FromCertificate returns the user of a verified client certificate: the
+// common name is the user name and the organizations are its groups, as for
+// Kubernetes client certificates.
+func FromCertificate(cert *x509.Certificate) *User {
+	return &User{
+		Name:   cert.Subject.CommonName,
+		Groups: cert.Subject.Organization,
+	}
+}
+
+// BearerToken returns the bearer token of the request, empty if it has none.
+func BearerToken(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+}
+
+// ReviewToken authenticates a bearer token with a TokenReview.
+func ReviewToken(kubeClient kubernetes.Interface, token string) (*User, error) {
+	review, err := kubeClient.AuthenticationV1().TokenReviews().Create(&authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{Token: token},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("review token: %v", err)
+	}
+	if !review.Status.Authenticated {
+		if review.Status.Error != "" {
+			return nil, fmt.Errorf("invalid token: %s", review.Status.Error)
+		}
+		return nil, errors.New("invalid token")
+	}
+	user := &User{
+		Name:   review.Status.User.Username,
+		Groups: review.Status.User.Groups,
+	}
+	for key, values := range review.Status.User.Extra {
+		if user.Extra == nil {
+			user.Extra = map[string]authorizationv1.ExtraValue{}
+		}
+		user.Extra[key] = authorizationv1.ExtraValue(values)
+	}
+	return user, nil
+}
+
+// Authorize checks with a SubjectAccessReview that the user may act on the
+// resource. It returns the reason when access is denied.
+func Authorize(kubeClient kubernetes.Interface, user *User, attributes *authorizationv1.ResourceAttributes) (bool, string, error) {
+	review, err := kubeClient.AuthorizationV1().SubjectAccessReviews().Create(&authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			User:               user.Name,
+			Groups:             user.Groups,
+			Extra:              user.Extra,
+			ResourceAttributes: attributes,
+		},
+	})
+	if err != nil {
+		return false, "", fmt.Errorf("authorize request: %v", err)
+	}
+	return review.Status.Allowed, review.Status.Reason, nil
+}
@@ -0,0 +1,125 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package backlog tracks the backlog of stale revisions: the deletion
+// candidates the last evaluation of every Service found. Operators, or the
+// controller itself, adapt how often every Service is evaluated again to
+// it: often while the backlog is high, rarely once the Services are clean.
+package backlog
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Backlog is the backlog of stale revisions across the evaluated Services.
+type Backlog struct {
+	// Services is the number of Services evaluated.
+	Services int `json:"services"`
+	// Stale is the number of Services with deletion candidates.
+	Stale int `json:"stale"`
+	// Candidates is the number of deletion candidates.
+	Candidates int `json:"candidates"`
+	// Average is the number of deletion candidates per Service.
+	Average float64 `json:"average"`
+	// ResyncInterval is the interval every Service is evaluated again at,
+	// empty unless the adaptive resync is enabled.
+	ResyncInterval string `json:"resyncInterval,omitempty"`
+}
+
+// Tracker tracks the deletion candidates per Service key.
+type Tracker struct {
+	mu       sync.Mutex
+	byKey    map[string]int
+	interval time.Duration
+}
+
+// Default is the Tracker of the planners created without one.
+var Default = New()
+
+type trackerKey struct{}
+
+// WithTracker attaches the Tracker the planner records to to ctx.
+func WithTracker(ctx context.Context, t *Tracker) context.Context {
+	return context.WithValue(ctx, trackerKey{}, t)
+}
+
+// FromContext returns the Tracker attached to ctx, Default when none is.
+func FromContext(ctx context.Context) *Tracker {
+	if t, ok := ctx.Value(trackerKey{}).(*Tracker); ok {
+		return t
+	}
+	return Default
+}
+
+// New returns an empty Tracker.
+func New() *Tracker {
+	return &Tracker{byKey: make(map[string]int)}
+}
+
+// Observe records the number of deletion candidates of the Service key.
+func (t *Tracker) Observe(key string, candidates int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.byKey[key] = candidates
+}
+
+// Forget drops the Service key, e.g. once the Service was deleted.
+func (t *Tracker) Forget(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.byKey, key)
+}
+
+// SetResyncInterval records the interval every Service is evaluated again
+// at, zero when the adaptive resync is disabled.
+func (t *Tracker) SetResyncInterval(d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.interval = d
+}
+
+// Snapshot returns the current backlog.
+func (t *Tracker) Snapshot() Backlog {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	b := Backlog{Services: len(t.byKey)}
+	for _, n := range t.byKey {
+		if n > 0 {
+			b.Stale++
+			b.Candidates += n
+		}
+	}
+	if b.Services > 0 {
+		b.Average = float64(b.Candidates) / float64(b.Services)
+	}
+	if t.interval > 0 {
+		b.ResyncInterval = t.interval.String()
+	}
+	return b
+}
+
+// Interval returns the interval every Service is evaluated again at for the
+// backlog: max while no Service has candidates, shrinking with the average
+// candidates per Service as max/(1+average), and never below min.
+func Interval(b Backlog, min, max time.Duration) time.Duration {
+	d := time.Duration(float64(max) / (1 + b.Average))
+	if d < min {
+		return min
+	}
+	return d
+}
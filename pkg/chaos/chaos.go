@@ -0,0 +1,40 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package chaos injects artificial failures into the controller to exercise
+// its retry and idempotency behavior. The injection is only compiled into
+// binaries built with the "chaos" build tag; in regular builds every hook
+// is a no-op and the flags are not registered.
+package chaos
+
+import (
+	"time"
+)
+
+// Options configures the injected failures.
+type Options struct {
+	// DeleteFailureRate is the fraction, between 0 and 1, of revision
+	// deletions that fail with an internal server error.
+	DeleteFailureRate float64
+
+	// ListerDelay is added to every revision lister call.
+	ListerDelay time.Duration
+
+	// StaleCacheRate is the fraction, between 0 and 1, of revision lister
+	// calls that return the result of a previous call instead of the
+	// current cache contents.
+	StaleCacheRate float64
+}
@@ -0,0 +1,42 @@
+//go:build !chaos
+// +build !chaos
+
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package chaos
+
+import (
+	"github.com/spf13/pflag"
+	"k8s.io/client-go/rest"
+	listers "knative.dev/serving/pkg/client/listers/serving/v1alpha1"
+)
+
+// Enabled reports whether failure injection is compiled in.
+const Enabled = false
+
+// AddFlags registers no flags without the chaos build tag.
+func AddFlags(fs *pflag.FlagSet) {}
+
+// WrapConfig returns cfg unchanged without the chaos build tag.
+func WrapConfig(cfg *rest.Config) *rest.Config {
+	return cfg
+}
+
+// RevisionLister returns l unchanged without the chaos build tag.
+func RevisionLister(l listers.RevisionLister) listers.RevisionLister {
+	return l
+}
@@ -0,0 +1,153 @@
+//go:build chaos
+// +build chaos
+
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package chaos
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/pflag"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/rest"
+	"knative.dev/serving/pkg/apis/serving/v1alpha1"
+	listers "knative.dev/serving/pkg/client/listers/serving/v1alpha1"
+)
+
+// Enabled reports whether failure injection is compiled in.
+const Enabled = true
+
+// active holds the options set by the flags.
+var active Options
+
+// AddFlags registers the failure injection flags.
+func AddFlags(fs *pflag.FlagSet) {
+	fs.Float64Var(&active.DeleteFailureRate, "chaos-delete-failure-rate", active.DeleteFailureRate, "Fraction of revision deletions that fail with an injected error.")
+	fs.DurationVar(&active.ListerDelay, "chaos-lister-delay", active.ListerDelay, "Delay added to every revision lister call.")
+	fs.Float64Var(&active.StaleCacheRate, "chaos-stale-cache-rate", active.StaleCacheRate, "Fraction of revision lister calls that return a previous, stale result.")
+}
+
+// Set replaces the injected failures, e.g. to drive the controller under
+// failures in tests, and returns the options it replaced.
+func Set(o Options) Options {
+	previous := active
+	active = o
+	return previous
+}
+
+// chance reports true with the probability rate.
+func chance(rate float64) bool {
+	return rate > 0 && rand.Float64() < rate
+}
+
+// WrapConfig returns a copy of cfg whose clients fail revision deletions at
+// the configured rate.
+func WrapConfig(cfg *rest.Config) *rest.Config {
+	cfg = rest.CopyConfig(cfg)
+	wrap := cfg.WrapTransport
+	cfg.WrapTransport = func(rt http.RoundTripper) http.RoundTripper {
+		if wrap != nil {
+			rt = wrap(rt)
+		}
+		return &failingTransport{next: rt}
+	}
+	return cfg
+}
+
+type failingTransport struct {
+	next http.RoundTripper
+}
+
+func (t *failingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method == http.MethodDelete && strings.Contains(req.URL.Path, "/revisions/") && chance(active.DeleteFailureRate) {
+		body := `{"kind":"Status","apiVersion":"v1","status":"Failure","message":"chaos: injected delete failure","reason":"InternalError","code":500}`
+		return &http.Response{
+			StatusCode: http.StatusInternalServerError,
+			Status:     "500 Internal Server Error",
+			Header:     http.Header{"Content-Type": []string{"application/json"}},
+			Body:       ioutil.NopCloser(bytes.NewBufferString(body)),
+			Request:    req,
+		}, nil
+	}
+	return t.next.RoundTrip(req)
+}
+
+// RevisionLister returns a lister that delays its calls and serves stale
+// results at the configured rates.
+func RevisionLister(l listers.RevisionLister) listers.RevisionLister {
+	return &revisionLister{next: l, previous: map[string][]*v1alpha1.Revision{}}
+}
+
+type revisionLister struct {
+	next listers.RevisionLister
+
+	mu       sync.Mutex
+	previous map[string][]*v1alpha1.Revision
+}
+
+// list delays the call, then either returns the result remembered for key
+// or calls fn and remembers its result.
+func (l *revisionLister) list(key string, fn func() ([]*v1alpha1.Revision, error)) ([]*v1alpha1.Revision, error) {
+	if active.ListerDelay > 0 {
+		time.Sleep(active.ListerDelay)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if stale, ok := l.previous[key]; ok && chance(active.StaleCacheRate) {
+		return stale, nil
+	}
+	ret, err := fn()
+	if err == nil {
+		l.previous[key] = ret
+	}
+	return ret, err
+}
+
+func (l *revisionLister) List(selector labels.Selector) ([]*v1alpha1.Revision, error) {
+	return l.list("/"+selector.String(), func() ([]*v1alpha1.Revision, error) {
+		return l.next.List(selector)
+	})
+}
+
+func (l *revisionLister) Revisions(namespace string) listers.RevisionNamespaceLister {
+	return &revisionNamespaceLister{lister: l, namespace: namespace}
+}
+
+type revisionNamespaceLister struct {
+	lister    *revisionLister
+	namespace string
+}
+
+func (l *revisionNamespaceLister) List(selector labels.Selector) ([]*v1alpha1.Revision, error) {
+	return l.lister.list(l.namespace+"/"+selector.String(), func() ([]*v1alpha1.Revision, error) {
+		return l.lister.next.Revisions(l.namespace).List(selector)
+	})
+}
+
+func (l *revisionNamespaceLister) Get(name string) (*v1alpha1.Revision, error) {
+	if active.ListerDelay > 0 {
+		time.Sleep(active.ListerDelay)
+	}
+	return l.lister.next.Revisions(l.namespace).Get(name)
+}
@@ -0,0 +1,61 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package clock abstracts the current time the ages, cool-downs and
+// windows of the garbage collection are computed against, so they can be
+// driven by a fixed time: deterministically in tests, and frozen at a
+// chosen future time to preview which revisions become eligible then.
+package clock
+
+import (
+	"fmt"
+	"time"
+)
+
+// Clock tells the current time.
+type Clock interface {
+	Now() time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+// Real is the wall clock.
+var Real Clock = realClock{}
+
+// Frozen is a Clock stopped at a time.
+type Frozen time.Time
+
+// Now implements Clock.
+func (f Frozen) Now() time.Time {
+	return time.Time(f)
+}
+
+// ParseAt parses the time a frozen clock is stopped at: an RFC 3339 time,
+// or a duration from now, e.g. 72h.
+func ParseAt(raw string, now time.Time) (Frozen, error) {
+	if d, err := time.ParseDuration(raw); err == nil {
+		return Frozen(now.Add(d)), nil
+	}
+	at, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		return Frozen{}, fmt.Errorf("invalid time %q: expected an RFC 3339 time or a duration from now", raw)
+	}
+	return Frozen(at), nil
+}
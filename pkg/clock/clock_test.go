@@ -0,0 +1,94 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReal(t *testing.T) {
+	before := time.Now()
+	got := Real.Now()
+	if after := time.Now(); got.Before(before) || got.After(after) {
+		t.Errorf("Real.Now() = %v, want between %v and %v", got, before, after)
+	}
+}
+
+func TestFrozen(t *testing.T) {
+	at := time.Date(2019, 8, 1, 12, 0, 0, 0, time.UTC)
+	var c Clock = Frozen(at)
+	for i := 0; i < 2; i++ {
+		if got := c.Now(); !got.Equal(at) {
+			t.Errorf("Frozen.Now() = %v, want %v", got, at)
+		}
+	}
+}
+
+func TestParseAt(t *testing.T) {
+	now := time.Date(2019, 8, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		raw     string
+		want    time.Time
+		wantErr bool
+	}{{
+		raw:  "72h",
+		want: now.Add(72 * time.Hour),
+	}, {
+		raw:  "90m",
+		want: now.Add(90 * time.Minute),
+	}, {
+		raw:  "-1h",
+		want: now.Add(-time.Hour),
+	}, {
+		raw:  "0s",
+		want: now,
+	}, {
+		raw:  "2019-08-04T12:00:00Z",
+		want: now.Add(72 * time.Hour),
+	}, {
+		raw:  "2019-08-04T14:00:00+02:00",
+		want: now.Add(72 * time.Hour),
+	}, {
+		raw:     "2019-08-04",
+		wantErr: true,
+	}, {
+		raw:     "3d",
+		wantErr: true,
+	}, {
+		raw:     "",
+		wantErr: true,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.raw, func(t *testing.T) {
+			got, err := ParseAt(test.raw, now)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("ParseAt(%q) = %v, want error", test.raw, got.Now())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseAt(%q) = %v", test.raw, err)
+			}
+			if !got.Now().Equal(test.want) {
+				t.Errorf("ParseAt(%q) = %v, want %v", test.raw, got.Now(), test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package clockskew estimates the offset between the clock of the controller
+// and the clock of the API server from the Date header of API responses, so
+// revision ages can be computed against the server clock that set their
+// creation timestamps.
+package clockskew
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"k8s.io/client-go/rest"
+)
+
+// maxSamples is the number of recent offsets the estimate is the median of.
+const maxSamples = 15
+
+// Monitor estimates the offset of the server clock from the local clock.
+type Monitor struct {
+	mu      sync.Mutex
+	samples []time.Duration
+}
+
+// Default is the Monitor fed by the clients of WrapConfig.
+var Default = &Monitor{}
+
+// Observe records a response with the server Date sent between the local
+// times sent and received. The Date header has a resolution of a second, the
+// server time is assumed to be in the middle of it.
+func (m *Monitor) Observe(date, sent, received time.Time) {
+	local := sent.Add(received.Sub(sent) / 2)
+	offset := date.Add(500 * time.Millisecond).Sub(local)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.samples) == maxSamples {
+		m.samples = append(m.samples[:0], m.samples[1:]...)
+	}
+	m.samples = append(m.samples, offset)
+}
+
+// Offset returns the estimated offset of the server clock from the local
+// clock, the median of the recent responses, and whether any was observed.
+func (m *Monitor) Offset() (time.Duration, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.samples) == 0 {
+		return 0, false
+	}
+	sorted := append([]time.Duration(nil), m.samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted[len(sorted)/2], true
+}
+
+// Now returns the estimated server time, the local time before any response
+// was observed.
+func (m *Monitor) Now() time.Time {
+	offset, _ := m.Offset()
+	return time.Now().Add(offset)
+}
+
+// Skew returns how far the creation timestamp is ahead of now, which a
+// server clock cannot produce unless the clocks disagree. It is zero when
+// created is not in the future.
+func Skew(created, now time.Time) time.Duration {
+	if ahead := created.Sub(now); ahead > 0 {
+		return ahead
+	}
+	return 0
+}
+
+// WrapConfig returns a copy of cfg whose clients report the Date of their
+// responses to Default. Watches are not observed, their Date is sent when
+// they start.
+func WrapConfig(cfg *rest.Config) *rest.Config {
+	cfg = rest.CopyConfig(cfg)
+	wrap := cfg.WrapTransport
+	cfg.WrapTransport = func(rt http.RoundTripper) http.RoundTripper {
+		if wrap != nil {
+			rt = wrap(rt)
+		}
+		return &observingTransport{next: rt, monitor: Default}
+	}
+	return cfg
+}
+
+type observingTransport struct {
+	next    http.RoundTripper
+	monitor *Monitor
+}
+
+func (t *observingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Query().Get("watch") == "true" {
+		return t.next.RoundTrip(req)
+	}
+	sent := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	if err == nil {
+		if date, err := http.ParseTime(resp.Header.Get("Date")); err == nil {
+			t.monitor.Observe(date, sent, time.Now())
+		}
+	}
+	return resp, err
+}
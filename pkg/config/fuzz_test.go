@@ -0,0 +1,85 @@
+//go:build go1.18
+// +build go1.18
+
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"io/ioutil"
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+// documented matches the settings of the data of the example ConfigMap.
+var documented = regexp.MustCompile(`(?m)^  ([a-z0-9-]+): "(.*)"$`)
+
+// exampleData returns the settings documented in the example ConfigMap.
+func exampleData(f *testing.F) map[string]string {
+	raw, err := ioutil.ReadFile("../../deployments/config-revision-gc.yaml")
+	if err != nil {
+		f.Fatalf("ReadFile() = %v", err)
+	}
+	data := map[string]string{}
+	for _, m := range documented.FindAllStringSubmatch(string(raw), -1) {
+		data[m[1]] = m[2]
+	}
+	return data
+}
+
+func FuzzNewGCFromMap(f *testing.F) {
+	example := exampleData(f)
+	if _, err := NewGCFromMap(example); err != nil {
+		f.Fatalf("NewGCFromMap(example) = %v", err)
+	}
+	for key, value := range example {
+		f.Add(key, value, false)
+		f.Add(key, value, true)
+	}
+	for _, seed := range [][2]string{
+		{"retain-count", "-1"},
+		{"min-stale-age", "1y"},
+		{"keep-last-deploys", "1000"},
+		{"drain-probe-port", "65536"},
+		{"label-keys", ","},
+	} {
+		f.Add(seed[0], seed[1], true)
+	}
+	f.Fuzz(func(t *testing.T, key, value string, withExample bool) {
+		data := map[string]string{}
+		if withExample {
+			for k, v := range example {
+				data[k] = v
+			}
+		}
+		data[key] = value
+		c, err := NewGCFromMap(data)
+		if err != nil {
+			return
+		}
+		c.Policy()
+		c.Quotas()
+		again, err := NewGCFromMap(data)
+		if err != nil {
+			t.Fatalf("NewGCFromMap(%s=%q) = %v on the second read", key, value, err)
+		}
+		if !reflect.DeepEqual(again, c) {
+			t.Errorf("NewGCFromMap(%s=%q) = %+v, then %+v", key, value, c, again)
+		}
+	})
+}
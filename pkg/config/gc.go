@@ -0,0 +1,1359 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/knative-sample/revision-controller/pkg/resourcequota"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	"github.com/knative-sample/revision-controller/pkg/pressure"
+	"github.com/knative-sample/revision-controller/pkg/quota"
+	"github.com/knative-sample/revision-controller/pkg/schedule"
+	"github.com/knative-sample/revision-controller/pkg/strategy"
+)
+
+const (
+	// GCConfigName is the name of the ConfigMap holding the cluster wide
+	// revision garbage collection policy.
+	GCConfigName = "config-revision-gc"
+
+	// DefaultPolicyName is the name reported for the cluster wide policy.
+	DefaultPolicyName = "default"
+
+	// DefaultConnectionsQuery sums the concurrent requests queue-proxy reports
+	// for every revision of the Service.
+	DefaultConnectionsQuery = `sum by (destination_revision) (queue_average_concurrent_requests{destination_namespace="{{.Namespace}}",destination_configuration="{{.Configuration}}"})`
+
+	// DefaultConnectionsRevisionLabel is the label holding the revision name
+	// in the samples of the default connections query.
+	DefaultConnectionsRevisionLabel = "destination_revision"
+
+	// MaxKeepLastDeploys bounds keep-last-deploys, which sizes the deploy
+	// history recorded on every Service.
+	MaxKeepLastDeploys = 50
+
+	// MaxDeletedHistory bounds deleted-history, which sizes the deleted
+	// revisions recorded on every Service.
+	MaxDeletedHistory = 50
+
+	// MaxKeepTagHistory bounds the holders kept per tag in keep-tag-history.
+	MaxKeepTagHistory = 20
+
+	// DefaultConcurrency is the number of workers of every controller, the
+	// knative default.
+	DefaultConcurrency = 2
+
+	// MaxConcurrency bounds concurrency.
+	MaxConcurrency = 64
+
+	// MaintenanceHoldAnnotationKey is the annotation on the GC ConfigMap that
+	// holds back all deletions while set to "true", e.g. during Knative upgrades.
+	MaintenanceHoldAnnotationKey = "revision-gc.knative.dev/maintenance-hold"
+
+	// DefaultAttestationAnnotation is the revision annotation recording the
+	// verification of the signature or provenance of its image, e.g. set by
+	// the pipeline that verified it with cosign.
+	DefaultAttestationAnnotation = "revision-gc.knative.dev/attestation"
+
+	// DefaultAttestationValue is the value of a verified attestation.
+	DefaultAttestationValue = "verified"
+
+	// DefaultGitCommitAnnotation, DefaultGitBranchAnnotation and
+	// DefaultGitTagAnnotation are the revision annotations recording the Git
+	// commit SHA, branch and tag its source was built from, e.g. set by the
+	// build pipeline.
+	DefaultGitCommitAnnotation = "revision-gc.knative.dev/git-commit"
+	DefaultGitBranchAnnotation = "revision-gc.knative.dev/git-branch"
+	DefaultGitTagAnnotation    = "revision-gc.knative.dev/git-tag"
+
+	// AllowMassDeletionAnnotationKey is the annotation on the GC ConfigMap
+	// that accepts a configuration deleting more revisions than
+	// mass-deletion-threshold when set to "true".
+	AllowMassDeletionAnnotationKey = "revision-gc.knative.dev/allow-mass-deletion"
+
+	// DrainVerifierIstio verifies drained connections with the Envoy
+	// sidecar stats.
+	DrainVerifierIstio = "istio"
+
+	// DrainVerifierHTTP verifies drained connections with an HTTP probe the
+	// pods serve.
+	DrainVerifierHTTP = "http"
+
+	// DefaultDrainProbePath is the path of the HTTP drain probe.
+	DefaultDrainProbePath = "/connections"
+
+	// DefaultDrainStatsPort is the port the Istio sidecar serves the merged
+	// Prometheus stats on.
+	DefaultDrainStatsPort = 15090
+
+	// MaxNamespaceTier bounds the tiers of namespace-tiers, between
+	// -MaxNamespaceTier and MaxNamespaceTier.
+	MaxNamespaceTier = 10
+
+	// DefaultAPICallTimeout is the default timeout of the API calls of a
+	// reconcile.
+	DefaultAPICallTimeout = 30 * time.Second
+
+	// DefaultShedRetryDelay is the delay before a shed Service is retried.
+	DefaultShedRetryDelay = 5 * time.Minute
+
+	// DefaultStalledDeletionTimeout is how long a revision may remain after
+	// its deletion before it is reported as stalled.
+	DefaultStalledDeletionTimeout = 15 * time.Minute
+
+	// StalledDeletionAlert reports stalled deletions, StalledDeletionRetry
+	// also re-issues them.
+	StalledDeletionAlert = "alert"
+	StalledDeletionRetry = "retry"
+
+	// RetentionDelete deletes stale revisions, RetentionSuspend first
+	// scales them to zero and labels them suspended, and deletes them once
+	// they have been suspended for SuspendTTL.
+	RetentionDelete  = "delete"
+	RetentionSuspend = "suspend"
+
+	// DefaultSuspendTTL is how long a revision remains suspended before it
+	// is deleted.
+	DefaultSuspendTTL = 7 * 24 * time.Hour
+
+	// SweepSecrets and SweepConfigMaps are the child resources that can be swept.
+	SweepSecrets    = "secrets"
+	SweepConfigMaps = "configmaps"
+)
+
+// GC holds the cluster wide revision garbage collection settings.
+type GC struct {
+	// Namespace is the namespace of the ConfigMap the settings were read from.
+	Namespace string
+
+	// RetainCount is the number of most recent stale revisions to keep.
+	RetainCount int
+
+	// MinStaleAge is the minimum age of a stale revision before it is deleted.
+	MinStaleAge time.Duration
+
+	// ClockSkewMargin widens MinStaleAge to tolerate small clock skew.
+	ClockSkewMargin time.Duration
+
+	// MaxClockSkew is the clock skew between the controller and the API
+	// server above which MinStaleAge cannot decide deletions. Zero disables
+	// the check.
+	MaxClockSkew time.Duration
+
+	// KeepLastDeploys keeps the revisions created by the last deploys of
+	// every Service, zero disables it.
+	KeepLastDeploys int
+
+	// DeletedHistory records the last revisions deleted from every Service
+	// on the Service, zero disables it.
+	DeletedHistory int
+
+	// MaxRevisions caps the number of live revisions per Service, zero
+	// disables the cap.
+	MaxRevisions int
+
+	// KeepTagHistory keeps the last revisions that held a traffic tag, by
+	// tag name, even after the tag moved on. Tags not listed are not tracked.
+	KeepTagHistory map[string]int
+
+	// KeepAttested retains at least one revision per attested image digest,
+	// i.e. whose AttestationAnnotation is set to AttestationValue.
+	KeepAttested          bool
+	AttestationAnnotation string
+	AttestationValue      string
+
+	// Git are the annotations recording the Git metadata of revisions.
+	// ProtectedGitBranches protects the revisions built from the branches
+	// matching its patterns, KeepGitTags retains the newest revision built
+	// from every tag matching its patterns.
+	Git                  strategy.GitMetadata
+	ProtectedGitBranches []string
+	KeepGitTags          []string
+
+	// DeleteWarm allows deleting stale revisions kept warm by a PodAutoscaler
+	// minScale above zero.
+	DeleteWarm bool
+
+	// QuotaPressureThreshold is the percentage of the limit of one of the
+	// QuotaPressureResources in a ResourceQuota of a namespace from which
+	// its stale revisions are collected with QuotaPressureRetainCount and
+	// QuotaPressureMinStaleAge, when lower. Zero disables it.
+	QuotaPressureThreshold   int
+	QuotaPressureResources   []corev1.ResourceName
+	QuotaPressureRetainCount int
+	QuotaPressureMinStaleAge time.Duration
+
+	// MaintenanceHold defers all deletions while set.
+	MaintenanceHold bool
+
+	// ApprovalRequired makes the executor wait until a plan is approved.
+	ApprovalRequired bool
+
+	// PlanExpiry is the time after which an unapproved plan is discarded.
+	PlanExpiry time.Duration
+
+	// DeletionWindows restricts deletions to the times one of the windows is
+	// open. Deletions are not restricted when empty.
+	DeletionWindows schedule.Windows
+
+	// NamespaceRollout enables deletions for a growing share of the
+	// namespaces only.
+	NamespaceRollout NamespaceRollout
+
+	// GlobalQuota limits the deletions across the cluster.
+	GlobalQuota quota.Limits
+
+	// NamespaceQuota limits the deletions in every namespace.
+	NamespaceQuota quota.Limits
+
+	// OwnerLabel is the Service label naming the team owning the Service.
+	// Deletions are counted and reported per team when set.
+	OwnerLabel string
+
+	// TeamQuota limits the deletions of every owner team, so one team cannot
+	// exhaust the global quota.
+	TeamQuota quota.Limits
+
+	// ConnectionsPrometheusURL is the Prometheus server open connections are
+	// read from. Open connections are not checked when empty.
+	ConnectionsPrometheusURL string
+
+	// ConnectionsQuery is the template of the query returning the open
+	// connections of every revision of a Service.
+	ConnectionsQuery string
+
+	// ConnectionsRevisionLabel is the label holding the revision name in the
+	// samples returned by ConnectionsQuery.
+	ConnectionsRevisionLabel string
+
+	// InvalidationHookURL is the hook that must confirm the invalidation of
+	// the caches in front of a revision before it is deleted. Deletions are
+	// not held when empty.
+	InvalidationHookURL string
+
+	// InvalidationPollInterval is the delay before a pending invalidation is
+	// checked again.
+	InvalidationPollInterval time.Duration
+
+	// DrainVerifier verifies that the pods of a revision hold no active
+	// connections before it is deleted: "istio" reads the Envoy sidecar
+	// stats, "http" the probe the pods serve. Deletions are not verified
+	// when empty.
+	DrainVerifier string
+
+	// DrainStatsPort is the port the Envoy sidecar serves its Prometheus
+	// stats on.
+	DrainStatsPort int
+
+	// DrainProbePort and DrainProbePath locate the HTTP drain probe on the
+	// pods, answering with the number of active connections.
+	DrainProbePort int
+	DrainProbePath string
+
+	// DrainPollInterval is the delay before a revision whose pods still hold
+	// active connections is checked again.
+	DrainPollInterval time.Duration
+
+	// IngressCheck holds the deletion of a revision while an Ingress of the
+	// Route still routes to it or has not programmed its latest spec.
+	IngressCheck bool
+
+	// IngressPollInterval is the delay before a revision held by the
+	// Ingress check is checked again.
+	IngressPollInterval time.Duration
+
+	// RelistSettlePeriod is how long deletions are held after an informer
+	// relisted its objects, e.g. after an API server restart, so the caches
+	// can become consistent again. Zero disables the hold.
+	RelistSettlePeriod time.Duration
+
+	// RelistJitter is the maximum random delay with which the Services
+	// delivered again by a relist are evaluated. Zero evaluates them at once.
+	RelistJitter time.Duration
+
+	// CandidateConfirmationPeriod is how long a revision must remain a
+	// deletion candidate, observed by two evaluations at least this far
+	// apart, before its deletion is planned. The observations are kept in
+	// memory, so every controller start confirms the candidates again. Zero
+	// plans candidates at once.
+	CandidateConfirmationPeriod time.Duration
+
+	// ResyncMinInterval and ResyncMaxInterval bound the interval every
+	// Service is evaluated again at, adapted to the backlog of stale
+	// revisions. Zero disables the adaptive resync.
+	ResyncMinInterval time.Duration
+	ResyncMaxInterval time.Duration
+
+	// AuditInterval is how often every Service is audited: its expected
+	// deletions recomputed and compared with the recorded plan, plus a
+	// random delay of up to AuditJitter. Zero disables the audit.
+	AuditInterval time.Duration
+	AuditJitter   time.Duration
+
+	// LabelKeys are the label keys used to match revisions to their Service.
+	LabelKeys strategy.LabelKeys
+
+	// MassDeletionThreshold is the number of revisions above which the
+	// webhook rejects configuration changes that increase the deletions,
+	// unless they are explicitly allowed. Zero disables the check.
+	MassDeletionThreshold int
+
+	// AdaptiveDeletions defers non-urgent deletions while the API server is
+	// under pressure according to Pressure.
+	AdaptiveDeletions bool
+
+	// Pressure describes when the API server is under pressure.
+	Pressure pressure.Thresholds
+
+	// SweepChildResources are the resources, "secrets" and "configmaps",
+	// generated for revisions that are deleted once they outlive their
+	// revision. Nothing is swept when empty.
+	SweepChildResources []string
+
+	// SweepNamePattern matches the names of child resources generated for a
+	// revision, with the revision name in its "revision" group. Only owner
+	// references and the revision label are matched when nil.
+	SweepNamePattern *regexp.Regexp
+
+	// SweepMinAge is the minimum age of an orphaned child resource before it
+	// is deleted, leaving the Kubernetes garbage collector time to delete it.
+	SweepMinAge time.Duration
+
+	// Concurrency is the number of workers reconciling concurrently in every
+	// controller. Changes apply without a restart.
+	Concurrency int
+
+	// SummaryInterval is the interval the outcome of the reconciles is
+	// summarized over per namespace, replacing the per-Service logs and
+	// events. Zero disables the summaries.
+	SummaryInterval time.Duration
+
+	// StuckFailedGenerations is the number of latest Configuration
+	// generations that all failed, with an older revision still Ready, for
+	// the Configuration to be reported as stuck. Zero disables the check.
+	StuckFailedGenerations int
+
+	// AnnotateStuck annotates stuck Configurations for operator attention.
+	AnnotateStuck bool
+
+	// LabelRevisionState labels the revisions of evaluated Services with
+	// their garbage collection state.
+	LabelRevisionState bool
+
+	// RollbackWindow keeps the previous generation for this long after a new
+	// one became latest. Zero disables it.
+	RollbackWindow time.Duration
+
+	// NoGCAnnotations are the annotations protecting a revision when set to
+	// "true", shared with the other garbage collectors of the cluster.
+	NoGCAnnotations []string
+
+	// RedactionSafeAnnotations are the annotations, or prefixes ending in
+	// "*", whose values are shown in logs, events and notifications in
+	// addition to those of the controller and of Knative.
+	RedactionSafeAnnotations []string
+
+	// VolumeClaims selects how stale revisions mounting
+	// PersistentVolumeClaims are collected.
+	VolumeClaims strategy.VolumeClaimPolicy
+
+	// ActivationCooldown protects stale revisions activated from zero for
+	// this long after the activation. Zero disables the protection.
+	ActivationCooldown time.Duration
+
+	// NotifyWhenCandidatesExceed withholds the deletions of a Service with
+	// more candidates than this and notifies instead. Zero disables it.
+	NotifyWhenCandidatesExceed int
+
+	// OwnerKey is the label, or else annotation, of a Service naming its
+	// owner, e.g. its team.
+	OwnerKey string
+
+	// OwnershipHandoffPeriod suspends garbage collection of a Service for
+	// this long after its owner changed. Zero disables it.
+	OwnershipHandoffPeriod time.Duration
+
+	// RollbackFreezePeriod suspends garbage collection of a Service for
+	// this long after its Route moved back to an older generation. Zero
+	// disables it.
+	RollbackFreezePeriod time.Duration
+
+	// NamespaceTiers rank namespaces, matched in order, for the work queue
+	// and load-shedding. Unmatched namespaces are in tier 0.
+	NamespaceTiers []NamespaceTier
+
+	// ShedQueueDepth is the work queue depth above which the Services of
+	// namespaces in tiers below ShedBelowTier are shed and retried after
+	// ShedRetryDelay. Zero disables load-shedding.
+	ShedQueueDepth int
+	ShedBelowTier  int
+	ShedRetryDelay time.Duration
+
+	// APICallTimeout bounds every API call of a reconcile, so a stuck call
+	// cannot wedge a worker. Zero leaves the calls unbounded.
+	APICallTimeout time.Duration
+
+	// VerifySteadyState lists the revisions of a Service from the API server
+	// after deleting some and alerts when they differ from the expected ones.
+	VerifySteadyState bool
+
+	// StalledDeletionTimeout is how long a deleted revision may remain, e.g.
+	// held by the finalizer of a webhook, before its deletion is reported as
+	// stalled. Zero disables the tracking.
+	StalledDeletionTimeout time.Duration
+
+	// StalledDeletionAction is StalledDeletionAlert or StalledDeletionRetry.
+	StalledDeletionAction string
+
+	// RetentionMode is RetentionDelete or RetentionSuspend.
+	RetentionMode string
+
+	// SuspendTTL is how long a revision remains suspended under
+	// RetentionSuspend before it is deleted.
+	SuspendTTL time.Duration
+}
+
+// NewGCFromConfigMap creates a GC from the supplied ConfigMap.
+func NewGCFromConfigMap(configMap *corev1.ConfigMap) (*GC, error) {
+	c, err := NewGCFromMap(configMap.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	if raw, ok := configMap.Annotations[MaintenanceHoldAnnotationKey]; ok {
+		val, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s annotation %q: %v", MaintenanceHoldAnnotationKey, raw, err)
+		}
+		c.MaintenanceHold = val
+	}
+	c.Namespace = configMap.Namespace
+
+	return c, nil
+}
+
+// NewGCFromMap creates a GC from the supplied map.
+func NewGCFromMap(data map[string]string) (*GC, error) {
+	c := &GC{}
+
+	if raw, ok := data["retain-count"]; !ok {
+		c.RetainCount = 0
+	} else if val, err := strconv.Atoi(raw); err != nil {
+		return nil, err
+	} else if val < 0 {
+		return nil, errors.New("retain-count must be zero or greater")
+	} else {
+		c.RetainCount = val
+	}
+
+	if raw, ok := data["keep-last-deploys"]; !ok {
+		c.KeepLastDeploys = 0
+	} else if val, err := strconv.Atoi(raw); err != nil {
+		return nil, err
+	} else if val < 0 || val > MaxKeepLastDeploys {
+		return nil, fmt.Errorf("keep-last-deploys must be between 0 and %d", MaxKeepLastDeploys)
+	} else {
+		c.KeepLastDeploys = val
+	}
+
+	if raw, ok := data["deleted-history"]; !ok {
+		c.DeletedHistory = 0
+	} else if val, err := strconv.Atoi(raw); err != nil {
+		return nil, err
+	} else if val < 0 || val > MaxDeletedHistory {
+		return nil, fmt.Errorf("deleted-history must be between 0 and %d", MaxDeletedHistory)
+	} else {
+		c.DeletedHistory = val
+	}
+
+	if raw, ok := data["max-revisions"]; !ok {
+		c.MaxRevisions = 0
+	} else if val, err := strconv.Atoi(raw); err != nil {
+		return nil, err
+	} else if val < 0 {
+		return nil, errors.New("max-revisions must be zero or greater")
+	} else {
+		c.MaxRevisions = val
+	}
+
+	if raw, ok := data["keep-tag-history"]; ok && strings.TrimSpace(raw) != "" {
+		c.KeepTagHistory = make(map[string]int)
+		for _, entry := range strings.Split(raw, ",") {
+			parts := strings.SplitN(strings.TrimSpace(entry), "=", 2)
+			if len(parts) != 2 || parts[0] == "" {
+				return nil, fmt.Errorf("invalid keep-tag-history entry %q: expected tag=count", entry)
+			}
+			val, err := strconv.Atoi(parts[1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid keep-tag-history entry %q: %v", entry, err)
+			} else if val < 1 || val > MaxKeepTagHistory {
+				return nil, fmt.Errorf("keep-tag-history count of tag %s must be between 1 and %d", parts[0], MaxKeepTagHistory)
+			}
+			c.KeepTagHistory[parts[0]] = val
+		}
+	}
+
+	if raw, ok := data["keep-attested-digests"]; !ok {
+		c.KeepAttested = false
+	} else if val, err := strconv.ParseBool(raw); err != nil {
+		return nil, err
+	} else {
+		c.KeepAttested = val
+	}
+
+	c.AttestationAnnotation = DefaultAttestationAnnotation
+	if raw, ok := data["attestation-annotation-key"]; ok && raw != "" {
+		if errs := validation.IsQualifiedName(raw); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid attestation-annotation-key %q: %s", raw, strings.Join(errs, "; "))
+		}
+		c.AttestationAnnotation = raw
+	}
+
+	c.AttestationValue = DefaultAttestationValue
+	if raw, ok := data["attestation-annotation-value"]; ok && raw != "" {
+		c.AttestationValue = raw
+	}
+
+	c.Git = strategy.GitMetadata{
+		CommitAnnotation: DefaultGitCommitAnnotation,
+		BranchAnnotation: DefaultGitBranchAnnotation,
+		TagAnnotation:    DefaultGitTagAnnotation,
+	}
+	for _, key := range []struct {
+		name  string
+		field *string
+	}{
+		{"git-commit-annotation-key", &c.Git.CommitAnnotation},
+		{"git-branch-annotation-key", &c.Git.BranchAnnotation},
+		{"git-tag-annotation-key", &c.Git.TagAnnotation},
+	} {
+		if raw, ok := data[key.name]; ok && raw != "" {
+			if errs := validation.IsQualifiedName(raw); len(errs) > 0 {
+				return nil, fmt.Errorf("invalid %s %q: %s", key.name, raw, strings.Join(errs, "; "))
+			}
+			*key.field = raw
+		}
+	}
+
+	for _, patterns := range []struct {
+		name  string
+		field *[]string
+	}{
+		{"protected-git-branches", &c.ProtectedGitBranches},
+		{"keep-git-tags", &c.KeepGitTags},
+	} {
+		*patterns.field = nil
+		for _, pattern := range strings.Split(data[patterns.name], ",") {
+			if pattern = strings.TrimSpace(pattern); pattern == "" {
+				continue
+			}
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("invalid %s entry %q: %v", patterns.name, pattern, err)
+			}
+			*patterns.field = append(*patterns.field, pattern)
+		}
+	}
+
+	if raw, ok := data["delete-warm-revisions"]; !ok {
+		c.DeleteWarm = false
+	} else if val, err := strconv.ParseBool(raw); err != nil {
+		return nil, err
+	} else {
+		c.DeleteWarm = val
+	}
+
+	if raw, ok := data["quota-pressure-threshold"]; !ok {
+		c.QuotaPressureThreshold = 0
+	} else if val, err := strconv.Atoi(raw); err != nil {
+		return nil, err
+	} else if val < 0 || val > 100 {
+		return nil, errors.New("quota-pressure-threshold must be between 0 and 100")
+	} else {
+		c.QuotaPressureThreshold = val
+	}
+
+	c.QuotaPressureResources = nil
+	for _, name := range strings.Split(data["quota-pressure-resources"], ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			c.QuotaPressureResources = append(c.QuotaPressureResources, corev1.ResourceName(name))
+		}
+	}
+	if len(c.QuotaPressureResources) == 0 {
+		c.QuotaPressureResources = resourcequota.DefaultResources
+	}
+
+	if raw, ok := data["quota-pressure-retain-count"]; !ok {
+		c.QuotaPressureRetainCount = 1
+	} else if val, err := strconv.Atoi(raw); err != nil {
+		return nil, err
+	} else if val < 0 {
+		return nil, errors.New("quota-pressure-retain-count must be zero or greater")
+	} else {
+		c.QuotaPressureRetainCount = val
+	}
+
+	if raw, ok := data["quota-pressure-min-stale-age"]; !ok {
+		c.QuotaPressureMinStaleAge = 0
+	} else if val, err := time.ParseDuration(raw); err != nil {
+		return nil, err
+	} else if val < 0 {
+		return nil, errors.New("quota-pressure-min-stale-age must be zero or greater")
+	} else {
+		c.QuotaPressureMinStaleAge = val
+	}
+
+	if raw, ok := data["approval-required"]; !ok {
+		c.ApprovalRequired = false
+	} else if val, err := strconv.ParseBool(raw); err != nil {
+		return nil, err
+	} else {
+		c.ApprovalRequired = val
+	}
+
+	if raw, ok := data["plan-expiry"]; !ok {
+		c.PlanExpiry = 24 * time.Hour
+	} else if val, err := time.ParseDuration(raw); err != nil {
+		return nil, err
+	} else if val < 0 {
+		return nil, errors.New("plan-expiry must be zero or greater")
+	} else {
+		c.PlanExpiry = val
+	}
+
+	if raw, ok := data["min-stale-age"]; !ok {
+		c.MinStaleAge = 0
+	} else if val, err := time.ParseDuration(raw); err != nil {
+		return nil, err
+	} else if val < 0 {
+		return nil, errors.New("min-stale-age must be zero or greater")
+	} else {
+		c.MinStaleAge = val
+	}
+
+	if raw, ok := data["clock-skew-margin"]; !ok {
+		c.ClockSkewMargin = time.Minute
+	} else if val, err := time.ParseDuration(raw); err != nil {
+		return nil, err
+	} else if val < 0 {
+		return nil, errors.New("clock-skew-margin must be zero or greater")
+	} else {
+		c.ClockSkewMargin = val
+	}
+
+	if raw, ok := data["max-clock-skew"]; !ok {
+		c.MaxClockSkew = 5 * time.Minute
+	} else if val, err := time.ParseDuration(raw); err != nil {
+		return nil, err
+	} else if val < 0 {
+		return nil, errors.New("max-clock-skew must be zero or greater")
+	} else {
+		c.MaxClockSkew = val
+	}
+
+	if raw, ok := data["deletion-windows"]; ok && strings.TrimSpace(raw) != "" {
+		windows, err := schedule.ParseWindows(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid deletion-windows: %v", err)
+		}
+		c.DeletionWindows = windows
+	}
+
+	if raw, ok := data["namespace-rollout-percent"]; !ok {
+		c.NamespaceRollout.Percent = 100
+	} else if val, err := strconv.Atoi(raw); err != nil {
+		return nil, err
+	} else if val < 0 || val > 100 {
+		return nil, errors.New("namespace-rollout-percent must be between 0 and 100")
+	} else {
+		c.NamespaceRollout.Percent = val
+	}
+
+	if raw, ok := data["namespace-rollout-duration"]; !ok {
+		c.NamespaceRollout.Duration = 0
+	} else if val, err := time.ParseDuration(raw); err != nil {
+		return nil, err
+	} else if val < 0 {
+		return nil, errors.New("namespace-rollout-duration must be zero or greater")
+	} else {
+		c.NamespaceRollout.Duration = val
+	}
+
+	if raw, ok := data["namespace-rollout-start"]; !ok || raw == "" {
+		if c.NamespaceRollout.Duration > 0 {
+			return nil, errors.New("namespace-rollout-start is required with a namespace-rollout-duration")
+		}
+	} else if val, err := time.Parse(time.RFC3339, raw); err != nil {
+		return nil, fmt.Errorf("invalid namespace-rollout-start %q: %v", raw, err)
+	} else {
+		c.NamespaceRollout.Start = val
+	}
+
+	c.NamespaceRollout.Salt = data["namespace-rollout-salt"]
+
+	for _, limit := range []struct {
+		key   string
+		field *int
+	}{{
+		key:   "max-deletions-per-hour",
+		field: &c.GlobalQuota.PerHour,
+	}, {
+		key:   "max-deletions-per-day",
+		field: &c.GlobalQuota.PerDay,
+	}, {
+		key:   "namespace-max-deletions-per-hour",
+		field: &c.NamespaceQuota.PerHour,
+	}, {
+		key:   "namespace-max-deletions-per-day",
+		field: &c.NamespaceQuota.PerDay,
+	}, {
+		key:   "team-max-deletions-per-hour",
+		field: &c.TeamQuota.PerHour,
+	}, {
+		key:   "team-max-deletions-per-day",
+		field: &c.TeamQuota.PerDay,
+	}} {
+		if raw, ok := data[limit.key]; !ok {
+			continue
+		} else if val, err := strconv.Atoi(raw); err != nil {
+			return nil, fmt.Errorf("invalid %s %q: %v", limit.key, raw, err)
+		} else if val < 0 {
+			return nil, fmt.Errorf("%s must be zero or greater", limit.key)
+		} else {
+			*limit.field = val
+		}
+	}
+
+	if raw, ok := data["owner-label"]; ok && raw != "" {
+		if errs := validation.IsQualifiedName(raw); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid owner-label %q: %s", raw, strings.Join(errs, "; "))
+		}
+		c.OwnerLabel = raw
+	}
+	if c.OwnerLabel == "" && !c.TeamQuota.Unlimited() {
+		return nil, errors.New("team-max-deletions-per-hour and team-max-deletions-per-day require owner-label")
+	}
+
+	if raw, ok := data["connections-prometheus-url"]; ok && raw != "" {
+		if u, err := url.Parse(raw); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid connections-prometheus-url %q: expected an http(s) URL", raw)
+		}
+		c.ConnectionsPrometheusURL = strings.TrimSuffix(raw, "/")
+	}
+
+	c.ConnectionsQuery = DefaultConnectionsQuery
+	if raw, ok := data["connections-query"]; ok && strings.TrimSpace(raw) != "" {
+		if _, err := template.New("connections-query").Parse(raw); err != nil {
+			return nil, fmt.Errorf("invalid connections-query: %v", err)
+		}
+		c.ConnectionsQuery = raw
+	}
+
+	c.ConnectionsRevisionLabel = DefaultConnectionsRevisionLabel
+	if raw, ok := data["connections-revision-label"]; ok && raw != "" {
+		c.ConnectionsRevisionLabel = raw
+	}
+
+	if raw, ok := data["invalidation-hook-url"]; ok && raw != "" {
+		if u, err := url.Parse(raw); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid invalidation-hook-url %q: expected an http(s) URL", raw)
+		}
+		c.InvalidationHookURL = raw
+	}
+
+	if raw, ok := data["invalidation-poll-interval"]; !ok {
+		c.InvalidationPollInterval = time.Minute
+	} else if val, err := time.ParseDuration(raw); err != nil {
+		return nil, err
+	} else if val <= 0 {
+		return nil, errors.New("invalidation-poll-interval must be greater than zero")
+	} else {
+		c.InvalidationPollInterval = val
+	}
+
+	if raw, ok := data["drain-verifier"]; ok && raw != "" {
+		if raw != DrainVerifierIstio && raw != DrainVerifierHTTP {
+			return nil, fmt.Errorf("invalid drain-verifier %q: expected %s or %s", raw, DrainVerifierIstio, DrainVerifierHTTP)
+		}
+		c.DrainVerifier = raw
+	}
+
+	if raw, ok := data["drain-stats-port"]; !ok {
+		c.DrainStatsPort = DefaultDrainStatsPort
+	} else if val, err := strconv.Atoi(raw); err != nil {
+		return nil, err
+	} else if val < 1 || val > 65535 {
+		return nil, errors.New("drain-stats-port must be between 1 and 65535")
+	} else {
+		c.DrainStatsPort = val
+	}
+
+	if raw, ok := data["drain-probe-port"]; ok && raw != "" {
+		if val, err := strconv.Atoi(raw); err != nil {
+			return nil, err
+		} else if val < 1 || val > 65535 {
+			return nil, errors.New("drain-probe-port must be between 1 and 65535")
+		} else {
+			c.DrainProbePort = val
+		}
+	}
+	if c.DrainVerifier == DrainVerifierHTTP && c.DrainProbePort == 0 {
+		return nil, errors.New("drain-verifier http requires drain-probe-port")
+	}
+
+	if raw, ok := data["drain-probe-path"]; !ok || raw == "" {
+		c.DrainProbePath = DefaultDrainProbePath
+	} else if !strings.HasPrefix(raw, "/") {
+		return nil, fmt.Errorf("invalid drain-probe-path %q: must start with /", raw)
+	} else {
+		c.DrainProbePath = raw
+	}
+
+	if raw, ok := data["drain-poll-interval"]; !ok {
+		c.DrainPollInterval = 30 * time.Second
+	} else if val, err := time.ParseDuration(raw); err != nil {
+		return nil, err
+	} else if val <= 0 {
+		return nil, errors.New("drain-poll-interval must be greater than zero")
+	} else {
+		c.DrainPollInterval = val
+	}
+
+	if raw, ok := data["ingress-check"]; !ok {
+		c.IngressCheck = false
+	} else if val, err := strconv.ParseBool(raw); err != nil {
+		return nil, err
+	} else {
+		c.IngressCheck = val
+	}
+
+	if raw, ok := data["ingress-poll-interval"]; !ok {
+		c.IngressPollInterval = 10 * time.Second
+	} else if val, err := time.ParseDuration(raw); err != nil {
+		return nil, err
+	} else if val <= 0 {
+		return nil, errors.New("ingress-poll-interval must be greater than zero")
+	} else {
+		c.IngressPollInterval = val
+	}
+
+	if raw, ok := data["relist-settle-period"]; !ok {
+		c.RelistSettlePeriod = 30 * time.Second
+	} else if val, err := time.ParseDuration(raw); err != nil {
+		return nil, err
+	} else if val < 0 {
+		return nil, errors.New("relist-settle-period must not be negative")
+	} else {
+		c.RelistSettlePeriod = val
+	}
+
+	if raw, ok := data["relist-jitter"]; !ok {
+		c.RelistJitter = 10 * time.Second
+	} else if val, err := time.ParseDuration(raw); err != nil {
+		return nil, err
+	} else if val < 0 {
+		return nil, errors.New("relist-jitter must not be negative")
+	} else {
+		c.RelistJitter = val
+	}
+
+	if raw, ok := data["candidate-confirmation-period"]; !ok {
+		c.CandidateConfirmationPeriod = 0
+	} else if val, err := time.ParseDuration(raw); err != nil {
+		return nil, err
+	} else if val < 0 {
+		return nil, errors.New("candidate-confirmation-period must not be negative")
+	} else {
+		c.CandidateConfirmationPeriod = val
+	}
+
+	for _, d := range []struct {
+		key   string
+		field *time.Duration
+	}{{
+		key:   "resync-min-interval",
+		field: &c.ResyncMinInterval,
+	}, {
+		key:   "resync-max-interval",
+		field: &c.ResyncMaxInterval,
+	}, {
+		key:   "audit-interval",
+		field: &c.AuditInterval,
+	}, {
+		key:   "audit-jitter",
+		field: &c.AuditJitter,
+	}} {
+		if raw, ok := data[d.key]; !ok {
+			*d.field = 0
+		} else if val, err := time.ParseDuration(raw); err != nil {
+			return nil, err
+		} else if val < 0 {
+			return nil, fmt.Errorf("%s must not be negative", d.key)
+		} else {
+			*d.field = val
+		}
+	}
+	if (c.ResyncMinInterval > 0) != (c.ResyncMaxInterval > 0) || c.ResyncMinInterval > c.ResyncMaxInterval {
+		return nil, errors.New("resync-min-interval and resync-max-interval must both be set, the minimum not above the maximum")
+	}
+
+	c.LabelKeys = strategy.DefaultLabelKeys()
+	for _, key := range []struct {
+		key   string
+		field *string
+	}{{
+		key:   "service-label-key",
+		field: &c.LabelKeys.Service,
+	}, {
+		key:   "configuration-label-key",
+		field: &c.LabelKeys.Configuration,
+	}, {
+		key:   "configuration-generation-label-key",
+		field: &c.LabelKeys.ConfigurationGeneration,
+	}, {
+		key:   "revision-label-key",
+		field: &c.LabelKeys.Revision,
+	}} {
+		if raw, ok := data[key.key]; !ok {
+			continue
+		} else if errs := validation.IsQualifiedName(raw); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid %s %q: %s", key.key, raw, strings.Join(errs, "; "))
+		} else {
+			*key.field = raw
+		}
+	}
+
+	if raw, ok := data["mass-deletion-threshold"]; !ok {
+		c.MassDeletionThreshold = 0
+	} else if val, err := strconv.Atoi(raw); err != nil {
+		return nil, fmt.Errorf("invalid mass-deletion-threshold %q: %v", raw, err)
+	} else if val < 0 {
+		return nil, errors.New("mass-deletion-threshold must be zero or greater")
+	} else {
+		c.MassDeletionThreshold = val
+	}
+
+	if raw, ok := data["adaptive-deletions"]; !ok {
+		c.AdaptiveDeletions = false
+	} else if val, err := strconv.ParseBool(raw); err != nil {
+		return nil, err
+	} else {
+		c.AdaptiveDeletions = val
+	}
+
+	if raw, ok := data["adaptive-latency-threshold"]; !ok {
+		c.Pressure.Latency = time.Second
+	} else if val, err := time.ParseDuration(raw); err != nil {
+		return nil, err
+	} else if val < 0 {
+		return nil, errors.New("adaptive-latency-threshold must be zero or greater")
+	} else {
+		c.Pressure.Latency = val
+	}
+
+	if raw, ok := data["adaptive-rejection-threshold"]; !ok {
+		c.Pressure.Rejections = 5
+	} else if val, err := strconv.Atoi(raw); err != nil {
+		return nil, fmt.Errorf("invalid adaptive-rejection-threshold %q: %v", raw, err)
+	} else if val < 0 {
+		return nil, errors.New("adaptive-rejection-threshold must be zero or greater")
+	} else {
+		c.Pressure.Rejections = val
+	}
+
+	if raw, ok := data["adaptive-window"]; !ok {
+		c.Pressure.Window = 2 * time.Minute
+	} else if val, err := time.ParseDuration(raw); err != nil {
+		return nil, err
+	} else if val <= 0 {
+		return nil, errors.New("adaptive-window must be greater than zero")
+	} else {
+		c.Pressure.Window = val
+	}
+
+	if raw, ok := data["sweep-child-resources"]; ok && strings.TrimSpace(raw) != "" {
+		for _, resource := range strings.Split(raw, ",") {
+			resource = strings.TrimSpace(resource)
+			if resource != SweepSecrets && resource != SweepConfigMaps {
+				return nil, fmt.Errorf("invalid sweep-child-resources %q: expected %s or %s", resource, SweepSecrets, SweepConfigMaps)
+			}
+			c.SweepChildResources = append(c.SweepChildResources, resource)
+		}
+	}
+
+	if raw, ok := data["sweep-name-pattern"]; ok && raw != "" {
+		re, err := regexp.Compile(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sweep-name-pattern: %v", err)
+		}
+		if !hasSubexp(re, "revision") {
+			return nil, fmt.Errorf("invalid sweep-name-pattern %q: missing the (?P<revision>...) group", raw)
+		}
+		c.SweepNamePattern = re
+	}
+
+	if raw, ok := data["sweep-min-age"]; !ok {
+		c.SweepMinAge = time.Hour
+	} else if val, err := time.ParseDuration(raw); err != nil {
+		return nil, err
+	} else if val < 0 {
+		return nil, errors.New("sweep-min-age must be zero or greater")
+	} else {
+		c.SweepMinAge = val
+	}
+
+	if raw, ok := data["concurrency"]; !ok {
+		c.Concurrency = DefaultConcurrency
+	} else if val, err := strconv.Atoi(raw); err != nil {
+		return nil, err
+	} else if val < 1 || val > MaxConcurrency {
+		return nil, fmt.Errorf("concurrency must be between 1 and %d", MaxConcurrency)
+	} else {
+		c.Concurrency = val
+	}
+
+	if raw, ok := data["summary-interval"]; !ok {
+		c.SummaryInterval = 0
+	} else if val, err := time.ParseDuration(raw); err != nil {
+		return nil, err
+	} else if val < 0 {
+		return nil, errors.New("summary-interval must be zero or greater")
+	} else {
+		c.SummaryInterval = val
+	}
+
+	if raw, ok := data["stuck-failed-generations"]; !ok {
+		c.StuckFailedGenerations = 0
+	} else if val, err := strconv.Atoi(raw); err != nil {
+		return nil, err
+	} else if val < 0 {
+		return nil, errors.New("stuck-failed-generations must be zero or greater")
+	} else {
+		c.StuckFailedGenerations = val
+	}
+
+	if raw, ok := data["annotate-stuck-configurations"]; !ok {
+		c.AnnotateStuck = false
+	} else if val, err := strconv.ParseBool(raw); err != nil {
+		return nil, err
+	} else {
+		c.AnnotateStuck = val
+	}
+
+	if raw, ok := data["label-revision-state"]; !ok {
+		c.LabelRevisionState = false
+	} else if val, err := strconv.ParseBool(raw); err != nil {
+		return nil, err
+	} else {
+		c.LabelRevisionState = val
+	}
+
+	if raw, ok := data["rollback-window"]; !ok {
+		c.RollbackWindow = 0
+	} else if val, err := time.ParseDuration(raw); err != nil {
+		return nil, err
+	} else if val < 0 {
+		return nil, errors.New("rollback-window must be zero or greater")
+	} else {
+		c.RollbackWindow = val
+	}
+
+	if raw, ok := data["no-gc-annotations"]; !ok {
+		c.NoGCAnnotations = []string{strategy.NoGCAnnotationKey}
+	} else {
+		c.NoGCAnnotations = nil
+		for _, key := range strings.Split(raw, ",") {
+			if key = strings.TrimSpace(key); key == "" {
+				continue
+			}
+			if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+				return nil, fmt.Errorf("invalid no-gc-annotations entry %q: %s", key, strings.Join(errs, "; "))
+			}
+			c.NoGCAnnotations = append(c.NoGCAnnotations, key)
+		}
+	}
+
+	c.RedactionSafeAnnotations = nil
+	for _, entry := range strings.Split(data["redaction-safe-annotations"], ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		if prefix := strings.TrimSuffix(entry, "*"); prefix == "" || strings.Contains(prefix, "*") {
+			return nil, fmt.Errorf("invalid redaction-safe-annotations entry %q: only a trailing * is allowed", entry)
+		}
+		c.RedactionSafeAnnotations = append(c.RedactionSafeAnnotations, entry)
+	}
+
+	if raw, ok := data["volume-claims"]; !ok {
+		c.VolumeClaims = strategy.VolumeClaimsIgnore
+	} else if val, err := strategy.ParseVolumeClaimPolicy(raw); err != nil {
+		return nil, err
+	} else {
+		c.VolumeClaims = val
+	}
+
+	if raw, ok := data["activation-cooldown"]; !ok {
+		c.ActivationCooldown = 0
+	} else if val, err := time.ParseDuration(raw); err != nil {
+		return nil, err
+	} else if val < 0 {
+		return nil, errors.New("activation-cooldown must be zero or greater")
+	} else {
+		c.ActivationCooldown = val
+	}
+
+	if raw, ok := data["notify-when-candidates-exceed"]; !ok {
+		c.NotifyWhenCandidatesExceed = 0
+	} else if val, err := strconv.Atoi(raw); err != nil {
+		return nil, err
+	} else if val < 0 {
+		return nil, errors.New("notify-when-candidates-exceed must be zero or greater")
+	} else {
+		c.NotifyWhenCandidatesExceed = val
+	}
+
+	if raw, ok := data["owner-key"]; ok && raw != "" {
+		if errs := validation.IsQualifiedName(raw); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid owner-key %q: %s", raw, strings.Join(errs, "; "))
+		}
+		c.OwnerKey = raw
+	}
+
+	if raw, ok := data["ownership-handoff-period"]; !ok {
+		c.OwnershipHandoffPeriod = 0
+	} else if val, err := time.ParseDuration(raw); err != nil {
+		return nil, err
+	} else if val < 0 {
+		return nil, errors.New("ownership-handoff-period must be zero or greater")
+	} else {
+		c.OwnershipHandoffPeriod = val
+	}
+	if c.OwnershipHandoffPeriod > 0 && c.OwnerKey == "" {
+		return nil, errors.New("ownership-handoff-period requires owner-key")
+	}
+
+	if raw, ok := data["rollback-freeze-period"]; !ok {
+		c.RollbackFreezePeriod = 0
+	} else if val, err := time.ParseDuration(raw); err != nil {
+		return nil, err
+	} else if val < 0 {
+		return nil, errors.New("rollback-freeze-period must be zero or greater")
+	} else {
+		c.RollbackFreezePeriod = val
+	}
+
+	if raw, ok := data["verify-steady-state"]; !ok {
+		c.VerifySteadyState = false
+	} else if val, err := strconv.ParseBool(raw); err != nil {
+		return nil, err
+	} else {
+		c.VerifySteadyState = val
+	}
+
+	if raw, ok := data["stalled-deletion-timeout"]; !ok {
+		c.StalledDeletionTimeout = DefaultStalledDeletionTimeout
+	} else if val, err := time.ParseDuration(raw); err != nil {
+		return nil, err
+	} else if val < 0 {
+		return nil, errors.New("stalled-deletion-timeout must be zero or greater")
+	} else {
+		c.StalledDeletionTimeout = val
+	}
+
+	c.StalledDeletionAction = StalledDeletionAlert
+	if raw, ok := data["stalled-deletion-action"]; ok && raw != "" {
+		if raw != StalledDeletionAlert && raw != StalledDeletionRetry {
+			return nil, fmt.Errorf("invalid stalled-deletion-action %q, use %s or %s", raw, StalledDeletionAlert, StalledDeletionRetry)
+		}
+		c.StalledDeletionAction = raw
+	}
+
+	c.RetentionMode = RetentionDelete
+	if raw, ok := data["retention-mode"]; ok && raw != "" {
+		if raw != RetentionDelete && raw != RetentionSuspend {
+			return nil, fmt.Errorf("invalid retention-mode %q, use %s or %s", raw, RetentionDelete, RetentionSuspend)
+		}
+		c.RetentionMode = raw
+	}
+
+	if raw, ok := data["suspend-ttl"]; !ok {
+		c.SuspendTTL = DefaultSuspendTTL
+	} else if val, err := time.ParseDuration(raw); err != nil {
+		return nil, err
+	} else if val <= 0 {
+		return nil, errors.New("suspend-ttl must be greater than zero")
+	} else {
+		c.SuspendTTL = val
+	}
+
+	if raw, ok := data["api-call-timeout"]; !ok {
+		c.APICallTimeout = DefaultAPICallTimeout
+	} else if val, err := time.ParseDuration(raw); err != nil {
+		return nil, err
+	} else if val < 0 {
+		return nil, errors.New("api-call-timeout must be zero or greater")
+	} else {
+		c.APICallTimeout = val
+	}
+
+	if raw, ok := data["namespace-tiers"]; ok && strings.TrimSpace(raw) != "" {
+		for _, entry := range strings.Split(raw, ",") {
+			parts := strings.SplitN(strings.TrimSpace(entry), "=", 2)
+			if len(parts) != 2 || parts[0] == "" {
+				return nil, fmt.Errorf("invalid namespace-tiers entry %q: expected namespace=tier", entry)
+			}
+			if _, err := path.Match(parts[0], ""); err != nil {
+				return nil, fmt.Errorf("invalid namespace-tiers entry %q: %v", entry, err)
+			}
+			val, err := strconv.Atoi(parts[1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid namespace-tiers entry %q: %v", entry, err)
+			} else if val < -MaxNamespaceTier || val > MaxNamespaceTier {
+				return nil, fmt.Errorf("namespace-tiers tier of %s must be between %d and %d", parts[0], -MaxNamespaceTier, MaxNamespaceTier)
+			}
+			c.NamespaceTiers = append(c.NamespaceTiers, NamespaceTier{Pattern: parts[0], Tier: val})
+		}
+	}
+
+	if raw, ok := data["shed-queue-depth"]; !ok {
+		c.ShedQueueDepth = 0
+	} else if val, err := strconv.Atoi(raw); err != nil {
+		return nil, err
+	} else if val < 0 {
+		return nil, errors.New("shed-queue-depth must be zero or greater")
+	} else {
+		c.ShedQueueDepth = val
+	}
+
+	if raw, ok := data["shed-below-tier"]; !ok {
+		c.ShedBelowTier = 0
+	} else if val, err := strconv.Atoi(raw); err != nil {
+		return nil, err
+	} else {
+		c.ShedBelowTier = val
+	}
+
+	if raw, ok := data["shed-retry-delay"]; !ok {
+		c.ShedRetryDelay = DefaultShedRetryDelay
+	} else if val, err := time.ParseDuration(raw); err != nil {
+		return nil, err
+	} else if val <= 0 {
+		return nil, errors.New("shed-retry-delay must be greater than zero")
+	} else {
+		c.ShedRetryDelay = val
+	}
+
+	return c, nil
+}
+
+// NamespaceTier ranks the namespaces matching Pattern, a path.Match pattern.
+// Higher tiers are reconciled first and shed last.
+type NamespaceTier struct {
+	Pattern string
+	Tier    int
+}
+
+// Tier returns the tier of the namespace, that of the first matching entry
+// of NamespaceTiers, 0 when none matches.
+func (c *GC) Tier(namespace string) int {
+	for _, t := range c.NamespaceTiers {
+		if ok, _ := path.Match(t.Pattern, namespace); ok {
+			return t.Tier
+		}
+	}
+	return 0
+}
+
+// Shed reports whether the Services of the namespace are shed at the work
+// queue depth.
+func (c *GC) Shed(namespace string, depth int) bool {
+	return c.ShedQueueDepth > 0 && depth > c.ShedQueueDepth && c.Tier(namespace) < c.ShedBelowTier
+}
+
+// Quotas returns the deletion quotas.
+func (c *GC) Quotas() quota.Quotas {
+	return quota.Quotas{
+		Global:    c.GlobalQuota,
+		Namespace: c.NamespaceQuota,
+		Team:      c.TeamQuota,
+	}
+}
+
+// Team returns the owner team of a Service with the labels, empty when
+// OwnerLabel is not set or the Service is not labeled.
+func (c *GC) Team(labels map[string]string) string {
+	if c.OwnerLabel == "" {
+		return ""
+	}
+	return labels[c.OwnerLabel]
+}
+
+// Policy returns the retention policy described by the GC settings.
+func (c *GC) Policy() strategy.Policy {
+	return strategy.Policy{
+		Name:            DefaultPolicyName,
+		Namespace:       c.Namespace,
+		RetainCount:     c.RetainCount,
+		MinStaleAge:     c.MinStaleAge,
+		ClockSkewMargin: c.ClockSkewMargin,
+		MaxClockSkew:    c.MaxClockSkew,
+		Labels:          c.LabelKeys,
+		DeleteWarm:      c.DeleteWarm,
+		MaxRevisions:    c.MaxRevisions,
+		KeepLastDeploys: c.KeepLastDeploys,
+
+		KeepAttested:          c.KeepAttested,
+		AttestationAnnotation: c.AttestationAnnotation,
+		AttestationValue:      c.AttestationValue,
+
+		QuotaPressure: strategy.QuotaPressurePolicy{
+			Enabled:     c.QuotaPressureThreshold > 0,
+			RetainCount: c.QuotaPressureRetainCount,
+			MinStaleAge: c.QuotaPressureMinStaleAge,
+		},
+
+		Git:                  c.Git,
+		ProtectedGitBranches: c.ProtectedGitBranches,
+		KeepGitTags:          c.KeepGitTags,
+
+		RollbackWindow:             c.RollbackWindow,
+		NoGCAnnotations:            c.NoGCAnnotations,
+		VolumeClaims:               c.VolumeClaims,
+		ActivationCooldown:         c.ActivationCooldown,
+		NotifyWhenCandidatesExceed: c.NotifyWhenCandidatesExceed,
+	}
+}
+
+// hasSubexp reports whether the expression has the named group.
+func hasSubexp(re *regexp.Regexp, name string) bool {
+	for _, n := range re.SubexpNames() {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,113 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// NotificationsConfigName is the name of the ConfigMap holding the
+	// notification sinks and thresholds.
+	NotificationsConfigName = "config-revision-gc-notifications"
+)
+
+// Notifications holds the notification sinks and thresholds.
+type Notifications struct {
+	// WebhookURL receives every notification as JSON.
+	WebhookURL string
+
+	// SlackWebhookURL is a Slack incoming webhook receiving summaries.
+	SlackWebhookURL string
+
+	// PagerDutyRoutingKey is the Events API v2 routing key that repeated
+	// failures are sent to.
+	PagerDutyRoutingKey string
+
+	// PlanSizeThreshold is the plan size above which a notification is sent.
+	// Zero disables the notification.
+	PlanSizeThreshold int
+
+	// FailureThreshold is the number of consecutive failures for a Service
+	// after which a notification is sent. Zero disables the notification.
+	FailureThreshold int
+}
+
+// NewNotificationsFromConfigMap creates a Notifications from the supplied ConfigMap.
+func NewNotificationsFromConfigMap(configMap *corev1.ConfigMap) (*Notifications, error) {
+	return NewNotificationsFromMap(configMap.Data)
+}
+
+// NewNotificationsFromMap creates a Notifications from the supplied map.
+func NewNotificationsFromMap(data map[string]string) (*Notifications, error) {
+	c := &Notifications{}
+
+	for _, u := range []struct {
+		key   string
+		field *string
+	}{{
+		key:   "webhook-url",
+		field: &c.WebhookURL,
+	}, {
+		key:   "slack-webhook-url",
+		field: &c.SlackWebhookURL,
+	}} {
+		raw, ok := data[u.key]
+		if !ok || raw == "" {
+			continue
+		}
+		if parsed, err := url.Parse(raw); err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", u.key, err)
+		} else if parsed.Scheme != "http" && parsed.Scheme != "https" {
+			return nil, fmt.Errorf("invalid %s %q: scheme must be http or https", u.key, raw)
+		}
+		*u.field = raw
+	}
+
+	c.PagerDutyRoutingKey = data["pagerduty-routing-key"]
+
+	for _, i := range []struct {
+		key          string
+		field        *int
+		defaultValue int
+	}{{
+		key:          "plan-size-threshold",
+		field:        &c.PlanSizeThreshold,
+		defaultValue: 0,
+	}, {
+		key:          "failure-threshold",
+		field:        &c.FailureThreshold,
+		defaultValue: 3,
+	}} {
+		if raw, ok := data[i.key]; !ok {
+			*i.field = i.defaultValue
+		} else if val, err := strconv.Atoi(raw); err != nil {
+			return nil, err
+		} else if val < 0 {
+			return nil, errors.New(i.key + " must be zero or greater")
+		} else {
+			*i.field = val
+		}
+	}
+
+	return c, nil
+}
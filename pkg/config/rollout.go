@@ -0,0 +1,87 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"hash/fnv"
+	"math"
+	"time"
+)
+
+// NamespaceRollout enables deletions for a share of the namespaces only,
+// growing over time, to ramp up the blast radius of the controller
+// gradually in large fleets. Every namespace hashes to a stable bucket from
+// 0 to 99 and is enabled while its bucket is below the percentage, so
+// raising the percentage only ever enables more namespaces.
+type NamespaceRollout struct {
+	// Percent is the percentage of namespaces enabled, 100 enables all.
+	Percent int
+
+	// Start and Duration ramp the percentage up linearly from Percent to
+	// 100 over Duration from Start. A zero Duration disables the ramp.
+	Start    time.Time
+	Duration time.Duration
+
+	// Salt is hashed with the namespace names, changing it reshuffles the
+	// buckets.
+	Salt string
+}
+
+// Limited reports whether the rollout holds back any namespace at now.
+func (r NamespaceRollout) Limited(now time.Time) bool {
+	return r.PercentAt(now) < 100
+}
+
+// PercentAt returns the percentage of namespaces enabled at now.
+func (r NamespaceRollout) PercentAt(now time.Time) int {
+	if r.Duration <= 0 || now.Before(r.Start) {
+		return r.Percent
+	}
+	elapsed := now.Sub(r.Start)
+	if elapsed >= r.Duration {
+		return 100
+	}
+	return r.Percent + int(int64(100-r.Percent)*int64(elapsed)/int64(r.Duration))
+}
+
+// Bucket returns the bucket of the namespace, from 0 to 99.
+func (r NamespaceRollout) Bucket(namespace string) int {
+	h := fnv.New64a()
+	h.Write([]byte(r.Salt))
+	h.Write([]byte{0})
+	h.Write([]byte(namespace))
+	return int(h.Sum64() % 100)
+}
+
+// Enabled reports whether deletions are enabled in the namespace at now.
+func (r NamespaceRollout) Enabled(namespace string, now time.Time) bool {
+	return r.Bucket(namespace) < r.PercentAt(now)
+}
+
+// EnabledAt returns when the ramp enables the namespace, false when it
+// never does.
+func (r NamespaceRollout) EnabledAt(namespace string) (time.Time, bool) {
+	bucket := r.Bucket(namespace)
+	if bucket < r.Percent {
+		return time.Time{}, true
+	}
+	if r.Duration <= 0 {
+		return time.Time{}, false
+	}
+	share := float64(bucket+1-r.Percent) / float64(100-r.Percent)
+	return r.Start.Add(time.Duration(math.Ceil(share * float64(r.Duration)))), true
+}
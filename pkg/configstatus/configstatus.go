@@ -0,0 +1,198 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package configstatus reports the configuration the controller runs with in
+// the status of the singleton RevisionGCConfig: the ConfigMap versions in
+// effect, the versions rejected by validation and when they were loaded.
+package configstatus
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	configv1alpha1 "github.com/knative-sample/revision-controller/pkg/apis/config/v1alpha1"
+	"github.com/knative-sample/revision-controller/pkg/config"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic"
+	"knative.dev/pkg/apis"
+	"knative.dev/pkg/configmap"
+)
+
+// retryInterval is the delay before a failed status update is retried.
+const retryInterval = 10 * time.Second
+
+var condSet = apis.NewLivingConditionSet(
+	configv1alpha1.ConditionGCConfigValid,
+	configv1alpha1.ConditionNotificationsConfigValid,
+)
+
+// Reporter keeps the status of the RevisionGCConfig in sync with the
+// configuration ConfigMaps it observes.
+type Reporter struct {
+	logger *zap.SugaredLogger
+	client dynamic.ResourceInterface
+
+	mu         sync.Mutex
+	configMaps map[string]*configv1alpha1.ConfigMapStatus
+
+	// changed signals a status to report
+	changed chan struct{}
+}
+
+// NewReporter returns a Reporter updating the RevisionGCConfig through the
+// dynamic client.
+func NewReporter(logger *zap.SugaredLogger, client dynamic.Interface) *Reporter {
+	return &Reporter{
+		logger:     logger,
+		client:     client.Resource(configv1alpha1.SchemeGroupVersion.WithResource(configv1alpha1.RevisionGCConfigsResource)),
+		configMaps: make(map[string]*configv1alpha1.ConfigMapStatus),
+		changed:    make(chan struct{}, 1),
+	}
+}
+
+// WatchConfigs observes the configuration ConfigMaps, validating them like
+// the config.Store loading them does.
+func (r *Reporter) WatchConfigs(cmw configmap.Watcher) {
+	cmw.Watch(config.GCConfigName, r.observer(func(cm *corev1.ConfigMap) error {
+		_, err := config.NewGCFromConfigMap(cm)
+		return err
+	}))
+	cmw.Watch(config.NotificationsConfigName, r.observer(func(cm *corev1.ConfigMap) error {
+		_, err := config.NewNotificationsFromConfigMap(cm)
+		return err
+	}))
+}
+
+// observer returns the observer recording the ConfigMaps validated by parse.
+func (r *Reporter) observer(parse func(*corev1.ConfigMap) error) configmap.Observer {
+	return func(cm *corev1.ConfigMap) {
+		err := parse(cm)
+
+		r.mu.Lock()
+		s, ok := r.configMaps[cm.Name]
+		if !ok {
+			s = &configv1alpha1.ConfigMapStatus{Name: cm.Name}
+			r.configMaps[cm.Name] = s
+		}
+		if err != nil {
+			s.RejectedResourceVersion = cm.ResourceVersion
+			s.Error = err.Error()
+		} else {
+			*s = configv1alpha1.ConfigMapStatus{
+				Name:            cm.Name,
+				ResourceVersion: cm.ResourceVersion,
+				Data:            cm.Data,
+				LastReloadTime:  metav1.Now(),
+			}
+		}
+		r.mu.Unlock()
+
+		select {
+		case r.changed <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Run reports the status whenever a ConfigMap changed until ctx is done.
+func (r *Reporter) Run(ctx context.Context) {
+	var retry <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-r.changed:
+		case <-retry:
+		}
+		retry = nil
+		if err := r.report(); err != nil {
+			r.logger.Errorf("config status report error: %s", err.Error())
+			retry = time.After(retryInterval)
+		}
+	}
+}
+
+// report writes the current status to the RevisionGCConfig, creating it when
+// it does not exist.
+func (r *Reporter) report() error {
+	existing, err := r.client.Get(configv1alpha1.RevisionGCConfigName, metav1.GetOptions{})
+	if apierrs.IsNotFound(err) {
+		existing = &unstructured.Unstructured{}
+		existing.SetAPIVersion(configv1alpha1.SchemeGroupVersion.String())
+		existing.SetKind("RevisionGCConfig")
+		existing.SetName(configv1alpha1.RevisionGCConfigName)
+		if existing, err = r.client.Create(existing, metav1.CreateOptions{}); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+
+	current := &configv1alpha1.RevisionGCConfig{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(existing.Object, current); err != nil {
+		return err
+	}
+	status := r.status(current.Status, current.Generation)
+
+	object, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&status)
+	if err != nil {
+		return err
+	}
+	existing.Object["status"] = object
+	_, err = r.client.UpdateStatus(existing, metav1.UpdateOptions{})
+	return err
+}
+
+// status returns the status of the observed ConfigMaps, keeping the
+// transition times of the conditions that did not change.
+func (r *Reporter) status(previous configv1alpha1.RevisionGCConfigStatus, generation int64) configv1alpha1.RevisionGCConfigStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	status := configv1alpha1.RevisionGCConfigStatus{}
+	status.Conditions = previous.Conditions
+	status.ObservedGeneration = generation
+	conditions := condSet.Manage(&status)
+	conditions.InitializeConditions()
+
+	for _, c := range []struct {
+		name      string
+		condition apis.ConditionType
+	}{
+		{config.GCConfigName, configv1alpha1.ConditionGCConfigValid},
+		{config.NotificationsConfigName, configv1alpha1.ConditionNotificationsConfigValid},
+	} {
+		s, ok := r.configMaps[c.name]
+		switch {
+		case !ok:
+			conditions.MarkUnknown(c.condition, "NotLoaded", "ConfigMap %s was not loaded yet", c.name)
+			continue
+		case s.Error != "":
+			conditions.MarkFalse(c.condition, "Rejected", "ConfigMap %s version %s was rejected, version %s stays in effect: %s",
+				c.name, s.RejectedResourceVersion, s.ResourceVersion, s.Error)
+		default:
+			conditions.MarkTrue(c.condition)
+		}
+		status.ConfigMaps = append(status.ConfigMaps, *s)
+	}
+	return status
+}
@@ -0,0 +1,146 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package connections reads the open connections of revisions from
+// Prometheus, so revisions still holding long-running connections (e.g.
+// websockets or gRPC streams) are not deleted under their clients.
+package connections
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"text/template"
+	"time"
+
+	"github.com/knative-sample/revision-controller/pkg/config"
+	"knative.dev/serving/pkg/apis/serving/v1alpha1"
+	resourcenames "knative.dev/serving/pkg/reconciler/service/resources/names"
+)
+
+var defaultClient = &http.Client{Timeout: 10 * time.Second}
+
+// QueryData is the data the query template is executed with.
+type QueryData struct {
+	Namespace     string
+	Service       string
+	Configuration string
+}
+
+// Prometheus reads open connections per revision with an instant query.
+type Prometheus struct {
+	// URL is the base URL of the Prometheus server.
+	URL string
+
+	// Query is executed with QueryData and returns one sample per revision.
+	Query *template.Template
+
+	// RevisionLabel is the label holding the revision name in the samples.
+	RevisionLabel string
+
+	Client *http.Client
+}
+
+// FromConfig returns the Prometheus source configured in cfg, or nil when no
+// Prometheus URL is configured.
+func FromConfig(cfg *config.GC) (*Prometheus, error) {
+	if cfg.ConnectionsPrometheusURL == "" {
+		return nil, nil
+	}
+	query, err := template.New("connections-query").Parse(cfg.ConnectionsQuery)
+	if err != nil {
+		return nil, err
+	}
+	return &Prometheus{
+		URL:           cfg.ConnectionsPrometheusURL,
+		Query:         query,
+		RevisionLabel: cfg.ConnectionsRevisionLabel,
+		Client:        defaultClient,
+	}, nil
+}
+
+// queryResponse is the subset of the Prometheus instant query response used.
+type queryResponse struct {
+	Status string `json:"status"`
+	Error  string `json:"error"`
+	Data   struct {
+		ResultType string `json:"resultType"`
+		Result     []struct {
+			Metric map[string]string `json:"metric"`
+			Value  []interface{}     `json:"value"`
+		} `json:"result"`
+	} `json:"data"`
+}
+
+// OpenConnections returns the open connections of the revisions of the
+// Service by revision name. Revisions without a sample are left out.
+func (p *Prometheus) OpenConnections(ctx context.Context, service *v1alpha1.Service) (map[string]float64, error) {
+	var query bytes.Buffer
+	if err := p.Query.Execute(&query, QueryData{
+		Namespace:     service.Namespace,
+		Service:       service.Name,
+		Configuration: resourcenames.Configuration(service),
+	}); err != nil {
+		return nil, fmt.Errorf("render connections query: %v", err)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, p.URL+"/api/v1/query?"+url.Values{"query": {query.String()}}.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	client := p.Client
+	if client == nil {
+		client = defaultClient
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("query prometheus: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var body queryResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("query prometheus: %s: %v", resp.Status, err)
+	}
+	if body.Status != "success" {
+		return nil, fmt.Errorf("query prometheus: %s: %s", resp.Status, body.Error)
+	}
+	if body.Data.ResultType != "vector" {
+		return nil, fmt.Errorf("query prometheus: expected a vector result, got %q", body.Data.ResultType)
+	}
+
+	open := make(map[string]float64, len(body.Data.Result))
+	for _, sample := range body.Data.Result {
+		revision := sample.Metric[p.RevisionLabel]
+		if revision == "" || len(sample.Value) != 2 {
+			continue
+		}
+		raw, ok := sample.Value[1].(string)
+		if !ok {
+			continue
+		}
+		val, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return nil, fmt.Errorf("query prometheus: invalid value %q for revision %s", raw, revision)
+		}
+		open[revision] += val
+	}
+	return open, nil
+}
@@ -0,0 +1,234 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/knative-sample/revision-controller/pkg/apicall"
+	"github.com/knative-sample/revision-controller/pkg/config"
+	"github.com/knative-sample/revision-controller/pkg/plan"
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const (
+	// DivergenceMissed counts deletion candidates the audit expects but
+	// no plan names.
+	DivergenceMissed = "missed"
+	// DivergenceStale counts planned revisions the audit retains.
+	DivergenceStale = "stale"
+
+	// auditGrace is how long a revision must have been eligible before a
+	// missing plan counts as a divergence, beyond the holds of the planner.
+	auditGrace = time.Minute
+)
+
+// auditor audits every Service periodically: it recomputes the deletions
+// expected for the Services of every namespace, independently of the
+// events driving the reconciles, and compares them with the plans the
+// planner recorded. Divergences point at missed events or bugs; they are
+// reported and the Service is evaluated again.
+type auditor struct {
+	logger *zap.SugaredLogger
+
+	mu       sync.Mutex
+	interval time.Duration
+	jitter   time.Duration
+	changed  chan struct{}
+}
+
+func newAuditor(logger *zap.SugaredLogger) *auditor {
+	return &auditor{logger: logger, changed: make(chan struct{}, 1)}
+}
+
+// observe records the audit interval and jitter of a loaded GC
+// configuration.
+func (a *auditor) observe(value interface{}) {
+	gc, ok := value.(*config.GC)
+	if !ok {
+		return
+	}
+	a.mu.Lock()
+	a.interval, a.jitter = gc.AuditInterval, gc.AuditJitter
+	a.mu.Unlock()
+	select {
+	case a.changed <- struct{}{}:
+	default:
+	}
+}
+
+// next returns the delay before the next audit, zero while disabled.
+func (a *auditor) next() time.Duration {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.interval <= 0 {
+		return 0
+	}
+	d := a.interval
+	if a.jitter > 0 {
+		d += time.Duration(rand.Int63n(int64(a.jitter)))
+	}
+	return d
+}
+
+// run calls audit on the jittered schedule until stopCh is closed.
+func (a *auditor) run(stopCh <-chan struct{}, audit func()) {
+	var timer *time.Timer
+	var fire <-chan time.Time
+	reset := func() {
+		if timer != nil {
+			timer.Stop()
+		}
+		timer, fire = nil, nil
+		if d := a.next(); d > 0 {
+			timer = time.NewTimer(d)
+			fire = timer.C
+		}
+	}
+	reset()
+	for {
+		select {
+		case <-stopCh:
+			if timer != nil {
+				timer.Stop()
+			}
+			return
+		case <-a.changed:
+			reset()
+		case <-fire:
+			audit()
+			timer = nil
+			reset()
+		}
+	}
+}
+
+// audit audits the Services of every namespace this replica reconciles.
+func (c *Reconciler) audit(ctx context.Context) {
+	services, err := c.serviceLister.List(labels.Everything())
+	if err != nil {
+		c.Logger.Errorf("audit list services error:%s", err.Error())
+		return
+	}
+	byNamespace := make(map[string][]string)
+	for _, service := range services {
+		if service.GetDeletionTimestamp() != nil {
+			continue
+		}
+		byNamespace[service.Namespace] = append(byNamespace[service.Namespace], service.Name)
+	}
+	namespaces := make([]string, 0, len(byNamespace))
+	for namespace := range byNamespace {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+
+	missed, stale := 0, 0
+	for _, namespace := range namespaces {
+		if !c.shards.Owns(namespace) || namespaceTerminating(c.namespaceLister, namespace) {
+			continue
+		}
+		for _, name := range byNamespace[namespace] {
+			m, s := c.auditService(ctx, namespace, name)
+			missed += m
+			stale += s
+		}
+	}
+	c.Logger.Infof("audit of %d namespaces found %d missed and %d stale deletions", len(namespaces), missed, stale)
+}
+
+// auditService recomputes the deletions expected for the Service and
+// compares them with its recorded plan. It returns the divergences found.
+func (c *Reconciler) auditService(ctx context.Context, namespace, name string) (missed, stale int) {
+	key := namespace + "/" + name
+	ctx = c.configStore.ToContext(ctx)
+	gc := config.FromContext(ctx).GC
+	ctx = apicall.WithTimeout(ctx, gc.APICallTimeout)
+	logger := c.Logger.With(zap.String("key", key))
+
+	service, err := c.serviceLister.Services(namespace).Get(name)
+	if err != nil {
+		return 0, 0
+	}
+	if gc.MaintenanceHold || c.relists.settling() > 0 || c.withheld.get(key) > 0 {
+		// The planner holds the plans back on purpose.
+		return 0, 0
+	}
+
+	// Audit with a read-only copy, in-flight protections are recorded by
+	// the planner only.
+	evaluator := *c.revisionEvaluator
+	evaluator.recordInFlight = false
+	result, err := evaluator.evaluate(ctx, service)
+	if err != nil {
+		logger.Errorf("audit service: %s evaluate revisions error:%s", key, err.Error())
+		return 0, 0
+	}
+	p, err := plan.FromAnnotations(service.Annotations)
+	if err != nil {
+		logger.Errorf("audit service: %s read plan error:%s", key, err.Error())
+		return 0, 0
+	}
+	planned := func(revision string) bool {
+		return p != nil && p.Contains(revision)
+	}
+
+	candidates := make(map[string]bool, len(result.Candidates))
+	grace := gc.CandidateConfirmationPeriod + gc.RelistSettlePeriod + auditGrace
+	now := c.clock.Now()
+	for _, d := range result.Candidates {
+		candidates[d.Revision.Name] = true
+		if !planned(d.Revision.Name) && !d.EligibleAt.IsZero() && now.Sub(d.EligibleAt) > grace {
+			logger.Warnf("audit service: %s revision:%s is a deletion candidate since %s but not planned", key, d.Revision.Name, d.EligibleAt)
+			missed++
+		}
+	}
+	if p != nil {
+		for _, revision := range p.Revisions {
+			if candidates[revision] {
+				continue
+			}
+			if _, err := c.revisionLister.Revisions(namespace).Get(revision); err != nil {
+				// Deleted meanwhile.
+				continue
+			}
+			logger.Warnf("audit service: %s revision:%s is planned for deletion but retained", key, revision)
+			stale++
+		}
+	}
+
+	if missed > 0 {
+		if err := c.statsReporter.ReportDivergence(DivergenceMissed, missed); err != nil {
+			logger.Errorf("report divergence error: %s", err.Error())
+		}
+	}
+	if stale > 0 {
+		if err := c.statsReporter.ReportDivergence(DivergenceStale, stale); err != nil {
+			logger.Errorf("report divergence error: %s", err.Error())
+		}
+	}
+	if missed > 0 || stale > 0 {
+		// Heal by evaluating the Service again.
+		c.enqueueKeyAfter(key, 0)
+	}
+	return missed, stale
+}
@@ -0,0 +1,201 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/knative-sample/revision-controller/pkg/clock"
+	"github.com/knative-sample/revision-controller/pkg/config"
+	"github.com/knative-sample/revision-controller/pkg/strategy"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+	"knative.dev/pkg/apis"
+	duckv1beta1 "knative.dev/pkg/apis/duck/v1beta1"
+	"knative.dev/pkg/logging"
+	autoscalingv1alpha1 "knative.dev/serving/pkg/apis/autoscaling/v1alpha1"
+	"knative.dev/serving/pkg/apis/serving/v1alpha1"
+	"knative.dev/serving/pkg/apis/serving/v1beta1"
+	palisters "knative.dev/serving/pkg/client/listers/autoscaling/v1alpha1"
+	listers "knative.dev/serving/pkg/client/listers/serving/v1alpha1"
+)
+
+// TestEvaluateAtFrozenClock evaluates the same objects with the clock frozen
+// at successive times, as the kubectl plugin previews them with --at, and
+// checks the minimum age, activation cooldown and rollback window expire
+// exactly when due.
+func TestEvaluateAtFrozenClock(t *testing.T) {
+	start := time.Date(2019, 8, 1, 12, 0, 0, 0, time.UTC)
+	keys := strategy.DefaultLabelKeys()
+	indexer := func() cache.Indexer {
+		return cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	}
+
+	revisionIndexer := indexer()
+	for _, re := range []struct {
+		name, generation string
+		age              time.Duration
+	}{
+		{"hello-00001", "1", 48 * time.Hour},
+		{"hello-00002", "2", 100 * time.Minute},
+		{"hello-00003", "3", 90 * time.Minute},
+		{"hello-00004", "4", time.Hour},
+	} {
+		revisionIndexer.Add(&v1alpha1.Revision{ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Name:      re.name,
+			Labels: map[string]string{
+				keys.Service:                 "hello",
+				keys.Configuration:           "hello",
+				keys.ConfigurationGeneration: re.generation,
+			},
+			CreationTimestamp: metav1.NewTime(start.Add(-re.age)),
+		}})
+	}
+
+	latest := true
+	route := &v1alpha1.Route{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "hello"}}
+	route.Status.Traffic = []v1alpha1.TrafficTarget{{TrafficTarget: v1beta1.TrafficTarget{
+		RevisionName: "hello-00004", LatestRevision: &latest, Percent: 100,
+	}}}
+	for _, c := range []apis.ConditionType{v1alpha1.RouteConditionAllTrafficAssigned, v1alpha1.RouteConditionIngressReady, v1alpha1.RouteConditionReady} {
+		route.Status.Conditions = append(route.Status.Conditions, apis.Condition{Type: c, Status: corev1.ConditionTrue})
+	}
+	routeIndexer := indexer()
+	routeIndexer.Add(route)
+
+	pa := &autoscalingv1alpha1.PodAutoscaler{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "hello-00001"}}
+	pa.Status.Conditions = duckv1beta1.Conditions{{
+		Type:               autoscalingv1alpha1.PodAutoscalerConditionActive,
+		Status:             corev1.ConditionTrue,
+		LastTransitionTime: apis.VolatileTime{Inner: metav1.NewTime(start.Add(-30 * time.Minute))},
+	}}
+	paIndexer := indexer()
+	paIndexer.Add(pa)
+
+	// Without a clock skew margin extending the minimum age, hello-00002 is
+	// too young until start+20m, hello-00001 cools down until start+30m and
+	// hello-00003, of the generation before the served one, is in the
+	// rollback window until start+2h.
+	gc := gcConfig(t, map[string]string{
+		"retain-count":        "0",
+		"min-stale-age":       "2h",
+		"clock-skew-margin":   "0s",
+		"activation-cooldown": "1h",
+		"rollback-window":     "3h",
+	})
+	ctx := logging.WithLogger(context.Background(), zap.NewNop().Sugar())
+	ctx = config.ToContext(ctx, &config.Config{GC: gc})
+	service := &v1alpha1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "hello"}}
+
+	tests := []struct {
+		name string
+		at   time.Duration
+		want map[string]strategy.Reason
+	}{{
+		name: "all protected",
+		want: map[string]strategy.Reason{
+			"hello-00001": strategy.ReasonActivated,
+			"hello-00002": strategy.ReasonTooYoung,
+			"hello-00003": strategy.ReasonRollbackWindow,
+			"hello-00004": strategy.ReasonRouted,
+		},
+	}, {
+		name: "just before the minimum age",
+		at:   20*time.Minute - time.Second,
+		want: map[string]strategy.Reason{
+			"hello-00001": strategy.ReasonActivated,
+			"hello-00002": strategy.ReasonTooYoung,
+			"hello-00003": strategy.ReasonRollbackWindow,
+			"hello-00004": strategy.ReasonRouted,
+		},
+	}, {
+		name: "minimum age reached",
+		at:   20 * time.Minute,
+		want: map[string]strategy.Reason{
+			"hello-00001": strategy.ReasonActivated,
+			"hello-00002": strategy.ReasonStale,
+			"hello-00003": strategy.ReasonRollbackWindow,
+			"hello-00004": strategy.ReasonRouted,
+		},
+	}, {
+		name: "just before the cooldown ends",
+		at:   30*time.Minute - time.Second,
+		want: map[string]strategy.Reason{
+			"hello-00001": strategy.ReasonActivated,
+			"hello-00002": strategy.ReasonStale,
+			"hello-00003": strategy.ReasonRollbackWindow,
+			"hello-00004": strategy.ReasonRouted,
+		},
+	}, {
+		name: "cooldown over",
+		at:   30 * time.Minute,
+		want: map[string]strategy.Reason{
+			"hello-00001": strategy.ReasonStale,
+			"hello-00002": strategy.ReasonStale,
+			"hello-00003": strategy.ReasonRollbackWindow,
+			"hello-00004": strategy.ReasonRouted,
+		},
+	}, {
+		name: "just before the rollback window ends",
+		at:   2*time.Hour - time.Second,
+		want: map[string]strategy.Reason{
+			"hello-00001": strategy.ReasonStale,
+			"hello-00002": strategy.ReasonStale,
+			"hello-00003": strategy.ReasonRollbackWindow,
+			"hello-00004": strategy.ReasonRouted,
+		},
+	}, {
+		name: "rollback window over",
+		at:   2 * time.Hour,
+		want: map[string]strategy.Reason{
+			"hello-00001": strategy.ReasonStale,
+			"hello-00002": strategy.ReasonStale,
+			"hello-00003": strategy.ReasonStale,
+			"hello-00004": strategy.ReasonRouted,
+		},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			e := &revisionEvaluator{
+				routeLister:    listers.NewRouteLister(routeIndexer),
+				revisionLister: listers.NewRevisionLister(revisionIndexer),
+				paLister:       palisters.NewPodAutoscalerLister(paIndexer),
+				clock:          clock.Frozen(start.Add(test.at)),
+			}
+			result, err := e.evaluate(ctx, service)
+			if err != nil {
+				t.Fatalf("evaluate() = %v", err)
+			}
+			got := make(map[string]strategy.Reason)
+			for _, decisions := range [][]strategy.Decision{result.Retained, result.Candidates} {
+				for _, d := range decisions {
+					got[d.Revision.Name] = d.Reason
+				}
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("evaluate() at %v = %v, want %v", start.Add(test.at), got, test.want)
+			}
+		})
+	}
+}
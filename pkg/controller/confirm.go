@@ -0,0 +1,91 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"sync"
+	"time"
+
+	"github.com/knative-sample/revision-controller/pkg/clock"
+	"github.com/knative-sample/revision-controller/pkg/strategy"
+)
+
+// candidateConfirmations records when the planner first found each revision
+// a deletion candidate, so its deletion is only planned once a later
+// evaluation, at least the confirmation period after, still finds it one.
+// A single evaluation right after a restart may see transient cache states;
+// two consistent ones far enough apart are unlikely to. The observations
+// are in memory on purpose: every controller start confirms again.
+type candidateConfirmations struct {
+	clock clock.Clock
+
+	mu        sync.Mutex
+	firstSeen map[string]map[string]time.Time
+}
+
+func newCandidateConfirmations(clock clock.Clock) *candidateConfirmations {
+	return &candidateConfirmations{
+		clock:     clock,
+		firstSeen: make(map[string]map[string]time.Time),
+	}
+}
+
+// confirm records the candidates of the Service key and returns those first
+// found at least period ago, and how long until the next one is confirmed,
+// zero when all are. Revisions no longer candidates are forgotten, so they
+// start over once they become candidates again.
+func (c *candidateConfirmations) confirm(key string, candidates []strategy.Decision, period time.Duration) ([]strategy.Decision, time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if period <= 0 {
+		delete(c.firstSeen, key)
+		return candidates, 0
+	}
+
+	now := c.clock.Now()
+	previous := c.firstSeen[key]
+	seen := make(map[string]time.Time, len(candidates))
+	var confirmed []strategy.Decision
+	var wait time.Duration
+	for _, d := range candidates {
+		first, ok := previous[d.Revision.Name]
+		if !ok {
+			first = now
+		}
+		seen[d.Revision.Name] = first
+		if remaining := first.Add(period).Sub(now); remaining > 0 {
+			if wait == 0 || remaining < wait {
+				wait = remaining
+			}
+			continue
+		}
+		confirmed = append(confirmed, d)
+	}
+	if len(seen) == 0 {
+		delete(c.firstSeen, key)
+	} else {
+		c.firstSeen[key] = seen
+	}
+	return confirmed, wait
+}
+
+// forget drops the observations of the Service key.
+func (c *candidateConfirmations) forget(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.firstSeen, key)
+}
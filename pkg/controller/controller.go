@@ -18,51 +18,343 @@ package controller
 
 import (
 	"context"
+	"time"
 
+	"github.com/knative-sample/revision-controller/pkg/backlog"
+	painformer "github.com/knative-sample/revision-controller/pkg/client/injection/informers/autoscaling/v1alpha1/podautoscaler"
+	"github.com/knative-sample/revision-controller/pkg/clock"
+	"github.com/knative-sample/revision-controller/pkg/crashreport"
+	"github.com/knative-sample/revision-controller/pkg/decisionlog"
+	"github.com/knative-sample/revision-controller/pkg/exemption"
+	"github.com/knative-sample/revision-controller/pkg/hooks"
+	"github.com/knative-sample/revision-controller/pkg/pressure"
+	"github.com/knative-sample/revision-controller/pkg/shard"
+	"github.com/knative-sample/revision-controller/pkg/summary"
+	"github.com/knative-sample/revision-controller/pkg/workspace"
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/labels"
+	deploymentinformer "knative.dev/pkg/injection/informers/kubeinformers/appsv1/deployment"
+	namespaceinformer "knative.dev/pkg/injection/informers/kubeinformers/corev1/namespace"
+	resourcequotainformer "knative.dev/pkg/injection/informers/kubeinformers/corev1/resourcequota"
 	servingclient "knative.dev/serving/pkg/client/injection/client"
 	configurationinformer "knative.dev/serving/pkg/client/injection/informers/serving/v1alpha1/configuration"
 	revisioninformer "knative.dev/serving/pkg/client/injection/informers/serving/v1alpha1/revision"
 	routeinformer "knative.dev/serving/pkg/client/injection/informers/serving/v1alpha1/route"
 	kserviceinformer "knative.dev/serving/pkg/client/injection/informers/serving/v1alpha1/service"
 
+	"github.com/knative-sample/revision-controller/pkg/chaos"
+	"github.com/knative-sample/revision-controller/pkg/config"
+	"github.com/knative-sample/revision-controller/pkg/priorityqueue"
+	"github.com/knative-sample/revision-controller/pkg/quota"
 	"k8s.io/client-go/tools/cache"
+	"knative.dev/pkg/configmap"
 	"knative.dev/pkg/controller"
+	"knative.dev/pkg/injection/clients/kubeclient"
 	"knative.dev/pkg/logging"
+	"knative.dev/pkg/system"
 	"knative.dev/serving/pkg/apis/serving/v1alpha1"
+	listers "knative.dev/serving/pkg/client/listers/serving/v1alpha1"
+	"knative.dev/serving/pkg/reconciler"
 )
 
 // NewController initializes the controller and is called by the generated code
 // Registers eventhandlers to enqueue events
 func NewController(
 	ctx context.Context,
+	cmw configmap.Watcher,
 ) *controller.Impl {
 	logger := logging.FromContext(ctx)
 	serviceInformer := kserviceinformer.Get(ctx)
 	routeInformer := routeinformer.Get(ctx)
 	configurationInformer := configurationinformer.Get(ctx)
 	revisionInformer := revisioninformer.Get(ctx)
+	deploymentInformer := deploymentinformer.Get(ctx)
+	paInformer := painformer.Get(ctx)
+	quotaInformer := resourcequotainformer.Get(ctx)
+	namespaceInformer := namespaceinformer.Get(ctx)
+
+	statsReporter, err := NewStatsReporter(ReconcilerName, workspace.FromContext(ctx))
+	if err != nil {
+		logger.Fatal(err)
+	}
 
 	c := &Reconciler{
-		serviceLister:     serviceInformer.Lister(),
-		revisionLister:    revisionInformer.Lister(),
-		revisionClientSet: servingclient.Get(ctx),
-		routeLister:       routeInformer.Lister(),
+		Base: reconciler.NewBase(ctx, ReconcilerName, cmw),
+		revisionEvaluator: &revisionEvaluator{
+			routeLister:         routeInformer.Lister(),
+			configurationLister: configurationInformer.Lister(),
+			revisionLister:      chaos.RevisionLister(revisionInformer.Lister()),
+			deploymentLister:    deploymentInformer.Lister(),
+			paLister:            paInformer.Lister(),
+			quotaLister:         quotaInformer.Lister(),
+			revisionClient:      servingclient.Get(ctx),
+			policies:            newPolicyTracker(clock.Real),
+			recordInFlight:      true,
+			clock:               clock.Real,
+			hooks:               hooks.FromContext(ctx),
+		},
+		serviceLister:       serviceInformer.Lister(),
+		configurationLister: configurationInformer.Lister(),
+		namespaceLister:     namespaceInformer.Lister(),
+		revisionClientSet:   servingclient.Get(ctx),
+		statsReporter:       statsReporter,
+		failures:            newFailureCounter(),
+		withheld:            newHeldDeletions(),
+		stalled:             newStalledDeletions(),
+		decisions:           decisionlog.FromContext(ctx),
+		relists:             newRelistGuard(clock.Real),
+		shards:              shard.FromContext(ctx),
+		staleBacklog:        backlog.FromContext(ctx),
+		resync:              newAdaptiveResync(logger, statsReporter, backlog.FromContext(ctx)),
+		summarizer:          summary.FromContext(ctx),
+		crashes:             crashreport.FromContext(ctx),
+		confirmations:       newCandidateConfirmations(clock.Real),
+		duplicates:          newDuplicateGenerations(),
+		audits:              newAuditor(logger),
 	}
 
-	impl := controller.NewImpl(c, logger, ReconcilerName)
+	impl := newImpl(ctx, c, logger, ReconcilerName)
+	queue := usePriorityQueue(impl, c.backlog)
+	if exemptions := exemption.FromContext(ctx); exemptions != nil {
+		c.exemptions = exemptions
+		// Evaluate the Services again with the new lists.
+		exemptions.OnChange(func() {
+			impl.GlobalResync(serviceInformer.Informer())
+		})
+	}
+	c.enqueueAfter = impl.EnqueueAfter
+	c.enqueueKeyAfter = impl.EnqueueKeyAfter
+	c.queueDepth = queue.Len
+	c.shards.OnAcquire(enqueueServicesOf(c.serviceLister, impl.Enqueue))
+
+	logger.Info("Setting up ConfigMap receivers")
+	c.configStore = config.NewStore(logger.Named("config-store"), func(_ string, value interface{}) {
+		c.policies.observe(value)
+		c.relists.observe(value)
+		c.resync.observe(value)
+		c.audits.observe(value)
+		impl.GlobalResync(serviceInformer.Informer())
+	})
+	c.configStore.WatchConfigs(cmw)
+	go c.resync.run(ctx.Done(), func() {
+		impl.GlobalResync(serviceInformer.Informer())
+	})
+	go c.audits.run(ctx.Done(), func() {
+		c.audit(ctx)
+	})
 
 	logger.Info("Setting up event handlers")
-	serviceInformer.Informer().AddEventHandler(controller.HandleAll(impl.Enqueue))
+	serviceInformer.Informer().AddEventHandler(handleChanged(impl.Enqueue, c.relists.spread(impl.EnqueueAfter), serviceChanged))
 
+	controllerOfAfter := enqueueControllerOfAfter(impl.EnqueueKeyAfter)
 	configurationInformer.Informer().AddEventHandler(cache.FilteringResourceEventHandler{
 		FilterFunc: controller.Filter(v1alpha1.SchemeGroupVersion.WithKind("Service")),
-		Handler:    controller.HandleAll(impl.EnqueueControllerOf),
+		Handler:    handleChanged(impl.EnqueueControllerOf, c.relists.spread(controllerOfAfter), configurationChanged),
 	})
 
 	routeInformer.Informer().AddEventHandler(cache.FilteringResourceEventHandler{
 		FilterFunc: controller.Filter(v1alpha1.SchemeGroupVersion.WithKind("Service")),
-		Handler:    controller.HandleAll(impl.EnqueueControllerOf),
+		Handler:    handleChanged(impl.EnqueueControllerOf, c.relists.spread(controllerOfAfter), routeChanged),
+	})
+	revisionInformer.Informer().AddEventHandler(c.relists.watch())
+
+	namespaceInformer.Informer().AddEventHandler(dropTerminated(logger, queue))
+	quotaInformer.Informer().AddEventHandler(quotaChanged(enqueueServicesOf(c.serviceLister, impl.Enqueue)))
+
+	return impl
+}
+
+// NewExecutorController initializes the controller that carries out the
+// deletion plans recorded on Services.
+func NewExecutorController(
+	ctx context.Context,
+	cmw configmap.Watcher,
+) *controller.Impl {
+	logger := logging.FromContext(ctx)
+	serviceInformer := kserviceinformer.Get(ctx)
+	routeInformer := routeinformer.Get(ctx)
+	configurationInformer := configurationinformer.Get(ctx)
+	revisionInformer := revisioninformer.Get(ctx)
+	deploymentInformer := deploymentinformer.Get(ctx)
+	paInformer := painformer.Get(ctx)
+	quotaInformer := resourcequotainformer.Get(ctx)
+	namespaceInformer := namespaceinformer.Get(ctx)
+
+	statsReporter, err := NewStatsReporter(ExecutorName, workspace.FromContext(ctx))
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	c := &Executor{
+		Base: reconciler.NewBase(ctx, ExecutorName, cmw),
+		revisionEvaluator: &revisionEvaluator{
+			routeLister:         routeInformer.Lister(),
+			configurationLister: configurationInformer.Lister(),
+			revisionLister:      chaos.RevisionLister(revisionInformer.Lister()),
+			deploymentLister:    deploymentInformer.Lister(),
+			paLister:            paInformer.Lister(),
+			quotaLister:         quotaInformer.Lister(),
+			revisionClient:      servingclient.Get(ctx),
+			policies:            newPolicyTracker(clock.Real),
+			clock:               clock.Real,
+			hooks:               hooks.FromContext(ctx),
+		},
+		serviceLister:     serviceInformer.Lister(),
+		namespaceLister:   namespaceInformer.Lister(),
+		revisionClientSet: servingclient.Get(ctx),
+		statsReporter:     statsReporter,
+		held:              newHeldDeletions(),
+		quota:             quota.NewTracker(kubeclient.Get(ctx), system.Namespace()),
+		kubeClient:        kubeclient.Get(ctx),
+		failures:          newFailureCounter(),
+		savings:           newSavingsTracker(),
+		decisions:         decisionlog.FromContext(ctx),
+		relists:           newRelistGuard(clock.Real),
+		shards:            shard.FromContext(ctx),
+		summarizer:        summary.FromContext(ctx),
+		crashes:           crashreport.FromContext(ctx),
+		apiPressure:       pressure.FromContext(ctx),
+		started:           clock.Real.Now(),
+	}
+
+	impl := newImpl(ctx, c, logger, ExecutorName)
+	queue := usePriorityQueue(impl, c.backlog)
+	if exemptions := exemption.FromContext(ctx); exemptions != nil {
+		c.exemptions = exemptions
+	}
+	c.enqueueAfter = impl.EnqueueAfter
+	c.shards.OnAcquire(enqueueServicesOf(c.serviceLister, impl.Enqueue))
+
+	logger.Info("Setting up ConfigMap receivers")
+	c.configStore = config.NewStore(logger.Named("config-store"), func(_ string, value interface{}) {
+		c.policies.observe(value)
+		c.relists.observe(value)
+		impl.GlobalResync(serviceInformer.Informer())
 	})
+	c.configStore.WatchConfigs(cmw)
+
+	logger.Info("Setting up event handlers")
+	serviceInformer.Informer().AddEventHandler(handleChanged(impl.Enqueue, c.relists.spread(impl.EnqueueAfter), planChanged))
+	routeInformer.Informer().AddEventHandler(c.relists.watch())
+	revisionInformer.Informer().AddEventHandler(c.relists.watch())
+	namespaceInformer.Informer().AddEventHandler(dropTerminated(logger, queue))
 
 	return impl
 }
+
+// NewSweeperController initializes the controller that deletes the child
+// resources of deleted revisions.
+func NewSweeperController(
+	ctx context.Context,
+	cmw configmap.Watcher,
+) *controller.Impl {
+	logger := logging.FromContext(ctx)
+	serviceInformer := kserviceinformer.Get(ctx)
+	revisionInformer := revisioninformer.Get(ctx)
+	namespaceInformer := namespaceinformer.Get(ctx)
+
+	statsReporter, err := NewStatsReporter(SweeperName, workspace.FromContext(ctx))
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	c := &Sweeper{
+		Base:            reconciler.NewBase(ctx, SweeperName, cmw),
+		revisionLister:  revisionInformer.Lister(),
+		serviceLister:   serviceInformer.Lister(),
+		namespaceLister: namespaceInformer.Lister(),
+		statsReporter:   statsReporter,
+		clock:           clock.Real,
+		shards:          shard.FromContext(ctx),
+		crashes:         crashreport.FromContext(ctx),
+		apiPressure:     pressure.FromContext(ctx),
+		relists:         newRelistGuard(clock.Real),
+	}
+
+	impl := newImpl(ctx, c, logger, SweeperName)
+	c.enqueueAfter = impl.EnqueueKeyAfter
+	c.shards.OnAcquire(impl.EnqueueKey)
+
+	logger.Info("Setting up ConfigMap receivers")
+	c.configStore = config.NewStore(logger.Named("config-store"), func(_ string, value interface{}) {
+		c.relists.observe(value)
+		c.enqueueNamespaces(impl.EnqueueKey)
+	})
+	c.configStore.WatchConfigs(cmw)
+
+	logger.Info("Setting up event handlers")
+	revisionInformer.Informer().AddEventHandler(enqueueNamespaceOf(impl.EnqueueKey))
+	revisionInformer.Informer().AddEventHandler(c.relists.watch())
+
+	return impl
+}
+
+// enqueueServicesOf returns a function enqueueing the Services of a
+// namespace, e.g. once this replica took it over.
+func enqueueServicesOf(lister listers.ServiceLister, enqueue func(interface{})) func(namespace string) {
+	return func(namespace string) {
+		services, err := lister.Services(namespace).List(labels.Everything())
+		if err != nil {
+			return
+		}
+		for _, service := range services {
+			enqueue(service)
+		}
+	}
+}
+
+// usePriorityQueue replaces the FIFO work queue of impl with one handing out
+// the Service keys with the largest backlog first.
+func usePriorityQueue(impl *controller.Impl, backlog func(item interface{}) int) *priorityqueue.Queue {
+	queue := priorityqueue.New(backlog)
+	impl.WorkQueue.ShutDown()
+	impl.WorkQueue = queue
+	return queue
+}
+
+type namespaceAggregationKey struct{}
+
+// WithNamespaceAggregation makes the controllers created with ctx label
+// their reconcile count and latency metrics with the namespace of the
+// Service keys instead of the keys, bounding their cardinality by the
+// number of namespaces.
+func WithNamespaceAggregation(ctx context.Context) context.Context {
+	return context.WithValue(ctx, namespaceAggregationKey{}, true)
+}
+
+// ImplStatsReporter returns the reporter of the reconcile metrics of the
+// controller named name, reporting them per namespace when ctx asks for it.
+// The workers running the controller report through it.
+func ImplStatsReporter(ctx context.Context, name string) (controller.StatsReporter, error) {
+	reporter, err := controller.NewStatsReporter(name)
+	if err != nil {
+		return nil, err
+	}
+	if aggregate, _ := ctx.Value(namespaceAggregationKey{}).(bool); aggregate {
+		reporter = namespaceReporter{reporter}
+	}
+	return reporter, nil
+}
+
+// newImpl returns the controller of the reconciler, reporting its reconcile
+// metrics per namespace when ctx asks for it.
+func newImpl(ctx context.Context, r controller.Reconciler, logger *zap.SugaredLogger, name string) *controller.Impl {
+	reporter, err := ImplStatsReporter(ctx, name)
+	if err != nil {
+		logger.Fatalw("Failed to initialize the stats reporter", zap.Error(err))
+	}
+	return controller.NewImplWithStats(r, logger, name, reporter)
+}
+
+// namespaceReporter reports the reconciles of a Service key under its
+// namespace.
+type namespaceReporter struct {
+	controller.StatsReporter
+}
+
+func (r namespaceReporter) ReportReconcile(duration time.Duration, key, success string) error {
+	if namespace, _, err := cache.SplitMetaNamespaceKey(key); err == nil {
+		key = namespace
+	}
+	return r.StatsReporter.ReportReconcile(duration, key, success)
+}
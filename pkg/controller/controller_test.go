@@ -0,0 +1,76 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// keyRecorder records the keys of the reported reconciles.
+type keyRecorder struct {
+	keys []string
+}
+
+func (r *keyRecorder) ReportQueueDepth(v int64) error {
+	return nil
+}
+
+func (r *keyRecorder) ReportReconcile(duration time.Duration, key, success string) error {
+	r.keys = append(r.keys, key)
+	return nil
+}
+
+func TestImplStatsReporter(t *testing.T) {
+	tests := []struct {
+		name      string
+		ctx       context.Context
+		aggregate bool
+	}{{
+		name: "per key",
+		ctx:  context.Background(),
+	}, {
+		name:      "per namespace",
+		ctx:       WithNamespaceAggregation(context.Background()),
+		aggregate: true,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reporter, err := ImplStatsReporter(tt.ctx, ReconcilerName)
+			if err != nil {
+				t.Fatalf("ImplStatsReporter() = %v", err)
+			}
+			if _, ok := reporter.(namespaceReporter); ok != tt.aggregate {
+				t.Errorf("ImplStatsReporter() = %T, aggregating %v, want %v", reporter, ok, tt.aggregate)
+			}
+		})
+	}
+}
+
+func TestNamespaceReporter(t *testing.T) {
+	recorder := &keyRecorder{}
+	reporter := namespaceReporter{recorder}
+	for _, key := range []string{"default/hello", "team-a/hello", "default/world", "a/b/c"} {
+		reporter.ReportReconcile(time.Second, key, "true")
+	}
+	want := []string{"default", "team-a", "default", "a/b/c"}
+	if !reflect.DeepEqual(recorder.keys, want) {
+		t.Errorf("reported keys = %v, want %v", recorder.keys, want)
+	}
+}
@@ -0,0 +1,42 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	"github.com/knative-sample/revision-controller/pkg/decisionlog"
+	"github.com/knative-sample/revision-controller/pkg/strategy"
+	"github.com/knative-sample/revision-controller/pkg/tracing"
+	"knative.dev/serving/pkg/apis/serving/v1alpha1"
+)
+
+// decision returns the decision log entry about the revisions of the
+// Service decided under the policy in the reconcile of ctx.
+func decision(ctx context.Context, service *v1alpha1.Service, policy string, decisions []strategy.Decision) decisionlog.Decision {
+	d := decisionlog.Decision{
+		DecisionID: tracing.DecisionID(ctx),
+		Namespace:  service.Namespace,
+		Service:    service.Name,
+		Policy:     policy,
+		Revisions:  make([]decisionlog.Revision, 0, len(decisions)),
+	}
+	for _, rd := range decisions {
+		d.Revisions = append(d.Revisions, decisionlog.Revision{Name: rd.Revision.Name, Reason: string(rd.Reason)})
+	}
+	return d
+}
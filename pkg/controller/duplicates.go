@@ -0,0 +1,74 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/knative-sample/revision-controller/pkg/strategy"
+	"github.com/knative-sample/revision-controller/pkg/tracing"
+	corev1 "k8s.io/api/core/v1"
+	"knative.dev/pkg/logging"
+	"knative.dev/serving/pkg/apis/serving/v1alpha1"
+)
+
+// duplicateGenerations remembers, per Service key, the duplicate generations
+// last reported, so every anomaly is reported once rather than on every
+// reconcile.
+type duplicateGenerations struct {
+	mu    sync.Mutex
+	byKey map[string]string
+}
+
+func newDuplicateGenerations() *duplicateGenerations {
+	return &duplicateGenerations{byKey: make(map[string]string)}
+}
+
+// set records the description of the duplicates of key, empty forgets it,
+// and reports whether it changed.
+func (t *duplicateGenerations) set(key, description string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.byKey[key] == description {
+		return false
+	}
+	if description == "" {
+		delete(t.byKey, key)
+	} else {
+		t.byKey[key] = description
+	}
+	return true
+}
+
+// reportDuplicates warns about revisions of the Service carrying the same
+// generation, once per change of the anomaly.
+func (c *Reconciler) reportDuplicates(ctx context.Context, service *v1alpha1.Service, duplicates []strategy.Duplicate) {
+	descriptions := make([]string, 0, len(duplicates))
+	for _, d := range duplicates {
+		descriptions = append(descriptions, d.String())
+	}
+	description := strings.Join(descriptions, "; ")
+	if !c.duplicates.set(service.Namespace+"/"+service.Name, description) || description == "" {
+		return
+	}
+
+	logging.FromContext(ctx).Warnf("controller reconcile service: %s/%s duplicate generations: %s", service.Namespace, service.Name, description)
+	tracing.EventRecorder(ctx, c.Recorder).Eventf(service, corev1.EventTypeWarning, "DuplicateGeneration",
+		"Found revisions with the same generation label, the revision controlled by the Configuration and created last is taken for it: %s", description)
+}
@@ -0,0 +1,55 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	"github.com/knative-sample/revision-controller/pkg/gcerrors"
+	"github.com/knative-sample/revision-controller/pkg/plan"
+	"github.com/knative-sample/revision-controller/pkg/strategy"
+	"github.com/knative-sample/revision-controller/pkg/tracing"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+	"knative.dev/pkg/logging"
+	"knative.dev/serving/pkg/apis/serving/v1alpha1"
+)
+
+// reportError records err in a warning event of the Service with the type of
+// err as reason, and counts it by type.
+func reportError(ctx context.Context, recorder record.EventRecorder, statsReporter StatsReporter, service *v1alpha1.Service, err error) {
+	errType := gcerrors.TypeOf(err)
+	tracing.EventRecorder(ctx, recorder).Event(service, corev1.EventTypeWarning, string(errType), err.Error())
+	if err := statsReporter.ReportError(errType); err != nil {
+		logging.FromContext(ctx).Errorf("report reconcile error error: %s", err.Error())
+	}
+}
+
+// retainedPlanned returns the conflict of the plan with the revisions of it
+// the policy retains by now, nil when there are none.
+func retainedPlanned(p *plan.Plan, result *strategy.Result) *gcerrors.ProtectedConflict {
+	retained := make(map[string]string)
+	for _, d := range result.Retained {
+		if p.Contains(d.Revision.Name) {
+			retained[d.Revision.Name] = string(d.Reason)
+		}
+	}
+	if len(retained) == 0 {
+		return nil
+	}
+	return &gcerrors.ProtectedConflict{Revisions: retained}
+}
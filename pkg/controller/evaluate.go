@@ -0,0 +1,258 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	"github.com/knative-sample/revision-controller/pkg/apicall"
+	"github.com/knative-sample/revision-controller/pkg/clock"
+	"github.com/knative-sample/revision-controller/pkg/clockskew"
+	"github.com/knative-sample/revision-controller/pkg/config"
+	"github.com/knative-sample/revision-controller/pkg/connections"
+	"github.com/knative-sample/revision-controller/pkg/exemption"
+	"github.com/knative-sample/revision-controller/pkg/explain"
+	"github.com/knative-sample/revision-controller/pkg/footprint"
+	"github.com/knative-sample/revision-controller/pkg/gc"
+	"github.com/knative-sample/revision-controller/pkg/gcerrors"
+	"github.com/knative-sample/revision-controller/pkg/hooks"
+	"github.com/knative-sample/revision-controller/pkg/quota"
+	"github.com/knative-sample/revision-controller/pkg/resourcequota"
+	"github.com/knative-sample/revision-controller/pkg/strategy"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	appslisters "k8s.io/client-go/listers/apps/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"knative.dev/pkg/logging"
+	autoscalingv1alpha1 "knative.dev/serving/pkg/apis/autoscaling/v1alpha1"
+	"knative.dev/serving/pkg/apis/serving/v1alpha1"
+	versioned "knative.dev/serving/pkg/client/clientset/versioned"
+	palisters "knative.dev/serving/pkg/client/listers/autoscaling/v1alpha1"
+	listers "knative.dev/serving/pkg/client/listers/serving/v1alpha1"
+	resourcenames "knative.dev/serving/pkg/reconciler/service/resources/names"
+)
+
+// revisionEvaluator evaluates the revisions of a Service against the policy
+// attached to the context. It reads the Service snapshots the gc engine
+// decides on and is shared by the planner and the executor.
+type revisionEvaluator struct {
+	routeLister listers.RouteLister
+	// configurationLister reads the Configurations the revision generations
+	// are checked against, nil when they are not checked
+	configurationLister listers.ConfigurationLister
+	revisionLister      listers.RevisionLister
+	deploymentLister    appslisters.DeploymentLister
+	paLister            palisters.PodAutoscalerLister
+	quotaLister         corelisters.ResourceQuotaLister
+
+	// revisionClient reads revisions bypassing the cache
+	revisionClient versioned.Interface
+
+	// policies tracks the policy changes the in-flight protections are
+	// migrated across, nil when they are not migrated
+	policies *policyTracker
+	// recordInFlight records the migrated protections on the revisions,
+	// set for the planner only so each migration is written once
+	recordInFlight bool
+
+	// exemptions exempts Services following external lists, nil when no
+	// exemption source is configured
+	exemptions exemption.Provider
+
+	// hooks asks the deletion check hooks about every candidate, nil when
+	// none is configured
+	hooks *hooks.Checker
+
+	// clock tells the time the ages and cool-downs are computed against
+	clock clock.Clock
+}
+
+// evaluate splits the revisions of the Service into retained revisions and
+// deletion candidates.
+func (e *revisionEvaluator) evaluate(ctx context.Context, service *v1alpha1.Service) (*strategy.Result, error) {
+	s, err := e.snapshot(ctx, service)
+	if err != nil {
+		return nil, err
+	}
+	engine := gc.New(config.FromContext(ctx).GC)
+	in, err := engine.Inputs(s)
+	if err != nil {
+		return nil, err
+	}
+	if err := e.migrateInFlight(ctx, service, &in); err != nil {
+		return nil, err
+	}
+	result, err := strategy.Evaluate(engine.Policy(), in)
+	if err != nil {
+		return nil, err
+	}
+	if result.SkipReason == strategy.ReasonRoutedRevisionMissing {
+		e.confirmRoutedRevision(ctx, service, result.RoutedRevision)
+	}
+	e.checkHooks(ctx, service, result)
+	return result, nil
+}
+
+// checkHooks retains the candidates a deletion check hook vetoes.
+func (e *revisionEvaluator) checkHooks(ctx context.Context, service *v1alpha1.Service, result *strategy.Result) {
+	if e.hooks == nil || len(result.Candidates) == 0 {
+		return
+	}
+	logger := logging.FromContext(ctx)
+	candidates := append([]strategy.Decision(nil), result.Candidates...)
+	for _, d := range candidates {
+		re := d.Revision
+		allowed, message := e.hooks.Check(ctx, hooks.Request{
+			Namespace:   service.Namespace,
+			Service:     service.Name,
+			Revision:    re.Name,
+			Generation:  d.Generation,
+			Reason:      string(d.Reason),
+			CreatedAt:   strategy.CreatedAt(re),
+			Labels:      re.Labels,
+			Annotations: re.Annotations,
+		})
+		if !allowed {
+			logger.Infof("service: %s/%s revision:%s %s", service.Namespace, service.Name, re.Name, message)
+			result.Veto(re.Name, message)
+		}
+	}
+}
+
+// confirmRoutedRevision reads the revision the Route sends its traffic to,
+// missing from the revision cache, from the API server to tell which cache is
+// behind. Deletions are deferred either way until the caches agree, a stale
+// Route must not make the revisions it still routes to look stale.
+func (e *revisionEvaluator) confirmRoutedRevision(ctx context.Context, service *v1alpha1.Service, name string) {
+	logger := logging.FromContext(ctx)
+	_, err := apicall.GetRevision(ctx, e.revisionClient, service.Namespace, name)
+	if apierrs.IsNotFound(err) {
+		logger.Infof("service: %s/%s routed revision %s does not exist, deferring deletions until the Route catches up", service.Namespace, service.Name, name)
+	} else if err != nil {
+		logger.Errorf("service: %s/%s read routed revision %s error:%s", service.Namespace, service.Name, name, err.Error())
+	} else {
+		logger.Infof("service: %s/%s routed revision %s missing from the revision cache, deferring deletions until it catches up", service.Namespace, service.Name, name)
+	}
+}
+
+// explain explains why the named revision of the Service is still there,
+// given the deletion quota remaining for its namespace.
+func (e *revisionEvaluator) explain(ctx context.Context, service *v1alpha1.Service, name string, remaining []quota.Remaining) (*explain.Explanation, error) {
+	s, err := e.snapshot(ctx, service)
+	if err != nil {
+		return nil, err
+	}
+	return gc.New(config.FromContext(ctx).GC).Explain(s, name, remaining)
+}
+
+// snapshot reads the objects the revisions of the Service are evaluated
+// against from the listers.
+func (e *revisionEvaluator) snapshot(ctx context.Context, service *v1alpha1.Service) (gc.Snapshot, error) {
+	cfg := config.FromContext(ctx).GC
+
+	route, err := e.routeLister.Routes(service.Namespace).Get(resourcenames.Route(service))
+	if apierrs.IsNotFound(err) {
+		route = nil
+	} else if err != nil {
+		return gc.Snapshot{}, err
+	}
+
+	var configuration *v1alpha1.Configuration
+	if e.configurationLister != nil {
+		configuration, err = e.configurationLister.Configurations(service.Namespace).Get(resourcenames.Configuration(service))
+		if apierrs.IsNotFound(err) {
+			configuration = nil
+		} else if err != nil {
+			return gc.Snapshot{}, err
+		}
+	}
+
+	revisions, err := e.revisionLister.Revisions(service.Namespace).List(cfg.LabelKeys.RevisionSelector(service))
+	if err != nil {
+		return gc.Snapshot{}, err
+	}
+
+	var pas []*autoscalingv1alpha1.PodAutoscaler
+	for _, re := range revisions {
+		pa, err := e.paLister.PodAutoscalers(re.Namespace).Get(re.Name)
+		if apierrs.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return gc.Snapshot{}, err
+		}
+		pas = append(pas, pa)
+	}
+
+	// Defer deletions while open connections cannot be read.
+	var open map[string]float64
+	source, err := connections.FromConfig(cfg)
+	if err != nil {
+		return gc.Snapshot{}, &gcerrors.PolicyResolutionError{Err: err}
+	}
+	if source != nil {
+		if open, err = source.OpenConnections(ctx, service); err != nil {
+			return gc.Snapshot{}, &gcerrors.PolicyResolutionError{Err: err}
+		}
+	}
+
+	var exemptedBy string
+	if e.exemptions != nil {
+		exemptedBy, _ = e.exemptions.Exempt(service.Namespace, service.Name)
+	}
+
+	var pressure string
+	if cfg.QuotaPressureThreshold > 0 {
+		quotas, err := e.quotaLister.ResourceQuotas(service.Namespace).List(labels.Everything())
+		if err != nil {
+			return gc.Snapshot{}, err
+		}
+		pressure, _ = resourcequota.Pressure(quotas, cfg.QuotaPressureResources, cfg.QuotaPressureThreshold)
+	}
+
+	// Ages are computed on the API server clock that set the timestamps.
+	skew, _ := clockskew.Default.Offset()
+	return gc.Snapshot{
+		Service:        service,
+		Route:          route,
+		Configuration:  configuration,
+		Revisions:      revisions,
+		PodAutoscalers: pas,
+		Connections:    open,
+		Now:            e.clock.Now().Add(skew),
+		ClockSkew:      skew,
+		ExemptedBy:     exemptedBy,
+		QuotaPressure:  pressure,
+	}, nil
+}
+
+// footprint estimates the resources held by the revisions from their
+// Deployments, falling back to the revision spec.
+func (e *revisionEvaluator) footprint(decisions []strategy.Decision) footprint.Footprint {
+	total := footprint.Footprint{}
+	for _, d := range decisions {
+		re := d.Revision
+		deployments, err := e.deploymentLister.Deployments(re.Namespace).List(footprint.RevisionSelector(re.Name))
+		if err != nil || len(deployments) == 0 {
+			total.Add(footprint.FromRevision(re))
+			continue
+		}
+		for _, deployment := range deployments {
+			total.Add(footprint.FromDeployment(deployment))
+		}
+	}
+	return total
+}
@@ -0,0 +1,606 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/knative-sample/revision-controller/pkg/apicall"
+	"github.com/knative-sample/revision-controller/pkg/config"
+	"github.com/knative-sample/revision-controller/pkg/crashreport"
+	"github.com/knative-sample/revision-controller/pkg/decisionlog"
+	"github.com/knative-sample/revision-controller/pkg/drain"
+	"github.com/knative-sample/revision-controller/pkg/footprint"
+	"github.com/knative-sample/revision-controller/pkg/gcerrors"
+	"github.com/knative-sample/revision-controller/pkg/invalidation"
+	"github.com/knative-sample/revision-controller/pkg/notifier"
+	"github.com/knative-sample/revision-controller/pkg/plan"
+	"github.com/knative-sample/revision-controller/pkg/pressure"
+	"github.com/knative-sample/revision-controller/pkg/quota"
+	"github.com/knative-sample/revision-controller/pkg/shard"
+	"github.com/knative-sample/revision-controller/pkg/strategy"
+	"github.com/knative-sample/revision-controller/pkg/summary"
+	"github.com/knative-sample/revision-controller/pkg/tracing"
+	"go.opencensus.io/trace"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"knative.dev/pkg/controller"
+	"knative.dev/pkg/logging"
+	"knative.dev/serving/pkg/apis/serving/v1alpha1"
+	versioned "knative.dev/serving/pkg/client/clientset/versioned"
+	listers "knative.dev/serving/pkg/client/listers/serving/v1alpha1"
+	"knative.dev/serving/pkg/reconciler"
+	resourcenames "knative.dev/serving/pkg/reconciler/service/resources/names"
+)
+
+const (
+	// ExecutorName is the name of the executor reconciler
+	ExecutorName = "revision-executor"
+)
+
+// Executor implements controller.Reconciler for Service resources. It
+// carries out the deletion plan the planner recorded on a Service.
+type Executor struct {
+	*reconciler.Base
+
+	// evaluator lists and evaluates the revisions of a Service
+	*revisionEvaluator
+
+	// listers index properties about resources
+	serviceLister     listers.ServiceLister
+	namespaceLister   corelisters.NamespaceLister
+	revisionClientSet versioned.Interface
+
+	configStore   *config.Store
+	statsReporter StatsReporter
+
+	// failures counts consecutive failures to notify about
+	failures *failureCounter
+
+	// held tracks the deletions deferred by the maintenance hold
+	held *heldDeletions
+
+	// savings tracks the savings recorded on the Services
+	savings *savingsTracker
+
+	// quota grants deletions against the deletion quotas
+	quota *quota.Tracker
+
+	// decisions archives the deletions, nil when the decision log is
+	// disabled
+	decisions *decisionlog.Log
+
+	// relists holds the deletions while the caches settle after a relist
+	relists *relistGuard
+
+	// shards tells the namespaces this replica reconciles in active-active
+	// mode, nil otherwise
+	shards *shard.Coordinator
+
+	// summarizer aggregates the deletions of the workspace per namespace
+	summarizer *summary.Summarizer
+
+	// crashes records the keys and panics of the workspace for the crash
+	// report
+	crashes *crashreport.Recorder
+
+	// apiPressure tells whether the API server of the workspace is under
+	// pressure
+	apiPressure *pressure.Monitor
+
+	// kubeClient lists the pods of revisions whose connections are verified
+	// and deletes the claims of the revisions under VolumeClaimsCleanup
+	kubeClient kubernetes.Interface
+
+	// started is when the executor started, plans recorded before are held
+	// for the candidate confirmation period
+	started time.Time
+
+	// enqueueAfter requeues a Service, e.g. when its plan expires
+	enqueueAfter func(obj interface{}, after time.Duration)
+}
+
+// Check that our Executor implements controller.Reconciler
+var _ controller.Reconciler = (*Executor)(nil)
+
+// Reconcile deletes the revisions recorded in the plan of the Service that
+// are still deletion candidates, then removes the plan.
+func (c *Executor) Reconcile(ctx context.Context, key string) (err error) {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		c.Logger.Errorf("invalid resource key: %s", key)
+		return nil
+	}
+	if !c.shards.Owns(namespace) {
+		// Another replica deletes the revisions of the namespace.
+		return nil
+	}
+	ctx, decisionID := tracing.WithDecisionID(ctx)
+	logger := logging.FromContext(ctx)
+	c.crashes.Reconciling(ExecutorName, key)
+	defer recoverReconcile(ctx, c.crashes, ExecutorName, key, c.Recorder, c.statsReporter, serviceObject(c.serviceLister, namespace, name), &err)
+	ctx = c.configStore.ToContext(ctx)
+	ctx = apicall.WithTimeout(ctx, config.FromContext(ctx).GC.APICallTimeout)
+	ctx, span := trace.StartSpan(ctx, ExecutorName+"/Reconcile")
+	defer span.End()
+	span.AddAttributes(trace.StringAttribute("key", key), trace.StringAttribute(tracing.DecisionIDKey, decisionID))
+
+	if namespaceTerminating(c.namespaceLister, namespace) {
+		// The revisions are deleted with the namespace.
+		logger.Debugf("executor service: %s/%s namespace terminating, skipped", namespace, name)
+		c.reportHeld(key, 0)
+		return nil
+	}
+
+	original, err := c.serviceLister.Services(namespace).Get(name)
+	if apierrs.IsNotFound(err) {
+		c.reportHeld(key, 0)
+		c.observeSavings(key, nil)
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if original.GetDeletionTimestamp() != nil {
+		c.reportHeld(key, 0)
+		c.observeSavings(key, nil)
+		return nil
+	}
+	c.observeSavings(key, original)
+
+	p, err := plan.FromAnnotations(original.Annotations)
+	if err != nil {
+		// Drop the unreadable plan, the planner records a fresh one.
+		logger.Errorf("executor service: %s/%s error: %s", namespace, name, err.Error())
+		c.reportHeld(key, 0)
+		return c.clearPlan(ctx, original)
+	}
+	if p == nil {
+		c.reportHeld(key, 0)
+		return nil
+	}
+
+	if p.DecisionID != "" {
+		// Correlate the deletions with the decision that planned them.
+		logger = logger.With(zap.String("plannedBy", p.DecisionID))
+		ctx = logging.WithLogger(ctx, logger)
+	}
+
+	// Don't modify the informers copy
+	service := original.DeepCopy()
+
+	reconcileErr := c.execute(ctx, key, service, p)
+	c.failures.observe(ctx, service, reconcileErr)
+	if reconcileErr != nil {
+		reportError(ctx, c.Recorder, c.statsReporter, service, reconcileErr)
+		logger.Errorf("executor service: %s/%s error: %s ", service.Namespace, service.Name, reconcileErr.Error())
+		return reconcileErr
+	}
+	return nil
+}
+
+func (c *Executor) execute(ctx context.Context, key string, service *v1alpha1.Service, p *plan.Plan) error {
+	logger := logging.FromContext(ctx)
+	gc := config.FromContext(ctx).GC
+	policy := gc.Policy()
+
+	if gc.MaintenanceHold {
+		held := len(p.Revisions)
+		if previous := c.reportHeld(key, held); held != previous {
+			logger.Infof("executor service: %s/%s maintenance hold active, deferring %d deletions", service.Namespace, service.Name, held)
+			tracing.EventRecorder(ctx, c.Recorder).Eventf(service, corev1.EventTypeNormal, "DeletionHeld",
+				"Maintenance hold active, deferring deletion of %d revisions", held)
+		}
+		return nil
+	}
+	if rollout := gc.NamespaceRollout; !rollout.Enabled(service.Namespace, c.clock.Now()) {
+		held := len(p.Revisions)
+		if previous := c.reportHeld(key, held); held != previous {
+			logger.Infof("executor service: %s/%s namespace not enabled by the rollout at %d%% (bucket %d), deferring %d deletions",
+				service.Namespace, service.Name, rollout.PercentAt(c.clock.Now()), rollout.Bucket(service.Namespace), held)
+			tracing.EventRecorder(ctx, c.Recorder).Eventf(service, corev1.EventTypeNormal, "DeletionNotEnabled",
+				"Deletions are not enabled in namespace %s yet, at %d%% of the namespaces (bucket %d), deferring deletion of %d revisions",
+				service.Namespace, rollout.PercentAt(c.clock.Now()), rollout.Bucket(service.Namespace), held)
+		}
+		if at, ok := rollout.EnabledAt(service.Namespace); ok {
+			// Execute the plan once the ramp reaches the namespace.
+			c.enqueueAfter(service, at.Sub(c.clock.Now()))
+		}
+		return nil
+	}
+	c.reportHeld(key, 0)
+
+	if wait := c.relists.settling(); wait > 0 {
+		// The caches may disagree right after a relist.
+		logger.Infof("executor service: %s/%s informers relisted, deferring %d deletions by %s", service.Namespace, service.Name, len(p.Revisions), wait)
+		c.enqueueAfter(service, wait+c.relists.delay())
+		return nil
+	}
+
+	if period := gc.CandidateConfirmationPeriod; period > 0 && p.CreatedAt.Time.Before(c.started) {
+		// The plan predates this start, give the planner the time to
+		// confirm or replace it.
+		if wait := c.started.Add(period).Sub(c.clock.Now()); wait > 0 {
+			logger.Infof("executor service: %s/%s plan recorded before the start, deferring %d deletions by %s for confirmation", service.Namespace, service.Name, len(p.Revisions), wait)
+			c.enqueueAfter(service, wait)
+			return nil
+		}
+	}
+
+	if len(gc.DeletionWindows) > 0 {
+		now := c.clock.Now()
+		if !gc.DeletionWindows.Active(now) {
+			next := gc.DeletionWindows.NextStart(now)
+			logger.Infof("executor service: %s/%s outside of the deletion windows, next opens at %s", service.Namespace, service.Name, next)
+			if !next.IsZero() {
+				c.enqueueAfter(service, next.Sub(now))
+			}
+			return nil
+		}
+	}
+
+	if gc.ApprovalRequired {
+		approver := p.ApprovedBy(service.Annotations)
+		if approver == "" {
+			if p.ApprovalMismatch(service.Annotations) {
+				logger.Infof("executor service: %s/%s approval by %s does not name plan %s", service.Namespace, service.Name,
+					service.Annotations[plan.ApprovedByAnnotationKey], p.Hash())
+				tracing.EventRecorder(ctx, c.Recorder).Eventf(service, corev1.EventTypeWarning, "ApprovalMismatch",
+					"Approval by %s was given for plan %q, not the recorded plan %s", service.Annotations[plan.ApprovedByAnnotationKey],
+					service.Annotations[plan.ApprovedPlanAnnotationKey], p.Hash())
+			}
+			now := c.clock.Now()
+			if p.Expired(gc.PlanExpiry, now) {
+				logger.Infof("executor service: %s/%s plan created at %s expired unapproved", service.Namespace, service.Name, p.CreatedAt)
+				tracing.EventRecorder(ctx, c.Recorder).Eventf(service, corev1.EventTypeNormal, "PlanExpired",
+					"Deletion plan created at %s expired without approval", p.CreatedAt)
+				return c.clearPlan(ctx, service)
+			}
+			logger.Infof("executor service: %s/%s plan awaiting approval", service.Namespace, service.Name)
+			if gc.PlanExpiry > 0 {
+				c.enqueueAfter(service, p.CreatedAt.Add(gc.PlanExpiry).Sub(now))
+			}
+			return nil
+		}
+		logger.Infof("executor service: %s/%s plan %s approved by %s", service.Namespace, service.Name, p.Hash(), approver)
+	}
+
+	// Only delete what is still a candidate, the plan may be outdated.
+	result, err := c.evaluate(ctx, service)
+	if err != nil {
+		return err
+	}
+	if result.Skipped() {
+		outcomef(c.summarizer, logger)("executor service: %s/%s skipped: %s", service.Namespace, service.Name, result.SkipReason)
+		return nil
+	}
+	if conflict := retainedPlanned(p, result); conflict != nil {
+		// Only candidates are deleted, surface the outdated plan.
+		logger.Infof("executor service: %s/%s %s", service.Namespace, service.Name, conflict.Error())
+		reportError(ctx, c.Recorder, c.statsReporter, service, conflict)
+	}
+	for _, d := range result.Retained {
+		if d.Reason == strategy.ReasonActivated && p.Contains(d.Revision.Name) {
+			// Something still routes to the revision out-of-band.
+			logger.Infof("executor service: %s/%s revision:%s %s, deletion aborted", service.Namespace, service.Name, d.Revision.Name, d.Message)
+			tracing.EventRecorder(ctx, c.Recorder).Eventf(service, corev1.EventTypeWarning, "ActivationDetected",
+				"Revision %s planned for deletion was %s, deletion aborted", d.Revision.Name, d.Message)
+		}
+	}
+
+	// Delete the oldest revisions first when the quota only grants a part.
+	var planned []strategy.Decision
+	for i := len(result.Candidates) - 1; i >= 0; i-- {
+		if d := result.Candidates[i]; p.Contains(d.Revision.Name) {
+			planned = append(planned, d)
+		}
+	}
+	if rest, kept := keepLastReady(result, planned); kept != nil {
+		logger.Infof("executor service: %s/%s revision:%s is the last Ready revision, deletion refused", service.Namespace, service.Name, kept.Name)
+		tracing.EventRecorder(ctx, c.Recorder).Eventf(service, corev1.EventTypeWarning, "LastReadyRevisionKept",
+			"Refused to delete revision %s: it is the last Ready revision of the Service, which must keep one regardless of the policy", kept.Name)
+		planned = rest
+	}
+	now := c.clock.Now()
+	deferred := 0
+	if gc.AdaptiveDeletions {
+		if stressed, why := c.apiPressure.Stressed(gc.Pressure, now); stressed {
+			// Enforcing the revision cap is urgent, the rest can wait.
+			var urgent []strategy.Decision
+			for _, d := range planned {
+				if d.Reason == strategy.ReasonMaxRevisions {
+					urgent = append(urgent, d)
+				}
+			}
+			deferred = len(planned) - len(urgent)
+			if deferred > 0 {
+				logger.Infof("executor service: %s/%s API server under pressure (%s), deferring %d deletions", service.Namespace, service.Name, why, deferred)
+				tracing.EventRecorder(ctx, c.Recorder).Eventf(service, corev1.EventTypeNormal, "APIPressure",
+					"API server under pressure (%s), deferring deletion of %d of %d revisions", why, deferred, len(planned))
+				c.enqueueAfter(service, gc.Pressure.Window)
+			}
+			planned = urgent
+		}
+	}
+
+	if gc.RetentionMode == config.RetentionSuspend && len(planned) > 0 {
+		// Suspend the revisions first, deleting them once their TTL passed.
+		var pending int
+		if planned, pending = c.suspend(ctx, service, planned, gc.SuspendTTL, now); pending > 0 {
+			deferred += pending
+			logger.Infof("executor service: %s/%s %d revisions suspended, deferring their deletion", service.Namespace, service.Name, pending)
+		}
+	}
+
+	if hook := invalidation.FromConfig(gc); hook != nil && len(planned) > 0 {
+		var pending int
+		if planned, pending = c.invalidate(ctx, hook, service, planned); pending > 0 {
+			deferred += pending
+			logger.Infof("executor service: %s/%s awaiting cache invalidation, deferring %d deletions", service.Namespace, service.Name, pending)
+			tracing.EventRecorder(ctx, c.Recorder).Eventf(service, corev1.EventTypeNormal, "InvalidationPending",
+				"Awaiting cache invalidation, deferring deletion of %d revisions", pending)
+			c.enqueueAfter(service, gc.InvalidationPollInterval)
+		}
+	}
+
+	if verifier := drain.FromConfig(gc); verifier != nil && len(planned) > 0 {
+		var pending int
+		if planned, pending = c.verifyDrained(ctx, verifier, service, planned); pending > 0 {
+			deferred += pending
+			logger.Infof("executor service: %s/%s connections active, deferring %d deletions", service.Namespace, service.Name, pending)
+			tracing.EventRecorder(ctx, c.Recorder).Eventf(service, corev1.EventTypeNormal, "ConnectionsActive",
+				"Pods still hold active connections, deferring deletion of %d revisions", pending)
+			c.enqueueAfter(service, gc.DrainPollInterval)
+		}
+	}
+
+	if gc.IngressCheck && len(planned) > 0 {
+		var pending int
+		if planned, pending = c.verifyUnrouted(ctx, service, planned); pending > 0 {
+			deferred += pending
+			logger.Infof("executor service: %s/%s ingress still routing, deferring %d deletions", service.Namespace, service.Name, pending)
+			tracing.EventRecorder(ctx, c.Recorder).Eventf(service, corev1.EventTypeNormal, "IngressProgramming",
+				"The networking layer may still route to the revisions, deferring deletion of %d revisions", pending)
+			c.enqueueAfter(service, gc.IngressPollInterval)
+		}
+	}
+
+	team := gc.Team(service.Labels)
+	granted, err := c.quota.Reserve(ctx, service.Namespace, team, len(planned), gc.Quotas(), now)
+	if err != nil {
+		return err
+	}
+	if exhausted := len(planned) - granted; exhausted > 0 {
+		deferred += exhausted
+		reset := quota.NextReset(now)
+		logger.Infof("executor service: %s/%s deletion quota exhausted, deferring %d deletions until %s", service.Namespace, service.Name, exhausted, reset)
+		tracing.EventRecorder(ctx, c.Recorder).Eventf(service, corev1.EventTypeNormal, "QuotaExhausted",
+			"Deletion quota exhausted, deferring deletion of %d of %d revisions until %s", exhausted, len(planned), reset)
+		c.enqueueAfter(service, reset.Sub(now))
+	}
+
+	var deleted []string
+	var reclaimed []strategy.Decision
+	failed := 0
+	for _, d := range planned[:granted] {
+		re := d.Revision
+		err := apicall.DeleteRevision(ctx, c.revisionClientSet, service.Namespace, re.Name, &v1.DeleteOptions{})
+		if err != nil && !apierrs.IsNotFound(err) {
+			logger.Errorf("executor service: %s/%s delete revisions:%s error:%s", service.Namespace, service.Name, re.Name, err.Error())
+			failed++
+			continue
+		}
+		// Clean up the claims of revisions deleted by an earlier attempt too,
+		// it may have failed to.
+		if policy.VolumeClaims == strategy.VolumeClaimsCleanup {
+			if cerr := c.cleanupVolumeClaims(ctx, service, re); cerr != nil {
+				logger.Errorf("executor service: %s/%s delete claims of revision:%s error:%s", service.Namespace, service.Name, re.Name, cerr.Error())
+				failed++
+			}
+		}
+		if err != nil {
+			continue
+		}
+		deleted = append(deleted, re.Name)
+		reclaimed = append(reclaimed, d)
+	}
+	// Return what was granted but not deleted to the quota.
+	if err := c.quota.Release(ctx, service.Namespace, team, granted-len(deleted), gc.Quotas(), now); err != nil {
+		logger.Errorf("executor service: %s/%s release deletion quota error:%s", service.Namespace, service.Name, err.Error())
+	}
+	c.reportQuota(ctx, service.Namespace, team, gc, now)
+	if len(deleted) > 0 {
+		fp := c.footprint(reclaimed)
+		if err := c.statsReporter.ReportDeleted(ctx, policy, team, len(deleted), fp); err != nil {
+			logger.Errorf("report deleted revisions error: %s", err.Error())
+		}
+		if traceID := tracing.TraceID(ctx); traceID != "" {
+			outcomef(c.summarizer, logger)("executor service: %s/%s deleted revisions: %v trace: %s", service.Namespace, service.Name, deleted, traceID)
+		}
+		c.summarizer.Deleted(service.Namespace, len(deleted))
+		c.decisions.Record(decisionlog.TypeDeleted, decision(ctx, service, policy.Name, reclaimed), now)
+		c.reportLatency(service.Namespace, result, reclaimed, now)
+		c.recordSavings(ctx, key, service, len(deleted), now)
+		if gc.DeletedHistory > 0 {
+			c.recordDeleted(ctx, service, deleted, now, gc.DeletedHistory)
+		}
+		if !c.summarizer.Enabled() {
+			tracing.EventRecorder(ctx, c.Recorder).Eventf(service, corev1.EventTypeNormal, "RevisionsDeleted",
+				"Deleted %d revisions (%s), estimated reclaimed %s", len(deleted), strings.Join(deleted, ", "), fp)
+		}
+		notify(ctx, &notifier.Notification{
+			Kind:      notifier.KindDeleted,
+			Namespace: service.Namespace,
+			Service:   service.Name,
+			Revisions: deleted,
+			Message:   fmt.Sprintf("deleted %d revisions under policy %s, estimated reclaimed %s", len(deleted), policy.Name, fp),
+		})
+	}
+	c.summarizer.Deferred(service.Namespace, deferred)
+	if gc.VerifySteadyState && len(deleted) > 0 {
+		c.verifySteadyState(ctx, service, result, deleted)
+	}
+	if failed > 0 {
+		return &gcerrors.TransientAPIError{Err: fmt.Errorf("failed to delete %d of the planned revisions", failed)}
+	}
+	if deferred > 0 {
+		// Keep the plan for the deferred deletions.
+		return nil
+	}
+
+	return c.clearPlan(ctx, service)
+}
+
+// backlog returns the queue priority of a Service key: the number of
+// revisions in its plan, so catch-up sweeps delete the most first.
+func (c *Executor) backlog(item interface{}) int {
+	namespace, name, err := cache.SplitMetaNamespaceKey(item.(string))
+	if err != nil {
+		return 0
+	}
+	service, err := c.serviceLister.Services(namespace).Get(name)
+	if err != nil {
+		return 0
+	}
+	p, err := plan.FromAnnotations(service.Annotations)
+	if err != nil || p == nil {
+		return 0
+	}
+	return len(p.Revisions)
+}
+
+// invalidate asks the hook to invalidate the caches in front of the planned
+// revisions and returns those it confirmed, holding the others.
+func (c *Executor) invalidate(ctx context.Context, hook *invalidation.Hook, service *v1alpha1.Service, planned []strategy.Decision) ([]strategy.Decision, int) {
+	logger := logging.FromContext(ctx)
+
+	route, err := c.routeLister.Routes(service.Namespace).Get(resourcenames.Route(service))
+	if err != nil {
+		route = nil
+	}
+	var confirmed []strategy.Decision
+	for _, d := range planned {
+		ok, err := hook.Invalidate(ctx, invalidation.Request{
+			Namespace: service.Namespace,
+			Service:   service.Name,
+			Revision:  d.Revision.Name,
+			URLs:      invalidation.RevisionURLs(route, d.Revision.Name),
+		})
+		if err != nil {
+			// Hold the deletion, the caches may still point to the revision.
+			logger.Errorf("executor service: %s/%s invalidate revision:%s error:%s", service.Namespace, service.Name, d.Revision.Name, err.Error())
+			continue
+		}
+		if ok {
+			confirmed = append(confirmed, d)
+		}
+	}
+	return confirmed, len(planned) - len(confirmed)
+}
+
+// verifyDrained returns the planned revisions whose pods hold no active
+// connections according to the verifier, holding the others.
+func (c *Executor) verifyDrained(ctx context.Context, verifier drain.Verifier, service *v1alpha1.Service, planned []strategy.Decision) ([]strategy.Decision, int) {
+	logger := logging.FromContext(ctx)
+
+	var drained []strategy.Decision
+	for _, d := range planned {
+		pods, err := apicall.ListPods(ctx, c.kubeClient, service.Namespace, footprint.RevisionSelector(d.Revision.Name))
+		if err != nil {
+			logger.Errorf("executor service: %s/%s list pods of revision:%s error:%s", service.Namespace, service.Name, d.Revision.Name, err.Error())
+			continue
+		}
+		items := make([]*corev1.Pod, 0, len(pods.Items))
+		for i := range pods.Items {
+			items = append(items, &pods.Items[i])
+		}
+		ok, pod, err := drain.Drained(ctx, verifier, items)
+		if err != nil {
+			// Hold the deletion, the pod may still hold connections.
+			logger.Errorf("executor service: %s/%s verify connections of revision:%s pod:%s error:%s", service.Namespace, service.Name, d.Revision.Name, pod, err.Error())
+			continue
+		}
+		if !ok {
+			logger.Infof("executor service: %s/%s revision:%s pod:%s holds active connections", service.Namespace, service.Name, d.Revision.Name, pod)
+			continue
+		}
+		drained = append(drained, d)
+	}
+	return drained, len(planned) - len(drained)
+}
+
+// clearPlan removes the recorded plan from the Service.
+func (c *Executor) clearPlan(ctx context.Context, service *v1alpha1.Service) error {
+	patch, err := plan.MergePatch(nil)
+	if err != nil {
+		return err
+	}
+	_, err = apicall.PatchService(ctx, c.revisionClientSet, service.Namespace, service.Name, types.MergePatchType, patch)
+	if apierrs.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// reportHeld records the number of deletions held back for the Service key,
+// reports the cluster wide totals and returns the previously held count.
+func (c *Executor) reportHeld(key string, count int) int {
+	previous, services, revisions := c.held.set(key, count)
+	if err := c.statsReporter.ReportHeld(services, revisions); err != nil {
+		c.Logger.Errorf("report held deletions error: %s", err.Error())
+	}
+	return previous
+}
+
+// reportLatency reports how long the deleted revisions waited for their
+// deletion after they became eligible: stale, and past the minimum age.
+func (c *Executor) reportLatency(namespace string, result *strategy.Result, reclaimed []strategy.Decision, now time.Time) {
+	for _, d := range reclaimed {
+		since := result.StaleSince(d.Generation)
+		if d.EligibleAt.After(since) {
+			since = d.EligibleAt
+		}
+		if since.IsZero() || since.After(now) {
+			continue
+		}
+		if err := c.statsReporter.ReportDeletionLatency(namespace, now.Sub(since)); err != nil {
+			c.Logger.Errorf("report deletion latency error: %s", err.Error())
+		}
+	}
+}
+
+// reportQuota reports the deletions left in the quotas of the namespace and
+// the team.
+func (c *Executor) reportQuota(ctx context.Context, namespace, team string, gc *config.GC, now time.Time) {
+	remaining, err := c.quota.Remaining(ctx, namespace, team, gc.Quotas(), now)
+	if err != nil {
+		c.Logger.Errorf("read deletion quota error: %s", err.Error())
+		return
+	}
+	if err := c.statsReporter.ReportQuotaRemaining(remaining); err != nil {
+		c.Logger.Errorf("report deletion quota error: %s", err.Error())
+	}
+}
@@ -0,0 +1,450 @@
+//go:build chaos
+// +build chaos
+
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/knative-sample/revision-controller/pkg/chaos"
+	"github.com/knative-sample/revision-controller/pkg/clock"
+	"github.com/knative-sample/revision-controller/pkg/config"
+	"github.com/knative-sample/revision-controller/pkg/crashreport"
+	"github.com/knative-sample/revision-controller/pkg/plan"
+	"github.com/knative-sample/revision-controller/pkg/pressure"
+	"github.com/knative-sample/revision-controller/pkg/quota"
+	"github.com/knative-sample/revision-controller/pkg/strategy"
+	"github.com/knative-sample/revision-controller/pkg/summary"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	appslisters "k8s.io/client-go/listers/apps/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"knative.dev/pkg/apis"
+	"knative.dev/pkg/logging"
+	"knative.dev/serving/pkg/apis/serving/v1alpha1"
+	"knative.dev/serving/pkg/apis/serving/v1beta1"
+	versioned "knative.dev/serving/pkg/client/clientset/versioned"
+	palisters "knative.dev/serving/pkg/client/listers/autoscaling/v1alpha1"
+	listers "knative.dev/serving/pkg/client/listers/serving/v1alpha1"
+	"knative.dev/serving/pkg/reconciler"
+)
+
+const (
+	chaosNamespace = "default"
+	// chaosStale is the number of stale revisions of every Service.
+	chaosStale = 6
+)
+
+// apiServer fakes the API calls of the executor: revision deletions, Service
+// patches and the deletion quota ConfigMap. Deleted revisions leave the
+// revision cache, as the informer would have them.
+type apiServer struct {
+	services  cache.Indexer
+	revisions cache.Indexer
+
+	mu sync.Mutex
+	// deleted counts the successful deletions per revision.
+	deleted map[string]int
+	// quota holds the deletion quota ConfigMap, nil until it is created.
+	quota *corev1.ConfigMap
+}
+
+func (s *apiServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	resource, name := parts[len(parts)-2], parts[len(parts)-1]
+	if strings.HasSuffix(r.URL.Path, "/configmaps") {
+		resource, name = "configmaps", ""
+	}
+
+	switch {
+	case resource == "revisions" && r.Method == http.MethodDelete:
+		key := chaosNamespace + "/" + name
+		obj, ok, _ := s.revisions.GetByKey(key)
+		if !ok {
+			writeStatus(w, http.StatusNotFound, "NotFound")
+			return
+		}
+		s.revisions.Delete(obj)
+		s.deleted[name]++
+		writeStatus(w, http.StatusOK, "")
+
+	case resource == "services" && r.Method == http.MethodPatch:
+		obj, ok, _ := s.services.GetByKey(chaosNamespace + "/" + name)
+		if !ok {
+			writeStatus(w, http.StatusNotFound, "NotFound")
+			return
+		}
+		var patch struct {
+			Metadata struct {
+				Annotations map[string]*string `json:"annotations"`
+			} `json:"metadata"`
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &patch); err != nil {
+			writeStatus(w, http.StatusBadRequest, "BadRequest")
+			return
+		}
+		service := obj.(*v1alpha1.Service).DeepCopy()
+		if service.Annotations == nil {
+			service.Annotations = map[string]string{}
+		}
+		for key, value := range patch.Metadata.Annotations {
+			if value == nil {
+				delete(service.Annotations, key)
+			} else {
+				service.Annotations[key] = *value
+			}
+		}
+		s.services.Update(service)
+		service.TypeMeta = metav1.TypeMeta{APIVersion: "serving.knative.dev/v1alpha1", Kind: "Service"}
+		writeObject(w, service)
+
+	case resource == "configmaps" && r.Method == http.MethodGet:
+		if s.quota == nil {
+			writeStatus(w, http.StatusNotFound, "NotFound")
+			return
+		}
+		writeObject(w, s.quota)
+
+	case resource == "configmaps" && (r.Method == http.MethodPost || r.Method == http.MethodPut):
+		cm := &corev1.ConfigMap{}
+		body, _ := ioutil.ReadAll(r.Body)
+		if err := json.Unmarshal(body, cm); err != nil {
+			writeStatus(w, http.StatusBadRequest, "BadRequest")
+			return
+		}
+		cm.TypeMeta = metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"}
+		s.quota = cm
+		writeObject(w, cm)
+
+	default:
+		writeStatus(w, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+func writeStatus(w http.ResponseWriter, code int, reason string) {
+	status := metav1.Status{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Status"},
+		Status:   metav1.StatusSuccess,
+		Code:     int32(code),
+	}
+	if code != http.StatusOK {
+		status.Status, status.Reason = metav1.StatusFailure, metav1.StatusReason(reason)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(status)
+}
+
+func writeObject(w http.ResponseWriter, obj interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(obj)
+}
+
+// chaosService returns a Service whose Route sends all traffic to its latest
+// revision, with chaosStale older revisions, all recorded in its plan.
+func chaosService(name string, now time.Time) (*v1alpha1.Service, *v1alpha1.Route, []*v1alpha1.Revision) {
+	keys := strategy.DefaultLabelKeys()
+	var revisions []*v1alpha1.Revision
+	var planned []string
+	for gen := 1; gen <= chaosStale+1; gen++ {
+		re := &v1alpha1.Revision{ObjectMeta: metav1.ObjectMeta{
+			Namespace: chaosNamespace,
+			Name:      fmt.Sprintf("%s-%05d", name, gen),
+			Labels: map[string]string{
+				keys.Service:                 name,
+				keys.Configuration:           name,
+				keys.ConfigurationGeneration: fmt.Sprint(gen),
+			},
+			CreationTimestamp: metav1.NewTime(now.Add(-time.Duration(100-gen) * time.Hour)),
+		}}
+		revisions = append(revisions, re)
+		if gen <= chaosStale {
+			planned = append(planned, re.Name)
+		}
+	}
+
+	raw, _ := json.Marshal(plan.New("default", planned, metav1.NewTime(now.Add(-time.Hour))))
+	service := &v1alpha1.Service{ObjectMeta: metav1.ObjectMeta{
+		Namespace:   chaosNamespace,
+		Name:        name,
+		Annotations: map[string]string{plan.AnnotationKey: string(raw)},
+	}}
+
+	latest := true
+	route := &v1alpha1.Route{ObjectMeta: metav1.ObjectMeta{Namespace: chaosNamespace, Name: name}}
+	route.Status.Traffic = []v1alpha1.TrafficTarget{{TrafficTarget: v1beta1.TrafficTarget{
+		RevisionName:   revisions[chaosStale].Name,
+		LatestRevision: &latest,
+		Percent:        100,
+	}}}
+	for _, t := range []apis.ConditionType{
+		v1alpha1.RouteConditionAllTrafficAssigned,
+		v1alpha1.RouteConditionIngressReady,
+		v1alpha1.RouteConditionReady,
+	} {
+		route.Status.Conditions = append(route.Status.Conditions, apis.Condition{Type: t, Status: corev1.ConditionTrue})
+	}
+	return service, route, revisions
+}
+
+// chaosExecutor returns an executor of the Services against a fake API
+// server, with the revision deletions and the revision lister subject to
+// the injected failures.
+func chaosExecutor(t *testing.T, gcConfig map[string]string, services int) (*Executor, *apiServer, *eventLog, func()) {
+	logger := zap.NewNop().Sugar()
+	now := time.Now()
+
+	newIndexer := func() cache.Indexer {
+		return cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	}
+	serviceIndexer, routeIndexer, revisionIndexer := newIndexer(), newIndexer(), newIndexer()
+	for i := 0; i < services; i++ {
+		service, route, revisions := chaosService(fmt.Sprintf("hello%d", i), now)
+		serviceIndexer.Add(service)
+		routeIndexer.Add(route)
+		for _, re := range revisions {
+			revisionIndexer.Add(re)
+		}
+	}
+
+	api := &apiServer{services: serviceIndexer, revisions: revisionIndexer, deleted: map[string]int{}}
+	server := httptest.NewServer(api)
+	cfg := &rest.Config{Host: server.URL, QPS: 1000, Burst: 1000}
+	servingClient := versioned.NewForConfigOrDie(chaos.WrapConfig(cfg))
+	kubeClient := kubernetes.NewForConfigOrDie(cfg)
+
+	store := config.NewStore(logger)
+	store.OnConfigChanged(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: config.GCConfigName},
+		Data:       gcConfig,
+	})
+	store.OnConfigChanged(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: config.NotificationsConfigName},
+	})
+	statsReporter, err := NewStatsReporter(ExecutorName, "")
+	if err != nil {
+		t.Fatalf("NewStatsReporter() = %v", err)
+	}
+
+	recorder := &eventLog{events: map[string][]string{}}
+	c := &Executor{
+		Base: &reconciler.Base{Logger: logger, Recorder: recorder},
+		revisionEvaluator: &revisionEvaluator{
+			routeLister:      listers.NewRouteLister(routeIndexer),
+			revisionLister:   chaos.RevisionLister(listers.NewRevisionLister(revisionIndexer)),
+			deploymentLister: appslisters.NewDeploymentLister(newIndexer()),
+			paLister:         palisters.NewPodAutoscalerLister(newIndexer()),
+			quotaLister:      corelisters.NewResourceQuotaLister(newIndexer()),
+			revisionClient:   servingClient,
+			clock:            clock.Real,
+		},
+		serviceLister:     listers.NewServiceLister(serviceIndexer),
+		namespaceLister:   corelisters.NewNamespaceLister(newIndexer()),
+		revisionClientSet: servingClient,
+		configStore:       store,
+		statsReporter:     statsReporter,
+		failures:          newFailureCounter(),
+		held:              newHeldDeletions(),
+		savings:           newSavingsTracker(),
+		quota:             quota.NewTracker(kubeClient, "knative-serving"),
+		kubeClient:        kubeClient,
+		summarizer:        summary.New(),
+		crashes:           crashreport.New(32),
+		apiPressure:       &pressure.Monitor{},
+		started:           now,
+		enqueueAfter:      func(interface{}, time.Duration) {},
+	}
+	return c, api, recorder, server.Close
+}
+
+// runChaos reconciles every Service from its own goroutine, as the work
+// queue never hands out a key twice at a time, until its plan is carried
+// out or the attempts are used up.
+func runChaos(c *Executor, services, attempts int) {
+	var wg sync.WaitGroup
+	for i := 0; i < services; i++ {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			ctx := logging.WithLogger(context.Background(), zap.NewNop().Sugar())
+			for attempt := 0; attempt < attempts; attempt++ {
+				c.Reconcile(ctx, chaosNamespace+"/"+name)
+				service, err := c.serviceLister.Services(chaosNamespace).Get(name)
+				if err != nil {
+					return
+				}
+				if _, ok := service.Annotations[plan.AnnotationKey]; !ok {
+					return
+				}
+			}
+		}(fmt.Sprintf("hello%d", i))
+	}
+	wg.Wait()
+}
+
+// eventLog records the events by reason.
+type eventLog struct {
+	mu     sync.Mutex
+	events map[string][]string
+}
+
+func (l *eventLog) Event(object runtime.Object, eventtype, reason, message string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events[reason] = append(l.events[reason], message)
+}
+
+func (l *eventLog) Eventf(object runtime.Object, eventtype, reason, messageFmt string, args ...interface{}) {
+	l.Event(object, eventtype, reason, fmt.Sprintf(messageFmt, args...))
+}
+
+func (l *eventLog) PastEventf(object runtime.Object, timestamp metav1.Time, eventtype, reason, messageFmt string, args ...interface{}) {
+	l.Eventf(object, eventtype, reason, messageFmt, args...)
+}
+
+func (l *eventLog) AnnotatedEventf(object runtime.Object, annotations map[string]string, eventtype, reason, messageFmt string, args ...interface{}) {
+	l.Eventf(object, eventtype, reason, messageFmt, args...)
+}
+
+// reportedDeletions returns how often the executor reported deleting each
+// revision in its RevisionsDeleted events.
+func (l *eventLog) reportedDeletions() map[string]int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	reported := map[string]int{}
+	for _, message := range l.events["RevisionsDeleted"] {
+		names := message[strings.Index(message, "(")+1 : strings.Index(message, ")")]
+		for _, name := range strings.Split(names, ", ") {
+			reported[name]++
+		}
+	}
+	return reported
+}
+
+// withChaos injects the failures and returns the function restoring the
+// previous ones.
+func withChaos(o chaos.Options) func() {
+	previous := chaos.Set(o)
+	return func() { chaos.Set(previous) }
+}
+
+func TestExecutorUnderChaos(t *testing.T) {
+	const services = 4
+	defer withChaos(chaos.Options{
+		DeleteFailureRate: 0.3,
+		ListerDelay:       2 * time.Millisecond,
+		StaleCacheRate:    0.5,
+	})()
+	c, api, recorder, stop := chaosExecutor(t, map[string]string{
+		"relist-settle-period": "0s",
+	}, services)
+	defer stop()
+
+	runChaos(c, services, 100)
+
+	reported := recorder.reportedDeletions()
+	for i := 0; i < services; i++ {
+		name := fmt.Sprintf("hello%d", i)
+		service, _ := c.serviceLister.Services(chaosNamespace).Get(name)
+		if _, ok := service.Annotations[plan.AnnotationKey]; ok {
+			t.Errorf("Service %s still has a plan after retrying the injected failures", name)
+		}
+		for gen := 1; gen <= chaosStale+1; gen++ {
+			re := fmt.Sprintf("%s-%05d", name, gen)
+			want := 1
+			if gen > chaosStale {
+				// The routed revision is not planned.
+				want = 0
+			}
+			if got := api.deleted[re]; got != want {
+				t.Errorf("revision %s deleted %d times, want %d", re, got, want)
+			}
+			if got := reported[re]; got != want {
+				t.Errorf("revision %s reported deleted %d times, want %d", re, got, want)
+			}
+		}
+	}
+}
+
+func TestExecutorUnderChaosRespectsQuota(t *testing.T) {
+	const (
+		services = 4
+		budget   = 10
+	)
+	defer withChaos(chaos.Options{
+		DeleteFailureRate: 0.3,
+		ListerDelay:       2 * time.Millisecond,
+		StaleCacheRate:    0.5,
+	})()
+	c, api, recorder, stop := chaosExecutor(t, map[string]string{
+		"relist-settle-period":             "0s",
+		"namespace-max-deletions-per-hour": fmt.Sprint(budget),
+	}, services)
+	defer stop()
+
+	runChaos(c, services, 30)
+
+	deleted := 0
+	for name, n := range api.deleted {
+		if n > 1 {
+			t.Errorf("revision %s deleted %d times", name, n)
+		}
+		deleted += n
+	}
+	if deleted != budget {
+		t.Errorf("deleted %d revisions, want the quota of %d", deleted, budget)
+	}
+	for name, n := range recorder.reportedDeletions() {
+		if n != api.deleted[name] {
+			t.Errorf("revision %s reported deleted %d times, deleted %d times", name, n, api.deleted[name])
+		}
+	}
+
+	// Granted deletions that failed or found the revision gone are returned
+	// to the quota, the persisted count matches the deletions.
+	var counter struct {
+		HourCount int `json:"hourCount"`
+	}
+	if api.quota == nil {
+		t.Fatal("deletion quota not persisted")
+	}
+	if err := json.Unmarshal([]byte(api.quota.Data["namespace."+chaosNamespace]), &counter); err != nil {
+		t.Fatalf("read persisted quota: %v", err)
+	}
+	if counter.HourCount != deleted {
+		t.Errorf("persisted quota consumption = %d, want %d", counter.HourCount, deleted)
+	}
+}
@@ -0,0 +1,58 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"sync"
+)
+
+// heldDeletions tracks, per Service key, the number of deletions that are
+// held back, e.g. while the maintenance hold is active.
+type heldDeletions struct {
+	mu    sync.Mutex
+	byKey map[string]int
+}
+
+func newHeldDeletions() *heldDeletions {
+	return &heldDeletions{byKey: make(map[string]int)}
+}
+
+// get returns the held deletions of key.
+func (h *heldDeletions) get(key string) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.byKey[key]
+}
+
+// set records count held deletions for key and returns the previous count
+// together with the totals across all Services.
+func (h *heldDeletions) set(key string, count int) (previous, services, revisions int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	previous = h.byKey[key]
+	if count > 0 {
+		h.byKey[key] = count
+	} else {
+		delete(h.byKey, key)
+	}
+
+	for _, n := range h.byKey {
+		revisions += n
+	}
+	return previous, len(h.byKey), revisions
+}
@@ -0,0 +1,125 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/knative-sample/revision-controller/pkg/apicall"
+	"github.com/knative-sample/revision-controller/pkg/clock"
+	"github.com/knative-sample/revision-controller/pkg/config"
+	"github.com/knative-sample/revision-controller/pkg/strategy"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"knative.dev/pkg/logging"
+	"knative.dev/serving/pkg/apis/serving/v1alpha1"
+)
+
+// policyTracker remembers the policy in effect before the last policy
+// change, so the in-flight protections it started can be migrated to the
+// current one. Changes made while the controller is down are not seen.
+type policyTracker struct {
+	mu       sync.Mutex
+	current  *strategy.Policy
+	previous *strategy.Policy
+	// version is the version of current
+	version string
+	// changedAt is when current replaced previous
+	changedAt time.Time
+	// clock tells when a change is observed
+	clock clock.Clock
+}
+
+func newPolicyTracker(c clock.Clock) *policyTracker {
+	return &policyTracker{clock: c}
+}
+
+// observe records the policy of a loaded GC configuration. Configuration
+// changes leaving the policy as is are ignored.
+func (t *policyTracker) observe(value interface{}) {
+	gc, ok := value.(*config.GC)
+	if !ok {
+		return
+	}
+	policy := gc.Policy()
+	version := strategy.PolicyVersion(policy)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if version == t.version {
+		return
+	}
+	t.previous, t.current, t.version, t.changedAt = t.current, &policy, version, t.clock.Now()
+}
+
+// previousPolicy returns the policy in effect before the last change and
+// when it changed.
+func (t *policyTracker) previousPolicy() (strategy.Policy, time.Time, bool) {
+	if t == nil {
+		return strategy.Policy{}, time.Time{}, false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.previous == nil {
+		return strategy.Policy{}, time.Time{}, false
+	}
+	return *t.previous, t.changedAt, true
+}
+
+// migrateInFlight migrates the in-flight protections of the revisions to
+// the policy of the context, adding the migrated state to in. The state is
+// recorded on the revisions when the evaluator records it.
+func (e *revisionEvaluator) migrateInFlight(ctx context.Context, service *v1alpha1.Service, in *strategy.Inputs) error {
+	previous, changedAt, ok := e.policies.previousPolicy()
+	if !ok {
+		return nil
+	}
+	current := config.FromContext(ctx).GC.Policy()
+	version := strategy.PolicyVersion(current)
+	if strategy.PolicyVersion(previous) == version {
+		return nil
+	}
+	migrated, err := strategy.MigrateInFlight(previous, current, changedAt, *in)
+	if err != nil || len(migrated) == 0 {
+		return err
+	}
+
+	logger := logging.FromContext(ctx)
+	states := make(map[string]strategy.InFlight, len(in.InFlight)+len(migrated))
+	for name, state := range in.InFlight {
+		states[name] = state
+	}
+	for name, state := range migrated {
+		states[name] = state
+		if !e.recordInFlight {
+			continue
+		}
+		patch, err := strategy.InFlightMergePatch(version, state)
+		if err != nil {
+			return err
+		}
+		if _, err := apicall.PatchRevision(ctx, e.revisionClient, service.Namespace, name, types.MergePatchType, patch); err != nil && !apierrs.IsNotFound(err) {
+			logger.Errorf("service: %s/%s record in-flight protections of revision:%s error:%s", service.Namespace, service.Name, name, err.Error())
+			return err
+		}
+		logger.Infof("service: %s/%s migrated in-flight protections of revision:%s to policy version %s", service.Namespace, service.Name, name, version)
+	}
+	in.InFlight = states
+	return nil
+}
@@ -0,0 +1,163 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/knative-sample/revision-controller/pkg/clock"
+	"github.com/knative-sample/revision-controller/pkg/config"
+	"github.com/knative-sample/revision-controller/pkg/strategy"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"knative.dev/pkg/apis"
+	duckv1beta1 "knative.dev/pkg/apis/duck/v1beta1"
+	"knative.dev/pkg/logging"
+	autoscalingv1alpha1 "knative.dev/serving/pkg/apis/autoscaling/v1alpha1"
+	"knative.dev/serving/pkg/apis/serving/v1alpha1"
+	"knative.dev/serving/pkg/apis/serving/v1beta1"
+)
+
+// gcConfig returns the GC configuration of the data.
+func gcConfig(t *testing.T, data map[string]string) *config.GC {
+	t.Helper()
+	gc, err := config.NewGCFromMap(data)
+	if err != nil {
+		t.Fatalf("NewGCFromMap(%v) = %v", data, err)
+	}
+	return gc
+}
+
+func TestPolicyTracker(t *testing.T) {
+	start := time.Date(2019, 8, 1, 12, 0, 0, 0, time.UTC)
+	tracker := newPolicyTracker(clock.Frozen(start))
+	if _, _, ok := tracker.previousPolicy(); ok {
+		t.Fatal("previousPolicy() = true before any configuration")
+	}
+
+	first := gcConfig(t, map[string]string{"activation-cooldown": "2h"})
+	tracker.observe(first)
+	tracker.observe("not a GC configuration")
+	if _, _, ok := tracker.previousPolicy(); ok {
+		t.Fatal("previousPolicy() = true after the first configuration")
+	}
+
+	// Settings outside the policy leave it as is.
+	tracker.clock = clock.Frozen(start.Add(time.Hour))
+	tracker.observe(gcConfig(t, map[string]string{"activation-cooldown": "2h", "summary-interval": "5m"}))
+	if _, _, ok := tracker.previousPolicy(); ok {
+		t.Fatal("previousPolicy() = true after a change outside the policy")
+	}
+
+	tracker.clock = clock.Frozen(start.Add(2 * time.Hour))
+	tracker.observe(gcConfig(t, map[string]string{"activation-cooldown": "30m"}))
+	previous, changedAt, ok := tracker.previousPolicy()
+	if !ok {
+		t.Fatal("previousPolicy() = false after a policy change")
+	}
+	if !reflect.DeepEqual(previous, first.Policy()) {
+		t.Errorf("previousPolicy() = %+v, want %+v", previous, first.Policy())
+	}
+	if want := start.Add(2 * time.Hour); !changedAt.Equal(want) {
+		t.Errorf("previousPolicy() changed at %v, want %v", changedAt, want)
+	}
+
+	var none *policyTracker
+	if _, _, ok := none.previousPolicy(); ok {
+		t.Error("previousPolicy() = true without a tracker")
+	}
+}
+
+func TestMigrateInFlight(t *testing.T) {
+	now := time.Date(2019, 8, 1, 12, 0, 0, 0, time.UTC)
+	keys := strategy.DefaultLabelKeys()
+	revision := func(name, generation string, age time.Duration) *v1alpha1.Revision {
+		return &v1alpha1.Revision{ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Name:      name,
+			Labels: map[string]string{
+				keys.Service:                 "hello",
+				keys.Configuration:           "hello",
+				keys.ConfigurationGeneration: generation,
+			},
+			CreationTimestamp: metav1.NewTime(now.Add(-age)),
+		}}
+	}
+	latest := true
+	route := &v1alpha1.Route{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "hello"}}
+	route.Status.Traffic = []v1alpha1.TrafficTarget{{TrafficTarget: v1beta1.TrafficTarget{
+		RevisionName: "hello-00002", LatestRevision: &latest, Percent: 100,
+	}}}
+	for _, c := range []apis.ConditionType{v1alpha1.RouteConditionAllTrafficAssigned, v1alpha1.RouteConditionIngressReady, v1alpha1.RouteConditionReady} {
+		route.Status.Conditions = append(route.Status.Conditions, apis.Condition{Type: c, Status: corev1.ConditionTrue})
+	}
+	pa := &autoscalingv1alpha1.PodAutoscaler{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "hello-00001"}}
+	pa.Status.Conditions = duckv1beta1.Conditions{{
+		Type:               autoscalingv1alpha1.PodAutoscalerConditionActive,
+		Status:             corev1.ConditionTrue,
+		LastTransitionTime: apis.VolatileTime{Inner: metav1.NewTime(now.Add(-time.Hour))},
+	}}
+
+	tests := []struct {
+		name     string
+		previous map[string]string
+		current  map[string]string
+		want     map[string]strategy.InFlight
+	}{{
+		name:    "no policy change",
+		current: map[string]string{"activation-cooldown": "30m"},
+	}, {
+		name:     "cooldown shortened",
+		previous: map[string]string{"activation-cooldown": "2h"},
+		current:  map[string]string{"activation-cooldown": "30m"},
+		want:     map[string]strategy.InFlight{"hello-00001": {strategy.ReasonActivated: now.Add(time.Hour)}},
+	}, {
+		name:     "cooldown lengthened",
+		previous: map[string]string{"activation-cooldown": "2h"},
+		current:  map[string]string{"activation-cooldown": "3h"},
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &revisionEvaluator{policies: newPolicyTracker(clock.Frozen(now.Add(-2 * time.Hour)))}
+			if tt.previous != nil {
+				e.policies.observe(gcConfig(t, tt.previous))
+			}
+			e.policies.clock = clock.Frozen(now.Add(-10 * time.Minute))
+			current := gcConfig(t, tt.current)
+			e.policies.observe(current)
+
+			ctx := logging.WithLogger(context.Background(), zap.NewNop().Sugar())
+			ctx = config.ToContext(ctx, &config.Config{GC: current})
+			in := &strategy.Inputs{
+				Route:          route,
+				Revisions:      []*v1alpha1.Revision{revision("hello-00001", "1", 48*time.Hour), revision("hello-00002", "2", time.Hour)},
+				PodAutoscalers: []*autoscalingv1alpha1.PodAutoscaler{pa},
+				Now:            now,
+			}
+			if err := e.migrateInFlight(ctx, nil, in); err != nil {
+				t.Fatalf("migrateInFlight() = %v", err)
+			}
+			if !reflect.DeepEqual(in.InFlight, tt.want) {
+				t.Errorf("migrateInFlight() InFlight = %v, want %v", in.InFlight, tt.want)
+			}
+		})
+	}
+}
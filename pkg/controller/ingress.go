@@ -0,0 +1,79 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	"github.com/knative-sample/revision-controller/pkg/apicall"
+	"github.com/knative-sample/revision-controller/pkg/ingress"
+	"github.com/knative-sample/revision-controller/pkg/strategy"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	"knative.dev/pkg/logging"
+	networkingv1alpha1 "knative.dev/serving/pkg/apis/networking/v1alpha1"
+	"knative.dev/serving/pkg/apis/serving/v1alpha1"
+	resourcenames "knative.dev/serving/pkg/reconciler/service/resources/names"
+)
+
+// verifyUnrouted returns the planned revisions the Ingresses of the Route of
+// the Service no longer route to, holding the others.
+func (c *Executor) verifyUnrouted(ctx context.Context, service *v1alpha1.Service, planned []strategy.Decision) ([]strategy.Decision, int) {
+	logger := logging.FromContext(ctx)
+
+	ingresses, err := c.routeIngresses(ctx, service)
+	if err != nil {
+		// Hold the deletions, the ingresses may still route to them.
+		logger.Errorf("executor service: %s/%s list ingresses error:%s", service.Namespace, service.Name, err.Error())
+		return nil, len(planned)
+	}
+
+	var unrouted []strategy.Decision
+	for _, d := range planned {
+		if why := ingress.Routing(ingresses, service.Namespace, d.Revision.Name); why != "" {
+			logger.Infof("executor service: %s/%s revision:%s %s", service.Namespace, service.Name, d.Revision.Name, why)
+			continue
+		}
+		unrouted = append(unrouted, d)
+	}
+	return unrouted, len(planned) - len(unrouted)
+}
+
+// routeIngresses lists the Ingresses of the Route of the Service from the
+// API server, and the ClusterIngresses older Knative Serving versions
+// program instead. A kind the cluster does not serve has none.
+func (c *Executor) routeIngresses(ctx context.Context, service *v1alpha1.Service) ([]*networkingv1alpha1.Ingress, error) {
+	selector := ingress.RouteSelector(service.Namespace, resourcenames.Route(service))
+
+	var out []*networkingv1alpha1.Ingress
+	ingresses, err := apicall.ListIngresses(ctx, c.revisionClientSet, service.Namespace, selector)
+	if err != nil && !apierrs.IsNotFound(err) {
+		return nil, err
+	} else if err == nil {
+		for i := range ingresses.Items {
+			out = append(out, &ingresses.Items[i])
+		}
+	}
+	clusterIngresses, err := apicall.ListClusterIngresses(ctx, c.revisionClientSet, selector)
+	if err != nil && !apierrs.IsNotFound(err) {
+		return nil, err
+	} else if err == nil {
+		for i := range clusterIngresses.Items {
+			out = append(out, ingress.FromClusterIngress(&clusterIngresses.Items[i]))
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,203 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/knative-sample/revision-controller/pkg/strategy"
+	"go.uber.org/zap"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+	"knative.dev/pkg/logging"
+	networkingv1alpha1 "knative.dev/serving/pkg/apis/networking/v1alpha1"
+	"knative.dev/serving/pkg/apis/serving"
+	"knative.dev/serving/pkg/apis/serving/v1alpha1"
+	versioned "knative.dev/serving/pkg/client/clientset/versioned"
+)
+
+// ingressServer serves the Ingresses and ClusterIngresses of the Route
+// "hello" in the namespace "default", answering a kind without items with
+// its status code.
+type ingressServer struct {
+	ingresses        []networkingv1alpha1.Ingress
+	clusterIngresses []networkingv1alpha1.ClusterIngress
+	ingressCode      int
+	clusterCode      int
+}
+
+func (s *ingressServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	const prefix = "/apis/networking.internal.knative.dev/v1alpha1"
+	if want := serving.RouteLabelKey + "=hello," + serving.RouteNamespaceLabelKey + "=default"; r.URL.Query().Get("labelSelector") != want {
+		http.Error(w, "unexpected label selector "+r.URL.Query().Get("labelSelector"), http.StatusBadRequest)
+		return
+	}
+	var list interface{}
+	code := http.StatusOK
+	switch r.URL.Path {
+	case prefix + "/namespaces/default/ingresses":
+		list, code = &networkingv1alpha1.IngressList{Items: s.ingresses}, s.ingressCode
+	case prefix + "/clusteringresses":
+		list, code = &networkingv1alpha1.ClusterIngressList{Items: s.clusterIngresses}, s.clusterCode
+	default:
+		code = http.StatusNotFound
+	}
+	if code != http.StatusOK && code != 0 {
+		writeAPIStatus(w, code)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+var reasons = map[int]metav1.StatusReason{
+	http.StatusNotFound:            metav1.StatusReasonNotFound,
+	http.StatusForbidden:           metav1.StatusReasonForbidden,
+	http.StatusInternalServerError: metav1.StatusReasonInternalError,
+}
+
+func writeAPIStatus(w http.ResponseWriter, code int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(metav1.Status{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Status"},
+		Status:   metav1.StatusFailure,
+		Code:     int32(code),
+		Reason:   reasons[code],
+		Message:  http.StatusText(code),
+	})
+}
+
+// programmedIngress returns a ready Ingress that observed its generation,
+// sending all traffic to the revision.
+func programmedIngress(name, revision string) networkingv1alpha1.Ingress {
+	ing := networkingv1alpha1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Generation: 1},
+		Spec: networkingv1alpha1.IngressSpec{
+			Rules: []networkingv1alpha1.IngressRule{{
+				HTTP: &networkingv1alpha1.HTTPIngressRuleValue{
+					Paths: []networkingv1alpha1.HTTPIngressPath{{
+						Splits: []networkingv1alpha1.IngressBackendSplit{{
+							IngressBackend: networkingv1alpha1.IngressBackend{ServiceNamespace: "default", ServiceName: revision},
+							Percent:        100,
+						}},
+					}},
+				},
+			}},
+		},
+	}
+	ing.Status.InitializeConditions()
+	ing.Status.MarkNetworkConfigured()
+	ing.Status.MarkLoadBalancerReady(nil, nil, nil)
+	ing.Status.ObservedGeneration = 1
+	return ing
+}
+
+func TestVerifyUnrouted(t *testing.T) {
+	lagging := programmedIngress("hello", "hello-00003")
+	lagging.Generation = 2
+	pending := programmedIngress("hello", "hello-00003")
+	pending.Status.MarkLoadBalancerPending()
+	legacy := programmedIngress("hello", "hello-00002")
+
+	tests := []struct {
+		name         string
+		server       *ingressServer
+		wantUnrouted []string
+		wantPending  int
+	}{{
+		name:         "no ingresses",
+		server:       &ingressServer{},
+		wantUnrouted: []string{"hello-00001", "hello-00002"},
+	}, {
+		name:         "ingress routes to another revision",
+		server:       &ingressServer{ingresses: []networkingv1alpha1.Ingress{programmedIngress("hello", "hello-00003")}},
+		wantUnrouted: []string{"hello-00001", "hello-00002"},
+	}, {
+		name:         "ingress still routes to a revision",
+		server:       &ingressServer{ingresses: []networkingv1alpha1.Ingress{programmedIngress("hello", "hello-00002")}},
+		wantUnrouted: []string{"hello-00001"},
+		wantPending:  1,
+	}, {
+		name:        "ingress has not observed its generation",
+		server:      &ingressServer{ingresses: []networkingv1alpha1.Ingress{lagging}},
+		wantPending: 2,
+	}, {
+		name:        "ingress not ready",
+		server:      &ingressServer{ingresses: []networkingv1alpha1.Ingress{pending}},
+		wantPending: 2,
+	}, {
+		name: "cluster ingress still routes to a revision",
+		server: &ingressServer{
+			ingresses:        []networkingv1alpha1.Ingress{programmedIngress("hello", "hello-00003")},
+			clusterIngresses: []networkingv1alpha1.ClusterIngress{{ObjectMeta: legacy.ObjectMeta, Spec: legacy.Spec, Status: legacy.Status}},
+		},
+		wantUnrouted: []string{"hello-00001"},
+		wantPending:  1,
+	}, {
+		name: "cluster ingresses not served",
+		server: &ingressServer{
+			ingresses:   []networkingv1alpha1.Ingress{programmedIngress("hello", "hello-00002")},
+			clusterCode: http.StatusNotFound,
+		},
+		wantUnrouted: []string{"hello-00001"},
+		wantPending:  1,
+	}, {
+		name:         "ingresses not served",
+		server:       &ingressServer{ingressCode: http.StatusNotFound},
+		wantUnrouted: []string{"hello-00001", "hello-00002"},
+	}, {
+		name:        "list error",
+		server:      &ingressServer{ingressCode: http.StatusInternalServerError},
+		wantPending: 2,
+	}, {
+		name:        "cluster list error",
+		server:      &ingressServer{clusterCode: http.StatusForbidden},
+		wantPending: 2,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := httptest.NewServer(test.server)
+			defer server.Close()
+			c := &Executor{revisionClientSet: versioned.NewForConfigOrDie(&rest.Config{Host: server.URL})}
+
+			service := &v1alpha1.Service{ObjectMeta: metav1.ObjectMeta{Name: "hello", Namespace: "default"}}
+			planned := []strategy.Decision{
+				{Revision: &v1alpha1.Revision{ObjectMeta: metav1.ObjectMeta{Name: "hello-00001", Namespace: "default"}}},
+				{Revision: &v1alpha1.Revision{ObjectMeta: metav1.ObjectMeta{Name: "hello-00002", Namespace: "default"}}},
+			}
+			unrouted, pending := c.verifyUnrouted(logging.WithLogger(context.Background(), zap.NewNop().Sugar()), service, planned)
+
+			var got []string
+			for _, d := range unrouted {
+				got = append(got, d.Revision.Name)
+			}
+			if !reflect.DeepEqual(got, test.wantUnrouted) {
+				t.Errorf("verifyUnrouted() = %v, want %v", got, test.wantUnrouted)
+			}
+			if pending != test.wantPending {
+				t.Errorf("verifyUnrouted() pending = %d, want %d", pending, test.wantPending)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"strings"
+
+	"github.com/knative-sample/revision-controller/pkg/priorityqueue"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// namespaceTerminating reports whether the namespace is being deleted. The
+// revisions of a terminating namespace are deleted with it, deleting them one
+// by one only wastes API calls.
+func namespaceTerminating(lister corelisters.NamespaceLister, name string) bool {
+	ns, err := lister.Get(name)
+	if err != nil {
+		return false
+	}
+	return isTerminating(ns)
+}
+
+func isTerminating(ns *corev1.Namespace) bool {
+	return ns.DeletionTimestamp != nil || ns.Status.Phase == corev1.NamespaceTerminating
+}
+
+// dropTerminated returns the handler dropping the queued Service keys of a
+// namespace once it starts terminating.
+func dropTerminated(logger *zap.SugaredLogger, queue *priorityqueue.Queue) cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(old, new interface{}) {
+			before, ok := old.(*corev1.Namespace)
+			if !ok {
+				return
+			}
+			after, ok := new.(*corev1.Namespace)
+			if !ok || isTerminating(before) || !isTerminating(after) {
+				return
+			}
+			prefix := after.Name + "/"
+			dropped := queue.DropIf(func(item interface{}) bool {
+				key, ok := item.(string)
+				return ok && strings.HasPrefix(key, prefix)
+			})
+			if dropped > 0 {
+				logger.Infof("namespace: %s terminating, dropped %d queued services", after.Name, dropped)
+			}
+		},
+	}
+}
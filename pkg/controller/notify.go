@@ -0,0 +1,74 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/knative-sample/revision-controller/pkg/config"
+	"github.com/knative-sample/revision-controller/pkg/notifier"
+	"github.com/knative-sample/revision-controller/pkg/tracing"
+	"knative.dev/pkg/logging"
+	"knative.dev/serving/pkg/apis/serving/v1alpha1"
+)
+
+// notify delivers the notification to the sinks configured in the context.
+func notify(ctx context.Context, n *notifier.Notification) {
+	sinks := notifier.SinksFromConfig(config.FromContext(ctx).Notifications)
+	if len(sinks) == 0 {
+		return
+	}
+	n.DecisionID = tracing.DecisionID(ctx)
+	notifier.New(logging.FromContext(ctx), sinks...).Notify(n)
+}
+
+// failureCounter counts consecutive reconcile failures per Service key.
+type failureCounter struct {
+	mu    sync.Mutex
+	byKey map[string]int
+}
+
+func newFailureCounter() *failureCounter {
+	return &failureCounter{byKey: make(map[string]int)}
+}
+
+// observe records the outcome of a reconcile for key and notifies once the
+// consecutive failures reach the configured threshold.
+func (f *failureCounter) observe(ctx context.Context, service *v1alpha1.Service, err error) {
+	key := service.Namespace + "/" + service.Name
+
+	f.mu.Lock()
+	if err == nil {
+		delete(f.byKey, key)
+		f.mu.Unlock()
+		return
+	}
+	f.byKey[key]++
+	failures := f.byKey[key]
+	f.mu.Unlock()
+
+	if threshold := config.FromContext(ctx).Notifications.FailureThreshold; threshold > 0 && failures == threshold {
+		notify(ctx, &notifier.Notification{
+			Kind:      notifier.KindRepeatedFailure,
+			Namespace: service.Namespace,
+			Service:   service.Name,
+			Message:   fmt.Sprintf("garbage collection failed %d times in a row: %s", failures, err.Error()),
+		})
+	}
+}
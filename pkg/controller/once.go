@@ -0,0 +1,204 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/knative-sample/revision-controller/pkg/apicall"
+	"github.com/knative-sample/revision-controller/pkg/plan"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"knative.dev/pkg/controller"
+	"knative.dev/pkg/logging"
+	"knative.dev/serving/pkg/apis/serving/v1alpha1"
+	versioned "knative.dev/serving/pkg/client/clientset/versioned"
+	servingclient "knative.dev/serving/pkg/client/injection/client"
+	revisioninformer "knative.dev/serving/pkg/client/injection/informers/serving/v1alpha1/revision"
+	kserviceinformer "knative.dev/serving/pkg/client/injection/informers/serving/v1alpha1/service"
+	listers "knative.dev/serving/pkg/client/listers/serving/v1alpha1"
+)
+
+// syncTimeout bounds the wait for the Service informer to observe the plan
+// the planner recorded.
+const syncTimeout = 30 * time.Second
+
+// Failure is a key a controller failed to reconcile.
+type Failure struct {
+	Controller string `json:"controller"`
+	Key        string `json:"key"`
+	Err        error  `json:"-"`
+}
+
+// MarshalJSON encodes the failure with the message of its error.
+func (f Failure) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Controller string `json:"controller"`
+		Key        string `json:"key"`
+		Error      string `json:"error"`
+	}{f.Controller, f.Key, f.Err.Error()})
+}
+
+// Summary summarizes a single garbage collection sweep.
+type Summary struct {
+	// Services is the number of Services reconciled.
+	Services int `json:"services"`
+	// Planned is the number of revisions planned for deletion.
+	Planned int `json:"planned"`
+	// Candidates are the revisions planned for deletion by Service key.
+	Candidates map[string][]string `json:"candidates,omitempty"`
+	// Deferred is the number of Services whose plan was kept for a later
+	// sweep, e.g. because it awaits approval or the quota is exhausted.
+	Deferred int `json:"deferred"`
+	// Namespaces is the number of namespaces swept for child resources.
+	Namespaces int `json:"namespaces"`
+	// Failures are the keys that failed to reconcile.
+	Failures []Failure `json:"failures"`
+}
+
+// String returns a one line summary followed by a line per failure.
+func (s *Summary) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "reconciled %d services, planned %d revision deletions, deferred %d plans, swept %d namespaces, %d failures",
+		s.Services, s.Planned, s.Deferred, s.Namespaces, len(s.Failures))
+	for _, f := range s.Failures {
+		fmt.Fprintf(&b, "\n%s %s: %v", f.Controller, f.Key, f.Err)
+	}
+	return b.String()
+}
+
+// RunOnce performs a single full garbage collection sweep, for running the
+// controller as a CronJob: it plans every Service, carries out the plans and
+// sweeps the child resources of deleted revisions, calling the reconcilers of
+// the planner, executor and sweeper directly instead of through their work
+// queues. Deletions deferred by the executor are left to the next sweep, as
+// are the child resources of revisions deleted in this one. The informers
+// must be synced.
+func RunOnce(ctx context.Context, planner, executor, sweeper *controller.Impl) *Summary {
+	logger := logging.FromContext(ctx)
+	client := servingclient.Get(ctx)
+	serviceLister := kserviceinformer.Get(ctx).Lister()
+	revisionLister := revisioninformer.Get(ctx).Lister()
+
+	// The sweep visits every Service once, shed Services would never be
+	// retried.
+	if r, ok := planner.Reconciler.(*Reconciler); ok {
+		r.queueDepth = func() int { return 0 }
+	}
+
+	summary := &Summary{}
+	fail := func(name, key string, err error) {
+		logger.Errorf("once %s key: %s error: %s", name, key, err.Error())
+		summary.Failures = append(summary.Failures, Failure{Controller: name, Key: key, Err: err})
+	}
+
+	services, err := serviceLister.List(labels.Everything())
+	if err != nil {
+		fail(ReconcilerName, "", err)
+		return summary
+	}
+	sort.Slice(services, func(i, j int) bool {
+		return services[i].Namespace+"/"+services[i].Name < services[j].Namespace+"/"+services[j].Name
+	})
+
+	namespaces := make(map[string]bool)
+	for _, service := range services {
+		namespaces[service.Namespace] = true
+		key := service.Namespace + "/" + service.Name
+		summary.Services++
+
+		if err := planner.Reconciler.Reconcile(ctx, key); err != nil {
+			fail(ReconcilerName, key, err)
+			continue
+		}
+		p, err := awaitPlan(ctx, client, serviceLister, service)
+		if err != nil {
+			fail(ReconcilerName, key, err)
+			continue
+		}
+		if p == nil {
+			continue
+		}
+		summary.Planned += len(p.Revisions)
+		if summary.Candidates == nil {
+			summary.Candidates = make(map[string][]string)
+		}
+		summary.Candidates[key] = p.Revisions
+
+		if err := executor.Reconciler.Reconcile(ctx, key); err != nil {
+			fail(ExecutorName, key, err)
+			continue
+		}
+		live, err := apicall.GetService(ctx, client, service.Namespace, service.Name)
+		if err != nil && !apierrs.IsNotFound(err) {
+			fail(ExecutorName, key, err)
+		} else if err == nil && live.Annotations[plan.AnnotationKey] != "" {
+			summary.Deferred++
+		}
+	}
+
+	if revisions, err := revisionLister.List(labels.Everything()); err == nil {
+		for _, re := range revisions {
+			namespaces[re.Namespace] = true
+		}
+	}
+	for namespace := range namespaces {
+		summary.Namespaces++
+		if err := sweeper.Reconciler.Reconcile(ctx, namespace); err != nil {
+			fail(SweeperName, namespace, err)
+		}
+	}
+	sort.Slice(summary.Failures, func(i, j int) bool {
+		return summary.Failures[i].Key < summary.Failures[j].Key
+	})
+	if summary.Failures == nil {
+		summary.Failures = []Failure{}
+	}
+	return summary
+}
+
+// awaitPlan waits until the Service informer observed the plan recorded on
+// the live Service, so the executor reads it, and returns the plan.
+func awaitPlan(ctx context.Context, client versioned.Interface, lister listers.ServiceLister, service *v1alpha1.Service) (*plan.Plan, error) {
+	live, err := apicall.GetService(ctx, client, service.Namespace, service.Name)
+	if apierrs.IsNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	want := live.Annotations[plan.AnnotationKey]
+
+	err = wait.PollImmediate(100*time.Millisecond, syncTimeout, func() (bool, error) {
+		cached, err := lister.Services(service.Namespace).Get(service.Name)
+		if apierrs.IsNotFound(err) {
+			return true, nil
+		} else if err != nil {
+			return false, err
+		}
+		return cached.Annotations[plan.AnnotationKey] == want, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("waiting for the informer to observe the plan: %v", err)
+	}
+	return plan.FromAnnotations(live.Annotations)
+}
@@ -0,0 +1,284 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	gcv1alpha1 "github.com/knative-sample/revision-controller/pkg/apis/gc/v1alpha1"
+	"github.com/knative-sample/revision-controller/pkg/chaos"
+	painformer "github.com/knative-sample/revision-controller/pkg/client/injection/informers/autoscaling/v1alpha1/podautoscaler"
+	"github.com/knative-sample/revision-controller/pkg/clock"
+	"github.com/knative-sample/revision-controller/pkg/config"
+	"github.com/knative-sample/revision-controller/pkg/exemption"
+	"github.com/knative-sample/revision-controller/pkg/explain"
+	"github.com/knative-sample/revision-controller/pkg/hooks"
+	"github.com/knative-sample/revision-controller/pkg/inventory"
+	"github.com/knative-sample/revision-controller/pkg/plan"
+	"github.com/knative-sample/revision-controller/pkg/quota"
+	"github.com/knative-sample/revision-controller/pkg/strategy"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	"knative.dev/pkg/configmap"
+	"knative.dev/pkg/injection/clients/kubeclient"
+	deploymentinformer "knative.dev/pkg/injection/informers/kubeinformers/appsv1/deployment"
+	resourcequotainformer "knative.dev/pkg/injection/informers/kubeinformers/corev1/resourcequota"
+	"knative.dev/pkg/logging"
+	"knative.dev/pkg/system"
+	"knative.dev/serving/pkg/apis/serving/v1alpha1"
+	servingclient "knative.dev/serving/pkg/client/injection/client"
+	configurationinformer "knative.dev/serving/pkg/client/injection/informers/serving/v1alpha1/configuration"
+	revisioninformer "knative.dev/serving/pkg/client/injection/informers/serving/v1alpha1/revision"
+	routeinformer "knative.dev/serving/pkg/client/injection/informers/serving/v1alpha1/route"
+	kserviceinformer "knative.dev/serving/pkg/client/injection/informers/serving/v1alpha1/service"
+	listers "knative.dev/serving/pkg/client/listers/serving/v1alpha1"
+)
+
+// PlanSource computes the garbage collection plans of Services and explains
+// the decisions made for their revisions on demand, with the same decision
+// logic the planner and the executor use.
+type PlanSource struct {
+	*revisionEvaluator
+
+	kubeClient    kubernetes.Interface
+	serviceLister listers.ServiceLister
+	configStore   *config.Store
+}
+
+// NewPlanSource returns a PlanSource reading from the injected informers.
+func NewPlanSource(ctx context.Context, cmw configmap.Watcher) *PlanSource {
+	logger := logging.FromContext(ctx)
+
+	s := &PlanSource{
+		revisionEvaluator: &revisionEvaluator{
+			routeLister:         routeinformer.Get(ctx).Lister(),
+			configurationLister: configurationinformer.Get(ctx).Lister(),
+			revisionLister:      chaos.RevisionLister(revisioninformer.Get(ctx).Lister()),
+			deploymentLister:    deploymentinformer.Get(ctx).Lister(),
+			paLister:            painformer.Get(ctx).Lister(),
+			quotaLister:         resourcequotainformer.Get(ctx).Lister(),
+			revisionClient:      servingclient.Get(ctx),
+			clock:               clock.Real,
+			hooks:               hooks.FromContext(ctx),
+		},
+		kubeClient:    kubeclient.Get(ctx),
+		serviceLister: kserviceinformer.Get(ctx).Lister(),
+		configStore:   config.NewStore(logger.Named("plan-config-store")),
+	}
+	if exemptions := exemption.FromContext(ctx); exemptions != nil {
+		s.exemptions = exemptions
+	}
+	s.configStore.WatchConfigs(cmw)
+	return s
+}
+
+// Get computes the plan of the named Service. It returns a NotFound error
+// when the Service does not exist.
+func (s *PlanSource) Get(ctx context.Context, namespace, name string) (*gcv1alpha1.RevisionGCPlan, error) {
+	service, err := s.serviceLister.Services(namespace).Get(name)
+	if err != nil {
+		return nil, err
+	}
+	return s.plan(s.configStore.ToContext(ctx), service)
+}
+
+// List computes the plans of the Services in the namespace, or of all
+// Services when namespace is empty.
+func (s *PlanSource) List(ctx context.Context, namespace string) (*gcv1alpha1.RevisionGCPlanList, error) {
+	var services []*v1alpha1.Service
+	var err error
+	if namespace == "" {
+		services, err = s.serviceLister.List(labels.Everything())
+	} else {
+		services, err = s.serviceLister.Services(namespace).List(labels.Everything())
+	}
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(services, func(i, j int) bool {
+		if services[i].Namespace != services[j].Namespace {
+			return services[i].Namespace < services[j].Namespace
+		}
+		return services[i].Name < services[j].Name
+	})
+
+	ctx = s.configStore.ToContext(ctx)
+	list := &gcv1alpha1.RevisionGCPlanList{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: gcv1alpha1.SchemeGroupVersion.String(),
+			Kind:       "RevisionGCPlanList",
+		},
+		Items: make([]gcv1alpha1.RevisionGCPlan, 0, len(services)),
+	}
+	for _, service := range services {
+		p, err := s.plan(ctx, service)
+		if err != nil {
+			return nil, err
+		}
+		list.Items = append(list.Items, *p)
+	}
+	return list, nil
+}
+
+// Explain explains why the named revision is still there. It returns a
+// NotFound error when the revision or its Service does not exist.
+func (s *PlanSource) Explain(ctx context.Context, namespace, name string) (*explain.Explanation, error) {
+	ctx = s.configStore.ToContext(ctx)
+	gc := config.FromContext(ctx).GC
+
+	revision, err := s.revisionLister.Revisions(namespace).Get(name)
+	if err != nil {
+		return nil, err
+	}
+	serviceName, ok := revision.Labels[gc.LabelKeys.Service]
+	if !ok {
+		return nil, apierrs.NewBadRequest(fmt.Sprintf("revision %s does not belong to a Service", name))
+	}
+	service, err := s.serviceLister.Services(namespace).Get(serviceName)
+	if err != nil {
+		return nil, err
+	}
+
+	// A fresh tracker reads the quota the executor persisted last.
+	remaining, err := quota.NewTracker(s.kubeClient, system.Namespace()).Remaining(ctx, namespace, gc.Team(service.Labels), gc.Quotas(), s.clock.Now())
+	if err != nil {
+		return nil, err
+	}
+	return s.explain(ctx, service, name, remaining)
+}
+
+// Inventory lists the revisions retained across all Services. Services
+// that cannot be evaluated are left out.
+func (s *PlanSource) Inventory(ctx context.Context) (*inventory.Inventory, error) {
+	services, err := s.serviceLister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(services, func(i, j int) bool {
+		if services[i].Namespace != services[j].Namespace {
+			return services[i].Namespace < services[j].Namespace
+		}
+		return services[i].Name < services[j].Name
+	})
+
+	// Like the estimates, the inventory does not query open connections.
+	current := s.configStore.Load()
+	gc := *current.GC
+	gc.ConnectionsPrometheusURL = ""
+	ctx = config.ToContext(ctx, &config.Config{GC: &gc, Notifications: current.Notifications})
+
+	inv := &inventory.Inventory{GeneratedAt: s.clock.Now()}
+	for _, service := range services {
+		result, err := s.evaluate(ctx, service)
+		if err != nil {
+			continue
+		}
+		inv.Add(service.Namespace, service.Name, gc.Team(service.Labels), result)
+	}
+	return inv, nil
+}
+
+// Estimate estimates how many of the existing revisions the GC configuration
+// deletes, compared to the configuration in effect. Open connections are not
+// checked, so the estimate is an upper bound. Services that cannot be
+// evaluated are left out.
+func (s *PlanSource) Estimate(ctx context.Context, gc *config.GC) (*plan.Estimate, error) {
+	services, err := s.serviceLister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	current := s.configStore.Load()
+
+	e := &plan.Estimate{EstimatedAt: metav1.NewTime(s.clock.Now())}
+	e.Candidates, e.Services = s.count(ctx, services, &config.Config{GC: gc, Notifications: current.Notifications})
+	e.Current, _ = s.count(ctx, services, current)
+	return e, nil
+}
+
+// count returns the deletion candidates across the Services under cfg and
+// the number of Services they belong to.
+func (s *PlanSource) count(ctx context.Context, services []*v1alpha1.Service, cfg *config.Config) (candidates, affected int) {
+	gc := *cfg.GC
+	gc.ConnectionsPrometheusURL = ""
+	ctx = config.ToContext(ctx, &config.Config{GC: &gc, Notifications: cfg.Notifications})
+
+	for _, service := range services {
+		result, err := s.evaluate(ctx, service)
+		if err != nil {
+			continue
+		}
+		if len(result.Candidates) > 0 {
+			candidates += len(result.Candidates)
+			affected++
+		}
+	}
+	return candidates, affected
+}
+
+func (s *PlanSource) plan(ctx context.Context, service *v1alpha1.Service) (*gcv1alpha1.RevisionGCPlan, error) {
+	result, err := s.evaluate(ctx, service)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &gcv1alpha1.RevisionGCPlan{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: gcv1alpha1.SchemeGroupVersion.String(),
+			Kind:       "RevisionGCPlan",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:         service.Namespace,
+			Name:              service.Name,
+			UID:               service.UID,
+			CreationTimestamp: service.CreationTimestamp,
+		},
+		Status: gcv1alpha1.RevisionGCPlanStatus{
+			Policy:         config.FromContext(ctx).GC.Policy().Name,
+			SkipReason:     string(result.SkipReason),
+			RoutedRevision: result.RoutedRevision,
+			Retained:       revisionDecisions(result.Retained),
+			Candidates:     revisionDecisions(result.Candidates),
+			EvaluatedAt:    metav1.NewTime(s.clock.Now()),
+		},
+	}
+	if len(result.Candidates) > 0 {
+		p.Status.EstimatedReclaim = s.footprint(result.Candidates).String()
+	}
+	return p, nil
+}
+
+func revisionDecisions(decisions []strategy.Decision) []gcv1alpha1.RevisionDecision {
+	out := make([]gcv1alpha1.RevisionDecision, 0, len(decisions))
+	for _, d := range decisions {
+		rd := gcv1alpha1.RevisionDecision{
+			Name:       d.Revision.Name,
+			Generation: d.Generation,
+			Reason:     string(d.Reason),
+			Message:    d.Message,
+		}
+		if !d.EligibleAt.IsZero() {
+			eligibleAt := metav1.NewTime(d.EligibleAt)
+			rd.EligibleAt = &eligibleAt
+		}
+		out = append(out, rd)
+	}
+	return out
+}
@@ -0,0 +1,162 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"github.com/knative-sample/revision-controller/pkg/history"
+	"github.com/knative-sample/revision-controller/pkg/plan"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/client-go/tools/cache"
+	"knative.dev/serving/pkg/apis/serving/v1alpha1"
+)
+
+// changedFunc reports whether an update from old to new is relevant for
+// revision garbage collection.
+type changedFunc func(old, new interface{}) bool
+
+// handleChanged returns an event handler that passes adds and deletes to h,
+// but only passes updates for which changed reports true. Informer resyncs
+// and relists (same resourceVersion) are always passed to resync so that the
+// periodic re-evaluation of every Service keeps working.
+func handleChanged(h, resync func(interface{}), changed changedFunc) cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: h,
+		UpdateFunc: func(old, new interface{}) {
+			if isResync(old, new) {
+				resync(new)
+			} else if changed(old, new) {
+				h(new)
+			}
+		},
+		DeleteFunc: h,
+	}
+}
+
+// isResync reports whether the update was produced by an informer resync.
+func isResync(old, new interface{}) bool {
+	oldMeta, err := meta.Accessor(old)
+	if err != nil {
+		return false
+	}
+	newMeta, err := meta.Accessor(new)
+	if err != nil {
+		return false
+	}
+	return oldMeta.GetResourceVersion() == newMeta.GetResourceVersion()
+}
+
+// recordAnnotations are the Service annotations the controller records its
+// own bookkeeping in. Changes limited to them come from its own patches and
+// do not call for another reconcile.
+var recordAnnotations = []string{
+	history.AnnotationKey,
+	history.TagsAnnotationKey,
+	history.OwnerAnnotationKey,
+	history.RollbackAnnotationKey,
+	history.DeletedAnnotationKey,
+	history.SavingsAnnotationKey,
+}
+
+// serviceChanged reports whether a Service update changed its spec, its
+// metadata, or the parts of its status that drive garbage collection. The
+// records of the controller and the plan the planner writes are ignored.
+var serviceChanged = serviceChangedIgnoring(append(recordAnnotations, plan.AnnotationKey)...)
+
+// planChanged is serviceChanged for the executor, which also acts on a new
+// plan or its approval.
+var planChanged = serviceChangedIgnoring(recordAnnotations...)
+
+// serviceChangedIgnoring returns the changedFunc of Service updates,
+// ignoring changes of the annotations.
+func serviceChangedIgnoring(annotations ...string) changedFunc {
+	ignored := make(map[string]bool, len(annotations))
+	for _, key := range annotations {
+		ignored[key] = true
+	}
+	return func(old, new interface{}) bool {
+		oldService, ok := old.(*v1alpha1.Service)
+		if !ok {
+			return true
+		}
+		newService, ok := new.(*v1alpha1.Service)
+		if !ok {
+			return true
+		}
+
+		return oldService.Generation != newService.Generation ||
+			oldService.Status.ObservedGeneration != newService.Status.ObservedGeneration ||
+			oldService.Status.LatestReadyRevisionName != newService.Status.LatestReadyRevisionName ||
+			!equality.Semantic.DeepEqual(oldService.Labels, newService.Labels) ||
+			annotationsChanged(oldService.Annotations, newService.Annotations, ignored) ||
+			!equality.Semantic.DeepEqual(oldService.Status.Traffic, newService.Status.Traffic)
+	}
+}
+
+// annotationsChanged reports whether an annotation other than the ignored
+// ones was added, removed or changed.
+func annotationsChanged(old, new map[string]string, ignored map[string]bool) bool {
+	for key, value := range new {
+		if ignored[key] {
+			continue
+		}
+		if previous, ok := old[key]; !ok || previous != value {
+			return true
+		}
+	}
+	for key := range old {
+		if _, ok := new[key]; !ok && !ignored[key] {
+			return true
+		}
+	}
+	return false
+}
+
+// routeChanged reports whether a Route update changed its generation, its
+// traffic status or its conditions, so a finished rollout is picked up.
+func routeChanged(old, new interface{}) bool {
+	oldRoute, ok := old.(*v1alpha1.Route)
+	if !ok {
+		return true
+	}
+	newRoute, ok := new.(*v1alpha1.Route)
+	if !ok {
+		return true
+	}
+
+	return oldRoute.Generation != newRoute.Generation ||
+		oldRoute.Status.ObservedGeneration != newRoute.Status.ObservedGeneration ||
+		oldRoute.Status.IsReady() != newRoute.Status.IsReady() ||
+		!equality.Semantic.DeepEqual(oldRoute.Status.Traffic, newRoute.Status.Traffic)
+}
+
+// configurationChanged reports whether a Configuration update changed its
+// generation or the revisions it considers latest.
+func configurationChanged(old, new interface{}) bool {
+	oldConfig, ok := old.(*v1alpha1.Configuration)
+	if !ok {
+		return true
+	}
+	newConfig, ok := new.(*v1alpha1.Configuration)
+	if !ok {
+		return true
+	}
+
+	return oldConfig.Generation != newConfig.Generation ||
+		oldConfig.Status.LatestCreatedRevisionName != newConfig.Status.LatestCreatedRevisionName ||
+		oldConfig.Status.LatestReadyRevisionName != newConfig.Status.LatestReadyRevisionName
+}
@@ -0,0 +1,100 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	"github.com/knative-sample/revision-controller/pkg/history"
+	"github.com/knative-sample/revision-controller/pkg/plan"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"knative.dev/serving/pkg/apis/serving/v1alpha1"
+)
+
+func service(annotations map[string]string) *v1alpha1.Service {
+	return &v1alpha1.Service{ObjectMeta: metav1.ObjectMeta{
+		Namespace:   "default",
+		Name:        "hello",
+		Annotations: annotations,
+	}}
+}
+
+func TestServiceChangedIgnoresRecords(t *testing.T) {
+	tests := []struct {
+		name     string
+		old, new map[string]string
+		planner  bool
+		executor bool
+	}{{
+		name: "unchanged",
+		old:  map[string]string{"a": "1"},
+		new:  map[string]string{"a": "1"},
+	}, {
+		name:     "user annotation changed",
+		old:      map[string]string{"a": "1"},
+		new:      map[string]string{"a": "2"},
+		planner:  true,
+		executor: true,
+	}, {
+		name:     "user annotation removed",
+		old:      map[string]string{"a": "1", history.AnnotationKey: "[]"},
+		new:      map[string]string{history.AnnotationKey: "[]"},
+		planner:  true,
+		executor: true,
+	}, {
+		name: "deploy history recorded",
+		old:  map[string]string{"a": "1"},
+		new:  map[string]string{"a": "1", history.AnnotationKey: "[]"},
+	}, {
+		name: "records changed",
+		old: map[string]string{
+			history.TagsAnnotationKey:     "{}",
+			history.OwnerAnnotationKey:    "{}",
+			history.RollbackAnnotationKey: "{}",
+			history.DeletedAnnotationKey:  "[]",
+		},
+		new: map[string]string{
+			history.TagsAnnotationKey:     "{\"stable\":[]}",
+			history.RollbackAnnotationKey: "{\"frozenUntil\":null}",
+			history.DeletedAnnotationKey:  "[\"hello-00001\"]",
+			history.SavingsAnnotationKey:  "{}",
+		},
+	}, {
+		name:     "plan recorded",
+		old:      nil,
+		new:      map[string]string{plan.AnnotationKey: "{}"},
+		executor: true,
+	}, {
+		name:     "plan approved",
+		old:      map[string]string{plan.AnnotationKey: "{}"},
+		new:      map[string]string{plan.AnnotationKey: "{}", plan.ApprovedByAnnotationKey: "alice"},
+		planner:  true,
+		executor: true,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			old, new := service(test.old), service(test.new)
+			if got := serviceChanged(old, new); got != test.planner {
+				t.Errorf("serviceChanged() = %v, want %v", got, test.planner)
+			}
+			if got := planChanged(old, new); got != test.executor {
+				t.Errorf("planChanged() = %v, want %v", got, test.executor)
+			}
+		})
+	}
+}
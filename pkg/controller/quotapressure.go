@@ -0,0 +1,47 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/client-go/tools/cache"
+)
+
+// quotaChanged returns the handler evaluating the Services of a namespace
+// again when the usage or limits of one of its ResourceQuotas change, so
+// the quota pressure is picked up and released promptly.
+func quotaChanged(enqueueNamespace func(namespace string)) cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(old, new interface{}) {
+			before, ok := old.(*corev1.ResourceQuota)
+			if !ok {
+				return
+			}
+			after, ok := new.(*corev1.ResourceQuota)
+			if !ok || equality.Semantic.DeepEqual(before.Status, after.Status) {
+				return
+			}
+			enqueueNamespace(after.Namespace)
+		},
+		DeleteFunc: func(obj interface{}) {
+			if q, ok := obj.(*corev1.ResourceQuota); ok {
+				enqueueNamespace(q.Namespace)
+			}
+		},
+	}
+}
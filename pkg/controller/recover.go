@@ -0,0 +1,76 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"runtime/debug"
+
+	"github.com/knative-sample/revision-controller/pkg/crashreport"
+	"github.com/knative-sample/revision-controller/pkg/gcerrors"
+	"github.com/knative-sample/revision-controller/pkg/tracing"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/record"
+	"knative.dev/pkg/logging"
+	listers "knative.dev/serving/pkg/client/listers/serving/v1alpha1"
+)
+
+// recoverReconcile turns a panic of the reconcile of key into a PanicError
+// in errp, so the key is requeued instead of crashing the process. It logs
+// the stack, records the panic in crashes for the crash report and reports
+// it, with an event on the object the key names, if it still exists. Call
+// it deferred.
+func recoverReconcile(ctx context.Context, crashes *crashreport.Recorder, controller, key string, recorder record.EventRecorder, statsReporter StatsReporter, object func() runtime.Object, errp *error) {
+	value := recover()
+	if value == nil {
+		return
+	}
+	stack := debug.Stack()
+	err := &gcerrors.PanicError{Value: value}
+	*errp = err
+
+	logging.FromContext(ctx).Errorf("%s key: %s %s\n%s", controller, key, err.Error(), stack)
+	crashes.Panicked(controller, key, value, stack)
+	if err := statsReporter.ReportError(gcerrors.TypePanic); err != nil {
+		logging.FromContext(ctx).Errorf("report reconcile error error: %s", err.Error())
+	}
+	if obj := object(); obj != nil {
+		tracing.EventRecorder(ctx, recorder).Event(obj, corev1.EventTypeWarning, string(gcerrors.TypePanic), err.Error())
+	}
+}
+
+// serviceObject returns the lookup of the named Service for recoverReconcile.
+func serviceObject(lister listers.ServiceLister, namespace, name string) func() runtime.Object {
+	return func() runtime.Object {
+		if service, err := lister.Services(namespace).Get(name); err == nil {
+			return service
+		}
+		return nil
+	}
+}
+
+// namespaceObject returns the lookup of the namespace for recoverReconcile.
+func namespaceObject(lister corelisters.NamespaceLister, name string) func() runtime.Object {
+	return func() runtime.Object {
+		if ns, err := lister.Get(name); err == nil {
+			return ns
+		}
+		return nil
+	}
+}
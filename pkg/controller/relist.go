@@ -0,0 +1,129 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/knative-sample/revision-controller/pkg/clock"
+	"github.com/knative-sample/revision-controller/pkg/config"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+	"knative.dev/pkg/kmeta"
+)
+
+// relistGuard notices when the informers deliver their objects again, as
+// they do when a watch is reset and they relist, e.g. after an API server
+// restart. The caches of the different resources may briefly disagree
+// then, so deletions are held until no relist was seen for the settle
+// period, and the objects delivered again are enqueued with a random delay
+// instead of all at once.
+type relistGuard struct {
+	clock clock.Clock
+
+	mu     sync.Mutex
+	settle time.Duration
+	jitter time.Duration
+	last   time.Time
+}
+
+func newRelistGuard(clock clock.Clock) *relistGuard {
+	return &relistGuard{clock: clock}
+}
+
+// observe records the settle period and the jitter of a loaded GC
+// configuration.
+func (g *relistGuard) observe(value interface{}) {
+	gc, ok := value.(*config.GC)
+	if !ok {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.settle, g.jitter = gc.RelistSettlePeriod, gc.RelistJitter
+}
+
+// relisted records that an informer delivered an object again.
+func (g *relistGuard) relisted() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.last = g.clock.Now()
+}
+
+// settling returns how long deletions are still held after the last relist,
+// zero once the caches settled.
+func (g *relistGuard) settling() time.Duration {
+	if g == nil {
+		return 0
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.settle <= 0 || g.last.IsZero() {
+		return 0
+	}
+	if remaining := g.last.Add(g.settle).Sub(g.clock.Now()); remaining > 0 {
+		return remaining
+	}
+	return 0
+}
+
+// delay returns a random delay of up to the configured jitter.
+func (g *relistGuard) delay() time.Duration {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.jitter <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(g.jitter)))
+}
+
+// spread returns a handler for the objects delivered again by a relist that
+// records the relist and enqueues them after a random delay.
+func (g *relistGuard) spread(after func(obj interface{}, after time.Duration)) func(interface{}) {
+	return func(obj interface{}) {
+		g.relisted()
+		after(obj, g.delay())
+	}
+}
+
+// watch returns an event handler that only records the relists of an
+// informer whose objects are not enqueued themselves.
+func (g *relistGuard) watch() cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(old, new interface{}) {
+			if isResync(old, new) {
+				g.relisted()
+			}
+		},
+	}
+}
+
+// enqueueControllerOfAfter returns a function enqueueing the controller of
+// an object after a delay, like controller.Impl.EnqueueControllerOf.
+func enqueueControllerOfAfter(enqueueKeyAfter func(key string, after time.Duration)) func(obj interface{}, after time.Duration) {
+	return func(obj interface{}, after time.Duration) {
+		object, err := kmeta.DeletionHandlingAccessor(obj)
+		if err != nil {
+			return
+		}
+		if owner := metav1.GetControllerOf(object); owner != nil {
+			enqueueKeyAfter(object.GetNamespace()+"/"+owner.Name, after)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"sync"
+	"time"
+
+	"github.com/knative-sample/revision-controller/pkg/backlog"
+	"github.com/knative-sample/revision-controller/pkg/config"
+	"go.uber.org/zap"
+)
+
+// adaptiveResync evaluates every Service again at an interval adapted to the
+// stale backlog: the minimum interval while many revisions are due, the
+// maximum once the Services are clean. It is disabled unless both bounds are
+// configured.
+type adaptiveResync struct {
+	logger        *zap.SugaredLogger
+	statsReporter StatsReporter
+	backlog       *backlog.Tracker
+
+	mu       sync.Mutex
+	min, max time.Duration
+	changed  chan struct{}
+}
+
+func newAdaptiveResync(logger *zap.SugaredLogger, statsReporter StatsReporter, tracker *backlog.Tracker) *adaptiveResync {
+	return &adaptiveResync{
+		logger:        logger,
+		statsReporter: statsReporter,
+		backlog:       tracker,
+		changed:       make(chan struct{}, 1),
+	}
+}
+
+// observe records the interval bounds of a loaded GC configuration.
+func (r *adaptiveResync) observe(value interface{}) {
+	gc, ok := value.(*config.GC)
+	if !ok {
+		return
+	}
+	r.mu.Lock()
+	r.min, r.max = gc.ResyncMinInterval, gc.ResyncMaxInterval
+	r.mu.Unlock()
+	select {
+	case r.changed <- struct{}{}:
+	default:
+	}
+}
+
+// interval returns the interval for the current backlog, zero while the
+// adaptive resync is disabled.
+func (r *adaptiveResync) interval() time.Duration {
+	r.mu.Lock()
+	min, max := r.min, r.max
+	r.mu.Unlock()
+	if min <= 0 || max <= 0 {
+		return 0
+	}
+	return backlog.Interval(r.backlog.Snapshot(), min, max)
+}
+
+// run calls resync every interval until stopCh is closed. The interval is
+// computed again after every resync and whenever the bounds change.
+func (r *adaptiveResync) run(stopCh <-chan struct{}, resync func()) {
+	var timer *time.Timer
+	var fire <-chan time.Time
+	reset := func() {
+		if timer != nil {
+			timer.Stop()
+		}
+		timer, fire = nil, nil
+		d := r.interval()
+		r.backlog.SetResyncInterval(d)
+		if d <= 0 {
+			return
+		}
+		if err := r.statsReporter.ReportResyncInterval(d); err != nil {
+			r.logger.Errorf("report resync interval error: %s", err.Error())
+		}
+		timer = time.NewTimer(d)
+		fire = timer.C
+	}
+	reset()
+	for {
+		select {
+		case <-stopCh:
+			if timer != nil {
+				timer.Stop()
+			}
+			return
+		case <-r.changed:
+			reset()
+		case <-fire:
+			r.logger.Debugf("adaptive resync of every Service, backlog %+v", r.backlog.Snapshot())
+			resync()
+			timer = nil
+			reset()
+		}
+	}
+}
+
+// reportBacklog records the deletion candidates of the Service key and
+// reports the resulting backlog.
+func (c *Reconciler) reportBacklog(key string, candidates int) {
+	c.staleBacklog.Observe(key, candidates)
+	c.reportBacklogSnapshot()
+}
+
+// forgetBacklog drops the Service key from the backlog, e.g. once the
+// Service was deleted or its namespace moved to another replica.
+func (c *Reconciler) forgetBacklog(key string) {
+	c.staleBacklog.Forget(key)
+	c.reportBacklogSnapshot()
+}
+
+func (c *Reconciler) reportBacklogSnapshot() {
+	b := c.staleBacklog.Snapshot()
+	if err := c.statsReporter.ReportBacklog(b.Candidates, b.Average); err != nil {
+		c.Logger.Errorf("report stale backlog error: %s", err.Error())
+	}
+}
@@ -0,0 +1,52 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"github.com/knative-sample/revision-controller/pkg/strategy"
+	"knative.dev/serving/pkg/apis/serving/v1alpha1"
+)
+
+// keepLastReady enforces the invariant that a Service with a Ready revision
+// keeps one regardless of the policy: when deleting the planned revisions
+// would leave none, it withholds the newest Ready one from planned and
+// returns it.
+func keepLastReady(result *strategy.Result, planned []strategy.Decision) ([]strategy.Decision, *v1alpha1.Revision) {
+	deleting := make(map[string]bool, len(planned))
+	for _, d := range planned {
+		deleting[d.Revision.Name] = true
+	}
+	for _, decisions := range [][]strategy.Decision{result.Retained, result.Candidates} {
+		for _, d := range decisions {
+			if !deleting[d.Revision.Name] && d.Revision.Status.IsReady() {
+				return planned, nil
+			}
+		}
+	}
+
+	last := -1
+	for i, d := range planned {
+		if d.Revision.Status.IsReady() && (last < 0 || strategy.CreatedAt(d.Revision).After(strategy.CreatedAt(planned[last].Revision))) {
+			last = i
+		}
+	}
+	if last < 0 {
+		return planned, nil
+	}
+	kept := planned[last].Revision
+	return append(append([]strategy.Decision(nil), planned[:last]...), planned[last+1:]...), kept
+}
@@ -0,0 +1,120 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/knative-sample/revision-controller/pkg/apicall"
+	"github.com/knative-sample/revision-controller/pkg/history"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"knative.dev/pkg/logging"
+	"knative.dev/serving/pkg/apis/serving/v1alpha1"
+)
+
+// savingsTracker tracks, per Service key, the savings recorded on the
+// Service, to report the totals across all Services.
+type savingsTracker struct {
+	mu    sync.Mutex
+	byKey map[string]history.Savings
+}
+
+func newSavingsTracker() *savingsTracker {
+	return &savingsTracker{byKey: make(map[string]history.Savings)}
+}
+
+// set records the savings of key, nil forgets them, and returns the totals
+// across all Services at now.
+func (t *savingsTracker) set(key string, s *history.Savings, now time.Time) (revisions int64, revisionDays float64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if s != nil && s.Deleted > 0 {
+		t.byKey[key] = *s
+	} else {
+		delete(t.byKey, key)
+	}
+
+	for _, s := range t.byKey {
+		revisions += s.Deleted
+		revisionDays += s.RevisionDays(now)
+	}
+	return revisions, revisionDays
+}
+
+// observeSavings reports the savings recorded on the Service, nil when it is
+// gone.
+func (c *Executor) observeSavings(key string, service *v1alpha1.Service) {
+	var s *history.Savings
+	if service != nil {
+		var err error
+		if s, err = history.SavingsFromAnnotations(service.Annotations); err != nil {
+			c.Logger.Errorf("executor service: %s error: %s", key, err.Error())
+		}
+	}
+	c.reportSavings(key, s)
+}
+
+// recordDeleted records the revisions deleted at now in the deleted history
+// of the Service, keeping at most limit.
+func (c *Executor) recordDeleted(ctx context.Context, service *v1alpha1.Service, deleted []string, now time.Time, limit int) {
+	logger := logging.FromContext(ctx)
+	previous, err := history.DeletedFromAnnotations(service.Annotations)
+	if err != nil {
+		// Start over rather than never recording again.
+		logger.Errorf("executor service: %s/%s error: %s", service.Namespace, service.Name, err.Error())
+	}
+	patch, err := history.DeletedMergePatch(previous.Record(deleted, v1.NewTime(now), limit))
+	if err != nil {
+		logger.Errorf("executor service: %s/%s record deleted history error:%s", service.Namespace, service.Name, err.Error())
+		return
+	}
+	if _, err := apicall.PatchService(ctx, c.revisionClientSet, service.Namespace, service.Name, types.MergePatchType, patch); err != nil {
+		logger.Errorf("executor service: %s/%s record deleted history error:%s", service.Namespace, service.Name, err.Error())
+	}
+}
+
+// recordSavings records n more revisions deleted at now on the Service.
+func (c *Executor) recordSavings(ctx context.Context, key string, service *v1alpha1.Service, n int, now time.Time) {
+	logger := logging.FromContext(ctx)
+	previous, err := history.SavingsFromAnnotations(service.Annotations)
+	if err != nil {
+		// Start over rather than never recording again.
+		logger.Errorf("executor service: %s/%s error: %s", service.Namespace, service.Name, err.Error())
+	}
+	s := previous.Record(n, now)
+	patch, err := history.SavingsMergePatch(s)
+	if err != nil {
+		logger.Errorf("executor service: %s/%s record savings error:%s", service.Namespace, service.Name, err.Error())
+		return
+	}
+	if _, err := apicall.PatchService(ctx, c.revisionClientSet, service.Namespace, service.Name, types.MergePatchType, patch); err != nil {
+		logger.Errorf("executor service: %s/%s record savings error:%s", service.Namespace, service.Name, err.Error())
+		return
+	}
+	c.reportSavings(key, s)
+}
+
+func (c *Executor) reportSavings(key string, s *history.Savings) {
+	revisions, revisionDays := c.savings.set(key, s, time.Now())
+	if err := c.statsReporter.ReportSavings(revisions, revisionDays); err != nil {
+		c.Logger.Errorf("report savings error: %s", err.Error())
+	}
+}
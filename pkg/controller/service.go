@@ -18,17 +18,33 @@ package controller
 
 import (
 	"context"
+	"fmt"
+	"strings"
+	"time"
 
-	"strconv"
-
+	"github.com/knative-sample/revision-controller/pkg/apicall"
+	"github.com/knative-sample/revision-controller/pkg/backlog"
+	"github.com/knative-sample/revision-controller/pkg/config"
+	"github.com/knative-sample/revision-controller/pkg/crashreport"
+	"github.com/knative-sample/revision-controller/pkg/decisionlog"
+	"github.com/knative-sample/revision-controller/pkg/gcerrors"
+	"github.com/knative-sample/revision-controller/pkg/history"
+	"github.com/knative-sample/revision-controller/pkg/notifier"
+	"github.com/knative-sample/revision-controller/pkg/plan"
+	"github.com/knative-sample/revision-controller/pkg/redact"
+	"github.com/knative-sample/revision-controller/pkg/shard"
+	"github.com/knative-sample/revision-controller/pkg/strategy"
+	"github.com/knative-sample/revision-controller/pkg/summary"
+	"github.com/knative-sample/revision-controller/pkg/tracing"
+	"go.opencensus.io/trace"
 	corev1 "k8s.io/api/core/v1"
 	apierrs "k8s.io/apimachinery/pkg/api/errors"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	corelisters "k8s.io/client-go/listers/core/v1"
 	"k8s.io/client-go/tools/cache"
 	"knative.dev/pkg/controller"
 	"knative.dev/pkg/logging"
-	"knative.dev/serving/pkg/apis/serving"
 	v1alpha12 "knative.dev/serving/pkg/apis/serving/v1alpha1"
 	versioned "knative.dev/serving/pkg/client/clientset/versioned"
 	listers "knative.dev/serving/pkg/client/listers/serving/v1alpha1"
@@ -39,17 +55,83 @@ import (
 const (
 	// ReconcilerName is the name of the reconciler
 	ReconcilerName = "serving-controller"
+
+	// cacheSyncRecheckDelay is the delay before a Service whose Route and
+	// revision caches disagree is evaluated again.
+	cacheSyncRecheckDelay = 10 * time.Second
+
+	// tierWeight orders the queue by namespace tier before revision count.
+	tierWeight = 1 << 20
 )
 
-// Reconciler implements controller.Reconciler for Service resources.
+// Reconciler implements controller.Reconciler for Service resources. It is
+// the planner: it evaluates the revisions of a Service and records the
+// deletion candidates as a plan on the Service, which the Executor carries out.
 type Reconciler struct {
 	*reconciler.Base
 
+	// evaluator lists and evaluates the revisions of a Service
+	*revisionEvaluator
+
 	// listers index properties about resources
-	serviceLister     listers.ServiceLister
-	revisionLister    listers.RevisionLister
-	routeLister       listers.RouteLister
-	revisionClientSet versioned.Interface
+	serviceLister       listers.ServiceLister
+	configurationLister listers.ConfigurationLister
+	namespaceLister     corelisters.NamespaceLister
+	revisionClientSet   versioned.Interface
+
+	configStore   *config.Store
+	statsReporter StatsReporter
+
+	// failures counts consecutive failures to notify about
+	failures *failureCounter
+
+	// withheld tracks the candidates withheld for exceeding the policy limit
+	withheld *heldDeletions
+
+	// stalled tracks the revisions whose deletion stalled
+	stalled *stalledDeletions
+
+	// decisions archives the plans, nil when the decision log is disabled
+	decisions *decisionlog.Log
+
+	// relists holds new plans while the caches settle after a relist
+	relists *relistGuard
+
+	// shards tells the namespaces this replica reconciles in active-active
+	// mode, nil otherwise
+	shards *shard.Coordinator
+
+	// staleBacklog tracks the deletion candidates of the Services of the
+	// workspace
+	staleBacklog *backlog.Tracker
+
+	// resync evaluates every Service again at an interval adapted to the
+	// stale backlog
+	resync *adaptiveResync
+
+	// summarizer aggregates the outcome of the reconciles of the workspace
+	// per namespace
+	summarizer *summary.Summarizer
+
+	// crashes records the keys and panics of the workspace for the crash
+	// report
+	crashes *crashreport.Recorder
+
+	// confirmations holds candidates until a later evaluation confirms them
+	confirmations *candidateConfirmations
+
+	// duplicates remembers the duplicate generations reported per Service
+	duplicates *duplicateGenerations
+
+	// audits compares the expected deletions with the recorded plans
+	audits *auditor
+
+	// enqueueAfter requeues a Service, e.g. until the caches agree
+	enqueueAfter func(obj interface{}, after time.Duration)
+	// enqueueKeyAfter requeues a Service key, e.g. once it was shed
+	enqueueKeyAfter func(key string, after time.Duration)
+	// queueDepth returns the number of queued Service keys
+	queueDepth func() int
 }
 
 // Check that our Reconciler implements controller.Reconciler
@@ -58,38 +140,75 @@ var _ controller.Reconciler = (*Reconciler)(nil)
 // Reconcile compares the actual state with the desired, and attempts to
 // converge the two. It then updates the Status block of the Service resource
 // with the current status of the resource.
-func (c *Reconciler) Reconcile(ctx context.Context, key string) error {
+func (c *Reconciler) Reconcile(ctx context.Context, key string) (err error) {
 	// Convert the namespace/name string into a distinct namespace and name
 	namespace, name, err := cache.SplitMetaNamespaceKey(key)
 	if err != nil {
 		c.Logger.Errorf("invalid resource key: %s", key)
 		return nil
 	}
+	if !c.shards.Owns(namespace) {
+		// Another replica reconciles the namespace.
+		c.forgetBacklog(key)
+		c.confirmations.forget(key)
+		c.duplicates.set(key, "")
+		return nil
+	}
+	ctx, decisionID := tracing.WithDecisionID(ctx)
 	logger := logging.FromContext(ctx)
+	c.crashes.Reconciling(ReconcilerName, key)
+	defer recoverReconcile(ctx, c.crashes, ReconcilerName, key, c.Recorder, c.statsReporter, serviceObject(c.serviceLister, namespace, name), &err)
+	ctx = c.configStore.ToContext(ctx)
+	ctx = apicall.WithTimeout(ctx, config.FromContext(ctx).GC.APICallTimeout)
+	ctx, span := trace.StartSpan(ctx, ReconcilerName+"/Reconcile")
+	defer span.End()
+	span.AddAttributes(trace.StringAttribute("key", key), trace.StringAttribute(tracing.DecisionIDKey, decisionID))
 
-	logger.Infof("Reconcile: %s/%s", namespace, name)
+	if namespaceTerminating(c.namespaceLister, namespace) {
+		logger.Debugf("controller reconcile service: %s/%s namespace terminating, skipped", namespace, name)
+		return nil
+	}
+
+	if cfg := config.FromContext(ctx).GC; cfg.Shed(namespace, c.queueDepth()) {
+		logger.Infof("controller reconcile service: %s/%s shed at queue depth %d, retry in %s", namespace, name, c.queueDepth(), cfg.ShedRetryDelay)
+		c.enqueueKeyAfter(key, cfg.ShedRetryDelay)
+		return nil
+	}
+
+	outcomef(c.summarizer, logger)("Reconcile: %s/%s", namespace, name)
 
 	// Get the Service resource with this namespace/name
 	original, err := c.serviceLister.Services(namespace).Get(name)
 	if apierrs.IsNotFound(err) {
 		// The resource may no longer exist, in which case we stop processing.
 		logger.Errorf("service %q in work queue no longer exists", key)
+		c.reportStalled(key, nil)
+		c.forgetBacklog(key)
+		c.confirmations.forget(key)
+		c.duplicates.set(key, "")
 		return nil
 	} else if err != nil {
 		return err
 	}
 
 	if original.GetDeletionTimestamp() != nil {
+		c.reportStalled(key, nil)
+		c.forgetBacklog(key)
+		c.confirmations.forget(key)
+		c.duplicates.set(key, "")
 		return nil
 	}
+	c.summarizer.Reconciled(namespace, name)
 
 	// Don't modify the informers copy
 	service := original.DeepCopy()
 
 	// Reconcile this copy of the service and then write back any status
 	// updates regardless of whether the reconciliation errored out.
-	if reconcileErr := c.reconcile(ctx, service); reconcileErr != nil {
-		c.Recorder.Event(service, corev1.EventTypeWarning, "InternalError", reconcileErr.Error())
+	reconcileErr := c.reconcile(ctx, service)
+	c.failures.observe(ctx, service, reconcileErr)
+	if reconcileErr != nil {
+		reportError(ctx, c.Recorder, c.statsReporter, service, reconcileErr)
 		logger.Errorf("Reconcile service: %s/%s error: %s ", service.Namespace, service.Name, reconcileErr.Error())
 		return reconcileErr
 	}
@@ -99,73 +218,424 @@ func (c *Reconciler) Reconcile(ctx context.Context, key string) error {
 
 func (c *Reconciler) reconcile(ctx context.Context, service *v1alpha12.Service) error {
 	logger := logging.FromContext(ctx)
+	policy := config.FromContext(ctx).GC.Policy()
 
-	routeName := resourcenames.Route(service)
-	route, err := c.routeLister.Routes(service.Namespace).Get(routeName)
-	if apierrs.IsNotFound(err) {
-		logger.Infof("controller reconcile service: %s/%s route is not found", service.Namespace, service.Name)
-		return nil
+	if policy.KeepLastDeploys > 0 {
+		if err := c.recordDeploy(ctx, service, policy.KeepLastDeploys); err != nil {
+			logger.Errorf("controller reconcile service: %s/%s record deploy error:%s", service.Namespace, service.Name, err.Error())
+			return err
+		}
+	}
+
+	if gc := config.FromContext(ctx).GC; len(gc.KeepTagHistory) > 0 {
+		if err := c.recordTags(ctx, service, gc.KeepTagHistory); err != nil {
+			logger.Errorf("controller reconcile service: %s/%s record tag history error:%s", service.Namespace, service.Name, err.Error())
+			return err
+		}
 	}
 
-	if route.Status.Traffic == nil {
-		logger.Infof("controller reconcile service: %s/%s route status.Traffic is nil", service.Namespace, service.Name)
+	if gc := config.FromContext(ctx).GC; gc.OwnershipHandoffPeriod > 0 {
+		if err := c.recordOwner(ctx, service, gc.OwnerKey, gc.OwnershipHandoffPeriod); err != nil {
+			logger.Errorf("controller reconcile service: %s/%s record owner error:%s", service.Namespace, service.Name, err.Error())
+			return err
+		}
+	}
+
+	if gc := config.FromContext(ctx).GC; gc.RollbackFreezePeriod > 0 {
+		if err := c.recordRollback(ctx, service, gc.LabelKeys, gc.RollbackFreezePeriod); err != nil {
+			logger.Errorf("controller reconcile service: %s/%s record rollback error:%s", service.Namespace, service.Name, err.Error())
+			return err
+		}
+	}
+
+	if err := c.checkStuck(ctx, service); err != nil {
+		logger.Errorf("controller reconcile service: %s/%s check stuck configuration error:%s", service.Namespace, service.Name, err.Error())
+		return err
+	}
+
+	if err := c.checkStalledDeletions(ctx, service); err != nil {
+		logger.Errorf("controller reconcile service: %s/%s check stalled deletions error:%s", service.Namespace, service.Name, err.Error())
+		return err
+	}
+
+	result, err := c.evaluate(ctx, service)
+	if err != nil {
+		logger.Errorf("controller reconcile service: %s/%s evaluate revisions error:%s", service.Namespace, service.Name, err.Error())
+		return err
+	}
+	if !result.Skipped() {
+		c.reportDuplicates(ctx, service, result.Duplicates)
+		c.labelStates(ctx, service, result)
+	}
+	if result.Skipped() {
+		c.reportBacklog(service.Namespace+"/"+service.Name, 0)
+		outcomef(c.summarizer, logger)("controller reconcile service: %s/%s skipped: %s", service.Namespace, service.Name, result.SkipReason)
+		c.summarizer.Skipped(service.Namespace, result.SkipReason)
+		if err := c.statsReporter.ReportSkipped(policy, result.SkipReason); err != nil {
+			logger.Errorf("report skipped service error: %s", err.Error())
+		}
+		switch result.SkipReason {
+		case strategy.ReasonRoutedRevisionMissing:
+			c.enqueueAfter(service, cacheSyncRecheckDelay)
+		case strategy.ReasonOwnershipHandoff:
+			if owner, err := history.OwnerFromAnnotations(service.Annotations); err == nil && owner.InHandoff(c.clock.Now()) {
+				// Evaluate again once the handoff ends.
+				c.enqueueAfter(service, owner.HandoffUntil.Time.Sub(c.clock.Now()))
+			}
+		case strategy.ReasonRollbackFreeze:
+			if rollback, err := history.RollbackFromAnnotations(service.Annotations); err == nil && rollback.Frozen(c.clock.Now()) {
+				// Evaluate again once the freeze ends.
+				c.enqueueAfter(service, rollback.FrozenUntil.Time.Sub(c.clock.Now()))
+			}
+		}
+		return c.recordPlan(ctx, service, nil, nil)
+	}
+
+	c.reportBacklog(service.Namespace+"/"+service.Name, len(result.Candidates))
+	if result.QuotaPressure != "" {
+		logger.Infof("controller reconcile service: %s/%s namespace near its quota (%s), collecting with the quota pressure retain count and minimum age",
+			service.Namespace, service.Name, result.QuotaPressure)
+	}
+	if err := c.statsReporter.ReportRetained(policy, result.Retained); err != nil {
+		logger.Errorf("report retained revisions error: %s", err.Error())
+	}
+	if expiry, ok := protectionExpiry(result.Retained); ok {
+		// Evaluate again once the protection ends, the revision may be due then.
+		c.enqueueAfter(service, expiry.Sub(c.clock.Now()))
+	}
+
+	if len(result.Candidates) == 0 {
+		c.withheld.set(service.Namespace+"/"+service.Name, 0)
+		c.confirmations.forget(service.Namespace + "/" + service.Name)
+		return c.recordPlan(ctx, service, nil, nil)
+	}
+	if wait := c.relists.settling(); wait > 0 {
+		// The caches may disagree right after a relist, keep the plan as is.
+		logger.Infof("controller reconcile service: %s/%s informers relisted, deferring the plan by %s", service.Namespace, service.Name, wait)
+		c.enqueueAfter(service, wait+c.relists.delay())
 		return nil
 	}
+	candidates, wait := c.confirmations.confirm(service.Namespace+"/"+service.Name, result.Candidates, config.FromContext(ctx).GC.CandidateConfirmationPeriod)
+	if wait > 0 {
+		// Evaluate again once the next candidate can be confirmed.
+		logger.Infof("controller reconcile service: %s/%s %d of %d candidates awaiting confirmation, evaluating again in %s",
+			service.Namespace, service.Name, len(result.Candidates)-len(candidates), len(result.Candidates), wait)
+		c.enqueueAfter(service, wait)
+	}
+	if len(candidates) == 0 {
+		c.withheld.set(service.Namespace+"/"+service.Name, 0)
+		return c.recordPlan(ctx, service, nil, nil)
+	}
+	names := make([]string, 0, len(candidates))
+	for _, d := range candidates {
+		names = append(names, d.Revision.Name)
+	}
+	if limit := policy.NotifyWhenCandidatesExceed; limit > 0 && len(names) > limit {
+		c.withholdPlan(ctx, service, names, limit)
+		return c.recordPlan(ctx, service, nil, nil)
+	}
+	c.withheld.set(service.Namespace+"/"+service.Name, 0)
+	desired := plan.New(policy.Name, names, v1.Now())
+	desired.DecisionID = tracing.DecisionID(ctx)
+	return c.recordPlan(ctx, service, desired, candidates)
+}
+
+// withholdPlan notifies about the candidates of the Service exceeding the
+// limit of the policy, once per change of their number, instead of planning
+// their deletion.
+func (c *Reconciler) withholdPlan(ctx context.Context, service *v1alpha12.Service, names []string, limit int) {
+	logger := logging.FromContext(ctx)
+	if previous, _, _ := c.withheld.set(service.Namespace+"/"+service.Name, len(names)); previous == len(names) {
+		return
+	}
+
+	logger.Infof("controller reconcile service: %s/%s %d candidates exceed %d, deletion withheld", service.Namespace, service.Name, len(names), limit)
+	tracing.EventRecorder(ctx, c.Recorder).Eventf(service, corev1.EventTypeWarning, "CandidatesExceeded",
+		"Found %d deletion candidates, more than the %d the policy deletes without attention; deletion withheld", len(names), limit)
+	notify(ctx, &notifier.Notification{
+		Kind:      notifier.KindCandidatesExceeded,
+		Namespace: service.Namespace,
+		Service:   service.Name,
+		Revisions: names,
+		Message:   fmt.Sprintf("found %d deletion candidates, more than the %d the policy deletes without attention; deletion withheld", len(names), limit),
+	})
+}
+
+// backlog returns the queue priority of a Service key: the tier of its
+// namespace, then the number of its revisions, so catch-up sweeps plan the
+// higher tiers and the Services likely to have the most stale revisions
+// first.
+func (c *Reconciler) backlog(item interface{}) int {
+	namespace, name, err := cache.SplitMetaNamespaceKey(item.(string))
+	if err != nil {
+		return 0
+	}
+	cfg := c.configStore.Load().GC
+	tier := cfg.Tier(namespace) * tierWeight
+	service, err := c.serviceLister.Services(namespace).Get(name)
+	if err != nil {
+		return tier
+	}
+	revisions, err := c.revisionLister.Revisions(namespace).List(cfg.LabelKeys.RevisionSelector(service))
+	if err != nil {
+		return tier
+	}
+	if len(revisions) >= tierWeight {
+		return tier + tierWeight - 1
+	}
+	return tier + len(revisions)
+}
+
+// protectionExpiry returns the end of the first lease, activation cooldown,
+// rollback window or rollout holding a retained revision.
+func protectionExpiry(retained []strategy.Decision) (time.Time, bool) {
+	var expiry time.Time
+	for _, d := range retained {
+		var until time.Time
+		switch d.Reason {
+		case strategy.ReasonLeased:
+			until, _ = strategy.LeasedUntil(d.Revision)
+		case strategy.ReasonActivated, strategy.ReasonRollbackWindow, strategy.ReasonRollout:
+			until = d.EligibleAt
+		}
+		if !until.IsZero() && (expiry.IsZero() || until.Before(expiry)) {
+			expiry = until
+		}
+	}
+	return expiry, !expiry.IsZero()
+}
+
+// unreadableHistory returns the error reconciling a Service whose recorded
+// history is unreadable. It is not started over: like gc.Engine.Inputs, the
+// Service is not evaluated until the annotation is repaired or removed, so
+// the revisions and the handoff or freeze it records stay protected.
+func unreadableHistory(err error) error {
+	return &gcerrors.PolicyResolutionError{Err: fmt.Errorf("%v, repair or remove it to resume garbage collection", err)}
+}
 
-	if len(route.Status.Traffic) > 1 {
-		logger.Infof("controller reconcile service: %s/%s route traffic is not LatestRevision only", service.Namespace, service.Name)
+// recordDeploy appends the current Configuration generation to the deploy
+// history recorded on the Service, keeping the last limit deploys.
+func (c *Reconciler) recordDeploy(ctx context.Context, service *v1alpha12.Service, limit int) error {
+	cfg, err := c.configurationLister.Configurations(service.Namespace).Get(resourcenames.Configuration(service))
+	if apierrs.IsNotFound(err) {
 		return nil
+	} else if err != nil {
+		return err
 	}
 
-	tt := route.Status.Traffic[0]
-	if !*tt.LatestRevision {
-		logger.Infof("controller reconcile service: %s/%s route status.traffic is not LatestRevision", service.Namespace, service.Name)
+	h, err := history.FromAnnotations(service.Annotations)
+	if err != nil {
+		return unreadableHistory(err)
+	}
+	h, changed := h.Record(cfg.Generation, v1.Now(), limit)
+	if !changed {
 		return nil
 	}
 
-	latestRevisionName := tt.RevisionName
-	latestRevision, err := c.revisionLister.Revisions(service.Namespace).Get(latestRevisionName)
+	patch, err := history.MergePatch(h)
 	if err != nil {
-		logger.Infof("controller reconcile service: %s/%s get LatestRevision: %s error:%s", service.Namespace, service.Name, latestRevisionName, err.Error())
 		return err
 	}
+	updated, err := apicall.PatchService(ctx, c.revisionClientSet, service.Namespace, service.Name, types.MergePatchType, patch)
+	if err != nil {
+		return err
+	}
+	service.Annotations = updated.Annotations
+	return nil
+}
+
+// recordTags records the revisions the traffic tags of the Route point to in
+// the tag history of the Service, keeping the last limits[tag] holders.
+func (c *Reconciler) recordTags(ctx context.Context, service *v1alpha12.Service, limits map[string]int) error {
+	current := make(map[string]string)
+	route, err := c.routeLister.Routes(service.Namespace).Get(resourcenames.Route(service))
+	if err != nil && !apierrs.IsNotFound(err) {
+		return err
+	} else if err == nil {
+		for _, t := range route.Status.Traffic {
+			tag := t.Tag
+			if tag == "" {
+				tag = t.DeprecatedName
+			}
+			if tag != "" && t.RevisionName != "" {
+				current[tag] = t.RevisionName
+			}
+		}
+	}
+
+	tags, err := history.TagsFromAnnotations(service.Annotations)
+	if err != nil {
+		return unreadableHistory(err)
+	}
+	tags, changed := tags.Record(current, v1.Now(), limits)
+	if !changed {
+		return nil
+	}
 
-	latestConfigurationGeneration := latestRevision.Labels[serving.ConfigurationGenerationLabelKey]
-	latestConfigurationGenerationVal, err := strconv.Atoi(latestConfigurationGeneration)
+	patch, err := history.TagsMergePatch(tags)
 	if err != nil {
-		logger.Errorf("controller reconcile service: %s/%s get LatestRevision configurationGeneration:%s error:%s", latestConfigurationGeneration, err.Error())
 		return err
 	}
+	updated, err := apicall.PatchService(ctx, c.revisionClientSet, service.Namespace, service.Name, types.MergePatchType, patch)
+	if err != nil {
+		return err
+	}
+	service.Annotations = updated.Annotations
+	return nil
+}
 
-	revisions, err := c.revisionLister.Revisions(service.Namespace).List(labels.SelectorFromSet(map[string]string{
-		serving.ServiceLabelKey:       service.Name,
-		serving.ConfigurationLabelKey: resourcenames.Configuration(service),
-	}))
+// recordOwner records the owner of the Service, named by its key label or
+// annotation, and starts a handoff of period when it changed, notifying the
+// previous and the new owner.
+func (c *Reconciler) recordOwner(ctx context.Context, service *v1alpha12.Service, key string, period time.Duration) error {
+	logger := logging.FromContext(ctx)
+
+	current, labeled := service.Labels[key]
+	if !labeled {
+		current = service.Annotations[key]
+	}
+
+	recorded, err := history.OwnerFromAnnotations(service.Annotations)
+	if err != nil {
+		return unreadableHistory(err)
+	}
+	owner, changed, handoff := recorded.Observe(current, c.clock.Now(), period)
+	if !changed {
+		return nil
+	}
+
+	patch, err := history.OwnerMergePatch(owner)
+	if err != nil {
+		return err
+	}
+	updated, err := apicall.PatchService(ctx, c.revisionClientSet, service.Namespace, service.Name, types.MergePatchType, patch)
 	if err != nil {
-		logger.Infof("controller reconcile service: %s/%s get revisions error:%s", service.Namespace, service.Name, err.Error())
 		return err
 	}
+	service.Annotations = updated.Annotations
 
-	for _, re := range revisions {
-		configurationGeneration := re.Labels[serving.ConfigurationGenerationLabelKey]
+	if handoff {
+		until := owner.HandoffUntil.Format(time.RFC3339)
+		previous, next := owner.Previous, owner.Owner
+		if !labeled {
+			// Annotation values may carry secrets, only the owners field of
+			// the notification names them unmasked for the sinks.
+			safe := config.FromContext(ctx).GC.RedactionSafeAnnotations
+			previous, next = redact.Value(safe, key, previous), redact.Value(safe, key, next)
+		}
+		logger.Infof("controller reconcile service: %s/%s owner changed from %q to %q, suspended until %s", service.Namespace, service.Name, previous, next, until)
+		tracing.EventRecorder(ctx, c.Recorder).Eventf(service, corev1.EventTypeWarning, "OwnershipHandoff",
+			"Owner changed from %q to %q, garbage collection suspended until %s", previous, next, until)
+		notify(ctx, &notifier.Notification{
+			Kind:      notifier.KindOwnershipHandoff,
+			Namespace: service.Namespace,
+			Service:   service.Name,
+			Owners:    []string{owner.Previous, owner.Owner},
+			Message:   fmt.Sprintf("owner changed from %q to %q, garbage collection suspended until %s", previous, next, until),
+		})
+	}
+	return nil
+}
 
-		val, err := strconv.Atoi(configurationGeneration)
+// recordRollback records the newest generation the Route of the Service
+// sends traffic to, and freezes garbage collection of the Service for period
+// when the Route moved back to an older generation.
+func (c *Reconciler) recordRollback(ctx context.Context, service *v1alpha12.Service, keys strategy.LabelKeys, period time.Duration) error {
+	logger := logging.FromContext(ctx)
+
+	route, err := c.routeLister.Routes(service.Namespace).Get(resourcenames.Route(service))
+	if apierrs.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	generation := 0
+	for _, t := range strategy.NewTrafficIndex(route).Serving() {
+		revision, err := c.revisionLister.Revisions(service.Namespace).Get(t.RevisionName)
 		if err != nil {
-			logger.Errorf("controller reconcile service: %s/%s get revisions %s configurationGeneration: %s error: %s", service.Namespace, service.Name, re.Name, configurationGeneration, err.Error())
-			continue
+			// The caches may disagree, observe the generation once they agree.
+			return nil
+		}
+		if g, err := keys.Generation(revision); err == nil && g > generation {
+			generation = g
 		}
+	}
+	if generation == 0 {
+		return nil
+	}
+
+	recorded, err := history.RollbackFromAnnotations(service.Annotations)
+	if err != nil {
+		return unreadableHistory(err)
+	}
+	rollback, changed, frozen := recorded.Observe(generation, c.clock.Now(), period)
+	if !changed {
+		return nil
+	}
+
+	patch, err := history.RollbackMergePatch(rollback)
+	if err != nil {
+		return err
+	}
+	updated, err := apicall.PatchService(ctx, c.revisionClientSet, service.Namespace, service.Name, types.MergePatchType, patch)
+	if err != nil {
+		return err
+	}
+	service.Annotations = updated.Annotations
+
+	if frozen {
+		until := rollback.FrozenUntil.Format(time.RFC3339)
+		logger.Infof("controller reconcile service: %s/%s rolled back from generation %d to %d, frozen until %s", service.Namespace, service.Name, rollback.From, rollback.Generation, until)
+		tracing.EventRecorder(ctx, c.Recorder).Eventf(service, corev1.EventTypeWarning, "RollbackDetected",
+			"Traffic rolled back from generation %d to %d, garbage collection frozen until %s", rollback.From, rollback.Generation, until)
+	}
+	return nil
+}
 
-		if val >= latestConfigurationGenerationVal {
-			continue
+// recordPlan records the desired plan of the candidates on the Service, or
+// removes the recorded plan when desired is nil. A recorded plan for the
+// same revisions is kept. The estimated footprint of the planned revisions
+// is reported in events, and a new plan is archived to the decision log.
+func (c *Reconciler) recordPlan(ctx context.Context, service *v1alpha12.Service, desired *plan.Plan, candidates []strategy.Decision) error {
+	logger := logging.FromContext(ctx)
+
+	existing, err := plan.FromAnnotations(service.Annotations)
+	if err != nil {
+		logger.Errorf("controller reconcile service: %s/%s read plan error:%s", service.Namespace, service.Name, err.Error())
+	} else if existing.SameRevisions(desired) {
+		return nil
+	} else if existing == nil && desired == nil {
+		return nil
+	}
+
+	patch, err := plan.MergePatch(desired)
+	if err != nil {
+		return err
+	}
+	if _, err := apicall.PatchService(ctx, c.revisionClientSet, service.Namespace, service.Name, types.MergePatchType, patch); err != nil {
+		logger.Errorf("controller reconcile service: %s/%s record plan error:%s", service.Namespace, service.Name, err.Error())
+		return err
+	}
+
+	if desired != nil {
+		estimate := c.footprint(candidates)
+		c.decisions.Record(decisionlog.TypePlanned, decision(ctx, service, desired.Policy, candidates), c.clock.Now())
+		outcomef(c.summarizer, logger)("controller reconcile service: %s/%s planned deletion of revisions: %v as plan %s", service.Namespace, service.Name, desired.Revisions, desired.Hash())
+		c.summarizer.Planned(service.Namespace, len(desired.Revisions))
+		if !c.summarizer.Enabled() {
+			tracing.EventRecorder(ctx, c.Recorder).Eventf(service, corev1.EventTypeNormal, "DeletionPlanned",
+				"Planned deletion of %d revisions (%s) as plan %s, estimated reclaim %s", len(desired.Revisions), strings.Join(desired.Revisions, ", "), desired.Hash(), estimate)
 		}
 
-		if err := c.revisionClientSet.ServingV1alpha1().Revisions(service.Namespace).Delete(re.Name, &v1.DeleteOptions{}); err != nil {
-			if !apierrs.IsNotFound(err) {
-				logger.Errorf("controller reconcile service: %s/%s delete revisions:%s error:%s", service.Namespace, service.Name, re.Name, err.Error())
-			}
-			continue
+		if threshold := config.FromContext(ctx).Notifications.PlanSizeThreshold; threshold > 0 && len(desired.Revisions) > threshold {
+			notify(ctx, &notifier.Notification{
+				Kind:      notifier.KindLargePlan,
+				Namespace: service.Namespace,
+				Service:   service.Name,
+				Revisions: desired.Revisions,
+				Message:   fmt.Sprintf("deletion plan of %d revisions exceeds the threshold of %d, estimated reclaim %s", len(desired.Revisions), threshold, estimate),
+			})
 		}
 	}
-
 	return nil
 }
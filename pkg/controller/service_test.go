@@ -0,0 +1,123 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/knative-sample/revision-controller/pkg/clock"
+	"github.com/knative-sample/revision-controller/pkg/gcerrors"
+	"github.com/knative-sample/revision-controller/pkg/history"
+	"github.com/knative-sample/revision-controller/pkg/strategy"
+	"go.uber.org/zap"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+	"knative.dev/pkg/logging"
+	"knative.dev/serving/pkg/apis/serving/v1alpha1"
+	"knative.dev/serving/pkg/apis/serving/v1beta1"
+	listers "knative.dev/serving/pkg/client/listers/serving/v1alpha1"
+)
+
+// TestRecordUnreadableHistory checks that an unreadable history recorded on
+// the Service stops its reconcile with a PolicyResolutionError, as in
+// gc.Engine.Inputs, instead of being started over. The Reconciler has no
+// clientset, a patch would panic.
+func TestRecordUnreadableHistory(t *testing.T) {
+	keys := strategy.DefaultLabelKeys()
+	indexer := func() cache.Indexer {
+		return cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	}
+
+	configurationIndexer := indexer()
+	configurationIndexer.Add(&v1alpha1.Configuration{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "hello", Generation: 2}})
+	revisionIndexer := indexer()
+	revisionIndexer.Add(&v1alpha1.Revision{ObjectMeta: metav1.ObjectMeta{
+		Namespace: "default",
+		Name:      "hello-00002",
+		Labels: map[string]string{
+			keys.Service:                 "hello",
+			keys.Configuration:           "hello",
+			keys.ConfigurationGeneration: "2",
+		},
+	}})
+	route := &v1alpha1.Route{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "hello"}}
+	route.Status.Traffic = []v1alpha1.TrafficTarget{{TrafficTarget: v1beta1.TrafficTarget{
+		RevisionName: "hello-00002", Tag: "stable", Percent: 100,
+	}}}
+	routeIndexer := indexer()
+	routeIndexer.Add(route)
+
+	c := &Reconciler{
+		revisionEvaluator: &revisionEvaluator{
+			routeLister:    listers.NewRouteLister(routeIndexer),
+			revisionLister: listers.NewRevisionLister(revisionIndexer),
+			clock:          clock.Frozen(time.Date(2019, 8, 1, 12, 0, 0, 0, time.UTC)),
+		},
+		configurationLister: listers.NewConfigurationLister(configurationIndexer),
+	}
+	ctx := logging.WithLogger(context.Background(), zap.NewNop().Sugar())
+
+	tests := []struct {
+		name       string
+		annotation string
+		record     func(*v1alpha1.Service) error
+	}{{
+		name:       "deploy history",
+		annotation: history.AnnotationKey,
+		record: func(s *v1alpha1.Service) error {
+			return c.recordDeploy(ctx, s, 3)
+		},
+	}, {
+		name:       "tag history",
+		annotation: history.TagsAnnotationKey,
+		record: func(s *v1alpha1.Service) error {
+			return c.recordTags(ctx, s, map[string]int{"stable": 3})
+		},
+	}, {
+		name:       "owner",
+		annotation: history.OwnerAnnotationKey,
+		record: func(s *v1alpha1.Service) error {
+			return c.recordOwner(ctx, s, "team", time.Hour)
+		},
+	}, {
+		name:       "rollback",
+		annotation: history.RollbackAnnotationKey,
+		record: func(s *v1alpha1.Service) error {
+			return c.recordRollback(ctx, s, keys, time.Hour)
+		},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			service := &v1alpha1.Service{ObjectMeta: metav1.ObjectMeta{
+				Namespace:   "default",
+				Name:        "hello",
+				Labels:      map[string]string{"team": "payments"},
+				Annotations: map[string]string{test.annotation: "{not json"},
+			}}
+			err := test.record(service)
+			if _, ok := err.(*gcerrors.PolicyResolutionError); !ok {
+				t.Errorf("record = %v, want a PolicyResolutionError", err)
+			}
+			if got := service.Annotations[test.annotation]; got != "{not json" {
+				t.Errorf("annotation = %q, want it left for repair", got)
+			}
+		})
+	}
+}
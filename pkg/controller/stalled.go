@@ -0,0 +1,165 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/knative-sample/revision-controller/pkg/apicall"
+	"github.com/knative-sample/revision-controller/pkg/config"
+	"github.com/knative-sample/revision-controller/pkg/notifier"
+	"github.com/knative-sample/revision-controller/pkg/tracing"
+	corev1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"knative.dev/pkg/logging"
+	"knative.dev/serving/pkg/apis/serving/v1alpha1"
+)
+
+// stalledDeletions tracks, per Service key, the revisions whose deletion
+// stalled: they have a deletion timestamp but remain, e.g. held by the
+// finalizer of a webhook.
+type stalledDeletions struct {
+	mu    sync.Mutex
+	byKey map[string]map[string]bool
+}
+
+func newStalledDeletions() *stalledDeletions {
+	return &stalledDeletions{byKey: make(map[string]map[string]bool)}
+}
+
+// set records the stalled revisions of key and returns the ones that were
+// not stalled before together with the total across all Services.
+func (s *stalledDeletions) set(key string, names []string) (added []string, total int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	previous := s.byKey[key]
+	current := make(map[string]bool, len(names))
+	for _, name := range names {
+		current[name] = true
+		if !previous[name] {
+			added = append(added, name)
+		}
+	}
+	if len(current) > 0 {
+		s.byKey[key] = current
+	} else {
+		delete(s.byKey, key)
+	}
+
+	for _, revisions := range s.byKey {
+		total += len(revisions)
+	}
+	return added, total
+}
+
+// reportStalled records the stalled revisions of the Service key, reports
+// the cluster wide total and returns the newly stalled ones.
+func (c *Reconciler) reportStalled(key string, names []string) []string {
+	added, total := c.stalled.set(key, names)
+	if err := c.statsReporter.ReportStalled(total); err != nil {
+		c.Logger.Errorf("report stalled deletions error: %s", err.Error())
+	}
+	return added
+}
+
+// checkStalledDeletions reports the revisions of the Service that remain
+// longer than the stalled deletion timeout after their deletion, and keeps
+// requeueing the Service until they are gone so they are not forgotten.
+// With the retry action their deletion is re-issued.
+func (c *Reconciler) checkStalledDeletions(ctx context.Context, service *v1alpha1.Service) error {
+	logger := logging.FromContext(ctx)
+	gc := config.FromContext(ctx).GC
+	key := service.Namespace + "/" + service.Name
+
+	if gc.StalledDeletionTimeout <= 0 {
+		c.reportStalled(key, nil)
+		return nil
+	}
+	revisions, err := c.revisionLister.Revisions(service.Namespace).List(gc.LabelKeys.RevisionSelector(service))
+	if err != nil {
+		return err
+	}
+
+	now := c.clock.Now()
+	var stalled []*v1alpha1.Revision
+	var recheck time.Duration
+	for _, re := range revisions {
+		if re.DeletionTimestamp == nil {
+			continue
+		}
+		if wait := re.DeletionTimestamp.Add(gc.StalledDeletionTimeout).Sub(now); wait > 0 {
+			if recheck == 0 || wait < recheck {
+				recheck = wait
+			}
+			continue
+		}
+		stalled = append(stalled, re)
+	}
+	sort.Slice(stalled, func(i, j int) bool {
+		return stalled[i].Name < stalled[j].Name
+	})
+	if len(stalled) > 0 {
+		// Check again until the stalled revisions are gone.
+		recheck = gc.StalledDeletionTimeout
+	}
+	if recheck > 0 {
+		c.enqueueAfter(service, recheck)
+	}
+
+	names := make([]string, 0, len(stalled))
+	for _, re := range stalled {
+		names = append(names, re.Name)
+	}
+	added := make(map[string]bool)
+	for _, name := range c.reportStalled(key, names) {
+		added[name] = true
+	}
+
+	for _, re := range stalled {
+		if added[re.Name] {
+			message := fmt.Sprintf("revision %s is still there %s after its deletion, held by finalizers [%s]",
+				re.Name, now.Sub(re.DeletionTimestamp.Time).Round(time.Second), strings.Join(re.Finalizers, ", "))
+			logger.Errorf("controller reconcile service: %s/%s deletion stalled: %s", service.Namespace, service.Name, message)
+			tracing.EventRecorder(ctx, c.Recorder).Eventf(service, corev1.EventTypeWarning, "DeletionStalled", "Deletion stalled: %s", message)
+			notify(ctx, &notifier.Notification{
+				Kind:      notifier.KindDeletionStalled,
+				Namespace: service.Namespace,
+				Service:   service.Name,
+				Revisions: []string{re.Name},
+				Message:   "deletion stalled: " + message,
+			})
+		}
+		if gc.StalledDeletionAction != config.StalledDeletionRetry {
+			continue
+		}
+		background := v1.DeletePropagationBackground
+		err := apicall.DeleteRevision(ctx, c.revisionClientSet, service.Namespace, re.Name, &v1.DeleteOptions{PropagationPolicy: &background})
+		if err != nil && !apierrs.IsNotFound(err) {
+			logger.Errorf("controller reconcile service: %s/%s retry deletion of revision:%s error:%s", service.Namespace, service.Name, re.Name, err.Error())
+			continue
+		}
+		logger.Infof("controller reconcile service: %s/%s retried stalled deletion of revision:%s", service.Namespace, service.Name, re.Name)
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	"github.com/knative-sample/revision-controller/pkg/apicall"
+	"github.com/knative-sample/revision-controller/pkg/config"
+	"github.com/knative-sample/revision-controller/pkg/strategy"
+	"k8s.io/apimachinery/pkg/types"
+	"knative.dev/pkg/logging"
+	"knative.dev/serving/pkg/apis/serving/v1alpha1"
+)
+
+// labelStates labels the revisions of the Service with their state in the
+// result, only patching those whose label differs. The labels are removed
+// when disabled. A revision failing to be labeled is retried on the next
+// reconcile.
+func (c *Reconciler) labelStates(ctx context.Context, service *v1alpha1.Service, result *strategy.Result) {
+	logger := logging.FromContext(ctx)
+	gc := config.FromContext(ctx).GC
+
+	revisions, err := c.revisionLister.Revisions(service.Namespace).List(gc.LabelKeys.RevisionSelector(service))
+	if err != nil {
+		logger.Errorf("controller reconcile service: %s/%s list revisions error:%s", service.Namespace, service.Name, err.Error())
+		return
+	}
+
+	states := result.States()
+	for _, re := range revisions {
+		var desired strategy.State
+		if gc.LabelRevisionState {
+			desired = states[re.Name]
+		}
+		current, labeled := re.Labels[strategy.StateLabelKey]
+		if (desired == "" && !labeled) || (labeled && strategy.State(current) == desired) {
+			continue
+		}
+		// A revision created since the evaluation has no state yet, keep
+		// its label until it is evaluated.
+		if _, ok := states[re.Name]; !ok && gc.LabelRevisionState {
+			continue
+		}
+		patch, err := strategy.StateMergePatch(desired)
+		if err != nil {
+			logger.Errorf("controller reconcile service: %s/%s label revision:%s state error:%s", service.Namespace, service.Name, re.Name, err.Error())
+			continue
+		}
+		if _, err := apicall.PatchRevision(ctx, c.revisionClientSet, re.Namespace, re.Name, types.MergePatchType, patch); err != nil {
+			logger.Errorf("controller reconcile service: %s/%s label revision:%s state error:%s", service.Namespace, service.Name, re.Name, err.Error())
+		}
+	}
+}
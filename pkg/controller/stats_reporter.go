@@ -0,0 +1,551 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"time"
+
+	"github.com/knative-sample/revision-controller/pkg/footprint"
+	"github.com/knative-sample/revision-controller/pkg/gcerrors"
+	"github.com/knative-sample/revision-controller/pkg/quota"
+	"github.com/knative-sample/revision-controller/pkg/strategy"
+	"github.com/knative-sample/revision-controller/pkg/tracing"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+	"knative.dev/pkg/metrics"
+)
+
+const (
+	// HeldServicesN is the number of Services with deletions held back.
+	HeldServicesN = "held_services"
+	// HeldRevisionsN is the number of revision deletions held back.
+	HeldRevisionsN = "held_revisions"
+	// StalledDeletionsN is the number of revisions whose deletion stalled.
+	StalledDeletionsN = "stalled_deletions"
+	// RevisionsDeletedN is the number of revisions deleted.
+	RevisionsDeletedN = "revisions_deleted"
+	// RevisionsDeletedPerReconcileN is the distribution of the revisions
+	// deleted by a reconcile, carrying exemplars linking to its trace.
+	RevisionsDeletedPerReconcileN = "revisions_deleted_per_reconcile"
+	// RevisionsRetainedN is the number of revisions retained by a reconcile.
+	RevisionsRetainedN = "revisions_retained"
+	// ServicesSkippedN is the number of reconciles that skipped a Service.
+	ServicesSkippedN = "services_skipped"
+	// ReclaimedCPUN is the estimated CPU reclaimed by deletions.
+	ReclaimedCPUN = "reclaimed_cpu_millicores"
+	// ReclaimedMemoryN is the estimated memory reclaimed by deletions.
+	ReclaimedMemoryN = "reclaimed_memory_bytes"
+	// DeletionLatencyN is the distribution of the time from a revision
+	// becoming eligible for deletion to its deletion.
+	DeletionLatencyN = "deletion_latency_seconds"
+	// QuotaRemainingN is the number of deletions left in a quota.
+	QuotaRemainingN = "deletion_quota_remaining"
+	// ChildResourcesSweptN is the number of orphaned child resources deleted.
+	ChildResourcesSweptN = "child_resources_swept"
+	// ReconcileErrorsN is the number of reconcile errors, by type.
+	ReconcileErrorsN = "reconcile_errors"
+	// RevisionsAvoidedN is the number of revisions that would exist without
+	// garbage collection.
+	RevisionsAvoidedN = "revisions_avoided"
+	// RevisionDaysSavedN is the number of days the deleted revisions would
+	// have existed without garbage collection.
+	RevisionDaysSavedN = "revision_days_saved"
+	// StaleBacklogCandidatesN is the number of deletion candidates found
+	// by the last evaluation of every Service.
+	StaleBacklogCandidatesN = "stale_backlog_candidates"
+	// StaleBacklogAverageN is the average number of deletion candidates
+	// per Service.
+	StaleBacklogAverageN = "stale_backlog_average"
+	// ResyncIntervalN is the adaptive interval every Service is evaluated
+	// again at.
+	ResyncIntervalN = "resync_interval_seconds"
+	// GCDivergenceN is the number of divergences the audit found between
+	// the expected deletions and the recorded plans.
+	GCDivergenceN = "gc_divergence_total"
+)
+
+var (
+	heldServicesStat = stats.Int64(
+		HeldServicesN,
+		"Number of Services with revision deletions held by the maintenance hold",
+		stats.UnitDimensionless)
+	heldRevisionsStat = stats.Int64(
+		HeldRevisionsN,
+		"Number of revision deletions held by the maintenance hold",
+		stats.UnitDimensionless)
+	stalledDeletionsStat = stats.Int64(
+		StalledDeletionsN,
+		"Number of deleted revisions remaining past the stalled deletion timeout, e.g. held by finalizers",
+		stats.UnitDimensionless)
+	revisionsDeletedStat = stats.Int64(
+		RevisionsDeletedN,
+		"Number of revisions deleted",
+		stats.UnitDimensionless)
+	revisionsRetainedStat = stats.Int64(
+		RevisionsRetainedN,
+		"Number of revisions retained by reconciles",
+		stats.UnitDimensionless)
+	servicesSkippedStat = stats.Int64(
+		ServicesSkippedN,
+		"Number of reconciles that skipped a Service",
+		stats.UnitDimensionless)
+	reclaimedCPUStat = stats.Int64(
+		ReclaimedCPUN,
+		"Estimated CPU requests reclaimed by revision deletions",
+		"m")
+	reclaimedMemoryStat = stats.Int64(
+		ReclaimedMemoryN,
+		"Estimated memory requests reclaimed by revision deletions",
+		stats.UnitBytes)
+	deletionLatencyStat = stats.Float64(
+		DeletionLatencyN,
+		"Time from a revision becoming stale and old enough for deletion to its deletion",
+		"s")
+	quotaRemainingStat = stats.Int64(
+		QuotaRemainingN,
+		"Number of revision deletions left in the current quota period",
+		stats.UnitDimensionless)
+	childResourcesSweptStat = stats.Int64(
+		ChildResourcesSweptN,
+		"Number of Secrets and ConfigMaps of deleted revisions deleted",
+		stats.UnitDimensionless)
+	reconcileErrorsStat = stats.Int64(
+		ReconcileErrorsN,
+		"Number of reconcile errors",
+		stats.UnitDimensionless)
+	revisionsAvoidedStat = stats.Int64(
+		RevisionsAvoidedN,
+		"Number of revisions of existing Services that would exist without garbage collection",
+		stats.UnitDimensionless)
+	revisionDaysSavedStat = stats.Float64(
+		RevisionDaysSavedN,
+		"Days the deleted revisions of existing Services would have existed without garbage collection",
+		"d")
+	staleBacklogCandidatesStat = stats.Int64(
+		StaleBacklogCandidatesN,
+		"Number of deletion candidates found by the last evaluation of every Service",
+		stats.UnitDimensionless)
+	staleBacklogAverageStat = stats.Float64(
+		StaleBacklogAverageN,
+		"Average number of deletion candidates per Service",
+		stats.UnitDimensionless)
+	gcDivergenceStat = stats.Int64(
+		GCDivergenceN,
+		"Number of divergences the audit found between the expected deletions and the recorded plans",
+		stats.UnitDimensionless)
+	resyncIntervalStat = stats.Float64(
+		ResyncIntervalN,
+		"Interval every Service is evaluated again at, adapted to the stale backlog",
+		"s")
+
+	reconcilerTagKey      tag.Key
+	workspaceTagKey       tag.Key
+	policyNameTagKey      tag.Key
+	policyNamespaceTagKey tag.Key
+	reasonTagKey          tag.Key
+	scopeTagKey           tag.Key
+	namespaceTagKey       tag.Key
+	teamTagKey            tag.Key
+	periodTagKey          tag.Key
+	resourceTagKey        tag.Key
+	errorTypeTagKey       tag.Key
+	divergenceTagKey      tag.Key
+)
+
+func init() {
+	var err error
+	// Create the tag keys that will be used to add tags to our measurements.
+	// Tag keys must conform to the restrictions described in
+	// go.opencensus.io/tag/validate.go. Currently those restrictions are:
+	// - length between 1 and 255 inclusive
+	// - characters are printable US-ASCII
+	reconcilerTagKey = mustNewTagKey("reconciler")
+	workspaceTagKey = mustNewTagKey("workspace")
+	policyNameTagKey = mustNewTagKey("policy_name")
+	policyNamespaceTagKey = mustNewTagKey("policy_namespace")
+	reasonTagKey = mustNewTagKey("reason")
+	scopeTagKey = mustNewTagKey("scope")
+	namespaceTagKey = mustNewTagKey("namespace_name")
+	teamTagKey = mustNewTagKey("team")
+	periodTagKey = mustNewTagKey("period")
+	resourceTagKey = mustNewTagKey("resource")
+	errorTypeTagKey = mustNewTagKey("error_type")
+	divergenceTagKey = mustNewTagKey("kind")
+
+	// Create views to see our measurements. This can return an error if
+	// a previously-registered view has the same name with a different value.
+	// View name defaults to the measure name if unspecified.
+	err = view.Register(
+		&view.View{
+			Description: heldServicesStat.Description(),
+			Measure:     heldServicesStat,
+			Aggregation: view.LastValue(),
+			TagKeys:     []tag.Key{reconcilerTagKey, workspaceTagKey},
+		},
+		&view.View{
+			Description: heldRevisionsStat.Description(),
+			Measure:     heldRevisionsStat,
+			Aggregation: view.LastValue(),
+			TagKeys:     []tag.Key{reconcilerTagKey, workspaceTagKey},
+		},
+		&view.View{
+			Description: stalledDeletionsStat.Description(),
+			Measure:     stalledDeletionsStat,
+			Aggregation: view.LastValue(),
+			TagKeys:     []tag.Key{reconcilerTagKey, workspaceTagKey},
+		},
+		&view.View{
+			Description: revisionsDeletedStat.Description(),
+			Measure:     revisionsDeletedStat,
+			Aggregation: view.Sum(),
+			TagKeys:     []tag.Key{reconcilerTagKey, workspaceTagKey, policyNameTagKey, policyNamespaceTagKey, teamTagKey},
+		},
+		&view.View{
+			// Only distributions keep the exemplars of the measurements.
+			Name:        RevisionsDeletedPerReconcileN,
+			Description: "Distribution of the number of revisions deleted by a reconcile",
+			Measure:     revisionsDeletedStat,
+			Aggregation: view.Distribution(metrics.Buckets125(1, 100)...),
+			TagKeys:     []tag.Key{reconcilerTagKey, workspaceTagKey, policyNameTagKey, policyNamespaceTagKey, teamTagKey},
+		},
+		&view.View{
+			Description: revisionsRetainedStat.Description(),
+			Measure:     revisionsRetainedStat,
+			Aggregation: view.Sum(),
+			TagKeys:     []tag.Key{reconcilerTagKey, workspaceTagKey, policyNameTagKey, policyNamespaceTagKey, reasonTagKey},
+		},
+		&view.View{
+			Description: reclaimedCPUStat.Description(),
+			Measure:     reclaimedCPUStat,
+			Aggregation: view.Sum(),
+			TagKeys:     []tag.Key{reconcilerTagKey, workspaceTagKey, policyNameTagKey, policyNamespaceTagKey, teamTagKey},
+		},
+		&view.View{
+			Description: reclaimedMemoryStat.Description(),
+			Measure:     reclaimedMemoryStat,
+			Aggregation: view.Sum(),
+			TagKeys:     []tag.Key{reconcilerTagKey, workspaceTagKey, policyNameTagKey, policyNamespaceTagKey, teamTagKey},
+		},
+		&view.View{
+			Description: servicesSkippedStat.Description(),
+			Measure:     servicesSkippedStat,
+			Aggregation: view.Count(),
+			TagKeys:     []tag.Key{reconcilerTagKey, workspaceTagKey, policyNameTagKey, policyNamespaceTagKey, reasonTagKey},
+		},
+		&view.View{
+			Description: deletionLatencyStat.Description(),
+			Measure:     deletionLatencyStat,
+			// From a minute to a week, cleanup SLOs range from hours to days.
+			Aggregation: view.Distribution(60, 300, 900, 1800, 3600, 3*3600, 6*3600, 12*3600, 24*3600, 2*24*3600, 7*24*3600),
+			TagKeys:     []tag.Key{reconcilerTagKey, workspaceTagKey, namespaceTagKey},
+		},
+		&view.View{
+			Description: quotaRemainingStat.Description(),
+			Measure:     quotaRemainingStat,
+			Aggregation: view.LastValue(),
+			TagKeys:     []tag.Key{reconcilerTagKey, workspaceTagKey, scopeTagKey, namespaceTagKey, teamTagKey, periodTagKey},
+		},
+		&view.View{
+			Description: childResourcesSweptStat.Description(),
+			Measure:     childResourcesSweptStat,
+			Aggregation: view.Sum(),
+			TagKeys:     []tag.Key{reconcilerTagKey, workspaceTagKey, namespaceTagKey, resourceTagKey},
+		},
+		&view.View{
+			Description: reconcileErrorsStat.Description(),
+			Measure:     reconcileErrorsStat,
+			Aggregation: view.Count(),
+			TagKeys:     []tag.Key{reconcilerTagKey, workspaceTagKey, errorTypeTagKey},
+		},
+		&view.View{
+			Description: revisionsAvoidedStat.Description(),
+			Measure:     revisionsAvoidedStat,
+			Aggregation: view.LastValue(),
+			TagKeys:     []tag.Key{reconcilerTagKey, workspaceTagKey},
+		},
+		&view.View{
+			Description: revisionDaysSavedStat.Description(),
+			Measure:     revisionDaysSavedStat,
+			Aggregation: view.LastValue(),
+			TagKeys:     []tag.Key{reconcilerTagKey, workspaceTagKey},
+		},
+		&view.View{
+			Description: staleBacklogCandidatesStat.Description(),
+			Measure:     staleBacklogCandidatesStat,
+			Aggregation: view.LastValue(),
+			TagKeys:     []tag.Key{reconcilerTagKey, workspaceTagKey},
+		},
+		&view.View{
+			Description: staleBacklogAverageStat.Description(),
+			Measure:     staleBacklogAverageStat,
+			Aggregation: view.LastValue(),
+			TagKeys:     []tag.Key{reconcilerTagKey, workspaceTagKey},
+		},
+		&view.View{
+			Description: gcDivergenceStat.Description(),
+			Measure:     gcDivergenceStat,
+			Aggregation: view.Sum(),
+			TagKeys:     []tag.Key{reconcilerTagKey, workspaceTagKey, divergenceTagKey},
+		},
+		&view.View{
+			Description: resyncIntervalStat.Description(),
+			Measure:     resyncIntervalStat,
+			Aggregation: view.LastValue(),
+			TagKeys:     []tag.Key{reconcilerTagKey, workspaceTagKey},
+		},
+	)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// StatsReporter reports the revision garbage collection metrics.
+type StatsReporter interface {
+	// ReportHeld reports the number of Services and revisions whose deletions
+	// are held back by the maintenance hold.
+	ReportHeld(services, revisions int) error
+
+	// ReportStalled reports the number of revisions whose deletion stalled.
+	ReportStalled(revisions int) error
+
+	// ReportDeleted reports revisions of a Service owned by team deleted
+	// under the policy together with the footprint they reclaimed. team is
+	// empty for Services without an owner team. The deletions are linked to
+	// the sampled span of ctx, if any.
+	ReportDeleted(ctx context.Context, policy strategy.Policy, team string, count int, reclaimed footprint.Footprint) error
+
+	// ReportRetained reports the revisions retained under the policy, by reason.
+	ReportRetained(policy strategy.Policy, retained []strategy.Decision) error
+
+	// ReportSkipped reports a Service skipped under the policy.
+	ReportSkipped(policy strategy.Policy, reason strategy.Reason) error
+
+	// ReportQuotaRemaining reports the deletions left in the quotas.
+	ReportQuotaRemaining(remaining []quota.Remaining) error
+
+	// ReportDeletionLatency reports the time a revision of the namespace
+	// waited for its deletion after becoming eligible.
+	ReportDeletionLatency(namespace string, latency time.Duration) error
+
+	// ReportSwept reports orphaned child resources deleted in the namespace.
+	ReportSwept(namespace, resource string, count int) error
+
+	// ReportError reports a reconcile error of the type.
+	ReportError(errType gcerrors.Type) error
+
+	// ReportSavings reports the revisions that would exist without garbage
+	// collection and the revision-days it saved.
+	ReportSavings(revisions int64, revisionDays float64) error
+
+	// ReportBacklog reports the deletion candidates found by the last
+	// evaluation of every Service and their average per Service.
+	ReportBacklog(candidates int, average float64) error
+
+	// ReportResyncInterval reports the adaptive interval every Service is
+	// evaluated again at.
+	ReportResyncInterval(interval time.Duration) error
+
+	// ReportDivergence reports divergences of the kind found by the audit.
+	ReportDivergence(kind string, count int) error
+}
+
+type reporter struct {
+	ctx context.Context
+}
+
+// NewStatsReporter creates a reporter for the revision garbage collection
+// metrics of the reconciler in the workspace, empty for the cluster of the
+// kubeconfig.
+func NewStatsReporter(reconciler, workspace string) (StatsReporter, error) {
+	ctx, err := tag.New(
+		context.Background(),
+		tag.Insert(reconcilerTagKey, reconciler),
+		tag.Insert(workspaceTagKey, workspace))
+	if err != nil {
+		return nil, err
+	}
+	return &reporter{ctx: ctx}, nil
+}
+
+// ReportHeld reports the number of held Services and revisions.
+func (r *reporter) ReportHeld(services, revisions int) error {
+	metrics.Record(r.ctx, heldServicesStat.M(int64(services)))
+	metrics.Record(r.ctx, heldRevisionsStat.M(int64(revisions)))
+	return nil
+}
+
+// ReportStalled reports the number of revisions whose deletion stalled.
+func (r *reporter) ReportStalled(revisions int) error {
+	metrics.Record(r.ctx, stalledDeletionsStat.M(int64(revisions)))
+	return nil
+}
+
+// ReportDeleted reports revisions deleted under the policy together with
+// the footprint they reclaimed, linked to the sampled span of traceCtx.
+func (r *reporter) ReportDeleted(traceCtx context.Context, policy strategy.Policy, team string, count int, reclaimed footprint.Footprint) error {
+	ctx, err := r.policyContext(policy, tag.Insert(teamTagKey, team))
+	if err != nil {
+		return err
+	}
+	if attachments := tracing.Attachments(traceCtx); attachments != nil {
+		// Sampled reconciles are traced, which needs a backend keeping
+		// exemplars anyway, so the custom metrics filter does not apply.
+		if err := stats.RecordWithOptions(ctx,
+			stats.WithMeasurements(revisionsDeletedStat.M(int64(count))),
+			stats.WithAttachments(attachments)); err != nil {
+			return err
+		}
+	} else {
+		metrics.Record(ctx, revisionsDeletedStat.M(int64(count)))
+	}
+	metrics.Record(ctx, reclaimedCPUStat.M(reclaimed.CPU.MilliValue()))
+	metrics.Record(ctx, reclaimedMemoryStat.M(reclaimed.Memory.Value()))
+	return nil
+}
+
+// ReportRetained reports the revisions retained under the policy, by reason.
+func (r *reporter) ReportRetained(policy strategy.Policy, retained []strategy.Decision) error {
+	byReason := make(map[strategy.Reason]int64)
+	for _, d := range retained {
+		byReason[d.Reason]++
+	}
+	for reason, count := range byReason {
+		ctx, err := r.policyContext(policy, tag.Insert(reasonTagKey, string(reason)))
+		if err != nil {
+			return err
+		}
+		metrics.Record(ctx, revisionsRetainedStat.M(count))
+	}
+	return nil
+}
+
+// ReportSkipped reports a Service skipped under the policy.
+func (r *reporter) ReportSkipped(policy strategy.Policy, reason strategy.Reason) error {
+	ctx, err := r.policyContext(policy, tag.Insert(reasonTagKey, string(reason)))
+	if err != nil {
+		return err
+	}
+	metrics.Record(ctx, servicesSkippedStat.M(1))
+	return nil
+}
+
+// ReportQuotaRemaining reports the deletions left in the quotas.
+func (r *reporter) ReportQuotaRemaining(remaining []quota.Remaining) error {
+	for _, q := range remaining {
+		scope := "global"
+		if q.Namespace != "" {
+			scope = "namespace"
+		} else if q.Team != "" {
+			scope = "team"
+		}
+		ctx, err := tag.New(
+			r.ctx,
+			tag.Insert(scopeTagKey, scope),
+			tag.Insert(namespaceTagKey, q.Namespace),
+			tag.Insert(teamTagKey, q.Team),
+			tag.Insert(periodTagKey, q.Period))
+		if err != nil {
+			return err
+		}
+		metrics.Record(ctx, quotaRemainingStat.M(int64(q.Remaining)))
+	}
+	return nil
+}
+
+// ReportDeletionLatency reports the time a revision of the namespace waited
+// for its deletion after becoming eligible.
+func (r *reporter) ReportDeletionLatency(namespace string, latency time.Duration) error {
+	ctx, err := tag.New(r.ctx, tag.Insert(namespaceTagKey, namespace))
+	if err != nil {
+		return err
+	}
+	metrics.Record(ctx, deletionLatencyStat.M(latency.Seconds()))
+	return nil
+}
+
+// ReportSavings reports the revisions avoided and the revision-days saved.
+func (r *reporter) ReportSavings(revisions int64, revisionDays float64) error {
+	metrics.Record(r.ctx, revisionsAvoidedStat.M(revisions))
+	metrics.Record(r.ctx, revisionDaysSavedStat.M(revisionDays))
+	return nil
+}
+
+// ReportBacklog reports the stale backlog.
+func (r *reporter) ReportBacklog(candidates int, average float64) error {
+	metrics.Record(r.ctx, staleBacklogCandidatesStat.M(int64(candidates)))
+	metrics.Record(r.ctx, staleBacklogAverageStat.M(average))
+	return nil
+}
+
+// ReportResyncInterval reports the adaptive resync interval.
+func (r *reporter) ReportResyncInterval(interval time.Duration) error {
+	metrics.Record(r.ctx, resyncIntervalStat.M(interval.Seconds()))
+	return nil
+}
+
+// ReportDivergence reports divergences found by the audit.
+func (r *reporter) ReportDivergence(kind string, count int) error {
+	ctx, err := tag.New(r.ctx, tag.Insert(divergenceTagKey, kind))
+	if err != nil {
+		return err
+	}
+	metrics.Record(ctx, gcDivergenceStat.M(int64(count)))
+	return nil
+}
+
+// ReportError reports a reconcile error of the type.
+func (r *reporter) ReportError(errType gcerrors.Type) error {
+	ctx, err := tag.New(r.ctx, tag.Insert(errorTypeTagKey, string(errType)))
+	if err != nil {
+		return err
+	}
+	metrics.Record(ctx, reconcileErrorsStat.M(1))
+	return nil
+}
+
+// ReportSwept reports orphaned child resources deleted in the namespace.
+func (r *reporter) ReportSwept(namespace, resource string, count int) error {
+	ctx, err := tag.New(
+		r.ctx,
+		tag.Insert(namespaceTagKey, namespace),
+		tag.Insert(resourceTagKey, resource))
+	if err != nil {
+		return err
+	}
+	metrics.Record(ctx, childResourcesSweptStat.M(int64(count)))
+	return nil
+}
+
+// policyContext returns the reporter context tagged with the policy.
+func (r *reporter) policyContext(policy strategy.Policy, mutators ...tag.Mutator) (context.Context, error) {
+	return tag.New(
+		r.ctx,
+		append([]tag.Mutator{
+			tag.Insert(policyNameTagKey, policy.Name),
+			tag.Insert(policyNamespaceTagKey, policy.Namespace),
+		}, mutators...)...)
+}
+
+func mustNewTagKey(s string) tag.Key {
+	tagKey, err := tag.NewKey(s)
+	if err != nil {
+		panic(err)
+	}
+	return tagKey
+}
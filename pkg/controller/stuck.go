@@ -0,0 +1,83 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/knative-sample/revision-controller/pkg/apicall"
+	"github.com/knative-sample/revision-controller/pkg/config"
+	"github.com/knative-sample/revision-controller/pkg/strategy"
+	"github.com/knative-sample/revision-controller/pkg/tracing"
+	corev1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"knative.dev/pkg/logging"
+	"knative.dev/serving/pkg/apis/serving/v1alpha1"
+	resourcenames "knative.dev/serving/pkg/reconciler/service/resources/names"
+)
+
+// checkStuck reports the Configuration of the Service when its latest
+// generations all failed while an older revision is still Ready, and
+// annotates it for operator attention if configured. The annotation is
+// removed once the Configuration recovers.
+func (c *Reconciler) checkStuck(ctx context.Context, service *v1alpha1.Service) error {
+	logger := logging.FromContext(ctx)
+	gc := config.FromContext(ctx).GC
+
+	cfg, err := c.configurationLister.Configurations(service.Namespace).Get(resourcenames.Configuration(service))
+	if apierrs.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	revisions, err := c.revisionLister.Revisions(service.Namespace).List(gc.LabelKeys.RevisionSelector(service))
+	if err != nil {
+		return err
+	}
+
+	stuck, ok := strategy.DetectStuck(revisions, gc.LabelKeys, gc.StuckFailedGenerations)
+	if ok {
+		logger.Infof("controller reconcile service: %s/%s configuration %s stuck: %s", service.Namespace, service.Name, cfg.Name, stuck)
+		tracing.EventRecorder(ctx, c.Recorder).Eventf(cfg, corev1.EventTypeWarning, "ConfigurationStuck",
+			"Configuration %s is stuck: %s; the failed revisions are retained", cfg.Name, stuck)
+	}
+
+	var desired interface{}
+	if ok && gc.AnnotateStuck {
+		desired = stuck.String()
+	}
+	current, annotated := cfg.Annotations[strategy.StuckAnnotationKey]
+	if (desired == nil && !annotated) || (annotated && desired == current) {
+		return nil
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]interface{}{
+				strategy.StuckAnnotationKey: desired,
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = apicall.PatchConfiguration(ctx, c.revisionClientSet, cfg.Namespace, cfg.Name, types.MergePatchType, patch)
+	return err
+}
@@ -0,0 +1,31 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"github.com/knative-sample/revision-controller/pkg/summary"
+	"go.uber.org/zap"
+)
+
+// outcomef returns the function logging the outcome of a reconcile for a
+// Service, at the debug level while summaries replace these lines.
+func outcomef(s *summary.Summarizer, logger *zap.SugaredLogger) func(template string, args ...interface{}) {
+	if s.Enabled() {
+		return logger.Debugf
+	}
+	return logger.Infof
+}
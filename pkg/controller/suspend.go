@@ -0,0 +1,94 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/knative-sample/revision-controller/pkg/apicall"
+	"github.com/knative-sample/revision-controller/pkg/strategy"
+	"github.com/knative-sample/revision-controller/pkg/tracing"
+	corev1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"knative.dev/pkg/logging"
+	"knative.dev/serving/pkg/apis/serving/v1alpha1"
+)
+
+// suspend returns the planned revisions suspended for at least ttl, to be
+// deleted, and suspends the others: their PodAutoscaler is scaled to zero
+// and they are labeled suspended. The Service is requeued when the next
+// suspended revision expires.
+func (c *Executor) suspend(ctx context.Context, service *v1alpha1.Service, planned []strategy.Decision, ttl time.Duration, now time.Time) ([]strategy.Decision, int) {
+	logger := logging.FromContext(ctx)
+
+	var expired []strategy.Decision
+	var suspended []string
+	var next time.Time
+	for _, d := range planned {
+		re := d.Revision
+		at, ok := strategy.SuspendedAt(re)
+		if ok && !now.Before(at.Add(ttl)) {
+			expired = append(expired, d)
+			continue
+		}
+		if !ok {
+			if err := c.suspendRevision(ctx, re, now); err != nil {
+				logger.Errorf("executor service: %s/%s suspend revision:%s error:%s", service.Namespace, service.Name, re.Name, err.Error())
+				continue
+			}
+			suspended = append(suspended, re.Name)
+			at = now
+		}
+		if expiry := at.Add(ttl); next.IsZero() || expiry.Before(next) {
+			next = expiry
+		}
+	}
+
+	if len(suspended) > 0 {
+		logger.Infof("executor service: %s/%s suspended revisions: %v", service.Namespace, service.Name, suspended)
+		tracing.EventRecorder(ctx, c.Recorder).Eventf(service, corev1.EventTypeNormal, "RevisionsSuspended",
+			"Suspended %d revisions (%s), deleting them after %s", len(suspended), strings.Join(suspended, ", "), ttl)
+	}
+	if !next.IsZero() {
+		c.enqueueAfter(service, next.Sub(now))
+	}
+	return expired, len(planned) - len(expired)
+}
+
+// suspendRevision scales the PodAutoscaler of the revision to zero, then
+// labels the revision suspended. The revision is only labeled once it is
+// scaled, so a failed attempt is retried in full.
+func (c *Executor) suspendRevision(ctx context.Context, revision *v1alpha1.Revision, now time.Time) error {
+	patch, err := strategy.ScaleToZeroMergePatch()
+	if err != nil {
+		return err
+	}
+	// A revision without PodAutoscaler, e.g. one that failed, has nothing to
+	// scale.
+	if _, err := apicall.PatchPodAutoscaler(ctx, c.revisionClientSet, revision.Namespace, revision.Name, types.MergePatchType, patch); err != nil && !apierrs.IsNotFound(err) {
+		return err
+	}
+	patch, err = strategy.SuspendMergePatch(now)
+	if err != nil {
+		return err
+	}
+	_, err = apicall.PatchRevision(ctx, c.revisionClientSet, revision.Namespace, revision.Name, types.MergePatchType, patch)
+	return err
+}
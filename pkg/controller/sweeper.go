@@ -0,0 +1,278 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"time"
+
+	"github.com/knative-sample/revision-controller/pkg/apicall"
+	"github.com/knative-sample/revision-controller/pkg/clock"
+	"github.com/knative-sample/revision-controller/pkg/config"
+	"github.com/knative-sample/revision-controller/pkg/crashreport"
+	"github.com/knative-sample/revision-controller/pkg/pressure"
+	"github.com/knative-sample/revision-controller/pkg/shard"
+	"github.com/knative-sample/revision-controller/pkg/sweeper"
+	"github.com/knative-sample/revision-controller/pkg/tracing"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"knative.dev/pkg/controller"
+	"knative.dev/pkg/logging"
+	listers "knative.dev/serving/pkg/client/listers/serving/v1alpha1"
+	"knative.dev/serving/pkg/reconciler"
+)
+
+const (
+	// SweeperName is the name of the child resource sweeper reconciler
+	SweeperName = "revision-child-sweeper"
+)
+
+// Sweeper implements controller.Reconciler for namespaces. It deletes the
+// Secrets and ConfigMaps generated for revisions that no longer exist,
+// under the same maintenance hold, namespace rollout, relist settle period
+// and deletion windows as the executor.
+type Sweeper struct {
+	*reconciler.Base
+
+	// listers index properties about resources
+	revisionLister  listers.RevisionLister
+	serviceLister   listers.ServiceLister
+	namespaceLister corelisters.NamespaceLister
+
+	configStore   *config.Store
+	statsReporter StatsReporter
+
+	// enqueueAfter requeues a namespace once its orphans are due
+	enqueueAfter func(key string, after time.Duration)
+
+	// clock tells the time the ages of the orphans are computed against
+	clock clock.Clock
+
+	// shards tells the namespaces this replica sweeps in active-active
+	// mode, nil otherwise
+	shards *shard.Coordinator
+
+	// crashes records the keys and panics of the workspace for the crash
+	// report
+	crashes *crashreport.Recorder
+
+	// apiPressure tells whether the API server of the workspace is under
+	// pressure
+	apiPressure *pressure.Monitor
+
+	// relists holds the deletions while the revision cache settles after a
+	// relist, it may miss revisions then
+	relists *relistGuard
+}
+
+// Check that our Sweeper implements controller.Reconciler
+var _ controller.Reconciler = (*Sweeper)(nil)
+
+// childClient lists and deletes one kind of child resources.
+type childClient struct {
+	list   func(ctx context.Context, namespace string) ([]v1.Object, error)
+	delete func(ctx context.Context, namespace, name string, options *v1.DeleteOptions) error
+}
+
+// Reconcile sweeps the orphaned child resources of the namespace the key
+// names.
+func (c *Sweeper) Reconcile(ctx context.Context, namespace string) (err error) {
+	if !c.shards.Owns(namespace) {
+		// Another replica sweeps the namespace.
+		return nil
+	}
+	ctx, _ = tracing.WithDecisionID(ctx)
+	logger := logging.FromContext(ctx)
+	c.crashes.Reconciling(SweeperName, namespace)
+	defer recoverReconcile(ctx, c.crashes, SweeperName, namespace, c.Recorder, c.statsReporter, namespaceObject(c.namespaceLister, namespace), &err)
+	ctx = c.configStore.ToContext(ctx)
+	gc := config.FromContext(ctx).GC
+	ctx = apicall.WithTimeout(ctx, gc.APICallTimeout)
+	if len(gc.SweepChildResources) == 0 {
+		return nil
+	}
+	if namespaceTerminating(c.namespaceLister, namespace) {
+		logger.Debugf("sweeper namespace: %s terminating, skipped", namespace)
+		return nil
+	}
+	if gc.MaintenanceHold {
+		logger.Infof("sweeper namespace: %s maintenance hold active, deferring", namespace)
+		return nil
+	}
+	if rollout := gc.NamespaceRollout; !rollout.Enabled(namespace, c.clock.Now()) {
+		logger.Infof("sweeper namespace: %s not enabled by the rollout at %d%% (bucket %d), deferring",
+			namespace, rollout.PercentAt(c.clock.Now()), rollout.Bucket(namespace))
+		if at, ok := rollout.EnabledAt(namespace); ok {
+			c.enqueueAfter(namespace, at.Sub(c.clock.Now()))
+		}
+		return nil
+	}
+	if wait := c.relists.settling(); wait > 0 {
+		// The revision cache may miss revisions right after a relist.
+		logger.Infof("sweeper namespace: %s informers relisted, deferring by %s", namespace, wait)
+		c.enqueueAfter(namespace, wait+c.relists.delay())
+		return nil
+	}
+	if len(gc.DeletionWindows) > 0 {
+		now := c.clock.Now()
+		if !gc.DeletionWindows.Active(now) {
+			next := gc.DeletionWindows.NextStart(now)
+			logger.Infof("sweeper namespace: %s outside of the deletion windows, next opens at %s", namespace, next)
+			if !next.IsZero() {
+				c.enqueueAfter(namespace, next.Sub(now))
+			}
+			return nil
+		}
+	}
+	if gc.AdaptiveDeletions {
+		if stressed, why := c.apiPressure.Stressed(gc.Pressure, c.clock.Now()); stressed {
+			logger.Infof("sweeper namespace: %s API server under pressure (%s), deferring", namespace, why)
+			c.enqueueAfter(namespace, gc.Pressure.Window)
+			return nil
+		}
+	}
+
+	revisions, err := c.revisionLister.Revisions(namespace).List(labels.Everything())
+	if err != nil {
+		return err
+	}
+	uids := make(map[string]types.UID, len(revisions))
+	for _, re := range revisions {
+		uids[re.Name] = re.UID
+	}
+	rules := sweeper.Rules{
+		RevisionLabel: gc.LabelKeys.Revision,
+		NamePattern:   gc.SweepNamePattern,
+		MinAge:        gc.SweepMinAge,
+	}
+
+	now := c.clock.Now()
+	var requeue time.Duration
+	for _, resource := range gc.SweepChildResources {
+		client := c.childClient(resource)
+		objs, err := client.list(ctx, namespace)
+		if err != nil {
+			return err
+		}
+		swept := 0
+		for _, obj := range objs {
+			if !rules.Orphaned(obj, uids) {
+				continue
+			}
+			if wait := rules.Due(obj, now); wait > 0 {
+				if requeue == 0 || wait < requeue {
+					requeue = wait
+				}
+				continue
+			}
+			// Don't delete an object recreated under the same name meanwhile.
+			uid := obj.GetUID()
+			err := client.delete(ctx, namespace, obj.GetName(), &v1.DeleteOptions{Preconditions: &v1.Preconditions{UID: &uid}})
+			if apierrs.IsNotFound(err) || apierrs.IsConflict(err) {
+				continue
+			} else if err != nil {
+				return err
+			}
+			owner, _ := rules.OwnerOf(obj)
+			logger.Infof("sweeper namespace: %s deleted %s %s of deleted revision %s", namespace, resource, obj.GetName(), owner.Name)
+			swept++
+		}
+		if swept > 0 {
+			if err := c.statsReporter.ReportSwept(namespace, resource, swept); err != nil {
+				logger.Errorf("report swept child resources error: %s", err.Error())
+			}
+		}
+	}
+
+	if requeue > 0 {
+		c.enqueueAfter(namespace, requeue)
+	}
+	return nil
+}
+
+func (c *Sweeper) childClient(resource string) childClient {
+	del := func(ctx context.Context, namespace, name string, options *v1.DeleteOptions) error {
+		return apicall.Delete(ctx, c.KubeClientSet, resource, namespace, name, options)
+	}
+	if resource == config.SweepSecrets {
+		return childClient{
+			list: func(ctx context.Context, namespace string) ([]v1.Object, error) {
+				list, err := apicall.ListSecrets(ctx, c.KubeClientSet, namespace)
+				if err != nil {
+					return nil, err
+				}
+				objs := make([]v1.Object, 0, len(list.Items))
+				for i := range list.Items {
+					objs = append(objs, &list.Items[i])
+				}
+				return objs, nil
+			},
+			delete: del,
+		}
+	}
+	return childClient{
+		list: func(ctx context.Context, namespace string) ([]v1.Object, error) {
+			list, err := apicall.ListConfigMaps(ctx, c.KubeClientSet, namespace)
+			if err != nil {
+				return nil, err
+			}
+			objs := make([]v1.Object, 0, len(list.Items))
+			for i := range list.Items {
+				objs = append(objs, &list.Items[i])
+			}
+			return objs, nil
+		},
+		delete: del,
+	}
+}
+
+// enqueueNamespaces enqueues every namespace holding Services or revisions.
+func (c *Sweeper) enqueueNamespaces(enqueue func(key string)) {
+	seen := make(map[string]bool)
+	if services, err := c.serviceLister.List(labels.Everything()); err == nil {
+		for _, s := range services {
+			seen[s.Namespace] = true
+		}
+	}
+	if revisions, err := c.revisionLister.List(labels.Everything()); err == nil {
+		for _, re := range revisions {
+			seen[re.Namespace] = true
+		}
+	}
+	for namespace := range seen {
+		enqueue(namespace)
+	}
+}
+
+// enqueueNamespaceOf enqueues the namespace of a deleted object.
+func enqueueNamespaceOf(enqueue func(key string)) cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if accessor, err := meta.Accessor(obj); err == nil {
+				enqueue(accessor.GetNamespace())
+			}
+		},
+	}
+}
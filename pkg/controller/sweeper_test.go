@@ -0,0 +1,173 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/knative-sample/revision-controller/pkg/clock"
+	"github.com/knative-sample/revision-controller/pkg/config"
+	"github.com/knative-sample/revision-controller/pkg/crashreport"
+	"github.com/knative-sample/revision-controller/pkg/pressure"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"knative.dev/pkg/logging"
+	listers "knative.dev/serving/pkg/client/listers/serving/v1alpha1"
+	"knative.dev/serving/pkg/reconciler"
+)
+
+// secretServer serves the Secrets of the namespace "default" and records
+// the deleted ones.
+type secretServer struct {
+	mu      sync.Mutex
+	secrets []corev1.Secret
+	deleted []string
+}
+
+func (s *secretServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	const path = "/api/v1/namespaces/default/secrets"
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == path:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(&corev1.SecretList{Items: s.secrets})
+	case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, path+"/"):
+		s.deleted = append(s.deleted, strings.TrimPrefix(r.URL.Path, path+"/"))
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(&metav1.Status{Status: metav1.StatusSuccess})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestSweeperGates(t *testing.T) {
+	now := time.Date(2019, 8, 1, 12, 0, 0, 0, time.UTC)
+	statsReporter, err := NewStatsReporter(SweeperName, "")
+	if err != nil {
+		t.Fatalf("NewStatsReporter() = %v", err)
+	}
+	newIndexer := func() cache.Indexer {
+		return cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	}
+
+	tests := []struct {
+		name        string
+		data        map[string]string
+		hold        bool
+		relisted    bool
+		wantDeleted []string
+		wantRequeue time.Duration
+	}{{
+		name:        "no gate",
+		wantDeleted: []string{"hello-00001-secret"},
+	}, {
+		name: "maintenance hold",
+		hold: true,
+	}, {
+		name: "namespace not reached by the rollout",
+		data: map[string]string{"namespace-rollout-percent": "0"},
+	}, {
+		name:        "caches settling after a relist",
+		data:        map[string]string{"relist-settle-period": "30s", "relist-jitter": "0s"},
+		relisted:    true,
+		wantRequeue: 30 * time.Second,
+	}, {
+		name:        "relist settled",
+		data:        map[string]string{"relist-settle-period": "0s"},
+		relisted:    true,
+		wantDeleted: []string{"hello-00001-secret"},
+	}, {
+		name:        "outside of the deletion windows",
+		data:        map[string]string{"deletion-windows": "- schedule: \"0 22 * * *\"\n  duration: 2h\n"},
+		wantRequeue: 10 * time.Hour,
+	}, {
+		name:        "inside a deletion window",
+		data:        map[string]string{"deletion-windows": "- schedule: \"0 11 * * *\"\n  duration: 2h\n"},
+		wantDeleted: []string{"hello-00001-secret"},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			api := &secretServer{secrets: []corev1.Secret{{ObjectMeta: metav1.ObjectMeta{
+				Namespace:         "default",
+				Name:              "hello-00001-secret",
+				UID:               "secret-uid",
+				Labels:            map[string]string{"serving.knative.dev/revision": "hello-00001"},
+				CreationTimestamp: metav1.NewTime(now.Add(-24 * time.Hour)),
+			}}}}
+			server := httptest.NewServer(api)
+			defer server.Close()
+
+			data := map[string]string{"sweep-child-resources": config.SweepSecrets}
+			for k, v := range test.data {
+				data[k] = v
+			}
+			cm := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: config.GCConfigName}, Data: data}
+			if test.hold {
+				cm.Annotations = map[string]string{config.MaintenanceHoldAnnotationKey: "true"}
+			}
+			logger := zap.NewNop().Sugar()
+			store := config.NewStore(logger)
+			store.OnConfigChanged(cm)
+			store.OnConfigChanged(&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: config.NotificationsConfigName}})
+
+			relists := newRelistGuard(clock.Frozen(now))
+			relists.observe(store.Load().GC)
+			if test.relisted {
+				relists.relisted()
+			}
+			var requeue time.Duration
+			c := &Sweeper{
+				Base:            &reconciler.Base{Logger: logger, KubeClientSet: kubernetes.NewForConfigOrDie(&rest.Config{Host: server.URL})},
+				revisionLister:  listers.NewRevisionLister(newIndexer()),
+				serviceLister:   listers.NewServiceLister(newIndexer()),
+				namespaceLister: corelisters.NewNamespaceLister(newIndexer()),
+				configStore:     store,
+				statsReporter:   statsReporter,
+				enqueueAfter:    func(_ string, after time.Duration) { requeue = after },
+				clock:           clock.Frozen(now),
+				relists:         relists,
+				crashes:         crashreport.New(8),
+				apiPressure:     &pressure.Monitor{},
+			}
+
+			if err := c.Reconcile(logging.WithLogger(context.Background(), logger), "default"); err != nil {
+				t.Fatalf("Reconcile() = %v", err)
+			}
+			if !reflect.DeepEqual(api.deleted, test.wantDeleted) {
+				t.Errorf("deleted = %v, want %v", api.deleted, test.wantDeleted)
+			}
+			if requeue != test.wantRequeue {
+				t.Errorf("requeued after %s, want %s", requeue, test.wantRequeue)
+			}
+		})
+	}
+}
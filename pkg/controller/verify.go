@@ -0,0 +1,89 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/knative-sample/revision-controller/pkg/apicall"
+	"github.com/knative-sample/revision-controller/pkg/config"
+	"github.com/knative-sample/revision-controller/pkg/notifier"
+	"github.com/knative-sample/revision-controller/pkg/strategy"
+	"github.com/knative-sample/revision-controller/pkg/tracing"
+	corev1 "k8s.io/api/core/v1"
+	"knative.dev/pkg/logging"
+	"knative.dev/serving/pkg/apis/serving/v1alpha1"
+)
+
+// verifySteadyState lists the revisions of the Service from the API server
+// after the deletions and alerts when they differ from what the evaluation
+// expects: a revision it retains is missing, or a deleted one is still there.
+// Revisions created in the meantime, and candidates not deleted, are not
+// expected either way.
+func (c *Executor) verifySteadyState(ctx context.Context, service *v1alpha1.Service, result *strategy.Result, deleted []string) {
+	logger := logging.FromContext(ctx)
+	selector := config.FromContext(ctx).GC.LabelKeys.RevisionSelector(service)
+
+	list, err := apicall.ListRevisions(ctx, c.revisionClientSet, service.Namespace, selector)
+	if err != nil {
+		logger.Errorf("executor service: %s/%s verify steady state error:%s", service.Namespace, service.Name, err.Error())
+		return
+	}
+	live := make(map[string]bool, len(list.Items))
+	for i := range list.Items {
+		live[list.Items[i].Name] = list.Items[i].DeletionTimestamp == nil
+	}
+
+	var lingering []string
+	for _, name := range deleted {
+		if live[name] {
+			lingering = append(lingering, name)
+		}
+	}
+	var missing []string
+	for _, d := range result.Retained {
+		if !live[d.Revision.Name] {
+			missing = append(missing, d.Revision.Name)
+		}
+	}
+	if len(missing) == 0 && len(lingering) == 0 {
+		return
+	}
+	sort.Strings(missing)
+	sort.Strings(lingering)
+
+	var problems []string
+	if len(missing) > 0 {
+		problems = append(problems, fmt.Sprintf("%d revisions retained are missing (%s)", len(missing), strings.Join(missing, ", ")))
+	}
+	if len(lingering) > 0 {
+		problems = append(problems, fmt.Sprintf("%d deleted revisions are still there (%s)", len(lingering), strings.Join(lingering, ", ")))
+	}
+	message := strings.Join(problems, "; ")
+	logger.Errorf("executor service: %s/%s steady state mismatch: %s", service.Namespace, service.Name, message)
+	tracing.EventRecorder(ctx, c.Recorder).Eventf(service, corev1.EventTypeWarning, "SteadyStateMismatch", "Revisions differ from the expected state after deletion: %s", message)
+	notify(ctx, &notifier.Notification{
+		Kind:      notifier.KindSteadyStateMismatch,
+		Namespace: service.Namespace,
+		Service:   service.Name,
+		Revisions: append(missing, lingering...),
+		Message:   "revisions differ from the expected state after deletion: " + message,
+	})
+}
@@ -0,0 +1,58 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	"github.com/knative-sample/revision-controller/pkg/apicall"
+	"github.com/knative-sample/revision-controller/pkg/footprint"
+	"github.com/knative-sample/revision-controller/pkg/strategy"
+	"github.com/knative-sample/revision-controller/pkg/tracing"
+	corev1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"knative.dev/pkg/logging"
+	"knative.dev/serving/pkg/apis/serving/v1alpha1"
+)
+
+// cleanupVolumeClaims deletes the PersistentVolumeClaims created for a
+// deleted revision mounting claims, i.e. those labeled with its name. Claims
+// shared with other revisions carry no such label and are left alone.
+func (c *Executor) cleanupVolumeClaims(ctx context.Context, service *v1alpha1.Service, revision *v1alpha1.Revision) error {
+	if len(strategy.VolumeClaims(revision)) == 0 {
+		return nil
+	}
+	logger := logging.FromContext(ctx)
+	claims, err := apicall.ListPersistentVolumeClaims(ctx, c.kubeClient, revision.Namespace, footprint.RevisionSelector(revision.Name))
+	if err != nil {
+		return err
+	}
+	var deleted []string
+	for _, claim := range claims.Items {
+		if err := apicall.Delete(ctx, c.kubeClient, "persistentvolumeclaims", claim.Namespace, claim.Name, &metav1.DeleteOptions{}); err != nil && !apierrs.IsNotFound(err) {
+			return err
+		}
+		deleted = append(deleted, claim.Name)
+	}
+	if len(deleted) > 0 {
+		logger.Infof("executor service: %s/%s deleted claims of revision:%s claims:%v", service.Namespace, service.Name, revision.Name, deleted)
+		tracing.EventRecorder(ctx, c.Recorder).Eventf(service, corev1.EventTypeNormal, "VolumeClaimsDeleted",
+			"Deleted %d PersistentVolumeClaims of revision %s", len(deleted), revision.Name)
+	}
+	return nil
+}
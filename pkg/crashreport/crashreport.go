@@ -0,0 +1,180 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package crashreport keeps what the controller worked on lately, the keys it
+// reconciled, the panics it recovered from and the configuration in effect,
+// and dumps it on fatal exit, e.g. to the termination log of the pod, so a
+// crash loop can be diagnosed from the pod status.
+package crashreport
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// Key is a key a controller started to reconcile.
+type Key struct {
+	At         time.Time `json:"at"`
+	Controller string    `json:"controller"`
+	Key        string    `json:"key"`
+}
+
+// Panic is a panic recovered while reconciling a key.
+type Panic struct {
+	Key
+	Value string `json:"value"`
+	Stack string `json:"stack"`
+}
+
+// Report is the dump written on fatal exit.
+type Report struct {
+	Reason string    `json:"reason"`
+	At     time.Time `json:"at"`
+	// Keys are the recent keys, the oldest first.
+	Keys   []Key   `json:"keys"`
+	Panics []Panic `json:"panics,omitempty"`
+	// Config holds the data of the configuration ConfigMaps by name.
+	Config map[string]map[string]string `json:"config,omitempty"`
+	// Workspaces holds the reports of the workspaces by name.
+	Workspaces map[string]*Report `json:"workspaces,omitempty"`
+}
+
+// Recorder keeps the recent keys, panics and configuration.
+type Recorder struct {
+	mu     sync.Mutex
+	size   int
+	keys   []Key
+	panics []Panic
+	config map[string]map[string]string
+
+	workspaces map[string]*Recorder
+}
+
+// Default is the process wide Recorder.
+var Default = New(32)
+
+// New returns a Recorder keeping the last size keys and panics.
+func New(size int) *Recorder {
+	return &Recorder{size: size, config: make(map[string]map[string]string), workspaces: make(map[string]*Recorder)}
+}
+
+// Workspace returns the Recorder of the named workspace, keeping as many
+// keys and panics. Its report is part of the report of r.
+func (r *Recorder) Workspace(name string) *Recorder {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	w, ok := r.workspaces[name]
+	if !ok {
+		w = New(r.size)
+		r.workspaces[name] = w
+	}
+	return w
+}
+
+type recorderKey struct{}
+
+// WithRecorder attaches the Recorder the controllers record to to ctx.
+func WithRecorder(ctx context.Context, r *Recorder) context.Context {
+	return context.WithValue(ctx, recorderKey{}, r)
+}
+
+// FromContext returns the Recorder attached to ctx, Default when none is.
+func FromContext(ctx context.Context) *Recorder {
+	if r, ok := ctx.Value(recorderKey{}).(*Recorder); ok {
+		return r
+	}
+	return Default
+}
+
+// Reconciling records that the controller started to reconcile key.
+func (r *Recorder) Reconciling(controller, key string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.keys = append(r.keys, Key{At: time.Now(), Controller: controller, Key: key})
+	if len(r.keys) > r.size {
+		r.keys = r.keys[len(r.keys)-r.size:]
+	}
+}
+
+// Panicked records a panic recovered while the controller reconciled key.
+func (r *Recorder) Panicked(controller, key string, value interface{}, stack []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.panics = append(r.panics, Panic{
+		Key:   Key{At: time.Now(), Controller: controller, Key: key},
+		Value: fmt.Sprint(value),
+		Stack: string(stack),
+	})
+	if len(r.panics) > r.size {
+		r.panics = r.panics[len(r.panics)-r.size:]
+	}
+}
+
+// Config records the data of the named configuration ConfigMap.
+func (r *Recorder) Config(name string, data map[string]string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.config[name] = data
+}
+
+// Report returns the report of what was recorded.
+func (r *Recorder) Report(reason string) *Report {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := &Report{
+		Reason: reason,
+		At:     time.Now(),
+		Keys:   append([]Key(nil), r.keys...),
+		Panics: append([]Panic(nil), r.panics...),
+		Config: make(map[string]map[string]string, len(r.config)),
+	}
+	for name, data := range r.config {
+		out.Config[name] = data
+	}
+	if len(r.workspaces) > 0 {
+		out.Workspaces = make(map[string]*Report, len(r.workspaces))
+		for name, w := range r.workspaces {
+			out.Workspaces[name] = w.Report(reason)
+		}
+	}
+	return out
+}
+
+// WriteFile writes the report as JSON to path.
+func (r *Recorder) WriteFile(path, reason string) error {
+	raw, err := json.MarshalIndent(r.Report(reason), "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, raw, 0644)
+}
+
+// FatalHook returns the zap hook writing the report to path when a fatal
+// entry is logged, before the process exits.
+func (r *Recorder) FatalHook(path string) func(zapcore.Entry) error {
+	return func(e zapcore.Entry) error {
+		if e.Level < zapcore.FatalLevel {
+			return nil
+		}
+		return r.WriteFile(path, e.Message)
+	}
+}
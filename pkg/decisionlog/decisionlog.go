@@ -0,0 +1,305 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package decisionlog archives the decisions of the controller for
+// compliance without extra infrastructure. Every recorded plan and every
+// deletion is a CloudEvent in the structured JSON format; the events are
+// batched and written on a schedule as newline-delimited JSON objects to
+// S3, GCS or Azure Blob storage:
+//
+//	<prefix>/2019/10/16/20191016T120000Z-<id>.ndjson
+//
+// Objects older than the retention are deleted by the controller, so the
+// bucket needs no lifecycle rules of its own. Only the objects of this
+// layout under the prefix are deleted, and a retention requires a prefix,
+// so the objects shared with other writers of the bucket are left alone.
+package decisionlog
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// TypePlanned is the type of the events recording a deletion plan.
+	TypePlanned = "dev.knative.revision-gc.planned"
+
+	// TypeDeleted is the type of the events recording deleted revisions.
+	TypeDeleted = "dev.knative.revision-gc.deleted"
+
+	// specVersion is the CloudEvents version of the events.
+	specVersion = "1.0"
+
+	// maxBuffered bounds the events held while the store is unavailable,
+	// the oldest are dropped beyond it.
+	maxBuffered = 50000
+
+	// pruneInterval is how often objects past the retention are deleted.
+	pruneInterval = time.Hour
+)
+
+// Options configures the decision log.
+type Options struct {
+	// URL is the object storage location the events are written to:
+	// s3://bucket/prefix, gs://bucket/prefix or
+	// azblob://account/container/prefix. Disabled when empty.
+	URL string
+	// Interval is how often the buffered events are written.
+	Interval time.Duration
+	// Retention is how long the written objects are kept, forever when
+	// zero.
+	Retention time.Duration
+	// Source is the CloudEvents source of the events.
+	Source string
+}
+
+// Enabled reports whether the decision log is configured.
+func (o Options) Enabled() bool {
+	return o.URL != ""
+}
+
+// Event is a CloudEvent in the structured JSON format.
+type Event struct {
+	SpecVersion     string    `json:"specversion"`
+	ID              string    `json:"id"`
+	Source          string    `json:"source"`
+	Type            string    `json:"type"`
+	Subject         string    `json:"subject"`
+	Time            time.Time `json:"time"`
+	DataContentType string    `json:"datacontenttype"`
+	Data            Decision  `json:"data"`
+}
+
+// Decision is the data of an event.
+type Decision struct {
+	// DecisionID identifies the reconcile that took the decision.
+	DecisionID string `json:"decisionID,omitempty"`
+	Namespace  string `json:"namespace"`
+	Service    string `json:"service"`
+	// Policy is the name of the policy the decision was taken under.
+	Policy    string     `json:"policy"`
+	Revisions []Revision `json:"revisions"`
+}
+
+// Revision is a revision a decision is about.
+type Revision struct {
+	Name string `json:"name"`
+	// Reason is why the revision was a deletion candidate.
+	Reason string `json:"reason,omitempty"`
+}
+
+// Log buffers events and writes them to its store on a schedule.
+type Log struct {
+	logger    *zap.SugaredLogger
+	store     Store
+	prefix    string
+	source    string
+	retention time.Duration
+
+	mu        sync.Mutex
+	buffered  []Event
+	dropped   int
+	lastPrune time.Time
+}
+
+// New returns the Log configured by options, writing until stopCh is
+// closed, then once more. It returns nil when the log is disabled.
+func New(logger *zap.SugaredLogger, options Options, stopCh <-chan struct{}) (*Log, error) {
+	if !options.Enabled() {
+		return nil, nil
+	}
+	if options.Interval <= 0 {
+		return nil, fmt.Errorf("decision log interval must be positive, got %s", options.Interval)
+	}
+	if options.Retention < 0 {
+		return nil, fmt.Errorf("decision log retention must be zero or greater, got %s", options.Retention)
+	}
+	store, prefix, err := NewStore(options.URL)
+	if err != nil {
+		return nil, err
+	}
+	if options.Retention > 0 && prefix == "" {
+		return nil, fmt.Errorf("decision log retention requires a prefix in the URL %q, e.g. %s/revision-gc, or it would delete the other objects of the bucket",
+			options.URL, store.Name())
+	}
+	l := newLog(logger, store, prefix, options)
+	go l.run(options.Interval, stopCh)
+	return l, nil
+}
+
+// newLog returns the Log writing to the prefix of the store.
+func newLog(logger *zap.SugaredLogger, store Store, prefix string, options Options) *Log {
+	return &Log{
+		logger:    logger,
+		store:     store,
+		prefix:    prefix,
+		source:    options.Source,
+		retention: options.Retention,
+	}
+}
+
+// Record buffers an event of type typ about the decision. It does nothing
+// on a nil Log.
+func (l *Log) Record(typ string, d Decision, now time.Time) {
+	if l == nil {
+		return
+	}
+	e := Event{
+		SpecVersion:     specVersion,
+		ID:              newID(),
+		Source:          l.source,
+		Type:            typ,
+		Subject:         d.Namespace + "/" + d.Service,
+		Time:            now.UTC(),
+		DataContentType: "application/json",
+		Data:            d,
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.buffered = append(l.buffered, e)
+	if over := len(l.buffered) - maxBuffered; over > 0 {
+		l.buffered = l.buffered[over:]
+		l.dropped += over
+	}
+}
+
+// Flush writes the buffered events now, e.g. before exiting. It does
+// nothing on a nil Log.
+func (l *Log) Flush() {
+	if l == nil {
+		return
+	}
+	l.flush(time.Now())
+}
+
+func (l *Log) run(interval time.Duration, stopCh <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			l.flush(time.Now())
+		case <-stopCh:
+			l.flush(time.Now())
+			return
+		}
+	}
+}
+
+// flush writes the buffered events as one object, keeping them for the
+// next attempt when the write fails, then deletes the objects past the
+// retention.
+func (l *Log) flush(now time.Time) {
+	l.mu.Lock()
+	events := l.buffered
+	dropped := l.dropped
+	l.buffered, l.dropped = nil, 0
+	l.mu.Unlock()
+
+	if dropped > 0 {
+		l.logger.Errorf("decision log: dropped %d events the store could not take in time", dropped)
+	}
+	if len(events) > 0 {
+		if err := l.write(events, now); err != nil {
+			l.logger.Errorf("decision log: write %d events to %s error:%s", len(events), l.store.Name(), err.Error())
+			l.mu.Lock()
+			l.buffered = append(events, l.buffered...)
+			if over := len(l.buffered) - maxBuffered; over > 0 {
+				l.buffered = l.buffered[over:]
+				l.dropped += over
+			}
+			l.mu.Unlock()
+		}
+	}
+
+	if l.retention > 0 && now.Sub(l.lastPrune) >= pruneInterval {
+		l.lastPrune = now
+		if err := l.prune(now.Add(-l.retention)); err != nil {
+			l.logger.Errorf("decision log: delete expired objects from %s error:%s", l.store.Name(), err.Error())
+		}
+	}
+}
+
+func (l *Log) write(events []Event, now time.Time) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, e := range events {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	now = now.UTC()
+	key := fmt.Sprintf("%s%s/%s-%s.ndjson", l.prefix, now.Format("2006/01/02"), now.Format("20060102T150405Z"), events[0].ID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	return l.store.Put(ctx, key, buf.Bytes())
+}
+
+// objectKey matches the key of the objects written by a log after its
+// prefix.
+var objectKey = regexp.MustCompile(`^[0-9]{4}/[0-9]{2}/[0-9]{2}/[0-9]{8}T[0-9]{6}Z-[0-9a-f]+\.ndjson$`)
+
+// prune deletes the objects of the log last modified before cutoff. Other
+// objects under the prefix are kept.
+func (l *Log) prune(cutoff time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	objects, err := l.store.List(ctx, l.prefix)
+	if err != nil {
+		return err
+	}
+	for _, o := range objects {
+		if !o.Modified.Before(cutoff) || !strings.HasPrefix(o.Key, l.prefix) || !objectKey.MatchString(o.Key[len(l.prefix):]) {
+			continue
+		}
+		if err := l.store.Delete(ctx, o.Key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func newID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b[:])
+}
+
+type logKey struct{}
+
+// WithLog returns a context carrying the Log.
+func WithLog(ctx context.Context, l *Log) context.Context {
+	return context.WithValue(ctx, logKey{}, l)
+}
+
+// FromContext returns the Log of the context, nil when there is none.
+func FromContext(ctx context.Context) *Log {
+	l, _ := ctx.Value(logKey{}).(*Log)
+	return l
+}
@@ -0,0 +1,308 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package decisionlog
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// fakeStore is an in-memory Store.
+type fakeStore struct {
+	mu      sync.Mutex
+	objects map[string]Object
+	bodies  map[string][]byte
+	putErr  error
+	listErr error
+	deleted []string
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{objects: map[string]Object{}, bodies: map[string][]byte{}}
+}
+
+func (s *fakeStore) Name() string {
+	return "fake://bucket"
+}
+
+func (s *fakeStore) Put(ctx context.Context, key string, body []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.putErr != nil {
+		return s.putErr
+	}
+	s.add(key, time.Now())
+	s.bodies[key] = body
+	return nil
+}
+
+func (s *fakeStore) add(key string, modified time.Time) {
+	s.objects[key] = Object{Key: key, Modified: modified}
+}
+
+func (s *fakeStore) List(ctx context.Context, prefix string) ([]Object, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.listErr != nil {
+		return nil, s.listErr
+	}
+	var out []Object
+	for key, o := range s.objects {
+		if strings.HasPrefix(key, prefix) {
+			out = append(out, o)
+		}
+	}
+	return out, nil
+}
+
+func (s *fakeStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.objects, key)
+	delete(s.bodies, key)
+	s.deleted = append(s.deleted, key)
+	return nil
+}
+
+func (s *fakeStore) keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]string, 0, len(s.objects))
+	for key := range s.objects {
+		out = append(out, key)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func testLog(store Store, prefix string, retention time.Duration) *Log {
+	return newLog(zap.NewNop().Sugar(), store, prefix, Options{Source: "revision-gc", Retention: retention})
+}
+
+func decodeEvents(t *testing.T, body []byte) []Event {
+	t.Helper()
+	var out []Event
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		e := Event{}
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatalf("Unmarshal(%s) = %v", scanner.Text(), err)
+		}
+		out = append(out, e)
+	}
+	return out
+}
+
+func TestFlush(t *testing.T) {
+	now := time.Date(2019, 10, 16, 12, 0, 0, 0, time.UTC)
+	store := newFakeStore()
+	l := testLog(store, "revision-gc/", 0)
+
+	planned := Decision{Namespace: "default", Service: "hello", Policy: "default", Revisions: []Revision{{Name: "hello-00001", Reason: "Stale"}}}
+	deleted := Decision{Namespace: "default", Service: "hello", Policy: "default", Revisions: []Revision{{Name: "hello-00001"}}}
+	l.Record(TypePlanned, planned, now)
+	l.Record(TypeDeleted, deleted, now.Add(time.Minute))
+	l.flush(now.Add(2 * time.Minute))
+
+	keys := store.keys()
+	if len(keys) != 1 {
+		t.Fatalf("objects = %v, want one", keys)
+	}
+	if !strings.HasPrefix(keys[0], "revision-gc/2019/10/16/20191016T120200Z-") || !objectKey.MatchString(strings.TrimPrefix(keys[0], "revision-gc/")) {
+		t.Errorf("object key = %s, want the layout of the log", keys[0])
+	}
+	events := decodeEvents(t, store.bodies[keys[0]])
+	if len(events) != 2 {
+		t.Fatalf("events = %+v, want 2", events)
+	}
+	for i, want := range []struct {
+		typ  string
+		data Decision
+		time time.Time
+	}{{TypePlanned, planned, now}, {TypeDeleted, deleted, now.Add(time.Minute)}} {
+		e := events[i]
+		if e.SpecVersion != specVersion || e.Source != "revision-gc" || e.Subject != "default/hello" || e.ID == "" || e.DataContentType != "application/json" {
+			t.Errorf("event %d = %+v, want a CloudEvent about default/hello", i, e)
+		}
+		if e.Type != want.typ || !e.Time.Equal(want.time) || !reflect.DeepEqual(e.Data, want.data) {
+			t.Errorf("event %d = %s at %v with %+v, want %s at %v with %+v", i, e.Type, e.Time, e.Data, want.typ, want.time, want.data)
+		}
+	}
+	if events[0].ID == events[1].ID {
+		t.Errorf("events share the ID %s", events[0].ID)
+	}
+	if !strings.HasSuffix(keys[0], "-"+events[0].ID+".ndjson") {
+		t.Errorf("object key = %s, want the ID of the first event %s", keys[0], events[0].ID)
+	}
+
+	// Nothing buffered, nothing written.
+	l.flush(now.Add(3 * time.Minute))
+	if got := store.keys(); len(got) != 1 {
+		t.Errorf("objects = %v after an empty flush, want one", got)
+	}
+}
+
+func TestFlushKeepsEventsOnWriteError(t *testing.T) {
+	now := time.Date(2019, 10, 16, 12, 0, 0, 0, time.UTC)
+	store := newFakeStore()
+	store.putErr = errors.New("unavailable")
+	l := testLog(store, "", 0)
+
+	l.Record(TypePlanned, Decision{Namespace: "default", Service: "hello"}, now)
+	l.flush(now)
+	l.Record(TypeDeleted, Decision{Namespace: "default", Service: "hello"}, now.Add(time.Minute))
+	if len(store.keys()) != 0 {
+		t.Fatalf("objects = %v, want none while the store fails", store.keys())
+	}
+
+	store.putErr = nil
+	l.flush(now.Add(2 * time.Minute))
+	keys := store.keys()
+	if len(keys) != 1 {
+		t.Fatalf("objects = %v, want one", keys)
+	}
+	events := decodeEvents(t, store.bodies[keys[0]])
+	if len(events) != 2 || events[0].Type != TypePlanned || events[1].Type != TypeDeleted {
+		t.Errorf("events = %+v, want the kept planned event then the deleted one", events)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	now := time.Date(2019, 10, 16, 12, 0, 0, 0, time.UTC)
+	old, recent := now.Add(-48*time.Hour), now.Add(-time.Hour)
+
+	store := newFakeStore()
+	for key, modified := range map[string]time.Time{
+		"revision-gc/2019/10/14/20191014T120000Z-0123456789abcdef0123456789abcdef.ndjson": old,
+		"revision-gc/2019/10/16/20191016T110000Z-fedcba9876543210fedcba9876543210.ndjson": recent,
+		// Not written by the log.
+		"revision-gc/README.md": old,
+		"revision-gc/2019/10/14/20191014T120000Z-0123456789abcdef.ndjson.bak":     old,
+		"revision-gc/2019/10/14/export.ndjson":                                    old,
+		"revision-gc/other/2019/10/14/20191014T120000Z-0123456789abcdef.ndjson":   old,
+		"revision-gc-staging/2019/10/14/20191014T120000Z-0123456789abcdef.ndjson": old,
+		"2019/10/14/20191014T120000Z-0123456789abcdef.ndjson":                     old,
+		"backups/db.tar.gz": old,
+	} {
+		store.add(key, modified)
+	}
+	l := testLog(store, "revision-gc/", 24*time.Hour)
+
+	l.flush(now)
+	want := []string{"revision-gc/2019/10/14/20191014T120000Z-0123456789abcdef0123456789abcdef.ndjson"}
+	if !reflect.DeepEqual(store.deleted, want) {
+		t.Errorf("deleted = %v, want %v", store.deleted, want)
+	}
+
+	// Pruned at most once per interval.
+	store.add(want[0], old)
+	l.flush(now.Add(pruneInterval - time.Second))
+	if len(store.deleted) != 1 {
+		t.Errorf("deleted = %v, want no prune before the interval", store.deleted)
+	}
+	l.flush(now.Add(pruneInterval))
+	if len(store.deleted) != 2 {
+		t.Errorf("deleted = %v, want a prune after the interval", store.deleted)
+	}
+}
+
+func TestPruneDisabled(t *testing.T) {
+	now := time.Date(2019, 10, 16, 12, 0, 0, 0, time.UTC)
+	store := newFakeStore()
+	store.add("revision-gc/2019/10/14/20191014T120000Z-0123456789abcdef.ndjson", now.Add(-365*24*time.Hour))
+	testLog(store, "revision-gc/", 0).flush(now)
+	if len(store.deleted) != 0 {
+		t.Errorf("deleted = %v without a retention, want none", store.deleted)
+	}
+}
+
+func TestNew(t *testing.T) {
+	os.Setenv(AzureSASTokenEnv, "sv=2019-02-02&sig=test")
+	defer os.Unsetenv(AzureSASTokenEnv)
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+
+	tests := []struct {
+		name    string
+		options Options
+		wantErr string
+	}{{
+		name:    "disabled",
+		options: Options{},
+	}, {
+		name:    "retention with a prefix",
+		options: Options{URL: "azblob://account/container/revision-gc", Interval: time.Minute, Retention: time.Hour},
+	}, {
+		name:    "no retention without a prefix",
+		options: Options{URL: "azblob://account/container", Interval: time.Minute},
+	}, {
+		name:    "retention without a prefix",
+		options: Options{URL: "azblob://account/container", Interval: time.Minute, Retention: time.Hour},
+		wantErr: "decision log retention requires a prefix",
+	}, {
+		name:    "retention with an S3 bucket only",
+		options: Options{URL: "s3://bucket/?region=eu-west-1", Interval: time.Minute, Retention: time.Hour},
+		wantErr: "e.g. s3://bucket/revision-gc",
+	}, {
+		name:    "no interval",
+		options: Options{URL: "azblob://account/container/revision-gc"},
+		wantErr: "interval must be positive",
+	}, {
+		name:    "negative retention",
+		options: Options{URL: "azblob://account/container/revision-gc", Interval: time.Minute, Retention: -time.Hour},
+		wantErr: "retention must be zero or greater",
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			l, err := New(zap.NewNop().Sugar(), test.options, stopCh)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Errorf("New() = %v, want error containing %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("New() = %v", err)
+			}
+			if (l != nil) != test.options.Enabled() {
+				t.Errorf("New() = %v, want a Log only when enabled", l)
+			}
+		})
+	}
+}
+
+func TestNilLog(t *testing.T) {
+	var l *Log
+	l.Record(TypePlanned, Decision{}, time.Now())
+	l.Flush()
+	if got := FromContext(WithLog(context.Background(), l)); got != nil {
+		t.Errorf("FromContext() = %v, want nil", got)
+	}
+}
@@ -0,0 +1,395 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package decisionlog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	v4 "github.com/aws/aws-sdk-go/aws/signer/v4"
+	"golang.org/x/oauth2/google"
+)
+
+// AzureSASTokenEnv is the environment variable holding the shared access
+// signature the Azure Blob container is written with.
+const AzureSASTokenEnv = "AZURE_STORAGE_SAS_TOKEN"
+
+// Object is an object of a Store.
+type Object struct {
+	Key      string
+	Modified time.Time
+}
+
+// Store is the object storage the events are written to.
+type Store interface {
+	// Name identifies the store in logs.
+	Name() string
+
+	// Put writes the object.
+	Put(ctx context.Context, key string, body []byte) error
+
+	// List lists the objects whose key starts with prefix.
+	List(ctx context.Context, prefix string) ([]Object, error)
+
+	// Delete deletes the object, a missing object is not an error.
+	Delete(ctx context.Context, key string) error
+}
+
+var defaultClient = &http.Client{Timeout: time.Minute}
+
+// NewStore returns the Store of the URL and the key prefix of the objects.
+// S3 credentials and region come from the default AWS chain, unless the
+// region query parameter sets it; the endpoint query parameter selects an
+// S3 compatible endpoint, addressed path-style. GCS credentials are the
+// Google application default credentials. Azure Blob is written with the
+// SAS token of AzureSASTokenEnv.
+func NewStore(raw string) (Store, string, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid decision log URL: %v", err)
+	}
+	path := strings.Trim(u.Path, "/")
+	switch u.Scheme {
+	case "s3":
+		if u.Host == "" {
+			return nil, "", fmt.Errorf("invalid decision log URL %q: missing bucket", raw)
+		}
+		sess, err := session.NewSession()
+		if err != nil {
+			return nil, "", fmt.Errorf("load AWS credentials: %v", err)
+		}
+		s := &S3Store{Bucket: u.Host, Endpoint: u.Query().Get("endpoint"), Region: u.Query().Get("region"), Signer: v4.NewSigner(sess.Config.Credentials), Client: defaultClient}
+		if s.Region == "" && sess.Config.Region != nil {
+			s.Region = *sess.Config.Region
+		}
+		if s.Region == "" {
+			return nil, "", fmt.Errorf("invalid decision log URL %q: no AWS region configured", raw)
+		}
+		return s, prefix(path), nil
+	case "gs":
+		if u.Host == "" {
+			return nil, "", fmt.Errorf("invalid decision log URL %q: missing bucket", raw)
+		}
+		client, err := google.DefaultClient(context.Background(), "https://www.googleapis.com/auth/devstorage.read_write")
+		if err != nil {
+			return nil, "", fmt.Errorf("load Google credentials: %v", err)
+		}
+		client.Timeout = defaultClient.Timeout
+		return &GCSStore{Bucket: u.Host, Client: client}, prefix(path), nil
+	case "azblob":
+		parts := strings.SplitN(path, "/", 2)
+		if u.Host == "" || parts[0] == "" {
+			return nil, "", fmt.Errorf("invalid decision log URL %q: expected azblob://account/container/prefix", raw)
+		}
+		sas := strings.TrimPrefix(os.Getenv(AzureSASTokenEnv), "?")
+		if sas == "" {
+			return nil, "", fmt.Errorf("%s must hold the SAS token of the Azure Blob container", AzureSASTokenEnv)
+		}
+		s := &AzureStore{Account: u.Host, Container: parts[0], SASToken: sas, Client: defaultClient}
+		if len(parts) == 1 {
+			return s, "", nil
+		}
+		return s, prefix(parts[1]), nil
+	default:
+		return nil, "", fmt.Errorf("invalid decision log URL %q: scheme must be s3, gs or azblob", raw)
+	}
+}
+
+func prefix(path string) string {
+	if path == "" {
+		return ""
+	}
+	return path + "/"
+}
+
+// do sends the request and checks for a 2xx response, or a 404 when
+// allowNotFound is set. It returns the response body.
+func do(client *http.Client, req *http.Request, allowNotFound bool) ([]byte, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 16<<20))
+	if err != nil {
+		return nil, err
+	}
+	if allowNotFound && resp.StatusCode == http.StatusNotFound {
+		return body, nil
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("unexpected status %s from %s %s", resp.Status, req.Method, req.URL.Host)
+	}
+	return body, nil
+}
+
+// S3Store writes to an S3 bucket with SigV4 signed requests.
+type S3Store struct {
+	Bucket   string
+	Region   string
+	Endpoint string
+	Signer   *v4.Signer
+	Client   *http.Client
+}
+
+// Name implements Store.
+func (s *S3Store) Name() string {
+	return "s3://" + s.Bucket
+}
+
+func (s *S3Store) url(key string, query url.Values) string {
+	base := fmt.Sprintf("https://%s.s3.%s.amazonaws.com/", s.Bucket, s.Region)
+	if s.Endpoint != "" {
+		base = strings.TrimSuffix(s.Endpoint, "/") + "/" + s.Bucket + "/"
+	}
+	u := base + (&url.URL{Path: key}).EscapedPath()
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	return u
+}
+
+func (s *S3Store) send(ctx context.Context, method, u string, body []byte, allowNotFound bool) ([]byte, error) {
+	req, err := http.NewRequest(method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.Signer.Sign(req, bytes.NewReader(body), "s3", s.Region, time.Now()); err != nil {
+		return nil, err
+	}
+	return do(s.Client, req.WithContext(ctx), allowNotFound)
+}
+
+// Put implements Store.
+func (s *S3Store) Put(ctx context.Context, key string, body []byte) error {
+	_, err := s.send(ctx, http.MethodPut, s.url(key, nil), body, false)
+	return err
+}
+
+// List implements Store.
+func (s *S3Store) List(ctx context.Context, prefix string) ([]Object, error) {
+	var out []Object
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		body, err := s.send(ctx, http.MethodGet, s.url("", query), nil, false)
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			Contents []struct {
+				Key          string
+				LastModified time.Time
+			}
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+		if err := xml.Unmarshal(body, &page); err != nil {
+			return nil, err
+		}
+		for _, c := range page.Contents {
+			out = append(out, Object{Key: c.Key, Modified: c.LastModified})
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return out, nil
+		}
+		token = page.NextContinuationToken
+	}
+}
+
+// Delete implements Store.
+func (s *S3Store) Delete(ctx context.Context, key string) error {
+	_, err := s.send(ctx, http.MethodDelete, s.url(key, nil), nil, true)
+	return err
+}
+
+// GCSStore writes to a GCS bucket with the JSON API.
+type GCSStore struct {
+	Bucket string
+	Client *http.Client
+}
+
+// Name implements Store.
+func (s *GCSStore) Name() string {
+	return "gs://" + s.Bucket
+}
+
+// Put implements Store.
+func (s *GCSStore) Put(ctx context.Context, key string, body []byte) error {
+	u := fmt.Sprintf("https://storage.googleapis.com/upload/storage/v1/b/%s/o?%s",
+		url.PathEscape(s.Bucket), url.Values{"uploadType": {"media"}, "name": {key}}.Encode())
+	req, err := http.NewRequest(http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	_, err = do(s.Client, req.WithContext(ctx), false)
+	return err
+}
+
+// List implements Store.
+func (s *GCSStore) List(ctx context.Context, prefix string) ([]Object, error) {
+	var out []Object
+	token := ""
+	for {
+		query := url.Values{"prefix": {prefix}, "fields": {"items(name,updated),nextPageToken"}}
+		if token != "" {
+			query.Set("pageToken", token)
+		}
+		req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("https://storage.googleapis.com/storage/v1/b/%s/o?%s", url.PathEscape(s.Bucket), query.Encode()), nil)
+		if err != nil {
+			return nil, err
+		}
+		body, err := do(s.Client, req.WithContext(ctx), false)
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			Items []struct {
+				Name    string    `json:"name"`
+				Updated time.Time `json:"updated"`
+			} `json:"items"`
+			NextPageToken string `json:"nextPageToken"`
+		}
+		if err := json.Unmarshal(body, &page); err != nil {
+			return nil, err
+		}
+		for _, item := range page.Items {
+			out = append(out, Object{Key: item.Name, Modified: item.Updated})
+		}
+		if page.NextPageToken == "" {
+			return out, nil
+		}
+		token = page.NextPageToken
+	}
+}
+
+// Delete implements Store.
+func (s *GCSStore) Delete(ctx context.Context, key string) error {
+	req, err := http.NewRequest(http.MethodDelete, fmt.Sprintf("https://storage.googleapis.com/storage/v1/b/%s/o/%s", url.PathEscape(s.Bucket), url.PathEscape(key)), nil)
+	if err != nil {
+		return err
+	}
+	_, err = do(s.Client, req.WithContext(ctx), true)
+	return err
+}
+
+// azureVersion is the Blob service REST API version of the requests.
+const azureVersion = "2019-02-02"
+
+// AzureStore writes to an Azure Blob container with a SAS token.
+type AzureStore struct {
+	Account   string
+	Container string
+	SASToken  string
+	Client    *http.Client
+}
+
+// Name implements Store.
+func (s *AzureStore) Name() string {
+	return "azblob://" + s.Account + "/" + s.Container
+}
+
+func (s *AzureStore) url(key string, query url.Values) string {
+	u := fmt.Sprintf("https://%s.blob.core.windows.net/%s", s.Account, s.Container)
+	if key != "" {
+		u += "/" + (&url.URL{Path: key}).EscapedPath()
+	}
+	u += "?" + s.SASToken
+	if len(query) > 0 {
+		u += "&" + query.Encode()
+	}
+	return u
+}
+
+func (s *AzureStore) send(ctx context.Context, method, u string, body []byte, header map[string]string, allowNotFound bool) ([]byte, error) {
+	req, err := http.NewRequest(method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("x-ms-version", azureVersion)
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	return do(s.Client, req.WithContext(ctx), allowNotFound)
+}
+
+// Put implements Store.
+func (s *AzureStore) Put(ctx context.Context, key string, body []byte) error {
+	_, err := s.send(ctx, http.MethodPut, s.url(key, nil), body, map[string]string{
+		"x-ms-blob-type": "BlockBlob",
+		"Content-Type":   "application/x-ndjson",
+	}, false)
+	return err
+}
+
+// List implements Store.
+func (s *AzureStore) List(ctx context.Context, prefix string) ([]Object, error) {
+	var out []Object
+	marker := ""
+	for {
+		query := url.Values{"restype": {"container"}, "comp": {"list"}, "prefix": {prefix}}
+		if marker != "" {
+			query.Set("marker", marker)
+		}
+		body, err := s.send(ctx, http.MethodGet, s.url("", query), nil, nil, false)
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			Blobs []struct {
+				Name         string `xml:"Name"`
+				LastModified string `xml:"Properties>Last-Modified"`
+			} `xml:"Blobs>Blob"`
+			NextMarker string `xml:"NextMarker"`
+		}
+		if err := xml.Unmarshal(body, &page); err != nil {
+			return nil, err
+		}
+		for _, b := range page.Blobs {
+			modified, err := time.Parse(time.RFC1123, b.LastModified)
+			if err != nil {
+				return nil, fmt.Errorf("blob %s: invalid Last-Modified %q", b.Name, b.LastModified)
+			}
+			out = append(out, Object{Key: b.Name, Modified: modified})
+		}
+		if page.NextMarker == "" {
+			return out, nil
+		}
+		marker = page.NextMarker
+	}
+}
+
+// Delete implements Store.
+func (s *AzureStore) Delete(ctx context.Context, key string) error {
+	_, err := s.send(ctx, http.MethodDelete, s.url(key, nil), nil, nil, true)
+	return err
+}
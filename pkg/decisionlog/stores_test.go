@@ -0,0 +1,316 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package decisionlog
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	v4 "github.com/aws/aws-sdk-go/aws/signer/v4"
+)
+
+// request is a request received by the test server.
+type request struct {
+	Method string
+	Host   string
+	Path   string
+	Query  url.Values
+	Header http.Header
+	Body   string
+}
+
+// storeServer answers the requests of a store with the responses, in
+// order, and records them.
+type storeServer struct {
+	responses []response
+	requests  []request
+}
+
+type response struct {
+	code int
+	body string
+}
+
+func (s *storeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	host := r.Header.Get("X-Original-Host")
+	if host == "" {
+		host = r.Host
+	}
+	s.requests = append(s.requests, request{Method: r.Method, Host: host, Path: r.URL.EscapedPath(), Query: r.URL.Query(), Header: r.Header, Body: string(body)})
+	resp := response{code: http.StatusInternalServerError}
+	if len(s.responses) > 0 {
+		resp, s.responses = s.responses[0], s.responses[1:]
+	}
+	w.WriteHeader(resp.code)
+	w.Write([]byte(resp.body))
+}
+
+// redirect sends every request to the test server, keeping the host it
+// was meant for in X-Original-Host.
+type redirect struct {
+	target *url.URL
+}
+
+func (r redirect) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("X-Original-Host", req.URL.Host)
+	req.URL.Scheme, req.URL.Host = r.target.Scheme, r.target.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func newStoreServer(t *testing.T, responses ...response) (*storeServer, *httptest.Server, *http.Client) {
+	t.Helper()
+	s := &storeServer{responses: responses}
+	server := httptest.NewServer(s)
+	target, _ := url.Parse(server.URL)
+	return s, server, &http.Client{Transport: redirect{target: target}, Timeout: 10 * time.Second}
+}
+
+func TestS3Store(t *testing.T) {
+	s, server, _ := newStoreServer(t,
+		response{code: http.StatusOK},
+		response{code: http.StatusOK, body: `<ListBucketResult><Contents><Key>gc/2019/10/16/a.ndjson</Key><LastModified>2019-10-16T12:00:00.000Z</LastModified></Contents><IsTruncated>true</IsTruncated><NextContinuationToken>next</NextContinuationToken></ListBucketResult>`},
+		response{code: http.StatusOK, body: `<ListBucketResult><Contents><Key>gc/2019/10/17/b c.ndjson</Key><LastModified>2019-10-17T12:00:00.000Z</LastModified></Contents><IsTruncated>false</IsTruncated></ListBucketResult>`},
+		response{code: http.StatusNotFound},
+		response{code: http.StatusForbidden},
+	)
+	defer server.Close()
+	store := &S3Store{
+		Bucket:   "bucket",
+		Region:   "eu-west-1",
+		Endpoint: server.URL + "/",
+		Signer:   v4.NewSigner(credentials.NewStaticCredentials("AKID", "SECRET", "")),
+		Client:   server.Client(),
+	}
+	ctx := context.Background()
+
+	if err := store.Put(ctx, "gc/2019/10/16/a.ndjson", []byte(`{"id":"1"}`)); err != nil {
+		t.Fatalf("Put() = %v", err)
+	}
+	put := s.requests[0]
+	if put.Method != http.MethodPut || put.Path != "/bucket/gc/2019/10/16/a.ndjson" || put.Body != `{"id":"1"}` {
+		t.Errorf("Put() sent %s %s %q, want PUT /bucket/gc/2019/10/16/a.ndjson", put.Method, put.Path, put.Body)
+	}
+	if auth := put.Header.Get("Authorization"); !strings.Contains(auth, "AWS4-HMAC-SHA256 Credential=AKID/") || !strings.Contains(auth, "/eu-west-1/s3/aws4_request") {
+		t.Errorf("Put() Authorization = %q, want a SigV4 signature for s3 in eu-west-1", auth)
+	}
+
+	objects, err := store.List(ctx, "gc/")
+	if err != nil {
+		t.Fatalf("List() = %v", err)
+	}
+	want := []Object{
+		{Key: "gc/2019/10/16/a.ndjson", Modified: time.Date(2019, 10, 16, 12, 0, 0, 0, time.UTC)},
+		{Key: "gc/2019/10/17/b c.ndjson", Modified: time.Date(2019, 10, 17, 12, 0, 0, 0, time.UTC)},
+	}
+	if !reflect.DeepEqual(objects, want) {
+		t.Errorf("List() = %v, want %v", objects, want)
+	}
+	if q := s.requests[1].Query; q.Get("list-type") != "2" || q.Get("prefix") != "gc/" || q.Get("continuation-token") != "" {
+		t.Errorf("List() first page query = %v", q)
+	}
+	if q := s.requests[2].Query; q.Get("continuation-token") != "next" {
+		t.Errorf("List() second page query = %v, want the continuation token", q)
+	}
+
+	if err := store.Delete(ctx, "gc/2019/10/17/b c.ndjson"); err != nil {
+		t.Errorf("Delete() of a missing object = %v", err)
+	}
+	if del := s.requests[3]; del.Method != http.MethodDelete || del.Path != "/bucket/gc/2019/10/17/b%20c.ndjson" {
+		t.Errorf("Delete() sent %s %s", del.Method, del.Path)
+	}
+	if err := store.Delete(ctx, "gc/2019/10/16/a.ndjson"); err == nil {
+		t.Error("Delete() = nil, want the forbidden error")
+	}
+}
+
+func TestS3StoreURL(t *testing.T) {
+	store := &S3Store{Bucket: "bucket", Region: "eu-west-1"}
+	if got, want := store.url("gc/a.ndjson", nil), "https://bucket.s3.eu-west-1.amazonaws.com/gc/a.ndjson"; got != want {
+		t.Errorf("url() = %s, want %s", got, want)
+	}
+}
+
+func TestGCSStore(t *testing.T) {
+	s, server, client := newStoreServer(t,
+		response{code: http.StatusOK, body: `{}`},
+		response{code: http.StatusOK, body: `{"items":[{"name":"gc/2019/10/16/a.ndjson","updated":"2019-10-16T12:00:00Z"}],"nextPageToken":"next"}`},
+		response{code: http.StatusOK, body: `{"items":[{"name":"gc/2019/10/17/b.ndjson","updated":"2019-10-17T12:00:00Z"}]}`},
+		response{code: http.StatusNotFound},
+		response{code: http.StatusUnauthorized},
+	)
+	defer server.Close()
+	store := &GCSStore{Bucket: "bucket", Client: client}
+	ctx := context.Background()
+
+	if err := store.Put(ctx, "gc/2019/10/16/a.ndjson", []byte(`{"id":"1"}`)); err != nil {
+		t.Fatalf("Put() = %v", err)
+	}
+	put := s.requests[0]
+	if put.Method != http.MethodPost || put.Host != "storage.googleapis.com" || put.Path != "/upload/storage/v1/b/bucket/o" ||
+		put.Query.Get("name") != "gc/2019/10/16/a.ndjson" || put.Query.Get("uploadType") != "media" || put.Body != `{"id":"1"}` {
+		t.Errorf("Put() sent %+v", put)
+	}
+
+	objects, err := store.List(ctx, "gc/")
+	if err != nil {
+		t.Fatalf("List() = %v", err)
+	}
+	want := []Object{
+		{Key: "gc/2019/10/16/a.ndjson", Modified: time.Date(2019, 10, 16, 12, 0, 0, 0, time.UTC)},
+		{Key: "gc/2019/10/17/b.ndjson", Modified: time.Date(2019, 10, 17, 12, 0, 0, 0, time.UTC)},
+	}
+	if !reflect.DeepEqual(objects, want) {
+		t.Errorf("List() = %v, want %v", objects, want)
+	}
+	if q := s.requests[1].Query; q.Get("prefix") != "gc/" || q.Get("pageToken") != "" {
+		t.Errorf("List() first page query = %v", q)
+	}
+	if q := s.requests[2].Query; q.Get("pageToken") != "next" {
+		t.Errorf("List() second page query = %v, want the page token", q)
+	}
+
+	if err := store.Delete(ctx, "gc/2019/10/17/b.ndjson"); err != nil {
+		t.Errorf("Delete() of a missing object = %v", err)
+	}
+	if del := s.requests[3]; del.Method != http.MethodDelete || del.Path != "/storage/v1/b/bucket/o/gc%2F2019%2F10%2F17%2Fb.ndjson" {
+		t.Errorf("Delete() sent %s %s", del.Method, del.Path)
+	}
+	if err := store.Delete(ctx, "gc/2019/10/16/a.ndjson"); err == nil {
+		t.Error("Delete() = nil, want the unauthorized error")
+	}
+}
+
+func TestAzureStore(t *testing.T) {
+	s, server, client := newStoreServer(t,
+		response{code: http.StatusCreated},
+		response{code: http.StatusOK, body: `<EnumerationResults><Blobs><Blob><Name>gc/2019/10/16/a.ndjson</Name><Properties><Last-Modified>Wed, 16 Oct 2019 12:00:00 GMT</Last-Modified></Properties></Blob></Blobs><NextMarker>next</NextMarker></EnumerationResults>`},
+		response{code: http.StatusOK, body: `<EnumerationResults><Blobs><Blob><Name>gc/2019/10/17/b.ndjson</Name><Properties><Last-Modified>Thu, 17 Oct 2019 12:00:00 GMT</Last-Modified></Properties></Blob></Blobs><NextMarker/></EnumerationResults>`},
+		response{code: http.StatusNotFound},
+		response{code: http.StatusOK, body: `<EnumerationResults><Blobs><Blob><Name>gc/c.ndjson</Name><Properties><Last-Modified>yesterday</Last-Modified></Properties></Blob></Blobs></EnumerationResults>`},
+	)
+	defer server.Close()
+	store := &AzureStore{Account: "account", Container: "container", SASToken: "sv=2019-02-02&sig=secret", Client: client}
+	ctx := context.Background()
+
+	if err := store.Put(ctx, "gc/2019/10/16/a.ndjson", []byte(`{"id":"1"}`)); err != nil {
+		t.Fatalf("Put() = %v", err)
+	}
+	put := s.requests[0]
+	if put.Method != http.MethodPut || put.Host != "account.blob.core.windows.net" || put.Path != "/container/gc/2019/10/16/a.ndjson" ||
+		put.Query.Get("sig") != "secret" || put.Header.Get("x-ms-blob-type") != "BlockBlob" || put.Header.Get("x-ms-version") != azureVersion {
+		t.Errorf("Put() sent %+v", put)
+	}
+
+	objects, err := store.List(ctx, "gc/")
+	if err != nil {
+		t.Fatalf("List() = %v", err)
+	}
+	want := []Object{
+		{Key: "gc/2019/10/16/a.ndjson", Modified: time.Date(2019, 10, 16, 12, 0, 0, 0, time.UTC)},
+		{Key: "gc/2019/10/17/b.ndjson", Modified: time.Date(2019, 10, 17, 12, 0, 0, 0, time.UTC)},
+	}
+	for i := range objects {
+		objects[i].Modified = objects[i].Modified.UTC()
+	}
+	if !reflect.DeepEqual(objects, want) {
+		t.Errorf("List() = %v, want %v", objects, want)
+	}
+	if q := s.requests[1].Query; q.Get("comp") != "list" || q.Get("restype") != "container" || q.Get("prefix") != "gc/" || q.Get("marker") != "" {
+		t.Errorf("List() first page query = %v", q)
+	}
+	if q := s.requests[2].Query; q.Get("marker") != "next" {
+		t.Errorf("List() second page query = %v, want the marker", q)
+	}
+
+	if err := store.Delete(ctx, "gc/2019/10/17/b.ndjson"); err != nil {
+		t.Errorf("Delete() of a missing object = %v", err)
+	}
+	if _, err := store.List(ctx, "gc/"); err == nil || !strings.Contains(err.Error(), "invalid Last-Modified") {
+		t.Errorf("List() = %v, want an invalid Last-Modified error", err)
+	}
+}
+
+func TestNewStore(t *testing.T) {
+	os.Setenv(AzureSASTokenEnv, "?sv=2019-02-02&sig=secret")
+	defer os.Unsetenv(AzureSASTokenEnv)
+
+	tests := []struct {
+		url        string
+		wantName   string
+		wantPrefix string
+		wantErr    string
+	}{{
+		url:        "s3://bucket/revision-gc/prod?region=eu-west-1",
+		wantName:   "s3://bucket",
+		wantPrefix: "revision-gc/prod/",
+	}, {
+		url:      "s3://bucket?region=eu-west-1",
+		wantName: "s3://bucket",
+	}, {
+		url:        "azblob://account/container/revision-gc/",
+		wantName:   "azblob://account/container",
+		wantPrefix: "revision-gc/",
+	}, {
+		url:      "azblob://account/container",
+		wantName: "azblob://account/container",
+	}, {
+		url:     "azblob://account",
+		wantErr: "expected azblob://account/container/prefix",
+	}, {
+		url:     "s3:///revision-gc",
+		wantErr: "missing bucket",
+	}, {
+		url:     "gs:///revision-gc",
+		wantErr: "missing bucket",
+	}, {
+		url:     "file:///var/log/revision-gc",
+		wantErr: "scheme must be s3, gs or azblob",
+	}}
+
+	for _, test := range tests {
+		t.Run(test.url, func(t *testing.T) {
+			store, prefix, err := NewStore(test.url)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Errorf("NewStore() = %v, want error containing %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewStore() = %v", err)
+			}
+			if store.Name() != test.wantName || prefix != test.wantPrefix {
+				t.Errorf("NewStore() = %s, %q, want %s, %q", store.Name(), prefix, test.wantName, test.wantPrefix)
+			}
+		})
+	}
+
+	if store, _, err := NewStore("azblob://account/container"); err == nil && store.(*AzureStore).SASToken != "sv=2019-02-02&sig=secret" {
+		t.Errorf("SASToken = %q, want it without the leading ?", store.(*AzureStore).SASToken)
+	}
+}
@@ -0,0 +1,124 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package distribution detects the Knative Serving distribution the
+// controller runs against and what it needs to handle differently.
+package distribution
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/discovery"
+)
+
+// Distribution is a Knative Serving distribution.
+type Distribution string
+
+const (
+	// Auto detects the distribution from the API groups the cluster serves.
+	Auto Distribution = "auto"
+	// Upstream is Knative Serving as released by the Knative project.
+	Upstream Distribution = "upstream"
+	// OpenShiftServerless is Knative Serving installed by the OpenShift
+	// Serverless operator.
+	OpenShiftServerless Distribution = "openshift-serverless"
+)
+
+// openShiftRouteGroup is only served by OpenShift clusters.
+const openShiftRouteGroup = "route.openshift.io"
+
+// InjectCABundleAnnotationKey makes the OpenShift service CA operator inject
+// its CA bundle into webhook configurations and APIServices.
+const InjectCABundleAnnotationKey = "service.beta.openshift.io/inject-cabundle"
+
+// requiredResources are the Knative resources the controller reads, by
+// group version. Distributions may stop serving the older versions. Changes
+// must pass the end-to-end tests of test/e2e against every supported Knative
+// Serving version.
+var requiredResources = map[string][]string{
+	"serving.knative.dev/v1alpha1":              {"configurations", "revisions", "routes", "services"},
+	"autoscaling.internal.knative.dev/v1alpha1": {"podautoscalers"},
+}
+
+// Parse parses a distribution name.
+func Parse(raw string) (Distribution, error) {
+	switch d := Distribution(raw); d {
+	case Auto, Upstream, OpenShiftServerless:
+		return d, nil
+	}
+	return "", fmt.Errorf("unknown distribution %q, use %s, %s or %s", raw, Auto, Upstream, OpenShiftServerless)
+}
+
+// Resolve returns d, or the detected distribution when d is Auto.
+func Resolve(d Distribution, client discovery.DiscoveryInterface) (Distribution, error) {
+	if d != Auto {
+		return d, nil
+	}
+	return Detect(client)
+}
+
+// Detect detects the distribution from the API groups the cluster serves.
+func Detect(client discovery.DiscoveryInterface) (Distribution, error) {
+	groups, err := client.ServerGroups()
+	if err != nil {
+		return "", fmt.Errorf("detect distribution: %v", err)
+	}
+	for _, g := range groups.Groups {
+		if g.Name == openShiftRouteGroup {
+			return OpenShiftServerless, nil
+		}
+	}
+	return Upstream, nil
+}
+
+// CheckAPIs returns an error naming the Knative resources the controller reads
+// that the cluster does not serve.
+func CheckAPIs(client discovery.DiscoveryInterface) error {
+	var missing []string
+	for gv, resources := range requiredResources {
+		list, err := client.ServerResourcesForGroupVersion(gv)
+		if apierrs.IsNotFound(err) {
+			missing = append(missing, gv)
+			continue
+		} else if err != nil {
+			return fmt.Errorf("discover %s: %v", gv, err)
+		}
+		served := make(map[string]bool, len(list.APIResources))
+		for _, r := range list.APIResources {
+			served[r.Name] = true
+		}
+		for _, r := range resources {
+			if !served[r] {
+				missing = append(missing, gv+"/"+r)
+			}
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("the cluster does not serve %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// InjectsCABundle reports whether the distribution runs the service CA
+// operator, which issues serving certificates for annotated Services and
+// injects their CA bundle into annotated webhook configurations.
+func (d Distribution) InjectsCABundle() bool {
+	return d == OpenShiftServerless
+}
@@ -0,0 +1,186 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package drain verifies that the pods of a revision no longer hold active
+// connections before the revision is deleted, e.g. long-lived connections
+// the service mesh keeps open after the Route moved on.
+package drain
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/knative-sample/revision-controller/pkg/config"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// IstioProxyContainer is the name of the Istio sidecar container.
+const IstioProxyContainer = "istio-proxy"
+
+var defaultClient = &http.Client{Timeout: 10 * time.Second}
+
+// Verifier verifies that pods hold no active connections.
+type Verifier interface {
+	// ActiveConnections returns the active connections of the pod.
+	ActiveConnections(ctx context.Context, pod *corev1.Pod) (float64, error)
+}
+
+// FromConfig returns the verifier configured in cfg, or nil when deletions
+// are not verified.
+func FromConfig(cfg *config.GC) Verifier {
+	switch cfg.DrainVerifier {
+	case config.DrainVerifierIstio:
+		return &Istio{StatsPort: cfg.DrainStatsPort, Client: defaultClient}
+	case config.DrainVerifierHTTP:
+		return &HTTPProbe{Port: cfg.DrainProbePort, Path: cfg.DrainProbePath, Client: defaultClient}
+	default:
+		return nil
+	}
+}
+
+// Drained reports whether none of the pods holds active connections, and the
+// first pod that does. Pods without an IP, not started or already gone,
+// hold none.
+func Drained(ctx context.Context, v Verifier, pods []*corev1.Pod) (bool, string, error) {
+	for _, pod := range pods {
+		if pod.Status.PodIP == "" || pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		active, err := v.ActiveConnections(ctx, pod)
+		if err != nil {
+			return false, pod.Name, err
+		}
+		if active > 0 {
+			return false, pod.Name, nil
+		}
+	}
+	return true, "", nil
+}
+
+// Istio reads the active connections of a pod from the Prometheus stats of
+// its Envoy sidecar: the connections Envoy holds to the application
+// container through the inbound clusters.
+type Istio struct {
+	// StatsPort is the port the sidecar serves /stats/prometheus on.
+	StatsPort int
+
+	Client *http.Client
+}
+
+// ActiveConnections sums envoy_cluster_upstream_cx_active over the inbound
+// clusters of the sidecar.
+func (i *Istio) ActiveConnections(ctx context.Context, pod *corev1.Pod) (float64, error) {
+	if !hasContainer(pod, IstioProxyContainer) {
+		return 0, fmt.Errorf("pod %s has no %s sidecar", pod.Name, IstioProxyContainer)
+	}
+
+	u := fmt.Sprintf("http://%s/stats/prometheus", net.JoinHostPort(pod.Status.PodIP, strconv.Itoa(i.StatsPort)))
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return 0, err
+	}
+	client := i.Client
+	if client == nil {
+		client = defaultClient
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return 0, fmt.Errorf("read envoy stats of pod %s: %v", pod.Name, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("read envoy stats of pod %s: unexpected status %s", pod.Name, resp.Status)
+	}
+
+	active := 0.0
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "envoy_cluster_upstream_cx_active{") || !strings.Contains(line, `cluster_name="inbound|`) {
+			continue
+		}
+		fields := strings.Fields(line)
+		val, err := strconv.ParseFloat(fields[len(fields)-1], 64)
+		if err != nil {
+			return 0, fmt.Errorf("read envoy stats of pod %s: invalid sample %q", pod.Name, line)
+		}
+		active += val
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, fmt.Errorf("read envoy stats of pod %s: %v", pod.Name, err)
+	}
+	return active, nil
+}
+
+// HTTPProbe reads the active connections of a pod from a probe it serves,
+// for pods without a service mesh sidecar: a GET request to Path on Port
+// answered with 200 and the number of active connections as the body.
+type HTTPProbe struct {
+	Port   int
+	Path   string
+	Client *http.Client
+}
+
+// maxProbeBody bounds the probe response read.
+const maxProbeBody = 64
+
+// ActiveConnections asks the probe of the pod.
+func (p *HTTPProbe) ActiveConnections(ctx context.Context, pod *corev1.Pod) (float64, error) {
+	u := fmt.Sprintf("http://%s%s", net.JoinHostPort(pod.Status.PodIP, strconv.Itoa(p.Port)), p.Path)
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return 0, err
+	}
+	client := p.Client
+	if client == nil {
+		client = defaultClient
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return 0, fmt.Errorf("probe connections of pod %s: %v", pod.Name, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("probe connections of pod %s: unexpected status %s", pod.Name, resp.Status)
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxProbeBody))
+	if err != nil {
+		return 0, fmt.Errorf("probe connections of pod %s: %v", pod.Name, err)
+	}
+	active, err := strconv.ParseFloat(strings.TrimSpace(string(body)), 64)
+	if err != nil || active < 0 {
+		return 0, fmt.Errorf("probe connections of pod %s: invalid count %q", pod.Name, body)
+	}
+	return active, nil
+}
+
+// hasContainer reports whether the pod runs the named container.
+func hasContainer(pod *corev1.Pod, name string) bool {
+	for _, c := range pod.Spec.Containers {
+		if c.Name == name {
+			return true
+		}
+	}
+	return false
+}
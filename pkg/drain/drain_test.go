@@ -0,0 +1,170 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package drain
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// probeServer serves the handler and returns the pod it stands for and the
+// port it listens on.
+func probeServer(t *testing.T, handler http.HandlerFunc) (*httptest.Server, *corev1.Pod, int) {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	host, rawPort, err := net.SplitHostPort(server.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("SplitHostPort() = %v", err)
+	}
+	port, _ := strconv.Atoi(rawPort)
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "hello-00001-deployment-abcde"},
+		Status:     corev1.PodStatus{PodIP: host, Phase: corev1.PodRunning},
+	}
+	return server, pod, port
+}
+
+func TestHTTPProbe(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		want    float64
+		wantErr bool
+	}{{
+		name:   "drained",
+		status: http.StatusOK,
+		body:   "0",
+	}, {
+		name:   "active",
+		status: http.StatusOK,
+		body:   "3\n",
+		want:   3,
+	}, {
+		name:    "error status",
+		status:  http.StatusServiceUnavailable,
+		wantErr: true,
+	}, {
+		name:    "not a number",
+		status:  http.StatusOK,
+		body:    "ok",
+		wantErr: true,
+	}, {
+		name:    "negative",
+		status:  http.StatusOK,
+		body:    "-1",
+		wantErr: true,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server, pod, port := probeServer(t, func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/connections" {
+					http.NotFound(w, r)
+					return
+				}
+				w.WriteHeader(test.status)
+				fmt.Fprint(w, test.body)
+			})
+			defer server.Close()
+
+			probe := &HTTPProbe{Port: port, Path: "/connections"}
+			got, err := probe.ActiveConnections(context.Background(), pod)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("ActiveConnections() error = %v, want error %v", err, test.wantErr)
+			}
+			if got != test.want {
+				t.Errorf("ActiveConnections() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestHTTPProbeUnreachable(t *testing.T) {
+	server, pod, port := probeServer(t, func(http.ResponseWriter, *http.Request) {})
+	server.Close()
+
+	probe := &HTTPProbe{Port: port, Path: "/connections"}
+	if _, err := probe.ActiveConnections(context.Background(), pod); err == nil {
+		t.Error("ActiveConnections() = nil error, want the pod to hold the deletion")
+	}
+}
+
+// connections is a Verifier reading the active connections by pod name.
+type connections map[string]float64
+
+func (c connections) ActiveConnections(_ context.Context, pod *corev1.Pod) (float64, error) {
+	return c[pod.Name], nil
+}
+
+func TestDrained(t *testing.T) {
+	verifier := connections{"busy": 2}
+	pod := func(name, ip string, phase corev1.PodPhase) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Status:     corev1.PodStatus{PodIP: ip, Phase: phase},
+		}
+	}
+
+	tests := []struct {
+		name    string
+		pods    []*corev1.Pod
+		drained bool
+		holder  string
+	}{{
+		name:    "no pods",
+		drained: true,
+	}, {
+		name:    "idle",
+		pods:    []*corev1.Pod{pod("idle", "10.0.0.1", corev1.PodRunning)},
+		drained: true,
+	}, {
+		name: "busy",
+		pods: []*corev1.Pod{
+			pod("idle", "10.0.0.1", corev1.PodRunning),
+			pod("busy", "10.0.0.2", corev1.PodRunning),
+		},
+		holder: "busy",
+	}, {
+		name: "not started or gone",
+		pods: []*corev1.Pod{
+			pod("busy", "", corev1.PodPending),
+			pod("busy", "10.0.0.2", corev1.PodSucceeded),
+		},
+		drained: true,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			drained, holder, err := Drained(context.Background(), verifier, test.pods)
+			if err != nil {
+				t.Fatalf("Drained() = %v", err)
+			}
+			if drained != test.drained || holder != test.holder {
+				t.Errorf("Drained() = %v, %q, want %v, %q", drained, holder, test.drained, test.holder)
+			}
+		})
+	}
+}
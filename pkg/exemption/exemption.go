@@ -0,0 +1,362 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package exemption exempts Services from garbage collection following
+// lists maintained outside of the controller, e.g. in a CMDB. A list holds
+// one entry per line, or a JSON array of entries:
+//
+//	# the whole namespace
+//	payments
+//	# a single Service
+//	checkout/frontend
+//
+// Lists are pulled periodically from an HTTP source, or read from a
+// ConfigMap another tool syncs them to. The revisions of exempted Services
+// are all retained with the Exempted reason.
+package exemption
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/kubernetes"
+	"knative.dev/pkg/configmap"
+)
+
+// ConfigMapKey is the key of the ConfigMap holding the list.
+const ConfigMapKey = "exemptions"
+
+// maxListSize bounds the lists read from an HTTP source.
+const maxListSize = 4 << 20
+
+var defaultClient = &http.Client{Timeout: 10 * time.Second}
+
+// Options configures the exemption sources.
+type Options struct {
+	// URL is the HTTP source the list is pulled from, none when empty.
+	URL string
+	// Interval is how often the list is pulled from URL.
+	Interval time.Duration
+	// ConfigMap names the ConfigMap of the system namespace holding the
+	// list in its exemptions key, none when empty.
+	ConfigMap string
+}
+
+// Enabled reports whether any source is configured.
+func (o Options) Enabled() bool {
+	return o.URL != "" || o.ConfigMap != ""
+}
+
+// Provider tells which Services are exempted from garbage collection.
+type Provider interface {
+	// Exempt returns the source exempting the Service, if any.
+	Exempt(namespace, service string) (source string, exempted bool)
+}
+
+// List is a parsed exemption list.
+type List struct {
+	namespaces map[string]bool
+	services   map[string]bool
+}
+
+// Parse parses a list of namespaces and namespace/service entries, one per
+// line with # comments, or as a JSON array.
+func Parse(raw []byte) (*List, error) {
+	var entries []string
+	if trimmed := strings.TrimSpace(string(raw)); strings.HasPrefix(trimmed, "[") {
+		if err := json.Unmarshal([]byte(trimmed), &entries); err != nil {
+			return nil, fmt.Errorf("invalid JSON list: %v", err)
+		}
+	} else {
+		for _, line := range strings.Split(trimmed, "\n") {
+			if i := strings.Index(line, "#"); i >= 0 {
+				line = line[:i]
+			}
+			entries = append(entries, line)
+		}
+	}
+
+	l := &List{namespaces: map[string]bool{}, services: map[string]bool{}}
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.Split(entry, "/")
+		if len(parts) > 2 {
+			return nil, fmt.Errorf("invalid entry %q: expected namespace or namespace/service", entry)
+		}
+		for _, part := range parts {
+			if errs := validation.IsDNS1123Label(part); len(errs) > 0 {
+				return nil, fmt.Errorf("invalid entry %q: %s", entry, strings.Join(errs, "; "))
+			}
+		}
+		if len(parts) == 1 {
+			l.namespaces[entry] = true
+		} else {
+			l.services[entry] = true
+		}
+	}
+	return l, nil
+}
+
+// Contains reports whether the list exempts the Service.
+func (l *List) Contains(namespace, service string) bool {
+	return l != nil && (l.namespaces[namespace] || l.services[namespace+"/"+service])
+}
+
+// Len returns the number of entries of the list.
+func (l *List) Len() int {
+	if l == nil {
+		return 0
+	}
+	return len(l.namespaces) + len(l.services)
+}
+
+// source holds the last list read from a source. A list that cannot be read
+// or parsed leaves the previous one in effect.
+type source struct {
+	name     string
+	logger   *zap.SugaredLogger
+	mu       sync.RWMutex
+	list     *List
+	onChange func()
+}
+
+// Exempt implements Provider.
+func (s *source) Exempt(namespace, service string) (string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.list.Contains(namespace, service) {
+		return s.name, true
+	}
+	return "", false
+}
+
+func (s *source) update(raw []byte) {
+	list, err := Parse(raw)
+	if err != nil {
+		s.logger.Errorf("exemption source: %s parse error:%s, keeping the previous list", s.name, err.Error())
+		return
+	}
+	s.mu.Lock()
+	changed := s.list == nil || !sameEntries(s.list, list)
+	s.list = list
+	s.mu.Unlock()
+	if changed {
+		s.logger.Infof("exemption source: %s loaded %d entries", s.name, list.Len())
+		if s.onChange != nil {
+			s.onChange()
+		}
+	}
+}
+
+func sameEntries(a, b *List) bool {
+	if len(a.namespaces) != len(b.namespaces) || len(a.services) != len(b.services) {
+		return false
+	}
+	for ns := range a.namespaces {
+		if !b.namespaces[ns] {
+			return false
+		}
+	}
+	for svc := range a.services {
+		if !b.services[svc] {
+			return false
+		}
+	}
+	return true
+}
+
+// Set merges the lists of its sources: a Service is exempted when any of
+// them exempts it.
+type Set struct {
+	sources []*source
+
+	mu        sync.Mutex
+	observers []func()
+}
+
+var _ Provider = (*Set)(nil)
+
+// Exempt implements Provider.
+func (s *Set) Exempt(namespace, service string) (string, bool) {
+	if s == nil {
+		return "", false
+	}
+	for _, src := range s.sources {
+		if name, ok := src.Exempt(namespace, service); ok {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// OnChange registers f to be called whenever a list changed, e.g. to
+// evaluate the Services again.
+func (s *Set) OnChange(f func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.observers = append(s.observers, f)
+}
+
+func (s *Set) changed() {
+	s.mu.Lock()
+	observers := append([]func(){}, s.observers...)
+	s.mu.Unlock()
+	for _, f := range observers {
+		f()
+	}
+}
+
+func (s *Set) add(name string, logger *zap.SugaredLogger) *source {
+	src := &source{name: name, logger: logger, onChange: s.changed}
+	s.sources = append(s.sources, src)
+	return src
+}
+
+// New returns the Set of the sources configured by options. The ConfigMap
+// source is watched with cmw in namespace, a missing ConfigMap exempts
+// nothing. The HTTP source is pulled until stopCh is closed. It returns nil
+// when no source is configured.
+func New(logger *zap.SugaredLogger, options Options, cmw configmap.DefaultingWatcher, namespace string, stopCh <-chan struct{}) (*Set, error) {
+	if !options.Enabled() {
+		return nil, nil
+	}
+	if options.URL != "" && options.Interval <= 0 {
+		return nil, fmt.Errorf("exemptions interval must be positive, got %s", options.Interval)
+	}
+	s := &Set{}
+	if options.ConfigMap != "" {
+		src := s.add("configmap "+options.ConfigMap, logger)
+		cmw.WatchWithDefault(corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: options.ConfigMap, Namespace: namespace},
+		}, func(cm *corev1.ConfigMap) {
+			src.update([]byte(cm.Data[ConfigMapKey]))
+		})
+	}
+	if options.URL != "" {
+		src := s.add(options.URL, logger)
+		go pull(src, options.URL, options.Interval, stopCh)
+	}
+	return s, nil
+}
+
+// Read returns the Set of the sources configured by options, read once, so
+// CLIs exempt the Services the controller exempts. The ConfigMap source is
+// read with kubeClient from namespace, a missing ConfigMap exempts nothing.
+// Unlike the sources of New, a list that cannot be read fails. It returns
+// nil when no source is configured.
+func Read(kubeClient kubernetes.Interface, options Options, namespace string) (*Set, error) {
+	if !options.Enabled() {
+		return nil, nil
+	}
+	s := &Set{}
+	if options.ConfigMap != "" {
+		raw := ""
+		cm, err := kubeClient.CoreV1().ConfigMaps(namespace).Get(options.ConfigMap, metav1.GetOptions{})
+		if err == nil {
+			raw = cm.Data[ConfigMapKey]
+		} else if !apierrs.IsNotFound(err) {
+			return nil, fmt.Errorf("read exemptions: %v", err)
+		}
+		if err := s.read("configmap "+options.ConfigMap, []byte(raw)); err != nil {
+			return nil, err
+		}
+	}
+	if options.URL != "" {
+		raw, err := fetch(options.URL)
+		if err != nil {
+			return nil, fmt.Errorf("read exemptions from %s: %v", options.URL, err)
+		}
+		if err := s.read(options.URL, raw); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// read adds the source name holding the list raw.
+func (s *Set) read(name string, raw []byte) error {
+	list, err := Parse(raw)
+	if err != nil {
+		return fmt.Errorf("read exemptions from %s: %v", name, err)
+	}
+	s.sources = append(s.sources, &source{name: name, list: list})
+	return nil
+}
+
+// pull reads the list at url every interval until stopCh is closed.
+func pull(src *source, url string, interval time.Duration, stopCh <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if raw, err := fetch(url); err != nil {
+			src.logger.Errorf("exemption source: %s fetch error:%s, keeping the previous list", url, err.Error())
+		} else {
+			src.update(raw)
+		}
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func fetch(url string) ([]byte, error) {
+	resp, err := defaultClient.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	raw, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxListSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) > maxListSize {
+		return nil, fmt.Errorf("list larger than %d bytes", maxListSize)
+	}
+	return raw, nil
+}
+
+type setKey struct{}
+
+// WithSet attaches the Set the controllers consult to ctx.
+func WithSet(ctx context.Context, s *Set) context.Context {
+	return context.WithValue(ctx, setKey{}, s)
+}
+
+// FromContext returns the Set attached to ctx, nil when none is.
+func FromContext(ctx context.Context) *Set {
+	s, _ := ctx.Value(setKey{}).(*Set)
+	return s
+}
@@ -0,0 +1,104 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package exemption
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+func TestRead(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/list":
+			w.Write([]byte("payments\n# a single Service\ncheckout/frontend\n"))
+		case "/invalid":
+			w.Write([]byte("a/b/c"))
+		case "/api/v1/namespaces/knative-serving/configmaps/exemptions":
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(&corev1.ConfigMap{Data: map[string]string{ConfigMapKey: `["batch"]`}})
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(&metav1.Status{Status: metav1.StatusFailure, Code: http.StatusNotFound, Reason: metav1.StatusReasonNotFound})
+		}
+	}))
+	defer server.Close()
+	kubeClient := kubernetes.NewForConfigOrDie(&rest.Config{Host: server.URL})
+
+	tests := []struct {
+		name    string
+		options Options
+		service string
+		want    string
+		wantErr bool
+	}{{
+		name:    "no source",
+		service: "payments/hello",
+	}, {
+		name:    "url",
+		options: Options{URL: server.URL + "/list"},
+		service: "checkout/frontend",
+		want:    server.URL + "/list",
+	}, {
+		name:    "url, not listed",
+		options: Options{URL: server.URL + "/list"},
+		service: "checkout/backend",
+	}, {
+		name:    "configmap",
+		options: Options{ConfigMap: "exemptions", URL: server.URL + "/list"},
+		service: "batch/hello",
+		want:    "configmap exemptions",
+	}, {
+		name:    "missing configmap",
+		options: Options{ConfigMap: "missing"},
+		service: "batch/hello",
+	}, {
+		name:    "invalid list",
+		options: Options{URL: server.URL + "/invalid"},
+		service: "a/b",
+		wantErr: true,
+	}, {
+		name:    "unreachable list",
+		options: Options{URL: server.URL + "/gone"},
+		service: "a/b",
+		wantErr: true,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := Read(kubeClient, tt.options, "knative-serving")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Read() = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			parts := strings.SplitN(tt.service, "/", 2)
+			if got, _ := s.Exempt(parts[0], parts[1]); got != tt.want {
+				t.Errorf("Exempt(%s) = %q, want %q", tt.service, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,163 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package explain explains why a revision is still there: every rule of the
+// policy and of the executor that currently keeps it. It is shared by the
+// admin API and the kubectl plugin.
+package explain
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/knative-sample/revision-controller/pkg/config"
+	"github.com/knative-sample/revision-controller/pkg/plan"
+	"github.com/knative-sample/revision-controller/pkg/quota"
+	"github.com/knative-sample/revision-controller/pkg/strategy"
+	"knative.dev/serving/pkg/apis/serving/v1alpha1"
+)
+
+const (
+	// ReasonMaintenanceHold is used while the maintenance hold defers all deletions.
+	ReasonMaintenanceHold strategy.Reason = "MaintenanceHold"
+	// ReasonOutsideDeletionWindows is used while no deletion window is open.
+	ReasonOutsideDeletionWindows strategy.Reason = "OutsideDeletionWindows"
+	// ReasonNotPlanned marks candidates the planner has not recorded in the
+	// deletion plan of the Service yet.
+	ReasonNotPlanned strategy.Reason = "NotPlanned"
+	// ReasonAwaitingApproval marks candidates whose plan is not approved yet.
+	ReasonAwaitingApproval strategy.Reason = "AwaitingApproval"
+	// ReasonQuotaExhausted is used while a deletion quota is exhausted.
+	ReasonQuotaExhausted strategy.Reason = "QuotaExhausted"
+)
+
+const (
+	// DecisionRetain means the policy keeps the revision.
+	DecisionRetain = "retain"
+	// DecisionDeferred means the revision is a deletion candidate, but the
+	// executor does not delete it yet.
+	DecisionDeferred = "deferred"
+	// DecisionDelete means the revision is deleted by the next execution.
+	DecisionDelete = "delete"
+)
+
+// Explanation is why a revision is still there.
+type Explanation struct {
+	Namespace  string `json:"namespace"`
+	Service    string `json:"service"`
+	Revision   string `json:"revision"`
+	Generation int    `json:"generation"`
+	Policy     string `json:"policy"`
+
+	// Decision is one of DecisionRetain, DecisionDeferred or DecisionDelete.
+	Decision string `json:"decision"`
+
+	// Rules lists every rule that currently keeps the revision.
+	Rules []Rule `json:"rules"`
+
+	// EligibleAt is the earliest time the revision is deleted once the
+	// minimum age, its lease and the deletion windows allow it. It is unset
+	// while rules that do not end by themselves keep it.
+	EligibleAt *time.Time `json:"eligibleAt,omitempty"`
+
+	EvaluatedAt time.Time `json:"evaluatedAt"`
+}
+
+// Rule is a rule keeping a revision.
+type Rule struct {
+	Reason  string `json:"reason"`
+	Message string `json:"message,omitempty"`
+}
+
+// Explain explains why the named revision of the Service is still there.
+// remaining is the deletion quota left for the namespace and the owner team
+// of the Service.
+func Explain(gc *config.GC, service *v1alpha1.Service, in strategy.Inputs, name string, remaining []quota.Remaining) (*Explanation, error) {
+	policy := gc.Policy()
+	e, err := strategy.Explain(policy, in, name)
+	if err != nil {
+		return nil, err
+	}
+
+	out := &Explanation{
+		Namespace:   service.Namespace,
+		Service:     service.Name,
+		Revision:    name,
+		Generation:  e.Decision.Generation,
+		Policy:      policy.Name,
+		Rules:       []Rule{},
+		EvaluatedAt: in.Now,
+	}
+	for _, p := range e.Protections {
+		out.addRule(p.Reason, p.Message)
+	}
+
+	// The executor rules, in the order the executor checks them.
+	if gc.MaintenanceHold {
+		out.addRule(ReasonMaintenanceHold, "the maintenance hold defers all deletions")
+	}
+	if windows := gc.DeletionWindows; len(windows) > 0 && !windows.Active(in.Now) {
+		out.addRule(ReasonOutsideDeletionWindows, fmt.Sprintf("no deletion window of %s is open, the next opens at %s",
+			windows, windows.NextStart(in.Now).Format(time.RFC3339)))
+	}
+	if e.Candidate {
+		p, err := plan.FromAnnotations(service.Annotations)
+		if err != nil {
+			return nil, err
+		}
+		if p == nil || !p.Contains(name) {
+			out.addRule(ReasonNotPlanned, "not recorded in the deletion plan of the Service yet")
+		} else if gc.ApprovalRequired && p.ApprovedBy(service.Annotations) == "" {
+			out.addRule(ReasonAwaitingApproval, fmt.Sprintf("the plan %s created at %s is not approved", p.Hash(), p.CreatedAt.Format(time.RFC3339)))
+		}
+	}
+	for _, r := range remaining {
+		if r.Remaining > 0 {
+			continue
+		}
+		scope := "global"
+		if r.Namespace != "" {
+			scope = "namespace " + r.Namespace
+		} else if r.Team != "" {
+			scope = "team " + r.Team
+		}
+		out.addRule(ReasonQuotaExhausted, fmt.Sprintf("the %s deletion quota per %s is exhausted", scope, r.Period))
+	}
+
+	if at := e.Decision.EligibleAt; !at.IsZero() {
+		if at.Before(in.Now) {
+			at = in.Now
+		}
+		if windows := gc.DeletionWindows; len(windows) > 0 && !windows.Active(at) {
+			at = windows.NextStart(at)
+		}
+		out.EligibleAt = &at
+	}
+
+	switch {
+	case !e.Candidate:
+		out.Decision = DecisionRetain
+	case len(out.Rules) > 0:
+		out.Decision = DecisionDeferred
+	default:
+		out.Decision = DecisionDelete
+	}
+	return out, nil
+}
+
+func (e *Explanation) addRule(reason strategy.Reason, message string) {
+	e.Rules = append(e.Rules, Rule{Reason: string(reason), Message: message})
+}
@@ -0,0 +1,107 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package footprint estimates the cluster resources held by a revision, so
+// the benefit of deleting it can be quantified.
+package footprint
+
+import (
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/labels"
+	"knative.dev/serving/pkg/apis/serving"
+	"knative.dev/serving/pkg/apis/serving/v1alpha1"
+)
+
+// Footprint is the estimated resource footprint of one or more revisions.
+type Footprint struct {
+	// Replicas is the number of desired pods.
+	Replicas int32
+	// Containers is the number of containers per pod, summed over revisions.
+	Containers int
+	// Volumes is the number of volumes per pod, summed over revisions.
+	Volumes int
+	// CPU is the requested CPU of all replicas.
+	CPU resource.Quantity
+	// Memory is the requested memory of all replicas.
+	Memory resource.Quantity
+}
+
+// RevisionSelector returns the selector matching the Deployment of a revision.
+func RevisionSelector(revision string) labels.Selector {
+	return labels.SelectorFromSet(map[string]string{
+		serving.RevisionLabelKey: revision,
+	})
+}
+
+// FromDeployment estimates the footprint of the Deployment backing a revision
+// from its desired replicas and the resource requests of its pod template.
+func FromDeployment(d *appsv1.Deployment) Footprint {
+	replicas := int32(1)
+	if d.Spec.Replicas != nil {
+		replicas = *d.Spec.Replicas
+	}
+	return fromPodSpec(&d.Spec.Template.Spec, replicas)
+}
+
+// FromRevision estimates the footprint of a revision from its spec, used when
+// its Deployment is not available. No replicas are assumed.
+func FromRevision(revision *v1alpha1.Revision) Footprint {
+	spec := revision.Spec.PodSpec.DeepCopy()
+	if len(spec.Containers) == 0 && revision.Spec.DeprecatedContainer != nil {
+		spec.Containers = []corev1.Container{*revision.Spec.DeprecatedContainer}
+	}
+	return fromPodSpec(spec, 0)
+}
+
+func fromPodSpec(spec *corev1.PodSpec, replicas int32) Footprint {
+	f := Footprint{
+		Replicas:   replicas,
+		Containers: len(spec.Containers),
+		Volumes:    len(spec.Volumes),
+	}
+	for _, c := range spec.Containers {
+		if q, ok := c.Resources.Requests[corev1.ResourceCPU]; ok {
+			for i := int32(0); i < replicas; i++ {
+				f.CPU.Add(q)
+			}
+		}
+		if q, ok := c.Resources.Requests[corev1.ResourceMemory]; ok {
+			for i := int32(0); i < replicas; i++ {
+				f.Memory.Add(q)
+			}
+		}
+	}
+	return f
+}
+
+// Add adds the other footprint to f.
+func (f *Footprint) Add(other Footprint) {
+	f.Replicas += other.Replicas
+	f.Containers += other.Containers
+	f.Volumes += other.Volumes
+	f.CPU.Add(other.CPU)
+	f.Memory.Add(other.Memory)
+}
+
+// String returns a short human readable representation.
+func (f Footprint) String() string {
+	return fmt.Sprintf("replicas=%d containers=%d volumes=%d cpu=%s memory=%s",
+		f.Replicas, f.Containers, f.Volumes, f.CPU.String(), f.Memory.String())
+}
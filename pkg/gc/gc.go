@@ -0,0 +1,182 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package gc is the revision garbage collection engine. It evaluates plain
+// snapshots of a Service and its related objects against the cluster
+// configuration, so operators and CLIs can embed the decisions the
+// controller makes without its informers:
+//
+//	cfg, _ := config.NewGCFromConfigMap(configMap)
+//	result, err := gc.New(cfg).Evaluate(gc.Snapshot{
+//		Service:   service,
+//		Route:     route,
+//		Revisions: revisions,
+//		Now:       time.Now(),
+//	})
+package gc
+
+import (
+	"time"
+
+	"github.com/knative-sample/revision-controller/pkg/config"
+	"github.com/knative-sample/revision-controller/pkg/explain"
+	"github.com/knative-sample/revision-controller/pkg/gcerrors"
+	"github.com/knative-sample/revision-controller/pkg/history"
+	"github.com/knative-sample/revision-controller/pkg/quota"
+	"github.com/knative-sample/revision-controller/pkg/strategy"
+	autoscalingv1alpha1 "knative.dev/serving/pkg/apis/autoscaling/v1alpha1"
+	"knative.dev/serving/pkg/apis/serving/v1alpha1"
+)
+
+// Snapshot holds a Service and the objects its revisions are evaluated
+// against, as read by the caller.
+type Snapshot struct {
+	// Service is the Service whose revisions are evaluated. The deploy and
+	// traffic tag histories are read from its annotations.
+	Service *v1alpha1.Service
+
+	// Route is the Route of the Service, nil if it does not exist yet.
+	Route *v1alpha1.Route
+
+	// Configuration is the Configuration of the Service, nil if it does not
+	// exist yet or is not read. The generations stamped on the revisions
+	// are checked against it.
+	Configuration *v1alpha1.Configuration
+
+	// Revisions are the revisions of the Service, as selected by
+	// Engine.RevisionSelector.
+	Revisions []*v1alpha1.Revision
+
+	// PodAutoscalers are the PodAutoscalers of the revisions.
+	PodAutoscalers []*autoscalingv1alpha1.PodAutoscaler
+
+	// Connections holds the open connections by revision name, nil when
+	// they are not checked.
+	Connections map[string]float64
+
+	// Now is the time the evaluation is done at, on the clock of the API
+	// server, e.g. from clockskew.Monitor.Now.
+	Now time.Time
+
+	// ClockSkew is the measured offset of the API server clock from the
+	// local clock, e.g. from clockskew.Monitor.Offset.
+	ClockSkew time.Duration
+
+	// ExemptedBy names the exemption source exempting the Service, empty
+	// when it is not exempted.
+	ExemptedBy string
+
+	// QuotaPressure describes the ResourceQuota the namespace of the
+	// Service is near, empty when it is near none or it is not checked.
+	QuotaPressure string
+}
+
+// Engine evaluates snapshots against a GC configuration.
+type Engine struct {
+	config *config.GC
+}
+
+// New returns an Engine deciding with cfg.
+func New(cfg *config.GC) *Engine {
+	return &Engine{config: cfg}
+}
+
+// Config returns the configuration the Engine decides with.
+func (e *Engine) Config() *config.GC {
+	return e.config
+}
+
+// Policy returns the retention policy the Engine applies.
+func (e *Engine) Policy() strategy.Policy {
+	return e.config.Policy()
+}
+
+// Inputs returns the strategy inputs of the snapshot, adding the deploy and
+// traffic tag histories recorded on the Service. A history the policy uses
+// that cannot be read fails with a PolicyResolutionError instead of being
+// ignored, as the controller does, since it may protect revisions.
+func (e *Engine) Inputs(s Snapshot) (strategy.Inputs, error) {
+	in := strategy.Inputs{
+		Route:           s.Route,
+		Revisions:       s.Revisions,
+		PodAutoscalers:  s.PodAutoscalers,
+		Connections:     s.Connections,
+		InFlight:        strategy.InFlightStates(s.Revisions),
+		Now:             s.Now,
+		ClockSkew:       s.ClockSkew,
+		ExemptedBy:      s.ExemptedBy,
+		LegacyMode:      strategy.LegacyMode(s.Service),
+		SpecRevisions:   strategy.SpecRevisions(s.Service),
+		RolloutDuration: strategy.RolloutDuration(s.Service, s.Route),
+		QuotaPressure:   s.QuotaPressure,
+	}
+	if s.Configuration != nil {
+		in.ConfigurationGeneration = s.Configuration.Generation
+	}
+	if e.config.KeepLastDeploys > 0 {
+		h, err := history.FromAnnotations(s.Service.Annotations)
+		if err != nil {
+			return strategy.Inputs{}, &gcerrors.PolicyResolutionError{Err: err}
+		}
+		in.DeployGenerations = h.Generations(e.config.KeepLastDeploys)
+	}
+	if len(e.config.KeepTagHistory) > 0 {
+		tags, err := history.TagsFromAnnotations(s.Service.Annotations)
+		if err != nil {
+			return strategy.Inputs{}, &gcerrors.PolicyResolutionError{Err: err}
+		}
+		in.TagHolders = tags.Revisions()
+	}
+	if e.config.OwnershipHandoffPeriod > 0 {
+		owner, err := history.OwnerFromAnnotations(s.Service.Annotations)
+		if err != nil {
+			return strategy.Inputs{}, &gcerrors.PolicyResolutionError{Err: err}
+		}
+		if owner.InHandoff(s.Now) {
+			in.HandoffUntil = owner.HandoffUntil.Time
+		}
+	}
+	if e.config.RollbackFreezePeriod > 0 {
+		rollback, err := history.RollbackFromAnnotations(s.Service.Annotations)
+		if err != nil {
+			return strategy.Inputs{}, &gcerrors.PolicyResolutionError{Err: err}
+		}
+		if rollback.Frozen(s.Now) {
+			in.FrozenUntil = rollback.FrozenUntil.Time
+		}
+	}
+	return in, nil
+}
+
+// Evaluate splits the revisions of the snapshot into retained revisions and
+// deletion candidates.
+func (e *Engine) Evaluate(s Snapshot) (*strategy.Result, error) {
+	in, err := e.Inputs(s)
+	if err != nil {
+		return nil, err
+	}
+	return strategy.Evaluate(e.Policy(), in)
+}
+
+// Explain explains why the named revision of the snapshot is still there.
+// remaining is the deletion quota left for the namespace of the Service.
+func (e *Engine) Explain(s Snapshot, revision string, remaining []quota.Remaining) (*explain.Explanation, error) {
+	in, err := e.Inputs(s)
+	if err != nil {
+		return nil, err
+	}
+	return explain.Explain(e.config, s.Service, in, revision, remaining)
+}
@@ -0,0 +1,140 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package gcerrors defines the types of the errors the revision garbage
+// collection surfaces, so events and metrics carry the type of an error and
+// alerting can be wired per type instead of matching messages.
+package gcerrors
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// Type is the type of an error, used as the reason of the Service events and
+// in the metrics.
+type Type string
+
+const (
+	// TypeTransientAPI is the type of failed API server requests, which are
+	// retried.
+	TypeTransientAPI Type = "TransientAPIError"
+	// TypePolicyResolution is the type of failures to resolve the inputs the
+	// policy decides on, e.g. an unreadable deploy history.
+	TypePolicyResolution Type = "PolicyResolutionError"
+	// TypeLabelParse is the type of unreadable revision labels and
+	// annotations.
+	TypeLabelParse Type = "LabelParseError"
+	// TypeProtectedConflict is the type of planned deletions of revisions the
+	// policy retains by now.
+	TypeProtectedConflict Type = "ProtectedConflict"
+	// TypePanic is the type of panics recovered while reconciling.
+	TypePanic Type = "Panic"
+	// TypeInternal is the type of all other errors.
+	TypeInternal Type = "InternalError"
+)
+
+// TransientAPIError is a failed API server request.
+type TransientAPIError struct {
+	Err error
+}
+
+func (e *TransientAPIError) Error() string { return e.Err.Error() }
+
+// Unwrap returns the error of the request.
+func (e *TransientAPIError) Unwrap() error { return e.Err }
+
+// PolicyResolutionError is a failure to resolve the inputs the policy
+// decides on.
+type PolicyResolutionError struct {
+	Err error
+}
+
+func (e *PolicyResolutionError) Error() string { return e.Err.Error() }
+
+// Unwrap returns the error resolving the inputs.
+func (e *PolicyResolutionError) Unwrap() error { return e.Err }
+
+// LabelParseError is an unreadable label or annotation of a revision.
+type LabelParseError struct {
+	Revision string
+	// Kind is "label" or "annotation".
+	Kind  string
+	Key   string
+	Value string
+	Err   error
+}
+
+func (e *LabelParseError) Error() string {
+	return fmt.Sprintf("revision %s has invalid %s %s %q: %v", e.Revision, e.Key, e.Kind, e.Value, e.Err)
+}
+
+// Unwrap returns the parse error.
+func (e *LabelParseError) Unwrap() error { return e.Err }
+
+// ProtectedConflict is a plan deleting revisions the policy retains by now.
+type ProtectedConflict struct {
+	// Revisions maps the planned revisions to the reason they are retained.
+	Revisions map[string]string
+}
+
+func (e *ProtectedConflict) Error() string {
+	retained := make([]string, 0, len(e.Revisions))
+	for name, reason := range e.Revisions {
+		retained = append(retained, fmt.Sprintf("%s (%s)", name, reason))
+	}
+	sort.Strings(retained)
+	return "planned revisions retained by the policy: " + strings.Join(retained, ", ")
+}
+
+// PanicError is a panic recovered while reconciling, retried like a
+// transient error.
+type PanicError struct {
+	Value interface{}
+}
+
+func (e *PanicError) Error() string { return fmt.Sprintf("recovered from panic: %v", e.Value) }
+
+// TypeOf returns the type of err. Errors of the API server and the network
+// are TransientAPIErrors even when not wrapped in one.
+func TypeOf(err error) Type {
+	var transient *TransientAPIError
+	var resolution *PolicyResolutionError
+	var parse *LabelParseError
+	var conflict *ProtectedConflict
+	var panicked *PanicError
+	var status apierrs.APIStatus
+	var netErr net.Error
+	switch {
+	case errors.As(err, &panicked):
+		return TypePanic
+	case errors.As(err, &conflict):
+		return TypeProtectedConflict
+	case errors.As(err, &parse):
+		return TypeLabelParse
+	case errors.As(err, &resolution):
+		return TypePolicyResolution
+	case errors.As(err, &transient), errors.As(err, &status), errors.As(err, &netErr):
+		return TypeTransientAPI
+	default:
+		return TypeInternal
+	}
+}
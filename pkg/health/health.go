@@ -0,0 +1,158 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package health serves the liveness and readiness of the controller for
+// the probes of its pod. The controller is live as long as it serves, and
+// ready once every component reported ready, e.g. not while it stands by
+// for Knative Serving to be installed.
+package health
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/knative-sample/revision-controller/pkg/listener"
+)
+
+// Default is the status of the process.
+var Default = New()
+
+// Status tracks why the components of the controller are not ready.
+type Status struct {
+	mu         sync.Mutex
+	reasons    map[string]string
+	workspaces map[string]*Status
+}
+
+// New returns a ready Status.
+func New() *Status {
+	return &Status{reasons: make(map[string]string), workspaces: make(map[string]*Status)}
+}
+
+// Workspace returns the status of the components of the named workspace.
+// The Status is only ready while all of its workspaces are.
+func (s *Status) Workspace(name string) *Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w, ok := s.workspaces[name]
+	if !ok {
+		w = New()
+		s.workspaces[name] = w
+	}
+	return w
+}
+
+type statusKey struct{}
+
+// WithStatus attaches the Status the components report to to ctx.
+func WithStatus(ctx context.Context, s *Status) context.Context {
+	return context.WithValue(ctx, statusKey{}, s)
+}
+
+// FromContext returns the Status attached to ctx, Default when none is.
+func FromContext(ctx context.Context) *Status {
+	if s, ok := ctx.Value(statusKey{}).(*Status); ok {
+		return s
+	}
+	return Default
+}
+
+// NotReady marks the component not ready for the reason.
+func (s *Status) NotReady(component, reason string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reasons[component] = reason
+}
+
+// Ready marks the component ready.
+func (s *Status) Ready(component string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.reasons, component)
+}
+
+// Reasons returns why the components are not ready, sorted by component.
+// The components of a workspace are prefixed with its name. It is empty
+// when all are ready.
+func (s *Status) Reasons() []string {
+	s.mu.Lock()
+	out := make([]string, 0, len(s.reasons))
+	for component, reason := range s.reasons {
+		out = append(out, fmt.Sprintf("%s: %s", component, reason))
+	}
+	workspaces := make(map[string]*Status, len(s.workspaces))
+	for name, w := range s.workspaces {
+		workspaces[name] = w
+	}
+	s.mu.Unlock()
+	for name, w := range workspaces {
+		for _, reason := range w.Reasons() {
+			out = append(out, name+"/"+reason)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// ServeHTTP implements http.Handler. It serves
+//
+//	GET /healthz
+//	GET /readyz
+//
+// /readyz fails with 503 Service Unavailable and the reasons while a
+// component is not ready.
+func (s *Status) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/healthz":
+		w.Write([]byte("ok"))
+	case "/readyz":
+		if reasons := s.Reasons(); len(reasons) > 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(strings.Join(reasons, "\n") + "\n"))
+			return
+		}
+		w.Write([]byte("ok"))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// Run serves the status over plain HTTP on the address until the context is
+// done.
+func (s *Status) Run(ctx context.Context, address string) error {
+	l, err := listener.Listen(address)
+	if err != nil {
+		return err
+	}
+	server := &http.Server{Handler: s}
+	errCh := make(chan error, 1)
+	go func() {
+		if err := server.Serve(l); err != http.ErrServerClosed {
+			errCh <- err
+		}
+	}()
+
+	select {
+	case <-ctx.Done():
+		return server.Shutdown(context.Background())
+	case err := <-errCh:
+		return err
+	}
+}
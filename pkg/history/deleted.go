@@ -0,0 +1,89 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package history
+
+import (
+	"encoding/json"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// DeletedAnnotationKey is the Service annotation holding the last revisions
+// garbage collection deleted, so they show inline with the Service.
+const DeletedAnnotationKey = "revision-gc.knative.dev/deleted-revisions"
+
+// DeletedRevision is a revision garbage collection deleted.
+type DeletedRevision struct {
+	// Name is the name of the revision.
+	Name string `json:"name"`
+
+	// DeletedAt is the time the revision was deleted.
+	DeletedAt metav1.Time `json:"deletedAt"`
+}
+
+// Deleted holds the most recently deleted revisions, newest first.
+type Deleted []DeletedRevision
+
+// DeletedFromAnnotations reads the deleted revisions recorded in the
+// annotations. It returns nil when none are recorded.
+func DeletedFromAnnotations(annotations map[string]string) (Deleted, error) {
+	raw, ok := annotations[DeletedAnnotationKey]
+	if !ok {
+		return nil, nil
+	}
+	var d Deleted
+	if err := json.Unmarshal([]byte(raw), &d); err != nil {
+		return nil, fmt.Errorf("invalid %s annotation: %v", DeletedAnnotationKey, err)
+	}
+	for _, r := range d {
+		if err := checkTime(DeletedAnnotationKey, &r.DeletedAt); err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+// Record returns the deleted revisions with the names deleted at now in
+// front, keeping at most limit revisions.
+func (d Deleted) Record(names []string, now metav1.Time, limit int) Deleted {
+	out := make(Deleted, 0, len(names)+len(d))
+	for _, name := range names {
+		out = append(out, DeletedRevision{Name: name, DeletedAt: now})
+	}
+	out = append(out, d...)
+	if len(out) > limit {
+		out = out[:limit]
+	}
+	return out
+}
+
+// DeletedMergePatch returns the JSON merge patch that records the deleted
+// revisions on the object.
+func DeletedMergePatch(d Deleted) ([]byte, error) {
+	raw, err := json.Marshal(d)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]interface{}{
+				DeletedAnnotationKey: string(raw),
+			},
+		},
+	})
+}
@@ -0,0 +1,167 @@
+//go:build go1.18
+// +build go1.18
+
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package history
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// annotation returns the value the merge patch sets the annotation to.
+func annotation(t *testing.T, patch []byte, key string) string {
+	t.Helper()
+	var p struct {
+		Metadata struct {
+			Annotations map[string]*string `json:"annotations"`
+		} `json:"metadata"`
+	}
+	if err := json.Unmarshal(patch, &p); err != nil {
+		t.Fatalf("invalid merge patch %s: %v", patch, err)
+	}
+	value := p.Metadata.Annotations[key]
+	if value == nil {
+		t.Fatalf("merge patch %s does not set %s", patch, key)
+	}
+	return *value
+}
+
+// roundTrip reads the record from the annotation value and, when it is
+// valid, checks that recording it again gives a value that reads back and
+// records the same.
+func roundTrip(t *testing.T, key, raw string, record func(annotations map[string]string) ([]byte, error)) {
+	first, err := record(map[string]string{key: raw})
+	if err != nil {
+		return
+	}
+	recorded := annotation(t, first, key)
+	second, err := record(map[string]string{key: recorded})
+	if err != nil {
+		t.Fatalf("recorded %s %q does not read back: %v", key, recorded, err)
+	}
+	if again := annotation(t, second, key); again != recorded {
+		t.Errorf("recorded %s %q, then %q", key, recorded, again)
+	}
+}
+
+// seeds adds the seed corpus of a record.
+func seeds(f *testing.F, values ...string) {
+	for _, v := range append(values, "", "null", "{}", "[]", `"`, "0") {
+		f.Add(v)
+	}
+}
+
+var observedAt = metav1.NewTime(time.Date(2019, 8, 1, 12, 0, 0, 0, time.UTC))
+
+func FuzzFromAnnotations(f *testing.F) {
+	h, _ := History(nil).Record(3, observedAt, 5)
+	raw, _ := json.Marshal(h)
+	seeds(f, string(raw), `[{"generation":-1,"observedAt":"9999-12-31T23:59:59-01:00"}]`)
+	f.Fuzz(func(t *testing.T, raw string) {
+		roundTrip(t, AnnotationKey, raw, func(annotations map[string]string) ([]byte, error) {
+			h, err := FromAnnotations(annotations)
+			if err != nil {
+				return nil, err
+			}
+			h.Generations(3)
+			return MergePatch(h)
+		})
+	})
+}
+
+func FuzzTagsFromAnnotations(f *testing.F) {
+	tags, _ := Tags(nil).Record(map[string]string{"stable": "hello-00001"}, observedAt, map[string]int{"stable": 2})
+	raw, _ := json.Marshal(tags)
+	seeds(f, string(raw), `{"stable":[{"revision":"hello-00001","observedAt":"9999-12-31T23:59:59-01:00"}]}`)
+	f.Fuzz(func(t *testing.T, raw string) {
+		roundTrip(t, TagsAnnotationKey, raw, func(annotations map[string]string) ([]byte, error) {
+			tags, err := TagsFromAnnotations(annotations)
+			if err != nil {
+				return nil, err
+			}
+			tags.Revisions()
+			return TagsMergePatch(tags)
+		})
+	})
+}
+
+func FuzzOwnerFromAnnotations(f *testing.F) {
+	o, _, _ := (&Owner{Owner: "team-a"}).Observe("team-b", observedAt.Time, time.Hour)
+	raw, _ := json.Marshal(o)
+	seeds(f, string(raw), `{"owner":"team-a","handoffUntil":"9999-12-31T23:59:59-01:00"}`)
+	f.Fuzz(func(t *testing.T, raw string) {
+		roundTrip(t, OwnerAnnotationKey, raw, func(annotations map[string]string) ([]byte, error) {
+			o, err := OwnerFromAnnotations(annotations)
+			if err != nil {
+				return nil, err
+			}
+			o.InHandoff(observedAt.Time)
+			return OwnerMergePatch(o)
+		})
+	})
+}
+
+func FuzzRollbackFromAnnotations(f *testing.F) {
+	r, _, _ := (&Rollback{Generation: 3}).Observe(2, observedAt.Time, time.Hour)
+	raw, _ := json.Marshal(r)
+	seeds(f, string(raw), `{"generation":2,"from":3,"frozenUntil":"9999-12-31T23:59:59-01:00"}`)
+	f.Fuzz(func(t *testing.T, raw string) {
+		roundTrip(t, RollbackAnnotationKey, raw, func(annotations map[string]string) ([]byte, error) {
+			r, err := RollbackFromAnnotations(annotations)
+			if err != nil {
+				return nil, err
+			}
+			r.Frozen(observedAt.Time)
+			return RollbackMergePatch(r)
+		})
+	})
+}
+
+func FuzzSavingsFromAnnotations(f *testing.F) {
+	raw, _ := json.Marshal((*Savings)(nil).Record(2, observedAt.Time))
+	seeds(f, string(raw), `{"deleted":9223372036854775807,"deletedAtSum":-1}`)
+	f.Fuzz(func(t *testing.T, raw string) {
+		roundTrip(t, SavingsAnnotationKey, raw, func(annotations map[string]string) ([]byte, error) {
+			s, err := SavingsFromAnnotations(annotations)
+			if err != nil {
+				return nil, err
+			}
+			s.RevisionDays(observedAt.Time)
+			return SavingsMergePatch(s)
+		})
+	})
+}
+
+func FuzzDeletedFromAnnotations(f *testing.F) {
+	raw, _ := json.Marshal(Deleted(nil).Record([]string{"hello-00001"}, observedAt, 5))
+	seeds(f, string(raw), `[{"name":"hello-00001","deletedAt":"9999-12-31T23:59:59-01:00"}]`)
+	f.Fuzz(func(t *testing.T, raw string) {
+		roundTrip(t, DeletedAnnotationKey, raw, func(annotations map[string]string) ([]byte, error) {
+			d, err := DeletedFromAnnotations(annotations)
+			if err != nil {
+				return nil, err
+			}
+			d.Record([]string{"hello-00002"}, observedAt, 5)
+			return DeletedMergePatch(d)
+		})
+	})
+}
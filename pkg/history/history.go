@@ -0,0 +1,117 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package history holds the deploy history the planner records on a Service.
+// A deploy is a bump of the generation of the Service's Configuration.
+package history
+
+import (
+	"encoding/json"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// AnnotationKey is the Service annotation holding the deploy history.
+const AnnotationKey = "revision-gc.knative.dev/deploy-history"
+
+// Deploy is an observed Configuration generation.
+type Deploy struct {
+	// Generation is the Configuration generation deployed.
+	Generation int64 `json:"generation"`
+
+	// ObservedAt is the time the generation was first observed.
+	ObservedAt metav1.Time `json:"observedAt"`
+}
+
+// History holds the most recent deploys, oldest first.
+type History []Deploy
+
+// FromAnnotations reads the history recorded in the annotations. It returns
+// nil when no history is recorded.
+func FromAnnotations(annotations map[string]string) (History, error) {
+	raw, ok := annotations[AnnotationKey]
+	if !ok {
+		return nil, nil
+	}
+	var h History
+	if err := json.Unmarshal([]byte(raw), &h); err != nil {
+		return nil, fmt.Errorf("invalid %s annotation: %v", AnnotationKey, err)
+	}
+	for _, d := range h {
+		if err := checkTime(AnnotationKey, &d.ObservedAt); err != nil {
+			return nil, err
+		}
+	}
+	return h, nil
+}
+
+// checkTime returns an error for a time that could not be recorded again:
+// metav1.Time encodes in UTC, where only the years 0 to 9999 parse back.
+func checkTime(key string, t *metav1.Time) error {
+	if t == nil {
+		return nil
+	}
+	if year := t.UTC().Year(); year < 0 || year > 9999 {
+		return fmt.Errorf("invalid %s annotation: time %v is out of range", key, t.Time)
+	}
+	return nil
+}
+
+// Record returns the history with the generation appended when it is newer
+// than the last recorded deploy, keeping at most limit deploys. It reports
+// whether the history changed.
+func (h History) Record(generation int64, now metav1.Time, limit int) (History, bool) {
+	out := h
+	changed := false
+	if len(out) == 0 || generation > out[len(out)-1].Generation {
+		out = append(append(History(nil), out...), Deploy{Generation: generation, ObservedAt: now})
+		changed = true
+	}
+	if len(out) > limit {
+		out = append(History(nil), out[len(out)-limit:]...)
+		changed = true
+	}
+	return out, changed
+}
+
+// Generations returns the generations of the last n deploys.
+func (h History) Generations(n int) []int {
+	if n > len(h) {
+		n = len(h)
+	}
+	out := make([]int, 0, n)
+	for _, d := range h[len(h)-n:] {
+		out = append(out, int(d.Generation))
+	}
+	return out
+}
+
+// MergePatch returns the JSON merge patch that records the history on the
+// object.
+func MergePatch(h History) ([]byte, error) {
+	raw, err := json.Marshal(h)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]interface{}{
+				AnnotationKey: string(raw),
+			},
+		},
+	})
+}
@@ -0,0 +1,95 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package history
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// OwnerAnnotationKey is the Service annotation holding the last observed
+// owner of the Service.
+const OwnerAnnotationKey = "revision-gc.knative.dev/owner"
+
+// Owner is the observed owner of a Service and the handoff in progress.
+type Owner struct {
+	// Owner is the value of the owner label or annotation.
+	Owner string `json:"owner"`
+
+	// Previous is the owner before the last handoff.
+	Previous string `json:"previous,omitempty"`
+
+	// HandoffUntil is the end of the last handoff, during which garbage
+	// collection of the Service is suspended.
+	HandoffUntil *metav1.Time `json:"handoffUntil,omitempty"`
+}
+
+// OwnerFromAnnotations reads the owner recorded in the annotations. It
+// returns nil when no owner is recorded.
+func OwnerFromAnnotations(annotations map[string]string) (*Owner, error) {
+	raw, ok := annotations[OwnerAnnotationKey]
+	if !ok {
+		return nil, nil
+	}
+	o := &Owner{}
+	if err := json.Unmarshal([]byte(raw), o); err != nil {
+		return nil, fmt.Errorf("invalid %s annotation: %v", OwnerAnnotationKey, err)
+	}
+	if err := checkTime(OwnerAnnotationKey, o.HandoffUntil); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// Observe returns the record of the current owner, starting a handoff of
+// period when the owner changed from the recorded one. Assigning a first
+// owner is no handoff. It reports whether the record changed and whether a
+// handoff started.
+func (o *Owner) Observe(current string, now time.Time, period time.Duration) (*Owner, bool, bool) {
+	if o == nil || (o.Owner == "" && current != "") {
+		return &Owner{Owner: current}, true, false
+	}
+	if o.Owner == current {
+		return o, false, false
+	}
+	until := metav1.NewTime(now.Add(period))
+	return &Owner{Owner: current, Previous: o.Owner, HandoffUntil: &until}, true, true
+}
+
+// InHandoff reports whether the handoff is still in progress at now.
+func (o *Owner) InHandoff(now time.Time) bool {
+	return o != nil && o.HandoffUntil != nil && now.Before(o.HandoffUntil.Time)
+}
+
+// OwnerMergePatch returns the JSON merge patch that records the owner on the
+// object.
+func OwnerMergePatch(o *Owner) ([]byte, error) {
+	raw, err := json.Marshal(o)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]interface{}{
+				OwnerAnnotationKey: string(raw),
+			},
+		},
+	})
+}
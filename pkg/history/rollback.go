@@ -0,0 +1,101 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package history
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// RollbackAnnotationKey is the Service annotation holding the generation the
+// Route last sent its traffic to and the rollback freeze in progress.
+const RollbackAnnotationKey = "revision-gc.knative.dev/rollback"
+
+// Rollback is the routed generation of a Service and the freeze started by
+// the last rollback.
+type Rollback struct {
+	// Generation is the newest Configuration generation the Route sends
+	// traffic to.
+	Generation int `json:"generation"`
+
+	// From is the generation the last rollback moved away from.
+	From int `json:"from,omitempty"`
+
+	// FrozenUntil is the end of the freeze started by the last rollback,
+	// during which garbage collection of the Service is suspended.
+	FrozenUntil *metav1.Time `json:"frozenUntil,omitempty"`
+}
+
+// RollbackFromAnnotations reads the rollback record in the annotations. It
+// returns nil when none is recorded.
+func RollbackFromAnnotations(annotations map[string]string) (*Rollback, error) {
+	raw, ok := annotations[RollbackAnnotationKey]
+	if !ok {
+		return nil, nil
+	}
+	r := &Rollback{}
+	if err := json.Unmarshal([]byte(raw), r); err != nil {
+		return nil, fmt.Errorf("invalid %s annotation: %v", RollbackAnnotationKey, err)
+	}
+	if err := checkTime(RollbackAnnotationKey, r.FrozenUntil); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Observe returns the record of the currently routed generation, starting a
+// freeze of period when the Route moved back to an older generation than
+// the recorded one. It reports whether the record changed and whether a
+// freeze started.
+func (r *Rollback) Observe(generation int, now time.Time, period time.Duration) (*Rollback, bool, bool) {
+	if r == nil {
+		return &Rollback{Generation: generation}, true, false
+	}
+	if r.Generation == generation {
+		return r, false, false
+	}
+	if generation > r.Generation {
+		// Rolling forward keeps a running freeze.
+		return &Rollback{Generation: generation, From: r.From, FrozenUntil: r.FrozenUntil}, true, false
+	}
+	until := metav1.NewTime(now.Add(period))
+	return &Rollback{Generation: generation, From: r.Generation, FrozenUntil: &until}, true, true
+}
+
+// Frozen reports whether the freeze is still in progress at now.
+func (r *Rollback) Frozen(now time.Time) bool {
+	return r != nil && r.FrozenUntil != nil && now.Before(r.FrozenUntil.Time)
+}
+
+// RollbackMergePatch returns the JSON merge patch that records the rollback
+// record on the object.
+func RollbackMergePatch(r *Rollback) ([]byte, error) {
+	raw, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]interface{}{
+				RollbackAnnotationKey: string(raw),
+			},
+		},
+	})
+}
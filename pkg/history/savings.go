@@ -0,0 +1,92 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package history
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// SavingsAnnotationKey is the Service annotation holding the record of the
+// revisions garbage collection deleted.
+const SavingsAnnotationKey = "revision-gc.knative.dev/savings"
+
+// Savings records the revisions deleted from a Service, which would still
+// exist without garbage collection. The sum of the deletion times is enough
+// to tell the revision-days saved at any time.
+type Savings struct {
+	// Deleted is the number of revisions deleted.
+	Deleted int64 `json:"deleted"`
+
+	// DeletedAtSum is the sum of the deletion times, in Unix seconds.
+	DeletedAtSum int64 `json:"deletedAtSum"`
+}
+
+// SavingsFromAnnotations reads the savings recorded in the annotations. It
+// returns nil when none are recorded.
+func SavingsFromAnnotations(annotations map[string]string) (*Savings, error) {
+	raw, ok := annotations[SavingsAnnotationKey]
+	if !ok {
+		return nil, nil
+	}
+	s := &Savings{}
+	if err := json.Unmarshal([]byte(raw), s); err != nil {
+		return nil, fmt.Errorf("invalid %s annotation: %v", SavingsAnnotationKey, err)
+	}
+	return s, nil
+}
+
+// Record returns the savings with n more revisions deleted at at.
+func (s *Savings) Record(n int, at time.Time) *Savings {
+	out := &Savings{}
+	if s != nil {
+		*out = *s
+	}
+	out.Deleted += int64(n)
+	out.DeletedAtSum += int64(n) * at.Unix()
+	return out
+}
+
+// RevisionDays returns the days the deleted revisions would have existed
+// until now without garbage collection.
+func (s *Savings) RevisionDays(now time.Time) float64 {
+	if s == nil {
+		return 0
+	}
+	seconds := s.Deleted*now.Unix() - s.DeletedAtSum
+	if seconds < 0 {
+		return 0
+	}
+	return float64(seconds) / (24 * time.Hour).Seconds()
+}
+
+// SavingsMergePatch returns the JSON merge patch that records the savings on
+// the object.
+func SavingsMergePatch(s *Savings) ([]byte, error) {
+	raw, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]interface{}{
+				SavingsAnnotationKey: string(raw),
+			},
+		},
+	})
+}
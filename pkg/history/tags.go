@@ -0,0 +1,116 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package history
+
+import (
+	"encoding/json"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// TagsAnnotationKey is the Service annotation holding the traffic tag history.
+const TagsAnnotationKey = "revision-gc.knative.dev/tag-history"
+
+// TagHolder is a revision observed holding a traffic tag.
+type TagHolder struct {
+	// Revision is the name of the revision the tag pointed to.
+	Revision string `json:"revision"`
+
+	// ObservedAt is the time the revision was first observed holding the tag.
+	ObservedAt metav1.Time `json:"observedAt"`
+}
+
+// Tags holds the most recent holders of every traffic tag, oldest first.
+type Tags map[string][]TagHolder
+
+// TagsFromAnnotations reads the tag history recorded in the annotations. It
+// returns nil when no history is recorded.
+func TagsFromAnnotations(annotations map[string]string) (Tags, error) {
+	raw, ok := annotations[TagsAnnotationKey]
+	if !ok {
+		return nil, nil
+	}
+	var t Tags
+	if err := json.Unmarshal([]byte(raw), &t); err != nil {
+		return nil, fmt.Errorf("invalid %s annotation: %v", TagsAnnotationKey, err)
+	}
+	for _, holders := range t {
+		for _, h := range holders {
+			if err := checkTime(TagsAnnotationKey, &h.ObservedAt); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return t, nil
+}
+
+// Record returns the history with the current holder of every tag appended
+// when it changed, keeping at most limits[tag] holders per tag. Tags without
+// a limit are dropped. It reports whether the history changed.
+func (t Tags) Record(current map[string]string, now metav1.Time, limits map[string]int) (Tags, bool) {
+	out := make(Tags, len(limits))
+	changed := false
+	for tag := range t {
+		if _, ok := limits[tag]; !ok {
+			changed = true
+		}
+	}
+	for tag, limit := range limits {
+		holders := t[tag]
+		if revision, ok := current[tag]; ok && (len(holders) == 0 || holders[len(holders)-1].Revision != revision) {
+			holders = append(append([]TagHolder(nil), holders...), TagHolder{Revision: revision, ObservedAt: now})
+			changed = true
+		}
+		if len(holders) > limit {
+			holders = append([]TagHolder(nil), holders[len(holders)-limit:]...)
+			changed = true
+		}
+		if len(holders) > 0 {
+			out[tag] = holders
+		}
+	}
+	return out, changed
+}
+
+// Revisions returns the recorded holders by revision name, with one of the
+// tags each held.
+func (t Tags) Revisions() map[string]string {
+	out := make(map[string]string)
+	for tag, holders := range t {
+		for _, h := range holders {
+			out[h.Revision] = tag
+		}
+	}
+	return out
+}
+
+// TagsMergePatch returns the JSON merge patch that records the tag history on
+// the object.
+func TagsMergePatch(t Tags) ([]byte, error) {
+	raw, err := json.Marshal(t)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]interface{}{
+				TagsAnnotationKey: string(raw),
+			},
+		},
+	})
+}
@@ -0,0 +1,215 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package hooks runs deletion check hooks: executables, configured on the
+// controller, asked whether a deletion candidate is safe to delete, so
+// custom checks are added without recompiling the controller. The contract
+// is JSON over stdin and stdout. For every candidate each hook is run with
+// a Request on stdin, e.g.
+//
+//	{"apiVersion":"revision-gc.knative.dev/v1","kind":"DeletionCheck",
+//	 "namespace":"default","service":"hello","revision":"hello-00001",
+//	 "generation":1,"reason":"Stale","createdAt":"2019-08-01T10:00:00Z",
+//	 "labels":{...},"annotations":{...}}
+//
+// and answers with a Response on stdout, exiting zero:
+//
+//	{"allowed":false,"message":"still referenced by the batch jobs"}
+//
+// A hook that exits non-zero, times out or answers anything else vetoes the
+// deletion, so a broken hook never lets a revision go unchecked.
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// APIVersion is the version of the contract sent in every Request.
+	APIVersion = "revision-gc.knative.dev/v1"
+	// Kind is the kind of every Request.
+	Kind = "DeletionCheck"
+
+	// maxOutput bounds the stdout and stderr read from a hook.
+	maxOutput = 64 << 10
+)
+
+// Request asks a hook whether the revision is safe to delete.
+type Request struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+
+	Namespace string `json:"namespace"`
+	Service   string `json:"service"`
+	Revision  string `json:"revision"`
+
+	// Generation is the Configuration generation of the revision.
+	Generation int `json:"generation"`
+	// Reason is why the policy made the revision a deletion candidate.
+	Reason string `json:"reason"`
+
+	CreatedAt   time.Time         `json:"createdAt"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// Response is the answer of a hook.
+type Response struct {
+	// Allowed is true when the revision may be deleted.
+	Allowed bool `json:"allowed"`
+	// Message tells why the deletion was vetoed.
+	Message string `json:"message,omitempty"`
+}
+
+// Options configures the hooks.
+type Options struct {
+	// Paths are the hook executables, run in order, none when empty.
+	Paths []string
+	// Timeout bounds every run of a hook.
+	Timeout time.Duration
+}
+
+// Checker runs the configured hooks.
+type Checker struct {
+	paths   []string
+	timeout time.Duration
+}
+
+// New returns the Checker of the options, nil when no hook is configured.
+// Every hook must be an executable file.
+func New(options Options) (*Checker, error) {
+	if len(options.Paths) == 0 {
+		return nil, nil
+	}
+	if options.Timeout <= 0 {
+		return nil, fmt.Errorf("deletion check hook timeout must be greater than zero")
+	}
+	for _, path := range options.Paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, fmt.Errorf("deletion check hook %s: %v", path, err)
+		}
+		if info.IsDir() || info.Mode()&0111 == 0 {
+			return nil, fmt.Errorf("deletion check hook %s is not an executable file", path)
+		}
+	}
+	return &Checker{paths: options.Paths, timeout: options.Timeout}, nil
+}
+
+// Check runs the hooks in order until one vetoes the deletion. It returns
+// whether the revision may be deleted and, when not, why, naming the hook.
+func (c *Checker) Check(ctx context.Context, req Request) (bool, string) {
+	if c == nil {
+		return true, ""
+	}
+	req.APIVersion, req.Kind = APIVersion, Kind
+	for _, path := range c.paths {
+		resp, err := c.run(ctx, path, req)
+		name := filepath.Base(path)
+		if err != nil {
+			return false, fmt.Sprintf("deletion check hook %s failed: %v", name, err)
+		}
+		if !resp.Allowed {
+			if resp.Message == "" {
+				return false, fmt.Sprintf("vetoed by deletion check hook %s", name)
+			}
+			return false, fmt.Sprintf("vetoed by deletion check hook %s: %s", name, resp.Message)
+		}
+	}
+	return true, ""
+}
+
+// run runs the hook with the request and decodes its response.
+func (c *Checker) run(ctx context.Context, path string, req Request) (*Response, error) {
+	in, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	stdout, stderr := &limitedBuffer{max: maxOutput}, &limitedBuffer{max: maxOutput}
+	cmd := exec.CommandContext(ctx, path)
+	cmd.Stdin = bytes.NewReader(in)
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, fmt.Errorf("timed out after %s", c.timeout)
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%v: %s", err, msg)
+		}
+		return nil, err
+	}
+	if stdout.truncated {
+		return nil, fmt.Errorf("response larger than %d bytes", maxOutput)
+	}
+	resp := &Response{}
+	if err := json.Unmarshal(stdout.Bytes(), resp); err != nil {
+		return nil, fmt.Errorf("invalid response: %v", err)
+	}
+	return resp, nil
+}
+
+// limitedBuffer keeps the first max bytes written to it. It does not embed
+// the bytes.Buffer, whose ReadFrom io.Copy would use instead of Write.
+type limitedBuffer struct {
+	buf       bytes.Buffer
+	max       int
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.max - b.buf.Len(); len(p) > room {
+		b.truncated = true
+		if room > 0 {
+			b.buf.Write(p[:room])
+		}
+		return len(p), nil
+	}
+	return b.buf.Write(p)
+}
+
+// Bytes returns the bytes kept.
+func (b *limitedBuffer) Bytes() []byte {
+	return b.buf.Bytes()
+}
+
+// String returns the bytes kept as a string.
+func (b *limitedBuffer) String() string {
+	return b.buf.String()
+}
+
+type checkerKey struct{}
+
+// WithChecker attaches the Checker the controllers consult to ctx.
+func WithChecker(ctx context.Context, c *Checker) context.Context {
+	return context.WithValue(ctx, checkerKey{}, c)
+}
+
+// FromContext returns the Checker attached to ctx, nil when none is.
+func FromContext(ctx context.Context) *Checker {
+	c, _ := ctx.Value(checkerKey{}).(*Checker)
+	return c
+}
@@ -0,0 +1,244 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hooks
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestHelperProcess is not a real test: it is the deletion check hook the
+// scripts written by hook run, behaving as HOOK_BEHAVIOR says.
+func TestHelperProcess(t *testing.T) {
+	if os.Getenv("GO_WANT_HELPER_PROCESS") != "1" {
+		return
+	}
+	req := &Request{}
+	if err := json.NewDecoder(os.Stdin).Decode(req); err != nil {
+		fmt.Fprintf(os.Stderr, "decode request: %v", err)
+		os.Exit(3)
+	}
+	if req.APIVersion != APIVersion || req.Kind != Kind || req.Revision == "" {
+		fmt.Fprintf(os.Stderr, "unexpected request %+v", req)
+		os.Exit(3)
+	}
+
+	switch os.Getenv("HOOK_BEHAVIOR") {
+	case "allow":
+		fmt.Print(`{"allowed":true}`)
+	case "deny":
+		fmt.Printf(`{"allowed":false,"message":"%s is still referenced by the batch jobs"}`, req.Revision)
+	case "deny-silently":
+		fmt.Print(`{"allowed":false}`)
+	case "malformed":
+		fmt.Print(`{"allowed":true`)
+	case "empty":
+	case "oversized":
+		fmt.Printf(`{"allowed":true,"message":"%s"}`, strings.Repeat("x", maxOutput))
+	case "fail":
+		fmt.Fprint(os.Stderr, "cannot reach the batch jobs API")
+		os.Exit(2)
+	case "fail-silently":
+		os.Exit(1)
+	case "hang":
+		time.Sleep(time.Minute)
+		fmt.Print(`{"allowed":true}`)
+	default:
+		os.Exit(4)
+	}
+	os.Exit(0)
+}
+
+// hook writes an executable named after the behavior to dir running
+// TestHelperProcess with it, and returns its path.
+func hook(t *testing.T, dir, behavior string) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("deletion check hooks are run as shell scripts")
+	}
+	path := filepath.Join(dir, behavior)
+	script := fmt.Sprintf("#!/bin/sh\nGO_WANT_HELPER_PROCESS=1 HOOK_BEHAVIOR=%s exec %q -test.run='^TestHelperProcess$'\n", behavior, os.Args[0])
+	if err := ioutil.WriteFile(path, []byte(script), 0755); err != nil {
+		t.Fatalf("WriteFile() = %v", err)
+	}
+	return path
+}
+
+func TestCheck(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hooks")
+	if err != nil {
+		t.Fatalf("TempDir() = %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name        string
+		hooks       []string
+		timeout     time.Duration
+		wantAllowed bool
+		wantMessage string
+	}{{
+		name:        "allow",
+		hooks:       []string{"allow"},
+		wantAllowed: true,
+	}, {
+		name:        "deny",
+		hooks:       []string{"deny"},
+		wantMessage: "vetoed by deletion check hook deny: hello-00001 is still referenced by the batch jobs",
+	}, {
+		name:        "deny without message",
+		hooks:       []string{"deny-silently"},
+		wantMessage: "vetoed by deletion check hook deny-silently",
+	}, {
+		name:        "malformed JSON",
+		hooks:       []string{"malformed"},
+		wantMessage: "deletion check hook malformed failed: invalid response: unexpected end of JSON input",
+	}, {
+		name:        "no response",
+		hooks:       []string{"empty"},
+		wantMessage: "deletion check hook empty failed: invalid response: unexpected end of JSON input",
+	}, {
+		name:        "oversized response",
+		hooks:       []string{"oversized"},
+		wantMessage: fmt.Sprintf("deletion check hook oversized failed: response larger than %d bytes", maxOutput),
+	}, {
+		name:        "non-zero exit",
+		hooks:       []string{"fail"},
+		wantMessage: "deletion check hook fail failed: exit status 2: cannot reach the batch jobs API",
+	}, {
+		name:        "non-zero exit without stderr",
+		hooks:       []string{"fail-silently"},
+		wantMessage: "deletion check hook fail-silently failed: exit status 1",
+	}, {
+		name:        "timeout",
+		hooks:       []string{"hang"},
+		timeout:     200 * time.Millisecond,
+		wantMessage: "deletion check hook hang failed: timed out after 200ms",
+	}, {
+		name:        "all allow",
+		hooks:       []string{"allow", "allow"},
+		wantAllowed: true,
+	}, {
+		name:        "later hook denies",
+		hooks:       []string{"allow", "deny"},
+		wantMessage: "vetoed by deletion check hook deny: hello-00001 is still referenced by the batch jobs",
+	}, {
+		name:        "first veto wins",
+		hooks:       []string{"deny-silently", "fail"},
+		wantMessage: "vetoed by deletion check hook deny-silently",
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var paths []string
+			for _, behavior := range test.hooks {
+				paths = append(paths, hook(t, dir, behavior))
+			}
+			timeout := test.timeout
+			if timeout == 0 {
+				timeout = 10 * time.Second
+			}
+			c, err := New(Options{Paths: paths, Timeout: timeout})
+			if err != nil {
+				t.Fatalf("New() = %v", err)
+			}
+
+			allowed, message := c.Check(context.Background(), Request{
+				Namespace:  "default",
+				Service:    "hello",
+				Revision:   "hello-00001",
+				Generation: 1,
+				Reason:     "Stale",
+				CreatedAt:  time.Date(2019, 8, 1, 10, 0, 0, 0, time.UTC),
+			})
+			if allowed != test.wantAllowed || message != test.wantMessage {
+				t.Errorf("Check() = (%v, %q), want (%v, %q)", allowed, message, test.wantAllowed, test.wantMessage)
+			}
+		})
+	}
+}
+
+func TestCheckWithoutHooks(t *testing.T) {
+	c, err := New(Options{})
+	if err != nil {
+		t.Fatalf("New() = %v", err)
+	}
+	if allowed, message := c.Check(context.Background(), Request{Revision: "hello-00001"}); !allowed || message != "" {
+		t.Errorf("Check() = (%v, %q), want (true, \"\")", allowed, message)
+	}
+}
+
+func TestNew(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hooks")
+	if err != nil {
+		t.Fatalf("TempDir() = %v", err)
+	}
+	defer os.RemoveAll(dir)
+	executable := hook(t, dir, "allow")
+	plain := filepath.Join(dir, "plain")
+	if err := ioutil.WriteFile(plain, []byte("{}"), 0644); err != nil {
+		t.Fatalf("WriteFile() = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		options Options
+		wantErr string
+	}{{
+		name:    "executable",
+		options: Options{Paths: []string{executable}, Timeout: time.Second},
+	}, {
+		name:    "no timeout",
+		options: Options{Paths: []string{executable}},
+		wantErr: "timeout must be greater than zero",
+	}, {
+		name:    "missing",
+		options: Options{Paths: []string{executable, filepath.Join(dir, "missing")}, Timeout: time.Second},
+		wantErr: "no such file or directory",
+	}, {
+		name:    "not executable",
+		options: Options{Paths: []string{plain}, Timeout: time.Second},
+		wantErr: "is not an executable file",
+	}, {
+		name:    "directory",
+		options: Options{Paths: []string{dir}, Timeout: time.Second},
+		wantErr: "is not an executable file",
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := New(test.options)
+			if test.wantErr == "" {
+				if err != nil {
+					t.Errorf("New() = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("New() = %v, want error containing %q", err, test.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package ingress verifies that the networking layer, e.g. Kourier, Contour
+// or Istio, no longer routes to a revision before it is deleted. The Route
+// status moves on as soon as the Route is reconciled, while the ingress may
+// still be programming the gateways with its previous state; deleting a
+// revision they still route to answers its requests with 503s.
+package ingress
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/labels"
+	"knative.dev/serving/pkg/apis/networking/v1alpha1"
+	"knative.dev/serving/pkg/apis/serving"
+)
+
+// RouteSelector returns the label selector matching the Ingresses and
+// ClusterIngresses of the Route.
+func RouteSelector(namespace, route string) labels.Selector {
+	return labels.SelectorFromSet(map[string]string{
+		serving.RouteLabelKey:          route,
+		serving.RouteNamespaceLabelKey: namespace,
+	})
+}
+
+// FromClusterIngress returns the Ingress equivalent of a ClusterIngress,
+// which older Knative Serving versions program instead.
+func FromClusterIngress(ci *v1alpha1.ClusterIngress) *v1alpha1.Ingress {
+	return &v1alpha1.Ingress{ObjectMeta: ci.ObjectMeta, Spec: ci.Spec, Status: ci.Status}
+}
+
+// Routing returns why the ingresses may still route to the revision of the
+// namespace, empty when none does: an ingress whose spec sends traffic to
+// it, or that has not programmed its latest spec yet, whose programmed
+// state is unknown.
+func Routing(ingresses []*v1alpha1.Ingress, namespace, revision string) string {
+	for _, ing := range ingresses {
+		if ing.Status.ObservedGeneration != ing.Generation || !ing.Status.IsReady() {
+			return fmt.Sprintf("ingress %s has not programmed generation %d yet", ing.Name, ing.Generation)
+		}
+		if routes(ing, namespace, revision) {
+			return fmt.Sprintf("ingress %s routes to it", ing.Name)
+		}
+	}
+	return ""
+}
+
+// routes reports whether the spec of the ingress sends traffic to the
+// revision, whose Kubernetes Service has its name.
+func routes(ing *v1alpha1.Ingress, namespace, revision string) bool {
+	for _, rule := range ing.Spec.Rules {
+		if rule.HTTP == nil {
+			continue
+		}
+		for _, path := range rule.HTTP.Paths {
+			for _, split := range path.Splits {
+				if split.ServiceNamespace == namespace && split.ServiceName == revision {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,185 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ingress
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"knative.dev/serving/pkg/apis/networking/v1alpha1"
+	"knative.dev/serving/pkg/apis/serving"
+)
+
+// split returns a traffic split to the revision of the namespace.
+func split(namespace, revision string, percent int) v1alpha1.IngressBackendSplit {
+	return v1alpha1.IngressBackendSplit{
+		IngressBackend: v1alpha1.IngressBackend{ServiceNamespace: namespace, ServiceName: revision},
+		Percent:        percent,
+	}
+}
+
+// programmed returns a ready Ingress that observed its generation, whose
+// single path splits the traffic as given.
+func programmed(name string, splits ...v1alpha1.IngressBackendSplit) *v1alpha1.Ingress {
+	ing := &v1alpha1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Generation: 2},
+		Spec: v1alpha1.IngressSpec{
+			Rules: []v1alpha1.IngressRule{{
+				Hosts: []string{"hello.default.example.com"},
+				HTTP: &v1alpha1.HTTPIngressRuleValue{
+					Paths: []v1alpha1.HTTPIngressPath{{Splits: splits}},
+				},
+			}},
+		},
+	}
+	ing.Status.InitializeConditions()
+	ing.Status.MarkNetworkConfigured()
+	ing.Status.MarkLoadBalancerReady(nil, nil, nil)
+	ing.Status.ObservedGeneration = 2
+	return ing
+}
+
+func TestRouting(t *testing.T) {
+	tests := []struct {
+		name      string
+		ingresses func() []*v1alpha1.Ingress
+		want      string
+	}{{
+		name:      "no ingresses",
+		ingresses: func() []*v1alpha1.Ingress { return nil },
+	}, {
+		name: "routes to other revisions",
+		ingresses: func() []*v1alpha1.Ingress {
+			return []*v1alpha1.Ingress{programmed("hello", split("default", "hello-00003", 80), split("default", "hello-00002", 20))}
+		},
+	}, {
+		name: "routes to the revision",
+		ingresses: func() []*v1alpha1.Ingress {
+			return []*v1alpha1.Ingress{programmed("hello", split("default", "hello-00003", 90), split("default", "hello-00001", 10))}
+		},
+		want: "ingress hello routes to it",
+	}, {
+		name: "routes to a revision of the name in another namespace",
+		ingresses: func() []*v1alpha1.Ingress {
+			return []*v1alpha1.Ingress{programmed("hello", split("other", "hello-00001", 100))}
+		},
+	}, {
+		name: "routes to the revision from a later path",
+		ingresses: func() []*v1alpha1.Ingress {
+			ing := programmed("hello", split("default", "hello-00003", 100))
+			ing.Spec.Rules[0].HTTP.Paths = append(ing.Spec.Rules[0].HTTP.Paths,
+				v1alpha1.HTTPIngressPath{Path: "/v1", Splits: []v1alpha1.IngressBackendSplit{split("default", "hello-00001", 100)}})
+			return []*v1alpha1.Ingress{ing}
+		},
+		want: "ingress hello routes to it",
+	}, {
+		name: "routes to the revision from a later rule",
+		ingresses: func() []*v1alpha1.Ingress {
+			ing := programmed("hello", split("default", "hello-00003", 100))
+			ing.Spec.Rules = append([]v1alpha1.IngressRule{{Hosts: []string{"hello.default.svc.cluster.local"}}}, ing.Spec.Rules...)
+			ing.Spec.Rules = append(ing.Spec.Rules, v1alpha1.IngressRule{
+				Hosts: []string{"hello.default"},
+				HTTP: &v1alpha1.HTTPIngressRuleValue{
+					Paths: []v1alpha1.HTTPIngressPath{{Splits: []v1alpha1.IngressBackendSplit{split("default", "hello-00001", 100)}}},
+				},
+			})
+			return []*v1alpha1.Ingress{ing}
+		},
+		want: "ingress hello routes to it",
+	}, {
+		name: "routes to the revision from another ingress",
+		ingresses: func() []*v1alpha1.Ingress {
+			return []*v1alpha1.Ingress{
+				programmed("hello", split("default", "hello-00003", 100)),
+				programmed("hello-tag", split("default", "hello-00001", 100)),
+			}
+		},
+		want: "ingress hello-tag routes to it",
+	}, {
+		name: "not ready",
+		ingresses: func() []*v1alpha1.Ingress {
+			ing := programmed("hello", split("default", "hello-00003", 100))
+			ing.Status.MarkLoadBalancerPending()
+			return []*v1alpha1.Ingress{ing}
+		},
+		want: "ingress hello has not programmed generation 2 yet",
+	}, {
+		name: "no conditions",
+		ingresses: func() []*v1alpha1.Ingress {
+			ing := programmed("hello", split("default", "hello-00003", 100))
+			ing.Status.Conditions = nil
+			return []*v1alpha1.Ingress{ing}
+		},
+		want: "ingress hello has not programmed generation 2 yet",
+	}, {
+		name: "latest generation not observed",
+		ingresses: func() []*v1alpha1.Ingress {
+			ing := programmed("hello", split("default", "hello-00003", 100))
+			ing.Generation = 3
+			return []*v1alpha1.Ingress{ing}
+		},
+		want: "ingress hello has not programmed generation 3 yet",
+	}, {
+		name: "another ingress has not observed its latest generation",
+		ingresses: func() []*v1alpha1.Ingress {
+			ing := programmed("hello-tag", split("default", "hello-00003", 100))
+			ing.Generation = 3
+			return []*v1alpha1.Ingress{programmed("hello", split("default", "hello-00003", 100)), ing}
+		},
+		want: "ingress hello-tag has not programmed generation 3 yet",
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := Routing(test.ingresses(), "default", "hello-00001"); got != test.want {
+				t.Errorf("Routing() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestRouteSelector(t *testing.T) {
+	selector := RouteSelector("default", "hello")
+	for _, test := range []struct {
+		labels map[string]string
+		want   bool
+	}{{
+		labels: map[string]string{serving.RouteLabelKey: "hello", serving.RouteNamespaceLabelKey: "default"},
+		want:   true,
+	}, {
+		labels: map[string]string{serving.RouteLabelKey: "hello", serving.RouteNamespaceLabelKey: "other"},
+	}, {
+		labels: map[string]string{serving.RouteLabelKey: "world", serving.RouteNamespaceLabelKey: "default"},
+	}, {
+		labels: map[string]string{serving.RouteLabelKey: "hello"},
+	}} {
+		if got := selector.Matches(labels.Set(test.labels)); got != test.want {
+			t.Errorf("RouteSelector().Matches(%v) = %v, want %v", test.labels, got, test.want)
+		}
+	}
+}
+
+func TestFromClusterIngress(t *testing.T) {
+	ing := programmed("hello", split("default", "hello-00001", 100))
+	ci := &v1alpha1.ClusterIngress{ObjectMeta: ing.ObjectMeta, Spec: ing.Spec, Status: ing.Status}
+
+	if got := FromClusterIngress(ci); !equality.Semantic.DeepEqual(got, ing) {
+		t.Errorf("FromClusterIngress() = %+v, want %+v", got, ing)
+	}
+}
@@ -0,0 +1,109 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package invalidation asks an external hook to invalidate the caches in
+// front of a revision, e.g. a CDN serving its revision-specific hostnames,
+// and holds the deletion of the revision until the hook confirms it.
+//
+// The hook is POSTed a Request for every revision about to be deleted. It
+// answers 200 OK once the caches are invalidated and 202 Accepted while the
+// invalidation is in progress; the request is repeated until it is confirmed,
+// so the hook must be idempotent. Any other answer holds the deletion too.
+package invalidation
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/knative-sample/revision-controller/pkg/config"
+	"knative.dev/serving/pkg/apis/serving/v1alpha1"
+)
+
+var defaultClient = &http.Client{Timeout: 10 * time.Second}
+
+// Request is the invalidation request posted for a revision.
+type Request struct {
+	Namespace string `json:"namespace"`
+	Service   string `json:"service"`
+	Revision  string `json:"revision"`
+
+	// URLs are the revision-specific URLs the Route reports for the
+	// revision, e.g. of its traffic tags.
+	URLs []string `json:"urls,omitempty"`
+}
+
+// Hook is the invalidation hook.
+type Hook struct {
+	// URL is the endpoint the requests are posted to.
+	URL string
+
+	Client *http.Client
+}
+
+// FromConfig returns the hook configured in cfg, or nil when no hook URL is
+// configured.
+func FromConfig(cfg *config.GC) *Hook {
+	if cfg.InvalidationHookURL == "" {
+		return nil
+	}
+	return &Hook{URL: cfg.InvalidationHookURL, Client: defaultClient}
+}
+
+// Invalidate posts the request and reports whether the hook confirmed the
+// invalidation.
+func (h *Hook) Invalidate(ctx context.Context, r Request) (bool, error) {
+	raw, err := json.Marshal(r)
+	if err != nil {
+		return false, err
+	}
+	req, err := http.NewRequest(http.MethodPost, h.URL, bytes.NewReader(raw))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := h.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusAccepted:
+		return false, nil
+	default:
+		return false, fmt.Errorf("unexpected status %s from %s", resp.Status, h.URL)
+	}
+}
+
+// RevisionURLs returns the URLs the Route reports for the revision.
+func RevisionURLs(route *v1alpha1.Route, revision string) []string {
+	if route == nil {
+		return nil
+	}
+	var urls []string
+	for _, t := range route.Status.Traffic {
+		if t.RevisionName == revision && t.URL != nil {
+			urls = append(urls, t.URL.String())
+		}
+	}
+	return urls
+}
@@ -0,0 +1,179 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package inventory exports the revisions the garbage collector keeps, so
+// spend and compliance reports can be reconciled against them without
+// listing the API. The inventory is served as JSON or in the Prometheus
+// text format, e.g. for the textfile collector of the node exporter.
+package inventory
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/knative-sample/revision-controller/pkg/strategy"
+	"go.uber.org/zap"
+)
+
+// Format is the encoding of an inventory.
+type Format string
+
+const (
+	// FormatJSON encodes the inventory as a JSON document.
+	FormatJSON Format = "json"
+	// FormatPrometheus encodes the inventory in the Prometheus text format.
+	FormatPrometheus Format = "prometheus"
+)
+
+// ParseFormat parses a Format, JSON when empty.
+func ParseFormat(raw string) (Format, error) {
+	switch f := Format(raw); f {
+	case "":
+		return FormatJSON, nil
+	case FormatJSON, FormatPrometheus:
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown inventory format %q, expected %s or %s", raw, FormatJSON, FormatPrometheus)
+}
+
+// FormatOf returns the format of an inventory file by its extension,
+// Prometheus for .prom files and JSON otherwise.
+func FormatOf(path string) Format {
+	if filepath.Ext(path) == ".prom" {
+		return FormatPrometheus
+	}
+	return FormatJSON
+}
+
+// Entry is a retained revision.
+type Entry struct {
+	Namespace string `json:"namespace"`
+	Service   string `json:"service"`
+	// Team is the team owning the Service, see the owner-label setting.
+	Team     string `json:"team,omitempty"`
+	Revision string `json:"revision"`
+	// Age is the age of the revision in seconds.
+	Age float64 `json:"ageSeconds"`
+	// Routed is set on the revision the Route sends its traffic to.
+	Routed bool `json:"routed"`
+	// Protected is set on revisions kept by a rule regardless of the retain
+	// count and age, e.g. a lease, named by Reason.
+	Protected bool `json:"protected"`
+	// Reason is why the revision is kept.
+	Reason string `json:"reason"`
+}
+
+// Inventory holds the retained revisions of all Services.
+type Inventory struct {
+	GeneratedAt time.Time `json:"generatedAt"`
+	Entries     []Entry   `json:"entries"`
+}
+
+// unprotected are the reasons revisions are kept for by the retention
+// itself rather than by a protection.
+var unprotected = map[strategy.Reason]bool{
+	strategy.ReasonRouted:            true,
+	strategy.ReasonNotStale:          true,
+	strategy.ReasonInvalidGeneration: true,
+	strategy.ReasonRetainCount:       true,
+	strategy.ReasonTooYoung:          true,
+}
+
+// Add adds the retained revisions of a Service owned by team.
+func (inv *Inventory) Add(namespace, service, team string, result *strategy.Result) {
+	for _, d := range result.Retained {
+		inv.Entries = append(inv.Entries, Entry{
+			Namespace: namespace,
+			Service:   service,
+			Team:      team,
+			Revision:  d.Revision.Name,
+			Age:       inv.GeneratedAt.Sub(strategy.CreatedAt(d.Revision)).Seconds(),
+			Routed:    d.Revision.Name == result.RoutedRevision,
+			Protected: !result.Skipped() && !unprotected[d.Reason],
+			Reason:    string(d.Reason),
+		})
+	}
+}
+
+// Write encodes the inventory to w in the format.
+func (inv *Inventory) Write(w io.Writer, format Format) error {
+	if format == FormatPrometheus {
+		return inv.writePrometheus(w)
+	}
+	return json.NewEncoder(w).Encode(inv)
+}
+
+func (inv *Inventory) writePrometheus(w io.Writer) error {
+	var b strings.Builder
+	b.WriteString("# HELP revision_gc_inventory_revision_age_seconds The age of the revisions the garbage collector retains.\n")
+	b.WriteString("# TYPE revision_gc_inventory_revision_age_seconds gauge\n")
+	for _, e := range inv.Entries {
+		fmt.Fprintf(&b, "revision_gc_inventory_revision_age_seconds{namespace=%q,service=%q,team=%q,revision=%q,routed=\"%t\",protected=\"%t\",reason=%q} %g\n",
+			e.Namespace, e.Service, e.Team, e.Revision, e.Routed, e.Protected, e.Reason, e.Age)
+	}
+	b.WriteString("# HELP revision_gc_inventory_generated_timestamp_seconds When the inventory was generated.\n")
+	b.WriteString("# TYPE revision_gc_inventory_generated_timestamp_seconds gauge\n")
+	fmt.Fprintf(&b, "revision_gc_inventory_generated_timestamp_seconds %d\n", inv.GeneratedAt.Unix())
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteFile replaces the file at path with the inventory, in the format of
+// its extension. The file is renamed into place, so readers never see a
+// partial inventory.
+func (inv *Inventory) WriteFile(path string) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := inv.Write(tmp, FormatOf(path)); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Dump writes the inventory of source to path every interval until stopCh
+// is closed.
+func Dump(stopCh <-chan struct{}, logger *zap.SugaredLogger, path string, interval time.Duration, source func() (*Inventory, error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if inv, err := source(); err != nil {
+			logger.Errorf("inventory: build error:%s", err.Error())
+		} else if err := inv.WriteFile(path); err != nil {
+			logger.Errorf("inventory: write %s error:%s", path, err.Error())
+		}
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,110 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package listener configures where and with which certificate the embedded
+// servers of the controller listen, so they fit the network policies of the
+// cluster: IPv4, IPv6 or dual-stack addresses, unix sockets, and serving
+// certificates from files or Secrets.
+package listener
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// unixPrefix marks a unix socket address.
+const unixPrefix = "unix://"
+
+// Options configures an embedded TLS server.
+type Options struct {
+	// Address is a "host:port" address such as ":8443" (all interfaces,
+	// dual-stack), "0.0.0.0:8443", "[::]:8443" or "[fd00::1]:8443", or a
+	// unix socket such as "unix:///var/run/revision-gc/api.sock".
+	Address string
+
+	// CertFile and KeyFile hold the serving certificate.
+	CertFile string
+	KeyFile  string
+
+	// TLSSecret references a kubernetes.io/tls Secret holding the serving
+	// certificate, as "name" in the system namespace or "namespace/name".
+	TLSSecret string
+}
+
+// Listen listens on the address of the options.
+func (o Options) Listen() (net.Listener, error) {
+	return Listen(o.Address)
+}
+
+// Listen listens on a "host:port" address or a "unix://" socket path. A
+// stale socket file left behind by a previous process is removed.
+func Listen(address string) (net.Listener, error) {
+	if strings.HasPrefix(address, unixPrefix) {
+		path := strings.TrimPrefix(address, unixPrefix)
+		if path == "" {
+			return nil, fmt.Errorf("invalid address %q: missing socket path", address)
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("remove stale socket %s: %v", path, err)
+		}
+		return net.Listen("unix", path)
+	}
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		return nil, fmt.Errorf("invalid address %q, expected host:port, [ipv6]:port or unix:///path: %v", address, err)
+	}
+	return net.Listen("tcp", address)
+}
+
+// Certificate returns the configured serving certificate together with the
+// CA bundle of a Secret ("ca.crt"), or nil when no certificate is configured.
+func (o Options) Certificate(kubeClient kubernetes.Interface, systemNamespace string) (*tls.Certificate, []byte, error) {
+	switch {
+	case o.TLSSecret != "" && (o.CertFile != "" || o.KeyFile != ""):
+		return nil, nil, fmt.Errorf("a TLS Secret and certificate files are mutually exclusive")
+	case o.TLSSecret != "":
+		return certificateFromSecret(kubeClient, o.TLSSecret, systemNamespace)
+	case o.CertFile != "" || o.KeyFile != "":
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("load certificate %s: %v", o.CertFile, err)
+		}
+		return &cert, nil, nil
+	}
+	return nil, nil, nil
+}
+
+func certificateFromSecret(kubeClient kubernetes.Interface, ref, systemNamespace string) (*tls.Certificate, []byte, error) {
+	namespace, name := systemNamespace, ref
+	if i := strings.Index(ref, "/"); i >= 0 {
+		namespace, name = ref[:i], ref[i+1:]
+	}
+	secret, err := kubeClient.CoreV1().Secrets(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return nil, nil, fmt.Errorf("read TLS Secret %s/%s: %v", namespace, name, err)
+	}
+	cert, err := tls.X509KeyPair(secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey])
+	if err != nil {
+		return nil, nil, fmt.Errorf("TLS Secret %s/%s: %v", namespace, name, err)
+	}
+	return &cert, secret.Data["ca.crt"], nil
+}
@@ -0,0 +1,140 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package notifier posts summaries of garbage collection activity to
+// pluggable sinks such as generic webhooks, Slack or PagerDuty.
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Kind is the kind of garbage collection activity a notification is about.
+type Kind string
+
+const (
+	// KindDeleted is sent after revisions were deleted.
+	KindDeleted Kind = "Deleted"
+	// KindLargePlan is sent when a plan exceeds the configured size threshold.
+	KindLargePlan Kind = "LargePlan"
+	// KindRepeatedFailure is sent when garbage collection keeps failing for a Service.
+	KindRepeatedFailure Kind = "RepeatedFailure"
+	// KindCandidatesExceeded is sent when a reconcile finds more candidates
+	// than the policy deletes without attention.
+	KindCandidatesExceeded Kind = "CandidatesExceeded"
+	// KindOwnershipHandoff is sent when the owner of a Service changed.
+	KindOwnershipHandoff Kind = "OwnershipHandoff"
+	// KindSteadyStateMismatch is sent when the revisions left after a
+	// deletion differ from the expected ones.
+	KindSteadyStateMismatch Kind = "SteadyStateMismatch"
+	// KindDeletionStalled is sent when deleted revisions remain past the
+	// stalled deletion timeout, e.g. held by a finalizer.
+	KindDeletionStalled Kind = "DeletionStalled"
+)
+
+// Notification is a summary of garbage collection activity for a Service.
+type Notification struct {
+	Kind      Kind      `json:"kind"`
+	Namespace string    `json:"namespace"`
+	Service   string    `json:"service"`
+	Revisions []string  `json:"revisions,omitempty"`
+	Owners    []string  `json:"owners,omitempty"`
+	Message   string    `json:"message"`
+	Time      time.Time `json:"time"`
+	// DecisionID identifies the reconcile that sent the notification.
+	DecisionID string `json:"decisionID,omitempty"`
+}
+
+// Summary returns a single line human readable summary.
+func (n *Notification) Summary() string {
+	summary := fmt.Sprintf("[revision-gc] %s %s/%s: %s", n.Kind, n.Namespace, n.Service, n.Message)
+	if len(n.Revisions) > 0 {
+		summary += " (" + strings.Join(n.Revisions, ", ") + ")"
+	}
+	return summary
+}
+
+// Sink delivers notifications to an external system.
+type Sink interface {
+	// Name identifies the sink in logs.
+	Name() string
+
+	// Send delivers the notification.
+	Send(ctx context.Context, n *Notification) error
+}
+
+// Notifier fans notifications out to all of its sinks.
+type Notifier struct {
+	sinks   []Sink
+	timeout time.Duration
+	logger  *zap.SugaredLogger
+}
+
+// New creates a Notifier delivering to the sinks.
+func New(logger *zap.SugaredLogger, sinks ...Sink) *Notifier {
+	return &Notifier{
+		sinks:   sinks,
+		timeout: 10 * time.Second,
+		logger:  logger,
+	}
+}
+
+// Notify delivers the notification to every sink in the background, so a
+// slow sink never blocks a reconcile. Delivery failures are only logged.
+func (n *Notifier) Notify(notification *Notification) {
+	if notification.Time.IsZero() {
+		notification.Time = time.Now()
+	}
+	for _, sink := range n.sinks {
+		go func(sink Sink) {
+			ctx, cancel := context.WithTimeout(context.Background(), n.timeout)
+			defer cancel()
+			if err := sink.Send(ctx, notification); err != nil {
+				n.logger.Errorf("notifier sink %s send %s notification error: %s", sink.Name(), notification.Kind, err.Error())
+			}
+		}(sink)
+	}
+}
+
+// postJSON posts the body as JSON to the url and checks for a 2xx response.
+func postJSON(ctx context.Context, client *http.Client, url string, body interface{}) error {
+	raw, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(raw))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s from %s", resp.Status, url)
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package notifier
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/knative-sample/revision-controller/pkg/config"
+)
+
+// PagerDutyEventsURL is the PagerDuty Events API v2 endpoint.
+const PagerDutyEventsURL = "https://events.pagerduty.com/v2/enqueue"
+
+// WebhookSink posts the notification as JSON to a generic webhook.
+type WebhookSink struct {
+	URL    string
+	Client *http.Client
+}
+
+var _ Sink = (*WebhookSink)(nil)
+
+// Name implements Sink.
+func (s *WebhookSink) Name() string {
+	return "webhook"
+}
+
+// Send implements Sink.
+func (s *WebhookSink) Send(ctx context.Context, n *Notification) error {
+	return postJSON(ctx, s.Client, s.URL, n)
+}
+
+// SlackSink posts the notification summary to a Slack incoming webhook.
+type SlackSink struct {
+	WebhookURL string
+	Client     *http.Client
+}
+
+var _ Sink = (*SlackSink)(nil)
+
+// Name implements Sink.
+func (s *SlackSink) Name() string {
+	return "slack"
+}
+
+// Send implements Sink.
+func (s *SlackSink) Send(ctx context.Context, n *Notification) error {
+	return postJSON(ctx, s.Client, s.WebhookURL, map[string]string{
+		"text": n.Summary(),
+	})
+}
+
+// PagerDutySink triggers a PagerDuty event through the Events API v2. Only
+// repeated failures are sent, deletions and large plans are not actionable
+// enough to page anyone.
+type PagerDutySink struct {
+	RoutingKey string
+	URL        string
+	Client     *http.Client
+}
+
+var _ Sink = (*PagerDutySink)(nil)
+
+// Name implements Sink.
+func (s *PagerDutySink) Name() string {
+	return "pagerduty"
+}
+
+// Send implements Sink.
+func (s *PagerDutySink) Send(ctx context.Context, n *Notification) error {
+	if n.Kind != KindRepeatedFailure {
+		return nil
+	}
+	url := s.URL
+	if url == "" {
+		url = PagerDutyEventsURL
+	}
+	return postJSON(ctx, s.Client, url, map[string]interface{}{
+		"routing_key":  s.RoutingKey,
+		"event_action": "trigger",
+		"dedup_key":    "revision-gc/" + n.Namespace + "/" + n.Service,
+		"payload": map[string]interface{}{
+			"summary":        n.Summary(),
+			"source":         n.Namespace + "/" + n.Service,
+			"severity":       "warning",
+			"component":      "revision-controller",
+			"custom_details": n,
+		},
+	})
+}
+
+// defaultClient is used by the sinks created from configuration.
+var defaultClient = &http.Client{Timeout: 10 * time.Second}
+
+// SinksFromConfig returns the sinks enabled in the configuration.
+func SinksFromConfig(cfg *config.Notifications) []Sink {
+	var sinks []Sink
+	if cfg.WebhookURL != "" {
+		sinks = append(sinks, &WebhookSink{URL: cfg.WebhookURL, Client: defaultClient})
+	}
+	if cfg.SlackWebhookURL != "" {
+		sinks = append(sinks, &SlackSink{WebhookURL: cfg.SlackWebhookURL, Client: defaultClient})
+	}
+	if cfg.PagerDutyRoutingKey != "" {
+		sinks = append(sinks, &PagerDutySink{RoutingKey: cfg.PagerDutyRoutingKey, Client: defaultClient})
+	}
+	return sinks
+}
@@ -0,0 +1,85 @@
+//go:build go1.18
+// +build go1.18
+
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plan
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// recorded returns the plan annotation the merge patch records.
+func recorded(t *testing.T, patch []byte) string {
+	t.Helper()
+	var p struct {
+		Metadata struct {
+			Annotations map[string]*string `json:"annotations"`
+		} `json:"metadata"`
+	}
+	if err := json.Unmarshal(patch, &p); err != nil {
+		t.Fatalf("invalid merge patch %s: %v", patch, err)
+	}
+	value := p.Metadata.Annotations[AnnotationKey]
+	if value == nil {
+		t.Fatalf("merge patch %s does not record the plan", patch)
+	}
+	return *value
+}
+
+func FuzzFromAnnotations(f *testing.F) {
+	raw, _ := json.Marshal(New("default", []string{"hello-00002", "hello-00001"}, created))
+	for _, seed := range []string{
+		string(raw),
+		`{"policy":"default","revisions":null,"createdAt":"9999-12-31T23:59:59-01:00"}`,
+		`{"policy":"default","revisions":["b","a"],"createdAt":null,"decisionID":"x"}`,
+		"", "null", "{}", "[]",
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, raw string) {
+		p, err := FromAnnotations(map[string]string{AnnotationKey: raw})
+		if err != nil {
+			return
+		}
+		p.Contains("hello-00001")
+		patch, err := MergePatch(p)
+		if err != nil {
+			t.Fatalf("MergePatch() = %v", err)
+		}
+		value := recorded(t, patch)
+		again, err := FromAnnotations(map[string]string{AnnotationKey: value})
+		if err != nil {
+			t.Fatalf("recorded plan %q does not read back: %v", value, err)
+		}
+		if again.Hash() != p.Hash() {
+			t.Errorf("Hash() = %s after recording %q, want %s", again.Hash(), value, p.Hash())
+		}
+		patch, err = MergePatch(again)
+		if err != nil {
+			t.Fatalf("MergePatch() = %v", err)
+		}
+		if got := recorded(t, patch); got != value {
+			t.Errorf("recorded plan %q, then %q", value, got)
+		}
+		if !reflect.DeepEqual(again.Revisions, p.Revisions) {
+			t.Errorf("Revisions = %v after recording, want %v", again.Revisions, p.Revisions)
+		}
+	})
+}
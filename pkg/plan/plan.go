@@ -0,0 +1,186 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package plan holds the deletion plan the planner records on a Service and
+// the executor carries out.
+package plan
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// AnnotationKey is the Service annotation holding the recorded plan.
+	AnnotationKey = "revision-gc.knative.dev/plan"
+
+	// ApprovedByAnnotationKey is the Service annotation a human sets to
+	// approve the recorded plan when approval is required. It is removed
+	// whenever the plan is replaced or removed.
+	ApprovedByAnnotationKey = "revision-gc.knative.dev/approved-by"
+
+	// ApprovedPlanAnnotationKey is the Service annotation holding the hash
+	// of the plan the approval was given for. An approval only counts for
+	// the plan with that hash.
+	ApprovedPlanAnnotationKey = "revision-gc.knative.dev/approved-plan"
+
+	// EstimateAnnotationKey is the GC ConfigMap annotation the webhook
+	// records the impact estimate of the configuration in.
+	EstimateAnnotationKey = "revision-gc.knative.dev/impact-estimate"
+)
+
+// Plan is the set of revisions of a Service that are due for deletion.
+type Plan struct {
+	// Policy is the name of the policy that produced the plan.
+	Policy string `json:"policy"`
+
+	// Revisions are the names of the revisions to delete.
+	Revisions []string `json:"revisions"`
+
+	// CreatedAt is the time the plan was recorded.
+	CreatedAt metav1.Time `json:"createdAt"`
+
+	// DecisionID identifies the reconcile that produced the plan.
+	DecisionID string `json:"decisionID,omitempty"`
+}
+
+// Estimate is the impact of a GC configuration on the existing revisions.
+type Estimate struct {
+	// Candidates is the number of revisions the configuration deletes.
+	Candidates int `json:"candidates"`
+
+	// Services is the number of Services losing revisions.
+	Services int `json:"services"`
+
+	// Current is the number of revisions the configuration in effect deletes.
+	Current int `json:"current"`
+
+	// EstimatedAt is the time the estimate was made.
+	EstimatedAt metav1.Time `json:"estimatedAt"`
+}
+
+// New returns a plan for the revisions, sorted by name.
+func New(policy string, revisions []string, now metav1.Time) *Plan {
+	sorted := append([]string(nil), revisions...)
+	sort.Strings(sorted)
+	return &Plan{
+		Policy:    policy,
+		Revisions: sorted,
+		CreatedAt: now,
+	}
+}
+
+// FromAnnotations reads the plan recorded in the annotations. It returns nil
+// when no plan is recorded.
+func FromAnnotations(annotations map[string]string) (*Plan, error) {
+	raw, ok := annotations[AnnotationKey]
+	if !ok {
+		return nil, nil
+	}
+	p := &Plan{}
+	if err := json.Unmarshal([]byte(raw), p); err != nil {
+		return nil, fmt.Errorf("invalid %s annotation: %v", AnnotationKey, err)
+	}
+	// metav1.Time encodes in UTC, where only the years 0 to 9999 parse back.
+	if year := p.CreatedAt.UTC().Year(); year < 0 || year > 9999 {
+		return nil, fmt.Errorf("invalid %s annotation: creation time %v is out of range", AnnotationKey, p.CreatedAt.Time)
+	}
+	return p, nil
+}
+
+// SameRevisions reports whether both plans delete the same revisions.
+func (p *Plan) SameRevisions(other *Plan) bool {
+	if p == nil || other == nil {
+		return p == other
+	}
+	if p.Policy != other.Policy || len(p.Revisions) != len(other.Revisions) {
+		return false
+	}
+	for i := range p.Revisions {
+		if p.Revisions[i] != other.Revisions[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Contains reports whether the plan deletes the named revision.
+func (p *Plan) Contains(name string) bool {
+	i := sort.SearchStrings(p.Revisions, name)
+	return i < len(p.Revisions) && p.Revisions[i] == name
+}
+
+// Hash identifies the content of the plan: its policy, revisions and
+// creation time. An approval names the hash of the plan it approves.
+func (p *Plan) Hash() string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n", p.Policy, p.CreatedAt.UTC().Format(time.RFC3339))
+	for _, name := range p.Revisions {
+		fmt.Fprintf(h, "%s\n", name)
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// ApprovedBy returns who approved the plan, as recorded in the annotations.
+// It returns "" when there is no approval or the approval names the hash of
+// another plan.
+func (p *Plan) ApprovedBy(annotations map[string]string) string {
+	approver := annotations[ApprovedByAnnotationKey]
+	if approver == "" || annotations[ApprovedPlanAnnotationKey] != p.Hash() {
+		return ""
+	}
+	return approver
+}
+
+// ApprovalMismatch reports whether the annotations hold an approval given
+// for another plan.
+func (p *Plan) ApprovalMismatch(annotations map[string]string) bool {
+	return annotations[ApprovedByAnnotationKey] != "" && p.ApprovedBy(annotations) == ""
+}
+
+// Expired reports whether the plan is older than expiry.
+func (p *Plan) Expired(expiry time.Duration, now time.Time) bool {
+	return expiry > 0 && now.Sub(p.CreatedAt.Time) > expiry
+}
+
+// MergePatch returns the JSON merge patch that records the plan on the object,
+// or removes the recorded plan when p is nil. Any approval is removed, since
+// it was given for the previous plan.
+func MergePatch(p *Plan) ([]byte, error) {
+	var value interface{}
+	if p != nil {
+		raw, err := json.Marshal(p)
+		if err != nil {
+			return nil, err
+		}
+		value = string(raw)
+	}
+	return json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]interface{}{
+				AnnotationKey:             value,
+				ApprovedByAnnotationKey:   nil,
+				ApprovedPlanAnnotationKey: nil,
+			},
+		},
+	})
+}
@@ -0,0 +1,142 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plan
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var created = metav1.NewTime(time.Date(2019, 8, 1, 12, 0, 0, 0, time.UTC))
+
+func TestHash(t *testing.T) {
+	p := New("max-stale", []string{"hello-00002", "hello-00001"}, created)
+
+	tests := []struct {
+		name  string
+		other *Plan
+		same  bool
+	}{{
+		name:  "same plan",
+		other: New("max-stale", []string{"hello-00001", "hello-00002"}, created),
+		same:  true,
+	}, {
+		name:  "other revisions",
+		other: New("max-stale", []string{"hello-00001", "hello-00003"}, created),
+	}, {
+		name:  "more revisions",
+		other: New("max-stale", []string{"hello-00001", "hello-00002", "hello-00003"}, created),
+	}, {
+		name:  "other policy",
+		other: New("max-count", []string{"hello-00001", "hello-00002"}, created),
+	}, {
+		name:  "recomputed later",
+		other: New("max-stale", []string{"hello-00001", "hello-00002"}, metav1.NewTime(created.Add(time.Minute))),
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := p.Hash() == test.other.Hash(); got != test.same {
+				t.Errorf("Hash() equal = %v, want %v", got, test.same)
+			}
+		})
+	}
+}
+
+func TestHashSurvivesAnnotation(t *testing.T) {
+	p := New("max-stale", []string{"hello-00001"}, metav1.NewTime(created.Add(123*time.Millisecond)))
+	raw, err := json.Marshal(p)
+	if err != nil {
+		t.Fatalf("Marshal() = %v", err)
+	}
+	read, err := FromAnnotations(map[string]string{AnnotationKey: string(raw)})
+	if err != nil {
+		t.Fatalf("FromAnnotations() = %v", err)
+	}
+	if read.Hash() != p.Hash() {
+		t.Errorf("Hash() after round trip = %s, want %s", read.Hash(), p.Hash())
+	}
+}
+
+func TestApprovedBy(t *testing.T) {
+	p := New("max-stale", []string{"hello-00001"}, created)
+	other := New("max-stale", []string{"hello-00001", "hello-00002"}, created)
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		want        string
+		mismatch    bool
+	}{{
+		name: "no approval",
+	}, {
+		name:        "approver without plan",
+		annotations: map[string]string{ApprovedByAnnotationKey: "alice"},
+		mismatch:    true,
+	}, {
+		name: "approved",
+		annotations: map[string]string{
+			ApprovedByAnnotationKey:   "alice",
+			ApprovedPlanAnnotationKey: p.Hash(),
+		},
+		want: "alice",
+	}, {
+		name: "approved another plan",
+		annotations: map[string]string{
+			ApprovedByAnnotationKey:   "alice",
+			ApprovedPlanAnnotationKey: other.Hash(),
+		},
+		mismatch: true,
+	}, {
+		name:        "plan without approver",
+		annotations: map[string]string{ApprovedPlanAnnotationKey: p.Hash()},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := p.ApprovedBy(test.annotations); got != test.want {
+				t.Errorf("ApprovedBy() = %q, want %q", got, test.want)
+			}
+			if got := p.ApprovalMismatch(test.annotations); got != test.mismatch {
+				t.Errorf("ApprovalMismatch() = %v, want %v", got, test.mismatch)
+			}
+		})
+	}
+}
+
+func TestMergePatchRemovesApproval(t *testing.T) {
+	raw, err := MergePatch(New("max-stale", []string{"hello-00001"}, created))
+	if err != nil {
+		t.Fatalf("MergePatch() = %v", err)
+	}
+	var patch struct {
+		Metadata struct {
+			Annotations map[string]*string `json:"annotations"`
+		} `json:"metadata"`
+	}
+	if err := json.Unmarshal(raw, &patch); err != nil {
+		t.Fatalf("Unmarshal() = %v", err)
+	}
+	for _, key := range []string{ApprovedByAnnotationKey, ApprovedPlanAnnotationKey} {
+		if value, ok := patch.Metadata.Annotations[key]; !ok || value != nil {
+			t.Errorf("MergePatch() does not remove %s", key)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package pressure observes the health of the API server through the
+// requests the controller makes, so non-urgent deletions can back off while
+// the API server is under stress. Requests rejected by API Priority and
+// Fairness or by max-in-flight limits surface as 429 responses.
+package pressure
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"k8s.io/client-go/rest"
+)
+
+// maxSamples bounds the memory used by the observed requests.
+const maxSamples = 10000
+
+// Thresholds describe when the API server is considered under pressure.
+type Thresholds struct {
+	// Latency is the average request latency at or above which the API
+	// server is under pressure. Zero disables the check.
+	Latency time.Duration
+
+	// Rejections is the number of throttled requests at or above which the
+	// API server is under pressure. Zero disables the check.
+	Rejections int
+
+	// Window is the period requests are observed over. The API server is
+	// healthy again once a whole window is below the thresholds.
+	Window time.Duration
+}
+
+type sample struct {
+	at       time.Time
+	latency  time.Duration
+	rejected bool
+}
+
+// Monitor records the latency and the outcome of API requests.
+type Monitor struct {
+	mu      sync.Mutex
+	samples []sample
+}
+
+// Default is the Monitor of the controllers created without one.
+var Default = &Monitor{}
+
+type monitorKey struct{}
+
+// WithMonitor attaches the Monitor fed by the clients of ctx to ctx.
+func WithMonitor(ctx context.Context, m *Monitor) context.Context {
+	return context.WithValue(ctx, monitorKey{}, m)
+}
+
+// FromContext returns the Monitor attached to ctx, Default when none is.
+func FromContext(ctx context.Context) *Monitor {
+	if m, ok := ctx.Value(monitorKey{}).(*Monitor); ok {
+		return m
+	}
+	return Default
+}
+
+// Observe records a request.
+func (m *Monitor) Observe(at time.Time, latency time.Duration, rejected bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.samples) == maxSamples {
+		m.samples = append(m.samples[:0], m.samples[1:]...)
+	}
+	m.samples = append(m.samples, sample{at: at, latency: latency, rejected: rejected})
+}
+
+// Stressed reports whether the requests observed over the last window exceed
+// a threshold, and which.
+func (m *Monitor) Stressed(th Thresholds, now time.Time) (bool, string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	since := now.Add(-th.Window)
+	// Drop the samples that left the window.
+	i := 0
+	for i < len(m.samples) && m.samples[i].at.Before(since) {
+		i++
+	}
+	m.samples = m.samples[i:]
+	if len(m.samples) == 0 {
+		return false, ""
+	}
+
+	var total time.Duration
+	rejected := 0
+	for _, s := range m.samples {
+		total += s.latency
+		if s.rejected {
+			rejected++
+		}
+	}
+	if th.Rejections > 0 && rejected >= th.Rejections {
+		return true, fmt.Sprintf("%d requests throttled in the last %s", rejected, th.Window)
+	}
+	if avg := total / time.Duration(len(m.samples)); th.Latency > 0 && avg >= th.Latency {
+		return true, fmt.Sprintf("average request latency %s in the last %s", avg.Round(time.Millisecond), th.Window)
+	}
+	return false, ""
+}
+
+// WrapConfig returns a copy of cfg whose clients report their requests to
+// the Monitor. Watches are not observed, they are long running by design.
+func (m *Monitor) WrapConfig(cfg *rest.Config) *rest.Config {
+	cfg = rest.CopyConfig(cfg)
+	wrap := cfg.WrapTransport
+	cfg.WrapTransport = func(rt http.RoundTripper) http.RoundTripper {
+		if wrap != nil {
+			rt = wrap(rt)
+		}
+		return &observingTransport{next: rt, monitor: m}
+	}
+	return cfg
+}
+
+type observingTransport struct {
+	next    http.RoundTripper
+	monitor *Monitor
+}
+
+func (t *observingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Query().Get("watch") == "true" {
+		return t.next.RoundTrip(req)
+	}
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	if err == nil {
+		t.monitor.Observe(start, time.Since(start), resp.StatusCode == http.StatusTooManyRequests)
+	}
+	return resp, err
+}
@@ -0,0 +1,324 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package priorityqueue implements a rate limiting work queue handing out the
+// item with the highest priority first instead of the oldest one. It keeps the
+// guarantees of the client-go work queue: an item is queued at most once and
+// never processed concurrently, an item added while it is processed is queued
+// again when it is done, and delayed additions of an item are coalesced into
+// the soonest one. Waiting items age, so a steady stream of high priority
+// items does not starve the others.
+package priorityqueue
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+
+	"k8s.io/client-go/util/workqueue"
+)
+
+// DefaultAging is the waiting time that raises the priority of a queued
+// item by one.
+const DefaultAging = time.Second
+
+// PriorityFunc returns the priority of an item, higher is handed out first.
+// It is called when the item is queued.
+type PriorityFunc func(item interface{}) int
+
+// Queue is a workqueue.RateLimitingInterface ordering items by priority.
+// The priority of a queued item rises by one for every aging period it
+// waits. Items of the same priority are handed out in the order they were
+// queued.
+type Queue struct {
+	priority    PriorityFunc
+	rateLimiter workqueue.RateLimiter
+	aging       time.Duration
+	// now tells the time the items wait against
+	now   func() time.Time
+	start time.Time
+
+	mu   sync.Mutex
+	cond *sync.Cond
+	// queue holds the items waiting to be processed
+	queue entries
+	// queued indexes the entries of queue by item
+	queued map[interface{}]*entry
+	// dirty holds the items added while they are processed
+	dirty map[interface{}]bool
+	// processing holds the items handed out and not done yet
+	processing map[interface{}]bool
+	// waiting holds the delayed additions by item, one per item
+	waiting map[interface{}]*delayed
+	// seq orders the items of the same priority
+	seq          uint64
+	shuttingDown bool
+}
+
+// Check that Queue implements workqueue.RateLimitingInterface
+var _ workqueue.RateLimitingInterface = (*Queue)(nil)
+
+// New returns a Queue ordering items by priority, aged by DefaultAging, and
+// rate limiting their retries with the default controller rate limiter.
+func New(priority PriorityFunc) *Queue {
+	return NewWithAging(priority, DefaultAging)
+}
+
+// NewWithAging returns a Queue ordering items by priority, raised by one for
+// every aging period an item waits. Zero disables the aging.
+func NewWithAging(priority PriorityFunc, aging time.Duration) *Queue {
+	q := &Queue{
+		priority:    priority,
+		rateLimiter: workqueue.DefaultControllerRateLimiter(),
+		aging:       aging,
+		now:         time.Now,
+		queued:      make(map[interface{}]*entry),
+		dirty:       make(map[interface{}]bool),
+		processing:  make(map[interface{}]bool),
+		waiting:     make(map[interface{}]*delayed),
+	}
+	q.start = q.now()
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// Add queues the item, updating its priority when it is queued already.
+func (q *Queue) Add(item interface{}) {
+	// Compute the priority outside the lock, it may read listers.
+	priority := q.priority(item)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.shuttingDown {
+		return
+	}
+	if q.processing[item] {
+		q.dirty[item] = true
+		return
+	}
+	if e, ok := q.queued[item]; ok {
+		// The item keeps the age it gained waiting.
+		e.score = q.score(priority, e.queuedAt)
+		heap.Fix(&q.queue, e.index)
+		return
+	}
+	q.push(item, priority)
+	q.cond.Signal()
+}
+
+// push queues the item, the lock must be held.
+func (q *Queue) push(item interface{}, priority int) {
+	q.seq++
+	queuedAt := q.now()
+	e := &entry{item: item, score: q.score(priority, queuedAt), queuedAt: queuedAt, seq: q.seq}
+	heap.Push(&q.queue, e)
+	q.queued[item] = e
+}
+
+// score orders the queued items. The aged priority of an item queued at
+// queuedAt is priority + (now - queuedAt) / aging, every item ages at the
+// same rate, so the items are ordered by the part independent of now.
+func (q *Queue) score(priority int, queuedAt time.Time) float64 {
+	if q.aging <= 0 {
+		return float64(priority)
+	}
+	return float64(priority) - float64(queuedAt.Sub(q.start))/float64(q.aging)
+}
+
+// DropIf removes the queued and delayed items matching drop and keeps the
+// items being processed from being queued again, returning how many were
+// dropped.
+func (q *Queue) DropIf(drop func(item interface{}) bool) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	dropped := 0
+	for item, e := range q.queued {
+		if drop(item) {
+			heap.Remove(&q.queue, e.index)
+			delete(q.queued, item)
+			dropped++
+		}
+	}
+	for item := range q.dirty {
+		if drop(item) {
+			delete(q.dirty, item)
+			dropped++
+		}
+	}
+	for item, d := range q.waiting {
+		if drop(item) {
+			d.timer.Stop()
+			delete(q.waiting, item)
+			dropped++
+		}
+	}
+	return dropped
+}
+
+// Len returns the number of queued items.
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.queue)
+}
+
+// Get blocks until it can hand out the item with the highest priority. It
+// returns shutdown true once the queue is shut down.
+func (q *Queue) Get() (interface{}, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.queue) == 0 && !q.shuttingDown {
+		q.cond.Wait()
+	}
+	if len(q.queue) == 0 {
+		return nil, true
+	}
+	e := heap.Pop(&q.queue).(*entry)
+	delete(q.queued, e.item)
+	q.processing[e.item] = true
+	return e.item, false
+}
+
+// Done marks the item as processed, queuing it again when it was added while
+// it was processed.
+func (q *Queue) Done(item interface{}) {
+	q.mu.Lock()
+	dirty := q.dirty[item]
+	delete(q.processing, item)
+	delete(q.dirty, item)
+	q.mu.Unlock()
+
+	if dirty {
+		q.Add(item)
+	}
+}
+
+// ShutDown makes Get return shutdown once the queued items are handed out,
+// and ignores further additions, delayed ones included.
+func (q *Queue) ShutDown() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.shuttingDown = true
+	for item, d := range q.waiting {
+		d.timer.Stop()
+		delete(q.waiting, item)
+	}
+	q.cond.Broadcast()
+}
+
+// ShuttingDown returns whether the queue is shut down.
+func (q *Queue) ShuttingDown() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.shuttingDown
+}
+
+// AddAfter queues the item once the duration passed. Like the client-go
+// delaying queue, an item waits for one addition only: the soonest one.
+func (q *Queue) AddAfter(item interface{}, duration time.Duration) {
+	if duration <= 0 {
+		q.Add(item)
+		return
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.shuttingDown {
+		return
+	}
+	at := q.now().Add(duration)
+	if d, ok := q.waiting[item]; ok {
+		if !at.Before(d.at) {
+			return
+		}
+		d.timer.Stop()
+	}
+	d := &delayed{at: at}
+	d.timer = time.AfterFunc(duration, func() {
+		q.mu.Lock()
+		current := q.waiting[item] == d
+		if current {
+			delete(q.waiting, item)
+		}
+		q.mu.Unlock()
+		if current {
+			q.Add(item)
+		}
+	})
+	q.waiting[item] = d
+}
+
+// AddRateLimited queues the item once the rate limiter allows it.
+func (q *Queue) AddRateLimited(item interface{}) {
+	q.AddAfter(item, q.rateLimiter.When(item))
+}
+
+// Forget stops tracking the retries of the item.
+func (q *Queue) Forget(item interface{}) {
+	q.rateLimiter.Forget(item)
+}
+
+// NumRequeues returns how many times the item was retried.
+func (q *Queue) NumRequeues(item interface{}) int {
+	return q.rateLimiter.NumRequeues(item)
+}
+
+// entry is a queued item.
+type entry struct {
+	item     interface{}
+	score    float64
+	queuedAt time.Time
+	seq      uint64
+	index    int
+}
+
+// delayed is the pending delayed addition of an item.
+type delayed struct {
+	at    time.Time
+	timer *time.Timer
+}
+
+// entries implements heap.Interface, the highest priority first.
+type entries []*entry
+
+func (h entries) Len() int { return len(h) }
+
+func (h entries) Less(i, j int) bool {
+	if h[i].score != h[j].score {
+		return h[i].score > h[j].score
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h entries) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *entries) Push(x interface{}) {
+	e := x.(*entry)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *entries) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return e
+}
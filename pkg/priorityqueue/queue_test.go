@@ -0,0 +1,243 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package priorityqueue
+
+import (
+	"testing"
+	"time"
+)
+
+// fixedPriority returns the priorities of the items from a map.
+func fixedPriority(priorities map[string]int) PriorityFunc {
+	return func(item interface{}) int {
+		return priorities[item.(string)]
+	}
+}
+
+// get hands out an item, failing the test when the queue is shut down.
+func get(t *testing.T, q *Queue) interface{} {
+	t.Helper()
+	item, shutdown := q.Get()
+	if shutdown {
+		t.Fatal("Get() = shutdown, want an item")
+	}
+	return item
+}
+
+func TestPriorityOrder(t *testing.T) {
+	q := NewWithAging(fixedPriority(map[string]int{"a": 1, "b": 3, "c": 2, "d": 3}), 0)
+	for _, item := range []string{"a", "b", "c", "d"} {
+		q.Add(item)
+	}
+
+	// The highest priority first, in the order queued among equals.
+	for _, want := range []string{"b", "d", "c", "a"} {
+		if got := get(t, q); got != want {
+			t.Errorf("Get() = %v, want %s", got, want)
+		}
+	}
+}
+
+func TestAddUpdatesPriority(t *testing.T) {
+	priorities := map[string]int{"a": 1, "b": 2}
+	q := NewWithAging(fixedPriority(priorities), 0)
+	q.Add("a")
+	q.Add("b")
+	priorities["a"] = 5
+	q.Add("a")
+
+	if got := q.Len(); got != 2 {
+		t.Errorf("Len() = %d, want 2", got)
+	}
+	if got := get(t, q); got != "a" {
+		t.Errorf("Get() = %v, want a", got)
+	}
+}
+
+func TestDeduplication(t *testing.T) {
+	q := New(fixedPriority(nil))
+	q.Add("a")
+	q.Add("a")
+	q.Add("a")
+
+	if got := q.Len(); got != 1 {
+		t.Errorf("Len() = %d, want 1", got)
+	}
+}
+
+func TestDirtyWhileProcessing(t *testing.T) {
+	q := New(fixedPriority(nil))
+	q.Add("a")
+	item := get(t, q)
+
+	// Added while processed: not handed out concurrently, queued once done.
+	q.Add("a")
+	q.Add("a")
+	if got := q.Len(); got != 0 {
+		t.Errorf("Len() while processing = %d, want 0", got)
+	}
+	q.Done(item)
+	if got := q.Len(); got != 1 {
+		t.Errorf("Len() after Done = %d, want 1", got)
+	}
+	q.Done(get(t, q))
+	if got := q.Len(); got != 0 {
+		t.Errorf("Len() after the second Done = %d, want 0", got)
+	}
+}
+
+func TestDropIf(t *testing.T) {
+	q := New(fixedPriority(nil))
+	q.Add("ns1/a")
+	q.Add("ns2/b")
+	q.Add("ns1/c")
+	processing := get(t, q)
+	q.Add(processing)
+	q.AddAfter("ns1/d", time.Hour)
+
+	dropped := q.DropIf(func(item interface{}) bool {
+		return item.(string)[:4] == "ns1/"
+	})
+	if dropped != 3 {
+		t.Errorf("DropIf() = %d, want 3", dropped)
+	}
+	q.Done(processing)
+	if got := q.Len(); got != 1 {
+		t.Errorf("Len() = %d, want 1", got)
+	}
+	if got := len(q.waiting); got != 0 {
+		t.Errorf("waiting = %d, want 0", got)
+	}
+}
+
+func TestShutDown(t *testing.T) {
+	q := New(fixedPriority(nil))
+	q.Add("a")
+	q.AddAfter("b", time.Millisecond)
+	q.ShutDown()
+
+	// Queued items are still handed out, additions are ignored.
+	q.Add("c")
+	q.AddAfter("d", time.Millisecond)
+	if !q.ShuttingDown() {
+		t.Error("ShuttingDown() = false, want true")
+	}
+	if got := get(t, q); got != "a" {
+		t.Errorf("Get() = %v, want a", got)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if item, shutdown := q.Get(); !shutdown {
+		t.Errorf("Get() = %v, want shutdown", item)
+	}
+}
+
+func TestShutDownWakesGet(t *testing.T) {
+	q := New(fixedPriority(nil))
+	done := make(chan bool)
+	go func() {
+		_, shutdown := q.Get()
+		done <- shutdown
+	}()
+
+	q.ShutDown()
+	select {
+	case shutdown := <-done:
+		if !shutdown {
+			t.Error("Get() shutdown = false, want true")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Get() still blocked after ShutDown")
+	}
+}
+
+func TestAddAfterCoalesces(t *testing.T) {
+	q := New(fixedPriority(nil))
+	q.AddAfter("a", time.Hour)
+	q.AddAfter("a", time.Hour)
+	q.AddAfter("a", 2*time.Hour)
+	if got := len(q.waiting); got != 1 {
+		t.Fatalf("waiting = %d, want 1", got)
+	}
+	later := q.waiting["a"].at
+
+	// A sooner addition replaces the later one.
+	q.AddAfter("a", 10*time.Millisecond)
+	if got := len(q.waiting); got != 1 {
+		t.Fatalf("waiting = %d, want 1", got)
+	}
+	if !q.waiting["a"].at.Before(later) {
+		t.Error("the sooner addition did not replace the later one")
+	}
+
+	if got := get(t, q); got != "a" {
+		t.Errorf("Get() = %v, want a", got)
+	}
+	q.Done("a")
+	time.Sleep(20 * time.Millisecond)
+	if got := q.Len(); got != 0 {
+		t.Errorf("Len() = %d, want 0 once the delayed additions coalesced", got)
+	}
+	if got := len(q.waiting); got != 0 {
+		t.Errorf("waiting = %d, want 0", got)
+	}
+}
+
+func TestAddAfterNonPositive(t *testing.T) {
+	q := New(fixedPriority(nil))
+	q.AddAfter("a", 0)
+	if got := q.Len(); got != 1 {
+		t.Errorf("Len() = %d, want 1", got)
+	}
+}
+
+func TestAging(t *testing.T) {
+	now := time.Date(2019, 8, 1, 12, 0, 0, 0, time.UTC)
+	q := NewWithAging(fixedPriority(map[string]int{"small": 1, "large": 10}), time.Second)
+	q.now = func() time.Time { return now }
+	q.start = now
+
+	q.Add("small")
+	now = now.Add(5 * time.Second)
+	q.Add("large")
+	// small aged to 6, below large.
+	if got := get(t, q); got != "large" {
+		t.Errorf("Get() = %v, want large", got)
+	}
+	q.Done("large")
+
+	// A steady stream of large items does not starve small: aged to 13, it
+	// goes before the next large item.
+	now = now.Add(7 * time.Second)
+	q.Add("large")
+	if got := get(t, q); got != "small" {
+		t.Errorf("Get() = %v, want small", got)
+	}
+}
+
+func TestNoAging(t *testing.T) {
+	now := time.Date(2019, 8, 1, 12, 0, 0, 0, time.UTC)
+	q := NewWithAging(fixedPriority(map[string]int{"small": 1, "large": 10}), 0)
+	q.now = func() time.Time { return now }
+	q.start = now
+
+	q.Add("small")
+	now = now.Add(time.Hour)
+	q.Add("large")
+	if got := get(t, q); got != "large" {
+		t.Errorf("Get() = %v, want large", got)
+	}
+}
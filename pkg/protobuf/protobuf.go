@@ -0,0 +1,43 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package protobuf configures the clients of the built-in Kubernetes APIs to
+// use the protobuf encoding. The API server encodes it faster, and sends it
+// smaller, than JSON, which matters for the large lists of a sweep. Custom
+// resources, such as the Knative ones, are only served as JSON, so the
+// clients of their API groups keep the default.
+//
+// Compression needs no configuration: the Go transport asks for gzip and
+// the API server compresses large responses when its APIResponseCompression
+// feature is enabled.
+package protobuf
+
+import (
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/rest"
+)
+
+// ContentType is the media type of the protobuf encoding.
+const ContentType = "application/vnd.kubernetes.protobuf"
+
+// WrapConfig returns a copy of cfg sending and accepting protobuf, falling
+// back to JSON for the resources that are not served as protobuf.
+func WrapConfig(cfg *rest.Config) *rest.Config {
+	cfg = rest.CopyConfig(cfg)
+	cfg.ContentType = ContentType
+	cfg.AcceptContentTypes = ContentType + "," + runtime.ContentTypeJSON
+	return cfg
+}
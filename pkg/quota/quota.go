@@ -0,0 +1,344 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package quota limits the number of revision deletions per hour and per day,
+// globally, per namespace and per owner team. The counters are persisted in a ConfigMap so
+// the budgets survive controller restarts and crash loops.
+package quota
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/knative-sample/revision-controller/pkg/apicall"
+	corev1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	// ConfigMapName is the name of the ConfigMap the counters are kept in.
+	ConfigMapName = "revision-gc-quota"
+
+	globalKey          = "global"
+	namespaceKeyPrefix = "namespace."
+	teamKeyPrefix      = "team."
+
+	hourFormat = "2006-01-02T15"
+	dayFormat  = "2006-01-02"
+)
+
+// Limits are the maximum deletions per period, zero means unlimited.
+type Limits struct {
+	PerHour int
+	PerDay  int
+}
+
+// Unlimited reports whether no limit is set.
+func (l Limits) Unlimited() bool {
+	return l.PerHour == 0 && l.PerDay == 0
+}
+
+// Quotas are the limits a deletion counts against.
+type Quotas struct {
+	// Global limits the deletions across the cluster.
+	Global Limits
+	// Namespace limits the deletions in every namespace.
+	Namespace Limits
+	// Team limits the deletions of every owner team, across namespaces.
+	// Deletions of Services without an owner team do not count against it.
+	Team Limits
+}
+
+// Unlimited reports whether no limit is set.
+func (q Quotas) Unlimited() bool {
+	return q.Global.Unlimited() && q.Namespace.Unlimited() && q.Team.Unlimited()
+}
+
+// counter counts the deletions of the current hour and day, in UTC.
+type counter struct {
+	Hour      string `json:"hour"`
+	HourCount int    `json:"hourCount"`
+	Day       string `json:"day"`
+	DayCount  int    `json:"dayCount"`
+}
+
+// roll resets the counts of the periods that ended before now.
+func (c *counter) roll(now time.Time) {
+	now = now.UTC()
+	if hour := now.Format(hourFormat); c.Hour != hour {
+		c.Hour, c.HourCount = hour, 0
+	}
+	if day := now.Format(dayFormat); c.Day != day {
+		c.Day, c.DayCount = day, 0
+	}
+}
+
+// remaining returns the deletions left under the limits, -1 when unlimited.
+func (c *counter) remaining(limits Limits) int {
+	left := -1
+	if limits.PerHour > 0 {
+		left = max0(limits.PerHour - c.HourCount)
+	}
+	if limits.PerDay > 0 {
+		if day := max0(limits.PerDay - c.DayCount); left < 0 || day < left {
+			left = day
+		}
+	}
+	return left
+}
+
+func max0(n int) int {
+	if n < 0 {
+		return 0
+	}
+	return n
+}
+
+// Remaining is the quota left for a scope and period.
+type Remaining struct {
+	// Namespace is set for the namespace quota.
+	Namespace string
+	// Team is set for the owner team quota.
+	Team string
+	// Period is "hour" or "day".
+	Period    string
+	Remaining int
+}
+
+// Tracker grants deletions against the quotas and persists the counters.
+type Tracker struct {
+	kubeClient kubernetes.Interface
+	namespace  string
+
+	mu     sync.Mutex
+	loaded bool
+	// counters are the counters last read or written, Reserve and Release
+	// read them again from the ConfigMap
+	counters map[string]*counter
+}
+
+// NewTracker returns a Tracker persisting its counters in the namespace.
+func NewTracker(kubeClient kubernetes.Interface, namespace string) *Tracker {
+	return &Tracker{
+		kubeClient: kubeClient,
+		namespace:  namespace,
+		counters:   map[string]*counter{},
+	}
+}
+
+// Reserve grants up to want deletions in the namespace, owned by team, under
+// the quotas and persists the consumption before returning, so deletions are
+// accounted for even if the controller crashes right after. team is empty for
+// Services without an owner team.
+func (t *Tracker) Reserve(ctx context.Context, namespace, team string, want int, quotas Quotas, now time.Time) (int, error) {
+	if quotas.Unlimited() {
+		return want, nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var granted int
+	err := t.update(ctx, now, func(counters map[string]*counter) bool {
+		scopes := scopes(counters, namespace, team, quotas, now)
+		granted = want
+		if len(scopes) == 0 {
+			return false
+		}
+		for _, s := range scopes {
+			if left := s.counter.remaining(s.limits); left >= 0 && left < granted {
+				granted = left
+			}
+		}
+		for _, s := range scopes {
+			s.counter.HourCount += granted
+			s.counter.DayCount += granted
+		}
+		return granted > 0
+	})
+	if err != nil {
+		return 0, err
+	}
+	return granted, nil
+}
+
+// Release returns n granted but unused deletions to the quotas.
+func (t *Tracker) Release(ctx context.Context, namespace, team string, n int, quotas Quotas, now time.Time) error {
+	if n == 0 || quotas.Unlimited() {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.update(ctx, now, func(counters map[string]*counter) bool {
+		for _, s := range scopes(counters, namespace, team, quotas, now) {
+			s.counter.HourCount = max0(s.counter.HourCount - n)
+			s.counter.DayCount = max0(s.counter.DayCount - n)
+		}
+		return true
+	})
+}
+
+// Remaining returns the quota left globally, in the namespace and for the
+// team for every limited period, as last read or written.
+func (t *Tracker) Remaining(ctx context.Context, namespace, team string, quotas Quotas, now time.Time) ([]Remaining, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.loaded {
+		cm, err := t.get(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if t.counters, err = decode(cm); err != nil {
+			return nil, err
+		}
+		t.loaded = true
+	}
+
+	var out []Remaining
+	for _, s := range scopes(t.counters, namespace, team, quotas, now) {
+		if s.limits.PerHour > 0 {
+			out = append(out, Remaining{Namespace: s.namespace, Team: s.team, Period: "hour", Remaining: max0(s.limits.PerHour - s.counter.HourCount)})
+		}
+		if s.limits.PerDay > 0 {
+			out = append(out, Remaining{Namespace: s.namespace, Team: s.team, Period: "day", Remaining: max0(s.limits.PerDay - s.counter.DayCount)})
+		}
+	}
+	return out, nil
+}
+
+// NextReset returns the start of the next hour, when exhausted hourly quotas
+// are refilled.
+func NextReset(now time.Time) time.Time {
+	return now.UTC().Truncate(time.Hour).Add(time.Hour)
+}
+
+type scope struct {
+	namespace string
+	team      string
+	limits    Limits
+	counter   *counter
+}
+
+// scopes returns the limited scopes a deletion in the namespace, owned by
+// team, counts against, with their counters in counters rolled to now.
+func scopes(counters map[string]*counter, namespace, team string, quotas Quotas, now time.Time) []scope {
+	var scopes []scope
+	if !quotas.Global.Unlimited() {
+		scopes = append(scopes, scope{limits: quotas.Global, counter: rolled(counters, globalKey, now)})
+	}
+	if !quotas.Namespace.Unlimited() {
+		scopes = append(scopes, scope{namespace: namespace, limits: quotas.Namespace, counter: rolled(counters, namespaceKeyPrefix+namespace, now)})
+	}
+	if team != "" && !quotas.Team.Unlimited() {
+		scopes = append(scopes, scope{team: team, limits: quotas.Team, counter: rolled(counters, teamKeyPrefix+team, now)})
+	}
+	return scopes
+}
+
+// rolled returns the counter of key in counters, rolled to now.
+func rolled(counters map[string]*counter, key string, now time.Time) *counter {
+	c, ok := counters[key]
+	if !ok {
+		c = &counter{}
+		counters[key] = c
+	}
+	c.roll(now)
+	return c
+}
+
+// update applies change to the counters read from the ConfigMap and writes
+// them back when it reports a change, dropping the ones whose periods ended
+// before now. The counters are read again on conflicts, so the deletions
+// other replicas counted meanwhile are not overwritten.
+func (t *Tracker) update(ctx context.Context, now time.Time, change func(map[string]*counter) bool) error {
+	today := now.UTC().Format(dayFormat)
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		cm, err := t.get(ctx)
+		if err != nil {
+			return err
+		}
+		counters, err := decode(cm)
+		if err != nil {
+			return err
+		}
+		if !change(counters) {
+			t.counters, t.loaded = counters, true
+			return nil
+		}
+
+		data := make(map[string]string, len(counters))
+		for key, c := range counters {
+			if c.Day != today {
+				delete(counters, key)
+				continue
+			}
+			raw, err := json.Marshal(c)
+			if err != nil {
+				return err
+			}
+			data[key] = string(raw)
+		}
+		if cm == nil {
+			_, err = apicall.CreateConfigMap(ctx, t.kubeClient, &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Namespace: t.namespace, Name: ConfigMapName},
+				Data:       data,
+			})
+		} else {
+			cm = cm.DeepCopy()
+			cm.Data = data
+			_, err = apicall.UpdateConfigMap(ctx, t.kubeClient, cm)
+		}
+		if err != nil {
+			return err
+		}
+		t.counters, t.loaded = counters, true
+		return nil
+	})
+}
+
+// get reads the ConfigMap holding the counters, nil when it does not exist.
+func (t *Tracker) get(ctx context.Context) (*corev1.ConfigMap, error) {
+	cm, err := apicall.GetConfigMap(ctx, t.kubeClient, t.namespace, ConfigMapName)
+	if apierrs.IsNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("read deletion quota: %v", err)
+	}
+	return cm, nil
+}
+
+// decode returns the counters held by the ConfigMap, none when it is nil.
+func decode(cm *corev1.ConfigMap) (map[string]*counter, error) {
+	counters := map[string]*counter{}
+	if cm == nil {
+		return counters, nil
+	}
+	for key, raw := range cm.Data {
+		c := &counter{}
+		if err := json.Unmarshal([]byte(raw), c); err != nil {
+			// A corrupted counter must not silently reset the budget,
+			// deletions stop until it is repaired or removed.
+			return nil, fmt.Errorf("read deletion quota %s: %v", key, err)
+		}
+		counters[key] = c
+	}
+	return counters, nil
+}
@@ -0,0 +1,313 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package quota
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+const path = "/api/v1/namespaces/knative-serving/configmaps"
+
+// configMapServer serves the quota ConfigMap of the namespace
+// "knative-serving", rejecting updates of stale resource versions.
+type configMapServer struct {
+	mu      sync.Mutex
+	cm      *corev1.ConfigMap
+	version int
+	updates int
+
+	// beforeUpdate is called before an update is applied, e.g. to write
+	// the ConfigMap as another replica.
+	beforeUpdate func(s *configMapServer)
+}
+
+// store stores the ConfigMap with data at the next resource version.
+func (s *configMapServer) store(data map[string]string) {
+	s.version++
+	s.cm = &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "knative-serving", Name: ConfigMapName, ResourceVersion: strconv.Itoa(s.version)},
+		Data:       data,
+	}
+}
+
+func (s *configMapServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == path+"/"+ConfigMapName:
+		if s.cm == nil {
+			writeStatus(w, http.StatusNotFound, metav1.StatusReasonNotFound)
+			return
+		}
+		writeObject(w, s.cm)
+	case r.Method == http.MethodPost && r.URL.Path == path:
+		if s.cm != nil {
+			writeStatus(w, http.StatusConflict, metav1.StatusReasonAlreadyExists)
+			return
+		}
+		var cm corev1.ConfigMap
+		json.NewDecoder(r.Body).Decode(&cm)
+		s.store(cm.Data)
+		writeObject(w, s.cm)
+	case r.Method == http.MethodPut && r.URL.Path == path+"/"+ConfigMapName:
+		if s.beforeUpdate != nil {
+			s.beforeUpdate(s)
+		}
+		var cm corev1.ConfigMap
+		json.NewDecoder(r.Body).Decode(&cm)
+		if s.cm == nil || cm.ResourceVersion != s.cm.ResourceVersion {
+			writeStatus(w, http.StatusConflict, metav1.StatusReasonConflict)
+			return
+		}
+		s.updates++
+		s.store(cm.Data)
+		writeObject(w, s.cm)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func writeStatus(w http.ResponseWriter, code int, reason metav1.StatusReason) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(&metav1.Status{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Status"},
+		Status:   metav1.StatusFailure,
+		Code:     int32(code),
+		Reason:   reason,
+	})
+}
+
+func writeObject(w http.ResponseWriter, obj interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(obj)
+}
+
+// newTracker returns a Tracker persisting in the ConfigMap of s.
+func newTracker(t *testing.T, s *configMapServer) (*Tracker, func()) {
+	server := httptest.NewServer(s)
+	kubeClient, err := kubernetes.NewForConfig(&rest.Config{Host: server.URL})
+	if err != nil {
+		t.Fatalf("NewForConfig() = %v", err)
+	}
+	return NewTracker(kubeClient, "knative-serving"), server.Close
+}
+
+// counterData returns the ConfigMap data of a counter counting n deletions
+// in the hour and the day of now.
+func counterData(now time.Time, n int) string {
+	raw, _ := json.Marshal(&counter{Hour: now.Format(hourFormat), HourCount: n, Day: now.Format(dayFormat), DayCount: n})
+	return string(raw)
+}
+
+// A day of the past, the counters are rolled against the passed time.
+var now = time.Date(2019, 8, 1, 12, 30, 0, 0, time.UTC)
+
+func TestReserve(t *testing.T) {
+	quotas := Quotas{Global: Limits{PerHour: 5}, Namespace: Limits{PerDay: 3}, Team: Limits{PerHour: 2}}
+	tests := []struct {
+		name        string
+		data        map[string]string
+		team        string
+		want        int
+		granted     int
+		wantData    map[string]string
+		wantUpdates int
+	}{{
+		name:    "no ConfigMap yet",
+		want:    4,
+		granted: 3,
+		wantData: map[string]string{
+			"global":            counterData(now, 3),
+			"namespace.default": counterData(now, 3),
+		},
+	}, {
+		name: "counted by other replicas",
+		data: map[string]string{
+			"global":            counterData(now, 4),
+			"namespace.default": counterData(now, 1),
+			"namespace.other":   counterData(now, 3),
+		},
+		want:    4,
+		granted: 1,
+		wantData: map[string]string{
+			"global":            counterData(now, 5),
+			"namespace.default": counterData(now, 2),
+			"namespace.other":   counterData(now, 3),
+		},
+		wantUpdates: 1,
+	}, {
+		name: "team quota",
+		data: map[string]string{
+			"team.payments": counterData(now, 1),
+		},
+		team:    "payments",
+		want:    4,
+		granted: 1,
+		wantData: map[string]string{
+			"global":            counterData(now, 1),
+			"namespace.default": counterData(now, 1),
+			"team.payments":     counterData(now, 2),
+		},
+		wantUpdates: 1,
+	}, {
+		name: "periods ended before now",
+		data: map[string]string{
+			"global":            counterData(now.Add(-24*time.Hour), 5),
+			"namespace.default": counterData(now.Add(-time.Hour), 2),
+			"namespace.other":   counterData(now.Add(-24*time.Hour), 3),
+		},
+		want:    1,
+		granted: 1,
+		wantData: map[string]string{
+			"global": counterData(now, 1),
+			"namespace.default": string(mustMarshal(&counter{
+				Hour: now.Format(hourFormat), HourCount: 1, Day: now.Format(dayFormat), DayCount: 3,
+			})),
+		},
+		wantUpdates: 1,
+	}, {
+		name: "exhausted",
+		data: map[string]string{
+			"global": counterData(now, 5),
+		},
+		want:    2,
+		granted: 0,
+		wantData: map[string]string{
+			"global": counterData(now, 5),
+		},
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &configMapServer{}
+			if tt.data != nil {
+				s.store(tt.data)
+			}
+			tracker, stop := newTracker(t, s)
+			defer stop()
+
+			granted, err := tracker.Reserve(context.Background(), "default", tt.team, tt.want, quotas, now)
+			if err != nil {
+				t.Fatalf("Reserve() = %v", err)
+			}
+			if granted != tt.granted {
+				t.Errorf("Reserve() = %d, want %d", granted, tt.granted)
+			}
+			if got := s.cm.Data; !reflect.DeepEqual(got, tt.wantData) {
+				t.Errorf("ConfigMap data = %v, want %v", got, tt.wantData)
+			}
+			if s.updates != tt.wantUpdates {
+				t.Errorf("updates = %d, want %d", s.updates, tt.wantUpdates)
+			}
+		})
+	}
+}
+
+func mustMarshal(v interface{}) []byte {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return raw
+}
+
+func TestReserveAcrossReplicas(t *testing.T) {
+	quotas := Quotas{Global: Limits{PerHour: 10}}
+	s := &configMapServer{}
+	a, stopA := newTracker(t, s)
+	defer stopA()
+	b, stopB := newTracker(t, s)
+	defer stopB()
+
+	ctx := context.Background()
+	for _, tracker := range []*Tracker{a, b, a, b} {
+		if _, err := tracker.Reserve(ctx, "default", "", 2, quotas, now); err != nil {
+			t.Fatalf("Reserve() = %v", err)
+		}
+	}
+	// Another replica counts while the update is in flight.
+	s.beforeUpdate = func(s *configMapServer) {
+		s.beforeUpdate = nil
+		s.store(map[string]string{"global": counterData(now, 9)})
+	}
+	granted, err := a.Reserve(ctx, "default", "", 2, quotas, now)
+	if err != nil {
+		t.Fatalf("Reserve() = %v", err)
+	}
+	if granted != 1 {
+		t.Errorf("Reserve() = %d, want 1", granted)
+	}
+	if got, want := s.cm.Data["global"], counterData(now, 10); got != want {
+		t.Errorf("global counter = %s, want %s", got, want)
+	}
+
+	remaining, err := b.Remaining(ctx, "default", "", quotas, now)
+	if err != nil {
+		t.Fatalf("Remaining() = %v", err)
+	}
+	if want := []Remaining{{Period: "hour", Remaining: 2}}; !reflect.DeepEqual(remaining, want) {
+		t.Errorf("Remaining() = %v, want %v as last written by b", remaining, want)
+	}
+}
+
+func TestRelease(t *testing.T) {
+	quotas := Quotas{Global: Limits{PerHour: 10}}
+	s := &configMapServer{}
+	s.store(map[string]string{"global": counterData(now, 6)})
+	tracker, stop := newTracker(t, s)
+	defer stop()
+
+	ctx := context.Background()
+	if _, err := tracker.Remaining(ctx, "default", "", quotas, now); err != nil {
+		t.Fatalf("Remaining() = %v", err)
+	}
+	// Another replica counts after the counters were read.
+	s.store(map[string]string{"global": counterData(now, 8)})
+	if err := tracker.Release(ctx, "default", "", 3, quotas, now); err != nil {
+		t.Fatalf("Release() = %v", err)
+	}
+	if got, want := s.cm.Data["global"], counterData(now, 5); got != want {
+		t.Errorf("global counter = %s, want %s", got, want)
+	}
+}
+
+func TestCorruptedCounter(t *testing.T) {
+	s := &configMapServer{}
+	s.store(map[string]string{"global": "{"})
+	tracker, stop := newTracker(t, s)
+	defer stop()
+
+	if _, err := tracker.Reserve(context.Background(), "default", "", 1, Quotas{Global: Limits{PerDay: 10}}, now); err == nil {
+		t.Error("Reserve() = nil, want an error")
+	}
+	if s.updates != 0 {
+		t.Errorf("updates = %d, want 0", s.updates)
+	}
+}
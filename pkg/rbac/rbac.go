@@ -0,0 +1,294 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package rbac generates the minimal RBAC manifests of the controller for a
+// feature set. Permissions on objects in all namespaces are granted with a
+// ClusterRole, those only needed in the system namespace with a Role.
+package rbac
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Feature is a feature of the controller needing permissions.
+type Feature string
+
+const (
+	// GC plans and deletes revisions and reports the configuration status.
+	GC Feature = "gc"
+	// Webhook registers and serves the admission webhook.
+	Webhook Feature = "webhook"
+	// APIServer serves the gc.knative.dev aggregated API.
+	APIServer Feature = "apiserver"
+	// Admin serves the admin API.
+	Admin Feature = "admin"
+	// Sweeper deletes the child resources of deleted revisions.
+	Sweeper Feature = "sweeper"
+	// Mesh verifies that the pods of revisions are drained before deletion.
+	Mesh Feature = "mesh"
+	// Ingress verifies that the networking layer no longer routes to
+	// revisions before deletion.
+	Ingress Feature = "ingress"
+	// Suspend scales stale revisions to zero instead of deleting them
+	// under the suspend retention mode.
+	Suspend Feature = "suspend"
+	// ActiveActive spreads the namespaces over the replicas with Leases.
+	ActiveActive Feature = "active-active"
+)
+
+// Features are all features, in the order their permissions are emitted.
+var Features = []Feature{GC, Webhook, APIServer, Admin, Sweeper, Mesh, Ingress, Suspend, ActiveActive}
+
+// Name is the name of the generated roles and bindings.
+const Name = "revision-controller"
+
+// permissions are the rules of a feature by scope.
+type permissions struct {
+	cluster   []rbacv1.PolicyRule
+	namespace []rbacv1.PolicyRule
+	// delegated are the ClusterRoles bound to the ServiceAccount, by the
+	// namespace of the RoleBinding, "" for a ClusterRoleBinding.
+	delegated map[string][]string
+}
+
+func rule(group string, resources []string, verbs ...string) rbacv1.PolicyRule {
+	return rbacv1.PolicyRule{APIGroups: []string{group}, Resources: resources, Verbs: verbs}
+}
+
+// readTLS reads the TLS Secrets of the embedded servers.
+var readTLS = rule("", []string{"secrets"}, "get")
+
+var byFeature = map[Feature]permissions{
+	GC: {
+		cluster: []rbacv1.PolicyRule{
+			rule("serving.knative.dev", []string{"services", "configurations"}, "get", "list", "watch", "patch"),
+			rule("serving.knative.dev", []string{"routes"}, "get", "list", "watch"),
+			rule("serving.knative.dev", []string{"revisions"}, "get", "list", "watch", "patch", "delete"),
+			rule("autoscaling.internal.knative.dev", []string{"podautoscalers"}, "get", "list", "watch"),
+			rule("apps", []string{"deployments"}, "get", "list", "watch"),
+			rule("", []string{"namespaces"}, "get", "list", "watch"),
+			rule("", []string{"resourcequotas"}, "get", "list", "watch"),
+			rule("", []string{"persistentvolumeclaims"}, "list", "delete"),
+			rule("", []string{"events"}, "create", "patch"),
+			rule("config.gc.knative.dev", []string{"revisiongcconfigs"}, "get", "create"),
+			rule("config.gc.knative.dev", []string{"revisiongcconfigs/status"}, "update"),
+		},
+		namespace: []rbacv1.PolicyRule{
+			rule("", []string{"configmaps"}, "get", "list", "watch", "create", "update", "patch"),
+		},
+	},
+	Webhook: {
+		cluster: []rbacv1.PolicyRule{
+			rule("admissionregistration.k8s.io", []string{"mutatingwebhookconfigurations"}, "get", "create", "update"),
+		},
+		namespace: []rbacv1.PolicyRule{readTLS},
+	},
+	APIServer: {
+		namespace: []rbacv1.PolicyRule{readTLS},
+		delegated: map[string][]string{
+			"":            {"system:auth-delegator"},
+			"kube-system": {"extension-apiserver-authentication-reader"},
+		},
+	},
+	Admin: {
+		namespace: []rbacv1.PolicyRule{readTLS},
+		delegated: map[string][]string{
+			"": {"system:auth-delegator"},
+		},
+	},
+	Sweeper: {
+		cluster: []rbacv1.PolicyRule{
+			rule("", []string{"secrets", "configmaps"}, "list", "delete"),
+		},
+	},
+	Mesh: {
+		cluster: []rbacv1.PolicyRule{
+			rule("", []string{"pods"}, "get", "list"),
+		},
+	},
+	Ingress: {
+		cluster: []rbacv1.PolicyRule{
+			rule("networking.internal.knative.dev", []string{"ingresses", "clusteringresses"}, "list"),
+		},
+	},
+	Suspend: {
+		cluster: []rbacv1.PolicyRule{
+			rule("autoscaling.internal.knative.dev", []string{"podautoscalers"}, "patch"),
+		},
+	},
+	ActiveActive: {
+		namespace: []rbacv1.PolicyRule{
+			rule("coordination.k8s.io", []string{"leases"}, "list", "create", "update", "delete"),
+		},
+	},
+}
+
+// ParseFeatures parses a comma separated list of features.
+func ParseFeatures(raw string) ([]Feature, error) {
+	var out []Feature
+	for _, f := range strings.Split(raw, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		if _, ok := byFeature[Feature(f)]; !ok {
+			names := make([]string, 0, len(Features))
+			for _, known := range Features {
+				names = append(names, string(known))
+			}
+			return nil, fmt.Errorf("unknown feature %q, expected one of %s", f, strings.Join(names, ", "))
+		}
+		out = append(out, Feature(f))
+	}
+	return out, nil
+}
+
+// Manifests returns the roles and bindings granting the features to the
+// ServiceAccount in namespace, the system namespace.
+func Manifests(features []Feature, namespace, serviceAccount string) []interface{} {
+	enabled := make(map[Feature]bool, len(features))
+	for _, f := range features {
+		enabled[f] = true
+	}
+	var cluster, local []rbacv1.PolicyRule
+	delegated := make(map[string]map[string]bool)
+	for _, f := range Features {
+		if !enabled[f] {
+			continue
+		}
+		p := byFeature[f]
+		cluster = appendRules(cluster, p.cluster...)
+		local = appendRules(local, p.namespace...)
+		for ns, roles := range p.delegated {
+			if delegated[ns] == nil {
+				delegated[ns] = make(map[string]bool)
+			}
+			for _, role := range roles {
+				delegated[ns][role] = true
+			}
+		}
+	}
+
+	subjects := []rbacv1.Subject{{Kind: rbacv1.ServiceAccountKind, Name: serviceAccount, Namespace: namespace}}
+	var out []interface{}
+	if len(cluster) > 0 {
+		out = append(out,
+			&rbacv1.ClusterRole{
+				TypeMeta:   typeMeta("ClusterRole"),
+				ObjectMeta: metav1.ObjectMeta{Name: Name},
+				Rules:      cluster,
+			},
+			&rbacv1.ClusterRoleBinding{
+				TypeMeta:   typeMeta("ClusterRoleBinding"),
+				ObjectMeta: metav1.ObjectMeta{Name: Name},
+				RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: Name},
+				Subjects:   subjects,
+			})
+	}
+	if len(local) > 0 {
+		out = append(out,
+			&rbacv1.Role{
+				TypeMeta:   typeMeta("Role"),
+				ObjectMeta: metav1.ObjectMeta{Name: Name, Namespace: namespace},
+				Rules:      local,
+			},
+			&rbacv1.RoleBinding{
+				TypeMeta:   typeMeta("RoleBinding"),
+				ObjectMeta: metav1.ObjectMeta{Name: Name, Namespace: namespace},
+				RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: Name},
+				Subjects:   subjects,
+			})
+	}
+
+	namespaces := make([]string, 0, len(delegated))
+	for ns := range delegated {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Strings(namespaces)
+	for _, ns := range namespaces {
+		roles := make([]string, 0, len(delegated[ns]))
+		for role := range delegated[ns] {
+			roles = append(roles, role)
+		}
+		sort.Strings(roles)
+		for _, role := range roles {
+			name := Name + "-" + strings.Replace(role, ":", "-", -1)
+			if ns == "" {
+				out = append(out, &rbacv1.ClusterRoleBinding{
+					TypeMeta:   typeMeta("ClusterRoleBinding"),
+					ObjectMeta: metav1.ObjectMeta{Name: name},
+					RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: role},
+					Subjects:   subjects,
+				})
+				continue
+			}
+			// Roles like extension-apiserver-authentication-reader are Roles
+			// of their namespace.
+			out = append(out, &rbacv1.RoleBinding{
+				TypeMeta:   typeMeta("RoleBinding"),
+				ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: ns},
+				RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: role},
+				Subjects:   subjects,
+			})
+		}
+	}
+	return out
+}
+
+// YAML renders the manifests as a multi-document YAML stream.
+func YAML(manifests []interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	for _, m := range manifests {
+		raw, err := yaml.Marshal(m)
+		if err != nil {
+			return nil, err
+		}
+		buf.WriteString("---\n")
+		buf.Write(raw)
+	}
+	return buf.Bytes(), nil
+}
+
+// appendRules appends the rules not yet granted.
+func appendRules(rules []rbacv1.PolicyRule, add ...rbacv1.PolicyRule) []rbacv1.PolicyRule {
+next:
+	for _, r := range add {
+		for _, existing := range rules {
+			if equalRules(existing, r) {
+				continue next
+			}
+		}
+		rules = append(rules, r)
+	}
+	return rules
+}
+
+func equalRules(a, b rbacv1.PolicyRule) bool {
+	return strings.Join(a.APIGroups, ",") == strings.Join(b.APIGroups, ",") &&
+		strings.Join(a.Resources, ",") == strings.Join(b.Resources, ",") &&
+		strings.Join(a.Verbs, ",") == strings.Join(b.Verbs, ",")
+}
+
+func typeMeta(kind string) metav1.TypeMeta {
+	return metav1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: kind}
+}
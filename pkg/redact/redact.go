@@ -0,0 +1,68 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package redact masks the values of user supplied annotations before they
+// reach logs, events and notifications. Annotations occasionally carry
+// tokens or URLs with credentials, so only the values of the annotations of
+// the controller and of Knative, and of those allowlisted by the cluster
+// operator, are shown as is.
+package redact
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// SafePrefixes are the prefixes of the annotations whose values are always
+// shown.
+var SafePrefixes = []string{
+	"revision-gc.knative.dev/",
+	"serving.knative.dev/",
+	"autoscaling.knative.dev/",
+	"networking.knative.dev/",
+}
+
+// Safe reports whether the value of the annotation key may be shown. The
+// allowlist holds keys, or prefixes when they end in "*".
+func Safe(allowlist []string, key string) bool {
+	for _, prefix := range SafePrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	for _, entry := range allowlist {
+		if prefix := strings.TrimSuffix(entry, "*"); prefix != entry {
+			if strings.HasPrefix(key, prefix) {
+				return true
+			}
+		} else if key == entry {
+			return true
+		}
+	}
+	return false
+}
+
+// Value returns the value of the annotation key, masked unless the key is
+// safe. The mask carries a short digest of the value, so a changed value is
+// still told apart from an unchanged one.
+func Value(allowlist []string, key, value string) string {
+	if value == "" || Safe(allowlist, key) {
+		return value
+	}
+	sum := sha256.Sum256([]byte(value))
+	return "redacted:" + hex.EncodeToString(sum[:4])
+}
@@ -0,0 +1,63 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package resourcequota tells how close a namespace is to the limits of its
+// ResourceQuotas, so garbage collection can relieve the quota pressure.
+package resourcequota
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// DefaultResources are the object counts stale revisions hold: the
+// revisions themselves and the Deployments, ReplicaSets and Services
+// Knative Serving creates for them.
+var DefaultResources = []corev1.ResourceName{
+	"count/revisions.serving.knative.dev",
+	"count/deployments.apps",
+	"count/replicasets.apps",
+	corev1.ResourceServices,
+	"count/services",
+}
+
+// Pressure returns the most used of the resources limited by the quotas,
+// described for logs and messages, when its usage reaches the threshold
+// percentage of its limit. It reports false when no resource does.
+func Pressure(quotas []*corev1.ResourceQuota, resources []corev1.ResourceName, threshold int) (string, bool) {
+	if threshold <= 0 {
+		return "", false
+	}
+	var most string
+	var mostRatio float64
+	for _, q := range quotas {
+		for _, name := range resources {
+			hard, ok := q.Status.Hard[name]
+			if !ok || hard.IsZero() {
+				continue
+			}
+			used := q.Status.Used[name]
+			ratio := float64(used.MilliValue()) / float64(hard.MilliValue())
+			if ratio*100 < float64(threshold) || ratio <= mostRatio {
+				continue
+			}
+			mostRatio = ratio
+			most = fmt.Sprintf("%s %s of %s used in ResourceQuota %s", name, used.String(), hard.String(), q.Name)
+		}
+	}
+	return most, most != ""
+}
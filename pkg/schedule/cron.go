@@ -0,0 +1,258 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package schedule parses cron expressions with IANA time zones and
+// evaluates the deletion windows built from them.
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cron is a parsed standard five field cron expression (minute, hour, day of
+// month, month, day of week) evaluated in a time zone.
+type Cron struct {
+	minute, hour, dom, month, dow uint64
+
+	// domStar and dowStar record an unrestricted day field; when both day
+	// fields are restricted a day matches either of them, as in cron(8).
+	domStar, dowStar bool
+
+	// Location is the time zone the expression is evaluated in.
+	Location *time.Location
+
+	expr string
+}
+
+type bounds struct {
+	name     string
+	min, max uint
+	names    map[string]uint
+}
+
+var (
+	minutes = bounds{name: "minute", min: 0, max: 59}
+	hours   = bounds{name: "hour", min: 0, max: 23}
+	doms    = bounds{name: "day of month", min: 1, max: 31}
+	months  = bounds{name: "month", min: 1, max: 12, names: map[string]uint{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	// Day of week 7 is accepted as an alias of Sunday.
+	dows = bounds{name: "day of week", min: 0, max: 7, names: map[string]uint{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+
+	macros = map[string]string{
+		"@yearly":   "0 0 1 1 *",
+		"@annually": "0 0 1 1 *",
+		"@monthly":  "0 0 1 * *",
+		"@weekly":   "0 0 * * 0",
+		"@daily":    "0 0 * * *",
+		"@midnight": "0 0 * * *",
+		"@hourly":   "0 * * * *",
+	}
+)
+
+// ParseCron parses a cron expression. The expression may be prefixed with
+// CRON_TZ=<zone> or TZ=<zone> to evaluate it in an IANA time zone, otherwise
+// defaultLocation is used. The @yearly, @monthly, @weekly, @daily and
+// @hourly macros are supported as well as month and day names.
+func ParseCron(expr string, defaultLocation *time.Location) (*Cron, error) {
+	spec := strings.TrimSpace(expr)
+	if spec == "" {
+		return nil, fmt.Errorf("empty cron expression")
+	}
+
+	loc := defaultLocation
+	if loc == nil {
+		loc = time.UTC
+	}
+	if strings.HasPrefix(spec, "CRON_TZ=") || strings.HasPrefix(spec, "TZ=") {
+		i := strings.IndexAny(spec, " \t")
+		if i < 0 {
+			return nil, fmt.Errorf("cron expression %q: missing fields after the time zone", expr)
+		}
+		zone := spec[strings.Index(spec, "=")+1 : i]
+		var err error
+		if loc, err = LoadLocation(zone); err != nil {
+			return nil, fmt.Errorf("cron expression %q: %v", expr, err)
+		}
+		spec = strings.TrimSpace(spec[i:])
+	}
+	if m, ok := macros[strings.ToLower(spec)]; ok {
+		spec = m
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression %q: expected 5 fields (minute hour day-of-month month day-of-week), found %d", expr, len(fields))
+	}
+
+	c := &Cron{Location: loc, expr: strings.TrimSpace(expr)}
+	for _, f := range []struct {
+		raw    string
+		bounds bounds
+		bits   *uint64
+	}{
+		{fields[0], minutes, &c.minute},
+		{fields[1], hours, &c.hour},
+		{fields[2], doms, &c.dom},
+		{fields[3], months, &c.month},
+		{fields[4], dows, &c.dow},
+	} {
+		bits, err := parseField(f.raw, f.bounds)
+		if err != nil {
+			return nil, fmt.Errorf("cron expression %q: %v", expr, err)
+		}
+		*f.bits = bits
+	}
+	// Fold Sunday as 7 onto 0.
+	if c.dow&(1<<7) != 0 {
+		c.dow = c.dow&^(1<<7) | 1
+	}
+	// As in cron(8), a field starting with "*" is unrestricted even with a
+	// step, e.g. "*/2".
+	c.domStar = strings.HasPrefix(fields[2], "*") || fields[2] == "?"
+	c.dowStar = strings.HasPrefix(fields[4], "*") || fields[4] == "?"
+
+	return c, nil
+}
+
+// LoadLocation loads an IANA time zone such as "Europe/Berlin".
+func LoadLocation(zone string) (*time.Location, error) {
+	if zone == "" {
+		return nil, fmt.Errorf("empty time zone")
+	}
+	loc, err := time.LoadLocation(zone)
+	if err != nil {
+		return nil, fmt.Errorf("unknown time zone %q, expected an IANA name such as \"Europe/Berlin\"", zone)
+	}
+	return loc, nil
+}
+
+// parseField parses a comma separated list of "*", "a", "a-b" items, each
+// optionally followed by a "/step", into a bit set.
+func parseField(field string, b bounds) (uint64, error) {
+	var bits uint64
+	for _, item := range strings.Split(field, ",") {
+		rng, step := item, uint(1)
+		if i := strings.Index(item, "/"); i >= 0 {
+			rng = item[:i]
+			s, err := strconv.ParseUint(item[i+1:], 10, 8)
+			if err != nil || s == 0 {
+				return 0, fmt.Errorf("%s field %q: invalid step %q", b.name, field, item[i+1:])
+			}
+			step = uint(s)
+		}
+
+		var lo, hi uint
+		switch {
+		case rng == "*" || rng == "?":
+			lo, hi = b.min, b.max
+		case strings.Contains(rng, "-"):
+			parts := strings.SplitN(rng, "-", 2)
+			var err error
+			if lo, err = parseValue(parts[0], b); err != nil {
+				return 0, fmt.Errorf("%s field %q: %v", b.name, field, err)
+			}
+			if hi, err = parseValue(parts[1], b); err != nil {
+				return 0, fmt.Errorf("%s field %q: %v", b.name, field, err)
+			}
+			if lo > hi {
+				return 0, fmt.Errorf("%s field %q: range %q starts after it ends", b.name, field, rng)
+			}
+		default:
+			v, err := parseValue(rng, b)
+			if err != nil {
+				return 0, fmt.Errorf("%s field %q: %v", b.name, field, err)
+			}
+			lo, hi = v, v
+			if strings.Contains(item, "/") {
+				hi = b.max
+			}
+		}
+
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+func parseValue(raw string, b bounds) (uint, error) {
+	if v, ok := b.names[strings.ToLower(raw)]; ok {
+		return v, nil
+	}
+	v, err := strconv.ParseUint(raw, 10, 8)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", raw)
+	}
+	if uint(v) < b.min || uint(v) > b.max {
+		return 0, fmt.Errorf("value %d out of range %d-%d", v, b.min, b.max)
+	}
+	return uint(v), nil
+}
+
+// String returns the expression the Cron was parsed from.
+func (c *Cron) String() string {
+	return c.expr
+}
+
+// Next returns the first time after t the expression matches, or the zero
+// time if it never matches within five years (e.g. "0 0 30 2 *").
+func (c *Cron) Next(t time.Time) time.Time {
+	t = t.In(c.Location).Truncate(time.Minute).Add(time.Minute)
+	limit := t.Year() + 5
+
+	for t.Year() <= limit {
+		if c.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, c.Location)
+			continue
+		}
+		if !c.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, c.Location)
+			continue
+		}
+		if c.hour&(1<<uint(t.Hour())) == 0 {
+			next := time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, c.Location)
+			if !next.After(t) {
+				// A daylight saving transition repeated the hour.
+				next = t.Truncate(time.Hour).Add(time.Hour)
+			}
+			t = next
+			continue
+		}
+		if c.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+func (c *Cron) dayMatches(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.domStar || c.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
@@ -0,0 +1,169 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func mustLoad(t *testing.T, zone string) *time.Location {
+	t.Helper()
+	loc, err := LoadLocation(zone)
+	if err != nil {
+		t.Fatalf("LoadLocation(%s) = %v", zone, err)
+	}
+	return loc
+}
+
+func TestParseCron(t *testing.T) {
+	tests := []struct {
+		expr    string
+		wantErr bool
+	}{
+		{expr: "0 22 * * mon-fri"},
+		{expr: "*/15 0-6 1,15 jan-mar *"},
+		{expr: "CRON_TZ=Europe/Berlin 0 1 * * sat,sun"},
+		{expr: "TZ=America/New_York @daily"},
+		{expr: "0 0 * * 7"},
+		{expr: "", wantErr: true},
+		{expr: "0 0 * *", wantErr: true},
+		{expr: "60 0 * * *", wantErr: true},
+		{expr: "0 0 0 * *", wantErr: true},
+		{expr: "0 5-1 * * *", wantErr: true},
+		{expr: "*/0 * * * *", wantErr: true},
+		{expr: "0 0 * foo *", wantErr: true},
+		{expr: "CRON_TZ=Mars/Olympus 0 0 * * *", wantErr: true},
+		{expr: "CRON_TZ=UTC", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			_, err := ParseCron(tt.expr, time.UTC)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseCron(%q) = %v, want error %v", tt.expr, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	berlin := mustLoad(t, "Europe/Berlin")
+	tests := []struct {
+		name string
+		expr string
+		from time.Time
+		want time.Time
+	}{{
+		name: "later the same day",
+		expr: "0 22 * * *",
+		from: time.Date(2019, 8, 1, 12, 0, 0, 0, time.UTC),
+		want: time.Date(2019, 8, 1, 22, 0, 0, 0, time.UTC),
+	}, {
+		name: "strictly after",
+		expr: "0 22 * * *",
+		from: time.Date(2019, 8, 1, 22, 0, 0, 0, time.UTC),
+		want: time.Date(2019, 8, 2, 22, 0, 0, 0, time.UTC),
+	}, {
+		name: "steps",
+		expr: "*/20 * * * *",
+		from: time.Date(2019, 8, 1, 12, 41, 30, 0, time.UTC),
+		want: time.Date(2019, 8, 1, 13, 0, 0, 0, time.UTC),
+	}, {
+		name: "next month",
+		expr: "0 0 1 * *",
+		from: time.Date(2019, 8, 1, 12, 0, 0, 0, time.UTC),
+		want: time.Date(2019, 9, 1, 0, 0, 0, 0, time.UTC),
+	}, {
+		name: "next year",
+		expr: "0 0 1 jan *",
+		from: time.Date(2019, 8, 1, 12, 0, 0, 0, time.UTC),
+		want: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+	}, {
+		name: "weekdays",
+		expr: "0 9 * * mon-fri",
+		// Friday 2019-08-02, 10:00.
+		from: time.Date(2019, 8, 2, 10, 0, 0, 0, time.UTC),
+		want: time.Date(2019, 8, 5, 9, 0, 0, 0, time.UTC),
+	}, {
+		name: "sunday as 7",
+		expr: "0 0 * * 7",
+		from: time.Date(2019, 8, 1, 12, 0, 0, 0, time.UTC),
+		want: time.Date(2019, 8, 4, 0, 0, 0, 0, time.UTC),
+	}, {
+		name: "both days restricted match either",
+		expr: "0 0 13 * fri",
+		// Friday 2019-08-02 comes before the 13th.
+		from: time.Date(2019, 8, 1, 12, 0, 0, 0, time.UTC),
+		want: time.Date(2019, 8, 2, 0, 0, 0, 0, time.UTC),
+	}, {
+		name: "day of week step is unrestricted",
+		expr: "0 0 13 * */2",
+		// Only the 13th: */2 does not restrict the days like "*".
+		from: time.Date(2019, 8, 1, 12, 0, 0, 0, time.UTC),
+		want: time.Date(2019, 8, 13, 0, 0, 0, 0, time.UTC),
+	}, {
+		name: "day of month step is unrestricted",
+		expr: "0 0 */2 * mon",
+		// Only Mondays, not every other day.
+		from: time.Date(2019, 8, 1, 12, 0, 0, 0, time.UTC),
+		want: time.Date(2019, 8, 5, 0, 0, 0, 0, time.UTC),
+	}, {
+		name: "never",
+		expr: "0 0 30 feb *",
+		from: time.Date(2019, 8, 1, 12, 0, 0, 0, time.UTC),
+		want: time.Time{},
+	}, {
+		name: "time zone",
+		expr: "CRON_TZ=Europe/Berlin 0 22 * * *",
+		from: time.Date(2019, 8, 1, 12, 0, 0, 0, time.UTC),
+		want: time.Date(2019, 8, 1, 20, 0, 0, 0, time.UTC),
+	}, {
+		name: "hour skipped by the switch to summer time",
+		expr: "CRON_TZ=Europe/Berlin 30 2 * * *",
+		from: time.Date(2019, 3, 31, 0, 0, 0, 0, berlin),
+		want: time.Date(2019, 4, 1, 2, 30, 0, 0, berlin),
+	}, {
+		name: "after the switch to summer time",
+		expr: "CRON_TZ=Europe/Berlin 0 3 * * *",
+		from: time.Date(2019, 3, 31, 0, 0, 0, 0, berlin),
+		// 03:00 CEST, an hour after 01:00 UTC.
+		want: time.Date(2019, 3, 31, 1, 0, 0, 0, time.UTC),
+	}, {
+		name: "hour repeated by the switch to winter time",
+		expr: "CRON_TZ=Europe/Berlin 30 2 * * *",
+		// 02:30 CEST passed, 02:30 CET matches again.
+		from: time.Date(2019, 10, 27, 0, 30, 0, 0, time.UTC),
+		want: time.Date(2019, 10, 27, 1, 30, 0, 0, time.UTC),
+	}, {
+		name: "hours after the switch to winter time",
+		expr: "CRON_TZ=Europe/Berlin 0 * * * *",
+		// From 02:00 CET, the next hour is 03:00 CET.
+		from: time.Date(2019, 10, 27, 1, 0, 0, 0, time.UTC),
+		want: time.Date(2019, 10, 27, 2, 0, 0, 0, time.UTC),
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := ParseCron(tt.expr, time.UTC)
+			if err != nil {
+				t.Fatalf("ParseCron(%q) = %v", tt.expr, err)
+			}
+			if got := c.Next(tt.from); !got.Equal(tt.want) {
+				t.Errorf("Next(%v) = %v, want %v", tt.from, got, tt.want)
+			}
+		})
+	}
+}
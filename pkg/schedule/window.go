@@ -0,0 +1,143 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package schedule
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ghodss/yaml"
+)
+
+// Window is a recurring period that starts whenever its cron expression
+// matches and lasts for Duration.
+type Window struct {
+	Cron     *Cron
+	Duration time.Duration
+}
+
+// windowSpec is the serialized form of a Window.
+type windowSpec struct {
+	Schedule string `json:"schedule"`
+	TimeZone string `json:"timeZone,omitempty"`
+	Duration string `json:"duration"`
+}
+
+// Windows is a set of windows, active when any of them is.
+type Windows []Window
+
+// ParseWindows parses a YAML list of windows such as:
+//
+//   - schedule: "0 22 * * mon-fri"
+//     timeZone: Europe/Berlin
+//     duration: 4h
+//   - schedule: "CRON_TZ=America/New_York 0 1 * * sat,sun"
+//     duration: 6h
+//
+// The timeZone is an IANA name and defaults to UTC; it cannot be combined
+// with a CRON_TZ= prefix in the schedule. Errors name the offending window.
+func ParseWindows(raw string) (Windows, error) {
+	var specs []windowSpec
+	if err := yaml.Unmarshal([]byte(raw), &specs); err != nil {
+		return nil, fmt.Errorf("expected a list of windows with schedule, timeZone and duration: %v", err)
+	}
+
+	windows := make(Windows, 0, len(specs))
+	for i, spec := range specs {
+		w, err := parseWindow(spec)
+		if err != nil {
+			return nil, fmt.Errorf("window %d: %v", i+1, err)
+		}
+		windows = append(windows, w)
+	}
+	return windows, nil
+}
+
+func parseWindow(spec windowSpec) (Window, error) {
+	if spec.Schedule == "" {
+		return Window{}, fmt.Errorf("missing schedule")
+	}
+	loc := time.UTC
+	if spec.TimeZone != "" {
+		s := strings.TrimSpace(spec.Schedule)
+		if strings.HasPrefix(s, "CRON_TZ=") || strings.HasPrefix(s, "TZ=") {
+			return Window{}, fmt.Errorf("timeZone %q conflicts with the time zone in schedule %q", spec.TimeZone, spec.Schedule)
+		}
+		var err error
+		if loc, err = LoadLocation(spec.TimeZone); err != nil {
+			return Window{}, err
+		}
+	}
+	cron, err := ParseCron(spec.Schedule, loc)
+	if err != nil {
+		return Window{}, err
+	}
+	if cron.Next(time.Now()).IsZero() {
+		return Window{}, fmt.Errorf("schedule %q never matches", spec.Schedule)
+	}
+
+	if spec.Duration == "" {
+		return Window{}, fmt.Errorf("missing duration")
+	}
+	d, err := time.ParseDuration(spec.Duration)
+	if err != nil {
+		return Window{}, fmt.Errorf("invalid duration %q: %v", spec.Duration, err)
+	}
+	if d < time.Minute {
+		return Window{}, fmt.Errorf("duration %s must be at least 1m", d)
+	}
+	return Window{Cron: cron, Duration: d}, nil
+}
+
+// Active reports whether the window is open at now.
+func (w Window) Active(now time.Time) bool {
+	// Any start that still covers now lies after now-Duration.
+	start := w.Cron.Next(now.Add(-w.Duration))
+	return !start.IsZero() && !start.After(now) && now.Before(start.Add(w.Duration))
+}
+
+// Active reports whether any of the windows is open at now.
+func (ws Windows) Active(now time.Time) bool {
+	for _, w := range ws {
+		if w.Active(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// NextStart returns the earliest time after now one of the windows opens, or
+// the zero time if none does.
+func (ws Windows) NextStart(now time.Time) time.Time {
+	var next time.Time
+	for _, w := range ws {
+		if t := w.Cron.Next(now); !t.IsZero() && (next.IsZero() || t.Before(next)) {
+			next = t
+		}
+	}
+	return next
+}
+
+// String returns a short description of the windows for events and logs.
+func (ws Windows) String() string {
+	parts := make([]string, 0, len(ws))
+	for _, w := range ws {
+		parts = append(parts, fmt.Sprintf("%s (%s) for %s", w.Cron, w.Cron.Location, w.Duration))
+	}
+	return strings.Join(parts, ", ")
+}
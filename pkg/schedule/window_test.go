@@ -0,0 +1,198 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseWindows(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		want    int
+		wantErr bool
+	}{{
+		name: "windows",
+		raw: `
+- schedule: "0 22 * * mon-fri"
+  timeZone: Europe/Berlin
+  duration: 4h
+- schedule: "CRON_TZ=America/New_York 0 1 * * sat,sun"
+  duration: 6h`,
+		want: 2,
+	}, {
+		name: "empty",
+		raw:  "",
+	}, {
+		name:    "not a list",
+		raw:     "schedule: 0 22 * * *",
+		wantErr: true,
+	}, {
+		name:    "missing schedule",
+		raw:     "- duration: 4h",
+		wantErr: true,
+	}, {
+		name:    "missing duration",
+		raw:     `- schedule: "0 22 * * *"`,
+		wantErr: true,
+	}, {
+		name:    "short duration",
+		raw:     "- schedule: \"0 22 * * *\"\n  duration: 30s",
+		wantErr: true,
+	}, {
+		name:    "two time zones",
+		raw:     "- schedule: \"CRON_TZ=UTC 0 22 * * *\"\n  timeZone: Europe/Berlin\n  duration: 4h",
+		wantErr: true,
+	}, {
+		name:    "never matches",
+		raw:     "- schedule: \"0 0 30 feb *\"\n  duration: 4h",
+		wantErr: true,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			windows, err := ParseWindows(tt.raw)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseWindows() = %v, want error %v", err, tt.wantErr)
+			}
+			if len(windows) != tt.want {
+				t.Errorf("ParseWindows() = %d windows, want %d", len(windows), tt.want)
+			}
+		})
+	}
+}
+
+func TestWindowActive(t *testing.T) {
+	berlin := mustLoad(t, "Europe/Berlin")
+	window := func(expr string, d time.Duration) Window {
+		c, err := ParseCron(expr, time.UTC)
+		if err != nil {
+			t.Fatalf("ParseCron(%q) = %v", expr, err)
+		}
+		return Window{Cron: c, Duration: d}
+	}
+	nightly := window("0 22 * * *", 4*time.Hour)
+
+	tests := []struct {
+		name   string
+		window Window
+		at     time.Time
+		want   bool
+	}{{
+		name:   "before",
+		window: nightly,
+		at:     time.Date(2019, 8, 1, 21, 59, 0, 0, time.UTC),
+	}, {
+		name:   "at the start",
+		window: nightly,
+		at:     time.Date(2019, 8, 1, 22, 0, 0, 0, time.UTC),
+		want:   true,
+	}, {
+		name:   "past midnight",
+		window: nightly,
+		at:     time.Date(2019, 8, 2, 1, 59, 59, 0, time.UTC),
+		want:   true,
+	}, {
+		name:   "at the end",
+		window: nightly,
+		at:     time.Date(2019, 8, 2, 2, 0, 0, 0, time.UTC),
+	}, {
+		name:   "weekdays only",
+		window: window("0 22 * * mon-fri", 4*time.Hour),
+		// Saturday 2019-08-03.
+		at: time.Date(2019, 8, 3, 23, 0, 0, 0, time.UTC),
+	}, {
+		name:   "opened the day before",
+		window: window("0 22 * * fri", 4*time.Hour),
+		// Saturday 2019-08-03, the window opened on Friday.
+		at:   time.Date(2019, 8, 3, 1, 0, 0, 0, time.UTC),
+		want: true,
+	}, {
+		name:   "local time zone",
+		window: window("CRON_TZ=Europe/Berlin 0 1 * * *", time.Hour),
+		at:     time.Date(2019, 8, 1, 23, 30, 0, 0, time.UTC),
+		want:   true,
+	}, {
+		name:   "switch to summer time",
+		window: window("CRON_TZ=Europe/Berlin 0 1 * * *", 2*time.Hour),
+		// 03:30 CEST, an hour and a half after 01:00 CET.
+		at:   time.Date(2019, 3, 31, 3, 30, 0, 0, berlin),
+		want: true,
+	}, {
+		name:   "closed after the switch to summer time",
+		window: window("CRON_TZ=Europe/Berlin 0 1 * * *", 2*time.Hour),
+		// 04:00 CEST, two hours after 01:00 CET.
+		at: time.Date(2019, 3, 31, 4, 0, 0, 0, berlin),
+	}, {
+		name:   "during the switch to winter time",
+		window: window("CRON_TZ=Europe/Berlin 0 1 * * *", 2*time.Hour),
+		// 02:30 CEST, an hour and a half after 01:00 CEST.
+		at:   time.Date(2019, 10, 27, 0, 30, 0, 0, time.UTC),
+		want: true,
+	}, {
+		name:   "switch to winter time",
+		window: window("CRON_TZ=Europe/Berlin 0 1 * * *", 2*time.Hour),
+		// 02:30 CET, two and a half hours after 01:00 CEST.
+		at: time.Date(2019, 10, 27, 1, 30, 0, 0, time.UTC),
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.window.Active(tt.at); got != tt.want {
+				t.Errorf("Active(%v) = %v, want %v", tt.at, got, tt.want)
+			}
+			if got := (Windows{tt.window}).Active(tt.at); got != tt.want {
+				t.Errorf("Windows.Active(%v) = %v, want %v", tt.at, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWindowsNextStart(t *testing.T) {
+	windows, err := ParseWindows(`
+- schedule: "0 22 * * *"
+  duration: 4h
+- schedule: "0 6 * * sat"
+  duration: 2h`)
+	if err != nil {
+		t.Fatalf("ParseWindows() = %v", err)
+	}
+	tests := []struct {
+		name string
+		now  time.Time
+		want time.Time
+	}{{
+		name: "the nightly window",
+		now:  time.Date(2019, 8, 1, 12, 0, 0, 0, time.UTC),
+		want: time.Date(2019, 8, 1, 22, 0, 0, 0, time.UTC),
+	}, {
+		name: "the weekend window",
+		// Saturday 2019-08-03.
+		now:  time.Date(2019, 8, 3, 2, 0, 0, 0, time.UTC),
+		want: time.Date(2019, 8, 3, 6, 0, 0, 0, time.UTC),
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := windows.NextStart(tt.now); !got.Equal(tt.want) {
+				t.Errorf("NextStart(%v) = %v, want %v", tt.now, got, tt.want)
+			}
+		})
+	}
+	if got := (Windows{}).NextStart(time.Now()); !got.IsZero() {
+		t.Errorf("NextStart() without windows = %v, want zero", got)
+	}
+}
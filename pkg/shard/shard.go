@@ -0,0 +1,430 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package shard spreads the namespaces over the replicas of the controller
+// in active-active mode. Every replica renews a member Lease in the system
+// namespace, and every namespace is assigned to one of the live members by
+// rendezvous hashing. The assigned replica holds a Lease for the namespace
+// and is the only one reconciling its Services. When a replica dies its
+// member Lease expires, the assignment moves its namespaces to the others,
+// and they take over the namespace Leases once those expired. A replica
+// joining takes over its share from the replicas releasing it.
+package shard
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/knative-sample/revision-controller/pkg/apicall"
+	"github.com/knative-sample/revision-controller/pkg/clock"
+	"go.uber.org/zap"
+	coordinationv1beta1 "k8s.io/api/coordination/v1beta1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// RoleLabelKey marks the Leases of the active-active mode with their
+	// role, RoleMember or RoleNamespace.
+	RoleLabelKey = "revision-gc.knative.dev/shard-role"
+	// NamespaceLabelKey names the namespace of a namespace Lease.
+	NamespaceLabelKey = "revision-gc.knative.dev/shard-namespace"
+
+	// RoleMember marks the Lease a replica renews while it is alive.
+	RoleMember = "member"
+	// RoleNamespace marks the Lease held by the replica reconciling a
+	// namespace.
+	RoleNamespace = "namespace"
+)
+
+// leaseSelector selects the Leases of the active-active mode.
+var leaseSelector = func() labels.Selector {
+	r, err := labels.NewRequirement(RoleLabelKey, selection.Exists, nil)
+	if err != nil {
+		panic(err)
+	}
+	return labels.NewSelector().Add(*r)
+}()
+
+// Options configures the active-active mode.
+type Options struct {
+	// Enabled turns the active-active mode on.
+	Enabled bool
+	// Identity identifies the replica, e.g. its pod name. The hostname is
+	// used when empty.
+	Identity string
+	// LeaseDuration is how long a Lease is valid after its last renewal was
+	// observed.
+	LeaseDuration time.Duration
+	// RenewInterval is how often the Leases are renewed and the namespaces
+	// assigned again.
+	RenewInterval time.Duration
+}
+
+// Coordinator holds the namespace Leases of this replica.
+type Coordinator struct {
+	logger     *zap.SugaredLogger
+	client     kubernetes.Interface
+	namespace  string
+	options    Options
+	namespaces func() ([]string, error)
+	clock      clock.Clock
+
+	// observed holds when the current version of every Lease was first
+	// seen, on the local clock; only sync and release use it
+	observed map[string]observation
+
+	mu        sync.RWMutex
+	owned     map[string]time.Time
+	observers []func(namespace string)
+}
+
+// New returns the Coordinator of the replica, renewing its Leases in
+// namespace, the system namespace, until stopCh is closed. namespaces lists
+// the namespaces to spread. It returns nil when the active-active mode is
+// disabled.
+func New(logger *zap.SugaredLogger, client kubernetes.Interface, options Options, namespace string, namespaces func() ([]string, error), stopCh <-chan struct{}) (*Coordinator, error) {
+	if !options.Enabled {
+		return nil, nil
+	}
+	if options.Identity == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("active-active mode needs an identity: %v", err)
+		}
+		options.Identity = hostname
+	}
+	if options.RenewInterval <= 0 || options.LeaseDuration <= options.RenewInterval {
+		return nil, fmt.Errorf("shard lease duration %s must be longer than the renew interval %s", options.LeaseDuration, options.RenewInterval)
+	}
+	c := &Coordinator{
+		logger:     logger,
+		client:     client,
+		namespace:  namespace,
+		options:    options,
+		namespaces: namespaces,
+		clock:      clock.Real,
+		observed:   make(map[string]observation),
+		owned:      make(map[string]time.Time),
+	}
+	go c.run(stopCh)
+	return c, nil
+}
+
+// Owns reports whether this replica reconciles namespace. Every namespace is
+// owned when the Coordinator is nil. A namespace is given up one renew
+// interval before its Lease expires, so it is never reconciled by two
+// replicas even when a renewal fails.
+func (c *Coordinator) Owns(namespace string) bool {
+	if c == nil {
+		return true
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.clock.Now().Before(c.owned[namespace])
+}
+
+// OnAcquire registers f to be called with every namespace this replica
+// takes over, e.g. to enqueue its Services.
+func (c *Coordinator) OnAcquire(f func(namespace string)) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.observers = append(c.observers, f)
+}
+
+// run assigns the namespaces every renew interval until stopCh is closed.
+// The namespace Leases are released on the way out so the other replicas
+// take over without waiting for them to expire.
+func (c *Coordinator) run(stopCh <-chan struct{}) {
+	ticker := time.NewTicker(c.options.RenewInterval)
+	defer ticker.Stop()
+	for {
+		if err := c.sync(); err != nil {
+			c.logger.Errorf("shard coordinator: %s sync error:%s", c.options.Identity, err.Error())
+		}
+		select {
+		case <-stopCh:
+			c.release()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sync renews the member Lease, then acquires, renews or releases the
+// namespace Leases according to the assignment over the live members.
+func (c *Coordinator) sync() error {
+	ctx, cancel := context.WithTimeout(context.Background(), c.options.RenewInterval)
+	defer cancel()
+	ctx = apicall.WithTimeout(ctx, c.options.RenewInterval/2)
+
+	now := c.clock.Now()
+	leases, err := apicall.ListLeases(ctx, c.client, c.namespace, leaseSelector)
+	if err != nil {
+		return err
+	}
+	c.observe(leases.Items, now)
+	var self *coordinationv1beta1.Lease
+	members := []string{c.options.Identity}
+	byNamespace := make(map[string]*coordinationv1beta1.Lease)
+	for i := range leases.Items {
+		lease := &leases.Items[i]
+		switch lease.Labels[RoleLabelKey] {
+		case RoleMember:
+			if holder(lease) == c.options.Identity {
+				self = lease
+			} else if !c.expired(lease, now) {
+				members = append(members, holder(lease))
+			}
+		case RoleNamespace:
+			byNamespace[lease.Labels[NamespaceLabelKey]] = lease
+		}
+	}
+	if _, err := c.hold(ctx, self, memberLeaseName(c.options.Identity), map[string]string{RoleLabelKey: RoleMember}, now); err != nil {
+		return fmt.Errorf("renew member lease: %v", err)
+	}
+
+	namespaces, err := c.namespaces()
+	if err != nil {
+		return err
+	}
+	until := now.Add(c.options.LeaseDuration - c.options.RenewInterval)
+	owned := make(map[string]time.Time)
+	var acquired []string
+	for _, ns := range namespaces {
+		lease := byNamespace[ns]
+		delete(byNamespace, ns)
+		mine := lease != nil && holder(lease) == c.options.Identity && !c.expired(lease, now)
+		if assign(members, ns) != c.options.Identity {
+			if mine {
+				// Hand the namespace over to its new replica.
+				c.logger.Infof("shard coordinator: %s releasing namespace %s", c.options.Identity, ns)
+				if err := c.releaseLease(ctx, lease); err != nil {
+					c.logger.Errorf("shard coordinator: %s release namespace %s error:%s", c.options.Identity, ns, err.Error())
+				}
+			}
+			continue
+		}
+		if lease != nil && !mine && holder(lease) != "" && !c.expired(lease, now) {
+			// Wait for the previous replica to release or lose it.
+			continue
+		}
+		leaseLabels := map[string]string{RoleLabelKey: RoleNamespace, NamespaceLabelKey: ns}
+		if _, err := c.hold(ctx, lease, namespaceLeaseName(ns), leaseLabels, now); err != nil {
+			c.logger.Errorf("shard coordinator: %s hold namespace %s error:%s", c.options.Identity, ns, err.Error())
+			continue
+		}
+		owned[ns] = until
+		if !c.Owns(ns) {
+			acquired = append(acquired, ns)
+		}
+	}
+	// Drop the Leases of deleted namespaces.
+	for _, lease := range byNamespace {
+		if holder(lease) == c.options.Identity || c.expired(lease, now) {
+			if err := apicall.DeleteLease(ctx, c.client, lease.Namespace, lease.Name); err != nil && !apierrs.IsNotFound(err) {
+				c.logger.Errorf("shard coordinator: %s delete lease %s error:%s", c.options.Identity, lease.Name, err.Error())
+			}
+		}
+	}
+
+	c.mu.Lock()
+	c.owned = owned
+	observers := append([]func(string){}, c.observers...)
+	c.mu.Unlock()
+	if len(acquired) > 0 {
+		c.logger.Infof("shard coordinator: %s acquired %d namespaces, owns %d of %d with %d members", c.options.Identity, len(acquired), len(owned), len(namespaces), len(members))
+	}
+	for _, ns := range acquired {
+		for _, f := range observers {
+			f(ns)
+		}
+	}
+	return nil
+}
+
+// hold creates lease, or takes it over, held by this replica and renewed at
+// now.
+func (c *Coordinator) hold(ctx context.Context, lease *coordinationv1beta1.Lease, name string, leaseLabels map[string]string, now time.Time) (*coordinationv1beta1.Lease, error) {
+	held, err := c.renew(ctx, lease, name, leaseLabels, now)
+	if err != nil {
+		return nil, err
+	}
+	c.observed[held.Name] = observation{resourceVersion: held.ResourceVersion, at: now}
+	return held, nil
+}
+
+// renew writes lease, or creates it, held by this replica and renewed at now.
+func (c *Coordinator) renew(ctx context.Context, lease *coordinationv1beta1.Lease, name string, leaseLabels map[string]string, now time.Time) (*coordinationv1beta1.Lease, error) {
+	renewed := metav1.NewMicroTime(now)
+	seconds := int32(c.options.LeaseDuration / time.Second)
+	if lease == nil {
+		return apicall.CreateLease(ctx, c.client, &coordinationv1beta1.Lease{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: c.namespace, Labels: leaseLabels},
+			Spec: coordinationv1beta1.LeaseSpec{
+				HolderIdentity:       &c.options.Identity,
+				LeaseDurationSeconds: &seconds,
+				AcquireTime:          &renewed,
+				RenewTime:            &renewed,
+			},
+		})
+	}
+	lease = lease.DeepCopy()
+	if holder(lease) != c.options.Identity {
+		transitions := int32(1)
+		if lease.Spec.LeaseTransitions != nil {
+			transitions += *lease.Spec.LeaseTransitions
+		}
+		lease.Spec.HolderIdentity = &c.options.Identity
+		lease.Spec.AcquireTime = &renewed
+		lease.Spec.LeaseTransitions = &transitions
+	}
+	lease.Spec.LeaseDurationSeconds = &seconds
+	lease.Spec.RenewTime = &renewed
+	// A conflict means another replica got there first.
+	return apicall.UpdateLease(ctx, c.client, lease)
+}
+
+// releaseLease clears the holder of lease, held by this replica.
+func (c *Coordinator) releaseLease(ctx context.Context, lease *coordinationv1beta1.Lease) error {
+	lease = lease.DeepCopy()
+	lease.Spec.HolderIdentity = nil
+	_, err := apicall.UpdateLease(ctx, c.client, lease)
+	return err
+}
+
+// release gives up every namespace and the membership of this replica.
+func (c *Coordinator) release() {
+	c.mu.Lock()
+	c.owned = make(map[string]time.Time)
+	c.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.options.RenewInterval)
+	defer cancel()
+	leases, err := apicall.ListLeases(ctx, c.client, c.namespace, leaseSelector)
+	if err != nil {
+		c.logger.Errorf("shard coordinator: %s release error:%s", c.options.Identity, err.Error())
+		return
+	}
+	for i := range leases.Items {
+		lease := &leases.Items[i]
+		if holder(lease) != c.options.Identity {
+			continue
+		}
+		if lease.Labels[RoleLabelKey] == RoleMember {
+			err = apicall.DeleteLease(ctx, c.client, lease.Namespace, lease.Name)
+		} else {
+			err = c.releaseLease(ctx, lease)
+		}
+		if err != nil && !apierrs.IsNotFound(err) {
+			c.logger.Errorf("shard coordinator: %s release lease %s error:%s", c.options.Identity, lease.Name, err.Error())
+		}
+	}
+}
+
+// assign returns the member namespace is assigned to: the one with the
+// highest hash of member and namespace, so a change of members only moves
+// the namespaces of the members that came or went.
+func assign(members []string, namespace string) string {
+	var best string
+	var bestScore uint64
+	for _, m := range members {
+		h := fnv.New64a()
+		h.Write([]byte(m))
+		h.Write([]byte{0})
+		h.Write([]byte(namespace))
+		if score := h.Sum64(); best == "" || score > bestScore {
+			best, bestScore = m, score
+		}
+	}
+	return best
+}
+
+func holder(lease *coordinationv1beta1.Lease) string {
+	if lease.Spec.HolderIdentity == nil {
+		return ""
+	}
+	return *lease.Spec.HolderIdentity
+}
+
+// observation is when a version of a Lease was first seen.
+type observation struct {
+	resourceVersion string
+	at              time.Time
+}
+
+// observe records the Leases listed at now, keeping when the versions seen
+// before were first seen and forgetting the Leases that are gone.
+func (c *Coordinator) observe(leases []coordinationv1beta1.Lease, now time.Time) {
+	observed := make(map[string]observation, len(leases))
+	for i := range leases {
+		lease := &leases[i]
+		o, ok := c.observed[lease.Name]
+		if !ok || o.resourceVersion != lease.ResourceVersion {
+			o = observation{resourceVersion: lease.ResourceVersion, at: now}
+		}
+		observed[lease.Name] = o
+	}
+	c.observed = observed
+}
+
+// expired reports whether lease was not renewed for its duration. Like the
+// leader election of client-go, the duration is measured on the local clock
+// from when the current version of the Lease was first seen, not from its
+// renew time: the other replicas set it on their clocks, which may be skewed
+// from this one. A replica starting thus waits for a full duration before it
+// takes over the Leases of the others.
+func (c *Coordinator) expired(lease *coordinationv1beta1.Lease, now time.Time) bool {
+	if lease.Spec.RenewTime == nil || lease.Spec.LeaseDurationSeconds == nil {
+		return true
+	}
+	o, ok := c.observed[lease.Name]
+	if !ok || o.resourceVersion != lease.ResourceVersion {
+		return false
+	}
+	return !now.Before(o.at.Add(time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second))
+}
+
+func memberLeaseName(identity string) string {
+	return "revision-gc-member-" + identity
+}
+
+func namespaceLeaseName(namespace string) string {
+	return "revision-gc-ns-" + namespace
+}
+
+type coordinatorKey struct{}
+
+// WithCoordinator attaches the Coordinator the controllers consult to ctx.
+func WithCoordinator(ctx context.Context, c *Coordinator) context.Context {
+	return context.WithValue(ctx, coordinatorKey{}, c)
+}
+
+// FromContext returns the Coordinator attached to ctx, nil when none is.
+func FromContext(ctx context.Context) *Coordinator {
+	c, _ := ctx.Value(coordinatorKey{}).(*Coordinator)
+	return c
+}
@@ -0,0 +1,322 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shard
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	coordinationv1beta1 "k8s.io/api/coordination/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+const leasesPath = "/apis/coordination.k8s.io/v1beta1/namespaces/knative-serving/leases"
+
+// leaseServer serves the Leases of the namespace "knative-serving",
+// rejecting updates of stale resource versions.
+type leaseServer struct {
+	mu      sync.Mutex
+	leases  map[string]coordinationv1beta1.Lease
+	version int
+}
+
+func (s *leaseServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	name := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, leasesPath), "/")
+	var lease coordinationv1beta1.Lease
+	switch r.Method {
+	case http.MethodGet:
+		list := &coordinationv1beta1.LeaseList{}
+		for _, l := range s.leases {
+			list.Items = append(list.Items, l)
+		}
+		writeObject(w, list)
+	case http.MethodPost:
+		json.NewDecoder(r.Body).Decode(&lease)
+		if _, ok := s.leases[lease.Name]; ok {
+			writeStatus(w, http.StatusConflict, metav1.StatusReasonAlreadyExists)
+			return
+		}
+		writeObject(w, s.store(lease))
+	case http.MethodPut:
+		json.NewDecoder(r.Body).Decode(&lease)
+		if current, ok := s.leases[name]; !ok || current.ResourceVersion != lease.ResourceVersion {
+			writeStatus(w, http.StatusConflict, metav1.StatusReasonConflict)
+			return
+		}
+		writeObject(w, s.store(lease))
+	case http.MethodDelete:
+		if _, ok := s.leases[name]; !ok {
+			writeStatus(w, http.StatusNotFound, metav1.StatusReasonNotFound)
+			return
+		}
+		delete(s.leases, name)
+		writeObject(w, &metav1.Status{Status: metav1.StatusSuccess})
+	}
+}
+
+// store stores lease at the next resource version.
+func (s *leaseServer) store(lease coordinationv1beta1.Lease) *coordinationv1beta1.Lease {
+	s.version++
+	lease.ResourceVersion = strconv.Itoa(s.version)
+	s.leases[lease.Name] = lease
+	return &lease
+}
+
+// holders returns the holders of the namespace Leases by namespace.
+func (s *leaseServer) holders() map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	holders := map[string]string{}
+	for _, lease := range s.leases {
+		if lease.Labels[RoleLabelKey] == RoleNamespace {
+			holders[lease.Labels[NamespaceLabelKey]] = holder(&lease)
+		}
+	}
+	return holders
+}
+
+func writeStatus(w http.ResponseWriter, code int, reason metav1.StatusReason) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(&metav1.Status{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Status"},
+		Status:   metav1.StatusFailure,
+		Code:     int32(code),
+		Reason:   reason,
+	})
+}
+
+func writeObject(w http.ResponseWriter, obj interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(obj)
+}
+
+// testClock is a Clock moved by the test.
+type testClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *testClock) advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+var namespaces = []string{"ns-0", "ns-1", "ns-2", "ns-3", "ns-4", "ns-5", "ns-6", "ns-7"}
+
+// newCoordinator returns the Coordinator of identity, synced by the test.
+func newCoordinator(t *testing.T, server *httptest.Server, identity string, clk *testClock) *Coordinator {
+	client, err := kubernetes.NewForConfig(&rest.Config{Host: server.URL, QPS: 1000, Burst: 1000})
+	if err != nil {
+		t.Fatalf("NewForConfig() = %v", err)
+	}
+	return &Coordinator{
+		logger:    zap.NewNop().Sugar(),
+		client:    client,
+		namespace: "knative-serving",
+		options: Options{
+			Enabled:       true,
+			Identity:      identity,
+			LeaseDuration: 15 * time.Second,
+			RenewInterval: 5 * time.Second,
+		},
+		namespaces: func() ([]string, error) { return namespaces, nil },
+		clock:      clk,
+		observed:   make(map[string]observation),
+		owned:      make(map[string]time.Time),
+	}
+}
+
+func mustSync(t *testing.T, coordinators ...*Coordinator) {
+	t.Helper()
+	for _, c := range coordinators {
+		if err := c.sync(); err != nil {
+			t.Fatalf("%s sync() = %v", c.options.Identity, err)
+		}
+	}
+}
+
+// owned returns the namespaces c owns.
+func owned(c *Coordinator) []string {
+	var out []string
+	for _, ns := range namespaces {
+		if c.Owns(ns) {
+			out = append(out, ns)
+		}
+	}
+	return out
+}
+
+// assigned returns the namespaces assigned to member out of members.
+func assigned(members []string, member string) []string {
+	var out []string
+	for _, ns := range namespaces {
+		if assign(members, ns) == member {
+			out = append(out, ns)
+		}
+	}
+	return out
+}
+
+func TestAssign(t *testing.T) {
+	members := []string{"a", "b", "c"}
+	counts := map[string]int{}
+	for i := 0; i < 300; i++ {
+		ns := fmt.Sprintf("ns-%d", i)
+		got := assign(members, ns)
+		counts[got]++
+		// The order of the members does not matter.
+		if again := assign([]string{"c", "a", "b"}, ns); again != got {
+			t.Fatalf("assign(%s) = %s and %s by the member order", ns, got, again)
+		}
+		// Losing a member only moves its namespaces.
+		if got != "c" {
+			if after := assign([]string{"a", "b"}, ns); after != got {
+				t.Errorf("assign(%s) moved from %s to %s when c left", ns, got, after)
+			}
+		}
+	}
+	for _, m := range members {
+		if counts[m] < 50 {
+			t.Errorf("assign() gave %d of 300 namespaces to %s, want a share", counts[m], m)
+		}
+	}
+}
+
+func TestCoordinatorHandover(t *testing.T) {
+	s := &leaseServer{leases: map[string]coordinationv1beta1.Lease{}}
+	server := httptest.NewServer(s)
+	defer server.Close()
+	clkA := &testClock{now: time.Date(2019, 8, 1, 12, 0, 0, 0, time.UTC)}
+	// The clock of b is an hour behind, its renew times look long expired
+	// on the clock of a.
+	clkB := &testClock{now: clkA.now.Add(-time.Hour)}
+	a := newCoordinator(t, server, "a", clkA)
+	b := newCoordinator(t, server, "b", clkB)
+	tick := func(d time.Duration) {
+		clkA.advance(d)
+		clkB.advance(d)
+	}
+
+	// a alone holds every namespace.
+	mustSync(t, a)
+	if got := owned(a); !reflect.DeepEqual(got, namespaces) {
+		t.Fatalf("a owns %v, want %v", got, namespaces)
+	}
+
+	// b joins: a releases the share of b, which takes it over.
+	mustSync(t, b)
+	if got := owned(b); got != nil {
+		t.Errorf("b owns %v before a released them, want none", got)
+	}
+	tick(5 * time.Second)
+	mustSync(t, a, b)
+	members := []string{"a", "b"}
+	if got, want := owned(a), assigned(members, "a"); !reflect.DeepEqual(got, want) {
+		t.Errorf("a owns %v, want %v", got, want)
+	}
+	if got, want := owned(b), assigned(members, "b"); !reflect.DeepEqual(got, want) {
+		t.Errorf("b owns %v, want %v", got, want)
+	}
+
+	// b keeps renewing: a does not take its namespaces over despite the
+	// clock of b.
+	for i := 0; i < 6; i++ {
+		tick(5 * time.Second)
+		mustSync(t, a, b)
+	}
+	if got, want := owned(a), assigned(members, "a"); !reflect.DeepEqual(got, want) {
+		t.Errorf("a owns %v while b renews, want %v", got, want)
+	}
+
+	// b dies: its Leases expire one lease duration after a last saw them
+	// renewed, then a takes over.
+	tick(5 * time.Second)
+	mustSync(t, a)
+	if got, want := owned(a), assigned(members, "a"); !reflect.DeepEqual(got, want) {
+		t.Errorf("a owns %v before the Leases of b expired, want %v", got, want)
+	}
+	tick(15 * time.Second)
+	mustSync(t, a, a)
+	if got := owned(a); !reflect.DeepEqual(got, namespaces) {
+		t.Errorf("a owns %v after b died, want %v", got, namespaces)
+	}
+	for ns, h := range s.holders() {
+		if h != "a" {
+			t.Errorf("namespace %s held by %q, want a", ns, h)
+		}
+	}
+}
+
+func TestCoordinatorRelease(t *testing.T) {
+	s := &leaseServer{leases: map[string]coordinationv1beta1.Lease{}}
+	server := httptest.NewServer(s)
+	defer server.Close()
+	clk := &testClock{now: time.Date(2019, 8, 1, 12, 0, 0, 0, time.UTC)}
+	a := newCoordinator(t, server, "a", clk)
+	b := newCoordinator(t, server, "b", clk)
+
+	mustSync(t, a, b)
+	clk.advance(5 * time.Second)
+	mustSync(t, a, b)
+	if got := owned(b); len(got) == 0 {
+		t.Fatalf("b owns no namespace")
+	}
+
+	// b shuts down: a takes over right away, without waiting for the
+	// Leases of b to expire.
+	b.release()
+	if got := owned(b); got != nil {
+		t.Errorf("b owns %v after release, want none", got)
+	}
+	var members []string
+	for name, lease := range s.leases {
+		if lease.Labels[RoleLabelKey] == RoleMember {
+			members = append(members, name)
+		}
+	}
+	sort.Strings(members)
+	if want := []string{memberLeaseName("a")}; !reflect.DeepEqual(members, want) {
+		t.Errorf("member Leases = %v, want %v", members, want)
+	}
+	clk.advance(time.Second)
+	mustSync(t, a)
+	if got := owned(a); !reflect.DeepEqual(got, namespaces) {
+		t.Errorf("a owns %v after b released, want %v", got, namespaces)
+	}
+}
@@ -0,0 +1,73 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package strategy
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"knative.dev/serving/pkg/apis/serving/v1alpha1"
+)
+
+// Digest returns the digest pinned image the revision runs: the digest
+// Serving resolved, or the configured image when it is pinned by digest. It
+// is empty when the digest is unknown.
+func Digest(revision *v1alpha1.Revision) string {
+	if revision.Status.ImageDigest != "" {
+		return revision.Status.ImageDigest
+	}
+	if image := revision.Spec.GetContainer().Image; strings.Contains(image, "@") {
+		return image
+	}
+	return ""
+}
+
+// attested reports whether the revision carries the annotation recording a
+// verified signature or provenance of its image.
+func (p Policy) attested(revision *v1alpha1.Revision) bool {
+	value, ok := revision.Annotations[p.AttestationAnnotation]
+	return ok && value == p.AttestationValue
+}
+
+// keepAttested retains the newest attested candidate of every image digest
+// no retained revision runs, so a known-good image stays available for
+// rollback.
+func keepAttested(policy Policy, result *Result) {
+	kept := make(map[string]bool)
+	for _, d := range result.Retained {
+		if digest := Digest(d.Revision); digest != "" {
+			kept[digest] = true
+		}
+	}
+
+	sortDecisions(result.Candidates)
+	candidates := make([]Decision, 0, len(result.Candidates))
+	for _, d := range result.Candidates {
+		digest := Digest(d.Revision)
+		if digest == "" || kept[digest] || !policy.attested(d.Revision) {
+			candidates = append(candidates, d)
+			continue
+		}
+		kept[digest] = true
+		d.Reason = ReasonAttested
+		d.Message = fmt.Sprintf("last revision running attested image %s", digest)
+		d.EligibleAt = time.Time{}
+		result.Retained = append(result.Retained, d)
+	}
+	result.Candidates = candidates
+}
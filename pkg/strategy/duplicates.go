@@ -0,0 +1,121 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package strategy
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"knative.dev/serving/pkg/apis/serving/v1alpha1"
+)
+
+// Duplicate describes revisions carrying the same generation label, e.g.
+// after a cache anomaly or a manual clone of a revision.
+type Duplicate struct {
+	// Generation is the generation the revisions carry.
+	Generation int
+	// Revisions are the revisions carrying it, the one taken for the
+	// generation first.
+	Revisions []string
+	// Ambiguous lists the revisions that cannot be told apart as the latest
+	// of the generation; they are never deleted.
+	Ambiguous []string
+}
+
+// String describes the anomaly.
+func (d Duplicate) String() string {
+	s := fmt.Sprintf("revisions %s carry generation %d", strings.Join(d.Revisions, ", "), d.Generation)
+	if len(d.Ambiguous) > 0 {
+		s += fmt.Sprintf(", %s cannot be told apart and are retained", strings.Join(d.Ambiguous, ", "))
+	}
+	return s
+}
+
+// duplicateGenerations finds the generations carried by several revisions.
+// Within a generation, the revisions controlled by the Configuration owning
+// the latest revision come first, the most recently created first. The
+// revisions sharing the first rank are ambiguous: which one is the latest of
+// the generation cannot be told.
+func duplicateGenerations(policy Policy, revisions []*v1alpha1.Revision, latest *v1alpha1.Revision) []Duplicate {
+	byGeneration := make(map[int][]*v1alpha1.Revision)
+	for _, re := range revisions {
+		if gen, err := policy.Labels.Generation(re); err == nil {
+			byGeneration[gen] = append(byGeneration[gen], re)
+		}
+	}
+
+	owner := controllerUID(latest)
+	var out []Duplicate
+	for gen, group := range byGeneration {
+		if len(group) < 2 {
+			continue
+		}
+		rank := func(re *v1alpha1.Revision) (bool, time.Time) {
+			return owner != "" && controllerUID(re) == owner, CreatedAt(re)
+		}
+		sort.SliceStable(group, func(i, j int) bool {
+			oi, ci := rank(group[i])
+			oj, cj := rank(group[j])
+			if oi != oj {
+				return oi
+			}
+			if !ci.Equal(cj) {
+				return ci.After(cj)
+			}
+			return newerRevision(group[i], group[j])
+		})
+		d := Duplicate{Generation: gen}
+		firstOwned, firstCreated := rank(group[0])
+		for _, re := range group {
+			d.Revisions = append(d.Revisions, re.Name)
+			if owned, created := rank(re); owned == firstOwned && created.Equal(firstCreated) {
+				d.Ambiguous = append(d.Ambiguous, re.Name)
+			}
+		}
+		if len(d.Ambiguous) < 2 {
+			d.Ambiguous = nil
+		}
+		out = append(out, d)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Generation > out[j].Generation })
+	return out
+}
+
+// controllerUID returns the UID of the controller of the revision, empty
+// when it has none.
+func controllerUID(revision *v1alpha1.Revision) types.UID {
+	if ref := metav1.GetControllerOf(revision); ref != nil {
+		return ref.UID
+	}
+	return ""
+}
+
+// duplicateRanks maps the revisions of the duplicate generations to their
+// rank within the generation, zero for the one taken for it.
+func duplicateRanks(duplicates []Duplicate) map[string]int {
+	ranks := make(map[string]int)
+	for _, d := range duplicates {
+		for i, name := range d.Revisions {
+			ranks[name] = i
+		}
+	}
+	return ranks
+}
@@ -0,0 +1,88 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package strategy
+
+import (
+	"fmt"
+	"time"
+)
+
+// Explanation lists every rule of the policy that currently keeps a revision.
+type Explanation struct {
+	// Decision is the decision Evaluate made for the revision.
+	Decision Decision
+
+	// Candidate reports whether the revision is a deletion candidate.
+	Candidate bool
+
+	// Protections holds every rule keeping the revision, not only the one
+	// the decision reports. It is empty for candidates.
+	Protections []Protection
+}
+
+// Explain evaluates the revisions of a Service like Evaluate and explains the
+// decision made for the named revision.
+func Explain(policy Policy, in Inputs, name string) (*Explanation, error) {
+	policy = policy.underQuotaPressure(in)
+	in.Revisions = trustedGenerations(in.Revisions, in.ConfigurationGeneration)
+	result, err := Evaluate(policy, in)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &Explanation{}
+	found := false
+	for _, d := range result.Candidates {
+		if d.Revision.Name == name {
+			e.Decision, e.Candidate, found = d, true, true
+		}
+	}
+	for _, d := range result.Retained {
+		if d.Revision.Name == name {
+			e.Decision, found = d, true
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("revision %s not found", name)
+	}
+	if e.Candidate {
+		return e, nil
+	}
+
+	d := e.Decision
+	switch {
+	case result.Skipped():
+		// Staleness is unknown while the Service is skipped.
+		e.Protections = []Protection{{d.Reason, "the Service is not evaluated, all of its revisions are retained"}}
+		return e, nil
+	case d.Reason == ReasonRouted, d.Reason == ReasonTrafficTarget, d.Reason == ReasonSpecPinned, d.Reason == ReasonNotStale, d.Reason == ReasonInvalidGeneration:
+		e.Protections = []Protection{{d.Reason, d.Message}}
+		return e, nil
+	}
+
+	e.Protections = protections(policy, in, d.Revision)
+	if d.Reason == ReasonRetainCount || d.Reason == ReasonAttested || d.Reason == ReasonGitTag {
+		e.Protections = append(e.Protections, Protection{d.Reason, d.Message})
+	}
+	age := in.Now.Sub(CreatedAt(d.Revision))
+	if untrusted, skew := agesUntrusted(policy, in); untrusted {
+		e.Protections = append(e.Protections, Protection{ReasonClockSkew, fmt.Sprintf("clock skew %s exceeds %s, the age cannot be trusted", skew.Round(time.Second), policy.MaxClockSkew)})
+	} else if minAge := policy.minStaleAge(); age < minAge {
+		e.Protections = append(e.Protections, Protection{ReasonTooYoung, fmt.Sprintf("age %s is below %s", age.Round(time.Second), minAge)})
+	}
+	return e, nil
+}
@@ -0,0 +1,85 @@
+//go:build go1.18
+// +build go1.18
+
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package strategy
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func FuzzParseGeneration(f *testing.F) {
+	for _, raw := range []string{"0", "1", "-1", "+7", "007", "", "1e3", "9223372036854775808"} {
+		f.Add(raw)
+	}
+	f.Fuzz(func(t *testing.T, raw string) {
+		val, err := parseGeneration(raw)
+		if err != nil {
+			return
+		}
+		if val < 0 {
+			t.Fatalf("parseGeneration(%q) = %d, want non-negative", raw, val)
+		}
+		again, err := parseGeneration(strconv.Itoa(val))
+		if err != nil || again != val {
+			t.Errorf("parseGeneration(%q) = %d, %v, want %d", strconv.Itoa(val), again, err, val)
+		}
+	})
+}
+
+func FuzzLeasedUntil(f *testing.F) {
+	for _, raw := range []string{"2019-08-01T13:00:00Z", "2019-08-01T13:00:00.5+02:00", "9999-12-31T23:59:59-01:00", "tomorrow", ""} {
+		f.Add(raw)
+	}
+	f.Fuzz(func(t *testing.T, raw string) {
+		revision := testRevision("hello-00001", 1, time.Hour)
+		revision.Annotations = map[string]string{LeaseAnnotationKey: raw}
+		until, ok := LeasedUntil(revision)
+		if !ok {
+			return
+		}
+		revision.Annotations[LeaseAnnotationKey] = until.Format(time.RFC3339Nano)
+		again, ok := LeasedUntil(revision)
+		if !ok || !again.Equal(until) {
+			t.Errorf("LeasedUntil(%q) = %v, %v, want %v", revision.Annotations[LeaseAnnotationKey], again, ok, until)
+		}
+	})
+}
+
+func FuzzNoGC(f *testing.F) {
+	for _, raw := range []string{"true", "True", "1", "false", "yes", ""} {
+		f.Add(raw)
+	}
+	f.Fuzz(func(t *testing.T, raw string) {
+		revision := testRevision("hello-00001", 1, time.Hour)
+		revision.Annotations = map[string]string{NoGCAnnotationKey: raw}
+		key, ok := NoGC([]string{"example.com/keep", NoGCAnnotationKey}, revision)
+		if !ok {
+			return
+		}
+		if key != NoGCAnnotationKey {
+			t.Fatalf("NoGC() = %q, want %q", key, NoGCAnnotationKey)
+		}
+		revision.Annotations[NoGCAnnotationKey] = strconv.FormatBool(true)
+		if _, ok := NoGC([]string{NoGCAnnotationKey}, revision); !ok {
+			t.Errorf("NoGC() = false after recording %q, want true", raw)
+		}
+	})
+}
@@ -0,0 +1,108 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package strategy
+
+import (
+	"fmt"
+	"path"
+	"time"
+
+	"knative.dev/serving/pkg/apis/serving/v1alpha1"
+)
+
+// GitMetadata are the annotations the build pipeline records the Git
+// metadata of the source of a revision in.
+type GitMetadata struct {
+	// CommitAnnotation, BranchAnnotation and TagAnnotation are the
+	// annotation keys of the commit SHA, branch and tag the revision was
+	// built from.
+	CommitAnnotation string
+	BranchAnnotation string
+	TagAnnotation    string
+}
+
+// commit returns the commit the revision was built from, shortened, or
+// empty when it is not annotated.
+func (g GitMetadata) commit(revision *v1alpha1.Revision) string {
+	sha := revision.Annotations[g.CommitAnnotation]
+	if len(sha) > 12 {
+		sha = sha[:12]
+	}
+	return sha
+}
+
+// describe returns the Git ref and commit the revision was built from for
+// messages.
+func (g GitMetadata) describe(kind, ref string, revision *v1alpha1.Revision) string {
+	if sha := g.commit(revision); sha != "" {
+		return fmt.Sprintf("%s %s at %s", kind, ref, sha)
+	}
+	return fmt.Sprintf("%s %s", kind, ref)
+}
+
+// matchAny reports whether the value matches one of the path.Match
+// patterns. Malformed patterns, rejected when the configuration is read,
+// match nothing.
+func matchAny(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if ok, err := path.Match(pattern, value); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
+
+// protectedBranch returns the protected branch the revision was built
+// from, false when it was built from none.
+func (p Policy) protectedBranch(revision *v1alpha1.Revision) (string, bool) {
+	if len(p.ProtectedGitBranches) == 0 {
+		return "", false
+	}
+	branch, ok := revision.Annotations[p.Git.BranchAnnotation]
+	if !ok || branch == "" || !matchAny(p.ProtectedGitBranches, branch) {
+		return "", false
+	}
+	return branch, true
+}
+
+// keepGitTags retains the newest candidate of every Git tag matching the
+// policy no retained revision was built from, so every release stays
+// available for rollback.
+func keepGitTags(policy Policy, result *Result) {
+	kept := make(map[string]bool)
+	for _, d := range result.Retained {
+		if tag := d.Revision.Annotations[policy.Git.TagAnnotation]; tag != "" {
+			kept[tag] = true
+		}
+	}
+
+	sortDecisions(result.Candidates)
+	candidates := make([]Decision, 0, len(result.Candidates))
+	for _, d := range result.Candidates {
+		tag := d.Revision.Annotations[policy.Git.TagAnnotation]
+		if tag == "" || kept[tag] || !matchAny(policy.KeepGitTags, tag) {
+			candidates = append(candidates, d)
+			continue
+		}
+		kept[tag] = true
+		d.Reason = ReasonGitTag
+		d.Message = fmt.Sprintf("newest revision built from %s", policy.Git.describe("tag", tag, d.Revision))
+		d.EligibleAt = time.Time{}
+		result.Retained = append(result.Retained, d)
+	}
+	result.Candidates = candidates
+}
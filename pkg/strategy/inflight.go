@@ -0,0 +1,188 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package strategy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"knative.dev/serving/pkg/apis/serving/v1alpha1"
+)
+
+const (
+	// PolicyVersionAnnotationKey is the revision annotation naming the
+	// version of the policy its in-flight protections were last migrated to.
+	PolicyVersionAnnotationKey = "revision-gc.knative.dev/policy-version"
+
+	// InFlightAnnotationKey is the revision annotation holding the
+	// time-bound protections carried over from earlier policies.
+	InFlightAnnotationKey = "revision-gc.knative.dev/in-flight"
+)
+
+// InFlight holds the ends of the time-bound protections of a revision
+// carried over from earlier policies, by reason. A policy change neither
+// ends them early nor restarts them.
+type InFlight map[Reason]time.Time
+
+// timeBound are the reasons whose protection ends by itself, at the
+// EligibleAt of the decision: cooldowns, rollback windows, rollouts and the
+// grace period of the minimum age.
+var timeBound = map[Reason]bool{
+	ReasonActivated:      true,
+	ReasonRollbackWindow: true,
+	ReasonRollout:        true,
+	ReasonTooYoung:       true,
+}
+
+// PolicyVersion returns a short fingerprint of the policy, changing with
+// any of its settings.
+func PolicyVersion(policy Policy) string {
+	raw, _ := json.Marshal(policy)
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:8])
+}
+
+// InFlightOf reads the in-flight protections recorded on the revision.
+// Unreadable records protect nothing.
+func InFlightOf(revision *v1alpha1.Revision) InFlight {
+	raw, ok := revision.Annotations[InFlightAnnotationKey]
+	if !ok {
+		return nil
+	}
+	state := InFlight{}
+	if err := json.Unmarshal([]byte(raw), &state); err != nil {
+		return nil
+	}
+	return state
+}
+
+// InFlightStates reads the in-flight protections recorded on the revisions,
+// by revision name.
+func InFlightStates(revisions []*v1alpha1.Revision) map[string]InFlight {
+	var out map[string]InFlight
+	for _, re := range revisions {
+		if state := InFlightOf(re); len(state) > 0 {
+			if out == nil {
+				out = make(map[string]InFlight)
+			}
+			out[re.Name] = state
+		}
+	}
+	return out
+}
+
+// reasons returns the reasons of the protections unexpired at now, sorted.
+func (s InFlight) reasons(now time.Time) []Reason {
+	var out []Reason
+	for reason, until := range s {
+		if now.Before(until) {
+			out = append(out, reason)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i] < out[j]
+	})
+	return out
+}
+
+// MigrateInFlight migrates the in-flight protections of the revisions from
+// the previous policy to the current one, which replaced it at changedAt.
+// Revisions protected by a time-bound rule started under the previous
+// policy whose protection ends earlier under the current one, or not at
+// all, carry the previous end over. It returns the state to record on the
+// revisions whose state changed, by revision name, expired protections left
+// out.
+func MigrateInFlight(previous, current Policy, changedAt time.Time, in Inputs) (map[string]InFlight, error) {
+	before, err := Evaluate(previous, in)
+	if err != nil {
+		return nil, err
+	}
+	after, err := Evaluate(current, in)
+	if err != nil {
+		return nil, err
+	}
+	ends := make(map[string]time.Time, len(after.Retained))
+	for _, d := range after.Retained {
+		if timeBound[d.Reason] {
+			ends[d.Revision.Name] = d.EligibleAt
+		}
+	}
+
+	var out map[string]InFlight
+	for _, d := range before.Retained {
+		if !timeBound[d.Reason] || !in.Now.Before(d.EligibleAt) || !startedBefore(previous, in, d, changedAt) {
+			continue
+		}
+		if end, ok := ends[d.Revision.Name]; ok && !end.Before(d.EligibleAt) {
+			continue
+		}
+		existing := in.InFlight[d.Revision.Name]
+		if existing[d.Reason].Equal(d.EligibleAt) {
+			continue
+		}
+		state := InFlight{d.Reason: d.EligibleAt}
+		for _, reason := range existing.reasons(in.Now) {
+			if reason != d.Reason {
+				state[reason] = existing[reason]
+			}
+		}
+		if out == nil {
+			out = make(map[string]InFlight)
+		}
+		out[d.Revision.Name] = state
+	}
+	return out, nil
+}
+
+// startedBefore reports whether the time-bound protection of the decision
+// started before t, i.e. was in flight then.
+func startedBefore(policy Policy, in Inputs, d Decision, t time.Time) bool {
+	var start time.Time
+	switch d.Reason {
+	case ReasonActivated:
+		start, _ = ActivatedAt(in.PodAutoscalers, d.Revision)
+	case ReasonRollbackWindow:
+		_, until, _ := rollbackTarget(policy, in)
+		start = until.Add(-policy.RollbackWindow)
+	case ReasonRollout:
+		_, until, _ := rolloutTarget(policy, in)
+		start = until.Add(-in.RolloutDuration)
+	default:
+		start = CreatedAt(d.Revision)
+	}
+	return start.Before(t)
+}
+
+// InFlightMergePatch returns the JSON merge patch recording the in-flight
+// protections of a revision under the policy version.
+func InFlightMergePatch(version string, state InFlight) ([]byte, error) {
+	raw, err := json.Marshal(state)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]interface{}{
+				PolicyVersionAnnotationKey: version,
+				InFlightAnnotationKey:      string(raw),
+			},
+		},
+	})
+}
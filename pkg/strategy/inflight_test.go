@@ -0,0 +1,229 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package strategy
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	autoscalingv1alpha1 "knative.dev/serving/pkg/apis/autoscaling/v1alpha1"
+)
+
+func TestMigrateInFlight(t *testing.T) {
+	// hello-00003 is served and an hour old, hello-00002 a day older and
+	// hello-00001 two days older.
+	route := readyRoute(latestTarget("hello-00003"))
+	cooldown := func(d time.Duration) Policy {
+		p := testPolicy()
+		p.ActivationCooldown = d
+		return p
+	}
+	rollbackWindow := func(d time.Duration) Policy {
+		p := testPolicy()
+		p.RollbackWindow = d
+		return p
+	}
+	minAge := func(d time.Duration) Policy {
+		p := testPolicy()
+		p.MinStaleAge = d
+		return p
+	}
+	activated := []*autoscalingv1alpha1.PodAutoscaler{activatedAutoscaler("hello-00001", now.Add(-time.Hour))}
+
+	tests := []struct {
+		name              string
+		previous, current Policy
+		changedAt         time.Time
+		autoscalers       []*autoscalingv1alpha1.PodAutoscaler
+		inFlight          map[string]InFlight
+		want              map[string]InFlight
+	}{{
+		name:        "activation cooldown shortened",
+		previous:    cooldown(2 * time.Hour),
+		current:     cooldown(30 * time.Minute),
+		changedAt:   now.Add(-10 * time.Minute),
+		autoscalers: activated,
+		want:        map[string]InFlight{"hello-00001": {ReasonActivated: now.Add(time.Hour)}},
+	}, {
+		name:        "activation cooldown removed",
+		previous:    cooldown(2 * time.Hour),
+		current:     testPolicy(),
+		changedAt:   now.Add(-10 * time.Minute),
+		autoscalers: activated,
+		want:        map[string]InFlight{"hello-00001": {ReasonActivated: now.Add(time.Hour)}},
+	}, {
+		name:        "activation cooldown lengthened",
+		previous:    cooldown(2 * time.Hour),
+		current:     cooldown(3 * time.Hour),
+		changedAt:   now.Add(-10 * time.Minute),
+		autoscalers: activated,
+	}, {
+		name:        "activated after the change",
+		previous:    cooldown(2 * time.Hour),
+		current:     cooldown(30 * time.Minute),
+		changedAt:   now.Add(-2 * time.Hour),
+		autoscalers: activated,
+	}, {
+		name:        "activation cooldown over",
+		previous:    cooldown(time.Hour),
+		current:     cooldown(30 * time.Minute),
+		changedAt:   now.Add(-10 * time.Minute),
+		autoscalers: activated,
+	}, {
+		name:      "rollback window shortened",
+		previous:  rollbackWindow(3 * time.Hour),
+		current:   rollbackWindow(30 * time.Minute),
+		changedAt: now.Add(-10 * time.Minute),
+		want:      map[string]InFlight{"hello-00002": {ReasonRollbackWindow: now.Add(2 * time.Hour)}},
+	}, {
+		name:      "rollback window removed",
+		previous:  rollbackWindow(3 * time.Hour),
+		current:   testPolicy(),
+		changedAt: now.Add(-10 * time.Minute),
+		want:      map[string]InFlight{"hello-00002": {ReasonRollbackWindow: now.Add(2 * time.Hour)}},
+	}, {
+		name:      "rollback window started after the change",
+		previous:  rollbackWindow(3 * time.Hour),
+		current:   rollbackWindow(30 * time.Minute),
+		changedAt: now.Add(-2 * time.Hour),
+	}, {
+		name:      "minimum age shortened",
+		previous:  minAge(36 * time.Hour),
+		current:   minAge(time.Hour),
+		changedAt: now.Add(-10 * time.Minute),
+		want:      map[string]InFlight{"hello-00002": {ReasonTooYoung: now.Add(11 * time.Hour)}},
+	}, {
+		name:      "minimum age lengthened",
+		previous:  minAge(36 * time.Hour),
+		current:   minAge(72 * time.Hour),
+		changedAt: now.Add(-10 * time.Minute),
+	}, {
+		name:        "already migrated",
+		previous:    cooldown(2 * time.Hour),
+		current:     cooldown(30 * time.Minute),
+		changedAt:   now.Add(-10 * time.Minute),
+		autoscalers: activated,
+		inFlight:    map[string]InFlight{"hello-00001": {ReasonActivated: now.Add(time.Hour)}},
+	}, {
+		name:        "earlier protections kept, expired ones dropped",
+		previous:    cooldown(2 * time.Hour),
+		current:     cooldown(30 * time.Minute),
+		changedAt:   now.Add(-10 * time.Minute),
+		autoscalers: activated,
+		inFlight: map[string]InFlight{"hello-00001": {
+			ReasonTooYoung:       now.Add(30 * time.Minute),
+			ReasonRollbackWindow: now.Add(-time.Hour),
+		}},
+		want: map[string]InFlight{"hello-00001": {
+			ReasonActivated: now.Add(time.Hour),
+			ReasonTooYoung:  now.Add(30 * time.Minute),
+		}},
+	}, {
+		name:        "held longer by an earlier protection",
+		previous:    cooldown(2 * time.Hour),
+		current:     cooldown(30 * time.Minute),
+		changedAt:   now.Add(-10 * time.Minute),
+		autoscalers: activated,
+		inFlight:    map[string]InFlight{"hello-00001": {ReasonTooYoung: now.Add(5 * time.Hour)}},
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := Inputs{
+				Route:          route,
+				Revisions:      generatedRevisions(3),
+				PodAutoscalers: tt.autoscalers,
+				InFlight:       tt.inFlight,
+				Now:            now,
+			}
+			got, err := MigrateInFlight(tt.previous, tt.current, tt.changedAt, in)
+			if err != nil {
+				t.Fatalf("MigrateInFlight() = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("MigrateInFlight() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInFlightOutlivesPolicyChange(t *testing.T) {
+	// The new policy ends the cooldown of hello-00001 and the rollback
+	// window of hello-00002 already, the migrated protections still hold
+	// them until their end under the old policy.
+	previous := testPolicy()
+	previous.ActivationCooldown = 2 * time.Hour
+	previous.RollbackWindow = 3 * time.Hour
+	current := testPolicy()
+	current.ActivationCooldown = 30 * time.Minute
+	current.RollbackWindow = 30 * time.Minute
+
+	in := Inputs{
+		Route:          readyRoute(latestTarget("hello-00003")),
+		Revisions:      generatedRevisions(3),
+		PodAutoscalers: []*autoscalingv1alpha1.PodAutoscaler{activatedAutoscaler("hello-00001", now.Add(-time.Hour))},
+		Now:            now,
+	}
+	migrated, err := MigrateInFlight(previous, current, now.Add(-10*time.Minute), in)
+	if err != nil {
+		t.Fatalf("MigrateInFlight() = %v", err)
+	}
+	in.InFlight = migrated
+
+	tests := []struct {
+		name string
+		at   time.Time
+		want map[string]Reason
+	}{{
+		name: "right after the change",
+		at:   now,
+		want: map[string]Reason{
+			"hello-00001": ReasonActivated,
+			"hello-00002": ReasonRollbackWindow,
+			"hello-00003": ReasonRouted,
+		},
+	}, {
+		name: "cooldown over",
+		at:   now.Add(time.Hour),
+		want: map[string]Reason{
+			"hello-00001": ReasonStale,
+			"hello-00002": ReasonRollbackWindow,
+			"hello-00003": ReasonRouted,
+		},
+	}, {
+		name: "rollback window over",
+		at:   now.Add(2 * time.Hour),
+		want: map[string]Reason{
+			"hello-00001": ReasonStale,
+			"hello-00002": ReasonStale,
+			"hello-00003": ReasonRouted,
+		},
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := in
+			in.Now = tt.at
+			result, err := Evaluate(current, in)
+			if err != nil {
+				t.Fatalf("Evaluate() = %v", err)
+			}
+			if got := reasons(result); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Evaluate() reasons = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
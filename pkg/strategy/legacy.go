@@ -0,0 +1,74 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package strategy
+
+import (
+	"knative.dev/serving/pkg/apis/serving/v1alpha1"
+)
+
+// The deprecated modes of v1alpha1 Service specs.
+const (
+	LegacyRunLatest = "runLatest"
+	LegacyRelease   = "release"
+	LegacyPinned    = "pinned"
+	LegacyManual    = "manual"
+)
+
+// LegacyMode returns the deprecated mode of the Service spec, empty when
+// the Service uses the inline spec. It is empty for a nil Service.
+func LegacyMode(service *v1alpha1.Service) string {
+	if service == nil {
+		return ""
+	}
+	switch spec := service.Spec; {
+	case spec.DeprecatedRunLatest != nil:
+		return LegacyRunLatest
+	case spec.DeprecatedRelease != nil:
+		return LegacyRelease
+	case spec.DeprecatedPinned != nil:
+		return LegacyPinned
+	case spec.DeprecatedManual != nil:
+		return LegacyManual
+	}
+	return ""
+}
+
+// SpecRevisions returns the revisions the release or pinned mode of the
+// Service spec names, by mode. The Route may not send them traffic yet, or
+// only by tag, but the Service will. The @latest keyword names none.
+func SpecRevisions(service *v1alpha1.Service) map[string]string {
+	var names []string
+	mode := LegacyMode(service)
+	switch mode {
+	case LegacyRelease:
+		names = service.Spec.DeprecatedRelease.Revisions
+	case LegacyPinned:
+		names = []string{service.Spec.DeprecatedPinned.RevisionName}
+	}
+
+	var out map[string]string
+	for _, name := range names {
+		if name == "" || name == v1alpha1.ReleaseLatestRevisionKeyword {
+			continue
+		}
+		if out == nil {
+			out = make(map[string]string, len(names))
+		}
+		out[name] = mode
+	}
+	return out
+}
@@ -0,0 +1,195 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package strategy
+
+import (
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"knative.dev/serving/pkg/apis/serving/v1alpha1"
+)
+
+// legacyService returns the Service "hello" with the spec.
+func legacyService(spec v1alpha1.ServiceSpec) *v1alpha1.Service {
+	return &v1alpha1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "hello"}, Spec: spec}
+}
+
+func TestEvaluateLegacyModes(t *testing.T) {
+	tests := []struct {
+		name           string
+		service        *v1alpha1.Service
+		wantMode       string
+		wantCandidates []string // newest first
+		wantReasons    map[string]Reason
+	}{{
+		name:           "inline",
+		service:        legacyService(v1alpha1.ServiceSpec{}),
+		wantCandidates: []string{"hello-00003", "hello-00002", "hello-00001"},
+		wantReasons:    map[string]Reason{"hello-00004": ReasonRouted},
+	}, {
+		name:           "runLatest",
+		service:        legacyService(v1alpha1.ServiceSpec{DeprecatedRunLatest: &v1alpha1.RunLatestType{}}),
+		wantMode:       LegacyRunLatest,
+		wantCandidates: []string{"hello-00003", "hello-00002", "hello-00001"},
+		wantReasons:    map[string]Reason{"hello-00004": ReasonRouted},
+	}, {
+		name: "release",
+		service: legacyService(v1alpha1.ServiceSpec{DeprecatedRelease: &v1alpha1.ReleaseType{
+			Revisions:      []string{"hello-00002", "hello-00003"},
+			RolloutPercent: 10,
+		}}),
+		wantMode:       LegacyRelease,
+		wantCandidates: []string{"hello-00001"},
+		wantReasons: map[string]Reason{
+			"hello-00002": ReasonSpecPinned,
+			"hello-00003": ReasonSpecPinned,
+			"hello-00004": ReasonRouted,
+		},
+	}, {
+		name: "release of the latest revision",
+		service: legacyService(v1alpha1.ServiceSpec{DeprecatedRelease: &v1alpha1.ReleaseType{
+			Revisions: []string{v1alpha1.ReleaseLatestRevisionKeyword},
+		}}),
+		wantMode:       LegacyRelease,
+		wantCandidates: []string{"hello-00003", "hello-00002", "hello-00001"},
+		wantReasons:    map[string]Reason{"hello-00004": ReasonRouted},
+	}, {
+		name: "release with the latest revision as candidate",
+		service: legacyService(v1alpha1.ServiceSpec{DeprecatedRelease: &v1alpha1.ReleaseType{
+			Revisions:      []string{"hello-00001", v1alpha1.ReleaseLatestRevisionKeyword},
+			RolloutPercent: 50,
+		}}),
+		wantMode:       LegacyRelease,
+		wantCandidates: []string{"hello-00003", "hello-00002"},
+		wantReasons: map[string]Reason{
+			"hello-00001": ReasonSpecPinned,
+			"hello-00004": ReasonRouted,
+		},
+	}, {
+		name: "release of the routed revision",
+		service: legacyService(v1alpha1.ServiceSpec{DeprecatedRelease: &v1alpha1.ReleaseType{
+			Revisions: []string{"hello-00004"},
+		}}),
+		wantMode:       LegacyRelease,
+		wantCandidates: []string{"hello-00003", "hello-00002", "hello-00001"},
+		wantReasons:    map[string]Reason{"hello-00004": ReasonRouted},
+	}, {
+		name:           "pinned",
+		service:        legacyService(v1alpha1.ServiceSpec{DeprecatedPinned: &v1alpha1.PinnedType{RevisionName: "hello-00002"}}),
+		wantMode:       LegacyPinned,
+		wantCandidates: []string{"hello-00003", "hello-00001"},
+		wantReasons: map[string]Reason{
+			"hello-00002": ReasonSpecPinned,
+			"hello-00004": ReasonRouted,
+		},
+	}, {
+		name:           "pinned to a missing revision",
+		service:        legacyService(v1alpha1.ServiceSpec{DeprecatedPinned: &v1alpha1.PinnedType{RevisionName: "hello-00009"}}),
+		wantMode:       LegacyPinned,
+		wantCandidates: []string{"hello-00003", "hello-00002", "hello-00001"},
+		wantReasons:    map[string]Reason{"hello-00004": ReasonRouted},
+	}, {
+		name:           "manual",
+		service:        legacyService(v1alpha1.ServiceSpec{DeprecatedManual: &v1alpha1.ManualType{}}),
+		wantMode:       LegacyManual,
+		wantCandidates: []string{},
+		wantReasons: map[string]Reason{
+			"hello-00001": ReasonManualMode,
+			"hello-00002": ReasonManualMode,
+			"hello-00003": ReasonManualMode,
+			"hello-00004": ReasonManualMode,
+		},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := LegacyMode(test.service); got != test.wantMode {
+				t.Errorf("LegacyMode() = %q, want %q", got, test.wantMode)
+			}
+			in := Inputs{
+				Route:         readyRoute(latestTarget("hello-00004")),
+				Revisions:     generatedRevisions(4),
+				Now:           now,
+				LegacyMode:    LegacyMode(test.service),
+				SpecRevisions: SpecRevisions(test.service),
+			}
+			result, err := Evaluate(testPolicy(), in)
+			if err != nil {
+				t.Fatalf("Evaluate() = %v", err)
+			}
+
+			if got := names(result.Candidates); !reflect.DeepEqual(got, test.wantCandidates) {
+				t.Errorf("Candidates = %v, want %v", got, test.wantCandidates)
+			}
+			got := make(map[string]Reason)
+			for _, d := range result.Retained {
+				got[d.Revision.Name] = d.Reason
+			}
+			if !reflect.DeepEqual(got, test.wantReasons) {
+				t.Errorf("Retained reasons = %v, want %v", got, test.wantReasons)
+			}
+		})
+	}
+}
+
+func TestSpecRevisions(t *testing.T) {
+	tests := []struct {
+		name    string
+		service *v1alpha1.Service
+		want    map[string]string
+	}{{
+		name: "nil Service",
+	}, {
+		name:    "inline",
+		service: legacyService(v1alpha1.ServiceSpec{}),
+	}, {
+		name:    "runLatest",
+		service: legacyService(v1alpha1.ServiceSpec{DeprecatedRunLatest: &v1alpha1.RunLatestType{}}),
+	}, {
+		name: "release",
+		service: legacyService(v1alpha1.ServiceSpec{DeprecatedRelease: &v1alpha1.ReleaseType{
+			Revisions: []string{"hello-00001", "hello-00002"},
+		}}),
+		want: map[string]string{"hello-00001": LegacyRelease, "hello-00002": LegacyRelease},
+	}, {
+		name: "release of the latest revision only",
+		service: legacyService(v1alpha1.ServiceSpec{DeprecatedRelease: &v1alpha1.ReleaseType{
+			Revisions: []string{v1alpha1.ReleaseLatestRevisionKeyword},
+		}}),
+	}, {
+		name: "release without revisions",
+		service: legacyService(v1alpha1.ServiceSpec{DeprecatedRelease: &v1alpha1.ReleaseType{
+			Revisions: []string{""},
+		}}),
+	}, {
+		name:    "pinned",
+		service: legacyService(v1alpha1.ServiceSpec{DeprecatedPinned: &v1alpha1.PinnedType{RevisionName: "hello-00001"}}),
+		want:    map[string]string{"hello-00001": LegacyPinned},
+	}, {
+		name:    "manual",
+		service: legacyService(v1alpha1.ServiceSpec{DeprecatedManual: &v1alpha1.ManualType{}}),
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := SpecRevisions(test.service); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("SpecRevisions() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
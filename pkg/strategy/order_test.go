@@ -0,0 +1,299 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package strategy
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"knative.dev/pkg/apis"
+	"knative.dev/serving/pkg/apis/serving/v1alpha1"
+)
+
+// byoRevision returns a revision with a bring-your-own name of the
+// generation, created age before now, with the UID.
+func byoRevision(name string, generation int, age time.Duration, uid string) *v1alpha1.Revision {
+	re := testRevision(name, generation, age)
+	re.UID = types.UID(uid)
+	return re
+}
+
+// reversed returns the revisions in reverse order.
+func reversed(revisions []*v1alpha1.Revision) []*v1alpha1.Revision {
+	out := make([]*v1alpha1.Revision, 0, len(revisions))
+	for i := len(revisions) - 1; i >= 0; i-- {
+		out = append(out, revisions[i])
+	}
+	return out
+}
+
+func TestSortDecisions(t *testing.T) {
+	createdAt := byoRevision("hello-aaa", 2, 2*time.Hour, "2")
+	createdAt.Annotations = map[string]string{CreatedAtAnnotationKey: now.Format(time.RFC3339)}
+
+	tests := []struct {
+		name      string
+		revisions []*v1alpha1.Revision
+		want      []string
+	}{{
+		name: "generations against the names",
+		revisions: []*v1alpha1.Revision{
+			byoRevision("hello-blue", 2, 2*time.Hour, "b"),
+			byoRevision("hello-green", 1, 3*time.Hour, "g"),
+			byoRevision("hello-zeta", 3, time.Hour, "z"),
+		},
+		want: []string{"hello-zeta", "hello-blue", "hello-green"},
+	}, {
+		name: "generated names past the padding",
+		revisions: []*v1alpha1.Revision{
+			byoRevision("hello-99999", 99999, 2*time.Hour, "a"),
+			byoRevision("hello-100000", 100000, time.Hour, "b"),
+		},
+		want: []string{"hello-100000", "hello-99999"},
+	}, {
+		name: "same generation, newest first",
+		revisions: []*v1alpha1.Revision{
+			byoRevision("hello-aaa", 2, 2*time.Hour, "1"),
+			byoRevision("hello-zzz", 2, time.Hour, "2"),
+		},
+		want: []string{"hello-zzz", "hello-aaa"},
+	}, {
+		name: "same generation, webhook creation time first",
+		revisions: []*v1alpha1.Revision{
+			createdAt,
+			byoRevision("hello-zzz", 2, time.Hour, "1"),
+		},
+		want: []string{"hello-aaa", "hello-zzz"},
+	}, {
+		name: "same generation and creation time, by UID",
+		revisions: []*v1alpha1.Revision{
+			byoRevision("hello-aaa", 2, time.Hour, "2"),
+			byoRevision("hello-zzz", 2, time.Hour, "1"),
+			byoRevision("hello-mmm", 1, 2*time.Hour, "0"),
+		},
+		want: []string{"hello-zzz", "hello-aaa", "hello-mmm"},
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The order does not depend on the order of the listing.
+			for _, revisions := range [][]*v1alpha1.Revision{tt.revisions, reversed(tt.revisions)} {
+				decisions := make([]Decision, 0, len(revisions))
+				for _, re := range revisions {
+					gen, err := DefaultLabelKeys().Generation(re)
+					if err != nil {
+						t.Fatalf("Generation(%s) = %v", re.Name, err)
+					}
+					decisions = append(decisions, Decision{Revision: re, Generation: gen})
+				}
+				sortDecisions(decisions)
+				if got := names(decisions); !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("sortDecisions(%v) = %v, want %v", revisionNames(revisions), got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestEvaluateRetainsByGeneration(t *testing.T) {
+	// The names sort against the generations: by name, hello-green would
+	// be kept as the most recent stale revision.
+	revisions := []*v1alpha1.Revision{
+		byoRevision("hello-green", 1, 72*time.Hour, "g"),
+		byoRevision("hello-blue", 2, 48*time.Hour, "b"),
+		byoRevision("hello-apple", 3, time.Hour, "a"),
+	}
+	policy := testPolicy()
+	policy.RetainCount = 1
+	for _, revisions := range [][]*v1alpha1.Revision{revisions, reversed(revisions)} {
+		result, err := Evaluate(policy, Inputs{
+			Route:     readyRoute(latestTarget("hello-apple")),
+			Revisions: revisions,
+			Now:       now,
+		})
+		if err != nil {
+			t.Fatalf("Evaluate() = %v", err)
+		}
+		want := map[string]Reason{
+			"hello-apple": ReasonRouted,
+			"hello-blue":  ReasonRetainCount,
+			"hello-green": ReasonStale,
+		}
+		if got := reasons(result); !reflect.DeepEqual(got, want) {
+			t.Errorf("Evaluate(%v) reasons = %v, want %v", revisionNames(revisions), got, want)
+		}
+	}
+}
+
+func TestDetectStuck(t *testing.T) {
+	withReady := func(re *v1alpha1.Revision, status corev1.ConditionStatus) *v1alpha1.Revision {
+		re.Status.Conditions = append(re.Status.Conditions, apis.Condition{
+			Type:   v1alpha1.RevisionConditionReady,
+			Status: status,
+		})
+		return re
+	}
+	tests := []struct {
+		name      string
+		revisions []*v1alpha1.Revision
+		want      *Stuck
+	}{{
+		name: "failed generations against the names",
+		revisions: []*v1alpha1.Revision{
+			withReady(byoRevision("hello-zeta", 1, 72*time.Hour, "1"), corev1.ConditionTrue),
+			withReady(byoRevision("hello-alpha", 2, 48*time.Hour, "2"), corev1.ConditionTrue),
+			withReady(byoRevision("hello-beta", 3, 2*time.Hour, "3"), corev1.ConditionFalse),
+			withReady(byoRevision("hello-aaa", 4, time.Hour, "4"), corev1.ConditionFalse),
+		},
+		want: &Stuck{Failed: []string{"hello-aaa", "hello-beta"}, LastReady: "hello-alpha"},
+	}, {
+		name: "newest of a generation by UID",
+		revisions: []*v1alpha1.Revision{
+			withReady(byoRevision("hello-zeta", 1, 72*time.Hour, "1"), corev1.ConditionTrue),
+			withReady(byoRevision("hello-bbb", 2, time.Hour, "3"), corev1.ConditionFalse),
+			withReady(byoRevision("hello-aaa", 2, time.Hour, "2"), corev1.ConditionFalse),
+		},
+		want: &Stuck{Failed: []string{"hello-aaa", "hello-bbb"}, LastReady: "hello-zeta"},
+	}, {
+		name: "newest generation Ready",
+		revisions: []*v1alpha1.Revision{
+			withReady(byoRevision("hello-zeta", 1, 72*time.Hour, "1"), corev1.ConditionFalse),
+			withReady(byoRevision("hello-alpha", 2, 48*time.Hour, "2"), corev1.ConditionFalse),
+			withReady(byoRevision("hello-beta", 3, time.Hour, "3"), corev1.ConditionTrue),
+		},
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, revisions := range [][]*v1alpha1.Revision{tt.revisions, reversed(tt.revisions)} {
+				got, ok := DetectStuck(revisions, DefaultLabelKeys(), 2)
+				if ok != (tt.want != nil) || !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("DetectStuck(%v) = %+v, %v, want %+v", revisionNames(revisions), got, ok, tt.want)
+				}
+			}
+		})
+	}
+}
+
+// revisionNames returns the names of the revisions, in order.
+func revisionNames(revisions []*v1alpha1.Revision) []string {
+	out := make([]string, 0, len(revisions))
+	for _, re := range revisions {
+		out = append(out, re.Name)
+	}
+	return out
+}
+
+func TestCreatedAt(t *testing.T) {
+	created := now.Add(-time.Hour)
+	tests := []struct {
+		name       string
+		annotation string
+		want       time.Time
+	}{{
+		name: "no annotation",
+		want: created,
+	}, {
+		name:       "stamped after the creation timestamp",
+		annotation: created.Add(time.Second).Format(time.RFC3339),
+		want:       created.Add(time.Second),
+	}, {
+		name:       "stamped within the tolerance",
+		annotation: created.Add(-CreatedAtTolerance).Format(time.RFC3339),
+		want:       created.Add(-CreatedAtTolerance),
+	}, {
+		name:       "copied from an older revision",
+		annotation: created.Add(-24 * time.Hour).Format(time.RFC3339),
+		want:       created,
+	}, {
+		name:       "unreadable",
+		annotation: "yesterday",
+		want:       created,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			re := testRevision("hello-00001", 1, time.Hour)
+			if tt.annotation != "" {
+				re.Annotations = map[string]string{CreatedAtAnnotationKey: tt.annotation}
+			}
+			if got := CreatedAt(re); !got.Equal(tt.want) {
+				t.Errorf("CreatedAt() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvaluateCopiedGeneration(t *testing.T) {
+	// Tooling copied the annotation of a later generation on every revision.
+	copied := func(re *v1alpha1.Revision) *v1alpha1.Revision {
+		re.Annotations = map[string]string{CreatedByGenerationAnnotationKey: "9"}
+		return re
+	}
+	revisions := []*v1alpha1.Revision{
+		copied(testRevision("hello-00001", 1, 72*time.Hour)),
+		copied(testRevision("hello-00002", 2, 48*time.Hour)),
+		copied(testRevision("hello-00003", 3, time.Hour)),
+	}
+
+	tests := []struct {
+		name       string
+		generation int64
+		want       map[string]int
+	}{{
+		name:       "configuration generation unknown",
+		generation: 0,
+		want:       map[string]int{"hello-00001": 9, "hello-00002": 9, "hello-00003": 9},
+	}, {
+		name:       "above the configuration generation",
+		generation: 3,
+		want:       map[string]int{"hello-00001": 1, "hello-00002": 2, "hello-00003": 3},
+	}, {
+		name:       "within the configuration generation",
+		generation: 9,
+		want:       map[string]int{"hello-00001": 9, "hello-00002": 9, "hello-00003": 9},
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := testPolicy()
+			policy.RetainCount = 1
+			result, err := Evaluate(policy, Inputs{
+				Route:                   readyRoute(latestTarget("hello-00003")),
+				Revisions:               revisions,
+				Now:                     now,
+				ConfigurationGeneration: tt.generation,
+			})
+			if err != nil {
+				t.Fatalf("Evaluate() = %v", err)
+			}
+			got := map[string]int{}
+			for _, decisions := range [][]Decision{result.Retained, result.Candidates} {
+				for _, d := range decisions {
+					got[d.Revision.Name] = d.Generation
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Evaluate() generations = %v, want %v", got, tt.want)
+			}
+			for _, re := range revisions {
+				if re.Annotations[CreatedByGenerationAnnotationKey] != "9" {
+					t.Errorf("Evaluate() changed the annotations of %s to %v", re.Name, re.Annotations)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,255 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package strategy
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"knative.dev/serving/pkg/apis/autoscaling"
+	autoscalingv1alpha1 "knative.dev/serving/pkg/apis/autoscaling/v1alpha1"
+	"knative.dev/serving/pkg/apis/serving/v1alpha1"
+)
+
+// Protection is a rule keeping a stale revision regardless of the retain
+// count and age.
+type Protection struct {
+	Reason  Reason
+	Message string
+}
+
+// protections returns every rule keeping a stale revision regardless of the
+// retain count and age, in order of precedence. It is empty when the revision
+// may be deleted.
+func protections(policy Policy, in Inputs, revision *v1alpha1.Revision) []Protection {
+	var out []Protection
+	if key, ok := NoGC(policy.NoGCAnnotations, revision); ok {
+		out = append(out, Protection{ReasonNoGC, fmt.Sprintf("annotated %s=true", key)})
+	}
+	if branch, ok := policy.protectedBranch(revision); ok {
+		out = append(out, Protection{ReasonProtectedBranch, fmt.Sprintf("built from protected %s", policy.Git.describe("branch", branch, revision))})
+	}
+	if gen, until, ok := rollbackTarget(policy, in); ok {
+		if g, err := policy.Labels.Generation(revision); err == nil && g == gen {
+			out = append(out, Protection{ReasonRollbackWindow, fmt.Sprintf("previous generation %d, kept for rollbacks until %s", gen, until.Format(time.RFC3339))})
+		}
+	}
+	if gen, until, ok := rolloutTarget(policy, in); ok {
+		if g, err := policy.Labels.Generation(revision); err == nil && g == gen {
+			out = append(out, Protection{ReasonRollout, fmt.Sprintf("previous generation %d, traffic shifts away from it until %s", gen, until.Format(time.RFC3339))})
+		}
+	}
+	if policy.VolumeClaims == VolumeClaimsSkip {
+		if claims := VolumeClaims(revision); len(claims) > 0 {
+			out = append(out, Protection{ReasonVolumeClaims, fmt.Sprintf("mounts PersistentVolumeClaims %s", strings.Join(claims, ", "))})
+		}
+	}
+	if until, ok := LeasedUntil(revision); ok && in.Now.Before(until) {
+		out = append(out, Protection{ReasonLeased, fmt.Sprintf("leased until %s", until.Format(time.RFC3339))})
+	}
+	if policy.ActivationCooldown > 0 {
+		if at, ok := ActivatedAt(in.PodAutoscalers, revision); ok && in.Now.Before(at.Add(policy.ActivationCooldown)) {
+			out = append(out, Protection{ReasonActivated, fmt.Sprintf("activated from zero at %s, cooling down until %s",
+				at.Format(time.RFC3339), at.Add(policy.ActivationCooldown).Format(time.RFC3339))})
+		}
+	}
+	if !policy.DeleteWarm {
+		if minScale := podAutoscalerMinScale(in, revision); minScale > 0 {
+			out = append(out, Protection{ReasonWarm, fmt.Sprintf("PodAutoscaler keeps minScale=%d", minScale)})
+		}
+	}
+	if policy.KeepLastDeploys > 0 {
+		if gen, err := policy.Labels.Generation(revision); err == nil {
+			for _, deployed := range in.DeployGenerations {
+				if gen == deployed {
+					out = append(out, Protection{ReasonRecentDeploy, fmt.Sprintf("created by one of the last %d deploys", policy.KeepLastDeploys)})
+					break
+				}
+			}
+		}
+	}
+	if tag, ok := in.TagHolders[revision.Name]; ok {
+		out = append(out, Protection{ReasonTagHistory, fmt.Sprintf("one of the last holders of traffic tag %s", tag)})
+	}
+	if open := in.Connections[revision.Name]; open > 0 {
+		out = append(out, Protection{ReasonActiveConnections, fmt.Sprintf("%g open connections", open)})
+	}
+	state := in.InFlight[revision.Name]
+	for _, reason := range state.reasons(in.Now) {
+		if !hasReason(out, reason) {
+			out = append(out, Protection{reason, fmt.Sprintf("in flight under an earlier policy until %s", state[reason].Format(time.RFC3339))})
+		}
+	}
+	return out
+}
+
+// protectionEnd returns when the protections of the revision end, no
+// earlier than after, zero unless leases, activations, rollback windows,
+// rollouts and in-flight protections are all that protect it.
+func protectionEnd(policy Policy, in Inputs, protections []Protection, revision *v1alpha1.Revision, after time.Time) time.Time {
+	end := after
+	state := in.InFlight[revision.Name]
+	for _, p := range protections {
+		var until time.Time
+		switch p.Reason {
+		case ReasonLeased:
+			until, _ = LeasedUntil(revision)
+		case ReasonActivated:
+			at, _ := ActivatedAt(in.PodAutoscalers, revision)
+			until = at.Add(policy.ActivationCooldown)
+		case ReasonRollbackWindow:
+			_, until, _ = rollbackTarget(policy, in)
+		case ReasonRollout:
+			_, until, _ = rolloutTarget(policy, in)
+		case ReasonTooYoung:
+		default:
+			return time.Time{}
+		}
+		if carried := state[p.Reason]; carried.After(until) {
+			until = carried
+		}
+		if until.After(end) {
+			end = until
+		}
+	}
+	return end
+}
+
+// hasReason reports whether one of the protections is for the reason.
+func hasReason(protections []Protection, reason Reason) bool {
+	for _, p := range protections {
+		if p.Reason == reason {
+			return true
+		}
+	}
+	return false
+}
+
+// rollbackTarget returns the generation a rollback returns to, the newest
+// below the generation of the revision the Route serves, and the end of the
+// rollback window, which starts when the served revision was created.
+func rollbackTarget(policy Policy, in Inputs) (int, time.Time, bool) {
+	if policy.RollbackWindow <= 0 {
+		return 0, time.Time{}, false
+	}
+	latest := servedRevision(in)
+	if latest == nil {
+		return 0, time.Time{}, false
+	}
+	until := CreatedAt(latest).Add(policy.RollbackWindow)
+	if !in.Now.Before(until) {
+		return 0, time.Time{}, false
+	}
+	previous, ok := previousGeneration(policy, in, latest)
+	if !ok {
+		return 0, time.Time{}, false
+	}
+	return previous, until, true
+}
+
+// servedRevision returns the revision the Route serves, nil when it serves
+// none or the revision is not among the inputs.
+func servedRevision(in Inputs) *v1alpha1.Revision {
+	serving := NewTrafficIndex(in.Route).Serving()
+	if len(serving) == 0 {
+		return nil
+	}
+	for _, re := range in.Revisions {
+		if re.Name == serving[0].RevisionName {
+			return re
+		}
+	}
+	return nil
+}
+
+// previousGeneration returns the newest generation below the one of the
+// latest revision.
+func previousGeneration(policy Policy, in Inputs, latest *v1alpha1.Revision) (int, bool) {
+	latestGeneration, err := policy.Labels.Generation(latest)
+	if err != nil {
+		return 0, false
+	}
+	previous := -1
+	for _, re := range in.Revisions {
+		if gen, err := policy.Labels.Generation(re); err == nil && gen < latestGeneration && gen > previous {
+			previous = gen
+		}
+	}
+	return previous, previous >= 0
+}
+
+// ActivatedAt returns when the PodAutoscaler of the revision last became
+// active or started activating, i.e. scaling from zero. Revisions without a
+// PodAutoscaler, or whose PodAutoscaler is inactive, were not activated.
+func ActivatedAt(pas []*autoscalingv1alpha1.PodAutoscaler, revision *v1alpha1.Revision) (time.Time, bool) {
+	for _, pa := range pas {
+		if pa.Namespace != revision.Namespace || pa.Name != revision.Name {
+			continue
+		}
+		c := pa.Status.GetCondition(autoscalingv1alpha1.PodAutoscalerConditionActive)
+		if c == nil || c.Status == corev1.ConditionFalse || c.LastTransitionTime.Inner.IsZero() {
+			return time.Time{}, false
+		}
+		return c.LastTransitionTime.Inner.Time, true
+	}
+	return time.Time{}, false
+}
+
+// NoGC returns the first of the annotations set to "true" on the revision.
+func NoGC(annotations []string, revision *v1alpha1.Revision) (string, bool) {
+	for _, key := range annotations {
+		if raw, ok := revision.Annotations[key]; ok {
+			if val, err := strconv.ParseBool(raw); err == nil && val {
+				return key, true
+			}
+		}
+	}
+	return "", false
+}
+
+// LeasedUntil returns the end of the lease of the revision. Unreadable leases
+// hold nothing.
+func LeasedUntil(revision *v1alpha1.Revision) (time.Time, bool) {
+	raw, ok := revision.Annotations[LeaseAnnotationKey]
+	if !ok {
+		return time.Time{}, false
+	}
+	until, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return until, true
+}
+
+// podAutoscalerMinScale returns the minScale of the PodAutoscaler of the
+// revision, zero when it has none.
+func podAutoscalerMinScale(in Inputs, revision *v1alpha1.Revision) int {
+	for _, pa := range in.PodAutoscalers {
+		if pa.Namespace != revision.Namespace || pa.Name != revision.Name {
+			continue
+		}
+		minScale, err := strconv.Atoi(pa.Annotations[autoscaling.MinScaleAnnotationKey])
+		if err != nil {
+			return 0
+		}
+		return minScale
+	}
+	return 0
+}
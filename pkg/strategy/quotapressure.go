@@ -0,0 +1,49 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package strategy
+
+import (
+	"time"
+)
+
+// underQuotaPressure returns the policy to evaluate the inputs with: the
+// policy with the retain count and minimum age lowered to their quota
+// pressure values while the namespace is near its quota, the policy itself
+// otherwise.
+func (p Policy) underQuotaPressure(in Inputs) Policy {
+	if in.QuotaPressure == "" || !p.QuotaPressure.Enabled {
+		return p
+	}
+	if p.QuotaPressure.RetainCount < p.RetainCount {
+		p.RetainCount = p.QuotaPressure.RetainCount
+	}
+	if p.QuotaPressure.MinStaleAge < p.MinStaleAge {
+		p.MinStaleAge = p.QuotaPressure.MinStaleAge
+	}
+	return p
+}
+
+// QuotaPressurePolicy collects stale revisions more aggressively while
+// their namespace is near its ResourceQuota.
+type QuotaPressurePolicy struct {
+	Enabled bool
+
+	// RetainCount and MinStaleAge replace the retain count and minimum age
+	// of the policy under quota pressure when lower.
+	RetainCount int
+	MinStaleAge time.Duration
+}
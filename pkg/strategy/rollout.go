@@ -0,0 +1,89 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package strategy
+
+import (
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"knative.dev/serving/pkg/apis/serving/v1alpha1"
+)
+
+// RolloutDurationAnnotationKey is the Serving annotation on a Route or
+// Service spreading the traffic shift to a new revision over a duration.
+const RolloutDurationAnnotationKey = "serving.knative.dev/rollout-duration"
+
+// RolloutDuration returns the rollout duration annotated on the Route, or
+// else on the Service, whose annotations Serving propagates to the Route.
+// It is zero when neither carries a valid positive duration.
+func RolloutDuration(service *v1alpha1.Service, route *v1alpha1.Route) time.Duration {
+	if route != nil {
+		if d, ok := parseRolloutDuration(route.Annotations[RolloutDurationAnnotationKey]); ok {
+			return d
+		}
+	}
+	if service != nil {
+		if d, ok := parseRolloutDuration(service.Annotations[RolloutDurationAnnotationKey]); ok {
+			return d
+		}
+	}
+	return 0
+}
+
+func parseRolloutDuration(raw string) (time.Duration, bool) {
+	if raw == "" {
+		return 0, false
+	}
+	d, err := time.ParseDuration(raw)
+	if err != nil || d <= 0 {
+		return 0, false
+	}
+	return d, true
+}
+
+// ReadyAt returns when the revision last became Ready, or its creation time
+// when its Ready condition does not tell.
+func ReadyAt(revision *v1alpha1.Revision) time.Time {
+	c := revision.Status.GetCondition(v1alpha1.RevisionConditionReady)
+	if c == nil || c.Status != corev1.ConditionTrue || c.LastTransitionTime.Inner.IsZero() {
+		return CreatedAt(revision)
+	}
+	return c.LastTransitionTime.Inner.Time
+}
+
+// rolloutTarget returns the generation the traffic shifts away from during
+// the rollout of the revision the Route serves, the newest below its
+// generation, and the end of the rollout, the rollout duration after the
+// served revision became Ready.
+func rolloutTarget(policy Policy, in Inputs) (int, time.Time, bool) {
+	if in.RolloutDuration <= 0 {
+		return 0, time.Time{}, false
+	}
+	latest := servedRevision(in)
+	if latest == nil {
+		return 0, time.Time{}, false
+	}
+	until := ReadyAt(latest).Add(in.RolloutDuration)
+	if !in.Now.Before(until) {
+		return 0, time.Time{}, false
+	}
+	previous, ok := previousGeneration(policy, in, latest)
+	if !ok {
+		return 0, time.Time{}, false
+	}
+	return previous, until, true
+}
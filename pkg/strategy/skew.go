@@ -0,0 +1,56 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package strategy
+
+import (
+	"time"
+)
+
+// minStaleAge returns the minimum age of a stale revision widened by the
+// clock skew margin, zero when the policy does not check ages.
+func (p Policy) minStaleAge() time.Duration {
+	if p.MinStaleAge <= 0 {
+		return 0
+	}
+	return p.MinStaleAge + p.ClockSkewMargin
+}
+
+// clockSkew returns the largest disagreement between the clocks seen by the
+// inputs: the measured offset of the API server clock, or how far a
+// creation timestamp set by the API server is ahead of Now.
+func clockSkew(in Inputs) time.Duration {
+	skew := in.ClockSkew
+	if skew < 0 {
+		skew = -skew
+	}
+	for _, re := range in.Revisions {
+		if ahead := re.CreationTimestamp.Sub(in.Now); ahead > skew {
+			skew = ahead
+		}
+	}
+	return skew
+}
+
+// agesUntrusted reports whether the clock skew seen by the inputs exceeds the
+// policy maximum, so ages cannot decide deletions, and the skew.
+func agesUntrusted(policy Policy, in Inputs) (bool, time.Duration) {
+	if policy.MinStaleAge <= 0 || policy.MaxClockSkew <= 0 {
+		return false, 0
+	}
+	skew := clockSkew(in)
+	return skew > policy.MaxClockSkew, skew
+}
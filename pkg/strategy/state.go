@@ -0,0 +1,83 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package strategy
+
+import (
+	"encoding/json"
+)
+
+// StateLabelKey is the label stamped on every revision of an evaluated
+// Service with its garbage collection state, so dashboards and other
+// controllers can select revisions by it.
+const StateLabelKey = "revision-gc.knative.dev/state"
+
+// State is the garbage collection state of a revision.
+type State string
+
+const (
+	// StateRetained marks revisions kept by the retain count, the minimum
+	// age or because they are not stale, which may become candidates as
+	// newer generations roll out.
+	StateRetained State = "retained"
+	// StateCandidate marks revisions that may be deleted.
+	StateCandidate State = "candidate"
+	// StateProtected marks revisions kept regardless of the retain count
+	// and age, e.g. routed, leased or opted out.
+	StateProtected State = "protected"
+)
+
+// States returns the state of every evaluated revision by name. It is empty
+// when the Service was skipped, whose revisions have no state.
+func (r *Result) States() map[string]State {
+	states := make(map[string]State, len(r.Retained)+len(r.Candidates))
+	if r.Skipped() {
+		return states
+	}
+	for _, d := range r.Retained {
+		states[d.Revision.Name] = d.Reason.state()
+	}
+	for _, d := range r.Candidates {
+		states[d.Revision.Name] = StateCandidate
+	}
+	return states
+}
+
+// state returns the state of a revision retained for the reason.
+func (reason Reason) state() State {
+	switch reason {
+	case ReasonNotStale, ReasonInvalidGeneration, ReasonRetainCount, ReasonTooYoung, ReasonClockSkew:
+		return StateRetained
+	default:
+		return StateProtected
+	}
+}
+
+// StateMergePatch returns the JSON merge patch labeling a revision with the
+// state, removing the label when the state is empty.
+func StateMergePatch(state State) ([]byte, error) {
+	var value interface{}
+	if state != "" {
+		value = string(state)
+	}
+	return json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"labels": map[string]interface{}{
+				StateLabelKey: value,
+			},
+		},
+	})
+}
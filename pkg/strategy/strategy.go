@@ -0,0 +1,724 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package strategy holds the revision retention decision logic shared by the
+// controller and the kubectl plugin.
+package strategy
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/knative-sample/revision-controller/pkg/gcerrors"
+	"k8s.io/apimachinery/pkg/labels"
+	"knative.dev/pkg/apis"
+	autoscalingv1alpha1 "knative.dev/serving/pkg/apis/autoscaling/v1alpha1"
+	"knative.dev/serving/pkg/apis/serving"
+	"knative.dev/serving/pkg/apis/serving/v1alpha1"
+	resourcenames "knative.dev/serving/pkg/reconciler/service/resources/names"
+)
+
+const (
+	// CreatedByGenerationAnnotationKey is stamped on new revisions by the
+	// webhook with the generation of the Configuration that created them.
+	// It takes precedence over the configuration generation label.
+	CreatedByGenerationAnnotationKey = "revision-gc.knative.dev/created-by-generation"
+
+	// CreatedAtAnnotationKey is stamped on new revisions by the webhook with
+	// their creation time in UTC, formatted as RFC 3339.
+	CreatedAtAnnotationKey = "revision-gc.knative.dev/created-at"
+
+	// LeaseAnnotationKey holds a revision until the time it is set to, in
+	// RFC 3339, e.g. by tooling running a load test against it.
+	LeaseAnnotationKey = "revision-gc.knative.dev/lease-until"
+
+	// NoGCAnnotationKey set to "true" protects a revision from the upstream
+	// Knative Serving garbage collector, and from this controller.
+	NoGCAnnotationKey = "serving.knative.dev/no-gc"
+)
+
+// LabelKeys holds the label keys used to match revisions to their Service,
+// to read their configuration generation and to match the resources generated
+// for a revision. Distributions that relabel the Knative resources can
+// override them.
+type LabelKeys struct {
+	Service                 string
+	Configuration           string
+	ConfigurationGeneration string
+	Revision                string
+}
+
+// DefaultLabelKeys returns the label keys set by Knative Serving.
+func DefaultLabelKeys() LabelKeys {
+	return LabelKeys{
+		Service:                 serving.ServiceLabelKey,
+		Configuration:           serving.ConfigurationLabelKey,
+		ConfigurationGeneration: serving.ConfigurationGenerationLabelKey,
+		Revision:                serving.RevisionLabelKey,
+	}
+}
+
+// RevisionSelector returns the label selector matching the revisions that
+// belong to the Service.
+func (k LabelKeys) RevisionSelector(service *v1alpha1.Service) labels.Selector {
+	return labels.SelectorFromSet(map[string]string{
+		k.Service:       service.Name,
+		k.Configuration: resourcenames.Configuration(service),
+	})
+}
+
+// Generation returns the configuration generation recorded on the revision,
+// preferring the generation stamped by the webhook over the label.
+func (k LabelKeys) Generation(revision *v1alpha1.Revision) (int, error) {
+	if raw, ok := revision.Annotations[CreatedByGenerationAnnotationKey]; ok {
+		val, err := parseGeneration(raw)
+		if err != nil {
+			return 0, &gcerrors.LabelParseError{Revision: revision.Name, Kind: "annotation", Key: CreatedByGenerationAnnotationKey, Value: raw, Err: err}
+		}
+		return val, nil
+	}
+	raw := revision.Labels[k.ConfigurationGeneration]
+	val, err := parseGeneration(raw)
+	if err != nil {
+		return 0, &gcerrors.LabelParseError{Revision: revision.Name, Kind: "label", Key: k.ConfigurationGeneration, Value: raw, Err: err}
+	}
+	return val, nil
+}
+
+// parseGeneration parses a configuration generation. Negative generations
+// are rejected, they would order the revision before all others and make it
+// the first deletion candidate.
+func parseGeneration(raw string) (int, error) {
+	val, err := strconv.Atoi(raw)
+	if err != nil {
+		return 0, err
+	}
+	if val < 0 {
+		return 0, fmt.Errorf("generation %d is negative", val)
+	}
+	return val, nil
+}
+
+// trustedGenerations returns the revisions without the created-by-generation
+// annotations above generation, the generation of the owning Configuration,
+// copying the revisions it changes. Such an annotation was copied from
+// another object and is not the generation the revision was created by; the
+// label is read instead. A zero generation trusts every annotation.
+func trustedGenerations(revisions []*v1alpha1.Revision, generation int64) []*v1alpha1.Revision {
+	if generation == 0 {
+		return revisions
+	}
+	var trusted []*v1alpha1.Revision
+	for i, re := range revisions {
+		val, err := parseGeneration(re.Annotations[CreatedByGenerationAnnotationKey])
+		if err != nil || int64(val) <= generation {
+			if trusted != nil {
+				trusted = append(trusted, re)
+			}
+			continue
+		}
+		if trusted == nil {
+			trusted = append(make([]*v1alpha1.Revision, 0, len(revisions)), revisions[:i]...)
+		}
+		re = re.DeepCopy()
+		delete(re.Annotations, CreatedByGenerationAnnotationKey)
+		trusted = append(trusted, re)
+	}
+	if trusted == nil {
+		return revisions
+	}
+	return trusted
+}
+
+// CreatedAtTolerance is how much earlier than the creation timestamp of a
+// revision the created-at annotation may be and still be trusted, the
+// default clock skew margin. The webhook stamps it moments before the API
+// server sets the timestamp; an earlier one was copied from an older object
+// and would age the revision past its minimum age.
+const CreatedAtTolerance = time.Minute
+
+// CreatedAt returns the creation time of the revision, preferring the time
+// stamped by the webhook over the object creation timestamp unless it is
+// more than CreatedAtTolerance before it.
+func CreatedAt(revision *v1alpha1.Revision) time.Time {
+	if raw, ok := revision.Annotations[CreatedAtAnnotationKey]; ok {
+		t, err := time.Parse(time.RFC3339, raw)
+		if err == nil && !t.Before(revision.CreationTimestamp.Add(-CreatedAtTolerance)) {
+			return t
+		}
+	}
+	return revision.CreationTimestamp.Time
+}
+
+// Policy describes which stale revisions of a Service are kept.
+type Policy struct {
+	// Name and Namespace identify the policy in decisions, reports and metrics.
+	Name      string
+	Namespace string
+
+	// Labels are the label keys used to read the revisions.
+	Labels LabelKeys
+
+	// RetainCount is the number of most recent stale revisions to keep.
+	RetainCount int
+
+	// MinStaleAge is the minimum age of a stale revision before it is deleted.
+	MinStaleAge time.Duration
+
+	// ClockSkewMargin widens MinStaleAge to tolerate small clock skew.
+	ClockSkewMargin time.Duration
+
+	// MaxClockSkew is the clock skew above which MinStaleAge cannot decide
+	// deletions, see Inputs.ClockSkew. Zero disables the check.
+	MaxClockSkew time.Duration
+
+	// KeepLastDeploys keeps the revisions created by the last deploys of the
+	// Service, i.e. its last Configuration generation bumps. Zero disables it.
+	KeepLastDeploys int
+
+	// MaxRevisions caps the number of live revisions of a Service. Stale
+	// revisions kept only by RetainCount or MinStaleAge are deleted, oldest
+	// first, while the cap is exceeded. This includes the ones MinStaleAge
+	// cannot decide on under MaxClockSkew: the cap orders them by generation,
+	// not by age, so the clock skew does not affect it. Zero disables the cap.
+	MaxRevisions int
+
+	// DeleteWarm allows deleting stale revisions whose PodAutoscaler keeps
+	// them warm with a minScale above zero.
+	DeleteWarm bool
+
+	// KeepAttested retains at least one revision per image digest whose
+	// signature or provenance was verified, as recorded by the
+	// AttestationAnnotation set to AttestationValue on the revision.
+	KeepAttested          bool
+	AttestationAnnotation string
+	AttestationValue      string
+
+	// Git are the annotations recording the Git metadata of revisions.
+	// Revisions built from a branch matching one of the path.Match patterns
+	// of ProtectedGitBranches are never deleted, and the newest revision
+	// built from every tag matching one of KeepGitTags is retained.
+	Git                  GitMetadata
+	ProtectedGitBranches []string
+	KeepGitTags          []string
+
+	// QuotaPressure lowers the retain count and minimum age while the
+	// namespace is near its ResourceQuota.
+	QuotaPressure QuotaPressurePolicy
+
+	// RollbackWindow keeps the revisions of the generation before the one
+	// the Route serves for this long after the served revision was created,
+	// so a rollback finds them, regardless of RetainCount and MaxRevisions.
+	// Zero disables it.
+	RollbackWindow time.Duration
+
+	// NoGCAnnotations are the annotations protecting a revision when set to
+	// "true", e.g. NoGCAnnotationKey.
+	NoGCAnnotations []string
+
+	// VolumeClaims selects how stale revisions mounting
+	// PersistentVolumeClaims are collected. Empty is VolumeClaimsIgnore.
+	VolumeClaims VolumeClaimPolicy
+
+	// ActivationCooldown protects stale revisions activated from zero, e.g.
+	// by requests routed to them out-of-band, for this long after the
+	// activation. Zero disables the protection.
+	ActivationCooldown time.Duration
+
+	// NotifyWhenCandidatesExceed withholds the deletion of the candidates of
+	// a Service and notifies instead when there are more than this many, a
+	// sign of a misbehaving deploy pipeline. Zero disables the check.
+	NotifyWhenCandidatesExceed int
+}
+
+// Reason explains why a revision is retained or why a Service is skipped.
+type Reason string
+
+const (
+	// ReasonRouted marks the revision the Route sends its traffic to.
+	ReasonRouted Reason = "Routed"
+	// ReasonSpecPinned marks revisions the release or pinned mode of the
+	// Service spec names.
+	ReasonSpecPinned Reason = "SpecPinned"
+	// ReasonNotStale marks revisions whose generation is not older than the routed one.
+	ReasonNotStale Reason = "NotStale"
+	// ReasonInvalidGeneration marks revisions without a parsable generation label.
+	ReasonInvalidGeneration Reason = "InvalidGeneration"
+	// ReasonRetainCount marks stale revisions kept by the policy retain count.
+	ReasonRetainCount Reason = "RetainCount"
+	// ReasonTooYoung marks stale revisions younger than the policy minimum age.
+	ReasonTooYoung Reason = "TooYoung"
+	// ReasonClockSkew marks stale revisions whose age cannot be trusted
+	// because the clocks disagree by more than the policy allows.
+	ReasonClockSkew Reason = "ClockSkew"
+	// ReasonWarm marks stale revisions kept warm by a PodAutoscaler minScale.
+	ReasonWarm Reason = "Warm"
+	// ReasonRecentDeploy marks stale revisions created by one of the last deploys.
+	ReasonRecentDeploy Reason = "RecentDeploy"
+	// ReasonTagHistory marks stale revisions that were among the last holders
+	// of a traffic tag.
+	ReasonTagHistory Reason = "TagHistory"
+	// ReasonActiveConnections marks stale revisions still holding open connections.
+	ReasonActiveConnections Reason = "ActiveConnections"
+	// ReasonAttested marks the last revision running an attested image digest.
+	ReasonAttested Reason = "Attested"
+	// ReasonGitTag marks the newest revision built from a kept Git tag.
+	ReasonGitTag Reason = "GitTag"
+	// ReasonProtectedBranch marks revisions built from a protected Git branch.
+	ReasonProtectedBranch Reason = "ProtectedBranch"
+	// ReasonLeased marks stale revisions held by an unexpired lease.
+	ReasonLeased Reason = "Leased"
+	// ReasonRollbackWindow is used for the previous generation during the
+	// rollback window of the latest one.
+	ReasonRollbackWindow Reason = "RollbackWindow"
+	// ReasonRollout is used for the previous generation while the traffic
+	// gradually shifts to the latest one over its rollout duration.
+	ReasonRollout Reason = "Rollout"
+	// ReasonNoGC is used when the revision opts out of garbage collection.
+	ReasonNoGC Reason = "NoGC"
+	// ReasonVolumeClaims marks stale revisions mounting
+	// PersistentVolumeClaims, kept under VolumeClaimsSkip.
+	ReasonVolumeClaims Reason = "VolumeClaims"
+	// ReasonActivated marks stale revisions recently activated from zero.
+	ReasonActivated Reason = "Activated"
+	// ReasonStale marks revisions that are deletion candidates.
+	ReasonStale Reason = "Stale"
+	// ReasonMaxRevisions marks deletion candidates that would otherwise be
+	// retained, but push the Service over its revision cap.
+	ReasonMaxRevisions Reason = "MaxRevisions"
+
+	// ReasonRouteNotFound is used when the Service has no Route yet.
+	ReasonRouteNotFound Reason = "RouteNotFound"
+	// ReasonNoTraffic is used when the Route has not reported any traffic yet.
+	ReasonNoTraffic Reason = "NoTraffic"
+	// ReasonTrafficTarget marks revisions a traffic target still references,
+	// e.g. by its tag.
+	ReasonTrafficTarget Reason = "TrafficTarget"
+	// ReasonSplitTraffic is used when the Route splits traffic across targets.
+	ReasonSplitTraffic Reason = "SplitTraffic"
+	// ReasonPinnedTraffic is used when the Route does not follow the latest revision.
+	ReasonPinnedTraffic Reason = "PinnedTraffic"
+	// ReasonRouteNotReady is used while the Route is still rolling out, i.e.
+	// its traffic is not fully assigned or its ingress is not ready yet.
+	ReasonRouteNotReady Reason = "RouteNotReady"
+	// ReasonRoutedRevisionMissing is used when the revision the Route sends
+	// its traffic to is not among the revisions, e.g. while the caches of the
+	// Route and the revisions disagree.
+	ReasonRoutedRevisionMissing Reason = "RoutedRevisionMissing"
+	// ReasonOwnershipHandoff is used while the ownership of the Service is
+	// handed off to a new owner.
+	ReasonOwnershipHandoff Reason = "OwnershipHandoff"
+	// ReasonRollbackFreeze is used while garbage collection of the Service
+	// is frozen after a rollback.
+	ReasonRollbackFreeze Reason = "RollbackFreeze"
+	// ReasonExempted is used when an exemption list exempts the Service.
+	ReasonExempted Reason = "Exempted"
+	// ReasonManualMode is used when the Service is in the deprecated manual
+	// mode, which leaves its Route and Configuration to the user.
+	ReasonManualMode Reason = "ManualMode"
+	// ReasonDuplicateGeneration is used for revisions carrying the same
+	// generation that cannot be told apart as the latest of it.
+	ReasonDuplicateGeneration Reason = "DuplicateGeneration"
+	// ReasonVetoed is used for candidates a deletion check hook vetoed.
+	ReasonVetoed Reason = "Vetoed"
+)
+
+// Inputs holds the objects the revisions of a Service are evaluated against.
+type Inputs struct {
+	// Route is the Route of the Service, nil if it does not exist yet.
+	Route *v1alpha1.Route
+
+	// Revisions are the revisions of the Service.
+	Revisions []*v1alpha1.Revision
+
+	// PodAutoscalers are the PodAutoscalers of the revisions.
+	PodAutoscalers []*autoscalingv1alpha1.PodAutoscaler
+
+	// DeployGenerations are the Configuration generations of the last deploys
+	// of the Service, from its recorded deploy history.
+	DeployGenerations []int
+
+	// TagHolders maps the revisions that were among the last holders of a
+	// tracked traffic tag to the tag, from the recorded tag history.
+	TagHolders map[string]string
+
+	// Connections holds the open connections by revision name, nil when
+	// they are not checked.
+	Connections map[string]float64
+
+	// InFlight holds the time-bound protections carried over from earlier
+	// policies by revision name, see MigrateInFlight.
+	InFlight map[string]InFlight
+
+	// Now is the time the evaluation is done at, on the clock of the API
+	// server that set the creation timestamps.
+	Now time.Time
+
+	// ClockSkew is the measured offset of the API server clock from the
+	// clock of the evaluation. Creation timestamps ahead of Now count as
+	// skew too.
+	ClockSkew time.Duration
+
+	// HandoffUntil is the end of the ownership handoff of the Service, zero
+	// when none is in progress. The Service is not evaluated before.
+	HandoffUntil time.Time
+
+	// FrozenUntil is the end of the freeze after a rollback of the Service,
+	// zero when none is in progress. The Service is not evaluated before.
+	FrozenUntil time.Time
+
+	// RolloutDuration is the rollout duration annotated on the Route or the
+	// Service, see RolloutDuration. The previous generation is kept until
+	// it elapsed after the served revision became Ready.
+	RolloutDuration time.Duration
+
+	// ExemptedBy names the exemption source exempting the Service, empty
+	// when it is not exempted. Exempted Services are not evaluated.
+	ExemptedBy string
+
+	// LegacyMode is the deprecated mode of the Service spec, see LegacyMode.
+	// Services in the manual mode are not evaluated.
+	LegacyMode string
+
+	// SpecRevisions are the revisions the Service spec names, by legacy
+	// mode, see SpecRevisions.
+	SpecRevisions map[string]string
+
+	// QuotaPressure describes the ResourceQuota the namespace of the
+	// Service is near, empty when it is near none.
+	QuotaPressure string
+
+	// ConfigurationGeneration is the generation of the Configuration of the
+	// Service, zero when it is unknown. Revisions annotated with a greater
+	// generation are ordered by their label instead.
+	ConfigurationGeneration int64
+}
+
+// Decision is the outcome of evaluating a single revision.
+type Decision struct {
+	Revision   *v1alpha1.Revision
+	Generation int
+	Reason     Reason
+	Message    string
+
+	// EligibleAt is the earliest time the revision is a deletion candidate
+	// under the policy, once the minimum age and the lease holding it end.
+	// It is zero while rules that do not end by themselves keep it.
+	EligibleAt time.Time
+}
+
+// Result is the outcome of evaluating all revisions of a Service.
+type Result struct {
+	// SkipReason is set when the Service was not evaluated at all; every
+	// revision is then retained.
+	SkipReason Reason
+
+	// RoutedRevision is the name of the revision receiving the traffic.
+	RoutedRevision string
+
+	// Retained holds the revisions that are kept.
+	Retained []Decision
+
+	// Candidates holds the revisions that may be deleted.
+	Candidates []Decision
+
+	// QuotaPressure describes the quota the namespace is near, empty when
+	// the policy was not lowered for it.
+	QuotaPressure string
+
+	// Duplicates describes the generations carried by several revisions.
+	Duplicates []Duplicate
+}
+
+// Veto retains the candidate revision with the ReasonVetoed reason and the
+// message.
+func (r *Result) Veto(name, message string) {
+	for i, d := range r.Candidates {
+		if d.Revision.Name != name {
+			continue
+		}
+		r.Candidates = append(r.Candidates[:i:i], r.Candidates[i+1:]...)
+		d.Reason, d.Message, d.EligibleAt = ReasonVetoed, message, time.Time{}
+		r.Retained = append(r.Retained, d)
+		sortDecisions(r.Retained)
+		return
+	}
+}
+
+// Skipped reports whether the Service was skipped as a whole.
+func (r *Result) Skipped() bool {
+	return r.SkipReason != ""
+}
+
+// StaleSince returns when the revisions of the generation became stale: the
+// creation of the first revision of a later generation among the evaluated
+// ones. It is zero when there is none.
+func (r *Result) StaleSince(generation int) time.Time {
+	var since time.Time
+	for _, decisions := range [][]Decision{r.Retained, r.Candidates} {
+		for _, d := range decisions {
+			if d.Generation <= generation || d.Reason == ReasonInvalidGeneration {
+				continue
+			}
+			if at := CreatedAt(d.Revision); since.IsZero() || at.Before(since) {
+				since = at
+			}
+		}
+	}
+	return since
+}
+
+// Evaluate splits the revisions of a Service into retained revisions and
+// deletion candidates according to the policy. Only revisions older than the
+// one the Route sends all of its traffic to are ever considered.
+func Evaluate(policy Policy, in Inputs) (*Result, error) {
+	result := &Result{}
+	policy = policy.underQuotaPressure(in)
+	in.Revisions = trustedGenerations(in.Revisions, in.ConfigurationGeneration)
+	route, revisions := in.Route, in.Revisions
+	traffic := NewTrafficIndex(route)
+
+	if in.ExemptedBy != "" {
+		return skipAll(result, policy, revisions, ReasonExempted), nil
+	}
+	if in.LegacyMode == LegacyManual {
+		return skipAll(result, policy, revisions, ReasonManualMode), nil
+	}
+	if in.Now.Before(in.HandoffUntil) {
+		return skipAll(result, policy, revisions, ReasonOwnershipHandoff), nil
+	}
+	if in.Now.Before(in.FrozenUntil) {
+		return skipAll(result, policy, revisions, ReasonRollbackFreeze), nil
+	}
+	if skip := routeSkipReason(route, traffic); skip != "" {
+		return skipAll(result, policy, revisions, skip), nil
+	}
+
+	result.RoutedRevision = traffic.Serving()[0].RevisionName
+	if policy.QuotaPressure.Enabled {
+		result.QuotaPressure = in.QuotaPressure
+	}
+	var latest *v1alpha1.Revision
+	for _, re := range revisions {
+		if re.Name == result.RoutedRevision {
+			latest = re
+			break
+		}
+	}
+	if latest == nil {
+		// Which revisions are older than one that cannot be seen is unknown.
+		return skipAll(result, policy, revisions, ReasonRoutedRevisionMissing), nil
+	}
+	latestGeneration, err := policy.Labels.Generation(latest)
+	if err != nil {
+		return nil, err
+	}
+	result.Duplicates = duplicateGenerations(policy, revisions, latest)
+	ambiguous := make(map[string]string)
+	for _, d := range result.Duplicates {
+		for _, name := range d.Ambiguous {
+			ambiguous[name] = d.String()
+		}
+	}
+
+	var stale []Decision
+	for _, re := range revisions {
+		gen, err := policy.Labels.Generation(re)
+		switch {
+		case re.Name == latest.Name:
+			result.Retained = append(result.Retained, Decision{Revision: re, Generation: gen, Reason: ReasonRouted})
+		case len(traffic.Targets(re.Name)) > 0:
+			result.Retained = append(result.Retained, Decision{Revision: re, Generation: gen, Reason: ReasonTrafficTarget,
+				Message: "referenced by traffic target " + targetNames(traffic.Targets(re.Name))})
+		case in.SpecRevisions[re.Name] != "":
+			result.Retained = append(result.Retained, Decision{Revision: re, Generation: gen, Reason: ReasonSpecPinned,
+				Message: "named by the " + in.SpecRevisions[re.Name] + " mode of the Service"})
+		case err != nil:
+			result.Retained = append(result.Retained, Decision{Revision: re, Reason: ReasonInvalidGeneration, Message: err.Error()})
+		case gen >= latestGeneration:
+			result.Retained = append(result.Retained, Decision{Revision: re, Generation: gen, Reason: ReasonNotStale})
+		default:
+			stale = append(stale, Decision{Revision: re, Generation: gen})
+		}
+	}
+
+	sortDecisions(stale)
+	if len(result.Duplicates) > 0 {
+		// Take the revision the Configuration controls as the most recent
+		// of a duplicated generation.
+		ranks := duplicateRanks(result.Duplicates)
+		sort.SliceStable(stale, func(i, j int) bool {
+			if stale[i].Generation != stale[j].Generation {
+				return stale[i].Generation > stale[j].Generation
+			}
+			return ranks[stale[i].Revision.Name] < ranks[stale[j].Revision.Name]
+		})
+	}
+	untrusted, skew := agesUntrusted(policy, in)
+	minAge := policy.minStaleAge()
+	kept := 0
+	for _, d := range stale {
+		if message, ok := ambiguous[d.Revision.Name]; ok {
+			d.Reason, d.Message = ReasonDuplicateGeneration, message
+			result.Retained = append(result.Retained, d)
+			continue
+		}
+		if p := protections(policy, in, d.Revision); len(p) > 0 {
+			d.Reason, d.Message = p[0].Reason, p[0].Message
+			d.EligibleAt = protectionEnd(policy, in, p, d.Revision, CreatedAt(d.Revision).Add(minAge))
+			result.Retained = append(result.Retained, d)
+			continue
+		}
+
+		age := in.Now.Sub(CreatedAt(d.Revision))
+		switch {
+		case kept < policy.RetainCount:
+			kept++
+			d.Reason = ReasonRetainCount
+			d.Message = fmt.Sprintf("one of the %d most recent stale revisions", policy.RetainCount)
+			result.Retained = append(result.Retained, d)
+		case untrusted:
+			d.Reason = ReasonClockSkew
+			d.Message = fmt.Sprintf("clock skew %s exceeds %s, the age cannot be trusted", skew.Round(time.Second), policy.MaxClockSkew)
+			result.Retained = append(result.Retained, d)
+		case age < minAge:
+			d.Reason = ReasonTooYoung
+			d.Message = fmt.Sprintf("age %s is below %s", age.Round(time.Second), minAge)
+			d.EligibleAt = CreatedAt(d.Revision).Add(minAge)
+			result.Retained = append(result.Retained, d)
+		default:
+			d.Reason = ReasonStale
+			d.EligibleAt = CreatedAt(d.Revision).Add(minAge)
+			result.Candidates = append(result.Candidates, d)
+		}
+	}
+
+	if policy.KeepAttested {
+		keepAttested(policy, result)
+	}
+	if len(policy.KeepGitTags) > 0 {
+		keepGitTags(policy, result)
+	}
+	if policy.MaxRevisions > 0 {
+		enforceMaxRevisions(policy, len(revisions), in.Now, result)
+	}
+
+	sortDecisions(result.Retained)
+	return result, nil
+}
+
+// skipAll retains all revisions for the reason the Service is skipped.
+func skipAll(result *Result, policy Policy, revisions []*v1alpha1.Revision, reason Reason) *Result {
+	result.SkipReason = reason
+	for _, re := range revisions {
+		gen, _ := policy.Labels.Generation(re)
+		result.Retained = append(result.Retained, Decision{Revision: re, Generation: gen, Reason: reason})
+	}
+	sortDecisions(result.Retained)
+	return result
+}
+
+// enforceMaxRevisions turns the oldest stale revisions retained only by the
+// retain count or the minimum age into candidates while the revisions left
+// after deleting the candidates exceed the cap.
+func enforceMaxRevisions(policy Policy, total int, now time.Time, result *Result) {
+	live := total - len(result.Candidates)
+	excess := live - policy.MaxRevisions
+	if excess <= 0 {
+		return
+	}
+
+	sortDecisions(result.Retained)
+	retained := make([]Decision, 0, len(result.Retained))
+	var capped []Decision
+	// Walk from the oldest generation.
+	for i := len(result.Retained) - 1; i >= 0; i-- {
+		d := result.Retained[i]
+		if excess > 0 && (d.Reason == ReasonRetainCount || d.Reason == ReasonTooYoung || d.Reason == ReasonClockSkew) {
+			excess--
+			d.Reason = ReasonMaxRevisions
+			d.Message = fmt.Sprintf("%d live revisions exceed the cap of %d", live, policy.MaxRevisions)
+			d.EligibleAt = now
+			capped = append(capped, d)
+			continue
+		}
+		retained = append(retained, d)
+	}
+	result.Retained = retained
+	result.Candidates = append(result.Candidates, capped...)
+	sortDecisions(result.Candidates)
+}
+
+// routeSkipReason returns why the Service of the Route is not evaluated.
+// Targets only reachable by their tag do not split the traffic.
+func routeSkipReason(route *v1alpha1.Route, traffic *TrafficIndex) Reason {
+	switch {
+	case route == nil:
+		return ReasonRouteNotFound
+	case route.Status.ObservedGeneration != route.Generation || !routeReady(route):
+		return ReasonRouteNotReady
+	case len(traffic.Serving()) == 0:
+		return ReasonNoTraffic
+	case len(traffic.Serving()) > 1:
+		return ReasonSplitTraffic
+	case traffic.Serving()[0].LatestRevision == nil || !*traffic.Serving()[0].LatestRevision:
+		return ReasonPinnedTraffic
+	}
+	return ""
+}
+
+// routeReady reports whether the Route finished rolling out its traffic.
+func routeReady(route *v1alpha1.Route) bool {
+	for _, t := range []apis.ConditionType{
+		v1alpha1.RouteConditionAllTrafficAssigned,
+		v1alpha1.RouteConditionIngressReady,
+		v1alpha1.RouteConditionReady,
+	} {
+		if c := route.Status.GetCondition(t); c == nil || !c.IsTrue() {
+			return false
+		}
+	}
+	return true
+}
+
+// sortDecisions orders decisions from the newest to the oldest generation,
+// then by newerRevision.
+func sortDecisions(decisions []Decision) {
+	sort.SliceStable(decisions, func(i, j int) bool {
+		if decisions[i].Generation != decisions[j].Generation {
+			return decisions[i].Generation > decisions[j].Generation
+		}
+		return newerRevision(decisions[i].Revision, decisions[j].Revision)
+	})
+}
+
+// newerRevision orders two revisions of a generation: the one created last
+// first, by CreatedAt, then by CreationTimestamp, and by UID for revisions
+// created in the same second, so the order does not depend on the order
+// they were listed in. Revision names never order revisions: they are user
+// chosen with bring-your-own names, e.g. "hello-blue", and only the
+// generated ones sort by generation.
+func newerRevision(a, b *v1alpha1.Revision) bool {
+	if ca, cb := CreatedAt(a), CreatedAt(b); !ca.Equal(cb) {
+		return ca.After(cb)
+	}
+	if ca, cb := a.CreationTimestamp.Time, b.CreationTimestamp.Time; !ca.Equal(cb) {
+		return ca.After(cb)
+	}
+	return a.UID < b.UID
+}
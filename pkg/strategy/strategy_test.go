@@ -0,0 +1,216 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package strategy
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"knative.dev/pkg/apis"
+	duckv1beta1 "knative.dev/pkg/apis/duck/v1beta1"
+	autoscalingv1alpha1 "knative.dev/serving/pkg/apis/autoscaling/v1alpha1"
+	"knative.dev/serving/pkg/apis/serving/v1alpha1"
+	"knative.dev/serving/pkg/apis/serving/v1beta1"
+)
+
+// now is the time the test revisions are evaluated at.
+var now = time.Date(2019, 8, 1, 12, 0, 0, 0, time.UTC)
+
+// testPolicy returns a policy deleting every stale revision right away.
+func testPolicy() Policy {
+	return Policy{Name: "test", Labels: DefaultLabelKeys()}
+}
+
+// testRevision returns a revision of the Service "hello" of the generation,
+// created age before now.
+func testRevision(name string, generation int, age time.Duration) *v1alpha1.Revision {
+	keys := DefaultLabelKeys()
+	return &v1alpha1.Revision{ObjectMeta: metav1.ObjectMeta{
+		Namespace: "default",
+		Name:      name,
+		Labels: map[string]string{
+			keys.Service:                 "hello",
+			keys.Configuration:           "hello",
+			keys.ConfigurationGeneration: fmt.Sprint(generation),
+		},
+		CreationTimestamp: metav1.NewTime(now.Add(-age)),
+	}}
+}
+
+// generatedRevisions returns count revisions of consecutive generations
+// with generated names, the newest created an hour ago and each earlier one
+// a day before the next.
+func generatedRevisions(count int) []*v1alpha1.Revision {
+	revisions := make([]*v1alpha1.Revision, 0, count)
+	for gen := 1; gen <= count; gen++ {
+		age := time.Hour + time.Duration(count-gen)*24*time.Hour
+		revisions = append(revisions, testRevision(fmt.Sprintf("hello-%05d", gen), gen, age))
+	}
+	return revisions
+}
+
+// activatedAutoscaler returns the PodAutoscaler of the revision, activated
+// from zero at the time.
+func activatedAutoscaler(name string, at time.Time) *autoscalingv1alpha1.PodAutoscaler {
+	pa := &autoscalingv1alpha1.PodAutoscaler{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name}}
+	pa.Status.Conditions = duckv1beta1.Conditions{{
+		Type:               autoscalingv1alpha1.PodAutoscalerConditionActive,
+		Status:             corev1.ConditionTrue,
+		LastTransitionTime: apis.VolatileTime{Inner: metav1.NewTime(at)},
+	}}
+	return pa
+}
+
+// latestTarget returns a target sending all traffic to the latest revision,
+// resolved to name.
+func latestTarget(name string) v1alpha1.TrafficTarget {
+	latest := true
+	return v1alpha1.TrafficTarget{TrafficTarget: v1beta1.TrafficTarget{
+		RevisionName:   name,
+		LatestRevision: &latest,
+		Percent:        100,
+	}}
+}
+
+// tagTarget returns a target only reachable by its tag.
+func tagTarget(tag, name string) v1alpha1.TrafficTarget {
+	latest := false
+	return v1alpha1.TrafficTarget{TrafficTarget: v1beta1.TrafficTarget{
+		Tag:            tag,
+		RevisionName:   name,
+		LatestRevision: &latest,
+	}}
+}
+
+// readyRoute returns a Route that finished rolling out the targets.
+func readyRoute(targets ...v1alpha1.TrafficTarget) *v1alpha1.Route {
+	route := &v1alpha1.Route{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "hello"}}
+	route.Status.Traffic = targets
+	for _, t := range []apis.ConditionType{
+		v1alpha1.RouteConditionAllTrafficAssigned,
+		v1alpha1.RouteConditionIngressReady,
+		v1alpha1.RouteConditionReady,
+	} {
+		route.Status.Conditions = append(route.Status.Conditions, apis.Condition{Type: t, Status: corev1.ConditionTrue})
+	}
+	return route
+}
+
+// names returns the names of the revisions of the decisions, in order.
+func names(decisions []Decision) []string {
+	out := make([]string, 0, len(decisions))
+	for _, d := range decisions {
+		out = append(out, d.Revision.Name)
+	}
+	return out
+}
+
+// reasons returns the reasons of the decisions by revision name.
+func reasons(result *Result) map[string]Reason {
+	out := make(map[string]Reason)
+	for _, decisions := range [][]Decision{result.Retained, result.Candidates} {
+		for _, d := range decisions {
+			out[d.Revision.Name] = d.Reason
+		}
+	}
+	return out
+}
+
+func TestEnforceMaxRevisions(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy func(*Policy)
+		skew   time.Duration
+		want   map[string]Reason
+	}{{
+		name: "under the cap",
+		policy: func(p *Policy) {
+			p.RetainCount = 2
+			p.MaxRevisions = 3
+		},
+		want: map[string]Reason{
+			"hello-00001": ReasonStale,
+			"hello-00002": ReasonStale,
+			"hello-00003": ReasonRetainCount,
+			"hello-00004": ReasonRetainCount,
+			"hello-00005": ReasonRouted,
+		},
+	}, {
+		name: "kept by the retain count",
+		policy: func(p *Policy) {
+			p.RetainCount = 3
+			p.MaxRevisions = 2
+		},
+		want: map[string]Reason{
+			"hello-00001": ReasonStale,
+			"hello-00002": ReasonMaxRevisions,
+			"hello-00003": ReasonMaxRevisions,
+			"hello-00004": ReasonRetainCount,
+			"hello-00005": ReasonRouted,
+		},
+	}, {
+		name: "kept by the minimum age",
+		policy: func(p *Policy) {
+			p.MinStaleAge = 60 * time.Hour
+			p.MaxRevisions = 2
+		},
+		want: map[string]Reason{
+			"hello-00001": ReasonStale,
+			"hello-00002": ReasonStale,
+			"hello-00003": ReasonMaxRevisions,
+			"hello-00004": ReasonTooYoung,
+			"hello-00005": ReasonRouted,
+		},
+	}, {
+		name: "age not trusted under clock skew",
+		policy: func(p *Policy) {
+			p.MinStaleAge = time.Hour
+			p.MaxClockSkew = time.Minute
+			p.MaxRevisions = 3
+		},
+		skew: 5 * time.Minute,
+		want: map[string]Reason{
+			"hello-00001": ReasonMaxRevisions,
+			"hello-00002": ReasonMaxRevisions,
+			"hello-00003": ReasonClockSkew,
+			"hello-00004": ReasonClockSkew,
+			"hello-00005": ReasonRouted,
+		},
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := testPolicy()
+			tt.policy(&policy)
+			result, err := Evaluate(policy, Inputs{
+				Route:     readyRoute(latestTarget("hello-00005")),
+				Revisions: generatedRevisions(5),
+				Now:       now,
+				ClockSkew: tt.skew,
+			})
+			if err != nil {
+				t.Fatalf("Evaluate() = %v", err)
+			}
+			if got := reasons(result); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Evaluate() reasons = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package strategy
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"knative.dev/serving/pkg/apis/serving/v1alpha1"
+)
+
+// StuckAnnotationKey is the annotation set on a Configuration stuck on failed
+// revisions for operator attention, describing the failed generations.
+const StuckAnnotationKey = "revision-gc.knative.dev/stuck"
+
+// Stuck describes a Configuration whose latest generations all failed while
+// an older revision is still Ready.
+type Stuck struct {
+	// Failed are the failed revisions, newest first.
+	Failed []string
+
+	// LastReady is the newest Ready revision older than the failed ones.
+	LastReady string
+}
+
+// String describes the stuck Configuration for events and the annotation.
+func (s *Stuck) String() string {
+	return fmt.Sprintf("latest %d generations failed (%s), last Ready revision %s", len(s.Failed), strings.Join(s.Failed, ", "), s.LastReady)
+}
+
+// DetectStuck reports whether the latest n generations of the revisions all
+// failed and an older revision is Ready. Revisions whose generation cannot be
+// read are ignored, as are revisions still being created.
+func DetectStuck(revisions []*v1alpha1.Revision, keys LabelKeys, n int) (*Stuck, bool) {
+	if n < 1 {
+		return nil, false
+	}
+	type generation struct {
+		revision   *v1alpha1.Revision
+		generation int
+	}
+	gens := make([]generation, 0, len(revisions))
+	for _, re := range revisions {
+		g, err := keys.Generation(re)
+		if err != nil {
+			continue
+		}
+		gens = append(gens, generation{revision: re, generation: g})
+	}
+	// Order by generation, then creation time and UID, never by name, see
+	// newerRevision.
+	sort.Slice(gens, func(i, j int) bool {
+		if gens[i].generation != gens[j].generation {
+			return gens[i].generation > gens[j].generation
+		}
+		return newerRevision(gens[i].revision, gens[j].revision)
+	})
+	if len(gens) <= n {
+		return nil, false
+	}
+
+	s := &Stuck{}
+	for _, g := range gens[:n] {
+		if !failed(g.revision) {
+			return nil, false
+		}
+		s.Failed = append(s.Failed, g.revision.Name)
+	}
+	for _, g := range gens[n:] {
+		if g.revision.Status.IsReady() {
+			s.LastReady = g.revision.Name
+			return s, true
+		}
+	}
+	return nil, false
+}
+
+// failed reports whether the revision failed to become Ready.
+func failed(revision *v1alpha1.Revision) bool {
+	c := revision.Status.GetCondition(v1alpha1.RevisionConditionReady)
+	return c != nil && c.Status == corev1.ConditionFalse
+}
@@ -0,0 +1,77 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package strategy
+
+import (
+	"encoding/json"
+	"time"
+
+	"knative.dev/serving/pkg/apis/autoscaling"
+	"knative.dev/serving/pkg/apis/serving/v1alpha1"
+)
+
+const (
+	// SuspendedLabelKey is the label marking a revision suspended instead of
+	// deleted, so suspended revisions can be selected.
+	SuspendedLabelKey = "revision-gc.knative.dev/suspended"
+
+	// SuspendedAtAnnotationKey is the revision annotation recording when the
+	// revision was suspended, its suspend TTL starts then.
+	SuspendedAtAnnotationKey = "revision-gc.knative.dev/suspended-at"
+)
+
+// SuspendedAt returns when the revision was suspended, false when it is not
+// suspended.
+func SuspendedAt(revision *v1alpha1.Revision) (time.Time, bool) {
+	if revision.Labels[SuspendedLabelKey] != "true" {
+		return time.Time{}, false
+	}
+	at, err := time.Parse(time.RFC3339, revision.Annotations[SuspendedAtAnnotationKey])
+	if err != nil {
+		return time.Time{}, false
+	}
+	return at, true
+}
+
+// SuspendMergePatch returns the JSON merge patch marking a revision
+// suspended at now.
+func SuspendMergePatch(now time.Time) ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"labels": map[string]interface{}{
+				SuspendedLabelKey: "true",
+			},
+			"annotations": map[string]interface{}{
+				SuspendedAtAnnotationKey: now.UTC().Format(time.RFC3339),
+			},
+		},
+	})
+}
+
+// ScaleToZeroMergePatch returns the JSON merge patch forcing the
+// PodAutoscaler of a suspended revision to minScale=0 and maxScale=0, so it
+// scales to zero even when it was kept warm.
+func ScaleToZeroMergePatch() ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]interface{}{
+				autoscaling.MinScaleAnnotationKey: "0",
+				autoscaling.MaxScaleAnnotationKey: "0",
+			},
+		},
+	})
+}
@@ -0,0 +1,89 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package strategy
+
+import (
+	"fmt"
+	"strings"
+
+	"knative.dev/serving/pkg/apis/serving/v1alpha1"
+)
+
+// maxTargetNames bounds the targets named in a decision message.
+const maxTargetNames = 3
+
+// TrafficIndex indexes the traffic targets of a Route by the revision they
+// reference. It is built once per evaluation, so Services with hundreds of
+// tagged targets are evaluated in linear time instead of scanning the
+// targets for every revision.
+type TrafficIndex struct {
+	// serving holds the targets receiving a share of the traffic
+	serving    []v1alpha1.TrafficTarget
+	byRevision map[string][]v1alpha1.TrafficTarget
+}
+
+// NewTrafficIndex indexes the traffic targets in the status of the Route,
+// which may be nil.
+func NewTrafficIndex(route *v1alpha1.Route) *TrafficIndex {
+	x := &TrafficIndex{byRevision: make(map[string][]v1alpha1.TrafficTarget)}
+	if route == nil {
+		return x
+	}
+	for _, t := range route.Status.Traffic {
+		if t.Percent > 0 {
+			x.serving = append(x.serving, t)
+		}
+		if t.RevisionName != "" {
+			x.byRevision[t.RevisionName] = append(x.byRevision[t.RevisionName], t)
+		}
+	}
+	return x
+}
+
+// Serving returns the targets receiving a share of the traffic. Targets only
+// reachable by their tag receive none.
+func (x *TrafficIndex) Serving() []v1alpha1.TrafficTarget {
+	return x.serving
+}
+
+// Targets returns the targets referencing the revision.
+func (x *TrafficIndex) Targets(revision string) []v1alpha1.TrafficTarget {
+	return x.byRevision[revision]
+}
+
+// targetNames names the targets in a decision message.
+func targetNames(targets []v1alpha1.TrafficTarget) string {
+	names := make([]string, 0, maxTargetNames)
+	for _, t := range targets {
+		if len(names) == maxTargetNames {
+			break
+		}
+		name := t.Tag
+		if name == "" {
+			name = t.DeprecatedName
+		}
+		if name == "" {
+			name = fmt.Sprintf("%d%%", t.Percent)
+		}
+		names = append(names, name)
+	}
+	out := strings.Join(names, ", ")
+	if more := len(targets) - len(names); more > 0 {
+		out += fmt.Sprintf(" and %d more", more)
+	}
+	return out
+}
@@ -0,0 +1,85 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package strategy
+
+import (
+	"fmt"
+	"testing"
+
+	"knative.dev/serving/pkg/apis/serving/v1alpha1"
+)
+
+// taggedInputs returns the inputs of a Service with as many revisions as
+// traffic targets: the latest revision serves the traffic, every other one
+// is reachable by its tag.
+func taggedInputs(targets int) Inputs {
+	revisions := generatedRevisions(targets)
+	traffic := make([]v1alpha1.TrafficTarget, 0, targets)
+	for _, re := range revisions[:targets-1] {
+		traffic = append(traffic, tagTarget("tag-"+re.Name, re.Name))
+	}
+	traffic = append(traffic, latestTarget(revisions[targets-1].Name))
+	return Inputs{Route: readyRoute(traffic...), Revisions: revisions, Now: now}
+}
+
+func TestTaggedRevisionsRetained(t *testing.T) {
+	in := taggedInputs(500)
+	result, err := Evaluate(testPolicy(), in)
+	if err != nil {
+		t.Fatalf("Evaluate() = %v", err)
+	}
+	if len(result.Candidates) != 0 {
+		t.Errorf("Candidates = %v, want none", names(result.Candidates))
+	}
+	latest := in.Revisions[len(in.Revisions)-1].Name
+	for name, reason := range reasons(result) {
+		want := ReasonTrafficTarget
+		if name == latest {
+			want = ReasonRouted
+		}
+		if reason != want {
+			t.Errorf("revision %s reason = %s, want %s", name, reason, want)
+		}
+	}
+}
+
+func BenchmarkNewTrafficIndex(b *testing.B) {
+	for _, targets := range []int{500, 5000} {
+		route := taggedInputs(targets).Route
+		b.Run(fmt.Sprintf("%d targets", targets), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				NewTrafficIndex(route)
+			}
+		})
+	}
+}
+
+func BenchmarkEvaluate(b *testing.B) {
+	policy := testPolicy()
+	for _, targets := range []int{500, 5000} {
+		in := taggedInputs(targets)
+		b.Run(fmt.Sprintf("%d targets", targets), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := Evaluate(policy, in); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
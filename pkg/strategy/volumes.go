@@ -0,0 +1,60 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package strategy
+
+import (
+	"fmt"
+
+	"knative.dev/serving/pkg/apis/serving/v1alpha1"
+)
+
+// VolumeClaimPolicy selects how stale revisions mounting
+// PersistentVolumeClaims are collected.
+type VolumeClaimPolicy string
+
+const (
+	// VolumeClaimsIgnore collects them like any other revision and leaves
+	// their claims alone.
+	VolumeClaimsIgnore VolumeClaimPolicy = "ignore"
+	// VolumeClaimsSkip keeps them.
+	VolumeClaimsSkip VolumeClaimPolicy = "skip"
+	// VolumeClaimsCleanup collects them and deletes the claims created for
+	// the revision, i.e. labeled with its name, once it is deleted.
+	VolumeClaimsCleanup VolumeClaimPolicy = "cleanup"
+)
+
+// ParseVolumeClaimPolicy parses a VolumeClaimPolicy.
+func ParseVolumeClaimPolicy(raw string) (VolumeClaimPolicy, error) {
+	switch p := VolumeClaimPolicy(raw); p {
+	case VolumeClaimsIgnore, VolumeClaimsSkip, VolumeClaimsCleanup:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown volume claim policy %q, expected one of %s, %s, %s",
+		raw, VolumeClaimsIgnore, VolumeClaimsSkip, VolumeClaimsCleanup)
+}
+
+// VolumeClaims returns the names of the PersistentVolumeClaims the pods of
+// the revision mount.
+func VolumeClaims(revision *v1alpha1.Revision) []string {
+	var out []string
+	for _, v := range revision.Spec.Volumes {
+		if v.PersistentVolumeClaim != nil {
+			out = append(out, v.PersistentVolumeClaim.ClaimName)
+		}
+	}
+	return out
+}
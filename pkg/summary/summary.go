@@ -0,0 +1,251 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package summary aggregates the outcome of the reconciles per namespace over
+// an interval, so large clusters log one summary per namespace and interval
+// instead of lines and events for every Service.
+package summary
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/knative-sample/revision-controller/pkg/strategy"
+	"go.uber.org/zap"
+)
+
+// Counts is the outcome of the reconciles in a namespace over an interval.
+type Counts struct {
+	// Services is the number of distinct Services reconciled.
+	Services int
+	// Planned is the number of revisions planned for deletion.
+	Planned int
+	// Deleted is the number of revisions deleted.
+	Deleted int
+	// Deferred is the number of deletions deferred, e.g. by the quotas.
+	Deferred int
+	// Skipped is the number of Services skipped, by reason.
+	Skipped map[strategy.Reason]int
+}
+
+// String returns the counts in a log line.
+func (c Counts) String() string {
+	reasons := make([]string, 0, len(c.Skipped))
+	for reason, n := range c.Skipped {
+		reasons = append(reasons, fmt.Sprintf("%s=%d", reason, n))
+	}
+	sort.Strings(reasons)
+	return fmt.Sprintf("%d services reconciled, %d revisions planned, %d deleted, %d deferred, skipped: [%s]",
+		c.Services, c.Planned, c.Deleted, c.Deferred, strings.Join(reasons, " "))
+}
+
+type namespaceCounts struct {
+	services map[string]bool
+	counts   Counts
+}
+
+// Summarizer aggregates the outcome of the reconciles per namespace. It
+// records nothing while its interval is zero.
+type Summarizer struct {
+	mu         sync.Mutex
+	interval   time.Duration
+	namespaces map[string]*namespaceCounts
+	// changed wakes Run up when the interval changed
+	changed chan struct{}
+}
+
+// Default is the Summarizer of the controllers created without one.
+var Default = New()
+
+type summarizerKey struct{}
+
+// WithSummarizer attaches the Summarizer the controllers record to to ctx.
+func WithSummarizer(ctx context.Context, s *Summarizer) context.Context {
+	return context.WithValue(ctx, summarizerKey{}, s)
+}
+
+// FromContext returns the Summarizer attached to ctx, Default when none is.
+func FromContext(ctx context.Context) *Summarizer {
+	if s, ok := ctx.Value(summarizerKey{}).(*Summarizer); ok {
+		return s
+	}
+	return Default
+}
+
+// New returns a disabled Summarizer.
+func New() *Summarizer {
+	return &Summarizer{
+		namespaces: make(map[string]*namespaceCounts),
+		changed:    make(chan struct{}, 1),
+	}
+}
+
+// Enabled reports whether the outcome is summarized, in which case the
+// per-Service logs and events are left out.
+func (s *Summarizer) Enabled() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.interval > 0
+}
+
+// SetInterval changes the interval summaries are emitted at, zero disables
+// the summaries.
+func (s *Summarizer) SetInterval(interval time.Duration) {
+	s.mu.Lock()
+	changed := interval != s.interval
+	s.interval = interval
+	if interval <= 0 {
+		s.namespaces = make(map[string]*namespaceCounts)
+	}
+	s.mu.Unlock()
+	if !changed {
+		return
+	}
+	select {
+	case s.changed <- struct{}{}:
+	default:
+		// Run has not picked up the previous change yet.
+	}
+}
+
+// record applies update to the counts of the namespace while enabled.
+func (s *Summarizer) record(namespace string, update func(*namespaceCounts)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.interval <= 0 {
+		return
+	}
+	ns, ok := s.namespaces[namespace]
+	if !ok {
+		ns = &namespaceCounts{services: make(map[string]bool)}
+		s.namespaces[namespace] = ns
+	}
+	update(ns)
+}
+
+// Reconciled records a reconcile of the Service.
+func (s *Summarizer) Reconciled(namespace, service string) {
+	s.record(namespace, func(ns *namespaceCounts) {
+		ns.services[service] = true
+	})
+}
+
+// Skipped records a Service skipped for reason.
+func (s *Summarizer) Skipped(namespace string, reason strategy.Reason) {
+	s.record(namespace, func(ns *namespaceCounts) {
+		if ns.counts.Skipped == nil {
+			ns.counts.Skipped = make(map[strategy.Reason]int)
+		}
+		ns.counts.Skipped[reason]++
+	})
+}
+
+// Planned records revisions planned for deletion.
+func (s *Summarizer) Planned(namespace string, count int) {
+	s.record(namespace, func(ns *namespaceCounts) {
+		ns.counts.Planned += count
+	})
+}
+
+// Deleted records deleted revisions.
+func (s *Summarizer) Deleted(namespace string, count int) {
+	s.record(namespace, func(ns *namespaceCounts) {
+		ns.counts.Deleted += count
+	})
+}
+
+// Deferred records deferred deletions.
+func (s *Summarizer) Deferred(namespace string, count int) {
+	s.record(namespace, func(ns *namespaceCounts) {
+		ns.counts.Deferred += count
+	})
+}
+
+// Flush returns the counts recorded since the last flush by namespace and
+// starts over.
+func (s *Summarizer) Flush() map[string]Counts {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	flushed := make(map[string]Counts, len(s.namespaces))
+	for name, ns := range s.namespaces {
+		ns.counts.Services = len(ns.services)
+		flushed[name] = ns.counts
+	}
+	s.namespaces = make(map[string]*namespaceCounts)
+	return flushed
+}
+
+// Run calls emit with the counts of every namespace once per interval until
+// stopCh is closed, following changes of the interval.
+func (s *Summarizer) Run(stopCh <-chan struct{}, emit func(namespace string, c Counts)) {
+	for s.runInterval(stopCh, emit) {
+	}
+}
+
+// runInterval emits the summaries at the current interval until it changes,
+// returning false once stopCh is closed.
+func (s *Summarizer) runInterval(stopCh <-chan struct{}, emit func(namespace string, c Counts)) bool {
+	s.mu.Lock()
+	interval := s.interval
+	s.mu.Unlock()
+
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-stopCh:
+			return false
+		case <-s.changed:
+			return true
+		case <-tick:
+			flushed := s.Flush()
+			names := make([]string, 0, len(flushed))
+			for name := range flushed {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				emit(name, flushed[name])
+			}
+		}
+	}
+}
+
+// Log returns the emit function of Run logging the summary of a namespace
+// with its counts as structured fields.
+func Log(logger *zap.SugaredLogger) func(namespace string, c Counts) {
+	return func(namespace string, c Counts) {
+		fields := []interface{}{
+			zap.String("namespace", namespace),
+			zap.Int("services", c.Services),
+			zap.Int("planned", c.Planned),
+			zap.Int("deleted", c.Deleted),
+			zap.Int("deferred", c.Deferred),
+		}
+		for reason, n := range c.Skipped {
+			fields = append(fields, zap.Int("skipped."+string(reason), n))
+		}
+		logger.Infow(fmt.Sprintf("summary namespace: %s %s", namespace, c), fields...)
+	}
+}
@@ -0,0 +1,95 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package sweeper finds the Secrets and ConfigMaps generated for revisions
+// that outlived them. Some Serving versions create them without an owner
+// reference, or with one the Kubernetes garbage collector cannot follow.
+package sweeper
+
+import (
+	"regexp"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"knative.dev/serving/pkg/apis/serving"
+)
+
+// Rules match child resources to the revision they were generated for.
+type Rules struct {
+	// RevisionLabel is the label holding the revision name.
+	RevisionLabel string
+
+	// NamePattern matches the resource names, with the revision name in its
+	// "revision" group. It is optional.
+	NamePattern *regexp.Regexp
+
+	// MinAge is the minimum age of an orphan before it is swept.
+	MinAge time.Duration
+}
+
+// Owner is the revision a child resource was generated for. UID is empty
+// when the revision was matched by label or name.
+type Owner struct {
+	Name string
+	UID  types.UID
+}
+
+// OwnerOf returns the revision the object was generated for, trying its
+// owner references, the revision label and the name pattern in turn.
+func (r Rules) OwnerOf(obj metav1.Object) (Owner, bool) {
+	for _, ref := range obj.GetOwnerReferences() {
+		if gv, err := schema.ParseGroupVersion(ref.APIVersion); err == nil && gv.Group == serving.GroupName && ref.Kind == "Revision" {
+			return Owner{Name: ref.Name, UID: ref.UID}, true
+		}
+	}
+	if name, ok := obj.GetLabels()[r.RevisionLabel]; ok && r.RevisionLabel != "" && name != "" {
+		return Owner{Name: name}, true
+	}
+	if r.NamePattern != nil {
+		if m := r.NamePattern.FindStringSubmatch(obj.GetName()); m != nil {
+			for i, n := range r.NamePattern.SubexpNames() {
+				if n == "revision" && m[i] != "" {
+					return Owner{Name: m[i]}, true
+				}
+			}
+		}
+	}
+	return Owner{}, false
+}
+
+// Orphaned reports whether the object was generated for a revision that no
+// longer exists. revisions maps the names of the existing revisions of the
+// namespace to their UIDs. A revision recreated under the same name does not
+// adopt the children of its predecessor.
+func (r Rules) Orphaned(obj metav1.Object, revisions map[string]types.UID) bool {
+	owner, ok := r.OwnerOf(obj)
+	if !ok {
+		return false
+	}
+	uid, exists := revisions[owner.Name]
+	return !exists || (owner.UID != "" && owner.UID != uid)
+}
+
+// Due returns how long the orphaned object must still wait before it is
+// swept, zero when it is due.
+func (r Rules) Due(obj metav1.Object, now time.Time) time.Duration {
+	if wait := obj.GetCreationTimestamp().Add(r.MinAge).Sub(now); wait > 0 {
+		return wait
+	}
+	return 0
+}
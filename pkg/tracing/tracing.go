@@ -0,0 +1,235 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package tracing traces the reconciles of the controller with OpenCensus and
+// links metrics to them: measurements recorded in a sampled reconcile carry
+// its span context as an exemplar attachment. Spans are exported to
+// Stackdriver Trace, whose metrics backend exports the exemplars of
+// distribution views, so a spike in deletions links to the reconciles that
+// caused it.
+//
+// Every reconcile also gets a decision ID, sampled or not. It is added to
+// the logs, events, notifications and exemplars of the reconcile, and to
+// the plan it records, so a single garbage collection decision can be
+// followed across all of them.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"contrib.go.opencensus.io/exporter/stackdriver"
+	"github.com/google/uuid"
+	"go.opencensus.io/metric/metricdata"
+	"go.opencensus.io/trace"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"knative.dev/pkg/logging"
+)
+
+const (
+	// ConfigName is the ConfigMap configuring tracing. It is shared with
+	// Knative Serving, which ignores the Stackdriver key.
+	ConfigName = "config-tracing"
+
+	enableKey               = "enable"
+	debugKey                = "debug"
+	sampleRateKey           = "sample-rate"
+	stackdriverProjectIDKey = "stackdriver-project-id"
+
+	// DecisionIDKey is the key of the decision ID in logs and exemplar
+	// attachments.
+	DecisionIDKey = "decision"
+
+	// DecisionIDAnnotationKey is the Event annotation holding the decision
+	// ID of the reconcile that emitted it.
+	DecisionIDAnnotationKey = "revision-gc.knative.dev/decision-id"
+)
+
+// Config configures tracing.
+type Config struct {
+	// Enable samples reconciles and exports their spans.
+	Enable bool
+	// Debug samples every reconcile.
+	Debug bool
+	// SampleRate is the fraction of reconciles sampled.
+	SampleRate float64
+	// StackdriverProjectID is the project spans are exported to, the project
+	// of the application default credentials when empty.
+	StackdriverProjectID string
+}
+
+// NewConfigFromMap parses the tracing ConfigMap data, reading the keys
+// Knative Serving uses for its own tracing. Tracing is disabled by default.
+func NewConfigFromMap(data map[string]string) (*Config, error) {
+	c := &Config{SampleRate: 0.1}
+	if raw, ok := data[enableKey]; !ok {
+		// keep the default
+	} else if b, err := strconv.ParseBool(raw); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", enableKey, err)
+	} else {
+		c.Enable = b
+	}
+	if raw, ok := data[debugKey]; !ok {
+		// keep the default
+	} else if b, err := strconv.ParseBool(raw); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", debugKey, err)
+	} else {
+		c.Debug = b
+	}
+	if raw, ok := data[sampleRateKey]; !ok {
+		// keep the default
+	} else if f, err := strconv.ParseFloat(raw, 64); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", sampleRateKey, err)
+	} else if f < 0 || f > 1 {
+		return nil, fmt.Errorf("%s must be between 0 and 1, got %v", sampleRateKey, f)
+	} else {
+		c.SampleRate = f
+	}
+	c.StackdriverProjectID = data[stackdriverProjectIDKey]
+	return c, nil
+}
+
+// Tracer applies the tracing configuration to the OpenCensus globals.
+type Tracer struct {
+	logger *zap.SugaredLogger
+
+	mu       sync.Mutex
+	current  Config
+	exporter *stackdriver.Exporter
+}
+
+// NewTracer returns a Tracer with tracing disabled.
+func NewTracer(logger *zap.SugaredLogger) *Tracer {
+	trace.ApplyConfig(trace.Config{DefaultSampler: trace.NeverSample()})
+	return &Tracer{logger: logger}
+}
+
+// DefaultConfigMap is the tracing ConfigMap assumed while it does not exist.
+func DefaultConfigMap(namespace string) corev1.ConfigMap {
+	return corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: ConfigName, Namespace: namespace},
+	}
+}
+
+// UpdateFromConfigMap applies the tracing ConfigMap. Invalid configurations
+// are logged and leave the current one in effect.
+func (t *Tracer) UpdateFromConfigMap(cm *corev1.ConfigMap) {
+	c, err := NewConfigFromMap(cm.Data)
+	if err != nil {
+		t.logger.Errorf("tracing config %s error: %s", cm.Name, err.Error())
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if *c == t.current {
+		return
+	}
+
+	if t.exporter != nil && (!c.Enable || c.StackdriverProjectID != t.current.StackdriverProjectID) {
+		trace.UnregisterExporter(t.exporter)
+		t.exporter.Flush()
+		t.exporter = nil
+	}
+	if c.Enable && t.exporter == nil {
+		exporter, err := stackdriver.NewExporter(stackdriver.Options{ProjectID: c.StackdriverProjectID})
+		if err != nil {
+			t.logger.Errorf("tracing create Stackdriver exporter error: %s", err.Error())
+			return
+		}
+		trace.RegisterExporter(exporter)
+		t.exporter = exporter
+	}
+
+	sampler := trace.NeverSample()
+	if c.Enable && c.Debug {
+		sampler = trace.AlwaysSample()
+	} else if c.Enable {
+		sampler = trace.ProbabilitySampler(c.SampleRate)
+	}
+	trace.ApplyConfig(trace.Config{DefaultSampler: sampler})
+	t.current = *c
+	t.logger.Infof("tracing config updated: enable=%t debug=%t sample-rate=%v", c.Enable, c.Debug, c.SampleRate)
+}
+
+// Attachments returns the exemplar attachments linking a measurement to the
+// span and the decision of ctx, nil when the span is not sampled.
+func Attachments(ctx context.Context) metricdata.Attachments {
+	span := trace.FromContext(ctx)
+	if span == nil || !span.SpanContext().IsSampled() {
+		return nil
+	}
+	attachments := metricdata.Attachments{metricdata.AttachmentKeySpanContext: span.SpanContext()}
+	if id := DecisionID(ctx); id != "" {
+		attachments[DecisionIDKey] = id
+	}
+	return attachments
+}
+
+type decisionIDKey struct{}
+
+// WithDecisionID returns a context carrying a new decision ID and a logger
+// logging it, and the ID.
+func WithDecisionID(ctx context.Context) (context.Context, string) {
+	id := uuid.New().String()
+	ctx = context.WithValue(ctx, decisionIDKey{}, id)
+	return logging.WithLogger(ctx, logging.FromContext(ctx).With(zap.String(DecisionIDKey, id))), id
+}
+
+// DecisionID returns the decision ID of ctx, empty when there is none.
+func DecisionID(ctx context.Context) string {
+	id, _ := ctx.Value(decisionIDKey{}).(string)
+	return id
+}
+
+// EventRecorder returns a recorder annotating the events with the decision
+// ID of ctx.
+func EventRecorder(ctx context.Context, recorder record.EventRecorder) record.EventRecorder {
+	id := DecisionID(ctx)
+	if id == "" {
+		return recorder
+	}
+	return &decisionRecorder{EventRecorder: recorder, annotations: map[string]string{DecisionIDAnnotationKey: id}}
+}
+
+type decisionRecorder struct {
+	record.EventRecorder
+	annotations map[string]string
+}
+
+func (r *decisionRecorder) Event(object runtime.Object, eventtype, reason, message string) {
+	r.EventRecorder.AnnotatedEventf(object, r.annotations, eventtype, reason, "%s", message)
+}
+
+func (r *decisionRecorder) Eventf(object runtime.Object, eventtype, reason, messageFmt string, args ...interface{}) {
+	r.EventRecorder.AnnotatedEventf(object, r.annotations, eventtype, reason, messageFmt, args...)
+}
+
+// TraceID returns the trace ID of the span of ctx, empty when the span is
+// not sampled.
+func TraceID(ctx context.Context) string {
+	span := trace.FromContext(ctx)
+	if span == nil || !span.SpanContext().IsSampled() {
+		return ""
+	}
+	return span.SpanContext().TraceID.String()
+}
@@ -0,0 +1,178 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package tuning adapts the Go runtime to the cgroup limits of the
+// container, so the controller behaves predictably in tightly limited pods
+// while caching large revision sets: GOMAXPROCS follows the CPU quota
+// rather than the CPUs of the node, and the garbage collector can be tuned
+// with GOGC and a memory ballast sized from the memory limit.
+package tuning
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// cgroupRoot is where the cgroup filesystem is mounted.
+const cgroupRoot = "/sys/fs/cgroup"
+
+// ballast is a large allocation never touched, raising the heap size the
+// garbage collector paces against. Its pages are never written, so it
+// takes no resident memory.
+var ballast []byte
+
+// Options configures the tuning.
+type Options struct {
+	// CgroupCPU sets GOMAXPROCS to the CPU quota of the cgroup, unless the
+	// GOMAXPROCS environment variable is set.
+	CgroupCPU bool
+
+	// GCPercent sets the garbage collection target percentage like GOGC.
+	// Zero leaves it to GOGC.
+	GCPercent int
+
+	// MemoryBallast is the size of the memory ballast, a quantity like
+	// 256Mi or a percentage of the cgroup memory limit like 25%. Empty
+	// allocates none.
+	MemoryBallast string
+}
+
+// Apply tunes the runtime as configured and logs the outcome.
+func Apply(logger *zap.SugaredLogger, options Options) error {
+	if options.CgroupCPU && os.Getenv("GOMAXPROCS") == "" {
+		if quota, ok := CPUQuota(); ok {
+			procs := int(math.Ceil(quota))
+			if procs < 1 {
+				procs = 1
+			}
+			if procs < runtime.NumCPU() {
+				runtime.GOMAXPROCS(procs)
+			}
+			logger.Infof("CPU quota %g, GOMAXPROCS set to %d", quota, runtime.GOMAXPROCS(0))
+		}
+	}
+
+	if options.GCPercent != 0 {
+		debug.SetGCPercent(options.GCPercent)
+		logger.Infof("Garbage collection target set to %d%%", options.GCPercent)
+	}
+
+	if options.MemoryBallast != "" {
+		size, err := ballastSize(options.MemoryBallast)
+		if err != nil {
+			return err
+		}
+		ballast = make([]byte, size)
+		logger.Infof("Memory ballast of %d bytes allocated", size)
+	}
+	return nil
+}
+
+// ballastSize parses the size of the memory ballast.
+func ballastSize(raw string) (int64, error) {
+	if strings.HasSuffix(raw, "%") {
+		percent, err := strconv.ParseFloat(strings.TrimSuffix(raw, "%"), 64)
+		if err != nil || percent <= 0 || percent >= 100 {
+			return 0, fmt.Errorf("invalid memory ballast %q: the percentage must be between 0 and 100", raw)
+		}
+		limit, ok := MemoryLimit()
+		if !ok {
+			return 0, fmt.Errorf("invalid memory ballast %q: the cgroup has no memory limit", raw)
+		}
+		return int64(float64(limit) * percent / 100), nil
+	}
+	q, err := resource.ParseQuantity(raw)
+	if err != nil {
+		return 0, fmt.Errorf("invalid memory ballast %q: %v", raw, err)
+	}
+	if q.Sign() <= 0 {
+		return 0, fmt.Errorf("invalid memory ballast %q: must be greater than zero", raw)
+	}
+	return q.Value(), nil
+}
+
+// CPUQuota returns the CPUs the cgroup of the process may use, from the
+// cgroup v2 cpu.max or the cgroup v1 CFS quota and period. It reports
+// false when no quota is set.
+func CPUQuota() (float64, bool) {
+	if raw, err := readFile(filepath.Join(cgroupRoot, "cpu.max")); err == nil {
+		fields := strings.Fields(raw)
+		if len(fields) != 2 || fields[0] == "max" {
+			return 0, false
+		}
+		return ratio(fields[0], fields[1])
+	}
+	quota, err := readFile(filepath.Join(cgroupRoot, "cpu", "cpu.cfs_quota_us"))
+	if err != nil {
+		return 0, false
+	}
+	period, err := readFile(filepath.Join(cgroupRoot, "cpu", "cpu.cfs_period_us"))
+	if err != nil {
+		return 0, false
+	}
+	return ratio(quota, period)
+}
+
+// MemoryLimit returns the memory limit of the cgroup of the process in
+// bytes, from the cgroup v2 memory.max or the cgroup v1
+// memory.limit_in_bytes. It reports false when no limit is set.
+func MemoryLimit() (int64, bool) {
+	raw, err := readFile(filepath.Join(cgroupRoot, "memory.max"))
+	if err != nil {
+		if raw, err = readFile(filepath.Join(cgroupRoot, "memory", "memory.limit_in_bytes")); err != nil {
+			return 0, false
+		}
+	}
+	if raw == "max" {
+		return 0, false
+	}
+	limit, err := strconv.ParseInt(raw, 10, 64)
+	// cgroup v1 reports no limit as a huge page aligned number.
+	if err != nil || limit <= 0 || limit >= math.MaxInt64/2 {
+		return 0, false
+	}
+	return limit, true
+}
+
+func ratio(quota, period string) (float64, bool) {
+	q, err := strconv.ParseFloat(quota, 64)
+	if err != nil || q <= 0 {
+		return 0, false
+	}
+	p, err := strconv.ParseFloat(period, 64)
+	if err != nil || p <= 0 {
+		return 0, false
+	}
+	return q / p, true
+}
+
+func readFile(path string) (string, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(raw)), nil
+}
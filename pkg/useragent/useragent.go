@@ -0,0 +1,50 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package useragent builds the User-Agent the binaries send to the API
+// server, so audit logs attribute every call to the component, version and,
+// with a suffix supplied by the cluster operator, the instance that issued
+// it.
+package useragent
+
+import (
+	"fmt"
+	"runtime"
+
+	"github.com/knative-sample/revision-controller/pkg/version"
+	"k8s.io/client-go/rest"
+)
+
+// Product is the product token of the User-Agent.
+const Product = "revision-controller"
+
+// Build returns the User-Agent of the component,
+//
+//	revision-controller/<version> (<os>/<arch>) <component> [<suffix>]
+func Build(component, suffix string) string {
+	ua := fmt.Sprintf("%s/%s (%s/%s) %s", Product, version.Version, runtime.GOOS, runtime.GOARCH, component)
+	if suffix != "" {
+		ua += " " + suffix
+	}
+	return ua
+}
+
+// WrapConfig returns a copy of cfg sending the User-Agent.
+func WrapConfig(cfg *rest.Config, userAgent string) *rest.Config {
+	cfg = rest.CopyConfig(cfg)
+	cfg.UserAgent = userAgent
+	return cfg
+}
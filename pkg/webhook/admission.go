@@ -0,0 +1,62 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"encoding/json"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// The admission.k8s.io/v1beta1 types are not part of the vendored API
+// packages; these are the subset of their fields the webhook reads and writes.
+
+// admissionReview mirrors admission.k8s.io/v1beta1 AdmissionReview.
+type admissionReview struct {
+	metav1.TypeMeta `json:",inline"`
+
+	Request  *admissionRequest  `json:"request,omitempty"`
+	Response *admissionResponse `json:"response,omitempty"`
+}
+
+// admissionRequest mirrors admission.k8s.io/v1beta1 AdmissionRequest.
+type admissionRequest struct {
+	UID       types.UID                   `json:"uid"`
+	Kind      metav1.GroupVersionKind     `json:"kind"`
+	Resource  metav1.GroupVersionResource `json:"resource"`
+	Namespace string                      `json:"namespace,omitempty"`
+	Operation string                      `json:"operation"`
+	Object    json.RawMessage             `json:"object,omitempty"`
+	DryRun    *bool                       `json:"dryRun,omitempty"`
+}
+
+// admissionResponse mirrors admission.k8s.io/v1beta1 AdmissionResponse.
+type admissionResponse struct {
+	UID       types.UID      `json:"uid"`
+	Allowed   bool           `json:"allowed"`
+	Result    *metav1.Status `json:"status,omitempty"`
+	Patch     []byte         `json:"patch,omitempty"`
+	PatchType *string        `json:"patchType,omitempty"`
+}
+
+// jsonPatchOperation is a single RFC 6902 JSON patch operation.
+type jsonPatchOperation struct {
+	Operation string      `json:"op"`
+	Path      string      `json:"path"`
+	Value     interface{} `json:"value,omitempty"`
+}
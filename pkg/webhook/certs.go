@@ -0,0 +1,153 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+
+	"github.com/knative-sample/revision-controller/pkg/distribution"
+	admissionregistrationv1beta1 "k8s.io/api/admissionregistration/v1beta1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/cert"
+)
+
+// generateCerts generates a CA and a serving certificate for the webhook
+// Service signed by it. A new pair is generated on every start and the CA is
+// registered with the webhook configuration.
+func generateCerts(serviceName, namespace string) (*tls.Certificate, []byte, error) {
+	caKey, err := cert.NewPrivateKey()
+	if err != nil {
+		return nil, nil, err
+	}
+	caCert, err := cert.NewSelfSignedCACert(cert.Config{CommonName: serviceName + "-ca"}, caKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("generate webhook CA: %v", err)
+	}
+
+	key, err := cert.NewPrivateKey()
+	if err != nil {
+		return nil, nil, err
+	}
+	host := fmt.Sprintf("%s.%s.svc", serviceName, namespace)
+	serverCert, err := cert.NewSignedCert(cert.Config{
+		CommonName: host,
+		AltNames: cert.AltNames{
+			DNSNames: []string{serviceName, serviceName + "." + namespace, host, host + ".cluster.local"},
+		},
+		Usages: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, key, caCert, caKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("generate webhook serving certificate: %v", err)
+	}
+
+	pair, err := tls.X509KeyPair(cert.EncodeCertPEM(serverCert), cert.EncodePrivateKeyPEM(key))
+	if err != nil {
+		return nil, nil, err
+	}
+	return &pair, cert.EncodeCertPEM(caCert), nil
+}
+
+// register creates or updates the MutatingWebhookConfiguration routing
+// Revision creations and configuration changes to the webhook. Failures to
+// reach the webhook are ignored so Revisions can always be created. Without a caBundle the
+// configuration asks the service CA operator to inject its bundle.
+func (wh *Webhook) register(caBundle []byte) error {
+	path, configPath := StampPath, ConfigPath
+	failurePolicy := admissionregistrationv1beta1.Ignore
+	desired := &admissionregistrationv1beta1.MutatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: ConfigurationName},
+		Webhooks: []admissionregistrationv1beta1.Webhook{{
+			Name: "stamp." + ConfigurationName,
+			Rules: []admissionregistrationv1beta1.RuleWithOperations{{
+				Operations: []admissionregistrationv1beta1.OperationType{admissionregistrationv1beta1.Create},
+				Rule: admissionregistrationv1beta1.Rule{
+					APIGroups:   []string{"serving.knative.dev"},
+					APIVersions: []string{"*"},
+					Resources:   []string{"revisions"},
+				},
+			}},
+			ClientConfig: admissionregistrationv1beta1.WebhookClientConfig{
+				Service: &admissionregistrationv1beta1.ServiceReference{
+					Namespace: wh.options.Namespace,
+					Name:      wh.options.ServiceName,
+					Path:      &path,
+				},
+				CABundle: caBundle,
+			},
+			FailurePolicy: &failurePolicy,
+		}, {
+			Name: "config." + ConfigurationName,
+			Rules: []admissionregistrationv1beta1.RuleWithOperations{{
+				Operations: []admissionregistrationv1beta1.OperationType{admissionregistrationv1beta1.Create, admissionregistrationv1beta1.Update},
+				Rule: admissionregistrationv1beta1.Rule{
+					APIGroups:   []string{""},
+					APIVersions: []string{"v1"},
+					Resources:   []string{"configmaps"},
+				},
+			}},
+			ClientConfig: admissionregistrationv1beta1.WebhookClientConfig{
+				Service: &admissionregistrationv1beta1.ServiceReference{
+					Namespace: wh.options.Namespace,
+					Name:      wh.options.ServiceName,
+					Path:      &configPath,
+				},
+				CABundle: caBundle,
+			},
+			NamespaceSelector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{ConfigNamespaceLabelKey: "true"},
+			},
+			FailurePolicy: &failurePolicy,
+		}},
+	}
+
+	injected := len(caBundle) == 0
+	if injected {
+		desired.Annotations = map[string]string{distribution.InjectCABundleAnnotationKey: "true"}
+	}
+
+	client := wh.kubeClient.AdmissionregistrationV1beta1().MutatingWebhookConfigurations()
+	existing, err := client.Get(ConfigurationName, metav1.GetOptions{})
+	if apierrs.IsNotFound(err) {
+		_, err = client.Create(desired)
+		return err
+	} else if err != nil {
+		return err
+	}
+	existing = existing.DeepCopy()
+	if injected {
+		// Keep the bundles the operator already injected.
+		for i := range desired.Webhooks {
+			for _, w := range existing.Webhooks {
+				if w.Name == desired.Webhooks[i].Name {
+					desired.Webhooks[i].ClientConfig.CABundle = w.ClientConfig.CABundle
+				}
+			}
+		}
+		if existing.Annotations == nil {
+			existing.Annotations = map[string]string{}
+		}
+		existing.Annotations[distribution.InjectCABundleAnnotationKey] = "true"
+	} else {
+		delete(existing.Annotations, distribution.InjectCABundleAnnotationKey)
+	}
+	existing.Webhooks = desired.Webhooks
+	_, err = client.Update(existing)
+	return err
+}
@@ -0,0 +1,103 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/knative-sample/revision-controller/pkg/config"
+	"github.com/knative-sample/revision-controller/pkg/plan"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// validateConfig rejects invalid configuration ConfigMaps. Valid GC
+// ConfigMaps are annotated with the estimate of the revisions they delete and
+// rejected when they delete more revisions than the configuration in effect
+// beyond mass-deletion-threshold, unless explicitly allowed.
+func (wh *Webhook) validateConfig(ctx context.Context, req *admissionRequest) *admissionResponse {
+	allowed := &admissionResponse{Allowed: true}
+	if req.Kind.Kind != "ConfigMap" || req.Namespace != wh.options.Namespace {
+		return allowed
+	}
+	cm := &corev1.ConfigMap{}
+	if err := json.Unmarshal(req.Object, cm); err != nil {
+		wh.logger.Errorf("webhook decode configmap error: %s", err.Error())
+		return allowed
+	}
+
+	if cm.Name == config.NotificationsConfigName {
+		if _, err := config.NewNotificationsFromConfigMap(cm); err != nil {
+			return denied(fmt.Sprintf("invalid %s: %v", cm.Name, err))
+		}
+		return allowed
+	}
+	if cm.Name != config.GCConfigName {
+		return allowed
+	}
+
+	gc, err := config.NewGCFromConfigMap(cm)
+	if err != nil {
+		return denied(fmt.Sprintf("invalid %s: %v", cm.Name, err))
+	}
+	if wh.options.Estimator == nil {
+		return allowed
+	}
+	estimate, err := wh.options.Estimator.Estimate(ctx, gc)
+	if err != nil {
+		wh.logger.Errorf("webhook estimate %s error: %s", cm.Name, err.Error())
+		return allowed
+	}
+
+	if threshold := gc.MassDeletionThreshold; threshold > 0 && estimate.Candidates > threshold &&
+		estimate.Candidates > estimate.Current && cm.Annotations[config.AllowMassDeletionAnnotationKey] != "true" {
+		return denied(fmt.Sprintf("%s would delete %d revisions of %d Services (%d now), above mass-deletion-threshold %d; "+
+			"set the %s annotation to \"true\" to apply it", cm.Name, estimate.Candidates, estimate.Services, estimate.Current,
+			threshold, config.AllowMassDeletionAnnotationKey))
+	}
+
+	raw, err := json.Marshal(estimate)
+	if err != nil {
+		wh.logger.Errorf("webhook encode estimate error: %s", err.Error())
+		return allowed
+	}
+	patch, err := json.Marshal(annotationPatch(cm.Annotations, map[string]string{plan.EstimateAnnotationKey: string(raw)}, nil))
+	if err != nil {
+		wh.logger.Errorf("webhook encode patch error: %s", err.Error())
+		return allowed
+	}
+	patchType := "JSONPatch"
+	allowed.Patch = patch
+	allowed.PatchType = &patchType
+	return allowed
+}
+
+// denied returns a response rejecting the request with the message.
+func denied(message string) *admissionResponse {
+	return &admissionResponse{
+		Allowed: false,
+		Result: &metav1.Status{
+			Status:  metav1.StatusFailure,
+			Message: message,
+			Reason:  metav1.StatusReasonInvalid,
+			Code:    http.StatusUnprocessableEntity,
+		},
+	}
+}
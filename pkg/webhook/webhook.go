@@ -0,0 +1,283 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package webhook implements the optional mutating admission webhook that
+// stamps new Revisions with the generation of the Configuration that created
+// them and a normalized creation time, so the retention logic does not depend
+// on the labels a Knative Serving version happens to set. It also validates
+// the configuration ConfigMaps and annotates the GC ConfigMap with an
+// estimate of the revisions it deletes.
+package webhook
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/knative-sample/revision-controller/pkg/clockskew"
+	"github.com/knative-sample/revision-controller/pkg/config"
+	"github.com/knative-sample/revision-controller/pkg/listener"
+	"github.com/knative-sample/revision-controller/pkg/plan"
+	"github.com/knative-sample/revision-controller/pkg/strategy"
+	"go.uber.org/zap"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"knative.dev/pkg/configmap"
+	"knative.dev/pkg/injection/clients/kubeclient"
+	"knative.dev/pkg/logging"
+	"knative.dev/serving/pkg/apis/serving/v1alpha1"
+	configurationinformer "knative.dev/serving/pkg/client/injection/informers/serving/v1alpha1/configuration"
+	listers "knative.dev/serving/pkg/client/listers/serving/v1alpha1"
+)
+
+const (
+	// ConfigurationName is the name of the MutatingWebhookConfiguration the
+	// webhook registers itself with.
+	ConfigurationName = "revision-gc.knative.dev"
+
+	// StampPath is the path new Revisions are admitted on.
+	StampPath = "/stamp-revisions"
+
+	// ConfigPath is the path the configuration ConfigMaps are admitted on.
+	ConfigPath = "/validate-config"
+
+	// ConfigNamespaceLabelKey selects the namespace whose ConfigMaps are
+	// sent to the webhook; label the system namespace with it.
+	ConfigNamespaceLabelKey = "revision-gc.knative.dev/config"
+)
+
+// Estimator estimates the impact of a GC configuration.
+type Estimator interface {
+	// Estimate estimates how many of the existing revisions gc deletes.
+	Estimate(ctx context.Context, gc *config.GC) (*plan.Estimate, error)
+}
+
+// Options configures the webhook.
+type Options struct {
+	// ServiceName and Namespace locate the Service routing to the webhook.
+	ServiceName string
+	Namespace   string
+
+	// Listener configures where the webhook listens and its serving
+	// certificate. A configured certificate must come from a TLS Secret with
+	// the CA bundle in "ca.crt"; one is generated otherwise.
+	Listener listener.Options
+
+	// InjectCABundle accepts a configured certificate without a CA bundle,
+	// leaving it to the OpenShift service CA operator to inject the bundle
+	// into the webhook configuration.
+	InjectCABundle bool
+
+	// Estimator estimates the impact of GC configuration changes. They are
+	// only validated when nil.
+	Estimator Estimator
+}
+
+// Webhook stamps new Revisions with their creation metadata.
+type Webhook struct {
+	logger              *zap.SugaredLogger
+	options             Options
+	kubeClient          kubernetes.Interface
+	configurationLister listers.ConfigurationLister
+	configStore         *config.Store
+}
+
+// New returns a Webhook reading from the injected clients and informers.
+func New(ctx context.Context, cmw configmap.Watcher, options Options) *Webhook {
+	logger := logging.FromContext(ctx).Named("webhook")
+
+	wh := &Webhook{
+		logger:              logger,
+		options:             options,
+		kubeClient:          kubeclient.Get(ctx),
+		configurationLister: configurationinformer.Get(ctx).Lister(),
+		configStore:         config.NewStore(logger.Named("config-store")),
+	}
+	wh.configStore.WatchConfigs(cmw)
+	return wh
+}
+
+// Run registers the webhook and serves admission requests until ctx is done.
+func (wh *Webhook) Run(ctx context.Context) error {
+	serverCert, caCert, err := wh.options.Listener.Certificate(wh.kubeClient, wh.options.Namespace)
+	if err != nil {
+		return err
+	}
+	if serverCert == nil {
+		if serverCert, caCert, err = generateCerts(wh.options.ServiceName, wh.options.Namespace); err != nil {
+			return err
+		}
+	} else if len(caCert) == 0 && !wh.options.InjectCABundle {
+		return fmt.Errorf("the webhook certificate needs a CA bundle, use a TLS Secret with ca.crt")
+	}
+	if err := wh.register(caCert); err != nil {
+		return err
+	}
+
+	l, err := wh.options.Listener.Listen()
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(StampPath, wh.serveAdmission(wh.stamp))
+	mux.HandleFunc(ConfigPath, wh.serveAdmission(wh.validateConfig))
+	server := &http.Server{
+		Handler: mux,
+		TLSConfig: &tls.Config{
+			Certificates: []tls.Certificate{*serverCert},
+			MinVersion:   tls.VersionTLS12,
+		},
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- server.ServeTLS(l, "", "")
+	}()
+	wh.logger.Infof("Serving webhook %s on %s", ConfigurationName, l.Addr())
+
+	select {
+	case <-ctx.Done():
+		return server.Shutdown(context.Background())
+	case err := <-errCh:
+		return err
+	}
+}
+
+// serveAdmission returns the handler answering admission reviews with admit.
+func (wh *Webhook) serveAdmission(admit func(context.Context, *admissionRequest) *admissionResponse) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var review admissionReview
+		if err := json.NewDecoder(r.Body).Decode(&review); err != nil || review.Request == nil {
+			http.Error(w, fmt.Sprintf("invalid admission review: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		response := admit(r.Context(), review.Request)
+		response.UID = review.Request.UID
+		review.Request = nil
+		review.Response = response
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(&review); err != nil {
+			wh.logger.Errorf("webhook encode response error: %s", err.Error())
+		}
+	}
+}
+
+// stamp admits the Revision, stamping its creation metadata annotations.
+// They are overwritten on every CREATE, a Revision may carry values copied
+// from another object, e.g. by tooling templating the annotations. A
+// generation that cannot be determined removes the copied one instead.
+func (wh *Webhook) stamp(ctx context.Context, req *admissionRequest) *admissionResponse {
+	allowed := &admissionResponse{Allowed: true}
+	if req.Operation != "CREATE" {
+		return allowed
+	}
+
+	revision := &v1alpha1.Revision{}
+	if err := json.Unmarshal(req.Object, revision); err != nil {
+		wh.logger.Errorf("webhook decode revision error: %s", err.Error())
+		return allowed
+	}
+	if revision.Namespace == "" {
+		revision.Namespace = req.Namespace
+	}
+
+	stamps := map[string]string{
+		// Stamp the API server time, the clock creation timestamps are set by.
+		strategy.CreatedAtAnnotationKey: clockskew.Default.Now().UTC().Format(time.RFC3339),
+	}
+	var removals []string
+	if generation, err := wh.generation(revision); err != nil {
+		wh.logger.Infof("webhook revision: %s/%s not stamped with its generation: %s", revision.Namespace, revision.Name, err.Error())
+		if _, ok := revision.Annotations[strategy.CreatedByGenerationAnnotationKey]; ok {
+			removals = append(removals, strategy.CreatedByGenerationAnnotationKey)
+		}
+	} else {
+		stamps[strategy.CreatedByGenerationAnnotationKey] = strconv.FormatInt(generation, 10)
+	}
+
+	patch, err := json.Marshal(annotationPatch(revision.Annotations, stamps, removals))
+	if err != nil {
+		wh.logger.Errorf("webhook encode patch error: %s", err.Error())
+		return allowed
+	}
+	patchType := "JSONPatch"
+	allowed.Patch = patch
+	allowed.PatchType = &patchType
+	return allowed
+}
+
+// generation returns the generation of the Configuration owning the
+// Revision, falling back to the configured generation label.
+func (wh *Webhook) generation(revision *v1alpha1.Revision) (int64, error) {
+	if owner := metav1.GetControllerOf(revision); owner != nil && owner.Kind == "Configuration" {
+		if cfg, err := wh.configurationLister.Configurations(revision.Namespace).Get(owner.Name); err == nil {
+			return cfg.Generation, nil
+		}
+	}
+
+	keys := wh.configStore.Load().GC.LabelKeys
+	raw, ok := revision.Labels[keys.ConfigurationGeneration]
+	if !ok {
+		return 0, fmt.Errorf("no owning Configuration and no %s label", keys.ConfigurationGeneration)
+	}
+	return strconv.ParseInt(raw, 10, 64)
+}
+
+// annotationPatch returns the JSON patch setting the stamps on annotations,
+// replacing the values already there, and removing the removals.
+func annotationPatch(annotations, stamps map[string]string, removals []string) []jsonPatchOperation {
+	if annotations == nil {
+		return []jsonPatchOperation{{Operation: "add", Path: "/metadata/annotations", Value: stamps}}
+	}
+	keys := make([]string, 0, len(stamps))
+	for key := range stamps {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	patch := make([]jsonPatchOperation, 0, len(stamps)+len(removals))
+	for _, key := range keys {
+		op := "add"
+		if _, ok := annotations[key]; ok {
+			op = "replace"
+		}
+		patch = append(patch, jsonPatchOperation{
+			Operation: op,
+			Path:      "/metadata/annotations/" + escapePointer(key),
+			Value:     stamps[key],
+		})
+	}
+	for _, key := range removals {
+		patch = append(patch, jsonPatchOperation{
+			Operation: "remove",
+			Path:      "/metadata/annotations/" + escapePointer(key),
+		})
+	}
+	return patch
+}
+
+// escapePointer escapes a JSON pointer reference token (RFC 6901).
+func escapePointer(token string) string {
+	return strings.Replace(strings.Replace(token, "~", "~0", -1), "/", "~1", -1)
+}
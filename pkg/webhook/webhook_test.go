@@ -0,0 +1,80 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/knative-sample/revision-controller/pkg/strategy"
+)
+
+func TestAnnotationPatch(t *testing.T) {
+	stamps := map[string]string{
+		strategy.CreatedAtAnnotationKey:           "2019-08-01T12:00:00Z",
+		strategy.CreatedByGenerationAnnotationKey: "3",
+	}
+	createdAt := "/metadata/annotations/revision-gc.knative.dev~1created-at"
+	generation := "/metadata/annotations/revision-gc.knative.dev~1created-by-generation"
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		stamps      map[string]string
+		removals    []string
+		want        []jsonPatchOperation
+	}{{
+		name:   "no annotations",
+		stamps: stamps,
+		want:   []jsonPatchOperation{{Operation: "add", Path: "/metadata/annotations", Value: stamps}},
+	}, {
+		name:        "missing stamps",
+		annotations: map[string]string{"foo": "bar"},
+		stamps:      stamps,
+		want: []jsonPatchOperation{
+			{Operation: "add", Path: createdAt, Value: "2019-08-01T12:00:00Z"},
+			{Operation: "add", Path: generation, Value: "3"},
+		},
+	}, {
+		name: "copied stamps",
+		annotations: map[string]string{
+			strategy.CreatedAtAnnotationKey:           "2019-01-01T00:00:00Z",
+			strategy.CreatedByGenerationAnnotationKey: "9",
+		},
+		stamps: stamps,
+		want: []jsonPatchOperation{
+			{Operation: "replace", Path: createdAt, Value: "2019-08-01T12:00:00Z"},
+			{Operation: "replace", Path: generation, Value: "3"},
+		},
+	}, {
+		name:        "copied generation of an unknown one",
+		annotations: map[string]string{strategy.CreatedByGenerationAnnotationKey: "9"},
+		stamps:      map[string]string{strategy.CreatedAtAnnotationKey: "2019-08-01T12:00:00Z"},
+		removals:    []string{strategy.CreatedByGenerationAnnotationKey},
+		want: []jsonPatchOperation{
+			{Operation: "add", Path: createdAt, Value: "2019-08-01T12:00:00Z"},
+			{Operation: "remove", Path: generation},
+		},
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := annotationPatch(tt.annotations, tt.stamps, tt.removals); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("annotationPatch() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,194 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package workers runs the workers of controllers in pools that are resized
+// at runtime, instead of the fixed number of workers controller.StartAll
+// starts, so the concurrency can follow the configuration without a restart.
+package workers
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"knative.dev/pkg/controller"
+	"knative.dev/pkg/logging"
+	"knative.dev/pkg/logging/logkey"
+)
+
+// Supervisor runs size workers for every controller added to it.
+type Supervisor struct {
+	logger *zap.SugaredLogger
+
+	mu sync.Mutex
+	// size is the desired number of workers per controller
+	size  int
+	pools []*pool
+	// resized wakes Run up when size changed
+	resized chan struct{}
+	// workers tracks the running workers of all pools
+	workers sync.WaitGroup
+}
+
+// pool holds the workers of one controller.
+type pool struct {
+	impl          *controller.Impl
+	logger        *zap.SugaredLogger
+	statsReporter controller.StatsReporter
+	// running is the number of workers, guarded by the Supervisor
+	running int
+}
+
+// New returns a Supervisor running size workers per controller.
+func New(logger *zap.SugaredLogger, size int) *Supervisor {
+	return &Supervisor{
+		logger:  logger,
+		size:    size,
+		resized: make(chan struct{}, 1),
+	}
+}
+
+// Add adds the controller reconciling the keys of its work queue under name,
+// reporting its reconciles to statsReporter, which labels them like the
+// reporter the controller was created with. Controllers must be added before
+// Run.
+func (s *Supervisor) Add(name string, impl *controller.Impl, statsReporter controller.StatsReporter) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pools = append(s.pools, &pool{
+		impl:          impl,
+		logger:        s.logger.With(zap.String(logkey.ControllerType, name)),
+		statsReporter: statsReporter,
+	})
+}
+
+// Resize changes the number of workers per controller. Missing workers are
+// started right away, surplus workers stop once they finished their current
+// item.
+func (s *Supervisor) Resize(size int) {
+	if size < 1 {
+		return
+	}
+	s.mu.Lock()
+	changed := size != s.size
+	s.size = size
+	s.mu.Unlock()
+	if !changed {
+		return
+	}
+	select {
+	case s.resized <- struct{}{}:
+	default:
+		// Run has not picked up the previous change yet.
+	}
+}
+
+// Run starts the workers and follows the size until stopCh is closed, then
+// shuts the work queues down and waits for the workers to finish.
+func (s *Supervisor) Run(stopCh <-chan struct{}) {
+	s.logger.Info("Starting controller workers")
+	s.scale()
+	for {
+		select {
+		case <-s.resized:
+			s.scale()
+		case <-stopCh:
+			s.logger.Info("Shutting down controller workers")
+			s.mu.Lock()
+			for _, p := range s.pools {
+				p.impl.WorkQueue.ShutDown()
+			}
+			s.mu.Unlock()
+			s.workers.Wait()
+			return
+		}
+	}
+}
+
+// scale starts the workers missing in every pool.
+func (s *Supervisor) scale() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.logger.Infof("Running %d workers per controller", s.size)
+	for _, p := range s.pools {
+		for ; p.running < s.size; p.running++ {
+			s.workers.Add(1)
+			go s.work(p)
+		}
+	}
+}
+
+// retire reports whether a worker of p is surplus, counting it as stopped.
+func (s *Supervisor) retire(p *pool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if p.running > s.size {
+		p.running--
+		return true
+	}
+	return false
+}
+
+// work processes the keys of the work queue of p until the queue shuts down
+// or the worker is surplus.
+func (s *Supervisor) work(p *pool) {
+	defer s.workers.Done()
+	for {
+		obj, shutdown := p.impl.WorkQueue.Get()
+		if shutdown {
+			return
+		}
+		p.process(obj.(string))
+		if s.retire(p) {
+			return
+		}
+	}
+}
+
+// process reconciles key like the workers of controller.Impl do: transient
+// errors requeue the key rate limited, permanent errors and successes
+// forget it.
+func (p *pool) process(key string) {
+	startTime := time.Now()
+	p.statsReporter.ReportQueueDepth(int64(p.impl.WorkQueue.Len()))
+	defer p.impl.WorkQueue.Done(key)
+
+	logger := p.logger.With(zap.String(logkey.TraceId, uuid.New().String()), zap.String(logkey.Key, key))
+	ctx := logging.WithLogger(context.TODO(), logger)
+
+	err := p.impl.Reconciler.Reconcile(ctx, key)
+	success := "true"
+	if err != nil {
+		success = "false"
+	}
+	p.statsReporter.ReportReconcile(time.Since(startTime), key, success)
+
+	if err != nil {
+		logger.Errorw("Reconcile error", zap.Error(err))
+		if controller.IsPermanentError(err) {
+			p.impl.WorkQueue.Forget(key)
+		} else {
+			p.impl.WorkQueue.AddRateLimited(key)
+		}
+		logger.Infof("Reconcile failed. Time taken: %v.", time.Since(startTime))
+		return
+	}
+
+	p.impl.WorkQueue.Forget(key)
+	logger.Infof("Reconcile succeeded. Time taken: %v.", time.Since(startTime))
+}
@@ -0,0 +1,91 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package workers
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"knative.dev/pkg/controller"
+)
+
+// recordingReporter records the keys of the reported reconciles.
+type recordingReporter struct {
+	mu   sync.Mutex
+	keys []string
+}
+
+func (r *recordingReporter) ReportQueueDepth(v int64) error {
+	return nil
+}
+
+func (r *recordingReporter) ReportReconcile(duration time.Duration, key, success string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.keys = append(r.keys, key+":"+success)
+	return nil
+}
+
+// reconcilerFunc reconciles keys by calling itself.
+type reconcilerFunc func(ctx context.Context, key string) error
+
+func (f reconcilerFunc) Reconcile(ctx context.Context, key string) error {
+	return f(ctx, key)
+}
+
+func TestSupervisorReportsThroughReporter(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+	done := make(chan string, 2)
+	impl := controller.NewImplWithStats(reconcilerFunc(func(ctx context.Context, key string) error {
+		done <- key
+		if key == "default/broken" {
+			return controller.NewPermanentError(context.Canceled)
+		}
+		return nil
+	}), logger, "test", &recordingReporter{})
+
+	reporter := &recordingReporter{}
+	s := New(logger, 1)
+	s.Add("test", impl, reporter)
+	stopCh := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		s.Run(stopCh)
+		close(stopped)
+	}()
+
+	impl.EnqueueKey("default/hello")
+	impl.EnqueueKey("default/broken")
+	for i := 0; i < 2; i++ {
+		select {
+		case <-done:
+		case <-time.After(10 * time.Second):
+			t.Fatal("the keys were not reconciled")
+		}
+	}
+	close(stopCh)
+	<-stopped
+
+	want := []string{"default/hello:true", "default/broken:false"}
+	if !reflect.DeepEqual(reporter.keys, want) {
+		t.Errorf("reported reconciles = %v, want %v", reporter.keys, want)
+	}
+}
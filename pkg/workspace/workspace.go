@@ -0,0 +1,79 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package workspace points the REST config of the controller at the kcp
+// logical clusters, or virtual workspaces, it operates in. Every workspace
+// gets its own clients and informers.
+package workspace
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"k8s.io/client-go/rest"
+)
+
+// segment is a segment of a logical cluster path, e.g. "org" of
+// "root:org:team".
+var segment = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+
+// Config returns a copy of cfg pointed at the workspace: either a logical
+// cluster path, e.g. "root:org:team", served under <host>/clusters/, or the
+// base URL of a virtual workspace.
+func Config(cfg *rest.Config, workspace string) (*rest.Config, error) {
+	out := rest.CopyConfig(cfg)
+	if strings.Contains(workspace, "://") {
+		u, err := url.Parse(workspace)
+		if err != nil {
+			return nil, fmt.Errorf("invalid workspace URL %q: %v", workspace, err)
+		}
+		if u.Scheme != "https" && u.Scheme != "http" {
+			return nil, fmt.Errorf("invalid workspace URL %q: expected an http or https URL", workspace)
+		}
+		out.Host = strings.TrimSuffix(u.String(), "/")
+		return out, nil
+	}
+	for _, s := range strings.Split(workspace, ":") {
+		if !segment.MatchString(s) {
+			return nil, fmt.Errorf("invalid workspace %q: expected a logical cluster path like root:org:team or a URL", workspace)
+		}
+	}
+	host := strings.TrimSuffix(cfg.Host, "/")
+	// The kubeconfig of a workspace already points at a logical cluster.
+	if i := strings.Index(host, "/clusters/"); i >= 0 {
+		host = host[:i]
+	}
+	out.Host = host + "/clusters/" + workspace
+	return out, nil
+}
+
+type nameKey struct{}
+
+// WithName attaches the name of the workspace the controllers of ctx operate
+// in to ctx.
+func WithName(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, nameKey{}, name)
+}
+
+// FromContext returns the name of the workspace attached to ctx, empty for
+// the cluster of the kubeconfig.
+func FromContext(ctx context.Context) string {
+	name, _ := ctx.Value(nameKey{}).(string)
+	return name
+}
@@ -0,0 +1,236 @@
+#!/bin/bash
+#****************************************************************#
+# End-to-end conformance of the revision controller against several Knative
+# Serving versions. For every version in SERVING_VERSIONS a kind cluster is
+# created, Istio and Knative Serving are installed, the controller image is
+# built from the working tree and deployed, and the script verifies:
+#
+#   - API availability detection: the controller detects the upstream
+#     distribution and starts, and refuses to start once a Knative resource
+#     it reads is no longer served;
+#   - label compatibility: the revisions of the Service are found through the
+#     serving.knative.dev/service and configurationGeneration labels of the
+#     version;
+#   - garbage collection: the stale revisions beyond retain-count are deleted
+#     and the routed revision is kept.
+#
+# Changes to pkg/distribution, to the label keys or to the Knative clients
+# must pass this script for every supported version.
+#
+# Requires docker, kind and kubectl. Usage:
+#   ./test/e2e/e2e.sh
+#   SERVING_VERSIONS="v0.10.0" KEEP_CLUSTERS=1 ./test/e2e/e2e.sh
+#****************************************************************#
+
+set -o errexit
+set -o nounset
+set -o pipefail
+
+ROOTDIR="$( cd "$( dirname "${BASH_SOURCE[0]}" )/../.." && pwd )"
+
+# The last three minor versions serving the serving.knative.dev/v1alpha1 and
+# autoscaling.internal.knative.dev/v1alpha1 APIs the controller reads.
+SERVING_VERSIONS="${SERVING_VERSIONS:-v0.8.1 v0.9.0 v0.10.0}"
+# The Kubernetes version of the kind nodes, supported by all of them.
+KIND_NODE_IMAGE="${KIND_NODE_IMAGE:-kindest/node:v1.15.12}"
+# The image of the Knative Service whose revisions are collected.
+SAMPLE_IMAGE="${SAMPLE_IMAGE:-gcr.io/knative-samples/helloworld-go}"
+KEEP_CLUSTERS="${KEEP_CLUSTERS:-}"
+TIMEOUT="${TIMEOUT:-300}"
+
+IMAGE="revision-controller:e2e"
+SYSTEM_NAMESPACE="knative-serving"
+TEST_NAMESPACE="revision-gc-e2e"
+SERVICE="e2e-gc"
+
+function log() {
+    echo "[$(date +%H:%M:%S)] $*"
+}
+
+function fail() {
+    echo "FAIL: $*" >&2
+    exit 1
+}
+
+# wait_for DESCRIPTION COMMAND... retries COMMAND until it succeeds or TIMEOUT
+# seconds passed.
+function wait_for() {
+    local description="$1"
+    shift
+    local deadline=$(( $(date +%s) + TIMEOUT ))
+    until "$@" >/dev/null 2>&1; do
+        if [ "$(date +%s)" -ge "${deadline}" ]; then
+            fail "timed out waiting for ${description}"
+        fi
+        sleep 5
+    done
+}
+
+# ingress_manifests VERSION prints the Istio manifests the Serving version
+# was released with. Override with INGRESS_MANIFESTS.
+function ingress_manifests() {
+    if [ -n "${INGRESS_MANIFESTS:-}" ]; then
+        echo "${INGRESS_MANIFESTS}"
+        return
+    fi
+    local istio
+    case "$1" in
+        v0.8.*) istio="istio-1.1.7" ;;
+        *) istio="istio-1.2-latest" ;;
+    esac
+    local base="https://raw.githubusercontent.com/knative/serving/$1/third_party/${istio}"
+    echo "${base}/istio-crds.yaml ${base}/istio-lean.yaml"
+}
+
+function install_serving() {
+    local version="$1"
+    log "installing Istio for Knative Serving ${version}"
+    for manifest in $(ingress_manifests "${version}"); do
+        kubectl apply -f "${manifest}"
+    done
+    kubectl wait --for=condition=Available deployment --all -n istio-system --timeout="${TIMEOUT}s"
+
+    log "installing Knative Serving ${version}"
+    local release="https://github.com/knative/serving/releases/download/${version}"
+    # The CRDs first, the resources of serving.yaml depend on them.
+    kubectl apply --selector knative.dev/crd-install=true -f "${release}/serving.yaml" || true
+    kubectl apply -f "${release}/serving.yaml"
+    kubectl wait --for=condition=Available deployment --all -n "${SYSTEM_NAMESPACE}" --timeout="${TIMEOUT}s"
+}
+
+function deploy_controller() {
+    log "deploying the revision controller"
+    kind load docker-image "${IMAGE}" --name "${CLUSTER}"
+    kubectl apply -f "${ROOTDIR}/deployments/serviceaccount.yaml"
+    kubectl apply -f "${ROOTDIR}/deployments/revisiongcconfig.yaml"
+    kubectl apply -f "${ROOTDIR}/deployments/config-revision-gc.yaml"
+    kubectl apply -f "${ROOTDIR}/deployments/config-revision-gc-notifications.yaml"
+    # Keep the stale revision right behind the routed one.
+    kubectl patch configmap config-revision-gc -n "${SYSTEM_NAMESPACE}" --type merge \
+        -p '{"data":{"retain-count":"1","min-stale-age":"0s"}}'
+    sed -e "s|image: .*revision-controller:.*|image: ${IMAGE}|" \
+        -e "s|imagePullPolicy: Always|imagePullPolicy: IfNotPresent|" \
+        "${ROOTDIR}/deployments/deployment.yaml" | kubectl apply -f -
+    kubectl rollout status deployment/revision-controller -n "${SYSTEM_NAMESPACE}" --timeout="${TIMEOUT}s"
+}
+
+function controller_logs() {
+    kubectl logs deployment/revision-controller -n "${SYSTEM_NAMESPACE}" --tail=-1
+}
+
+function check_detection() {
+    log "checking the API availability detection"
+    wait_for "the distribution detection" bash -c \
+        "kubectl logs deployment/revision-controller -n ${SYSTEM_NAMESPACE} --tail=-1 | grep -q 'Running against the upstream Knative Serving distribution'"
+}
+
+# generation_of REVISION prints the configuration generation label of the
+# revision.
+function generation_of() {
+    kubectl get revision "$1" -n "${TEST_NAMESPACE}" \
+        -o jsonpath='{.metadata.labels.serving\.knative\.dev/configurationGeneration}'
+}
+
+function revisions() {
+    kubectl get revisions -n "${TEST_NAMESPACE}" -l "serving.knative.dev/service=${SERVICE}" \
+        -o jsonpath='{range .items[*]}{.metadata.name}{"\n"}{end}'
+}
+
+function check_gc() {
+    log "checking the garbage collection"
+    kubectl create namespace "${TEST_NAMESPACE}" --dry-run -o yaml | kubectl apply -f -
+    # Four generations, the last routed: the first two are collected.
+    for generation in 1 2 3 4; do
+        cat <<YAML | kubectl apply -f -
+apiVersion: serving.knative.dev/v1alpha1
+kind: Service
+metadata:
+  name: ${SERVICE}
+  namespace: ${TEST_NAMESPACE}
+spec:
+  template:
+    spec:
+      containers:
+      - image: ${SAMPLE_IMAGE}
+        env:
+        - name: TARGET
+          value: "generation ${generation}"
+YAML
+        wait_for "generation ${generation} of Service ${SERVICE}" bash -c \
+            "[ \"\$(kubectl get ksvc ${SERVICE} -n ${TEST_NAMESPACE} -o jsonpath='{.status.observedGeneration}')\" = ${generation} ] && \
+             kubectl wait --for=condition=Ready ksvc/${SERVICE} -n ${TEST_NAMESPACE} --timeout=5s"
+    done
+
+    wait_for "the stale revisions of ${SERVICE} to be collected" bash -c \
+        "[ \"\$(kubectl get revisions -n ${TEST_NAMESPACE} -l serving.knative.dev/service=${SERVICE} -o name | wc -l)\" -eq 2 ]"
+
+    local generations=""
+    for revision in $(revisions); do
+        generations="${generations} $(generation_of "${revision}")"
+    done
+    if [ "$(echo ${generations} | tr ' ' '\n' | sort -n | tr '\n' ' ')" != "3 4 " ]; then
+        fail "expected the revisions of generations 3 and 4 to be kept, got:${generations}"
+    fi
+    local routed
+    routed="$(kubectl get route "${SERVICE}" -n "${TEST_NAMESPACE}" -o jsonpath='{.status.traffic[0].revisionName}')"
+    if [ "$(generation_of "${routed}")" != "4" ]; then
+        fail "expected the routed revision ${routed} to be kept"
+    fi
+}
+
+function check_missing_api() {
+    log "checking the controller refuses to start without the APIs it reads"
+    kubectl delete crd podautoscalers.autoscaling.internal.knative.dev
+    kubectl delete pod -n "${SYSTEM_NAMESPACE}" -l app=revisoin-controller --wait=false
+    wait_for "the controller to report the missing API" bash -c \
+        "kubectl logs deployment/revision-controller -n ${SYSTEM_NAMESPACE} --tail=-1 | grep -q 'does not serve autoscaling.internal.knative.dev/v1alpha1'"
+}
+
+# cleanup dumps the controller logs when the checks failed and deletes the
+# cluster.
+function cleanup() {
+    local status=$?
+    if [ "${status}" -ne 0 ]; then
+        controller_logs >&2 || true
+    fi
+    if [ -z "${KEEP_CLUSTERS}" ]; then
+        kind delete cluster --name "${CLUSTER}" || true
+    fi
+    return "${status}"
+}
+
+function run_version() {
+    local version="$1"
+    CLUSTER="revision-gc-e2e-${version//./-}"
+    log "=== Knative Serving ${version} on cluster ${CLUSTER}"
+    kind create cluster --name "${CLUSTER}" --image "${KIND_NODE_IMAGE}" --wait "${TIMEOUT}s"
+    trap cleanup EXIT
+    kubectl config use-context "kind-${CLUSTER}"
+
+    install_serving "${version}"
+    deploy_controller
+    check_detection
+    check_gc
+    check_missing_api
+    log "=== Knative Serving ${version} passed"
+}
+
+log "building ${IMAGE}"
+docker build -t "${IMAGE}" -f "${ROOTDIR}/build/Dockerfile" "${ROOTDIR}"
+
+failed=""
+for version in ${SERVING_VERSIONS}; do
+    # Each version runs in a subshell, so a failure moves on to the next.
+    # Not in a condition, where errexit would be ignored.
+    set +o errexit
+    (set -o errexit; run_version "${version}")
+    status=$?
+    set -o errexit
+    if [ "${status}" -ne 0 ]; then
+        failed="${failed} ${version}"
+    fi
+done
+if [ -n "${failed}" ]; then
+    fail "Knative Serving${failed}"
+fi
+log "all Knative Serving versions passed: ${SERVING_VERSIONS}"